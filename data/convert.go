@@ -0,0 +1,327 @@
+package data
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FieldError describes why one field of an incoming row was rejected.
+// Location is the dotted path to the field, e.g. "address.city".
+type FieldError struct {
+	Location string
+	Message  string
+}
+
+// ConvertRow checks a JSON-decoded row against a schema and converts its
+// values to the types rows are stored with: int64 for INTEGER, float64 for
+// FLOAT and NUMERIC, bool for BOOLEAN, []byte for BYTES, time.Time for
+// TIMESTAMP, canonical strings for DATE, TIME and DATETIME, maps for
+// RECORD and slices for REPEATED fields.
+func ConvertRow(fields []Field, values map[string]interface{},
+	ignoreUnknownValues bool) (map[string]interface{}, []FieldError) {
+	return convertRecord(fields, values, ignoreUnknownValues, "")
+}
+
+func convertRecord(fields []Field, values map[string]interface{},
+	ignoreUnknownValues bool, prefix string) (map[string]interface{}, []FieldError) {
+
+	fieldErrors := []FieldError{}
+
+	valuesByName := map[string]interface{}{}
+	for key, value := range values {
+		valuesByName[strings.ToLower(key)] = value
+	}
+
+	row := map[string]interface{}{}
+	for _, field := range fields {
+		location := prefix + field.Name
+		value, present := valuesByName[strings.ToLower(field.Name)]
+		delete(valuesByName, strings.ToLower(field.Name))
+
+		if !present || value == nil {
+			if NormalizeMode(field.Mode) == "REQUIRED" {
+				fieldErrors = append(fieldErrors, FieldError{
+					Location: location,
+					Message:  fmt.Sprintf("Missing required field: %s.", location),
+				})
+			}
+			continue
+		}
+
+		if NormalizeMode(field.Mode) == "REPEATED" {
+			elements, ok := value.([]interface{})
+			if !ok {
+				fieldErrors = append(fieldErrors, FieldError{
+					Location: location,
+					Message:  fmt.Sprintf("This field: %s is not an array.", location),
+				})
+				continue
+			}
+			elementField := field
+			elementField.Mode = "NULLABLE"
+			converted := []interface{}{}
+			for _, element := range elements {
+				if element == nil {
+					fieldErrors = append(fieldErrors, FieldError{
+						Location: location,
+						Message:  fmt.Sprintf("Array elements cannot be null: %s.", location),
+					})
+					continue
+				}
+				convertedElement, elementErrors :=
+					convertField(elementField, element, ignoreUnknownValues, location)
+				fieldErrors = append(fieldErrors, elementErrors...)
+				converted = append(converted, convertedElement)
+			}
+			row[field.Name] = converted
+			continue
+		} else if _, isArray := value.([]interface{}); isArray {
+			fieldErrors = append(fieldErrors, FieldError{
+				Location: location,
+				Message:  fmt.Sprintf("Array specified for non-repeated field: %s.", location),
+			})
+			continue
+		}
+
+		converted, errors := convertField(field, value, ignoreUnknownValues, location)
+		fieldErrors = append(fieldErrors, errors...)
+		row[field.Name] = converted
+	}
+
+	if !ignoreUnknownValues {
+		unknownNames := []string{}
+		for name := range valuesByName {
+			unknownNames = append(unknownNames, name)
+		}
+		sort.Strings(unknownNames)
+		for _, name := range unknownNames {
+			fieldErrors = append(fieldErrors, FieldError{
+				Location: prefix + name,
+				Message:  fmt.Sprintf("no such field: %s.", prefix+name),
+			})
+		}
+	}
+
+	return row, fieldErrors
+}
+
+func convertField(field Field, value interface{}, ignoreUnknownValues bool,
+	location string) (interface{}, []FieldError) {
+
+	if NormalizeType(field.Type) == "RECORD" {
+		record, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, []FieldError{{
+				Location: location,
+				Message:  fmt.Sprintf("This field: %s is not a record.", location),
+			}}
+		}
+		return convertRecord(field.Fields, record, ignoreUnknownValues, location+".")
+	}
+
+	converted, err := ConvertValue(field.Type, value)
+	if err != nil {
+		return nil, []FieldError{{Location: location, Message: err.Error()}}
+	}
+	return converted, nil
+}
+
+// ConvertValue converts a single non-NULL scalar, given as it appears in
+// JSON (or as a string from a CSV file), to the type it's stored as.
+func ConvertValue(fieldType string, value interface{}) (interface{}, error) {
+	switch NormalizeType(fieldType) {
+	case "STRING", "JSON", "GEOGRAPHY":
+		switch value := value.(type) {
+		case string:
+			return value, nil
+		case float64, bool, json.Number:
+			return FormatScalar("STRING", value), nil
+		}
+		if NormalizeType(fieldType) == "JSON" {
+			encoded, err := json.Marshal(value)
+			if err == nil {
+				return string(encoded), nil
+			}
+		}
+		return nil, fmt.Errorf("Cannot convert value to string.")
+
+	case "INTEGER":
+		switch value := value.(type) {
+		case int64:
+			return value, nil
+		case float64:
+			if value == math.Trunc(value) {
+				return int64(value), nil
+			}
+		case string, json.Number:
+			parsed, err := strconv.ParseInt(strings.TrimSpace(FormatScalar("STRING", value)), 10, 64)
+			if err == nil {
+				return parsed, nil
+			}
+			// A JSON number like 1.0 or 1e3 is still a whole number
+			if number, ok := value.(json.Number); ok {
+				if float, err := number.Float64(); err == nil && float == math.Trunc(float) && math.Abs(float) < 1<<63 {
+					return int64(float), nil
+				}
+			}
+		}
+		return nil, fmt.Errorf("Cannot convert value to integer (bad value):%v", value)
+
+	case "FLOAT", "NUMERIC", "BIGNUMERIC":
+		switch value := value.(type) {
+		case float64:
+			return value, nil
+		case int64:
+			return float64(value), nil
+		case string, json.Number:
+			valueString := strings.TrimSpace(FormatScalar("STRING", value))
+			switch valueString {
+			case "NaN":
+				return math.NaN(), nil
+			case "Infinity", "inf", "+inf":
+				return math.Inf(1), nil
+			case "-Infinity", "-inf":
+				return math.Inf(-1), nil
+			}
+			parsed, err := strconv.ParseFloat(valueString, 64)
+			if err == nil {
+				return parsed, nil
+			}
+		}
+		return nil, fmt.Errorf("Cannot convert value to floating point (bad value):%v", value)
+
+	case "BOOLEAN":
+		switch value := value.(type) {
+		case bool:
+			return value, nil
+		case string:
+			switch strings.ToLower(strings.TrimSpace(value)) {
+			case "true", "t", "yes", "y", "1":
+				return true, nil
+			case "false", "f", "no", "n", "0":
+				return false, nil
+			}
+		case float64:
+			if value == 0 || value == 1 {
+				return value == 1, nil
+			}
+		case json.Number:
+			switch value.String() {
+			case "0", "1":
+				return value.String() == "1", nil
+			}
+		}
+		return nil, fmt.Errorf("Cannot convert value to boolean (bad value):%v", value)
+
+	case "BYTES":
+		switch value := value.(type) {
+		case []byte:
+			return value, nil
+		case string:
+			decoded, err := base64.StdEncoding.DecodeString(value)
+			if err == nil {
+				return decoded, nil
+			}
+		}
+		return nil, fmt.Errorf("Could not decode base64 string to bytes.")
+
+	case "TIMESTAMP":
+		switch value := value.(type) {
+		case time.Time:
+			return value.UTC(), nil
+		case float64:
+			return timeFromSeconds(value), nil
+		case json.Number:
+			if seconds, err := value.Float64(); err == nil {
+				return timeFromSeconds(seconds), nil
+			}
+		case string:
+			if parsed, err := ParseTimestamp(value); err == nil {
+				return parsed, nil
+			}
+			if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+				return timeFromSeconds(seconds), nil
+			}
+		}
+		return nil, fmt.Errorf("Could not parse '%v' as a timestamp. Required format is YYYY-MM-DD HH:MM[:SS[.SSSSSS]]", value)
+
+	case "DATE":
+		if valueString, ok := value.(string); ok {
+			if parsed, err := time.Parse("2006-01-02", strings.TrimSpace(valueString)); err == nil {
+				return parsed.Format("2006-01-02"), nil
+			}
+		}
+		return nil, fmt.Errorf("Could not parse '%v' as a date. Required format is YYYY-MM-DD", value)
+
+	case "TIME":
+		if valueString, ok := value.(string); ok {
+			if parsed, err := time.Parse("15:04:05.999999999", strings.TrimSpace(valueString)); err == nil {
+				return parsed.Format("15:04:05.999999"), nil
+			}
+		}
+		return nil, fmt.Errorf("Could not parse '%v' as a time. Required format is HH:MM:SS[.SSSSSS]", value)
+
+	case "DATETIME":
+		if valueString, ok := value.(string); ok {
+			if parsed, err := ParseDatetime(valueString); err == nil {
+				return parsed.Format("2006-01-02T15:04:05.999999"), nil
+			}
+		}
+		return nil, fmt.Errorf("Could not parse '%v' as a datetime. Required format is YYYY-MM-DD[ HH:MM[:SS[.SSSSSS]]]", value)
+
+	default:
+		return value, nil
+	}
+}
+
+// TIMESTAMP_LAYOUTS lists the formats BigQuery accepts for TIMESTAMP
+// literals; any of them may be followed by a zone like Z, +07:00 or UTC.
+var TIMESTAMP_LAYOUTS = []string{
+	"2006-01-02T15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999 Z07:00",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02 15:04Z07:00",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+func ParseTimestamp(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	value = strings.TrimSuffix(value, " UTC")
+	for _, layout := range TIMESTAMP_LAYOUTS {
+		if parsed, err := time.Parse(layout, value); err == nil {
+			return parsed.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("Invalid timestamp: '%s'", value)
+}
+
+func ParseDatetime(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	for _, layout := range []string{
+		"2006-01-02T15:04:05.999999999",
+		"2006-01-02 15:04:05.999999999",
+		"2006-01-02T15:04",
+		"2006-01-02 15:04",
+		"2006-01-02",
+	} {
+		if parsed, err := time.Parse(layout, value); err == nil {
+			return parsed, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("Invalid datetime string \"%s\"", value)
+}
+
+func timeFromSeconds(seconds float64) time.Time {
+	micros := int64(math.Round(seconds * 1e6))
+	return time.Unix(micros/1000000, (micros%1000000)*1000).UTC()
+}
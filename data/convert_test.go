@@ -0,0 +1,70 @@
+package data
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+var CONVERT_FIELDS = []Field{
+	{Name: "id", Type: "INTEGER", Mode: "REQUIRED"},
+	{Name: "tags", Type: "STRING", Mode: "REPEATED"},
+	{Name: "info", Type: "RECORD", Mode: "NULLABLE", Fields: []Field{
+		{Name: "ok", Type: "BOOLEAN", Mode: "NULLABLE"},
+	}},
+}
+
+// decodeRow decodes a row the way insertAll and load jobs do.
+func decodeRow(t *testing.T, rowJson string) map[string]interface{} {
+	t.Helper()
+	decoder := json.NewDecoder(bytes.NewReader([]byte(rowJson)))
+	decoder.UseNumber()
+	var row map[string]interface{}
+	if err := decoder.Decode(&row); err != nil {
+		t.Fatalf("Decode(%s): %v", rowJson, err)
+	}
+	return row
+}
+
+func TestConvertRow(t *testing.T) {
+	for _, test := range []struct {
+		rowJson string
+		want    map[string]interface{}
+	}{
+		{`{"id": 9007199254740993}`, map[string]interface{}{"id": int64(9007199254740993)}},
+		{`{"id": "-12", "tags": ["a", "b"]}`,
+			map[string]interface{}{"id": int64(-12), "tags": []interface{}{"a", "b"}}},
+		{`{"ID": 1e3, "info": {"ok": 1}}`,
+			map[string]interface{}{"id": int64(1000), "info": map[string]interface{}{"ok": true}}},
+	} {
+		row, fieldErrors := ConvertRow(CONVERT_FIELDS, decodeRow(t, test.rowJson), false)
+		if len(fieldErrors) > 0 {
+			t.Errorf("ConvertRow(%s) gave errors %v", test.rowJson, fieldErrors)
+		} else if !reflect.DeepEqual(row, test.want) {
+			t.Errorf("ConvertRow(%s) = %#v, want %#v", test.rowJson, row, test.want)
+		}
+	}
+}
+
+func TestConvertRowErrors(t *testing.T) {
+	for _, test := range []struct {
+		rowJson string
+		want    []FieldError
+	}{
+		{`{}`, []FieldError{{"id", "Missing required field: id."}}},
+		{`{"id": 1.5}`, []FieldError{{"id", "Cannot convert value to integer (bad value):1.5"}}},
+		{`{"id": [1]}`, []FieldError{{"id", "Array specified for non-repeated field: id."}}},
+		{`{"id": 1, "info": [{"ok": true}]}`, []FieldError{{"info", "Array specified for non-repeated field: info."}}},
+		{`{"id": 1, "tags": "a"}`, []FieldError{{"tags", "This field: tags is not an array."}}},
+		{`{"id": 1, "tags": ["a", null]}`, []FieldError{{"tags", "Array elements cannot be null: tags."}}},
+		{`{"id": 1, "info": 5}`, []FieldError{{"info", "This field: info is not a record."}}},
+		{`{"id": 1, "info": {"ok": 2}}`, []FieldError{{"info.ok", "Cannot convert value to boolean (bad value):2"}}},
+		{`{"id": 1, "extra": 2}`, []FieldError{{"extra", "no such field: extra."}}},
+	} {
+		_, fieldErrors := ConvertRow(CONVERT_FIELDS, decodeRow(t, test.rowJson), false)
+		if !reflect.DeepEqual(fieldErrors, test.want) {
+			t.Errorf("ConvertRow(%s) gave errors %v, want %v", test.rowJson, fieldErrors, test.want)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package data
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// Error is a failure that BigQuery reports with a reason such as
+// "notFound" or "invalid".
+type Error struct {
+	Reason  string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func newError(reason, format string, args ...interface{}) *Error {
+	return &Error{Reason: reason, Message: fmt.Sprintf(format, args...)}
+}
+
+type CopyOptions struct {
+	OperationType     string // COPY (the default), SNAPSHOT, RESTORE or CLONE
+	CreateDisposition string // CREATE_IF_NEEDED (the default) or CREATE_NEVER
+	WriteDisposition  string // WRITE_EMPTY (the default), WRITE_APPEND or WRITE_TRUNCATE
+	ExpirationTime    int64  // for the destination, in milliseconds since epoch
+	Description       string // for the destination
+
+	// CreateTable, if set, charges a new destination table to a quota
+	CreateTable func(TableRef) *Error
+}
+
+// CopyTables copies the rows of sources into destination, the way copy
+// jobs and CREATE SNAPSHOT TABLE / CREATE TABLE ... CLONE statements do.
+// It returns the number of rows copied.
+func CopyTables(projects map[string]Project, sources []TableRef, destination TableRef,
+	options CopyOptions) (int, *Error) {
+
+	operationType := options.OperationType
+	if operationType == "" {
+		operationType = "COPY"
+	}
+	if len(sources) == 0 {
+		return 0, newError("invalid", "No source tables specified.")
+	}
+	if len(sources) > 1 && operationType != "COPY" {
+		return 0, newError("invalid", "%s operations take exactly one source table.", operationType)
+	}
+
+	sourceTables := []Table{}
+	for _, source := range sources {
+		tableId, partitionId := SplitPartitionDecorator(source.TableId)
+		sourceTable, err := lookupTable(projects, TableRef{source.ProjectId, source.DatasetId, tableId})
+		if err != nil {
+			return 0, err
+		}
+		if partitionId != "" {
+			if err := sourceTable.CheckPartitionId(partitionId); err != nil {
+				return 0, newError("invalid", "%s", err)
+			}
+			sourceTable.Rows, _ = sourceTable.PartitionRows(partitionId)
+		}
+		switch {
+		case sourceTable.View != nil || sourceTable.MaterializedView != nil:
+			return 0, newError("invalid",
+				"%s is not allowed for this operation because it is currently a %s.", source, sourceTable.Type())
+		case operationType == "SNAPSHOT" && sourceTable.Snapshot != nil:
+			return 0, newError("invalid", "Cannot create a snapshot of table snapshot %s.", source)
+		case operationType == "RESTORE" && sourceTable.Snapshot == nil:
+			return 0, newError("invalid", "Cannot restore from %s, which is not a table snapshot.", source)
+		}
+		if len(sourceTables) > 0 && !reflect.DeepEqual(sourceTable.Fields, sourceTables[0].Fields) {
+			return 0, newError("invalid", "Incompatible table schemas: %s and %s.", sources[0], source)
+		}
+		sourceTables = append(sourceTables, sourceTable)
+	}
+
+	combined := Table{
+		Fields:                 sourceTables[0].Fields,
+		Rows:                   []map[string]interface{}{},
+		TimePartitioning:       sourceTables[0].TimePartitioning,
+		RangePartitioning:      sourceTables[0].RangePartitioning,
+		RequirePartitionFilter: sourceTables[0].RequirePartitionFilter,
+		Clustering:             sourceTables[0].Clustering,
+	}
+	for _, sourceTable := range sourceTables {
+		combined.Rows = append(combined.Rows, sourceTable.Rows...)
+	}
+	switch operationType {
+	case "SNAPSHOT":
+		combined.Snapshot = &BaseTable{TableRef: sources[0], Time: NowMillis()}
+	case "CLONE":
+		combined.Clone = &BaseTable{TableRef: sources[0], Time: NowMillis()}
+	}
+
+	if err := WriteTable(projects, destination, combined, options); err != nil {
+		return 0, err
+	}
+	return len(combined.Rows), nil
+}
+
+// WriteTable writes a copy of source's rows to destination, honoring the
+// create and write dispositions in options. source's schema, partitioning,
+// clustering and snapshot or clone definition carry over to a new table.
+func WriteTable(projects map[string]Project, destination TableRef, source Table,
+	options CopyOptions) *Error {
+
+	writeDisposition := options.WriteDisposition
+	if writeDisposition == "" {
+		writeDisposition = "WRITE_EMPTY"
+	}
+
+	if _, projectOk := projects[destination.ProjectId]; !projectOk {
+		projects[destination.ProjectId] = Project{
+			Datasets: map[string]Dataset{},
+		}
+	}
+	dataset, datasetOk := projects[destination.ProjectId].Datasets[destination.DatasetId]
+	if !datasetOk {
+		return newError("notFound", "Not found: Dataset %s:%s",
+			destination.ProjectId, destination.DatasetId)
+	}
+
+	tableId, partitionId := SplitPartitionDecorator(destination.TableId)
+	destination.TableId = tableId
+	table, tableExists := dataset.Tables[tableId]
+	if tableExists {
+		if table.Snapshot != nil {
+			return newError("invalid", "Cannot write to table snapshot %s.", destination)
+		} else if table.View != nil {
+			return newError("invalid", "Cannot write to view %s.", destination)
+		} else if table.MaterializedView != nil {
+			return newError("invalid", "Cannot write to materialized view %s.", destination)
+		}
+		existing := table.Rows
+		if partitionId != "" {
+			if err := table.CheckPartitionId(partitionId); err != nil {
+				return newError("invalid", "%s", err)
+			}
+			existing, _ = table.PartitionRows(partitionId)
+		}
+		if source.Snapshot != nil || source.Clone != nil ||
+			(writeDisposition == "WRITE_EMPTY" && len(existing) > 0) {
+			return newError("duplicate", "Already Exists: Table %s", destination)
+		}
+		if writeDisposition == "WRITE_APPEND" && len(table.Fields) > 0 &&
+			!reflect.DeepEqual(table.Fields, source.Fields) {
+			return newError("invalid", "Provided Schema does not match Table %s.", destination)
+		}
+	} else if options.CreateDisposition == "CREATE_NEVER" {
+		return newError("notFound", "Not found: Table %s", destination)
+	} else if partitionId != "" {
+		if err := source.CheckPartitionId(partitionId); err != nil {
+			return newError("invalid", "%s", err)
+		}
+	}
+
+	nowMillis := NowMillis()
+	if !tableExists {
+		table = Table{
+			CreationTime:           nowMillis,
+			TimePartitioning:       source.TimePartitioning,
+			RangePartitioning:      source.RangePartitioning,
+			RequirePartitionFilter: source.RequirePartitionFilter,
+			Clustering:             source.Clustering,
+			Snapshot:               source.Snapshot,
+			Clone:                  source.Clone,
+			ExpirationTime:         dataset.NewTableExpirationTime(nowMillis),
+		}
+	}
+	if !tableExists || (writeDisposition == "WRITE_TRUNCATE" && partitionId == "") || len(table.Fields) == 0 {
+		table.Fields = make([]Field, len(source.Fields))
+		copy(table.Fields, source.Fields)
+	}
+	if !tableExists {
+		table.Rows = []map[string]interface{}{}
+	} else if writeDisposition == "WRITE_TRUNCATE" && partitionId != "" {
+		_, table.Rows = table.PartitionRows(partitionId)
+	} else if writeDisposition == "WRITE_TRUNCATE" {
+		table.Rows = []map[string]interface{}{}
+		table.StreamingBuffer = nil
+	}
+	now := time.Unix(0, nowMillis*int64(time.Millisecond))
+	for _, row := range source.Rows {
+		copied := CopyValue(row).(map[string]interface{})
+		if err := table.AssignPartition(copied, partitionId, now); err != nil {
+			return newError("invalid", "%s", err)
+		}
+		table.Rows = append(table.Rows, copied)
+	}
+
+	if options.ExpirationTime != 0 {
+		table.ExpirationTime = options.ExpirationTime
+	}
+	if options.Description != "" {
+		table.Description = options.Description
+	}
+	if !tableExists && options.CreateTable != nil {
+		if err := options.CreateTable(destination); err != nil {
+			return err
+		}
+	}
+	table.LastModifiedTime = nowMillis
+	table.Version += 1
+	dataset.Tables[tableId] = table
+	return nil
+}
+
+func lookupTable(projects map[string]Project, ref TableRef) (Table, *Error) {
+	dataset, datasetOk := projects[ref.ProjectId].Datasets[ref.DatasetId]
+	if !datasetOk {
+		return Table{}, newError("notFound", "Not found: Dataset %s:%s", ref.ProjectId, ref.DatasetId)
+	}
+	table, tableOk := dataset.Tables[ref.TableId]
+	if !tableOk {
+		return Table{}, newError("notFound", "Not found: Table %s", ref)
+	}
+	return table, nil
+}
+
+// CopyValue deep-copies a stored row or value, so that a copy doesn't
+// change when the original's RECORDs or REPEATED fields do.
+func CopyValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(value))
+		for key, element := range value {
+			copied[key] = CopyValue(element)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(value))
+		for i, element := range value {
+			copied[i] = CopyValue(element)
+		}
+		return copied
+	default:
+		return value
+	}
+}
@@ -0,0 +1,78 @@
+package data
+
+import (
+	"testing"
+)
+
+// copyTestProjects gives a project p whose dataset d holds t, with two
+// rows.
+func copyTestProjects() map[string]Project {
+	return map[string]Project{"p": {Datasets: map[string]Dataset{"d": {Tables: map[string]Table{
+		"t": {
+			Fields: []Field{{Name: "a", Type: "INTEGER", Mode: "NULLABLE"}},
+			Rows:   []map[string]interface{}{{"a": int64(1)}, {"a": int64(2)}},
+		},
+	}}}}}
+}
+
+func copyTestRef(tableId string) TableRef {
+	return TableRef{ProjectId: "p", DatasetId: "d", TableId: tableId}
+}
+
+func TestCopyTables(t *testing.T) {
+	for _, test := range []struct {
+		steps   []CopyOptions // each copying t to x
+		reason  string        // the error the last step fails with, if any
+		numRows int           // in x afterwards
+	}{
+		{[]CopyOptions{{}}, "", 2},
+		{[]CopyOptions{{}, {}}, "duplicate", 2},
+		{[]CopyOptions{{}, {WriteDisposition: "WRITE_APPEND"}}, "", 4},
+		{[]CopyOptions{{}, {WriteDisposition: "WRITE_TRUNCATE"}}, "", 2},
+		{[]CopyOptions{{CreateDisposition: "CREATE_NEVER"}}, "notFound", 0},
+		{[]CopyOptions{{OperationType: "SNAPSHOT"}, {WriteDisposition: "WRITE_APPEND"}}, "invalid", 2},
+		{[]CopyOptions{{OperationType: "CLONE"}}, "", 2},
+	} {
+		projects := copyTestProjects()
+		var err *Error
+		for _, options := range test.steps {
+			_, err = CopyTables(projects, []TableRef{copyTestRef("t")}, copyTestRef("x"), options)
+		}
+		got := ""
+		if err != nil {
+			got = err.Reason
+		}
+		if got != test.reason {
+			t.Errorf("%+v failed with %v, want %q", test.steps, err, test.reason)
+		}
+		if rows := len(projects["p"].Datasets["d"].Tables["x"].Rows); rows != test.numRows {
+			t.Errorf("%+v left %d rows, want %d", test.steps, rows, test.numRows)
+		}
+	}
+}
+
+func TestSnapshots(t *testing.T) {
+	projects := copyTestProjects()
+	copyTable := func(source, destination, operationType string) *Error {
+		_, err := CopyTables(projects, []TableRef{copyTestRef(source)}, copyTestRef(destination),
+			CopyOptions{OperationType: operationType})
+		return err
+	}
+	if err := copyTable("t", "s", "SNAPSHOT"); err != nil {
+		t.Fatalf("SNAPSHOT: %s", err)
+	} else if snapshot := projects["p"].Datasets["d"].Tables["s"].Snapshot; snapshot == nil ||
+		snapshot.TableRef != copyTestRef("t") {
+		t.Errorf("snapshot's base table is %+v, want t", snapshot)
+	}
+	if err := copyTable("s", "r", "RESTORE"); err != nil {
+		t.Errorf("RESTORE: %s", err)
+	} else if restored := projects["p"].Datasets["d"].Tables["r"]; len(restored.Rows) != 2 || restored.Snapshot != nil {
+		t.Errorf("restored table is %+v, want a table with 2 rows", restored)
+	}
+	if err := copyTable("s", "s2", "SNAPSHOT"); err == nil {
+		t.Errorf("snapshot of a snapshot succeeded")
+	}
+	if err := copyTable("t", "r2", "RESTORE"); err == nil {
+		t.Errorf("restoring from a table that isn't a snapshot succeeded")
+	}
+}
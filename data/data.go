@@ -1,33 +1,314 @@
 package data
 
+import (
+	"fmt"
+	"strings"
+)
+
 type Table struct {
-	Fields []Field
-	Rows   []map[string]interface{}
+	Fields            []Field
+	Rows              []map[string]interface{}
+	Description       string
+	Labels            map[string]string
+	ExpirationTime    int64 // milliseconds since epoch, or 0 for never
+	CreationTime      int64 // milliseconds since epoch
+	LastModifiedTime  int64 // milliseconds since epoch
+	TimePartitioning  *TimePartitioning
+	RangePartitioning *RangePartitioning
+	// RequirePartitionFilter makes queries that don't filter on the
+	// partitioning column fail.
+	RequirePartitionFilter bool
+	Clustering             *Clustering
+	StreamingBuffer        *StreamingBuffer  // nil until rows are streamed in
+	Snapshot               *BaseTable        // set on table snapshots
+	Clone                  *BaseTable        // set on table clones
+	View                   *View             // set on logical views, which hold no rows
+	MaterializedView       *MaterializedView // set on materialized views
+	// Version counts the writes users make, like DML, loads and schema
+	// changes, but not background work like flushing the streaming buffer,
+	// so that a transaction can tell when another job changed a table.
+	Version int64
+}
+
+// Type is what tables.get and tables.list report as the table's type.
+func (table Table) Type() string {
+	if table.Snapshot != nil {
+		return "SNAPSHOT"
+	} else if table.View != nil {
+		return "VIEW"
+	} else if table.MaterializedView != nil {
+		return "MATERIALIZED_VIEW"
+	}
+	return "TABLE"
+}
+
+type TableRef struct {
+	ProjectId string
+	DatasetId string
+	TableId   string
+}
+
+func (ref TableRef) String() string {
+	return ref.ProjectId + ":" + ref.DatasetId + "." + ref.TableId
+}
+
+// BaseTable records which table a snapshot or clone was taken from.
+type BaseTable struct {
+	TableRef
+	Time int64 // milliseconds since epoch
+}
+
+// View is the definition of a logical view, which queries expand in
+// place of the view's name.
+type View struct {
+	Query        string `json:"query"`
+	UseLegacySql bool   `json:"useLegacySql"`
+}
+
+// DEFAULT_REFRESH_INTERVAL_MS is how often BigQuery refreshes a
+// materialized view unless told otherwise.
+const DEFAULT_REFRESH_INTERVAL_MS = 30 * 60 * 1000
+
+// MaterializedView is the definition of a materialized view, whose Rows
+// hold the results of Query as of LastRefreshTime.
+type MaterializedView struct {
+	Query             string
+	EnableRefresh     bool
+	RefreshIntervalMs int64
+	LastRefreshTime   int64 // milliseconds since epoch
+}
+
+type TimePartitioning struct {
+	Type                   string `json:"type"` // DAY, HOUR, MONTH, YEAR
+	Field                  string `json:"field,omitempty"`
+	ExpirationMs           string `json:"expirationMs,omitempty"`
+	RequirePartitionFilter bool   `json:"requirePartitionFilter,omitempty"`
+}
+
+type Clustering struct {
+	Fields []string `json:"fields"`
+}
+
+// MAX_CLUSTERING_FIELDS is how many columns a table can be clustered by.
+const MAX_CLUSTERING_FIELDS = 4
+
+// Check validates the clustering columns of a table with fields.
+func (clustering Clustering) Check(fields []Field) error {
+	if len(clustering.Fields) > MAX_CLUSTERING_FIELDS {
+		return fmt.Errorf("Too many clustering columns: %d, only up to %d are allowed",
+			len(clustering.Fields), MAX_CLUSTERING_FIELDS)
+	}
+	for _, name := range clustering.Fields {
+		found := false
+		for _, field := range fields {
+			found = found || strings.EqualFold(field.Name, name)
+		}
+		if !found {
+			return fmt.Errorf("The field specified for clustering cannot be found in the schema. Invalid field: %s", name)
+		}
+	}
+	return nil
+}
+
+// StreamingBuffer holds the stats of a table's streamed rows until they
+// age out of it, the way BigQuery moves them to managed storage.
+type StreamingBuffer struct {
+	EstimatedRows   int64
+	EstimatedBytes  int64
+	OldestEntryTime int64            // milliseconds since epoch
+	Entries         []StreamingEntry // oldest first
+}
+
+// StreamingEntry counts the rows streamed in at one time.
+type StreamingEntry struct {
+	Time  int64 // milliseconds since epoch
+	Rows  int64
+	Bytes int64
+}
+
+// Add counts a row of the given size streamed in at nowMillis.
+func (buffer *StreamingBuffer) Add(nowMillis, bytes int64) {
+	if len(buffer.Entries) == 0 {
+		buffer.OldestEntryTime = nowMillis
+	}
+	last := len(buffer.Entries) - 1
+	if last == -1 || buffer.Entries[last].Time != nowMillis {
+		buffer.Entries = append(buffer.Entries, StreamingEntry{Time: nowMillis})
+		last += 1
+	}
+	buffer.Entries[last].Rows += 1
+	buffer.Entries[last].Bytes += bytes
+	buffer.EstimatedRows += 1
+	buffer.EstimatedBytes += bytes
+}
+
+// Flush gives what's left of the buffer once the rows streamed in at or
+// before millis have left it, or nil if none are left.
+func (buffer StreamingBuffer) Flush(millis int64) *StreamingBuffer {
+	left := &StreamingBuffer{}
+	for _, entry := range buffer.Entries {
+		if entry.Time > millis {
+			if len(left.Entries) == 0 {
+				left.OldestEntryTime = entry.Time
+			}
+			left.Entries = append(left.Entries, entry)
+			left.EstimatedRows += entry.Rows
+			left.EstimatedBytes += entry.Bytes
+		}
+	}
+	if len(left.Entries) == 0 {
+		return nil
+	}
+	return left
 }
 
 type Field struct {
-	Name string `json:"name"`
-	Type string `json:"type"` // TIMESTAMP, FLOAT, STRING, INTEGER
-	Mode string `json:"mode"` // NULLABLE, REQUIRED
+	Name        string  `json:"name"`
+	Type        string  `json:"type"` // TIMESTAMP, FLOAT, STRING, INTEGER, BOOLEAN, RECORD
+	Mode        string  `json:"mode"` // NULLABLE, REQUIRED, REPEATED
+	Fields      []Field `json:"fields,omitempty"`
+	Description string  `json:"description,omitempty"`
 }
 
 type Dataset struct {
-	Tables map[string]Table
+	Tables                       map[string]Table
+	Location                     string
+	Description                  string
+	FriendlyName                 string
+	Labels                       map[string]string
+	DefaultTableExpirationMs     int64 // 0 for never
+	DefaultPartitionExpirationMs int64 // 0 for never
+	Access                       []AccessEntry
+	CreationTime                 int64 // milliseconds since epoch
+	LastModifiedTime             int64 // milliseconds since epoch
+}
+
+// NewTableExpirationTime is when a table created in the dataset at
+// nowMillis expires by default, or 0 for never.
+func (dataset Dataset) NewTableExpirationTime(nowMillis int64) int64 {
+	if dataset.DefaultTableExpirationMs == 0 {
+		return 0
+	}
+	return nowMillis + dataset.DefaultTableExpirationMs
+}
+
+// AccessEntry grants Role to exactly one of the other fields, e.g.
+// {"role": "READER", "specialGroup": "projectReaders"}.
+type AccessEntry struct {
+	Role         string                 `json:"role,omitempty"`
+	UserByEmail  string                 `json:"userByEmail,omitempty"`
+	GroupByEmail string                 `json:"groupByEmail,omitempty"`
+	Domain       string                 `json:"domain,omitempty"`
+	SpecialGroup string                 `json:"specialGroup,omitempty"`
+	IamMember    string                 `json:"iamMember,omitempty"`
+	View         map[string]interface{} `json:"view,omitempty"`
+	Routine      map[string]interface{} `json:"routine,omitempty"`
+	Dataset      map[string]interface{} `json:"dataset,omitempty"`
+}
+
+// DEFAULT_ACCESS is what BigQuery grants on a dataset created without
+// explicit access entries.
+var DEFAULT_ACCESS = []AccessEntry{
+	{Role: "WRITER", SpecialGroup: "projectWriters"},
+	{Role: "OWNER", SpecialGroup: "projectOwners"},
+	{Role: "OWNER", UserByEmail: "a@b.com"},
+	{Role: "READER", SpecialGroup: "projectReaders"},
 }
 
 type Project struct {
 	Datasets map[string]Dataset
 }
 
+// Result is the output of a query, with Rows stored the same way as a
+// Table's so that it can be written to a destination table.
 type Result struct {
-	Fields []Field
-	Rows   []ResultRow
+	StatementType string // SELECT, INSERT, UPDATE, DELETE, MERGE, CREATE_TABLE, ...
+	Fields        []Field
+	Rows          []map[string]interface{}
+	DmlStats      *DmlStats // set for INSERT, UPDATE, DELETE and MERGE
+
+	// TotalBytesBilled is TotalBytesProcessed rounded up to what BigQuery
+	// charges for
+	TotalBytesProcessed int64
+	TotalBytesBilled    int64
+
+	ReferencedTables []TableRef // the tables and views the statement reads or writes
+
+	// Set for DDL statements. DdlOperationPerformed is CREATE, SKIP,
+	// REPLACE or DROP; DdlTargetDataset has no TableId.
+	DdlOperationPerformed string
+	DdlTargetTable        *TableRef
+	DdlTargetDataset      *TableRef
+
+	// For a SCRIPT, the statements it ran, even if it then failed
+	ChildJobs []ChildJob
+}
+
+// ChildJob is a statement run by a script, which becomes a job of its own
+// with the script's job as its parent.
+type ChildJob struct {
+	Query          string // the statement's text
+	EvaluationKind string // STATEMENT, or EXPRESSION for the query of a FOR loop
+	StartLine      int
+	StartColumn    int
+	EndLine        int
+	EndColumn      int
+	StartTime      int64   // milliseconds since epoch
+	EndTime        int64   // milliseconds since epoch
+	Result         *Result // nil if it failed
+	Error          *Error
+}
+
+// JobInfo is what INFORMATION_SCHEMA.JOBS_BY_PROJECT shows of a job.
+type JobInfo struct {
+	ProjectId           string
+	JobId               string
+	ParentJobId         string // for a statement run by a script
+	SessionId           string // for a query run in a session
+	Location            string
+	UserEmail           string
+	JobType             string // QUERY, LOAD, EXTRACT or COPY
+	StatementType       string // for query jobs
+	Query               string
+	State               string
+	CreationTime        int64 // milliseconds since epoch
+	StartTime           int64 // milliseconds since epoch
+	EndTime             int64 // milliseconds since epoch, or 0 while running
+	TotalBytesProcessed int64
+	TotalBytesBilled    int64
+	ErrorReason         string // "" unless the job failed
+	ErrorLocation       string
+	ErrorMessage        string
+	DestinationTable    *TableRef
+	ReferencedTables    []TableRef
+}
+
+type DmlStats struct {
+	InsertedRowCount int64
+	DeletedRowCount  int64
+	UpdatedRowCount  int64
+}
+
+// AffectedRows is what BigQuery reports as numDmlAffectedRows.
+func (stats DmlStats) AffectedRows() int64 {
+	return stats.InsertedRowCount + stats.DeletedRowCount + stats.UpdatedRowCount
+}
+
+// Json renders the dmlStats of job statistics and query responses.
+func (stats DmlStats) Json() map[string]string {
+	return map[string]string{
+		"insertedRowCount": fmt.Sprintf("%d", stats.InsertedRowCount),
+		"deletedRowCount":  fmt.Sprintf("%d", stats.DeletedRowCount),
+		"updatedRowCount":  fmt.Sprintf("%d", stats.UpdatedRowCount),
+	}
 }
 
 type ResultRow struct {
 	Values []ResultValue `json:"f"`
 }
 
+// Value is nil for NULL, a *string for scalars, a ResultRow for RECORD
+// fields and a []ResultValue for REPEATED fields.
 type ResultValue struct {
-	Value *string `json:"v"`
+	Value interface{} `json:"v"`
 }
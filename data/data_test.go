@@ -0,0 +1,58 @@
+package data
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStreamingBuffer(t *testing.T) {
+	buffer := &StreamingBuffer{}
+	buffer.Add(1000, 10)
+	buffer.Add(1000, 20)
+	buffer.Add(2000, 5)
+	if buffer.EstimatedRows != 3 || buffer.EstimatedBytes != 35 || buffer.OldestEntryTime != 1000 {
+		t.Errorf("after Add, buffer is %+v", buffer)
+	}
+
+	if left := buffer.Flush(999); left == nil || left.EstimatedRows != 3 || left.OldestEntryTime != 1000 {
+		t.Errorf("Flush(999) = %+v, want every row left", left)
+	}
+	left := buffer.Flush(1000)
+	if left == nil || left.EstimatedRows != 1 || left.EstimatedBytes != 5 || left.OldestEntryTime != 2000 {
+		t.Errorf("Flush(1000) = %+v, want the row from 2000 left", left)
+	}
+	if left := buffer.Flush(2000); left != nil {
+		t.Errorf("Flush(2000) = %+v, want nil", left)
+	}
+}
+
+func TestStreamedPartition(t *testing.T) {
+	table := Table{TimePartitioning: &TimePartitioning{Type: "DAY"}}
+	streamedAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	millis := streamedAt.UnixNano() / int64(time.Millisecond)
+	buffered, decorated := map[string]interface{}{}, map[string]interface{}{}
+	if err := table.AssignStreamedPartition(buffered, "", streamedAt); err != nil {
+		t.Fatalf("AssignStreamedPartition: %v", err)
+	}
+	if err := table.AssignStreamedPartition(decorated, "20240101", streamedAt); err != nil {
+		t.Fatalf("AssignStreamedPartition with a decorator: %v", err)
+	}
+	table.Rows = []map[string]interface{}{buffered, decorated}
+	if id := table.PartitionId(buffered); id != UNPARTITIONED {
+		t.Errorf("buffered row is in partition %s, want %s", id, UNPARTITIONED)
+	}
+	if id := table.PartitionId(decorated); id != "20240101" {
+		t.Errorf("decorated row is in partition %s, want 20240101", id)
+	}
+
+	if rows := table.FlushStreamedRows(millis - 1); table.PartitionId(rows[0]) != UNPARTITIONED {
+		t.Errorf("row flushed before it was streamed")
+	}
+	rows := table.FlushStreamedRows(millis)
+	if id := table.PartitionId(rows[0]); id != "20240102" {
+		t.Errorf("flushed row is in partition %s, want 20240102", id)
+	}
+	if _, ok := buffered[PARTITION_TIME_KEY]; ok {
+		t.Errorf("FlushStreamedRows changed the table's row rather than copying it")
+	}
+}
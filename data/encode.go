@@ -0,0 +1,87 @@
+package data
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// EncodeRow converts a stored row into BigQuery's f/v representation,
+// including only the given fields (and their sub-fields) in order.
+func EncodeRow(fields []Field, row map[string]interface{}) ResultRow {
+	values := make([]ResultValue, 0, len(fields))
+	for _, field := range fields {
+		values = append(values, EncodeValue(field, row[field.Name]))
+	}
+	return ResultRow{Values: values}
+}
+
+func EncodeValue(field Field, value interface{}) ResultValue {
+	if value == nil {
+		if field.Mode == "REPEATED" {
+			return ResultValue{Value: []ResultValue{}}
+		}
+		return ResultValue{}
+	}
+
+	if field.Mode == "REPEATED" {
+		elements, ok := value.([]interface{})
+		if !ok {
+			elements = []interface{}{value}
+		}
+		elementField := field
+		elementField.Mode = "NULLABLE"
+		encoded := make([]ResultValue, 0, len(elements))
+		for _, element := range elements {
+			encoded = append(encoded, EncodeValue(elementField, element))
+		}
+		return ResultValue{Value: encoded}
+	}
+
+	if field.Type == "RECORD" || field.Type == "STRUCT" {
+		record, ok := value.(map[string]interface{})
+		if !ok {
+			return ResultValue{}
+		}
+		return ResultValue{Value: EncodeRow(field.Fields, record)}
+	}
+
+	valueString := FormatScalar(field.Type, value)
+	return ResultValue{Value: &valueString}
+}
+
+// FormatScalar renders a non-NULL scalar the way BigQuery's JSON API does.
+func FormatScalar(fieldType string, value interface{}) string {
+	switch value := value.(type) {
+	case string:
+		return value
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case int64:
+		return strconv.FormatInt(value, 10)
+	case int:
+		return strconv.Itoa(value)
+	case bool:
+		return strconv.FormatBool(value)
+	case []byte:
+		return base64.StdEncoding.EncodeToString(value)
+	case time.Time:
+		if fieldType == "TIMESTAMP" {
+			return FormatTimestamp(value)
+		}
+		return value.Format(time.RFC3339Nano)
+	default:
+		return fmt.Sprintf("%v", value)
+	}
+}
+
+// FormatTimestamp renders a time as (possibly fractional) seconds since the
+// epoch, which is how TIMESTAMP values are sent back to clients.
+func FormatTimestamp(t time.Time) string {
+	micros := t.UnixNano() / 1000
+	if micros%1000000 == 0 {
+		return fmt.Sprintf("%d", t.Unix())
+	}
+	return strconv.FormatFloat(float64(micros)/1e6, 'f', -1, 64)
+}
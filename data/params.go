@@ -0,0 +1,109 @@
+package data
+
+import (
+	"fmt"
+)
+
+// QueryParameter is the value of one @name or ? parameter of a query, as
+// it appears in a job's configuration.query.queryParameters.
+type QueryParameter struct {
+	Name           string              `json:"name"`
+	ParameterType  QueryParameterType  `json:"parameterType"`
+	ParameterValue QueryParameterValue `json:"parameterValue"`
+}
+
+type QueryParameterType struct {
+	Type        string                     `json:"type"`
+	ArrayType   *QueryParameterType        `json:"arrayType"`
+	StructTypes []QueryParameterStructType `json:"structTypes"`
+}
+
+type QueryParameterStructType struct {
+	Name string             `json:"name"`
+	Type QueryParameterType `json:"type"`
+}
+
+// QueryParameterValue holds a scalar as a string, or the elements of an
+// ARRAY, or the fields of a STRUCT keyed by name. A nil Value is NULL.
+type QueryParameterValue struct {
+	Value        *string                        `json:"value"`
+	ArrayValues  []QueryParameterValue          `json:"arrayValues"`
+	StructValues map[string]QueryParameterValue `json:"structValues"`
+}
+
+// Field returns the schema of a parameter of type t named name.
+func (t QueryParameterType) Field(name string) (Field, error) {
+	switch fieldType := NormalizeType(t.Type); fieldType {
+	case "ARRAY":
+		if t.ArrayType == nil {
+			return Field{}, fmt.Errorf("Array parameter %s is missing its arrayType", name)
+		} else if NormalizeType(t.ArrayType.Type) == "ARRAY" {
+			return Field{}, fmt.Errorf("Parameter %s cannot be an array of arrays", name)
+		}
+		field, err := t.ArrayType.Field(name)
+		field.Mode = "REPEATED"
+		return field, err
+	case "RECORD":
+		field := Field{Name: name, Type: "RECORD", Mode: "NULLABLE"}
+		for i, structType := range t.StructTypes {
+			subfield, err := structType.Type.Field(structFieldName(structType, i))
+			if err != nil {
+				return Field{}, err
+			}
+			field.Fields = append(field.Fields, subfield)
+		}
+		return field, nil
+	case "STRING", "BYTES", "INTEGER", "FLOAT", "NUMERIC", "BIGNUMERIC", "BOOLEAN",
+		"TIMESTAMP", "DATE", "TIME", "DATETIME", "GEOGRAPHY", "JSON":
+		return Field{Name: name, Type: fieldType, Mode: "NULLABLE"}, nil
+	default:
+		return Field{}, fmt.Errorf("Unsupported type %s for parameter %s", t.Type, name)
+	}
+}
+
+// Convert converts a parameter value of type t to the type it's stored as.
+// An ARRAY without arrayValues is empty; other types are NULL when unset.
+func (t QueryParameterType) Convert(value QueryParameterValue) (interface{}, error) {
+	switch NormalizeType(t.Type) {
+	case "ARRAY":
+		elements := []interface{}{}
+		for _, elementValue := range value.ArrayValues {
+			element, err := t.ArrayType.Convert(elementValue)
+			if err != nil {
+				return nil, err
+			} else if element == nil {
+				return nil, fmt.Errorf("Array elements cannot be null")
+			}
+			elements = append(elements, element)
+		}
+		return elements, nil
+	case "RECORD":
+		if value.StructValues == nil {
+			return nil, nil
+		}
+		record := map[string]interface{}{}
+		for i, structType := range t.StructTypes {
+			name := structFieldName(structType, i)
+			converted, err := structType.Type.Convert(value.StructValues[structType.Name])
+			if err != nil {
+				return nil, err
+			} else if converted != nil {
+				record[name] = converted
+			}
+		}
+		return record, nil
+	default:
+		if value.Value == nil {
+			return nil, nil
+		}
+		return ConvertValue(t.Type, *value.Value)
+	}
+}
+
+// structFieldName names anonymous STRUCT fields like STRUCT(1, 2) does.
+func structFieldName(structType QueryParameterStructType, i int) string {
+	if structType.Name == "" {
+		return fmt.Sprintf("_field_%d", i+1)
+	}
+	return structType.Name
+}
@@ -0,0 +1,361 @@
+package data
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type RangePartitioning struct {
+	Field string         `json:"field"`
+	Range PartitionRange `json:"range"`
+}
+
+type PartitionRange struct {
+	Start    string `json:"start"`
+	End      string `json:"end"`
+	Interval string `json:"interval"`
+}
+
+// PARTITION_TIME_KEY is where each row of an ingestion-time partitioned
+// table keeps its _PARTITIONTIME, next to its fields' values.
+const PARTITION_TIME_KEY = "_PARTITIONTIME"
+
+// STREAMED_TIME_KEY is where a row streamed into such a table without a
+// partition decorator keeps when it was streamed, while it's in the
+// streaming buffer and so in no partition yet.
+const STREAMED_TIME_KEY = "_STREAMEDTIME"
+
+// NULL_PARTITION holds rows whose partitioning column is NULL, and
+// UNPARTITIONED the rows of an integer-range partitioned table outside
+// of its range.
+const NULL_PARTITION = "__NULL__"
+const UNPARTITIONED = "__UNPARTITIONED__"
+
+// MAX_PARTITIONS is the most partitions an integer range may divide into.
+const MAX_PARTITIONS = 10000
+
+// PARTITION_ID_LAYOUTS formats the start of a time partition as its ID,
+// e.g. 20240101 for a DAY partition.
+var PARTITION_ID_LAYOUTS = map[string]string{
+	"HOUR":  "2006010215",
+	"DAY":   "20060102",
+	"MONTH": "200601",
+	"YEAR":  "2006",
+}
+
+// SplitPartitionDecorator splits a name like events$20240101 into the
+// table's name and the partition's ID, which is "" without a decorator.
+func SplitPartitionDecorator(tableId string) (string, string) {
+	if i := strings.Index(tableId, "$"); i != -1 {
+		return tableId[:i], tableId[i+1:]
+	}
+	return tableId, ""
+}
+
+func (table Table) IsPartitioned() bool {
+	return table.TimePartitioning != nil || table.RangePartitioning != nil
+}
+
+// IngestionTimePartitioned reports whether rows are partitioned by when
+// they were written rather than by a column.
+func (table Table) IngestionTimePartitioned() bool {
+	return table.TimePartitioning != nil && table.TimePartitioning.Field == ""
+}
+
+// PartitionType is DAY unless the table says otherwise.
+func (partitioning TimePartitioning) PartitionType() string {
+	if partitioning.Type == "" {
+		return "DAY"
+	}
+	return strings.ToUpper(partitioning.Type)
+}
+
+// CheckPartitioning validates a new table's partitioning against its
+// schema.
+func (table Table) CheckPartitioning() error {
+	if table.TimePartitioning != nil && table.RangePartitioning != nil {
+		return fmt.Errorf("Cannot specify both time partitioning and range partitioning")
+	}
+	if partitioning := table.TimePartitioning; partitioning != nil {
+		if _, ok := PARTITION_ID_LAYOUTS[partitioning.PartitionType()]; !ok {
+			return fmt.Errorf("Invalid time partitioning type: %s", partitioning.Type)
+		}
+		if partitioning.ExpirationMs != "" {
+			if _, err := strconv.ParseInt(partitioning.ExpirationMs, 10, 64); err != nil {
+				return fmt.Errorf("Invalid value for expirationMs: %s", partitioning.ExpirationMs)
+			}
+		}
+		if partitioning.Field != "" {
+			field, ok := table.partitioningField(partitioning.Field)
+			if !ok {
+				return fmt.Errorf("The field specified for partitioning cannot be found in the schema")
+			}
+			switch fieldType := NormalizeType(field.Type); fieldType {
+			case "TIMESTAMP", "DATE", "DATETIME":
+			default:
+				return fmt.Errorf("The field specified for time partitioning can only be of type TIMESTAMP, DATE or DATETIME. The type found is: %s.", fieldType)
+			}
+		}
+	}
+	if partitioning := table.RangePartitioning; partitioning != nil {
+		field, ok := table.partitioningField(partitioning.Field)
+		if !ok {
+			return fmt.Errorf("The field specified for partitioning cannot be found in the schema")
+		} else if NormalizeType(field.Type) != "INTEGER" {
+			return fmt.Errorf("The field specified for range partitioning can only be of type INTEGER. The type found is: %s.",
+				NormalizeType(field.Type))
+		}
+		start, end, interval, err := partitioning.Range.bounds()
+		if err != nil {
+			return err
+		} else if interval <= 0 || end <= start {
+			return fmt.Errorf("Range partitioning requires start < end and a positive interval")
+		} else if (end-start+interval-1)/interval > MAX_PARTITIONS {
+			return fmt.Errorf("Too many partitions: range partitioning allows at most %d", MAX_PARTITIONS)
+		}
+	}
+	return nil
+}
+
+func (table Table) partitioningField(name string) (Field, bool) {
+	for _, field := range table.Fields {
+		if strings.EqualFold(field.Name, name) && NormalizeMode(field.Mode) != "REPEATED" {
+			return field, true
+		}
+	}
+	return Field{}, false
+}
+
+func (partitionRange PartitionRange) bounds() (int64, int64, int64, error) {
+	values := []int64{}
+	for _, text := range []string{partitionRange.Start, partitionRange.End, partitionRange.Interval} {
+		value, err := strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			return 0, 0, 0, fmt.Errorf("Invalid range partitioning bound: %q", text)
+		}
+		values = append(values, value)
+	}
+	return values[0], values[1], values[2], nil
+}
+
+// PartitionColumns are the columns a query on a table that requires a
+// partition filter must filter on.
+func (table Table) PartitionColumns() []string {
+	switch {
+	case table.IngestionTimePartitioned():
+		if table.TimePartitioning.PartitionType() == "DAY" {
+			return []string{"_PARTITIONDATE", "_PARTITIONTIME"}
+		}
+		return []string{"_PARTITIONTIME"}
+	case table.TimePartitioning != nil:
+		return []string{table.TimePartitioning.Field}
+	case table.RangePartitioning != nil:
+		return []string{table.RangePartitioning.Field}
+	}
+	return nil
+}
+
+// PartitionTime gives the start of the time partition a row is in, or
+// false for the NULL partition or a table not partitioned by time.
+func (table Table) PartitionTime(row map[string]interface{}) (time.Time, bool) {
+	partitioning := table.TimePartitioning
+	if partitioning == nil {
+		return time.Time{}, false
+	}
+	var value time.Time
+	if partitioning.Field == "" {
+		t, ok := row[PARTITION_TIME_KEY].(time.Time)
+		if !ok {
+			return time.Time{}, false
+		}
+		value = t
+	} else {
+		field, _ := table.partitioningField(partitioning.Field)
+		switch stored := row[field.Name].(type) {
+		case time.Time:
+			value = stored
+		case string:
+			var err error
+			if NormalizeType(field.Type) == "DATE" {
+				value, err = time.Parse("2006-01-02", stored)
+			} else {
+				value, err = ParseDatetime(stored)
+			}
+			if err != nil {
+				return time.Time{}, false
+			}
+		default:
+			return time.Time{}, false
+		}
+	}
+	return truncateToPartition(value.UTC(), partitioning.PartitionType()), true
+}
+
+func truncateToPartition(t time.Time, partitionType string) time.Time {
+	switch partitionType {
+	case "HOUR":
+		return t.Truncate(time.Hour)
+	case "MONTH":
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	case "YEAR":
+		return time.Date(t.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+	default:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	}
+}
+
+// PartitionEnd is when the time partition starting at start ends.
+func PartitionEnd(start time.Time, partitionType string) time.Time {
+	switch partitionType {
+	case "HOUR":
+		return start.Add(time.Hour)
+	case "MONTH":
+		return start.AddDate(0, 1, 0)
+	case "YEAR":
+		return start.AddDate(1, 0, 0)
+	default:
+		return start.AddDate(0, 0, 1)
+	}
+}
+
+// PartitionId gives the ID of the partition a row is in.
+func (table Table) PartitionId(row map[string]interface{}) string {
+	if table.TimePartitioning != nil {
+		start, ok := table.PartitionTime(row)
+		if !ok && table.TimePartitioning.Field == "" {
+			return UNPARTITIONED
+		} else if !ok {
+			return NULL_PARTITION
+		}
+		return start.Format(PARTITION_ID_LAYOUTS[table.TimePartitioning.PartitionType()])
+	}
+	if partitioning := table.RangePartitioning; partitioning != nil {
+		field, _ := table.partitioningField(partitioning.Field)
+		value, ok := row[field.Name].(int64)
+		if !ok {
+			return NULL_PARTITION
+		}
+		start, end, interval, err := partitioning.Range.bounds()
+		if err != nil || value < start || value >= end {
+			return UNPARTITIONED
+		}
+		return strconv.FormatInt(start+(value-start)/interval*interval, 10)
+	}
+	return ""
+}
+
+// CheckPartitionId validates the partition decorator of a write.
+func (table Table) CheckPartitionId(partitionId string) error {
+	if !table.IsPartitioned() {
+		return fmt.Errorf("Cannot write to partition %s of a table that isn't partitioned", partitionId)
+	}
+	if partitioning := table.TimePartitioning; partitioning != nil {
+		layout := PARTITION_ID_LAYOUTS[partitioning.PartitionType()]
+		if t, err := time.Parse(layout, partitionId); err == nil && t.Format(layout) == partitionId {
+			return nil
+		} else if partitionId == NULL_PARTITION && partitioning.Field != "" {
+			return nil
+		}
+	} else if partitionId == NULL_PARTITION || partitionId == UNPARTITIONED {
+		return nil
+	} else if value, err := strconv.ParseInt(partitionId, 10, 64); err == nil {
+		if table.PartitionId(map[string]interface{}{table.RangePartitioning.Field: value}) == partitionId {
+			return nil
+		}
+	}
+	return fmt.Errorf("Invalid partition decorator $%s", partitionId)
+}
+
+// AssignPartition puts a row being written into its partition: rows of
+// ingestion-time partitioned tables get the _PARTITIONTIME of the
+// partition named by partitionId, or else of the current one, and other
+// rows must belong to the partition named by partitionId, if any.
+func (table Table) AssignPartition(row map[string]interface{}, partitionId string, now time.Time) error {
+	delete(row, STREAMED_TIME_KEY)
+	if !table.IngestionTimePartitioned() {
+		delete(row, PARTITION_TIME_KEY)
+		if partitionId != "" && table.PartitionId(row) != partitionId {
+			return fmt.Errorf("The row's partitioning column puts it in partition %s rather than %s",
+				table.PartitionId(row), partitionId)
+		}
+		return nil
+	}
+	partitionType := table.TimePartitioning.PartitionType()
+	if partitionId != "" {
+		start, err := time.Parse(PARTITION_ID_LAYOUTS[partitionType], partitionId)
+		if err != nil {
+			return fmt.Errorf("Invalid partition decorator $%s", partitionId)
+		}
+		row[PARTITION_TIME_KEY] = start
+	} else if _, ok := row[PARTITION_TIME_KEY].(time.Time); !ok {
+		row[PARTITION_TIME_KEY] = truncateToPartition(now.UTC(), partitionType)
+	}
+	return nil
+}
+
+// AssignStreamedPartition is AssignPartition for a row streamed in with
+// insertAll. Like BigQuery, it leaves a row of an ingestion-time
+// partitioned table streamed without a decorator in no partition, with a
+// NULL _PARTITIONTIME, until FlushStreamedRows.
+func (table Table) AssignStreamedPartition(row map[string]interface{}, partitionId string, now time.Time) error {
+	if partitionId != "" || !table.IngestionTimePartitioned() {
+		return table.AssignPartition(row, partitionId, now)
+	}
+	delete(row, PARTITION_TIME_KEY)
+	row[STREAMED_TIME_KEY] = now.UTC()
+	return nil
+}
+
+// FlushStreamedRows gives the table's rows with those streamed at or
+// before millis, which have left the streaming buffer, put in the
+// partitions of when they were streamed. It copies rather than changes
+// the rows.
+func (table Table) FlushStreamedRows(millis int64) []map[string]interface{} {
+	rows := table.Rows
+	copied := false
+	for i, row := range table.Rows {
+		streamed, ok := row[STREAMED_TIME_KEY].(time.Time)
+		if !ok || streamed.UnixNano()/int64(time.Millisecond) > millis {
+			continue
+		}
+		if !copied {
+			rows = append([]map[string]interface{}{}, table.Rows...)
+			copied = true
+		}
+		flushed := map[string]interface{}{}
+		for key, value := range row {
+			flushed[key] = value
+		}
+		delete(flushed, STREAMED_TIME_KEY)
+		flushed[PARTITION_TIME_KEY] = truncateToPartition(streamed, table.TimePartitioning.PartitionType())
+		rows[i] = flushed
+	}
+	return rows
+}
+
+// PartitionRows splits a table's rows into those in a partition and the
+// rest.
+func (table Table) PartitionRows(partitionId string) ([]map[string]interface{}, []map[string]interface{}) {
+	in, out := []map[string]interface{}{}, []map[string]interface{}{}
+	for _, row := range table.Rows {
+		if table.PartitionId(row) == partitionId {
+			in = append(in, row)
+		} else {
+			out = append(out, row)
+		}
+	}
+	return in, out
+}
+
+// RequiresPartitionFilter reports whether queries must filter on the
+// table's partitioning column, which either the table or its older
+// timePartitioning.requirePartitionFilter can ask for.
+func (table Table) RequiresPartitionFilter() bool {
+	if !table.IsPartitioned() {
+		return false
+	}
+	return table.RequirePartitionFilter ||
+		(table.TimePartitioning != nil && table.TimePartitioning.RequirePartitionFilter)
+}
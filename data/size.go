@@ -0,0 +1,78 @@
+package data
+
+import (
+	"time"
+)
+
+// NowMillis returns the current time in milliseconds since the epoch, the
+// unit BigQuery uses for creationTime, lastModifiedTime and friends.
+func NowMillis() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
+
+// TableBytes estimates a table's logical size using BigQuery's data size
+// rules, e.g. 8 bytes per INTEGER and 2 bytes plus the length per STRING.
+func TableBytes(table Table) int64 {
+	var total int64
+	for _, row := range table.Rows {
+		total += RowBytes(table.Fields, row)
+	}
+	return total
+}
+
+func RowBytes(fields []Field, row map[string]interface{}) int64 {
+	var total int64
+	for _, field := range fields {
+		total += ValueBytes(field, row[field.Name])
+	}
+	return total
+}
+
+func ValueBytes(field Field, value interface{}) int64 {
+	if value == nil {
+		return 0
+	}
+
+	if field.Mode == "REPEATED" {
+		elements, ok := value.([]interface{})
+		if !ok {
+			elements = []interface{}{value}
+		}
+		elementField := field
+		elementField.Mode = "NULLABLE"
+		var total int64
+		for _, element := range elements {
+			total += ValueBytes(elementField, element)
+		}
+		return total
+	}
+
+	switch NormalizeType(field.Type) {
+	case "STRING", "JSON":
+		if s, ok := value.(string); ok {
+			return 2 + int64(len(s))
+		}
+		return 2 + int64(len(FormatScalar(field.Type, value)))
+	case "BYTES":
+		if s, ok := value.(string); ok {
+			// Bytes arrive base64-encoded
+			return 2 + int64(len(s)*3/4)
+		}
+		return 2
+	case "BOOLEAN":
+		return 1
+	case "NUMERIC":
+		return 16
+	case "BIGNUMERIC":
+		return 32
+	case "GEOGRAPHY":
+		return 16
+	case "RECORD":
+		if record, ok := value.(map[string]interface{}); ok {
+			return RowBytes(field.Fields, record)
+		}
+		return 0
+	default: // INTEGER, FLOAT, TIMESTAMP, DATE, TIME, DATETIME
+		return 8
+	}
+}
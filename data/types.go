@@ -0,0 +1,30 @@
+package data
+
+import (
+	"strings"
+)
+
+// NormalizeType maps standard SQL type names onto the legacy names used in
+// table schemas, e.g. INT64 to INTEGER and STRUCT to RECORD.
+func NormalizeType(fieldType string) string {
+	switch upper := strings.ToUpper(fieldType); upper {
+	case "INT64":
+		return "INTEGER"
+	case "FLOAT64":
+		return "FLOAT"
+	case "BOOL":
+		return "BOOLEAN"
+	case "STRUCT":
+		return "RECORD"
+	default:
+		return upper
+	}
+}
+
+// NormalizeMode treats an empty mode as NULLABLE, like BigQuery does.
+func NormalizeMode(mode string) string {
+	if mode == "" {
+		return "NULLABLE"
+	}
+	return strings.ToUpper(mode)
+}
@@ -7,6 +7,7 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/danielstutzman/fake-bigquery/routes"
 )
@@ -14,6 +15,20 @@ import (
 func main() {
 	discoveryJsonPath := flag.String("discovery-json-path", "", "path to discovery.json")
 	portNum := flag.Int("port", 0, "port number to listen at")
+	dedupWindow := flag.Duration("dedup-window", time.Minute,
+		"how long to remember insertIds for deduplicating streamed rows (0 disables)")
+	dedupLeakRate := flag.Float64("dedup-leak-rate", 0,
+		"fraction of duplicate streamed rows to let through anyway (0 to 1)")
+	gcsDir := flag.String("gcs-dir", "",
+		"directory standing in for Cloud Storage, with one subdirectory per bucket")
+	maxConcurrentQueries := flag.Int("max-concurrent-queries", 0,
+		"query jobs each project may run at once (0 for no limit)")
+	maxInsertRowsPerSecond := flag.Int("max-insert-rows-per-second", 0,
+		"rows each project may stream per second (0 for no limit)")
+	maxTableCreationsPerDay := flag.Int("max-table-creations-per-day", 0,
+		"tables each project may create with tables.insert per day (0 for no limit)")
+	streamingBufferFlush := flag.Duration("streaming-buffer-flush", 90*time.Minute,
+		"how long streamed rows stay in their table's streaming buffer")
 	flag.Parse()
 
 	if *discoveryJsonPath == "" {
@@ -32,11 +47,21 @@ func main() {
 	discoveryJson = bytes.Replace(discoveryJson,
 		[]byte("https://www.googleapis.com"), []byte(myUrl), -1)
 
-	listenAndServe(discoveryJson, *portNum)
+	options := routes.Options{
+		DedupWindow:   *dedupWindow,
+		DedupLeakRate: *dedupLeakRate,
+		GcsDir:        *gcsDir,
+
+		MaxConcurrentQueries:    *maxConcurrentQueries,
+		MaxInsertRowsPerSecond:  *maxInsertRowsPerSecond,
+		MaxTableCreationsPerDay: *maxTableCreationsPerDay,
+		StreamingBufferFlush:    *streamingBufferFlush,
+	}
+	listenAndServe(discoveryJson, options, *portNum)
 }
 
-func listenAndServe(discoveryJson []byte, portNum int) {
-	app := routes.NewApp(discoveryJson)
+func listenAndServe(discoveryJson []byte, options routes.Options, portNum int) {
+	app := routes.NewApp(discoveryJson, options)
 	http.HandleFunc("/", app.Route)
 
 	log.Printf("Listening on :%d...", portNum)
@@ -0,0 +1,235 @@
+package formats
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/danielstutzman/fake-bigquery/data"
+)
+
+var NON_IDENTIFIER_REGEXP = regexp.MustCompile(`[^A-Za-z0-9_]+`)
+
+// DetectCsvSchema infers a schema from CSV records the way autodetect does:
+// every column gets the narrowest type that fits all its values, and the
+// first record is taken as a header if it doesn't fit those types itself.
+func DetectCsvSchema(records [][]CsvField) (fields []data.Field, hasHeader bool) {
+	numColumns := 0
+	for _, record := range records {
+		if len(record) > numColumns {
+			numColumns = len(record)
+		}
+	}
+
+	detectTypes := func(records [][]CsvField) []string {
+		types := make([]string, numColumns)
+		for _, record := range records {
+			for i, field := range record {
+				if field.Value == "" && !field.Quoted {
+					continue
+				}
+				types[i] = widenType(types[i], detectStringType(field.Value))
+			}
+		}
+		for i := range types {
+			if types[i] == "" {
+				types[i] = "STRING"
+			}
+		}
+		return types
+	}
+
+	types := detectTypes(records)
+	if len(records) > 1 {
+		bodyTypes := detectTypes(records[1:])
+		for i, field := range records[0] {
+			if bodyTypes[i] != "STRING" && detectStringType(field.Value) == "STRING" {
+				hasHeader = true
+			}
+		}
+		if hasHeader {
+			types = bodyTypes
+		}
+	}
+
+	for i := 0; i < numColumns; i++ {
+		name := fmt.Sprintf("string_field_%d", i)
+		if types[i] != "STRING" {
+			name = fmt.Sprintf("%s_field_%d", strings.ToLower(types[i]), i)
+		}
+		if hasHeader && i < len(records[0]) && records[0][i].Value != "" {
+			name = ColumnName(records[0][i].Value)
+		}
+		fields = append(fields, data.Field{Name: name, Type: types[i], Mode: "NULLABLE"})
+	}
+	return fields, hasHeader
+}
+
+// DetectJsonSchema infers a schema from decoded JSON rows. Since Go maps
+// don't keep key order, columns are sorted by name within each row.
+func DetectJsonSchema(rows []map[string]interface{}) []data.Field {
+	fields := []data.Field{}
+	for _, row := range rows {
+		fields = mergeJsonFields(fields, row)
+	}
+	return fields
+}
+
+func mergeJsonFields(fields []data.Field, row map[string]interface{}) []data.Field {
+	keys := []string{}
+	for key := range row {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := row[key]
+		if value == nil {
+			continue
+		}
+
+		detected := detectJsonField(key, value)
+		found := false
+		for i, field := range fields {
+			if strings.EqualFold(field.Name, key) {
+				found = true
+				if field.Type == "RECORD" && detected.Type == "RECORD" {
+					for _, element := range jsonRecords(value) {
+						fields[i].Fields = mergeJsonFields(fields[i].Fields, element)
+					}
+				} else if field.Type != "RECORD" && detected.Type != "RECORD" {
+					fields[i].Type = widenType(field.Type, detected.Type)
+				}
+				if detected.Mode == "REPEATED" {
+					fields[i].Mode = "REPEATED"
+				}
+			}
+		}
+		if !found {
+			fields = append(fields, detected)
+		}
+	}
+	return fields
+}
+
+func detectJsonField(name string, value interface{}) data.Field {
+	field := data.Field{Name: name, Mode: "NULLABLE"}
+	if elements, ok := value.([]interface{}); ok {
+		field.Mode = "REPEATED"
+		for _, element := range elements {
+			if element != nil {
+				elementField := detectJsonField(name, element)
+				if field.Type == "" || field.Type == elementField.Type {
+					field.Type = elementField.Type
+					field.Fields = mergeFieldLists(field.Fields, elementField.Fields)
+				} else if field.Type != "RECORD" && elementField.Type != "RECORD" {
+					field.Type = widenType(field.Type, elementField.Type)
+				}
+			}
+		}
+		if field.Type == "" {
+			field.Type = "STRING"
+		}
+		return field
+	}
+
+	switch value := value.(type) {
+	case map[string]interface{}:
+		field.Type = "RECORD"
+		field.Fields = mergeJsonFields([]data.Field{}, value)
+	case bool:
+		field.Type = "BOOLEAN"
+	case json.Number:
+		if _, err := value.Int64(); err == nil {
+			field.Type = "INTEGER"
+		} else {
+			field.Type = "FLOAT"
+		}
+	case float64:
+		field.Type = "FLOAT"
+	case string:
+		field.Type = detectStringType(value)
+		if field.Type == "INTEGER" || field.Type == "FLOAT" || field.Type == "BOOLEAN" {
+			// Quoted numbers and booleans stay strings in JSON
+			field.Type = "STRING"
+		}
+	default:
+		field.Type = "STRING"
+	}
+	return field
+}
+
+func mergeFieldLists(fields, moreFields []data.Field) []data.Field {
+	for _, moreField := range moreFields {
+		found := false
+		for _, field := range fields {
+			if strings.EqualFold(field.Name, moreField.Name) {
+				found = true
+			}
+		}
+		if !found {
+			fields = append(fields, moreField)
+		}
+	}
+	return fields
+}
+
+func jsonRecords(value interface{}) []map[string]interface{} {
+	if record, ok := value.(map[string]interface{}); ok {
+		return []map[string]interface{}{record}
+	}
+	records := []map[string]interface{}{}
+	if elements, ok := value.([]interface{}); ok {
+		for _, element := range elements {
+			if record, ok := element.(map[string]interface{}); ok {
+				records = append(records, record)
+			}
+		}
+	}
+	return records
+}
+
+// detectStringType returns the narrowest type a string value parses as.
+func detectStringType(value string) string {
+	for _, fieldType := range []string{"INTEGER", "FLOAT", "BOOLEAN", "DATE", "TIME", "TIMESTAMP"} {
+		if fieldType == "BOOLEAN" {
+			lower := strings.ToLower(strings.TrimSpace(value))
+			if lower != "true" && lower != "false" {
+				continue
+			}
+		}
+		if _, err := data.ConvertValue(fieldType, value); err == nil {
+			return fieldType
+		}
+	}
+	return "STRING"
+}
+
+// widenType returns a type that can hold values of both types.
+func widenType(type1, type2 string) string {
+	if type1 == "" || type1 == type2 {
+		return type2
+	}
+	if type2 == "" {
+		return type1
+	}
+	if (type1 == "INTEGER" || type1 == "FLOAT") && (type2 == "INTEGER" || type2 == "FLOAT") {
+		return "FLOAT"
+	}
+	if (type1 == "DATE" || type1 == "TIMESTAMP") && (type2 == "DATE" || type2 == "TIMESTAMP") {
+		return "TIMESTAMP"
+	}
+	return "STRING"
+}
+
+// ColumnName turns a header into a legal column name, e.g. "First name"
+// into "First_name".
+func ColumnName(header string) string {
+	name := NON_IDENTIFIER_REGEXP.ReplaceAllString(strings.TrimSpace(header), "_")
+	if name == "" || name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name
+}
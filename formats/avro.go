@@ -0,0 +1,648 @@
+package formats
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"time"
+
+	"github.com/danielstutzman/fake-bigquery/data"
+)
+
+var AVRO_MAGIC = []byte("Obj\x01")
+
+type avroSchema struct {
+	Type        string // null, boolean, int, long, float, double, bytes, string, record, enum, array, map, fixed, union
+	LogicalType string
+	Scale       int
+	Name        string
+	Fields      []avroField   // record
+	Items       *avroSchema   // array
+	Values      *avroSchema   // map
+	Symbols     []string      // enum
+	Size        int           // fixed
+	Branches    []*avroSchema // union
+}
+
+type avroField struct {
+	Name   string
+	Schema *avroSchema
+}
+
+// ReadAvro decodes an Avro object container file into a schema and rows,
+// mapping Avro types onto BigQuery's the way load jobs do: a union with
+// null becomes NULLABLE, an array becomes REPEATED, and logical types like
+// timestamp-micros become TIMESTAMP.
+func ReadAvro(content []byte) ([]data.Field, []map[string]interface{}, error) {
+	if !bytes.HasPrefix(content, AVRO_MAGIC) {
+		return nil, nil, fmt.Errorf("Not an Avro file")
+	}
+	reader := &avroReader{buf: content[len(AVRO_MAGIC):]}
+
+	metadata := map[string][]byte{}
+	for {
+		count := reader.readLong()
+		if count == 0 {
+			break
+		}
+		if count < 0 {
+			count = -count
+			reader.readLong() // byte size of block
+		}
+		for i := int64(0); i < count; i++ {
+			key := string(reader.readBytes())
+			metadata[key] = reader.readBytes()
+		}
+	}
+	sync := reader.readFixed(16)
+	if reader.err != nil {
+		return nil, nil, fmt.Errorf("Error reading Avro header: %s", reader.err)
+	}
+
+	var rawSchema interface{}
+	if err := json.Unmarshal(metadata["avro.schema"], &rawSchema); err != nil {
+		return nil, nil, fmt.Errorf("Error parsing Avro schema: %s", err)
+	}
+	schema, err := parseAvroSchema(rawSchema, map[string]*avroSchema{})
+	if err != nil {
+		return nil, nil, err
+	}
+	if schema.Type != "record" {
+		return nil, nil, fmt.Errorf("Avro schema must be a record, not %s", schema.Type)
+	}
+	fields := make([]data.Field, 0, len(schema.Fields))
+	for _, field := range schema.Fields {
+		fields = append(fields, avroToField(field.Name, field.Schema))
+	}
+
+	codec := string(metadata["avro.codec"])
+	rows := []map[string]interface{}{}
+	for len(reader.buf) > 0 && reader.err == nil {
+		count := reader.readLong()
+		size := reader.readLong()
+		block := reader.readFixed(int(size))
+		if !bytes.Equal(reader.readFixed(16), sync) && reader.err == nil {
+			return nil, nil, fmt.Errorf("Avro sync marker mismatch")
+		}
+		if reader.err != nil {
+			break
+		}
+
+		switch codec {
+		case "", "null":
+		case "deflate":
+			block, err = ioutil.ReadAll(flate.NewReader(bytes.NewReader(block)))
+			if err != nil {
+				return nil, nil, fmt.Errorf("Error inflating Avro block: %s", err)
+			}
+		case "snappy":
+			if len(block) < 4 {
+				return nil, nil, fmt.Errorf("Corrupt snappy Avro block")
+			}
+			// The last 4 bytes are a CRC32 of the uncompressed data
+			block, err = SnappyDecode(block[:len(block)-4])
+			if err != nil {
+				return nil, nil, err
+			}
+		default:
+			return nil, nil, fmt.Errorf("Unsupported Avro codec: %s", codec)
+		}
+
+		blockReader := &avroReader{buf: block}
+		for i := int64(0); i < count; i++ {
+			value := blockReader.readValue(schema)
+			if blockReader.err != nil {
+				return nil, nil, fmt.Errorf("Error decoding Avro row: %s", blockReader.err)
+			}
+			rows = append(rows, value.(map[string]interface{}))
+		}
+	}
+	if reader.err != nil {
+		return nil, nil, fmt.Errorf("Error reading Avro file: %s", reader.err)
+	}
+	return fields, rows, nil
+}
+
+func parseAvroSchema(raw interface{}, named map[string]*avroSchema) (*avroSchema, error) {
+	switch raw := raw.(type) {
+	case string:
+		if schema, ok := named[raw]; ok {
+			return schema, nil
+		}
+		return &avroSchema{Type: raw}, nil
+
+	case []interface{}:
+		schema := &avroSchema{Type: "union"}
+		for _, rawBranch := range raw {
+			branch, err := parseAvroSchema(rawBranch, named)
+			if err != nil {
+				return nil, err
+			}
+			schema.Branches = append(schema.Branches, branch)
+		}
+		return schema, nil
+
+	case map[string]interface{}:
+		schema := &avroSchema{}
+		schema.Type, _ = raw["type"].(string)
+		schema.LogicalType, _ = raw["logicalType"].(string)
+		schema.Name, _ = raw["name"].(string)
+		if scale, ok := raw["scale"].(float64); ok {
+			schema.Scale = int(scale)
+		}
+
+		switch schema.Type {
+		case "record", "error":
+			schema.Type = "record"
+			named[schema.Name] = schema
+			rawFields, _ := raw["fields"].([]interface{})
+			for _, rawField := range rawFields {
+				rawFieldMap, _ := rawField.(map[string]interface{})
+				name, _ := rawFieldMap["name"].(string)
+				fieldSchema, err := parseAvroSchema(rawFieldMap["type"], named)
+				if err != nil {
+					return nil, err
+				}
+				schema.Fields = append(schema.Fields, avroField{Name: name, Schema: fieldSchema})
+			}
+		case "enum":
+			named[schema.Name] = schema
+			rawSymbols, _ := raw["symbols"].([]interface{})
+			for _, rawSymbol := range rawSymbols {
+				symbol, _ := rawSymbol.(string)
+				schema.Symbols = append(schema.Symbols, symbol)
+			}
+		case "fixed":
+			named[schema.Name] = schema
+			if size, ok := raw["size"].(float64); ok {
+				schema.Size = int(size)
+			}
+		case "array":
+			items, err := parseAvroSchema(raw["items"], named)
+			if err != nil {
+				return nil, err
+			}
+			schema.Items = items
+		case "map":
+			values, err := parseAvroSchema(raw["values"], named)
+			if err != nil {
+				return nil, err
+			}
+			schema.Values = values
+		case "":
+			// e.g. {"type": {"type": "string"}}
+			return parseAvroSchema(raw["type"], named)
+		}
+		return schema, nil
+
+	default:
+		return nil, fmt.Errorf("Invalid Avro schema: %v", raw)
+	}
+}
+
+func avroToField(name string, schema *avroSchema) data.Field {
+	field := data.Field{Name: name, Mode: "REQUIRED"}
+
+	if schema.Type == "union" {
+		nonNull := []*avroSchema{}
+		for _, branch := range schema.Branches {
+			if branch.Type != "null" {
+				nonNull = append(nonNull, branch)
+			}
+		}
+		if len(nonNull) == 1 {
+			field = avroToField(name, nonNull[0])
+			if field.Mode == "REQUIRED" {
+				field.Mode = "NULLABLE"
+			}
+			return field
+		}
+		// Unions of several types can't be represented; fall back to STRING
+		field.Type = "STRING"
+		field.Mode = "NULLABLE"
+		return field
+	}
+
+	if schema.Type == "array" {
+		field = avroToField(name, schema.Items)
+		field.Mode = "REPEATED"
+		return field
+	}
+
+	if schema.Type == "map" {
+		valueField := avroToField("value", schema.Values)
+		field.Type = "RECORD"
+		field.Mode = "REPEATED"
+		field.Fields = []data.Field{
+			{Name: "key", Type: "STRING", Mode: "REQUIRED"},
+			valueField,
+		}
+		return field
+	}
+
+	switch schema.LogicalType {
+	case "timestamp-millis", "timestamp-micros":
+		field.Type = "TIMESTAMP"
+		return field
+	case "local-timestamp-millis", "local-timestamp-micros", "datetime":
+		field.Type = "DATETIME"
+		return field
+	case "date":
+		field.Type = "DATE"
+		return field
+	case "time-millis", "time-micros":
+		field.Type = "TIME"
+		return field
+	case "decimal":
+		field.Type = "NUMERIC"
+		return field
+	}
+
+	switch schema.Type {
+	case "boolean":
+		field.Type = "BOOLEAN"
+	case "int", "long":
+		field.Type = "INTEGER"
+	case "float", "double":
+		field.Type = "FLOAT"
+	case "bytes", "fixed":
+		field.Type = "BYTES"
+	case "record":
+		field.Type = "RECORD"
+		for _, subField := range schema.Fields {
+			field.Fields = append(field.Fields, avroToField(subField.Name, subField.Schema))
+		}
+	default: // string, enum
+		field.Type = "STRING"
+	}
+	return field
+}
+
+type avroReader struct {
+	buf []byte
+	err error
+}
+
+func (reader *avroReader) readLong() int64 {
+	if reader.err != nil {
+		return 0
+	}
+	value, n := binary.Varint(reader.buf)
+	if n <= 0 {
+		reader.err = fmt.Errorf("bad varint")
+		return 0
+	}
+	reader.buf = reader.buf[n:]
+	return value
+}
+
+func (reader *avroReader) readFixed(size int) []byte {
+	if reader.err != nil {
+		return nil
+	}
+	if size < 0 || size > len(reader.buf) {
+		reader.err = fmt.Errorf("unexpected end of data")
+		return nil
+	}
+	value := reader.buf[:size]
+	reader.buf = reader.buf[size:]
+	return value
+}
+
+func (reader *avroReader) readBytes() []byte {
+	return reader.readFixed(int(reader.readLong()))
+}
+
+func (reader *avroReader) readValue(schema *avroSchema) interface{} {
+	if reader.err != nil {
+		return nil
+	}
+
+	switch schema.Type {
+	case "null":
+		return nil
+	case "boolean":
+		bytes := reader.readFixed(1)
+		return bytes != nil && bytes[0] != 0
+	case "int", "long":
+		value := reader.readLong()
+		switch schema.LogicalType {
+		case "timestamp-millis":
+			return time.Unix(0, value*int64(time.Millisecond)).UTC()
+		case "timestamp-micros":
+			return time.Unix(0, value*int64(time.Microsecond)).UTC()
+		case "local-timestamp-millis":
+			return time.Unix(0, value*int64(time.Millisecond)).UTC().Format("2006-01-02T15:04:05.999999")
+		case "local-timestamp-micros":
+			return time.Unix(0, value*int64(time.Microsecond)).UTC().Format("2006-01-02T15:04:05.999999")
+		case "date":
+			return time.Unix(value*86400, 0).UTC().Format("2006-01-02")
+		case "time-millis":
+			return time.Unix(0, value*int64(time.Millisecond)).UTC().Format("15:04:05.999999")
+		case "time-micros":
+			return time.Unix(0, value*int64(time.Microsecond)).UTC().Format("15:04:05.999999")
+		}
+		return value
+	case "float":
+		bytes := reader.readFixed(4)
+		if bytes == nil {
+			return nil
+		}
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(bytes)))
+	case "double":
+		bytes := reader.readFixed(8)
+		if bytes == nil {
+			return nil
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(bytes))
+	case "bytes", "fixed":
+		var bytes []byte
+		if schema.Type == "fixed" {
+			bytes = reader.readFixed(schema.Size)
+		} else {
+			bytes = reader.readBytes()
+		}
+		if schema.LogicalType == "decimal" {
+			return decodeDecimal(bytes, schema.Scale)
+		}
+		return append([]byte{}, bytes...)
+	case "string":
+		return string(reader.readBytes())
+	case "enum":
+		index := int(reader.readLong())
+		if index < 0 || index >= len(schema.Symbols) {
+			reader.err = fmt.Errorf("bad enum index %d", index)
+			return nil
+		}
+		return schema.Symbols[index]
+	case "union":
+		index := int(reader.readLong())
+		if index < 0 || index >= len(schema.Branches) {
+			reader.err = fmt.Errorf("bad union index %d", index)
+			return nil
+		}
+		return reader.readValue(schema.Branches[index])
+	case "record":
+		record := map[string]interface{}{}
+		for _, field := range schema.Fields {
+			record[field.Name] = reader.readValue(field.Schema)
+		}
+		return record
+	case "array", "map":
+		elements := []interface{}{}
+		for {
+			count := reader.readLong()
+			if count == 0 || reader.err != nil {
+				break
+			}
+			if count < 0 {
+				count = -count
+				reader.readLong() // byte size of block
+			}
+			for i := int64(0); i < count; i++ {
+				if schema.Type == "array" {
+					elements = append(elements, reader.readValue(schema.Items))
+				} else {
+					key := string(reader.readBytes())
+					elements = append(elements, map[string]interface{}{
+						"key":   key,
+						"value": reader.readValue(schema.Values),
+					})
+				}
+			}
+		}
+		return elements
+	default:
+		reader.err = fmt.Errorf("unsupported Avro type %s", schema.Type)
+		return nil
+	}
+}
+
+// decodeDecimal interprets big-endian two's-complement bytes as an
+// unscaled decimal.
+func decodeDecimal(bytes []byte, scale int) float64 {
+	unscaled := new(big.Int).SetBytes(bytes)
+	if len(bytes) > 0 && bytes[0]&0x80 != 0 {
+		unscaled.Sub(unscaled, new(big.Int).Lsh(big.NewInt(1), uint(8*len(bytes))))
+	}
+	value, _ := new(big.Float).Quo(new(big.Float).SetInt(unscaled),
+		new(big.Float).SetFloat64(math.Pow10(scale))).Float64()
+	return value
+}
+
+// WriteAvro renders rows as an Avro object container file, using logical
+// types for TIMESTAMP, DATE, TIME and NUMERIC columns. codec is "null",
+// "deflate" or "snappy".
+func WriteAvro(fields []data.Field, rows []map[string]interface{}, codec string) ([]byte, error) {
+	recordCount := 0
+	schemaJson, err := json.Marshal(avroRecordSchema("Root", fields, &recordCount))
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	out.Write(AVRO_MAGIC)
+	writer := &avroWriter{out: &out}
+	writer.writeLong(2)
+	writer.writeBytes([]byte("avro.schema"))
+	writer.writeBytes(schemaJson)
+	writer.writeBytes([]byte("avro.codec"))
+	writer.writeBytes([]byte(codec))
+	writer.writeLong(0)
+	sync := []byte("fake-bigquery-sy")
+	out.Write(sync)
+
+	if len(rows) == 0 {
+		return out.Bytes(), nil
+	}
+
+	var block bytes.Buffer
+	blockWriter := &avroWriter{out: &block}
+	for _, row := range rows {
+		blockWriter.writeRecord(fields, row)
+	}
+	blockBytes := block.Bytes()
+
+	switch codec {
+	case "null":
+	case "deflate":
+		var compressed bytes.Buffer
+		deflater, err := flate.NewWriter(&compressed, flate.DefaultCompression)
+		if err != nil {
+			return nil, err
+		}
+		deflater.Write(blockBytes)
+		deflater.Close()
+		blockBytes = compressed.Bytes()
+	case "snappy":
+		checksum := make([]byte, 4)
+		binary.BigEndian.PutUint32(checksum, crc32.ChecksumIEEE(blockBytes))
+		blockBytes = append(SnappyEncode(blockBytes), checksum...)
+	default:
+		return nil, fmt.Errorf("Unsupported Avro codec: %s", codec)
+	}
+
+	writer.writeLong(int64(len(rows)))
+	writer.writeLong(int64(len(blockBytes)))
+	out.Write(blockBytes)
+	out.Write(sync)
+	return out.Bytes(), nil
+}
+
+func avroRecordSchema(name string, fields []data.Field, recordCount *int) map[string]interface{} {
+	avroFields := []map[string]interface{}{}
+	for _, field := range fields {
+		fieldSchema := avroFieldSchema(field, recordCount)
+		switch data.NormalizeMode(field.Mode) {
+		case "NULLABLE":
+			fieldSchema = []interface{}{"null", fieldSchema}
+		case "REPEATED":
+			fieldSchema = map[string]interface{}{"type": "array", "items": fieldSchema}
+		}
+		avroFields = append(avroFields, map[string]interface{}{
+			"name": field.Name,
+			"type": fieldSchema,
+		})
+	}
+	return map[string]interface{}{
+		"type":   "record",
+		"name":   name,
+		"fields": avroFields,
+	}
+}
+
+func avroFieldSchema(field data.Field, recordCount *int) interface{} {
+	switch data.NormalizeType(field.Type) {
+	case "RECORD":
+		*recordCount += 1
+		return avroRecordSchema(fmt.Sprintf("__s_%d", *recordCount-1), field.Fields, recordCount)
+	case "INTEGER":
+		return "long"
+	case "FLOAT":
+		return "double"
+	case "BOOLEAN":
+		return "boolean"
+	case "BYTES":
+		return "bytes"
+	case "TIMESTAMP":
+		return map[string]interface{}{"type": "long", "logicalType": "timestamp-micros"}
+	case "DATE":
+		return map[string]interface{}{"type": "int", "logicalType": "date"}
+	case "TIME":
+		return map[string]interface{}{"type": "long", "logicalType": "time-micros"}
+	case "DATETIME":
+		return map[string]interface{}{"type": "string", "logicalType": "datetime"}
+	case "NUMERIC", "BIGNUMERIC":
+		return map[string]interface{}{"type": "bytes", "logicalType": "decimal", "precision": 38, "scale": 9}
+	case "JSON":
+		return map[string]interface{}{"type": "string", "sqlType": "JSON"}
+	default:
+		return "string"
+	}
+}
+
+type avroWriter struct {
+	out *bytes.Buffer
+}
+
+func (writer *avroWriter) writeLong(value int64) {
+	buf := make([]byte, binary.MaxVarintLen64)
+	writer.out.Write(buf[:binary.PutVarint(buf, value)])
+}
+
+func (writer *avroWriter) writeBytes(value []byte) {
+	writer.writeLong(int64(len(value)))
+	writer.out.Write(value)
+}
+
+func (writer *avroWriter) writeRecord(fields []data.Field, row map[string]interface{}) {
+	for _, field := range fields {
+		value := row[field.Name]
+		switch data.NormalizeMode(field.Mode) {
+		case "NULLABLE":
+			if value == nil {
+				writer.writeLong(0)
+				continue
+			}
+			writer.writeLong(1)
+			writer.writeValue(field, value)
+		case "REPEATED":
+			elements, _ := value.([]interface{})
+			if len(elements) > 0 {
+				writer.writeLong(int64(len(elements)))
+				for _, element := range elements {
+					writer.writeValue(field, element)
+				}
+			}
+			writer.writeLong(0)
+		default:
+			writer.writeValue(field, value)
+		}
+	}
+}
+
+func (writer *avroWriter) writeValue(field data.Field, value interface{}) {
+	switch data.NormalizeType(field.Type) {
+	case "RECORD":
+		record, _ := value.(map[string]interface{})
+		writer.writeRecord(field.Fields, record)
+	case "INTEGER":
+		number, _ := value.(int64)
+		writer.writeLong(number)
+	case "FLOAT":
+		number, _ := value.(float64)
+		buf := make([]byte, 8)
+		binary.LittleEndian.PutUint64(buf, math.Float64bits(number))
+		writer.out.Write(buf)
+	case "BOOLEAN":
+		if flag, _ := value.(bool); flag {
+			writer.out.WriteByte(1)
+		} else {
+			writer.out.WriteByte(0)
+		}
+	case "BYTES":
+		bytes, _ := value.([]byte)
+		writer.writeBytes(bytes)
+	case "TIMESTAMP":
+		t, _ := value.(time.Time)
+		writer.writeLong(t.UnixNano() / int64(time.Microsecond))
+	case "DATE":
+		date, _ := time.Parse("2006-01-02", fmt.Sprintf("%v", value))
+		writer.writeLong(date.Unix() / 86400)
+	case "TIME":
+		timeOfDay, _ := time.Parse("15:04:05.999999", fmt.Sprintf("%v", value))
+		midnight := time.Date(0, 1, 1, 0, 0, 0, 0, time.UTC)
+		writer.writeLong(int64(timeOfDay.Sub(midnight) / time.Microsecond))
+	case "NUMERIC", "BIGNUMERIC":
+		number, _ := value.(float64)
+		writer.writeBytes(encodeDecimal(number, 9))
+	default:
+		writer.writeBytes([]byte(data.FormatScalar(field.Type, value)))
+	}
+}
+
+// encodeDecimal is the inverse of decodeDecimal.
+func encodeDecimal(value float64, scale int) []byte {
+	scaled, _ := new(big.Float).Mul(big.NewFloat(value), big.NewFloat(math.Pow10(scale))).Int(nil)
+	if scaled.Sign() >= 0 {
+		bytes := scaled.Bytes()
+		if len(bytes) == 0 || bytes[0]&0x80 != 0 {
+			bytes = append([]byte{0}, bytes...)
+		}
+		return bytes
+	}
+	// Two's complement: add 2^(8n) for the smallest n that fits
+	numBytes := len(scaled.Bytes()) + 1
+	complement := new(big.Int).Add(scaled, new(big.Int).Lsh(big.NewInt(1), uint(8*numBytes)))
+	bytes := complement.Bytes()
+	for len(bytes) < numBytes {
+		bytes = append([]byte{0xff}, bytes...)
+	}
+	return bytes
+}
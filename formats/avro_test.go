@@ -0,0 +1,140 @@
+package formats
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/danielstutzman/fake-bigquery/data"
+)
+
+var TEST_FIELDS = []data.Field{
+	{Name: "id", Type: "INTEGER", Mode: "REQUIRED"},
+	{Name: "name", Type: "STRING", Mode: "NULLABLE"},
+	{Name: "score", Type: "FLOAT", Mode: "NULLABLE"},
+	{Name: "ok", Type: "BOOLEAN", Mode: "NULLABLE"},
+	{Name: "at", Type: "TIMESTAMP", Mode: "NULLABLE"},
+	{Name: "tags", Type: "INTEGER", Mode: "REPEATED"},
+	{Name: "info", Type: "RECORD", Mode: "NULLABLE", Fields: []data.Field{
+		{Name: "city", Type: "STRING", Mode: "NULLABLE"},
+		{Name: "zip", Type: "INTEGER", Mode: "NULLABLE"},
+	}},
+}
+
+var TEST_ROWS = []map[string]interface{}{
+	{
+		"id":    int64(1),
+		"name":  "one",
+		"score": 1.5,
+		"ok":    true,
+		"at":    time.Date(2024, 1, 2, 3, 4, 5, 6000, time.UTC),
+		"tags":  []interface{}{int64(1), int64(-2)},
+		"info":  map[string]interface{}{"city": "Paris", "zip": int64(75001)},
+	},
+	{
+		"id":    int64(-9007199254740993), // beyond a float64's integers
+		"name":  nil,
+		"score": nil,
+		"ok":    false,
+		"at":    nil,
+		"tags":  []interface{}{},
+		"info":  nil,
+	},
+}
+
+// sameFields compares schemas the way BigQuery does, where "" is NULLABLE.
+func sameFields(t *testing.T, got, want []data.Field) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d fields, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i].Name != want[i].Name || data.NormalizeType(got[i].Type) != data.NormalizeType(want[i].Type) ||
+			data.NormalizeMode(got[i].Mode) != data.NormalizeMode(want[i].Mode) {
+			t.Errorf("field %d is %+v, want %+v", i, got[i], want[i])
+		}
+		sameFields(t, got[i].Fields, want[i].Fields)
+	}
+}
+
+// sameRows compares rows, where a missing value is the same as NULL.
+func sameRows(t *testing.T, got, want []map[string]interface{}) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d rows, want %d", len(got), len(want))
+	}
+	for i := range want {
+		for name, wantValue := range want[i] {
+			gotValue := got[i][name]
+			if wantTime, ok := wantValue.(time.Time); ok {
+				if gotTime, ok := gotValue.(time.Time); !ok || !gotTime.Equal(wantTime) {
+					t.Errorf("row %d: %s is %#v, want %v", i, name, gotValue, wantTime)
+				}
+			} else if elements, ok := wantValue.([]interface{}); ok && len(elements) == 0 {
+				if gotElements, _ := gotValue.([]interface{}); len(gotElements) != 0 {
+					t.Errorf("row %d: %s is %#v, want no elements", i, name, gotValue)
+				}
+			} else if !reflect.DeepEqual(gotValue, wantValue) {
+				t.Errorf("row %d: %s is %#v, want %#v", i, name, gotValue, wantValue)
+			}
+		}
+	}
+}
+
+func TestAvroRoundTrip(t *testing.T) {
+	for _, codec := range []string{"null", "deflate", "snappy"} {
+		content, err := WriteAvro(TEST_FIELDS, TEST_ROWS, codec)
+		if err != nil {
+			t.Fatalf("WriteAvro with codec %s: %v", codec, err)
+		}
+		fields, rows, err := ReadAvro(content)
+		if err != nil {
+			t.Fatalf("ReadAvro with codec %s: %v", codec, err)
+		}
+		sameFields(t, fields, TEST_FIELDS)
+		sameRows(t, rows, TEST_ROWS)
+	}
+}
+
+func TestAvroNoRows(t *testing.T) {
+	content, err := WriteAvro(TEST_FIELDS, nil, "null")
+	if err != nil {
+		t.Fatalf("WriteAvro: %v", err)
+	}
+	fields, rows, err := ReadAvro(content)
+	if err != nil {
+		t.Fatalf("ReadAvro: %v", err)
+	}
+	sameFields(t, fields, TEST_FIELDS)
+	if len(rows) != 0 {
+		t.Errorf("got %d rows, want none", len(rows))
+	}
+}
+
+func TestAvroCorrupt(t *testing.T) {
+	valid, err := WriteAvro(TEST_FIELDS, TEST_ROWS, "snappy")
+	if err != nil {
+		t.Fatalf("WriteAvro: %v", err)
+	}
+	corruptSync := append([]byte{}, valid...)
+	corruptSync[len(corruptSync)-1] ^= 0xff
+	for name, content := range map[string][]byte{
+		"empty":        {},
+		"not avro":     []byte("PAR1 and so on"),
+		"header only":  AVRO_MAGIC,
+		"bad schema":   append(append([]byte{}, AVRO_MAGIC...), 2, 22, 'a', 'v', 'r', 'o', '.', 's', 'c', 'h', 'e', 'm', 'a', 2, '{', 0),
+		"bad sync":     corruptSync,
+		"bad codec":    bytes.Replace(valid, []byte("snappy"), []byte("zstd!!"), 1),
+		"truncated":    valid[:len(valid)-20],
+		"huge varints": append(append([]byte{}, AVRO_MAGIC...), 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01),
+	} {
+		if _, _, err := ReadAvro(content); err == nil {
+			t.Errorf("ReadAvro of %s content succeeded, want an error", name)
+		}
+	}
+	for i := range valid {
+		// Every prefix fails cleanly, rather than panicking
+		ReadAvro(valid[:i])
+	}
+}
@@ -0,0 +1,169 @@
+package formats
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/danielstutzman/fake-bigquery/data"
+)
+
+type CsvOptions struct {
+	FieldDelimiter      string // defaults to ","
+	Quote               string // defaults to "\"", or "" to disable quoting
+	AllowQuotedNewlines bool
+}
+
+// CsvDelimiter interprets a fieldDelimiter option, which may be spelled
+// out as "tab" or an escape like "\t", the way the API accepts it.
+func CsvDelimiter(option string) string {
+	switch option {
+	case "":
+		return ","
+	case "tab", "\\t":
+		return "\t"
+	default:
+		return option
+	}
+}
+
+// ReadCsv splits CSV content into records. Unlike encoding/csv, it
+// supports any delimiter and quote character, and reports quoted fields
+// so that a quoted empty string can be told apart from a NULL.
+func ReadCsv(content string, options CsvOptions) ([][]CsvField, error) {
+	delimiter := CsvDelimiter(options.FieldDelimiter)
+	quote := options.Quote
+
+	records := [][]CsvField{}
+	record := []CsvField{}
+	var field strings.Builder
+	quoted := false
+	inQuotes := false
+	lineNum := 1
+
+	endField := func() {
+		record = append(record, CsvField{Value: field.String(), Quoted: quoted})
+		field.Reset()
+		quoted = false
+	}
+	endRecord := func() {
+		endField()
+		// Skip blank lines
+		if len(record) > 1 || record[0].Value != "" || record[0].Quoted {
+			records = append(records, record)
+		}
+		record = []CsvField{}
+	}
+
+	for i := 0; i < len(content); {
+		if inQuotes {
+			if strings.HasPrefix(content[i:], quote) {
+				if strings.HasPrefix(content[i+len(quote):], quote) {
+					// Doubled quote is an escaped quote
+					field.WriteString(quote)
+					i += 2 * len(quote)
+				} else {
+					inQuotes = false
+					i += len(quote)
+				}
+				continue
+			}
+			if content[i] == '\n' {
+				if !options.AllowQuotedNewlines {
+					return nil, fmt.Errorf(
+						"Error detected while parsing row starting at position: %d. Error: Missing close double quote (\") character.", lineNum)
+				}
+				lineNum += 1
+			}
+			field.WriteByte(content[i])
+			i += 1
+			continue
+		}
+
+		if strings.HasPrefix(content[i:], delimiter) {
+			endField()
+			i += len(delimiter)
+		} else if content[i] == '\n' || content[i] == '\r' {
+			endRecord()
+			if content[i] == '\r' && i+1 < len(content) && content[i+1] == '\n' {
+				i += 1
+			}
+			i += 1
+			lineNum += 1
+		} else if quote != "" && field.Len() == 0 && !quoted && strings.HasPrefix(content[i:], quote) {
+			inQuotes = true
+			quoted = true
+			i += len(quote)
+		} else {
+			field.WriteByte(content[i])
+			i += 1
+		}
+	}
+	if inQuotes {
+		return nil, fmt.Errorf(
+			"Error detected while parsing row starting at position: %d. Error: Missing close double quote (\") character.", lineNum)
+	}
+	if field.Len() > 0 || quoted || len(record) > 0 {
+		endRecord()
+	}
+	return records, nil
+}
+
+type CsvField struct {
+	Value  string
+	Quoted bool
+}
+
+// WriteCsv renders rows as CSV the way extract jobs do, quoting only the
+// values that need it. Fields must not be RECORD or REPEATED.
+func WriteCsv(fields []data.Field, rows []map[string]interface{}, delimiter string, printHeader bool) []byte {
+	delimiter = CsvDelimiter(delimiter)
+	var out bytes.Buffer
+
+	writeRecord := func(values []string) {
+		for i, value := range values {
+			if i > 0 {
+				out.WriteString(delimiter)
+			}
+			if strings.Contains(value, delimiter) || strings.ContainsAny(value, "\"\r\n") {
+				value = "\"" + strings.Replace(value, "\"", "\"\"", -1) + "\""
+			}
+			out.WriteString(value)
+		}
+		out.WriteString("\n")
+	}
+
+	if printHeader {
+		names := []string{}
+		for _, field := range fields {
+			names = append(names, field.Name)
+		}
+		writeRecord(names)
+	}
+	for _, row := range rows {
+		values := []string{}
+		for _, field := range fields {
+			value := row[field.Name]
+			if value == nil {
+				values = append(values, "")
+			} else {
+				values = append(values, FormatExportValue(field.Type, value))
+			}
+		}
+		writeRecord(values)
+	}
+	return out.Bytes()
+}
+
+// FormatExportValue renders a scalar for CSV or JSON exports, which (unlike
+// the API) write TIMESTAMPs like "2020-01-01 12:00:00 UTC".
+func FormatExportValue(fieldType string, value interface{}) string {
+	if t, ok := value.(time.Time); ok && data.NormalizeType(fieldType) == "TIMESTAMP" {
+		return t.UTC().Format("2006-01-02 15:04:05.999999") + " UTC"
+	}
+	if datetime, ok := value.(string); ok && data.NormalizeType(fieldType) == "DATETIME" {
+		return strings.Replace(datetime, "T", " ", 1)
+	}
+	return data.FormatScalar(fieldType, value)
+}
@@ -0,0 +1,89 @@
+package formats
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/danielstutzman/fake-bigquery/data"
+)
+
+// ReadNdjson decodes newline-delimited JSON, one object per line. Numbers
+// are kept as json.Number so that integers survive intact.
+func ReadNdjson(content []byte) ([]map[string]interface{}, error) {
+	rows := []map[string]interface{}{}
+	for lineNum, line := range bytes.Split(content, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		decoder := json.NewDecoder(bytes.NewReader(line))
+		decoder.UseNumber()
+		var row map[string]interface{}
+		if err := decoder.Decode(&row); err != nil {
+			return nil, fmt.Errorf(
+				"Error while reading data, error message: JSON table encountered too many errors, giving up. Rows: %d; errors: 1. Please look into the errors[] collection for more details. (%s)",
+				lineNum+1, strings.TrimPrefix(err.Error(), "json: "))
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// WriteNdjson renders rows as newline-delimited JSON the way extract jobs
+// do: NULLs are omitted, and INTEGERs are quoted to keep their precision.
+func WriteNdjson(fields []data.Field, rows []map[string]interface{}) ([]byte, error) {
+	var out bytes.Buffer
+	for _, row := range rows {
+		rowJson, err := json.Marshal(exportJsonRecord(fields, row))
+		if err != nil {
+			return nil, err
+		}
+		out.Write(rowJson)
+		out.WriteString("\n")
+	}
+	return out.Bytes(), nil
+}
+
+func exportJsonRecord(fields []data.Field, row map[string]interface{}) map[string]interface{} {
+	record := map[string]interface{}{}
+	for _, field := range fields {
+		value := row[field.Name]
+		if value == nil {
+			continue
+		}
+		if data.NormalizeMode(field.Mode) == "REPEATED" {
+			elements, _ := value.([]interface{})
+			exported := []interface{}{}
+			for _, element := range elements {
+				exported = append(exported, exportJsonValue(field, element))
+			}
+			record[field.Name] = exported
+		} else {
+			record[field.Name] = exportJsonValue(field, value)
+		}
+	}
+	return record
+}
+
+func exportJsonValue(field data.Field, value interface{}) interface{} {
+	switch data.NormalizeType(field.Type) {
+	case "RECORD":
+		subRow, _ := value.(map[string]interface{})
+		return exportJsonRecord(field.Fields, subRow)
+	case "FLOAT":
+		if f, ok := value.(float64); ok && !math.IsNaN(f) && !math.IsInf(f, 0) {
+			return f
+		}
+	case "BOOLEAN":
+		return value
+	case "JSON":
+		var decoded interface{}
+		if err := json.Unmarshal([]byte(FormatExportValue(field.Type, value)), &decoded); err == nil {
+			return decoded
+		}
+	}
+	return FormatExportValue(field.Type, value)
+}
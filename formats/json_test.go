@@ -0,0 +1,37 @@
+package formats
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/danielstutzman/fake-bigquery/data"
+)
+
+func TestWriteNdjson(t *testing.T) {
+	content, err := WriteNdjson(TEST_FIELDS, TEST_ROWS)
+	if err != nil {
+		t.Fatalf("WriteNdjson: %s", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	want := []string{
+		`{"at":"2024-01-02 03:04:05.000006 UTC","id":"1","info":{"city":"Paris","zip":"75001"},"name":"one","ok":true,"score":1.5,"tags":["1","-2"]}`,
+		`{"id":"-9007199254740993","ok":false,"tags":[]}`,
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("got %d lines, want %d:\n%s", len(lines), len(want), content)
+	}
+	for i := range want {
+		if lines[i] != want[i] {
+			t.Errorf("line %d is %s, want %s", i, lines[i], want[i])
+		}
+	}
+}
+
+func TestWriteNdjsonError(t *testing.T) {
+	fields := []data.Field{{Name: "ok", Type: "BOOLEAN", Mode: "NULLABLE"}}
+	rows := []map[string]interface{}{{"ok": math.NaN()}}
+	if _, err := WriteNdjson(fields, rows); err == nil {
+		t.Errorf("WriteNdjson succeeded on an unencodable value")
+	}
+}
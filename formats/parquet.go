@@ -0,0 +1,1259 @@
+package formats
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/danielstutzman/fake-bigquery/data"
+)
+
+var PARQUET_MAGIC = []byte("PAR1")
+
+// Parquet enums, as numbered in parquet.thrift
+const (
+	PARQUET_BOOLEAN              = 0
+	PARQUET_INT32                = 1
+	PARQUET_INT64                = 2
+	PARQUET_INT96                = 3
+	PARQUET_FLOAT                = 4
+	PARQUET_DOUBLE               = 5
+	PARQUET_BYTE_ARRAY           = 6
+	PARQUET_FIXED_LEN_BYTE_ARRAY = 7
+
+	PARQUET_REQUIRED = 0
+	PARQUET_OPTIONAL = 1
+	PARQUET_REPEATED = 2
+
+	PARQUET_UTF8             = 0
+	PARQUET_MAP              = 1
+	PARQUET_MAP_KEY_VALUE    = 2
+	PARQUET_LIST             = 3
+	PARQUET_ENUM             = 4
+	PARQUET_DECIMAL          = 5
+	PARQUET_DATE             = 6
+	PARQUET_TIME_MILLIS      = 7
+	PARQUET_TIME_MICROS      = 8
+	PARQUET_TIMESTAMP_MILLIS = 9
+	PARQUET_TIMESTAMP_MICROS = 10
+	PARQUET_JSON             = 19
+
+	PARQUET_ENCODING_PLAIN            = 0
+	PARQUET_ENCODING_PLAIN_DICTIONARY = 2
+	PARQUET_ENCODING_RLE              = 3
+	PARQUET_ENCODING_RLE_DICTIONARY   = 8
+
+	PARQUET_CODEC_UNCOMPRESSED = 0
+	PARQUET_CODEC_SNAPPY       = 1
+	PARQUET_CODEC_GZIP         = 2
+
+	PARQUET_DATA_PAGE       = 0
+	PARQUET_DICTIONARY_PAGE = 2
+	PARQUET_DATA_PAGE_V2    = 3
+)
+
+type parquetNode struct {
+	Name          string
+	Repetition    int
+	PhysicalType  int // -1 for groups
+	TypeLength    int
+	ConvertedType int // -1 for none
+	LogicalType   thriftStruct
+	Scale         int
+	Children      []*parquetNode
+
+	MaxDef int // definition level of a value present at this node
+	MaxRep int // repetition level of this node's innermost repetition
+}
+
+// ReadParquet decodes a Parquet file into a schema and rows. It handles
+// PLAIN and dictionary encodings, uncompressed, Snappy and gzip pages, and
+// nested groups, LISTs and MAPs, which covers files written by the common
+// writers with default settings.
+func ReadParquet(content []byte) ([]data.Field, []map[string]interface{}, error) {
+	if len(content) < 12 || !bytes.HasPrefix(content, PARQUET_MAGIC) ||
+		!bytes.HasSuffix(content, PARQUET_MAGIC) {
+		return nil, nil, fmt.Errorf("Not a Parquet file")
+	}
+
+	footerLength := int(binary.LittleEndian.Uint32(content[len(content)-8:]))
+	if footerLength > len(content)-12 {
+		return nil, nil, fmt.Errorf("Corrupt Parquet footer")
+	}
+	footerReader := &thriftReader{buf: content[len(content)-8-footerLength : len(content)-8]}
+	metadata := footerReader.readStruct()
+	if footerReader.err != nil {
+		return nil, nil, fmt.Errorf("Error reading Parquet metadata: %s", footerReader.err)
+	}
+
+	elements := metadata.list(2)
+	if len(elements) == 0 {
+		return nil, nil, fmt.Errorf("Parquet file has no schema")
+	}
+	root, rest, err := buildParquetNode(elements)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(rest) != 0 {
+		return nil, nil, fmt.Errorf("Parquet schema has extra elements")
+	}
+	setParquetLevels(root, 0, 0)
+
+	leafPaths := [][]*parquetNode{}
+	var collectLeaves func(node *parquetNode, path []*parquetNode)
+	collectLeaves = func(node *parquetNode, path []*parquetNode) {
+		for _, child := range node.Children {
+			childPath := append(append([]*parquetNode{}, path...), child)
+			if child.PhysicalType == -1 {
+				collectLeaves(child, childPath)
+			} else {
+				leafPaths = append(leafPaths, childPath)
+			}
+		}
+	}
+	collectLeaves(root, nil)
+
+	rows := []map[string]interface{}{}
+	for _, rowGroupValue := range metadata.list(4) {
+		rowGroup, _ := rowGroupValue.(thriftStruct)
+		numRows := int(rowGroup.int(3))
+		if numRows < 0 {
+			return nil, nil, fmt.Errorf("Corrupt Parquet row group")
+		}
+		// Rows are added as the columns fill them, rather than trusting numRows
+		groupRows := []map[string]interface{}{}
+
+		columns := rowGroup.list(1)
+		if len(columns) != len(leafPaths) {
+			return nil, nil, fmt.Errorf("Parquet row group has %d columns but schema has %d",
+				len(columns), len(leafPaths))
+		}
+		for i, columnValue := range columns {
+			column, _ := columnValue.(thriftStruct)
+			triples, err := readParquetColumn(content, column.strct(3), leafPaths[i])
+			if err != nil {
+				return nil, nil, err
+			}
+			groupRows, err = assembleParquetColumn(groupRows, numRows, triples, leafPaths[i])
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		if len(groupRows) != numRows {
+			return nil, nil, fmt.Errorf("Parquet row group has %d rows but its columns have %d",
+				numRows, len(groupRows))
+		}
+		rows = append(rows, groupRows...)
+	}
+
+	fields := make([]data.Field, 0, len(root.Children))
+	for _, child := range root.Children {
+		fields = append(fields, parquetToField(child))
+	}
+	for i, row := range rows {
+		reshaped := map[string]interface{}{}
+		for _, child := range root.Children {
+			reshaped[child.Name] = reshapeParquetValue(child, row[child.Name])
+		}
+		rows[i] = reshaped
+	}
+	return fields, rows, nil
+}
+
+func buildParquetNode(elements []interface{}) (*parquetNode, []interface{}, error) {
+	element, _ := elements[0].(thriftStruct)
+	node := &parquetNode{
+		Name:          element.string(4),
+		Repetition:    int(element.int(3)),
+		PhysicalType:  -1,
+		TypeLength:    int(element.int(2)),
+		ConvertedType: -1,
+		LogicalType:   element.strct(10),
+		Scale:         int(element.int(7)),
+	}
+	if element.has(1) {
+		node.PhysicalType = int(element.int(1))
+	}
+	if element.has(6) {
+		node.ConvertedType = int(element.int(6))
+	}
+	if decimal := node.LogicalType.strct(5); decimal != nil {
+		node.Scale = int(decimal.int(1))
+	}
+
+	rest := elements[1:]
+	numChildren := int(element.int(5))
+	for i := 0; i < numChildren; i++ {
+		if len(rest) == 0 {
+			return nil, nil, fmt.Errorf("Parquet schema is truncated")
+		}
+		child, childRest, err := buildParquetNode(rest)
+		if err != nil {
+			return nil, nil, err
+		}
+		node.Children = append(node.Children, child)
+		rest = childRest
+	}
+	return node, rest, nil
+}
+
+func setParquetLevels(node *parquetNode, parentDef, parentRep int) {
+	node.MaxDef = parentDef
+	node.MaxRep = parentRep
+	if node.Repetition == PARQUET_OPTIONAL {
+		node.MaxDef += 1
+	} else if node.Repetition == PARQUET_REPEATED {
+		node.MaxDef += 1
+		node.MaxRep += 1
+	}
+	for _, child := range node.Children {
+		setParquetLevels(child, node.MaxDef, node.MaxRep)
+	}
+}
+
+type parquetTriple struct {
+	rep   int
+	def   int
+	value interface{}
+}
+
+func readParquetColumn(content []byte, columnMetadata thriftStruct, path []*parquetNode) ([]parquetTriple, error) {
+	leaf := path[len(path)-1]
+	if columnMetadata == nil {
+		return nil, fmt.Errorf("Parquet column %s has no metadata", leaf.Name)
+	}
+
+	codec := int(columnMetadata.int(4))
+	numValues := int(columnMetadata.int(5))
+	offset := int(columnMetadata.int(9))
+	if columnMetadata.has(11) && columnMetadata.int(11) > 0 && int(columnMetadata.int(11)) < offset {
+		offset = int(columnMetadata.int(11))
+	}
+
+	var dictionary []interface{}
+	triples := []parquetTriple{}
+	for len(triples) < numValues {
+		if offset < 0 || offset >= len(content) {
+			return nil, fmt.Errorf("Parquet column %s is truncated", leaf.Name)
+		}
+		headerReader := &thriftReader{buf: content[offset:]}
+		header := headerReader.readStruct()
+		if headerReader.err != nil {
+			return nil, fmt.Errorf("Error reading Parquet page header: %s", headerReader.err)
+		}
+		offset += headerReader.pos
+		compressedSize := int(header.int(3))
+		uncompressedSize := int(header.int(2))
+		if compressedSize < 0 || offset+compressedSize > len(content) {
+			return nil, fmt.Errorf("Parquet page of column %s is truncated", leaf.Name)
+		}
+		page := content[offset : offset+compressedSize]
+		offset += compressedSize
+
+		switch header.int(1) {
+		case PARQUET_DICTIONARY_PAGE:
+			page, err := decompressParquet(codec, page, uncompressedSize)
+			if err != nil {
+				return nil, err
+			}
+			dictionaryHeader := header.strct(7)
+			dictionary, _, err = decodeParquetPlain(page, leaf, int(dictionaryHeader.int(1)))
+			if err != nil {
+				return nil, err
+			}
+
+		case PARQUET_DATA_PAGE:
+			page, err := decompressParquet(codec, page, uncompressedSize)
+			if err != nil {
+				return nil, err
+			}
+			dataHeader := header.strct(5)
+			pageValues := int(dataHeader.int(1))
+			if pageValues < 0 {
+				return nil, fmt.Errorf("Corrupt Parquet page header in column %s", leaf.Name)
+			}
+
+			var reps, defs []int
+			if leaf.MaxRep > 0 {
+				reps, page, err = readParquetLevels(page, leaf.MaxRep, pageValues, true)
+				if err != nil {
+					return nil, err
+				}
+			}
+			if leaf.MaxDef > 0 {
+				defs, page, err = readParquetLevels(page, leaf.MaxDef, pageValues, true)
+				if err != nil {
+					return nil, err
+				}
+			}
+			pageTriples, err := decodeParquetValues(page, int(dataHeader.int(2)), leaf, dictionary, reps, defs, pageValues)
+			if err != nil {
+				return nil, err
+			}
+			triples = append(triples, pageTriples...)
+
+		case PARQUET_DATA_PAGE_V2:
+			dataHeader := header.strct(8)
+			pageValues := int(dataHeader.int(1))
+			repLength := int(dataHeader.int(6))
+			defLength := int(dataHeader.int(5))
+			if pageValues < 0 || repLength < 0 || defLength < 0 {
+				return nil, fmt.Errorf("Corrupt Parquet page header in column %s", leaf.Name)
+			}
+			if repLength+defLength > len(page) {
+				return nil, fmt.Errorf("Parquet page of column %s is truncated", leaf.Name)
+			}
+
+			var reps, defs []int
+			var err error
+			if leaf.MaxRep > 0 {
+				reps, _, err = readParquetLevels(page[:repLength], leaf.MaxRep, pageValues, false)
+				if err != nil {
+					return nil, err
+				}
+			}
+			if leaf.MaxDef > 0 {
+				defs, _, err = readParquetLevels(page[repLength:repLength+defLength], leaf.MaxDef, pageValues, false)
+				if err != nil {
+					return nil, err
+				}
+			}
+			values := page[repLength+defLength:]
+			if !dataHeader.has(7) || dataHeader.bool(7) {
+				values, err = decompressParquet(codec, values, uncompressedSize-repLength-defLength)
+				if err != nil {
+					return nil, err
+				}
+			}
+			pageTriples, err := decodeParquetValues(values, int(dataHeader.int(4)), leaf, dictionary, reps, defs, pageValues)
+			if err != nil {
+				return nil, err
+			}
+			triples = append(triples, pageTriples...)
+
+		default:
+			// Index pages and the like carry no values
+		}
+	}
+	return triples, nil
+}
+
+func decompressParquet(codec int, page []byte, uncompressedSize int) ([]byte, error) {
+	switch codec {
+	case PARQUET_CODEC_UNCOMPRESSED:
+		return page, nil
+	case PARQUET_CODEC_SNAPPY:
+		return SnappyDecode(page)
+	case PARQUET_CODEC_GZIP:
+		reader, err := gzip.NewReader(bytes.NewReader(page))
+		if err != nil {
+			return nil, fmt.Errorf("Error decompressing Parquet page: %s", err)
+		}
+		return ioutil.ReadAll(reader)
+	default:
+		return nil, fmt.Errorf("Unsupported Parquet compression codec %d", codec)
+	}
+}
+
+// readParquetLevels decodes repetition or definition levels, which in v1
+// data pages are prefixed with their length in bytes.
+func readParquetLevels(page []byte, maxLevel, count int, lengthPrefixed bool) ([]int, []byte, error) {
+	encoded := page
+	if lengthPrefixed {
+		if len(page) < 4 {
+			return nil, nil, fmt.Errorf("Parquet levels are truncated")
+		}
+		length := int(binary.LittleEndian.Uint32(page))
+		if 4+length > len(page) {
+			return nil, nil, fmt.Errorf("Parquet levels are truncated")
+		}
+		encoded = page[4 : 4+length]
+		page = page[4+length:]
+	}
+	levels, err := decodeRleHybrid(encoded, bitWidth(maxLevel), count)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, level := range levels {
+		if level > maxLevel {
+			return nil, nil, fmt.Errorf("Parquet level %d is more than its maximum of %d", level, maxLevel)
+		}
+	}
+	return levels, page, nil
+}
+
+func bitWidth(maxValue int) int {
+	width := 0
+	for maxValue > 0 {
+		width += 1
+		maxValue >>= 1
+	}
+	return width
+}
+
+// decodeRleHybrid decodes Parquet's RLE/bit-packing hybrid encoding.
+func decodeRleHybrid(buf []byte, width, count int) ([]int, error) {
+	if width > 32 || count < 0 {
+		return nil, fmt.Errorf("Corrupt RLE data in Parquet file")
+	}
+	values := []int{}
+	for len(values) < count {
+		header, n := binary.Uvarint(buf)
+		if n <= 0 {
+			return nil, fmt.Errorf("Corrupt RLE data in Parquet file")
+		}
+		buf = buf[n:]
+
+		if header&1 == 1 {
+			// Groups past the end of buf, or past count, would decode as zeros
+			numGroups := header >> 1
+			if numGroups > uint64(len(buf)+count) {
+				numGroups = uint64(len(buf) + count)
+			}
+			numValues := int(numGroups) * 8
+			numBytes := int(numGroups) * width
+			if numBytes > len(buf) {
+				numBytes = len(buf)
+			}
+			for i := 0; i < numValues && len(values) < count; i++ {
+				value := 0
+				for bit := 0; bit < width; bit++ {
+					bitIndex := i*width + bit
+					if bitIndex/8 < numBytes && buf[bitIndex/8]&(1<<uint(bitIndex%8)) != 0 {
+						value |= 1 << uint(bit)
+					}
+				}
+				values = append(values, value)
+			}
+			buf = buf[numBytes:]
+		} else {
+			runLength := int(header >> 1)
+			numBytes := (width + 7) / 8
+			if numBytes > len(buf) {
+				return nil, fmt.Errorf("Corrupt RLE data in Parquet file")
+			}
+			value := 0
+			for i := 0; i < numBytes; i++ {
+				value |= int(buf[i]) << uint(8*i)
+			}
+			buf = buf[numBytes:]
+			for i := 0; i < runLength && len(values) < count; i++ {
+				values = append(values, value)
+			}
+		}
+	}
+	return values[:count], nil
+}
+
+func decodeParquetValues(page []byte, encoding int, leaf *parquetNode,
+	dictionary []interface{}, reps, defs []int, count int) ([]parquetTriple, error) {
+
+	numPresent := count
+	if defs != nil {
+		numPresent = 0
+		for _, def := range defs {
+			if def == leaf.MaxDef {
+				numPresent += 1
+			}
+		}
+	}
+
+	var values []interface{}
+	switch encoding {
+	case PARQUET_ENCODING_PLAIN:
+		var err error
+		values, _, err = decodeParquetPlain(page, leaf, numPresent)
+		if err != nil {
+			return nil, err
+		}
+	case PARQUET_ENCODING_PLAIN_DICTIONARY, PARQUET_ENCODING_RLE_DICTIONARY:
+		if len(page) < 1 {
+			if numPresent == 0 {
+				break
+			}
+			return nil, fmt.Errorf("Parquet dictionary page of %s is truncated", leaf.Name)
+		}
+		indexes, err := decodeRleHybrid(page[1:], int(page[0]), numPresent)
+		if err != nil {
+			return nil, err
+		}
+		for _, index := range indexes {
+			if index >= len(dictionary) {
+				return nil, fmt.Errorf("Parquet dictionary index out of range in %s", leaf.Name)
+			}
+			values = append(values, dictionary[index])
+		}
+	default:
+		return nil, fmt.Errorf("Unsupported Parquet encoding %d in column %s", encoding, leaf.Name)
+	}
+
+	if len(values) < numPresent {
+		return nil, fmt.Errorf("Parquet values of column %s are truncated", leaf.Name)
+	}
+	triples := make([]parquetTriple, 0, count)
+	valueIndex := 0
+	for i := 0; i < count; i++ {
+		triple := parquetTriple{def: leaf.MaxDef}
+		if reps != nil {
+			triple.rep = reps[i]
+		}
+		if defs != nil {
+			triple.def = defs[i]
+		}
+		if triple.def == leaf.MaxDef {
+			triple.value = convertParquetValue(leaf, values[valueIndex])
+			valueIndex += 1
+		}
+		triples = append(triples, triple)
+	}
+	return triples, nil
+}
+
+func decodeParquetPlain(page []byte, leaf *parquetNode, count int) ([]interface{}, []byte, error) {
+	values := []interface{}{}
+	truncated := fmt.Errorf("Parquet values of column %s are truncated", leaf.Name)
+	for i := 0; i < count; i++ {
+		switch leaf.PhysicalType {
+		case PARQUET_BOOLEAN:
+			if i/8 >= len(page) {
+				return nil, nil, truncated
+			}
+			values = append(values, page[i/8]&(1<<uint(i%8)) != 0)
+		case PARQUET_INT32:
+			if len(page) < 4 {
+				return nil, nil, truncated
+			}
+			values = append(values, int64(int32(binary.LittleEndian.Uint32(page))))
+			page = page[4:]
+		case PARQUET_INT64:
+			if len(page) < 8 {
+				return nil, nil, truncated
+			}
+			values = append(values, int64(binary.LittleEndian.Uint64(page)))
+			page = page[8:]
+		case PARQUET_INT96:
+			if len(page) < 12 {
+				return nil, nil, truncated
+			}
+			nanosOfDay := int64(binary.LittleEndian.Uint64(page))
+			julianDay := int64(binary.LittleEndian.Uint32(page[8:]))
+			values = append(values, time.Unix((julianDay-2440588)*86400, nanosOfDay).UTC())
+			page = page[12:]
+		case PARQUET_FLOAT:
+			if len(page) < 4 {
+				return nil, nil, truncated
+			}
+			values = append(values, float64(math.Float32frombits(binary.LittleEndian.Uint32(page))))
+			page = page[4:]
+		case PARQUET_DOUBLE:
+			if len(page) < 8 {
+				return nil, nil, truncated
+			}
+			values = append(values, math.Float64frombits(binary.LittleEndian.Uint64(page)))
+			page = page[8:]
+		case PARQUET_BYTE_ARRAY:
+			if len(page) < 4 {
+				return nil, nil, truncated
+			}
+			length := int(binary.LittleEndian.Uint32(page))
+			if 4+length > len(page) {
+				return nil, nil, truncated
+			}
+			values = append(values, page[4:4+length])
+			page = page[4+length:]
+		case PARQUET_FIXED_LEN_BYTE_ARRAY:
+			if leaf.TypeLength < 0 || leaf.TypeLength > len(page) {
+				return nil, nil, truncated
+			}
+			values = append(values, page[:leaf.TypeLength])
+			page = page[leaf.TypeLength:]
+		default:
+			return nil, nil, fmt.Errorf("Unsupported Parquet type %d", leaf.PhysicalType)
+		}
+	}
+	if leaf.PhysicalType == PARQUET_BOOLEAN {
+		page = page[(count+7)/8:]
+	}
+	return values, page, nil
+}
+
+// parquetFieldType picks the BigQuery type for a leaf column.
+func parquetFieldType(leaf *parquetNode) string {
+	logical := leaf.LogicalType
+	switch {
+	case leaf.ConvertedType == PARQUET_DECIMAL || logical.has(5):
+		return "NUMERIC"
+	case leaf.ConvertedType == PARQUET_DATE || logical.has(6):
+		return "DATE"
+	case leaf.ConvertedType == PARQUET_TIME_MILLIS || leaf.ConvertedType == PARQUET_TIME_MICROS || logical.has(7):
+		return "TIME"
+	case logical.has(8):
+		if logical.strct(8).has(1) && !logical.strct(8).bool(1) {
+			return "DATETIME"
+		}
+		return "TIMESTAMP"
+	case leaf.ConvertedType == PARQUET_TIMESTAMP_MILLIS || leaf.ConvertedType == PARQUET_TIMESTAMP_MICROS:
+		return "TIMESTAMP"
+	case leaf.ConvertedType == PARQUET_JSON || logical.has(12):
+		return "JSON"
+	case leaf.ConvertedType == PARQUET_UTF8 || leaf.ConvertedType == PARQUET_ENUM ||
+		logical.has(1) || logical.has(4):
+		return "STRING"
+	}
+
+	switch leaf.PhysicalType {
+	case PARQUET_BOOLEAN:
+		return "BOOLEAN"
+	case PARQUET_INT32, PARQUET_INT64:
+		return "INTEGER"
+	case PARQUET_INT96:
+		return "TIMESTAMP"
+	case PARQUET_FLOAT, PARQUET_DOUBLE:
+		return "FLOAT"
+	default:
+		return "BYTES"
+	}
+}
+
+func convertParquetValue(leaf *parquetNode, value interface{}) interface{} {
+	fieldType := parquetFieldType(leaf)
+	switch fieldType {
+	case "NUMERIC":
+		switch value := value.(type) {
+		case int64:
+			return float64(value) / math.Pow10(leaf.Scale)
+		case []byte:
+			return decodeDecimal(value, leaf.Scale)
+		}
+	case "DATE":
+		if days, ok := value.(int64); ok {
+			return time.Unix(days*86400, 0).UTC().Format("2006-01-02")
+		}
+	case "TIME", "TIMESTAMP", "DATETIME":
+		number, ok := value.(int64)
+		if !ok {
+			return value // INT96 is already a time.Time
+		}
+		unit := time.Microsecond
+		if leaf.ConvertedType == PARQUET_TIME_MILLIS || leaf.ConvertedType == PARQUET_TIMESTAMP_MILLIS ||
+			leaf.PhysicalType == PARQUET_INT32 {
+			unit = time.Millisecond
+		}
+		timeUnit := leaf.LogicalType.strct(7)
+		if timeUnit == nil {
+			timeUnit = leaf.LogicalType.strct(8)
+		}
+		if timeUnit != nil {
+			switch {
+			case timeUnit.strct(2).has(1):
+				unit = time.Millisecond
+			case timeUnit.strct(2).has(2):
+				unit = time.Microsecond
+			case timeUnit.strct(2).has(3):
+				unit = time.Nanosecond
+			}
+		}
+		t := time.Unix(0, number*int64(unit)).UTC()
+		if fieldType == "TIME" {
+			return t.Format("15:04:05.999999")
+		} else if fieldType == "DATETIME" {
+			return t.Format("2006-01-02T15:04:05.999999")
+		}
+		return t
+	case "STRING", "JSON":
+		if bytes, ok := value.([]byte); ok {
+			return string(bytes)
+		}
+	case "BYTES":
+		if bytes, ok := value.([]byte); ok {
+			return append([]byte{}, bytes...)
+		}
+	}
+	return value
+}
+
+// assembleParquetColumn places one column's values into the rows, creating
+// the intermediate records and lists along the column's path as needed.
+func assembleParquetColumn(rows []map[string]interface{}, numRows int,
+	triples []parquetTriple, path []*parquetNode) ([]map[string]interface{}, error) {
+
+	repeatedIndexes := make([]int, path[len(path)-1].MaxRep)
+	rowIndex := -1
+	for _, triple := range triples {
+		if triple.rep == 0 {
+			rowIndex += 1
+			for i := range repeatedIndexes {
+				repeatedIndexes[i] = 0
+			}
+		} else if rowIndex == -1 {
+			return nil, fmt.Errorf("Parquet column starts in the middle of a row")
+		} else {
+			repeatedIndexes[triple.rep-1] += 1
+			for i := triple.rep; i < len(repeatedIndexes); i++ {
+				repeatedIndexes[i] = 0
+			}
+		}
+		if rowIndex >= numRows {
+			return nil, fmt.Errorf("Parquet column has more rows than its row group")
+		} else if rowIndex == len(rows) {
+			rows = append(rows, map[string]interface{}{})
+		}
+
+		container := rows[rowIndex]
+		for depth, node := range path {
+			isLeaf := depth == len(path)-1
+			if node.Repetition == PARQUET_REPEATED {
+				list, _ := container[node.Name].([]interface{})
+				if list == nil {
+					list = []interface{}{}
+					container[node.Name] = list
+				}
+				if triple.def < node.MaxDef {
+					break
+				}
+				index := repeatedIndexes[node.MaxRep-1]
+				for len(list) <= index {
+					if isLeaf {
+						list = append(list, nil)
+					} else {
+						list = append(list, map[string]interface{}{})
+					}
+				}
+				container[node.Name] = list
+				if isLeaf {
+					list[index] = triple.value
+					break
+				}
+				container, _ = list[index].(map[string]interface{})
+				if container == nil {
+					return nil, fmt.Errorf("Parquet column %s doesn't match its schema", node.Name)
+				}
+			} else {
+				if triple.def < node.MaxDef {
+					if _, exists := container[node.Name]; !exists {
+						container[node.Name] = nil
+					}
+					break
+				}
+				if isLeaf {
+					container[node.Name] = triple.value
+					break
+				}
+				child, _ := container[node.Name].(map[string]interface{})
+				if child == nil {
+					child = map[string]interface{}{}
+					container[node.Name] = child
+				}
+				container = child
+			}
+		}
+	}
+	return rows, nil
+}
+
+func isParquetList(node *parquetNode) bool {
+	return node.PhysicalType == -1 && len(node.Children) == 1 &&
+		node.Children[0].Repetition == PARQUET_REPEATED &&
+		(node.ConvertedType == PARQUET_LIST || node.LogicalType.has(3))
+}
+
+func isParquetMap(node *parquetNode) bool {
+	return node.PhysicalType == -1 && len(node.Children) == 1 &&
+		node.Children[0].Repetition == PARQUET_REPEATED &&
+		(node.ConvertedType == PARQUET_MAP || node.ConvertedType == PARQUET_MAP_KEY_VALUE ||
+			node.LogicalType.has(2))
+}
+
+// listElement returns the node holding each element of a LIST group, which
+// is the repeated group's only child in the standard 3-level layout.
+func listElement(node *parquetNode) (*parquetNode, bool) {
+	repeated := node.Children[0]
+	if repeated.PhysicalType == -1 && len(repeated.Children) == 1 &&
+		!strings.HasSuffix(repeated.Name, "_tuple") && repeated.Name != "array" {
+		return repeated.Children[0], true
+	}
+	return repeated, false
+}
+
+func parquetToField(node *parquetNode) data.Field {
+	field := data.Field{Name: node.Name, Mode: "NULLABLE"}
+	if node.Repetition == PARQUET_REQUIRED {
+		field.Mode = "REQUIRED"
+	} else if node.Repetition == PARQUET_REPEATED {
+		field.Mode = "REPEATED"
+	}
+
+	if isParquetList(node) {
+		element, _ := listElement(node)
+		elementField := parquetToField(element)
+		elementField.Name = node.Name
+		elementField.Mode = "REPEATED"
+		return elementField
+	}
+	if isParquetMap(node) {
+		keyValue := parquetToField(node.Children[0])
+		keyValue.Name = node.Name
+		keyValue.Mode = "REPEATED"
+		return keyValue
+	}
+
+	if node.PhysicalType == -1 {
+		field.Type = "RECORD"
+		for _, child := range node.Children {
+			field.Fields = append(field.Fields, parquetToField(child))
+		}
+		return field
+	}
+
+	field.Type = parquetFieldType(node)
+	return field
+}
+
+func reshapeParquetValue(node *parquetNode, value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+
+	if isParquetList(node) || isParquetMap(node) {
+		group, _ := value.(map[string]interface{})
+		repeated := node.Children[0]
+		elements, _ := group[repeated.Name].([]interface{})
+		element, nested := listElement(node)
+		if isParquetMap(node) {
+			element, nested = repeated, false
+		}
+		reshaped := []interface{}{}
+		for _, elementValue := range elements {
+			if nested {
+				elementGroup, _ := elementValue.(map[string]interface{})
+				elementValue = elementGroup[element.Name]
+			}
+			if elementValue != nil {
+				reshaped = append(reshaped, reshapeParquetScalarOrGroup(element, elementValue))
+			}
+		}
+		return reshaped
+	}
+
+	if node.Repetition == PARQUET_REPEATED {
+		elements, _ := value.([]interface{})
+		reshaped := []interface{}{}
+		for _, element := range elements {
+			reshaped = append(reshaped, reshapeParquetScalarOrGroup(node, element))
+		}
+		return reshaped
+	}
+	return reshapeParquetScalarOrGroup(node, value)
+}
+
+func reshapeParquetScalarOrGroup(node *parquetNode, value interface{}) interface{} {
+	if isParquetList(node) || isParquetMap(node) {
+		return reshapeParquetValue(node, value)
+	}
+	if node.PhysicalType != -1 {
+		return value
+	}
+	group, _ := value.(map[string]interface{})
+	reshaped := map[string]interface{}{}
+	for _, child := range node.Children {
+		reshaped[child.Name] = reshapeParquetValue(child, group[child.Name])
+	}
+	return reshaped
+}
+
+type parquetColumn struct {
+	path         []data.Field
+	repLevels    []int // repetition level reached at each depth of path
+	maxDef       int
+	maxRep       int
+	physicalType int
+	reps         []int
+	defs         []int
+	values       []interface{}
+}
+
+// WriteParquet renders rows as a Parquet file with one row group and one
+// PLAIN-encoded data page per column. REPEATED fields are written in the
+// simple two-level layout (a repeated field with no LIST wrapper), which
+// every reader accepts. compression is "NONE", "SNAPPY" or "GZIP".
+func WriteParquet(fields []data.Field, rows []map[string]interface{}, compression string) ([]byte, error) {
+	codec := PARQUET_CODEC_UNCOMPRESSED
+	switch compression {
+	case "", "NONE":
+	case "SNAPPY":
+		codec = PARQUET_CODEC_SNAPPY
+	case "GZIP":
+		codec = PARQUET_CODEC_GZIP
+	default:
+		return nil, fmt.Errorf("Unsupported Parquet compression: %s", compression)
+	}
+
+	columns := []*parquetColumn{}
+	var collectColumns func(fields []data.Field, path []data.Field, def, rep int, repLevels []int)
+	collectColumns = func(fields []data.Field, path []data.Field, def, rep int, repLevels []int) {
+		for _, field := range fields {
+			fieldDef, fieldRep := def, rep
+			switch data.NormalizeMode(field.Mode) {
+			case "NULLABLE":
+				fieldDef += 1
+			case "REPEATED":
+				fieldDef += 1
+				fieldRep += 1
+			}
+			fieldPath := append(append([]data.Field{}, path...), field)
+			fieldRepLevels := append(append([]int{}, repLevels...), fieldRep)
+			if data.NormalizeType(field.Type) == "RECORD" {
+				collectColumns(field.Fields, fieldPath, fieldDef, fieldRep, fieldRepLevels)
+			} else {
+				columns = append(columns, &parquetColumn{
+					path:         fieldPath,
+					repLevels:    fieldRepLevels,
+					maxDef:       fieldDef,
+					maxRep:       fieldRep,
+					physicalType: parquetPhysicalType(field.Type),
+				})
+			}
+		}
+	}
+	collectColumns(fields, nil, 0, 0, nil)
+
+	for _, column := range columns {
+		for _, row := range rows {
+			column.shred(row, 0, 0, 0)
+		}
+	}
+
+	var out bytes.Buffer
+	out.Write(PARQUET_MAGIC)
+
+	columnOffsets := []int64{}
+	columnSizes := []int64{}
+	uncompressedSizes := []int64{}
+	for _, column := range columns {
+		var page bytes.Buffer
+		if column.maxRep > 0 {
+			writeParquetLevels(&page, column.reps, column.maxRep)
+		}
+		if column.maxDef > 0 {
+			writeParquetLevels(&page, column.defs, column.maxDef)
+		}
+		if err := writeParquetPlain(&page, column); err != nil {
+			return nil, err
+		}
+
+		pageBytes := page.Bytes()
+		uncompressedSize := len(pageBytes)
+		switch codec {
+		case PARQUET_CODEC_SNAPPY:
+			pageBytes = SnappyEncode(pageBytes)
+		case PARQUET_CODEC_GZIP:
+			var compressed bytes.Buffer
+			gzipWriter := gzip.NewWriter(&compressed)
+			gzipWriter.Write(pageBytes)
+			gzipWriter.Close()
+			pageBytes = compressed.Bytes()
+		}
+
+		header := &thriftWriter{}
+		header.beginStruct()
+		header.i32Field(1, PARQUET_DATA_PAGE)
+		header.i32Field(2, int64(uncompressedSize))
+		header.i32Field(3, int64(len(pageBytes)))
+		header.structField(5)
+		header.i32Field(1, int64(len(column.defs)))
+		header.i32Field(2, PARQUET_ENCODING_PLAIN)
+		header.i32Field(3, PARQUET_ENCODING_RLE)
+		header.i32Field(4, PARQUET_ENCODING_RLE)
+		header.endStruct()
+		header.endStruct()
+
+		columnOffsets = append(columnOffsets, int64(out.Len()))
+		out.Write(header.out.Bytes())
+		out.Write(pageBytes)
+		columnSizes = append(columnSizes, int64(header.out.Len()+len(pageBytes)))
+		uncompressedSizes = append(uncompressedSizes, int64(header.out.Len()+uncompressedSize))
+	}
+
+	metadata := &thriftWriter{}
+	metadata.beginStruct()
+	metadata.i32Field(1, 1)
+	schemaElements := 1
+	var countElements func(fields []data.Field)
+	countElements = func(fields []data.Field) {
+		for _, field := range fields {
+			schemaElements += 1
+			if data.NormalizeType(field.Type) == "RECORD" {
+				countElements(field.Fields)
+			}
+		}
+	}
+	countElements(fields)
+	metadata.listField(2, THRIFT_STRUCT, schemaElements)
+	metadata.beginStruct()
+	metadata.binaryField(4, []byte("schema"))
+	metadata.i32Field(5, int64(len(fields)))
+	metadata.endStruct()
+	writeParquetSchema(metadata, fields)
+	metadata.i64Field(3, int64(len(rows)))
+
+	var totalSize int64
+	for _, size := range uncompressedSizes {
+		totalSize += size
+	}
+	metadata.listField(4, THRIFT_STRUCT, 1)
+	metadata.beginStruct()
+	metadata.listField(1, THRIFT_STRUCT, len(columns))
+	for i, column := range columns {
+		metadata.beginStruct()
+		metadata.i64Field(2, columnOffsets[i])
+		metadata.structField(3)
+		metadata.i32Field(1, int64(column.physicalType))
+		metadata.listField(2, THRIFT_I32, 2)
+		metadata.writeVarint(PARQUET_ENCODING_PLAIN)
+		metadata.writeVarint(PARQUET_ENCODING_RLE)
+		metadata.listField(3, THRIFT_BINARY, len(column.path))
+		for _, field := range column.path {
+			metadata.writeUvarint(uint64(len(field.Name)))
+			metadata.out.WriteString(field.Name)
+		}
+		metadata.i32Field(4, int64(codec))
+		metadata.i64Field(5, int64(len(column.defs)))
+		metadata.i64Field(6, uncompressedSizes[i])
+		metadata.i64Field(7, columnSizes[i])
+		metadata.i64Field(9, columnOffsets[i])
+		metadata.endStruct()
+		metadata.endStruct()
+	}
+	metadata.i64Field(2, totalSize)
+	metadata.i64Field(3, int64(len(rows)))
+	metadata.endStruct()
+	metadata.binaryField(6, []byte("fake-bigquery"))
+	metadata.endStruct()
+
+	out.Write(metadata.out.Bytes())
+	footerLength := make([]byte, 4)
+	binary.LittleEndian.PutUint32(footerLength, uint32(metadata.out.Len()))
+	out.Write(footerLength)
+	out.Write(PARQUET_MAGIC)
+	return out.Bytes(), nil
+}
+
+// shred walks one row down this column's path, recording the repetition
+// and definition level of every value (or NULL) it finds, per Dremel.
+func (column *parquetColumn) shred(container map[string]interface{}, depth, rep, def int) {
+	field := column.path[depth]
+	value := container[field.Name]
+	isLeaf := depth == len(column.path)-1
+
+	switch data.NormalizeMode(field.Mode) {
+	case "REPEATED":
+		elements, _ := value.([]interface{})
+		if len(elements) == 0 {
+			column.add(rep, def, nil)
+			return
+		}
+		for i, element := range elements {
+			elementRep := rep
+			if i > 0 {
+				elementRep = column.repLevels[depth]
+			}
+			if isLeaf {
+				column.add(elementRep, def+1, element)
+			} else {
+				record, _ := element.(map[string]interface{})
+				column.shred(record, depth+1, elementRep, def+1)
+			}
+		}
+		return
+	case "NULLABLE":
+		if value == nil {
+			column.add(rep, def, nil)
+			return
+		}
+		def += 1
+	}
+
+	if isLeaf {
+		column.add(rep, def, value)
+	} else {
+		record, _ := value.(map[string]interface{})
+		column.shred(record, depth+1, rep, def)
+	}
+}
+
+func (column *parquetColumn) add(rep, def int, value interface{}) {
+	column.reps = append(column.reps, rep)
+	column.defs = append(column.defs, def)
+	if def == column.maxDef {
+		column.values = append(column.values, value)
+	}
+}
+
+func parquetPhysicalType(fieldType string) int {
+	switch data.NormalizeType(fieldType) {
+	case "INTEGER", "TIMESTAMP", "TIME", "DATETIME":
+		return PARQUET_INT64
+	case "DATE":
+		return PARQUET_INT32
+	case "FLOAT":
+		return PARQUET_DOUBLE
+	case "BOOLEAN":
+		return PARQUET_BOOLEAN
+	default: // STRING, BYTES, NUMERIC, JSON, GEOGRAPHY
+		return PARQUET_BYTE_ARRAY
+	}
+}
+
+func writeParquetSchema(writer *thriftWriter, fields []data.Field) {
+	for _, field := range fields {
+		writer.beginStruct()
+		fieldType := data.NormalizeType(field.Type)
+		if fieldType != "RECORD" {
+			writer.i32Field(1, int64(parquetPhysicalType(fieldType)))
+		}
+		switch data.NormalizeMode(field.Mode) {
+		case "REQUIRED":
+			writer.i32Field(3, PARQUET_REQUIRED)
+		case "REPEATED":
+			writer.i32Field(3, PARQUET_REPEATED)
+		default:
+			writer.i32Field(3, PARQUET_OPTIONAL)
+		}
+		writer.binaryField(4, []byte(field.Name))
+		if fieldType == "RECORD" {
+			writer.i32Field(5, int64(len(field.Fields)))
+		}
+
+		switch fieldType {
+		case "STRING", "GEOGRAPHY":
+			writer.i32Field(6, PARQUET_UTF8)
+		case "JSON":
+			writer.i32Field(6, PARQUET_JSON)
+		case "DATE":
+			writer.i32Field(6, PARQUET_DATE)
+		case "TIME":
+			writer.i32Field(6, PARQUET_TIME_MICROS)
+		case "TIMESTAMP", "DATETIME":
+			if fieldType == "TIMESTAMP" {
+				writer.i32Field(6, PARQUET_TIMESTAMP_MICROS)
+			}
+			writer.structField(10)
+			writer.structField(8)
+			writer.boolField(1, fieldType == "TIMESTAMP")
+			writer.structField(2)
+			writer.structField(2) // MICROS
+			writer.endStruct()
+			writer.endStruct()
+			writer.endStruct()
+			writer.endStruct()
+		case "NUMERIC", "BIGNUMERIC":
+			writer.i32Field(6, PARQUET_DECIMAL)
+			writer.i32Field(7, 9)
+			writer.i32Field(8, 38)
+		}
+		writer.endStruct()
+
+		if fieldType == "RECORD" {
+			writeParquetSchema(writer, field.Fields)
+		}
+	}
+}
+
+// writeParquetLevels writes levels as length-prefixed RLE runs.
+func writeParquetLevels(out *bytes.Buffer, levels []int, maxLevel int) {
+	width := bitWidth(maxLevel)
+	numBytes := (width + 7) / 8
+	var encoded bytes.Buffer
+	buf := make([]byte, binary.MaxVarintLen64)
+	for i := 0; i < len(levels); {
+		runLength := 1
+		for i+runLength < len(levels) && levels[i+runLength] == levels[i] {
+			runLength += 1
+		}
+		encoded.Write(buf[:binary.PutUvarint(buf, uint64(runLength)<<1)])
+		for j := 0; j < numBytes; j++ {
+			encoded.WriteByte(byte(levels[i] >> uint(8*j)))
+		}
+		i += runLength
+	}
+
+	length := make([]byte, 4)
+	binary.LittleEndian.PutUint32(length, uint32(encoded.Len()))
+	out.Write(length)
+	out.Write(encoded.Bytes())
+}
+
+func writeParquetPlain(out *bytes.Buffer, column *parquetColumn) error {
+	fieldType := data.NormalizeType(column.path[len(column.path)-1].Type)
+	buf := make([]byte, 8)
+
+	if column.physicalType == PARQUET_BOOLEAN {
+		packed := make([]byte, (len(column.values)+7)/8)
+		for i, value := range column.values {
+			if flag, _ := value.(bool); flag {
+				packed[i/8] |= 1 << uint(i%8)
+			}
+		}
+		out.Write(packed)
+		return nil
+	}
+
+	for _, value := range column.values {
+		switch fieldType {
+		case "INTEGER":
+			number, _ := value.(int64)
+			binary.LittleEndian.PutUint64(buf, uint64(number))
+			out.Write(buf[:8])
+		case "FLOAT":
+			number, _ := value.(float64)
+			binary.LittleEndian.PutUint64(buf, math.Float64bits(number))
+			out.Write(buf[:8])
+		case "TIMESTAMP":
+			t, _ := value.(time.Time)
+			binary.LittleEndian.PutUint64(buf, uint64(t.UnixNano()/int64(time.Microsecond)))
+			out.Write(buf[:8])
+		case "DATETIME":
+			datetime, err := data.ParseDatetime(fmt.Sprintf("%v", value))
+			if err != nil {
+				return err
+			}
+			binary.LittleEndian.PutUint64(buf, uint64(datetime.UnixNano()/int64(time.Microsecond)))
+			out.Write(buf[:8])
+		case "TIME":
+			timeOfDay, err := time.Parse("15:04:05.999999", fmt.Sprintf("%v", value))
+			if err != nil {
+				return err
+			}
+			midnight := time.Date(0, 1, 1, 0, 0, 0, 0, time.UTC)
+			binary.LittleEndian.PutUint64(buf, uint64(timeOfDay.Sub(midnight)/time.Microsecond))
+			out.Write(buf[:8])
+		case "DATE":
+			date, err := time.Parse("2006-01-02", fmt.Sprintf("%v", value))
+			if err != nil {
+				return err
+			}
+			binary.LittleEndian.PutUint32(buf, uint32(int32(date.Unix()/86400)))
+			out.Write(buf[:4])
+		default:
+			var bytes []byte
+			switch fieldType {
+			case "BYTES":
+				bytes, _ = value.([]byte)
+			case "NUMERIC", "BIGNUMERIC":
+				number, _ := value.(float64)
+				bytes = encodeDecimal(number, 9)
+			default:
+				bytes = []byte(data.FormatScalar(fieldType, value))
+			}
+			binary.LittleEndian.PutUint32(buf, uint32(len(bytes)))
+			out.Write(buf[:4])
+			out.Write(bytes)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package formats
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestParquetRoundTrip(t *testing.T) {
+	for _, compression := range []string{"NONE", "SNAPPY", "GZIP"} {
+		content, err := WriteParquet(TEST_FIELDS, TEST_ROWS, compression)
+		if err != nil {
+			t.Fatalf("WriteParquet with %s: %v", compression, err)
+		}
+		fields, rows, err := ReadParquet(content)
+		if err != nil {
+			t.Fatalf("ReadParquet with %s: %v", compression, err)
+		}
+		sameFields(t, fields, TEST_FIELDS)
+		sameRows(t, rows, TEST_ROWS)
+	}
+}
+
+func TestParquetNoRows(t *testing.T) {
+	content, err := WriteParquet(TEST_FIELDS, nil, "NONE")
+	if err != nil {
+		t.Fatalf("WriteParquet: %v", err)
+	}
+	fields, rows, err := ReadParquet(content)
+	if err != nil {
+		t.Fatalf("ReadParquet: %v", err)
+	}
+	sameFields(t, fields, TEST_FIELDS)
+	if len(rows) != 0 {
+		t.Errorf("got %d rows, want none", len(rows))
+	}
+}
+
+func TestParquetCorrupt(t *testing.T) {
+	valid, err := WriteParquet(TEST_FIELDS, TEST_ROWS, "SNAPPY")
+	if err != nil {
+		t.Fatalf("WriteParquet: %v", err)
+	}
+	hugeFooter := append([]byte{}, valid...)
+	binary.LittleEndian.PutUint32(hugeFooter[len(hugeFooter)-8:], 1<<31)
+	for name, content := range map[string][]byte{
+		"empty":       {},
+		"not parquet": []byte("Obj\x01 and so on"),
+		"magic only":  []byte("PAR1PAR1"),
+		"huge footer": hugeFooter,
+		"no footer":   append(append([]byte{}, PARQUET_MAGIC...), 0, 0, 0, 0, 'P', 'A', 'R', '1'),
+		"truncated":   append(append([]byte{}, valid[:len(valid)/2]...), valid[len(valid)-8:]...),
+	} {
+		if _, _, err := ReadParquet(content); err == nil {
+			t.Errorf("ReadParquet of %s content succeeded, want an error", name)
+		}
+	}
+	// Flipping any one byte gives wrong data or an error, but never a panic
+	uncompressed, err := WriteParquet(TEST_FIELDS, TEST_ROWS, "NONE")
+	if err != nil {
+		t.Fatalf("WriteParquet: %v", err)
+	}
+	for _, content := range [][]byte{valid, uncompressed} {
+		for i := range content {
+			corrupt := append([]byte{}, content...)
+			corrupt[i] ^= 0xff
+			ReadParquet(corrupt)
+		}
+	}
+}
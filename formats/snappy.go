@@ -0,0 +1,134 @@
+package formats
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// SNAPPY_MAX_RATIO bounds how much Snappy can expand its input: the best
+// it does is a 3-byte copy of 64 bytes.
+const SNAPPY_MAX_RATIO = 22
+
+// SnappyDecode decompresses a raw (unframed) Snappy block, as used by the
+// Avro and Parquet snappy codecs.
+func SnappyDecode(src []byte) ([]byte, error) {
+	length, n := binary.Uvarint(src)
+	if n <= 0 {
+		return nil, fmt.Errorf("snappy: corrupt input")
+	}
+	src = src[n:]
+	// The length comes from the input, so is only trusted as far as the
+	// rest of the input could expand to it
+	if length > uint64(len(src))*SNAPPY_MAX_RATIO {
+		return nil, fmt.Errorf("snappy: corrupt input")
+	}
+	dst := make([]byte, 0, length)
+
+	for len(src) > 0 {
+		tag := src[0]
+		switch tag & 0x03 {
+		case 0x00: // literal
+			literalLength := int(tag >> 2)
+			headerLength := 1
+			if literalLength >= 60 {
+				numBytes := literalLength - 59
+				if len(src) < 1+numBytes {
+					return nil, fmt.Errorf("snappy: corrupt input")
+				}
+				literalLength = 0
+				for i := 0; i < numBytes; i++ {
+					literalLength |= int(src[1+i]) << (8 * uint(i))
+				}
+				headerLength += numBytes
+			}
+			literalLength += 1
+			if len(src) < headerLength+literalLength {
+				return nil, fmt.Errorf("snappy: corrupt input")
+			}
+			if uint64(len(dst)+literalLength) > length {
+				return nil, fmt.Errorf("snappy: corrupt input")
+			}
+			dst = append(dst, src[headerLength:headerLength+literalLength]...)
+			src = src[headerLength+literalLength:]
+			continue
+
+		case 0x01: // copy with 1-byte offset
+			if len(src) < 2 {
+				return nil, fmt.Errorf("snappy: corrupt input")
+			}
+			copyLength := 4 + int(tag>>2)&0x07
+			offset := int(tag&0xe0)<<3 | int(src[1])
+			src = src[2:]
+			if err := snappyCopy(&dst, offset, copyLength); err != nil {
+				return nil, err
+			}
+
+		case 0x02: // copy with 2-byte offset
+			if len(src) < 3 {
+				return nil, fmt.Errorf("snappy: corrupt input")
+			}
+			copyLength := 1 + int(tag>>2)
+			offset := int(binary.LittleEndian.Uint16(src[1:3]))
+			src = src[3:]
+			if err := snappyCopy(&dst, offset, copyLength); err != nil {
+				return nil, err
+			}
+
+		case 0x03: // copy with 4-byte offset
+			if len(src) < 5 {
+				return nil, fmt.Errorf("snappy: corrupt input")
+			}
+			copyLength := 1 + int(tag>>2)
+			offset := int(binary.LittleEndian.Uint32(src[1:5]))
+			src = src[5:]
+			if err := snappyCopy(&dst, offset, copyLength); err != nil {
+				return nil, err
+			}
+		}
+		if uint64(len(dst)) > length {
+			return nil, fmt.Errorf("snappy: corrupt input")
+		}
+	}
+
+	if uint64(len(dst)) != length {
+		return nil, fmt.Errorf("snappy: corrupt input")
+	}
+	return dst, nil
+}
+
+func snappyCopy(dst *[]byte, offset, length int) error {
+	if offset <= 0 || offset > len(*dst) {
+		return fmt.Errorf("snappy: corrupt input")
+	}
+	start := len(*dst) - offset
+	// Byte by byte, since the source may overlap what's being written
+	for i := 0; i < length; i++ {
+		*dst = append(*dst, (*dst)[start+i])
+	}
+	return nil
+}
+
+// SnappyEncode compresses src as a valid Snappy block made only of
+// literals. It doesn't shrink anything, but every reader can decode it.
+func SnappyEncode(src []byte) []byte {
+	dst := make([]byte, binary.MaxVarintLen64)
+	dst = dst[:binary.PutUvarint(dst, uint64(len(src)))]
+
+	for len(src) > 0 {
+		chunk := src
+		if len(chunk) > 65536 {
+			chunk = chunk[:65536]
+		}
+		n := len(chunk) - 1
+		if n < 60 {
+			dst = append(dst, byte(n<<2))
+		} else if n < 1<<8 {
+			dst = append(dst, 60<<2, byte(n))
+		} else {
+			dst = append(dst, 61<<2, byte(n), byte(n>>8))
+		}
+		dst = append(dst, chunk...)
+		src = src[len(chunk):]
+	}
+	return dst
+}
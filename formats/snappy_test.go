@@ -0,0 +1,68 @@
+package formats
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestSnappyRoundTrip(t *testing.T) {
+	for _, src := range [][]byte{
+		{},
+		[]byte("a"),
+		[]byte(strings.Repeat("x", 59)),
+		[]byte(strings.Repeat("y", 60)),
+		[]byte(strings.Repeat("abc", 1000)),
+		bytes.Repeat([]byte{0, 1, 2, 255}, 40000), // more than one literal chunk
+	} {
+		decoded, err := SnappyDecode(SnappyEncode(src))
+		if err != nil {
+			t.Errorf("SnappyDecode of %d bytes: %v", len(src), err)
+		} else if !bytes.Equal(decoded, src) {
+			t.Errorf("SnappyDecode of %d bytes gave %d different bytes", len(src), len(decoded))
+		}
+	}
+}
+
+func TestSnappyDecodeCopies(t *testing.T) {
+	for _, test := range []struct {
+		src  []byte
+		want string
+	}{
+		// "ab", then a 1-byte-offset copy of 4 bytes from 2 back
+		{[]byte{6, 1 << 2, 'a', 'b', 0x01, 2}, "ababab"},
+		// "abc", then a 2-byte-offset copy of 6 bytes from 3 back
+		{[]byte{9, 2 << 2, 'a', 'b', 'c', 5<<2 | 0x02, 3, 0}, "abcabcabc"},
+		// "a", then a 4-byte-offset copy of 2 bytes from 1 back
+		{[]byte{3, 0, 'a', 1<<2 | 0x03, 1, 0, 0, 0}, "aaa"},
+	} {
+		decoded, err := SnappyDecode(test.src)
+		if err != nil {
+			t.Errorf("SnappyDecode(%v): %v", test.src, err)
+		} else if string(decoded) != test.want {
+			t.Errorf("SnappyDecode(%v) = %q, want %q", test.src, decoded, test.want)
+		}
+	}
+}
+
+func TestSnappyDecodeCorrupt(t *testing.T) {
+	for _, src := range [][]byte{
+		{},                                      // no length
+		{0x80},                                  // unterminated length
+		{0xff, 0xff, 0xff, 0xff, 0x0f},          // a huge length for no input
+		{0xff, 0xff, 0xff, 0xff, 0x0f, 0, 'a'},  // a huge length for little input
+		{5, 4 << 2, 'a'},                        // literal longer than the input
+		{2, 0, 'a', 0x01, 2},                    // copy from before the start
+		{2, 0, 'a', 0x01, 0},                    // copy from offset 0
+		{3, 0, 'a'},                             // shorter than its length
+		{1, 1 << 2, 'a', 'b'},                   // longer than its length
+		{2, 0, 'a', 0x02},                       // truncated copy
+		{12, 0, 'a', 0x01, 1, 0x01, 1, 0x01, 1}, // copies past its length
+		{1, 60 << 2},                            // truncated literal length
+		{70, 0, 'a', 63<<2 | 0x02, 1},           // copy with a truncated offset
+	} {
+		if decoded, err := SnappyDecode(src); err == nil {
+			t.Errorf("SnappyDecode(%v) = %q, want an error", src, decoded)
+		}
+	}
+}
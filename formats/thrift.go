@@ -0,0 +1,266 @@
+package formats
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// Parquet stores its metadata with Thrift's compact protocol. Rather than
+// generate code for every Parquet struct, thriftReader decodes any struct
+// into a thriftStruct keyed by field id, and callers pick out the fields
+// they need.
+type thriftStruct map[int16]interface{}
+
+const (
+	THRIFT_STOP    = 0
+	THRIFT_TRUE    = 1
+	THRIFT_FALSE   = 2
+	THRIFT_BYTE    = 3
+	THRIFT_I16     = 4
+	THRIFT_I32     = 5
+	THRIFT_I64     = 6
+	THRIFT_DOUBLE  = 7
+	THRIFT_BINARY  = 8
+	THRIFT_LIST    = 9
+	THRIFT_SET     = 10
+	THRIFT_MAP     = 11
+	THRIFT_STRUCT  = 12
+	THRIFT_MAX_LEN = 1 << 28
+)
+
+type thriftReader struct {
+	buf []byte
+	pos int
+	err error
+}
+
+func (reader *thriftReader) fail(format string, args ...interface{}) {
+	if reader.err == nil {
+		reader.err = fmt.Errorf("thrift: "+format, args...)
+	}
+}
+
+func (reader *thriftReader) readByte() byte {
+	if reader.pos >= len(reader.buf) {
+		reader.fail("unexpected end of data")
+		return 0
+	}
+	b := reader.buf[reader.pos]
+	reader.pos += 1
+	return b
+}
+
+func (reader *thriftReader) readUvarint() uint64 {
+	if reader.err != nil {
+		return 0
+	}
+	value, n := binary.Uvarint(reader.buf[reader.pos:])
+	if n <= 0 {
+		reader.fail("bad varint")
+		return 0
+	}
+	reader.pos += n
+	return value
+}
+
+func (reader *thriftReader) readVarint() int64 {
+	value := reader.readUvarint()
+	return int64(value>>1) ^ -int64(value&1)
+}
+
+func (reader *thriftReader) readStruct() thriftStruct {
+	result := thriftStruct{}
+	var lastFieldId int16
+	for reader.err == nil {
+		header := reader.readByte()
+		fieldType := header & 0x0f
+		if fieldType == THRIFT_STOP {
+			break
+		}
+		if delta := header >> 4; delta != 0 {
+			lastFieldId += int16(delta)
+		} else {
+			lastFieldId = int16(reader.readVarint())
+		}
+		result[lastFieldId] = reader.readValue(fieldType)
+	}
+	return result
+}
+
+func (reader *thriftReader) readValue(fieldType byte) interface{} {
+	switch fieldType {
+	case THRIFT_TRUE:
+		return true
+	case THRIFT_FALSE:
+		return false
+	case THRIFT_BYTE:
+		return int64(int8(reader.readByte()))
+	case THRIFT_I16, THRIFT_I32, THRIFT_I64:
+		return reader.readVarint()
+	case THRIFT_DOUBLE:
+		if reader.pos+8 > len(reader.buf) {
+			reader.fail("unexpected end of data")
+			return 0.0
+		}
+		bits := binary.LittleEndian.Uint64(reader.buf[reader.pos:])
+		reader.pos += 8
+		return math.Float64frombits(bits)
+	case THRIFT_BINARY:
+		length := int(reader.readUvarint())
+		if length < 0 || length > THRIFT_MAX_LEN || reader.pos+length > len(reader.buf) {
+			reader.fail("bad binary length %d", length)
+			return []byte{}
+		}
+		value := reader.buf[reader.pos : reader.pos+length]
+		reader.pos += length
+		return value
+	case THRIFT_LIST, THRIFT_SET:
+		header := reader.readByte()
+		size := int(header >> 4)
+		if size == 15 {
+			size = int(reader.readUvarint())
+		}
+		elementType := header & 0x0f
+		if size < 0 || size > THRIFT_MAX_LEN {
+			reader.fail("bad list size %d", size)
+			return []interface{}{}
+		}
+		list := make([]interface{}, 0, size)
+		for i := 0; i < size && reader.err == nil; i++ {
+			if elementType == THRIFT_TRUE || elementType == THRIFT_FALSE {
+				list = append(list, reader.readByte() == THRIFT_TRUE)
+			} else {
+				list = append(list, reader.readValue(elementType))
+			}
+		}
+		return list
+	case THRIFT_MAP:
+		size := int(reader.readUvarint())
+		if size == 0 {
+			return map[interface{}]interface{}{}
+		}
+		types := reader.readByte()
+		result := map[interface{}]interface{}{}
+		for i := 0; i < size && reader.err == nil; i++ {
+			key := reader.readValue(types >> 4)
+			if keyBytes, ok := key.([]byte); ok {
+				key = string(keyBytes)
+			}
+			result[key] = reader.readValue(types & 0x0f)
+		}
+		return result
+	case THRIFT_STRUCT:
+		return reader.readStruct()
+	default:
+		reader.fail("unknown type %d", fieldType)
+		return nil
+	}
+}
+
+func (s thriftStruct) int(id int16) int64 {
+	value, _ := s[id].(int64)
+	return value
+}
+
+func (s thriftStruct) has(id int16) bool {
+	_, ok := s[id]
+	return ok
+}
+
+func (s thriftStruct) bool(id int16) bool {
+	value, _ := s[id].(bool)
+	return value
+}
+
+func (s thriftStruct) string(id int16) string {
+	value, _ := s[id].([]byte)
+	return string(value)
+}
+
+func (s thriftStruct) strct(id int16) thriftStruct {
+	value, _ := s[id].(thriftStruct)
+	return value
+}
+
+func (s thriftStruct) list(id int16) []interface{} {
+	value, _ := s[id].([]interface{})
+	return value
+}
+
+// thriftWriter encodes structs with the compact protocol. Callers write
+// fields in increasing id order between beginStruct and endStruct.
+type thriftWriter struct {
+	out          bytes.Buffer
+	lastFieldIds []int16
+}
+
+func (writer *thriftWriter) writeUvarint(value uint64) {
+	buf := make([]byte, binary.MaxVarintLen64)
+	writer.out.Write(buf[:binary.PutUvarint(buf, value)])
+}
+
+func (writer *thriftWriter) writeVarint(value int64) {
+	writer.writeUvarint(uint64(value<<1) ^ uint64(value>>63))
+}
+
+func (writer *thriftWriter) fieldHeader(id int16, fieldType byte) {
+	last := &writer.lastFieldIds[len(writer.lastFieldIds)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		writer.out.WriteByte(byte(delta)<<4 | fieldType)
+	} else {
+		writer.out.WriteByte(fieldType)
+		writer.writeVarint(int64(id))
+	}
+	*last = id
+}
+
+func (writer *thriftWriter) beginStruct() {
+	writer.lastFieldIds = append(writer.lastFieldIds, 0)
+}
+
+func (writer *thriftWriter) endStruct() {
+	writer.out.WriteByte(THRIFT_STOP)
+	writer.lastFieldIds = writer.lastFieldIds[:len(writer.lastFieldIds)-1]
+}
+
+func (writer *thriftWriter) structField(id int16) {
+	writer.fieldHeader(id, THRIFT_STRUCT)
+	writer.beginStruct()
+}
+
+func (writer *thriftWriter) boolField(id int16, value bool) {
+	if value {
+		writer.fieldHeader(id, THRIFT_TRUE)
+	} else {
+		writer.fieldHeader(id, THRIFT_FALSE)
+	}
+}
+
+func (writer *thriftWriter) i32Field(id int16, value int64) {
+	writer.fieldHeader(id, THRIFT_I32)
+	writer.writeVarint(value)
+}
+
+func (writer *thriftWriter) i64Field(id int16, value int64) {
+	writer.fieldHeader(id, THRIFT_I64)
+	writer.writeVarint(value)
+}
+
+func (writer *thriftWriter) binaryField(id int16, value []byte) {
+	writer.fieldHeader(id, THRIFT_BINARY)
+	writer.writeUvarint(uint64(len(value)))
+	writer.out.Write(value)
+}
+
+// listField writes a list header; the caller then writes size elements.
+func (writer *thriftWriter) listField(id int16, elementType byte, size int) {
+	writer.fieldHeader(id, THRIFT_LIST)
+	if size < 15 {
+		writer.out.WriteByte(byte(size)<<4 | elementType)
+	} else {
+		writer.out.WriteByte(0xf0 | elementType)
+		writer.writeUvarint(uint64(size))
+	}
+}
@@ -0,0 +1,65 @@
+package formats
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestThriftRoundTrip(t *testing.T) {
+	writer := &thriftWriter{}
+	writer.beginStruct()
+	writer.boolField(1, true)
+	writer.i32Field(2, -5)
+	writer.i64Field(3, 1<<40)
+	writer.binaryField(4, []byte("hello"))
+	writer.structField(20) // too far from 4 for a delta
+	writer.boolField(1, false)
+	writer.endStruct()
+	writer.listField(21, THRIFT_I32, 20) // too long for a short header
+	for i := 0; i < 20; i++ {
+		writer.writeVarint(int64(i))
+	}
+	writer.endStruct()
+
+	reader := &thriftReader{buf: writer.out.Bytes()}
+	s := reader.readStruct()
+	if reader.err != nil {
+		t.Fatalf("readStruct: %v", reader.err)
+	}
+	if !s.bool(1) || s.int(2) != -5 || s.int(3) != 1<<40 || s.string(4) != "hello" {
+		t.Errorf("readStruct gave %v", s)
+	}
+	if inner := s.strct(20); inner == nil || inner.bool(1) || !inner.has(1) {
+		t.Errorf("readStruct gave inner struct %v", inner)
+	}
+	list := s.list(21)
+	want := []interface{}{}
+	for i := 0; i < 20; i++ {
+		want = append(want, int64(i))
+	}
+	if !reflect.DeepEqual(list, want) {
+		t.Errorf("readStruct gave list %v, want %v", list, want)
+	}
+	if reader.pos != len(reader.buf) {
+		t.Errorf("readStruct read %d of %d bytes", reader.pos, len(reader.buf))
+	}
+}
+
+func TestThriftCorrupt(t *testing.T) {
+	for name, content := range map[string][]byte{
+		"empty":            {},
+		"unterminated":     {0x15, 0x02},
+		"huge binary":      {0x18, 0xff, 0xff, 0xff, 0xff, 0x0f},
+		"truncated binary": {0x18, 0x05, 'a'},
+		"huge list":        {0x19, 0xf5, 0xff, 0xff, 0xff, 0xff, 0x0f},
+		"truncated double": {0x17, 0, 0},
+		"unknown type":     {0x1e},
+		"bad varint":       {0x15, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+	} {
+		reader := &thriftReader{buf: content}
+		reader.readStruct()
+		if reader.err == nil {
+			t.Errorf("readStruct of %s content succeeded, want an error", name)
+		}
+	}
+}
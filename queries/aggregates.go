@@ -0,0 +1,657 @@
+package queries
+
+import (
+	"math"
+	"strings"
+
+	"github.com/danielstutzman/fake-bigquery/data"
+)
+
+var AGGREGATES = map[string]bool{
+	"COUNT": true, "COUNTIF": true, "SUM": true, "AVG": true, "MIN": true, "MAX": true,
+	"ARRAY_AGG": true, "ARRAY_CONCAT_AGG": true, "STRING_AGG": true, "ANY_VALUE": true,
+	"LOGICAL_AND": true, "LOGICAL_OR": true, "BIT_AND": true, "BIT_OR": true, "BIT_XOR": true,
+	"STDDEV": true, "STDDEV_SAMP": true, "STDDEV_POP": true,
+	"VARIANCE": true, "VAR_SAMP": true, "VAR_POP": true,
+	"CORR": true, "COVAR_POP": true, "COVAR_SAMP": true, "APPROX_COUNT_DISTINCT": true,
+}
+
+// ANALYTIC_FUNCTIONS can only be called with an OVER clause.
+var ANALYTIC_FUNCTIONS = map[string]bool{
+	"ROW_NUMBER": true, "RANK": true, "DENSE_RANK": true, "PERCENT_RANK": true, "CUME_DIST": true,
+	"NTILE": true, "LAG": true, "LEAD": true, "FIRST_VALUE": true, "LAST_VALUE": true, "NTH_VALUE": true,
+}
+
+// aggregate computes one value from the rows of a group or window frame.
+type aggregate struct {
+	field   data.Field
+	compute func(contexts []*evalContext) (interface{}, error)
+}
+
+func (c *compiler) compileAggregate(e *Call, name string) (expression, error) {
+	if c.clause == "aggregate" {
+		return expression{}, queryError(e.Pos, "Aggregations of aggregations are not allowed")
+	}
+	if c.grouping == nil {
+		return expression{}, queryError(e.Pos, "Aggregate function %s not allowed in %s", name, c.clause)
+	}
+	inner := c.child(c.scope)
+	inner.clause = "aggregate"
+	agg, err := inner.compileAggregateCall(e, name)
+	if err != nil {
+		return expression{}, err
+	}
+	return expression{field: agg.field, eval: func(ctx *evalContext) (interface{}, error) {
+		return agg.compute(ctx.group)
+	}}, nil
+}
+
+// compileAggregateCall compiles the arguments of an aggregate function
+// call, which are evaluated against each of the rows it aggregates.
+func (c *compiler) compileAggregateCall(e *Call, name string) (aggregate, error) {
+	if e.Star && name != "COUNT" {
+		return aggregate{}, queryError(e.Pos, "Argument * can only be used in COUNT(*)")
+	}
+	if (len(e.OrderBy) > 0 || e.Limit != nil) && name != "ARRAY_AGG" && name != "STRING_AGG" && name != "ARRAY_CONCAT_AGG" {
+		return aggregate{}, queryError(e.Pos, "%s does not support ORDER BY or LIMIT in arguments", name)
+	}
+	args, err := c.compileExprs(e.Args)
+	if err != nil {
+		return aggregate{}, err
+	}
+	minArgs, maxArgs := 1, 1
+	switch name {
+	case "COUNT":
+		if e.Star {
+			minArgs, maxArgs = 0, 0
+		}
+	case "STRING_AGG":
+		maxArgs = 2
+	case "CORR", "COVAR_POP", "COVAR_SAMP":
+		minArgs, maxArgs = 2, 2
+	}
+	if len(args) < minArgs || len(args) > maxArgs {
+		return aggregate{}, queryError(e.Pos, "Number of arguments does not match for aggregate function %s", name)
+	}
+	orderings, err := c.compileOrdering(e.OrderBy)
+	if err != nil {
+		return aggregate{}, err
+	}
+	limit := int64(-1)
+	if e.Limit != nil {
+		if limit, err = c.constantInt(e.Limit, "LIMIT"); err != nil {
+			return aggregate{}, err
+		}
+	}
+
+	var field data.Field
+	if len(args) > 0 {
+		field = args[0].field
+	}
+	mismatch := func() (aggregate, error) {
+		return aggregate{}, queryError(e.Pos, "No matching signature for aggregate function %s for argument types: %s",
+			name, strings.Join(fieldTypeNames(args), ", "))
+	}
+	numeric := !isArray(field) && (isNumeric(field.Type) || field.Type == "NULL")
+
+	var result data.Field
+	var reduce func(values [][]interface{}) (interface{}, error)
+	switch name {
+	case "COUNT", "APPROX_COUNT_DISTINCT":
+		result = scalarField("INTEGER")
+		reduce = func(values [][]interface{}) (interface{}, error) {
+			return int64(len(values)), nil
+		}
+	case "COUNTIF":
+		if field.Type != "BOOLEAN" && field.Type != "NULL" || isArray(field) {
+			return mismatch()
+		}
+		result = scalarField("INTEGER")
+		reduce = func(values [][]interface{}) (interface{}, error) {
+			count := int64(0)
+			for _, value := range values {
+				if value[0] == true {
+					count += 1
+				}
+			}
+			return count, nil
+		}
+	case "SUM":
+		if !numeric && field.Type != "INTERVAL" {
+			return mismatch()
+		}
+		result = outputField("", field)
+		reduce = func(values [][]interface{}) (interface{}, error) {
+			if len(values) == 0 {
+				return nil, nil
+			}
+			var sum interface{} = values[0][0]
+			for _, value := range values[1:] {
+				var err error
+				if sum, err = arithmetic("+", result, sum, value[0]); err != nil {
+					return nil, err
+				}
+			}
+			return sum, nil
+		}
+	case "AVG":
+		if !numeric {
+			return mismatch()
+		}
+		result = scalarField("FLOAT")
+		if field.Type == "NUMERIC" || field.Type == "BIGNUMERIC" {
+			result = scalarField(field.Type)
+		}
+		reduce = func(values [][]interface{}) (interface{}, error) {
+			if len(values) == 0 {
+				return nil, nil
+			}
+			sum := 0.0
+			for _, value := range values {
+				sum += toFloat(value[0])
+			}
+			return sum / float64(len(values)), nil
+		}
+	case "MIN", "MAX":
+		if isArray(field) || field.Type == "RECORD" || field.Type == "JSON" || field.Type == "INTERVAL" {
+			return mismatch()
+		}
+		result = outputField("", field)
+		direction := 1
+		if name == "MIN" {
+			direction = -1
+		}
+		reduce = func(values [][]interface{}) (interface{}, error) {
+			var best interface{}
+			for _, value := range values {
+				if isNaN(value[0]) {
+					return value[0], nil
+				}
+				if best == nil || compareValues(value[0], best)*direction > 0 {
+					best = value[0]
+				}
+			}
+			return best, nil
+		}
+	case "ANY_VALUE":
+		result = outputField("", field)
+		reduce = func(values [][]interface{}) (interface{}, error) {
+			if len(values) == 0 {
+				return nil, nil
+			}
+			return values[0][0], nil
+		}
+	case "ARRAY_AGG":
+		if isArray(field) {
+			return aggregate{}, queryError(e.Pos, "Cannot use ARRAY_AGG on a value with type %s because nested arrays are not supported",
+				typeName(field))
+		}
+		result = arrayField(outputField("", field))
+		reduce = func(values [][]interface{}) (interface{}, error) {
+			if len(values) == 0 {
+				return nil, nil
+			}
+			elements := make([]interface{}, len(values))
+			for i, value := range values {
+				if value[0] == nil {
+					return nil, runtimeError("Array cannot have a null element; error in writing field")
+				}
+				elements[i] = value[0]
+			}
+			return elements, nil
+		}
+	case "ARRAY_CONCAT_AGG":
+		if !isArray(field) && field.Type != "NULL" {
+			return mismatch()
+		}
+		result = outputField("", field)
+		reduce = func(values [][]interface{}) (interface{}, error) {
+			if len(values) == 0 {
+				return nil, nil
+			}
+			elements := []interface{}{}
+			for _, value := range values {
+				elements = append(elements, value[0].([]interface{})...)
+			}
+			return elements, nil
+		}
+	case "STRING_AGG":
+		if !matchesParam("STRINGY", field) || (len(args) == 2 && !matchesParam(field.Type, args[1].field)) {
+			return mismatch()
+		}
+		result = outputField("", field)
+		if field.Type == "NULL" {
+			result = scalarField("STRING")
+		}
+		reduce = func(values [][]interface{}) (interface{}, error) {
+			if len(values) == 0 {
+				return nil, nil
+			}
+			delimiter := ","
+			parts := make([]string, len(values))
+			for i, value := range values {
+				parts[i] = toString(value[0])
+				if len(value) == 2 && value[1] != nil {
+					delimiter = toString(value[1])
+				}
+			}
+			return sameKind(values[0][0], strings.Join(parts, delimiter)), nil
+		}
+	case "LOGICAL_AND", "LOGICAL_OR":
+		if field.Type != "BOOLEAN" && field.Type != "NULL" || isArray(field) {
+			return mismatch()
+		}
+		result = scalarField("BOOLEAN")
+		target := name == "LOGICAL_OR"
+		reduce = func(values [][]interface{}) (interface{}, error) {
+			if len(values) == 0 {
+				return nil, nil
+			}
+			for _, value := range values {
+				if value[0] == target {
+					return target, nil
+				}
+			}
+			return !target, nil
+		}
+	case "BIT_AND", "BIT_OR", "BIT_XOR":
+		if field.Type != "INTEGER" && field.Type != "NULL" || isArray(field) {
+			return mismatch()
+		}
+		result = scalarField("INTEGER")
+		reduce = func(values [][]interface{}) (interface{}, error) {
+			if len(values) == 0 {
+				return nil, nil
+			}
+			bits := values[0][0].(int64)
+			for _, value := range values[1:] {
+				switch name {
+				case "BIT_AND":
+					bits &= value[0].(int64)
+				case "BIT_OR":
+					bits |= value[0].(int64)
+				default:
+					bits ^= value[0].(int64)
+				}
+			}
+			return bits, nil
+		}
+	case "STDDEV", "STDDEV_SAMP", "STDDEV_POP", "VARIANCE", "VAR_SAMP", "VAR_POP":
+		if !numeric {
+			return mismatch()
+		}
+		result = scalarField("FLOAT")
+		population := strings.HasSuffix(name, "_POP")
+		root := strings.HasPrefix(name, "STDDEV")
+		reduce = func(values [][]interface{}) (interface{}, error) {
+			variance, ok := covariance(values, 0, 0, population)
+			if !ok {
+				return nil, nil
+			}
+			if root {
+				return math.Sqrt(variance), nil
+			}
+			return variance, nil
+		}
+	case "CORR", "COVAR_POP", "COVAR_SAMP":
+		if !numeric || !matchesParam("NUMBER", args[1].field) {
+			return mismatch()
+		}
+		result = scalarField("FLOAT")
+		reduce = func(values [][]interface{}) (interface{}, error) {
+			covar, ok := covariance(values, 0, 1, name == "COVAR_POP")
+			if !ok {
+				return nil, nil
+			}
+			if name != "CORR" {
+				return covar, nil
+			}
+			varX, _ := covariance(values, 0, 0, false)
+			varY, _ := covariance(values, 1, 1, false)
+			return covar / math.Sqrt(varX*varY), nil
+		}
+	}
+
+	// Most aggregate functions skip NULLs, but ARRAY_AGG keeps them unless
+	// told to IGNORE NULLS, so that it can report them.
+	skipNulls := !e.Star && (name != "ARRAY_AGG" || e.IgnoreNulls)
+	distinct := e.Distinct || name == "APPROX_COUNT_DISTINCT"
+	star := e.Star
+	return aggregate{field: result, compute: func(contexts []*evalContext) (interface{}, error) {
+		if len(orderings) > 0 {
+			contexts = append([]*evalContext{}, contexts...)
+			if _, err := sortContexts(contexts, orderings); err != nil {
+				return nil, err
+			}
+		}
+		values := [][]interface{}{}
+		seen := map[string]bool{}
+		for _, ctx := range contexts {
+			if star {
+				values = append(values, nil)
+				continue
+			}
+			value := make([]interface{}, len(args))
+			for i, arg := range args {
+				var err error
+				if value[i], err = arg.eval(ctx); err != nil {
+					return nil, err
+				}
+			}
+			if skipNulls && (value[0] == nil || (len(args) == 2 && args[1].field.Type != "STRING" &&
+				args[1].field.Type != "BYTES" && value[1] == nil)) {
+				continue
+			}
+			if distinct {
+				key := valueKey(value[0])
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+			}
+			values = append(values, value)
+		}
+		if limit >= 0 && int64(len(values)) > limit {
+			values = values[:limit]
+		}
+		return reduce(values)
+	}}, nil
+}
+
+// covariance computes the covariance of two columns of values, or the
+// variance of one when x and y are the same.
+func covariance(values [][]interface{}, x, y int, population bool) (float64, bool) {
+	n := float64(len(values))
+	if n == 0 || (!population && n < 2) {
+		return 0, false
+	}
+	meanX, meanY := 0.0, 0.0
+	for _, value := range values {
+		meanX += toFloat(value[x]) / n
+		meanY += toFloat(value[y]) / n
+	}
+	sum := 0.0
+	for _, value := range values {
+		sum += (toFloat(value[x]) - meanX) * (toFloat(value[y]) - meanY)
+	}
+	if population {
+		return sum / n, true
+	}
+	return sum / (n - 1), true
+}
+
+// Analytic functions
+
+// window is an analytic function call, computed over the rows of a SELECT
+// after grouping and HAVING. Its value for each row is stored in the
+// row's evalContext.window.
+type window struct {
+	partitionBy []expression
+	orderBy     []ordering
+	// compute gets one sorted partition, with peers[i] numbering the
+	// group of rows that tie with row i under ORDER BY.
+	compute func(partition []*evalContext, peers []int) ([]interface{}, error)
+}
+
+func (c *compiler) compileWindow(e *Call, name string) (expression, error) {
+	if c.windows == nil {
+		return expression{}, queryError(e.Pos, "Analytic function not allowed in %s", c.clause)
+	}
+	inner := *c
+	inner.windows = nil
+	inner.aliases = nil
+
+	w := &window{}
+	for _, partition := range e.Over.PartitionBy {
+		x, err := inner.compileExpr(partition)
+		if err != nil {
+			return expression{}, err
+		}
+		if isArray(x.field) || x.field.Type == "RECORD" || x.field.Type == "FLOAT" {
+			return expression{}, queryError(partition.position(), "Partitioning by expressions of type %s is not allowed",
+				typeName(x.field))
+		}
+		w.partitionBy = append(w.partitionBy, x)
+	}
+	var err error
+	if w.orderBy, err = inner.compileOrdering(e.Over.OrderBy); err != nil {
+		return expression{}, err
+	}
+	ordered := len(w.orderBy) > 0
+
+	var field data.Field
+	if AGGREGATES[name] {
+		agg, err := inner.compileAggregateCall(e, name)
+		if err != nil {
+			return expression{}, err
+		}
+		field = agg.field
+		// Without ORDER BY the frame is the whole partition; with it, the
+		// frame runs up to the last row that ties with the current one.
+		w.compute = func(partition []*evalContext, peers []int) ([]interface{}, error) {
+			values := make([]interface{}, len(partition))
+			for i := 0; i < len(partition); {
+				end := len(partition)
+				if ordered {
+					end = peerEnd(peers, i)
+				}
+				value, err := agg.compute(partition[:end])
+				if err != nil {
+					return nil, err
+				}
+				for ; i < end; i++ {
+					values[i] = value
+				}
+			}
+			return values, nil
+		}
+	} else {
+		if field, w.compute, err = inner.compileAnalytic(e, name, ordered); err != nil {
+			return expression{}, err
+		}
+	}
+
+	*c.windows = append(*c.windows, w)
+	index := len(*c.windows) - 1
+	return expression{field: field, eval: func(ctx *evalContext) (interface{}, error) {
+		return ctx.window[index], nil
+	}}, nil
+}
+
+func peerEnd(peers []int, i int) int {
+	end := i + 1
+	for end < len(peers) && peers[end] == peers[i] {
+		end += 1
+	}
+	return end
+}
+
+func peerStart(peers []int, i int) int {
+	start := i
+	for start > 0 && peers[start-1] == peers[i] {
+		start -= 1
+	}
+	return start
+}
+
+func (c *compiler) compileAnalytic(e *Call, name string, ordered bool) (data.Field,
+	func([]*evalContext, []int) ([]interface{}, error), error) {
+
+	if e.Distinct || e.Star || len(e.OrderBy) > 0 || e.Limit != nil {
+		return data.Field{}, nil, queryError(e.Pos, "Analytic function %s does not support %s", name, callModifier(e))
+	}
+	args, err := c.compileExprs(e.Args)
+	if err != nil {
+		return data.Field{}, nil, err
+	}
+	argCounts := map[string][2]int{
+		"ROW_NUMBER": {0, 0}, "RANK": {0, 0}, "DENSE_RANK": {0, 0}, "PERCENT_RANK": {0, 0}, "CUME_DIST": {0, 0},
+		"NTILE": {1, 1}, "LAG": {1, 3}, "LEAD": {1, 3}, "FIRST_VALUE": {1, 1}, "LAST_VALUE": {1, 1}, "NTH_VALUE": {2, 2},
+	}[name]
+	if len(args) < argCounts[0] || len(args) > argCounts[1] {
+		return data.Field{}, nil, queryError(e.Pos, "Number of arguments does not match for function %s", name)
+	}
+	if !ordered && name != "ROW_NUMBER" && name != "FIRST_VALUE" && name != "LAST_VALUE" && name != "NTH_VALUE" {
+		return data.Field{}, nil, queryError(e.Pos, "Window ORDER BY is required for analytic function %s", name)
+	}
+	for i, arg := range args {
+		if i > 0 && arg.field.Type != "INTEGER" && arg.field.Type != "NULL" && !(name == "LAG" || name == "LEAD") ||
+			(name == "NTILE" && arg.field.Type != "INTEGER") {
+			return data.Field{}, nil, queryError(e.Pos, "No matching signature for analytic function %s for argument types: %s",
+				name, strings.Join(fieldTypeNames(args), ", "))
+		}
+	}
+
+	// argAt evaluates argument i against a row of the partition.
+	argAt := func(partition []*evalContext, i, row int) (interface{}, error) {
+		return args[i].eval(partition[row])
+	}
+
+	switch name {
+	case "ROW_NUMBER", "RANK", "DENSE_RANK":
+		return scalarField("INTEGER"), func(partition []*evalContext, peers []int) ([]interface{}, error) {
+			values := make([]interface{}, len(partition))
+			for i := range partition {
+				switch name {
+				case "ROW_NUMBER":
+					values[i] = int64(i + 1)
+				case "RANK":
+					values[i] = int64(peerStart(peers, i) + 1)
+				default:
+					values[i] = int64(peers[i] + 1)
+				}
+			}
+			return values, nil
+		}, nil
+
+	case "PERCENT_RANK", "CUME_DIST":
+		return scalarField("FLOAT"), func(partition []*evalContext, peers []int) ([]interface{}, error) {
+			values := make([]interface{}, len(partition))
+			n := float64(len(partition))
+			for i := range partition {
+				if name == "CUME_DIST" {
+					values[i] = float64(peerEnd(peers, i)) / n
+				} else if n == 1 {
+					values[i] = 0.0
+				} else {
+					values[i] = float64(peerStart(peers, i)) / (n - 1)
+				}
+			}
+			return values, nil
+		}, nil
+
+	case "NTILE":
+		return scalarField("INTEGER"), func(partition []*evalContext, peers []int) ([]interface{}, error) {
+			value, err := argAt(partition, 0, 0)
+			if value == nil || err != nil {
+				return make([]interface{}, len(partition)), err
+			}
+			buckets := value.(int64)
+			if buckets <= 0 {
+				return nil, runtimeError("The N value (number of buckets) for the NTILE function must be positive")
+			}
+			n := int64(len(partition))
+			size, extra := n/buckets, n%buckets
+			values := make([]interface{}, len(partition))
+			row := int64(0)
+			for bucket := int64(1); bucket <= buckets && row < n; bucket++ {
+				count := size
+				if bucket <= extra {
+					count += 1
+				}
+				for j := int64(0); j < count; j++ {
+					values[row] = bucket
+					row += 1
+				}
+			}
+			return values, nil
+		}, nil
+
+	case "LAG", "LEAD":
+		field := outputField("", args[0].field)
+		if len(args) == 3 && !assignable(args[2].field, field) {
+			return data.Field{}, nil, queryError(e.Pos, "No matching signature for analytic function %s for argument types: %s",
+				name, strings.Join(fieldTypeNames(args), ", "))
+		}
+		if len(args) == 2 && args[1].field.Type != "INTEGER" {
+			return data.Field{}, nil, queryError(e.Pos, "Argument 2 to %s must be an INT64", name)
+		}
+		direction := -1
+		if name == "LEAD" {
+			direction = 1
+		}
+		return field, func(partition []*evalContext, peers []int) ([]interface{}, error) {
+			values := make([]interface{}, len(partition))
+			for i := range partition {
+				offset := int64(1)
+				if len(args) > 1 {
+					value, err := argAt(partition, 1, i)
+					if err != nil {
+						return nil, err
+					}
+					if value == nil {
+						return nil, runtimeError("The offset to %s must not be NULL", name)
+					}
+					if offset = value.(int64); offset < 0 {
+						return nil, runtimeError("The offset to %s must not be negative", name)
+					}
+				}
+				other := int64(i) + offset*int64(direction)
+				var err error
+				if other >= 0 && other < int64(len(partition)) {
+					values[i], err = argAt(partition, 0, int(other))
+				} else if len(args) == 3 {
+					values[i], err = argAt(partition, 2, i)
+					values[i] = coerce(values[i], args[2].field, field)
+				}
+				if err != nil {
+					return nil, err
+				}
+			}
+			return values, nil
+		}, nil
+
+	default: // FIRST_VALUE, LAST_VALUE, NTH_VALUE
+		ignoreNulls := e.IgnoreNulls
+		return outputField("", args[0].field), func(partition []*evalContext, peers []int) ([]interface{}, error) {
+			values := make([]interface{}, len(partition))
+			for i := range partition {
+				end := len(partition)
+				if ordered {
+					end = peerEnd(peers, i)
+				}
+				frame := []interface{}{}
+				for row := 0; row < end; row++ {
+					value, err := argAt(partition, 0, row)
+					if err != nil {
+						return nil, err
+					}
+					if value != nil || !ignoreNulls {
+						frame = append(frame, value)
+					}
+				}
+				if len(frame) == 0 {
+					continue
+				}
+				switch name {
+				case "FIRST_VALUE":
+					values[i] = frame[0]
+				case "LAST_VALUE":
+					values[i] = frame[len(frame)-1]
+				default:
+					value, err := argAt(partition, 1, i)
+					if err != nil {
+						return nil, err
+					}
+					if value == nil || value.(int64) < 1 {
+						return nil, runtimeError("The N value for the NTH_VALUE function must be positive")
+					}
+					if n := value.(int64); n <= int64(len(frame)) {
+						values[i] = frame[n-1]
+					}
+				}
+			}
+			return values, nil
+		}, nil
+	}
+}
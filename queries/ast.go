@@ -0,0 +1,508 @@
+package queries
+
+// Expressions
+
+type Expr interface {
+	position() position
+}
+
+type Literal struct {
+	Pos   position
+	Value interface{} // stored the same way as table values; nil for NULL
+	Type  string      // NULL for an untyped NULL
+}
+
+type Param struct {
+	Pos   position
+	Name  string // "" for a positional ? parameter
+	Index int    // which ? parameter this is, counting from 0
+}
+
+// Path is a dotted name like a, t.a or t.a.b, resolved at compile time to
+// a column, a table alias or a STRUCT field.
+type Path struct {
+	Pos   position
+	Parts []string
+}
+
+type Unary struct {
+	Pos position
+	Op  string // -, NOT, ~
+	X   Expr
+}
+
+type Binary struct {
+	Pos  position
+	Op   string // + - * / || = != < <= > >= AND OR LIKE & | ^ << >>
+	L, R Expr
+}
+
+type IsExpr struct {
+	Pos  position
+	X    Expr
+	Not  bool
+	What string // NULL, TRUE, FALSE
+}
+
+type InExpr struct {
+	Pos    position
+	X      Expr
+	Not    bool
+	List   []Expr
+	Query  *Query
+	Unnest Expr
+}
+
+type Between struct {
+	Pos          position
+	X, Low, High Expr
+	Not          bool
+}
+
+type When struct {
+	Cond, Result Expr
+}
+
+type Case struct {
+	Pos     position
+	Operand Expr // nil for CASE WHEN cond THEN ...
+	Whens   []When
+	Else    Expr
+}
+
+type Cast struct {
+	Pos  position
+	X    Expr
+	Type *TypeSpec
+	Safe bool
+}
+
+type Call struct {
+	Pos         position
+	Name        string // uppercased, e.g. COUNT or NET.HOST
+	Args        []Expr
+	Star        bool // COUNT(*)
+	Distinct    bool
+	IgnoreNulls bool
+	OrderBy     []OrderItem
+	Limit       Expr
+	Over        *Window
+}
+
+// Window is the OVER clause of an analytic function call.
+type Window struct {
+	PartitionBy []Expr
+	OrderBy     []OrderItem
+}
+
+type Subquery struct {
+	Pos   position
+	Kind  string // SCALAR, EXISTS, ARRAY
+	Query *Query
+}
+
+type ArrayLit struct {
+	Pos      position
+	Elems    []Expr
+	ElemType *TypeSpec
+}
+
+type StructLit struct {
+	Pos    position
+	Names  []string // "" where a field has no name
+	Values []Expr
+	Type   *TypeSpec
+}
+
+type Index struct {
+	Pos   position
+	X     Expr
+	Index Expr
+	Mode  string // OFFSET, ORDINAL, SAFE_OFFSET, SAFE_ORDINAL
+}
+
+type FieldAccess struct {
+	Pos  position
+	X    Expr
+	Name string
+}
+
+type Extract struct {
+	Pos  position
+	Part string
+	X    Expr
+}
+
+type Interval struct {
+	Pos   position
+	Value Expr
+	Unit  string
+}
+
+func (e *Literal) position() position     { return e.Pos }
+func (e *Param) position() position       { return e.Pos }
+func (e *Path) position() position        { return e.Pos }
+func (e *Unary) position() position       { return e.Pos }
+func (e *Binary) position() position      { return e.Pos }
+func (e *IsExpr) position() position      { return e.Pos }
+func (e *InExpr) position() position      { return e.Pos }
+func (e *Between) position() position     { return e.Pos }
+func (e *Case) position() position        { return e.Pos }
+func (e *Cast) position() position        { return e.Pos }
+func (e *Call) position() position        { return e.Pos }
+func (e *Subquery) position() position    { return e.Pos }
+func (e *ArrayLit) position() position    { return e.Pos }
+func (e *StructLit) position() position   { return e.Pos }
+func (e *Index) position() position       { return e.Pos }
+func (e *FieldAccess) position() position { return e.Pos }
+func (e *Extract) position() position     { return e.Pos }
+func (e *Interval) position() position    { return e.Pos }
+
+// TypeSpec is a type written in a query, e.g. ARRAY<STRUCT<a INT64>>.
+type TypeSpec struct {
+	Name   string // a data.Field type like INTEGER, or ARRAY
+	Elem   *TypeSpec
+	Fields []TypeField
+}
+
+type TypeField struct {
+	Name string
+	Type *TypeSpec
+}
+
+// Queries
+
+type Query struct {
+	With    []CTE
+	Body    QueryBody // *Select, *SetOperation or *Query
+	OrderBy []OrderItem
+	Limit   Expr
+	Offset  Expr
+}
+
+type CTE struct {
+	Name  string
+	Query *Query
+}
+
+type QueryBody interface{}
+
+type Select struct {
+	Pos      position
+	Distinct bool
+	AsStruct bool
+	Items    []SelectItem
+	From     FromItem // nil for SELECT without FROM
+	Where    Expr
+	GroupBy  []Expr
+	Having   Expr
+	Qualify  Expr
+}
+
+type SelectItem struct {
+	Expr    Expr // nil for * and t.*
+	Alias   string
+	Star    bool
+	Of      Expr // the t in t.*, or nil for *
+	Except  []string
+	Replace []SelectItem
+}
+
+type SetOperation struct {
+	Pos         position
+	Op          string // UNION, INTERSECT, EXCEPT
+	Distinct    bool
+	Left, Right QueryBody
+}
+
+type OrderItem struct {
+	Expr       Expr
+	Desc       bool
+	NullsFirst *bool
+}
+
+type FromItem interface{}
+
+type TableName struct {
+	Pos        position
+	Path       []string
+	Alias      string
+	SystemTime Expr // FOR SYSTEM_TIME AS OF
+}
+
+type SubqueryFrom struct {
+	Pos   position
+	Query *Query
+	Alias string
+}
+
+type UnnestFrom struct {
+	Pos         position
+	X           Expr
+	Alias       string
+	WithOffset  bool
+	OffsetAlias string
+}
+
+// LegacyUnion is legacy SQL's FROM a, b, which appends the rows of its
+// items rather than joining them.
+type LegacyUnion struct {
+	Pos   position
+	Items []FromItem
+}
+
+// TableDateRange is legacy SQL's TABLE_DATE_RANGE(prefix, start, end),
+// the union of the tables named prefix followed by a YYYYMMDD date.
+type TableDateRange struct {
+	Pos        position
+	Prefix     []string
+	Start, End Expr
+	Alias      string
+}
+
+// Flatten is legacy SQL's FLATTEN(source, field), which repeats each row
+// of source once per element of its repeated field.
+type Flatten struct {
+	Pos    position
+	Source FromItem
+	Field  []string
+	Alias  string
+}
+
+type Join struct {
+	Pos         position
+	Kind        string // INNER, LEFT, RIGHT, FULL, CROSS, COMMA
+	Left, Right FromItem
+	On          Expr
+	Using       []string
+}
+
+// Statements
+
+type Statement interface{}
+
+type QueryStatement struct {
+	Query *Query
+}
+
+type InsertStatement struct {
+	Pos     position
+	Target  *TableName
+	Columns []string
+	Values  [][]Expr // nil when inserting the results of Query
+	Query   *Query
+}
+
+type SetClause struct {
+	Pos  position
+	Path []string
+	X    Expr // nil for DEFAULT
+}
+
+type UpdateStatement struct {
+	Pos    position
+	Target *TableName
+	Sets   []SetClause
+	From   FromItem
+	Where  Expr
+}
+
+type DeleteStatement struct {
+	Pos    position
+	Target *TableName
+	Where  Expr
+}
+
+type MergeClause struct {
+	Pos       position
+	Kind      string // MATCHED, NOT_MATCHED_BY_TARGET, NOT_MATCHED_BY_SOURCE
+	Cond      Expr
+	Action    string // UPDATE, DELETE, INSERT
+	Sets      []SetClause
+	Columns   []string
+	Values    []Expr
+	InsertRow bool
+}
+
+type MergeStatement struct {
+	Pos     position
+	Target  *TableName
+	Source  FromItem
+	On      Expr
+	Clauses []MergeClause
+}
+
+// Option is one name = value pair of an OPTIONS(...) list.
+type Option struct {
+	Pos  position
+	Name string
+	X    Expr
+}
+
+type ColumnDef struct {
+	Pos     position
+	Name    string
+	Type    *TypeSpec
+	NotNull bool
+	Options []Option
+}
+
+type CreateTableStatement struct {
+	Pos         position
+	OrReplace   bool
+	Temporary   bool // CREATE TEMP TABLE, in a script
+	IfNotExists bool
+	Target      *TableName
+	Columns     []ColumnDef // nil when the schema comes from Query
+	ClusterBy   []string
+	Options     []Option
+	Query       *Query
+}
+
+type CreateViewStatement struct {
+	Pos          position
+	Materialized bool
+	OrReplace    bool
+	IfNotExists  bool
+	Target       *TableName
+	ClusterBy    []string // materialized views only
+	Options      []Option
+	Query        *Query
+	QueryText    string // the view's definition, as written
+}
+
+type DropTableStatement struct {
+	Pos      position
+	Kind     string // TABLE, SNAPSHOT TABLE, VIEW, MATERIALIZED VIEW
+	IfExists bool
+	Target   *TableName
+}
+
+type AlterAction struct {
+	Pos      position
+	Kind     string // ADD COLUMN, DROP COLUMN, RENAME COLUMN, RENAME TO, SET OPTIONS
+	IfExists bool   // IF EXISTS for DROP and RENAME COLUMN, IF NOT EXISTS for ADD COLUMN
+	Column   ColumnDef
+	Name     string // the column to drop or rename
+	NewName  string
+	Options  []Option
+}
+
+type AlterTableStatement struct {
+	Pos      position
+	Kind     string // TABLE, VIEW, MATERIALIZED VIEW
+	IfExists bool
+	Target   *TableName
+	Actions  []AlterAction
+}
+
+type CreateSchemaStatement struct {
+	Pos         position
+	IfNotExists bool
+	Path        []string
+	Options     []Option
+}
+
+type DropSchemaStatement struct {
+	Pos      position
+	IfExists bool
+	Path     []string
+	Cascade  bool
+}
+
+// CallStatement calls a system procedure like BQ.REFRESH_MATERIALIZED_VIEW.
+type CallStatement struct {
+	Pos       position
+	Procedure []string
+	Args      []Expr
+}
+
+// Scripts
+
+// ScriptStatement is one statement of a script, with where it's written.
+type ScriptStatement struct {
+	Pos       position
+	Text      string
+	Statement Statement
+}
+
+type DeclareStatement struct {
+	Pos     position
+	Names   []string
+	Type    *TypeSpec // nil to take the type of Default
+	Default Expr      // nil for NULL
+}
+
+// SetStatement assigns to a variable, or with SET (a, b) = ..., to each
+// variable from a field of a STRUCT.
+type SetStatement struct {
+	Pos   position
+	Names []string
+	Tuple bool
+	X     Expr
+}
+
+type IfBranch struct {
+	Cond Expr
+	Body []ScriptStatement
+}
+
+type IfStatement struct {
+	Pos      position
+	Branches []IfBranch // IF, then each ELSEIF
+	Else     []ScriptStatement
+}
+
+// LoopStatement is a LOOP, WHILE or REPEAT loop.
+type LoopStatement struct {
+	Pos  position
+	Kind string
+	Cond Expr // for WHILE, whether to go on; for REPEAT, whether to stop
+	Body []ScriptStatement
+}
+
+type ForStatement struct {
+	Pos       position
+	Name      string
+	Query     *Query
+	QueryText string
+	Body      []ScriptStatement
+}
+
+// BlockStatement is BEGIN ... END, with the statements of its EXCEPTION
+// handler to run instead if one of its own fails.
+type BlockStatement struct {
+	Pos     position
+	Body    []ScriptStatement
+	Handler []ScriptStatement // nil without EXCEPTION WHEN ERROR THEN
+}
+
+type RaiseStatement struct {
+	Pos     position
+	Message Expr // nil to raise the error being handled again
+}
+
+type ReturnStatement struct {
+	Pos position
+}
+
+// BreakStatement is BREAK or LEAVE, or with Continue, CONTINUE or ITERATE.
+type BreakStatement struct {
+	Pos      position
+	Continue bool
+}
+
+type ExecuteImmediateStatement struct {
+	Pos        position
+	SQL        Expr
+	Into       []string
+	Using      []Expr
+	UsingNames []string // "" for a positional parameter
+}
+
+// TransactionStatement is BEGIN, COMMIT or ROLLBACK, with or without
+// TRANSACTION after it.
+type TransactionStatement struct {
+	Pos  position
+	Kind string // BEGIN, COMMIT or ROLLBACK
+}
@@ -0,0 +1,308 @@
+package queries
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/danielstutzman/fake-bigquery/data"
+)
+
+// BYTES_BILLED_UNIT is what totalBytesBilled is rounded up to, and
+// MIN_BYTES_BILLED_PER_TABLE the least billed for each table a query reads.
+const BYTES_BILLED_UNIT = 1024 * 1024
+const MIN_BYTES_BILLED_PER_TABLE = 10 * BYTES_BILLED_UNIT
+
+// tableScan is one read of a table by a query, which is billed for the
+// referenced columns of the rows that partition pruning and clustering
+// can't skip.
+type tableScan struct {
+	fields       []data.Field
+	names        []string        // of the values in rows, pseudo-columns included
+	rows         [][]interface{} // the table's rows when the query started
+	partitions   []string        // the partition of each row
+	partitioning map[string]bool // lowercased names of columns that prune partitions
+	clustering   map[string]bool // lowercased names of columns that prune blocks
+	referenced   map[string]bool // lowercased names of the columns the query uses
+	whole        bool            // billed for every column, like DML's target
+	filters      []scanFilter
+
+	wildcardTables []wildcardTable // the tables a wildcard scan reads
+}
+
+// wildcardTable is one of the tables a wildcard like events_* matched.
+type wildcardTable struct {
+	suffix string // its _TABLE_SUFFIX
+	ref    data.TableRef
+}
+
+// scanFilter is a WHERE condition on just a scan's partitioning or
+// clustering columns, which is evaluated against a row of the scope it
+// was compiled in holding the values at indexes.
+type scanFilter struct {
+	condition expression
+	width     int
+	indexes   map[int]int // of scope columns, to the index of their values in the scan's rows
+	partition bool        // prunes whole partitions rather than rows
+}
+
+// touch records a column reference while looking for scanFilters.
+type touch struct {
+	column column
+	depth  int
+	index  int
+}
+
+// newScan starts billing for a read of a relation with columns, whose
+// first values are those of fields; it's nil when not billing.
+func (e *executor) newScan(fields []data.Field, columns []column) *tableScan {
+	if e.scans == nil {
+		return nil
+	}
+	scan := &tableScan{
+		fields:       fields,
+		partitioning: map[string]bool{},
+		clustering:   map[string]bool{},
+		referenced:   map[string]bool{},
+	}
+	for i := range columns {
+		scan.names = append(scan.names, columns[i].name)
+		columns[i].scans = append(columns[i].scans, scan)
+	}
+	*e.scans = append(*e.scans, scan)
+	return scan
+}
+
+// scanTable starts billing for a read of table, whose relation has
+// columns: its fields followed by any pseudo-columns.
+func (e *executor) scanTable(table data.Table, fields []data.Field, columns []column) *tableScan {
+	scan := e.newScan(fields, columns)
+	if scan == nil {
+		return nil
+	}
+	scan.rows = partitionedTableRows(table, fields)
+	for _, row := range table.Rows {
+		scan.partitions = append(scan.partitions, table.PartitionId(row))
+	}
+	for _, name := range table.PartitionColumns() {
+		scan.partitioning[strings.ToLower(name)] = true
+	}
+	if table.Clustering != nil {
+		for _, name := range table.Clustering.Fields {
+			scan.clustering[strings.ToLower(name)] = true
+		}
+	}
+	return scan
+}
+
+// reference bills for the column or columns a name resolved to.
+func (c *compiler) reference(r *resolution) {
+	sc := c.scope
+	for i := 0; i < r.depth; i++ {
+		sc = sc.parent
+	}
+	if r.index != -1 {
+		c.executor.reference(sc.columns[r.index], r.depth, r.index)
+		return
+	}
+	for i := r.rangeVar.start; i < r.rangeVar.end; i++ {
+		c.executor.reference(sc.columns[i], r.depth, i)
+	}
+}
+
+func (e *executor) reference(col column, depth, index int) {
+	if e.deferred != nil {
+		*e.deferred = append(*e.deferred, col)
+	} else {
+		billColumns([]column{col})
+	}
+	if e.touched != nil {
+		*e.touched = append(*e.touched, touch{col, depth, index})
+	}
+}
+
+// compileOutput compiles a SELECT list item, which in a lazy query only
+// bills for the columns it uses if the enclosing query uses it.
+func (c *compiler) compileOutput(e Expr) (expression, []column, error) {
+	if !c.lazy {
+		x, err := c.compileExpr(e)
+		return x, nil, err
+	}
+	deferred := c.executor.deferred
+	deps := []column{}
+	c.executor.deferred = &deps
+	x, err := c.compileExpr(e)
+	c.executor.deferred = deferred
+	return x, deps, err
+}
+
+// billColumns bills for columns and what they're computed from.
+func billColumns(columns []column) {
+	for _, col := range columns {
+		for _, scan := range col.scans {
+			scan.referenced[strings.ToLower(col.name)] = true
+		}
+		billColumns(col.deps)
+	}
+}
+
+func concatColumns(lists [][]column) []column {
+	all := []column{}
+	for _, columns := range lists {
+		all = append(all, columns...)
+	}
+	return all
+}
+
+// addScanFilters finds the conditions ANDed together in a WHERE clause
+// that only use one scan's partitioning or clustering columns.
+func (c *compiler) addScanFilters(where Expr) {
+	if c.executor.scans == nil || where == nil {
+		return
+	}
+	if binary, ok := where.(*Binary); ok && strings.ToUpper(binary.Op) == "AND" {
+		c.addScanFilters(binary.L)
+		c.addScanFilters(binary.R)
+		return
+	}
+	if containsSubquery(reflect.ValueOf(where)) {
+		return
+	}
+
+	touched := []touch{}
+	c.executor.touched = &touched
+	condition, err := c.child(c.scope).compileExpr(where)
+	c.executor.touched = nil
+	if err != nil || len(touched) == 0 || condition.field.Type != "BOOLEAN" {
+		return
+	}
+	scan := touched[0].column.scans
+	if len(scan) != 1 {
+		return
+	}
+	filter := scanFilter{condition: condition, width: len(c.scope.columns), indexes: map[int]int{},
+		partition: true}
+	for _, t := range touched {
+		name := strings.ToLower(t.column.name)
+		if t.depth != 0 || len(t.column.scans) != 1 || t.column.scans[0] != scan[0] {
+			return
+		} else if !scan[0].partitioning[name] && !scan[0].clustering[name] {
+			return
+		}
+		filter.partition = filter.partition && scan[0].partitioning[name]
+		for i, scanned := range scan[0].names {
+			if strings.EqualFold(scanned, t.column.name) {
+				filter.indexes[t.index] = i
+			}
+		}
+	}
+	scan[0].filters = append(scan[0].filters, filter)
+}
+
+func containsSubquery(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		if value.IsNil() {
+			return false
+		}
+		switch value.Interface().(type) {
+		case *Subquery, *Query:
+			return true
+		}
+		return containsSubquery(value.Elem())
+	case reflect.Struct:
+		for i := 0; i < value.NumField(); i++ {
+			if containsSubquery(value.Field(i)) {
+				return true
+			}
+		}
+	case reflect.Slice:
+		for i := 0; i < value.Len(); i++ {
+			if containsSubquery(value.Index(i)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// passes reports whether a filter might hold for a row, which it does
+// unless it's definitely not true.
+func (filter scanFilter) passes(row []interface{}) bool {
+	scoped := make([]interface{}, filter.width)
+	for index, i := range filter.indexes {
+		scoped[index] = row[i]
+	}
+	value, err := filter.condition.eval(&evalContext{row: scoped})
+	return err != nil || value == true
+}
+
+// bytes is how many bytes the scan reads: the referenced columns of the
+// rows in partitions that some row of passes the partition filters, less
+// the rows the clustering filters skip.
+func (scan *tableScan) bytes() int64 {
+	keptPartitions := map[string]bool{}
+	for i, row := range scan.rows {
+		if scan.passes(row, true) {
+			keptPartitions[scan.partitions[i]] = true
+		}
+	}
+	var total int64
+	for i, row := range scan.rows {
+		if !keptPartitions[scan.partitions[i]] || !scan.passes(row, false) {
+			continue
+		}
+		for j, field := range scan.fields {
+			if scan.whole || scan.referenced[strings.ToLower(field.Name)] {
+				total += data.ValueBytes(field, row[j])
+			}
+		}
+	}
+	return total
+}
+
+func (scan *tableScan) passes(row []interface{}, partition bool) bool {
+	for _, filter := range scan.filters {
+		if filter.partition == partition && !filter.passes(row) {
+			return false
+		}
+	}
+	return true
+}
+
+// referenceWildcardTables references the tables wildcard scans read,
+// other than those that filters on _TABLE_SUFFIX pruned.
+func (e *executor) referenceWildcardTables() {
+	if e.scans == nil {
+		return
+	}
+	for _, scan := range *e.scans {
+		for _, table := range scan.wildcardTables {
+			row := make([]interface{}, len(scan.names))
+			row[len(row)-1] = table.suffix
+			if scan.passes(row, true) {
+				e.referenceTable(table.ref)
+			}
+		}
+	}
+}
+
+// bytesBilled gives a statement's totalBytesProcessed and totalBytesBilled,
+// which is rounded up to whole megabytes with a minimum for each table read.
+func (e *executor) bytesBilled() (int64, int64) {
+	var processed int64
+	tables := int64(0)
+	for _, scan := range *e.scans {
+		if bytes := scan.bytes(); bytes > 0 {
+			processed += bytes
+			tables += 1
+		}
+	}
+	if processed == 0 {
+		return 0, 0
+	}
+	billed := (processed + BYTES_BILLED_UNIT - 1) / BYTES_BILLED_UNIT * BYTES_BILLED_UNIT
+	if billed < tables*MIN_BYTES_BILLED_PER_TABLE {
+		billed = tables * MIN_BYTES_BILLED_PER_TABLE
+	}
+	return processed, billed
+}
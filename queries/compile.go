@@ -0,0 +1,1532 @@
+package queries
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/danielstutzman/fake-bigquery/data"
+)
+
+// executor holds what's shared by everything compiled for one statement.
+type executor struct {
+	projects       map[string]data.Project
+	projectName    string
+	now            time.Time        // CURRENT_TIMESTAMP() is the same throughout a statement
+	views          []data.TableRef  // the views being expanded, innermost last
+	params         *parameters      // nil inside views, which can't use them
+	legacy         bool             // compiling legacy SQL
+	defaultDataset *data.TableRef   // nil inside views, whose table names are qualified
+	scans          *[]*tableScan    // the table reads to bill for, or nil when not billing
+	touched        *[]touch         // the columns referenced, while finding pruning filters
+	deferred       *[]column        // the columns a lazy query's output uses, billed if it's used
+	dryRun         bool             // compiling without running anything or changing any table
+	referenced     *[]data.TableRef // the tables and views the statement names, or nil
+	jobs           func() []data.JobInfo
+	createTable    func(data.TableRef) *data.Error
+	script         *script // the script running the statement, or nil
+}
+
+// evalContext is what a compiled expression is evaluated against: one row
+// of its scope, or one group of rows when aggregating, plus the context of
+// the enclosing query for correlated references.
+type evalContext struct {
+	row    []interface{}
+	outer  *evalContext
+	group  []*evalContext // the rows of a group, when aggregating
+	keys   []interface{}  // the GROUP BY values of a group
+	window []interface{}  // the values of analytic function calls
+}
+
+type expression struct {
+	field data.Field
+	eval  func(ctx *evalContext) (interface{}, error)
+}
+
+type column struct {
+	qualifier string // the alias of the FROM item the column comes from
+	name      string
+	field     data.Field
+	hidden    bool         // not expanded by SELECT *
+	merged    bool         // the right side of a JOIN USING, only reachable through its qualifier
+	scans     []*tableScan // the table reads its values come from, which bill for it
+	deps      []column     // for a subquery's output, the columns it's computed from
+}
+
+// rangeVariable is a FROM item alias, which names the columns from start
+// up to end.
+type rangeVariable struct {
+	name       string
+	start, end int
+}
+
+type scope struct {
+	columns []column
+	ranges  []rangeVariable
+	parent  *scope
+}
+
+type compiler struct {
+	executor *executor
+	scope    *scope
+	ctes     map[string]*cte
+	grouping *grouping
+	windows  *[]*window      // nil where analytic functions aren't allowed
+	aliases  map[string]Expr // SELECT list aliases, for HAVING, QUALIFY and ORDER BY
+	clause   string          // for errors about misplaced aggregate functions
+	lazy     bool            // a FROM clause subquery, billed only for the outputs used
+}
+
+// grouping holds the GROUP BY expressions of an aggregating SELECT, which
+// are the only expressions over input columns its SELECT list may use
+// outside of aggregate functions.
+type grouping struct {
+	keys   []string // exprKey of each GROUP BY expression
+	fields []data.Field
+}
+
+// columnRef refers directly to a column of the innermost scope, as
+// produced by expanding SELECT *.
+type columnRef struct {
+	Pos   position
+	Index int
+	Name  string
+}
+
+func (e *columnRef) position() position { return e.Pos }
+
+func (c *compiler) child(sc *scope) *compiler {
+	return &compiler{executor: c.executor, scope: sc, ctes: c.ctes, clause: c.clause}
+}
+
+func queryError(pos position, format string, args ...interface{}) error {
+	return &data.Error{Reason: "invalidQuery", Message: fmt.Sprintf(format, args...) + " at " + pos.String()}
+}
+
+func runtimeError(format string, args ...interface{}) error {
+	return &data.Error{Reason: "invalidQuery", Message: fmt.Sprintf(format, args...)}
+}
+
+func constant(field data.Field, value interface{}) expression {
+	return expression{field: field, eval: func(ctx *evalContext) (interface{}, error) {
+		return value, nil
+	}}
+}
+
+// Name resolution
+
+type resolution struct {
+	depth    int
+	index    int // -1 for a range variable
+	rangeVar rangeVariable
+	fields   []string // STRUCT fields to descend into
+	field    data.Field
+	columns  []column // of the range variable
+}
+
+func (c *compiler) resolve(path []string, pos position) (*resolution, error) {
+	depth := 0
+	for sc := c.scope; sc != nil; sc, depth = sc.parent, depth+1 {
+		if len(path) > 1 {
+			for _, rangeVar := range sc.ranges {
+				if !strings.EqualFold(rangeVar.name, path[0]) {
+					continue
+				}
+				for index := rangeVar.start; index < rangeVar.end; index++ {
+					if strings.EqualFold(sc.columns[index].name, path[1]) {
+						return c.resolveFields(&resolution{depth: depth, index: index,
+							field: sc.columns[index].field}, path[2:], pos)
+					}
+				}
+				if !c.columnExists(sc, path[0]) {
+					return nil, queryError(pos, "Name %s not found inside %s", path[1], rangeVar.name)
+				}
+			}
+		}
+
+		found := -1
+		for index, column := range sc.columns {
+			if column.merged || column.name == "" || !strings.EqualFold(column.name, path[0]) {
+				continue
+			}
+			if found != -1 {
+				return nil, queryError(pos, "Column name %s is ambiguous", path[0])
+			}
+			found = index
+		}
+		if found != -1 {
+			return c.resolveFields(&resolution{depth: depth, index: found,
+				field: sc.columns[found].field}, path[1:], pos)
+		}
+
+		for _, rangeVar := range sc.ranges {
+			if strings.EqualFold(rangeVar.name, path[0]) {
+				columns := sc.columns[rangeVar.start:rangeVar.end]
+				field := scalarField("RECORD")
+				for _, column := range columns {
+					subfield := column.field
+					subfield.Name = column.name
+					field.Fields = append(field.Fields, subfield)
+				}
+				return c.resolveFields(&resolution{depth: depth, index: -1, rangeVar: rangeVar,
+					field: field, columns: columns}, path[1:], pos)
+			}
+		}
+	}
+	return nil, queryError(pos, "Unrecognized name: %s", path[0])
+}
+
+// inScope reports whether a name is that of a column or range variable,
+// which take precedence over script variables.
+func (c *compiler) inScope(name string) bool {
+	for sc := c.scope; sc != nil; sc = sc.parent {
+		if c.columnExists(sc, name) {
+			return true
+		}
+		for _, rangeVar := range sc.ranges {
+			if strings.EqualFold(rangeVar.name, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c *compiler) columnExists(sc *scope, name string) bool {
+	for _, column := range sc.columns {
+		if strings.EqualFold(column.name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *compiler) resolveFields(r *resolution, path []string, pos position) (*resolution, error) {
+	for _, name := range path {
+		field, err := structField(r.field, name, pos)
+		if err != nil {
+			return nil, err
+		}
+		r.fields = append(r.fields, field.Name)
+		r.field = field
+	}
+	return r, nil
+}
+
+func structField(field data.Field, name string, pos position) (data.Field, error) {
+	if field.Type != "RECORD" || isArray(field) {
+		return data.Field{}, queryError(pos, "Cannot access field %s on a value with type %s",
+			name, typeName(field))
+	}
+	for _, subfield := range field.Fields {
+		if strings.EqualFold(subfield.Name, name) {
+			return subfield, nil
+		}
+	}
+	return data.Field{}, queryError(pos, "Field name %s does not exist in %s", name, typeName(field))
+}
+
+func (r *resolution) eval(ctx *evalContext) interface{} {
+	for i := 0; i < r.depth && ctx != nil; i++ {
+		ctx = ctx.outer
+	}
+	if ctx == nil {
+		return nil
+	}
+	var value interface{}
+	if r.index == -1 {
+		record := map[string]interface{}{}
+		for i, column := range r.columns {
+			record[column.name] = ctx.row[r.rangeVar.start+i]
+		}
+		value = record
+	} else {
+		value = ctx.row[r.index]
+	}
+	for _, name := range r.fields {
+		record, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = record[name]
+	}
+	return value
+}
+
+// exprKey renders an expression so that two expressions that refer to the
+// same things get the same key, for matching SELECT list expressions to
+// GROUP BY expressions.
+func (c *compiler) exprKey(e interface{}) string {
+	switch e := e.(type) {
+	case *Path:
+		if r, err := c.resolve(e.Parts, e.Pos); err == nil {
+			return fmt.Sprintf("col(%d,%d,%d,%s)", r.depth, r.index, r.rangeVar.start, strings.Join(r.fields, "."))
+		}
+		return "path(" + strings.ToLower(strings.Join(e.Parts, ".")) + ")"
+	case *columnRef:
+		return fmt.Sprintf("col(0,%d,0,)", e.Index)
+	case position:
+		return ""
+	}
+	value := reflect.ValueOf(e)
+	switch value.Kind() {
+	case reflect.Invalid:
+		return "nil"
+	case reflect.Ptr:
+		if value.IsNil() {
+			return "nil"
+		}
+		return c.exprKey(value.Elem().Interface())
+	case reflect.Struct:
+		parts := []string{}
+		for i := 0; i < value.NumField(); i++ {
+			parts = append(parts, c.exprKey(value.Field(i).Interface()))
+		}
+		return value.Type().Name() + "{" + strings.Join(parts, ",") + "}"
+	case reflect.Slice:
+		if value.Type().Elem().Kind() == reflect.Uint8 {
+			return fmt.Sprintf("%q", value.Interface())
+		}
+		parts := []string{}
+		for i := 0; i < value.Len(); i++ {
+			parts = append(parts, c.exprKey(value.Index(i).Interface()))
+		}
+		return "[" + strings.Join(parts, ",") + "]"
+	case reflect.String:
+		return fmt.Sprintf("%q", strings.ToUpper(value.String()))
+	default:
+		return fmt.Sprintf("%#v", e)
+	}
+}
+
+// Expressions
+
+func (c *compiler) compileExpr(e Expr) (expression, error) {
+	if c.grouping != nil {
+		if _, isLiteral := e.(*Literal); !isLiteral {
+			key := c.exprKey(e)
+			for i, groupKey := range c.grouping.keys {
+				if key == groupKey {
+					index := i
+					return expression{field: c.grouping.fields[i], eval: func(ctx *evalContext) (interface{}, error) {
+						return ctx.keys[index], nil
+					}}, nil
+				}
+			}
+		}
+	}
+
+	switch e := e.(type) {
+	case *Literal:
+		return constant(scalarField(e.Type), e.Value), nil
+	case *Param:
+		return c.compileParam(e)
+	case *Path:
+		return c.compilePath(e)
+	case *columnRef:
+		if c.grouping != nil {
+			return expression{}, queryError(e.Pos,
+				"SELECT list expression references column %s which is neither grouped nor aggregated", e.Name)
+		}
+		index := e.Index
+		c.executor.reference(c.scope.columns[index], 0, index)
+		return expression{field: c.scope.columns[index].field, eval: func(ctx *evalContext) (interface{}, error) {
+			return ctx.row[index], nil
+		}}, nil
+	case *Unary:
+		return c.compileUnary(e)
+	case *Binary:
+		return c.compileBinary(e)
+	case *IsExpr:
+		return c.compileIs(e)
+	case *InExpr:
+		return c.compileIn(e)
+	case *Between:
+		return c.compileBetween(e)
+	case *Case:
+		return c.compileCase(e)
+	case *Cast:
+		return c.compileCast(e)
+	case *Call:
+		return c.compileCall(e)
+	case *Subquery:
+		return c.compileSubquery(e)
+	case *ArrayLit:
+		return c.compileArray(e)
+	case *StructLit:
+		return c.compileStruct(e)
+	case *Index:
+		return c.compileIndex(e)
+	case *FieldAccess:
+		return c.compileFieldAccess(e)
+	case *Extract:
+		return c.compileExtract(e)
+	case *Interval:
+		return c.compileInterval(e)
+	}
+	return expression{}, runtimeError("Unsupported expression %T", e)
+}
+
+func (c *compiler) compileExprs(exprs []Expr) ([]expression, error) {
+	compiled := make([]expression, len(exprs))
+	for i, e := range exprs {
+		var err error
+		if compiled[i], err = c.compileExpr(e); err != nil {
+			return nil, err
+		}
+	}
+	return compiled, nil
+}
+
+// compileCondition compiles an expression that must be a BOOL, like a
+// WHERE clause.
+func (c *compiler) compileCondition(e Expr, clause string) (expression, error) {
+	condition, err := c.compileExpr(e)
+	if err != nil {
+		return expression{}, err
+	}
+	if condition.field.Type != "BOOLEAN" && condition.field.Type != "NULL" || isArray(condition.field) {
+		return expression{}, queryError(e.position(), "%s clause should return type BOOL, but returns %s",
+			clause, typeName(condition.field))
+	}
+	return condition, nil
+}
+
+func (c *compiler) compilePath(e *Path) (expression, error) {
+	if len(e.Parts) == 1 && c.aliases != nil {
+		if alias, ok := c.aliases[strings.ToLower(e.Parts[0])]; ok {
+			withoutAliases := *c
+			withoutAliases.aliases = nil
+			return withoutAliases.compileExpr(alias)
+		}
+	}
+	r, err := c.resolve(e.Parts, e.Pos)
+	if err != nil {
+		if v := c.executor.variable(e.Parts[0]); v != nil && !c.inScope(e.Parts[0]) {
+			return c.compileVariable(v, e)
+		}
+		return expression{}, err
+	}
+	c.reference(r)
+	if c.grouping != nil && r.depth == 0 {
+		return expression{}, queryError(e.Pos,
+			"SELECT list expression references column %s which is neither grouped nor aggregated",
+			strings.Join(e.Parts, "."))
+	}
+	return expression{field: r.field, eval: func(ctx *evalContext) (interface{}, error) {
+		return r.eval(ctx), nil
+	}}, nil
+}
+
+func (c *compiler) compileFieldAccess(e *FieldAccess) (expression, error) {
+	x, err := c.compileExpr(e.X)
+	if err != nil {
+		return expression{}, err
+	}
+	field, err := structField(x.field, e.Name, e.Pos)
+	if err != nil {
+		return expression{}, err
+	}
+	return expression{field: field, eval: func(ctx *evalContext) (interface{}, error) {
+		value, err := x.eval(ctx)
+		if err != nil {
+			return nil, err
+		}
+		record, _ := value.(map[string]interface{})
+		return record[field.Name], nil
+	}}, nil
+}
+
+func (c *compiler) compileUnary(e *Unary) (expression, error) {
+	x, err := c.compileExpr(e.X)
+	if err != nil {
+		return expression{}, err
+	}
+	switch e.Op {
+	case "NOT":
+		if x.field.Type != "BOOLEAN" && x.field.Type != "NULL" {
+			return expression{}, queryError(e.Pos,
+				"No matching signature for operator NOT for argument types: %s", typeName(x.field))
+		}
+		return expression{field: scalarField("BOOLEAN"), eval: func(ctx *evalContext) (interface{}, error) {
+			value, err := x.eval(ctx)
+			if value == nil || err != nil {
+				return nil, err
+			}
+			return !value.(bool), nil
+		}}, nil
+	case "-":
+		if !isNumeric(x.field.Type) && x.field.Type != "NULL" || isArray(x.field) {
+			return expression{}, queryError(e.Pos,
+				"No matching signature for operator - for argument types: %s", typeName(x.field))
+		}
+		return expression{field: x.field, eval: func(ctx *evalContext) (interface{}, error) {
+			value, err := x.eval(ctx)
+			if value == nil || err != nil {
+				return nil, err
+			}
+			if number, ok := value.(int64); ok {
+				if number == math.MinInt64 {
+					return nil, runtimeError("int64 overflow: -%d", number)
+				}
+				return -number, nil
+			}
+			return -value.(float64), nil
+		}}, nil
+	default: // ~
+		if x.field.Type != "INTEGER" && x.field.Type != "NULL" || isArray(x.field) {
+			return expression{}, queryError(e.Pos,
+				"No matching signature for operator ~ for argument types: %s", typeName(x.field))
+		}
+		return expression{field: scalarField("INTEGER"), eval: func(ctx *evalContext) (interface{}, error) {
+			value, err := x.eval(ctx)
+			if value == nil || err != nil {
+				return nil, err
+			}
+			return ^value.(int64), nil
+		}}, nil
+	}
+}
+
+// evalBoth evaluates two operands, returning ok=false if either is NULL.
+func evalBoth(ctx *evalContext, l, r expression) (interface{}, interface{}, bool, error) {
+	lv, err := l.eval(ctx)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	rv, err := r.eval(ctx)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	return lv, rv, lv != nil && rv != nil, nil
+}
+
+func (c *compiler) compileBinary(e *Binary) (expression, error) {
+	l, err := c.compileExpr(e.L)
+	if err != nil {
+		return expression{}, err
+	}
+	r, err := c.compileExpr(e.R)
+	if err != nil {
+		return expression{}, err
+	}
+	noSignature := func() (expression, error) {
+		return expression{}, queryError(e.Pos, "No matching signature for operator %s for argument types: %s, %s",
+			e.Op, typeName(l.field), typeName(r.field))
+	}
+
+	switch e.Op {
+	case "AND", "OR":
+		for _, operand := range []expression{l, r} {
+			if operand.field.Type != "BOOLEAN" && operand.field.Type != "NULL" || isArray(operand.field) {
+				return noSignature()
+			}
+		}
+		isAnd := e.Op == "AND"
+		return expression{field: scalarField("BOOLEAN"), eval: func(ctx *evalContext) (interface{}, error) {
+			lv, err := l.eval(ctx)
+			if err != nil {
+				return nil, err
+			}
+			// FALSE AND x and TRUE OR x don't depend on x
+			if lv != nil && lv.(bool) != isAnd {
+				return lv, nil
+			}
+			rv, err := r.eval(ctx)
+			if err != nil {
+				return nil, err
+			}
+			if rv != nil && rv.(bool) != isAnd {
+				return rv, nil
+			}
+			if lv == nil || rv == nil {
+				return nil, nil
+			}
+			return isAnd, nil
+		}}, nil
+
+	case "=", "!=", "<", "<=", ">", ">=":
+		l, r, err = c.coerceLiterals(e.L, l, e.R, r)
+		if err != nil {
+			return expression{}, err
+		}
+		if !comparable(l.field, r.field, e.Op == "=" || e.Op == "!=") {
+			return noSignature()
+		}
+		op := e.Op
+		return expression{field: scalarField("BOOLEAN"), eval: func(ctx *evalContext) (interface{}, error) {
+			lv, rv, ok, err := evalBoth(ctx, l, r)
+			if !ok || err != nil {
+				return nil, err
+			}
+			return compareWith(op, lv, rv), nil
+		}}, nil
+
+	case "LIKE":
+		if !(l.field.Type == "STRING" || l.field.Type == "BYTES" || l.field.Type == "NULL") ||
+			!(r.field.Type == l.field.Type || r.field.Type == "NULL" || l.field.Type == "NULL") ||
+			isArray(l.field) || isArray(r.field) {
+			return noSignature()
+		}
+		cache := map[string]*regexp.Regexp{}
+		return expression{field: scalarField("BOOLEAN"), eval: func(ctx *evalContext) (interface{}, error) {
+			lv, rv, ok, err := evalBoth(ctx, l, r)
+			if !ok || err != nil {
+				return nil, err
+			}
+			pattern := toString(rv)
+			compiled, ok := cache[pattern]
+			if !ok {
+				if compiled, err = likeRegexp(pattern); err != nil {
+					return nil, err
+				}
+				cache[pattern] = compiled
+			}
+			return compiled.MatchString(toString(lv)), nil
+		}}, nil
+
+	case "||":
+		if l.field.Type == "NULL" {
+			l.field = r.field
+		}
+		if r.field.Type == "NULL" {
+			r.field = l.field
+		}
+		if !sameType(l.field, r.field) || !(isArray(l.field) || l.field.Type == "STRING" || l.field.Type == "BYTES") {
+			return noSignature()
+		}
+		return expression{field: l.field, eval: func(ctx *evalContext) (interface{}, error) {
+			lv, rv, ok, err := evalBoth(ctx, l, r)
+			if !ok || err != nil {
+				return nil, err
+			}
+			switch lv := lv.(type) {
+			case string:
+				return lv + rv.(string), nil
+			case []byte:
+				return append(append([]byte{}, lv...), rv.([]byte)...), nil
+			default:
+				return append(append([]interface{}{}, lv.([]interface{})...), rv.([]interface{})...), nil
+			}
+		}}, nil
+
+	case "&", "|", "^", "<<", ">>":
+		for _, operand := range []expression{l, r} {
+			if operand.field.Type != "INTEGER" && operand.field.Type != "NULL" || isArray(operand.field) {
+				return noSignature()
+			}
+		}
+		op := e.Op
+		return expression{field: scalarField("INTEGER"), eval: func(ctx *evalContext) (interface{}, error) {
+			lv, rv, ok, err := evalBoth(ctx, l, r)
+			if !ok || err != nil {
+				return nil, err
+			}
+			a, b := lv.(int64), rv.(int64)
+			switch op {
+			case "&":
+				return a & b, nil
+			case "|":
+				return a | b, nil
+			case "^":
+				return a ^ b, nil
+			case "<<":
+				if b < 0 {
+					return nil, runtimeError("Bit shift by negative offset")
+				} else if b >= 64 {
+					return int64(0), nil
+				}
+				return a << uint(b), nil
+			default:
+				if b < 0 {
+					return nil, runtimeError("Bit shift by negative offset")
+				} else if b >= 64 {
+					return int64(0), nil
+				}
+				return int64(uint64(a) >> uint(b)), nil
+			}
+		}}, nil
+
+	default: // + - * /
+		field, ok := arithmeticType(e.Op, l.field, r.field)
+		if !ok {
+			return noSignature()
+		}
+		op := e.Op
+		return expression{field: field, eval: func(ctx *evalContext) (interface{}, error) {
+			lv, rv, ok, err := evalBoth(ctx, l, r)
+			if !ok || err != nil {
+				return nil, err
+			}
+			return arithmetic(op, field, lv, rv)
+		}}, nil
+	}
+}
+
+func comparable(a, b data.Field, equality bool) bool {
+	if a.Type == "NULL" || b.Type == "NULL" {
+		return true
+	}
+	if isArray(a) || isArray(b) || a.Type == "JSON" || b.Type == "JSON" {
+		return false
+	}
+	if a.Type == "RECORD" || b.Type == "RECORD" {
+		if !equality || a.Type != b.Type || len(a.Fields) != len(b.Fields) {
+			return false
+		}
+		for i := range a.Fields {
+			if !comparable(a.Fields[i], b.Fields[i], true) {
+				return false
+			}
+		}
+		return true
+	}
+	_, ok := supertype(a, b)
+	return ok && a.Type != "GEOGRAPHY"
+}
+
+func compareWith(op string, lv, rv interface{}) bool {
+	if isNaN(lv) || isNaN(rv) {
+		return op == "!="
+	}
+	if dateTime, ok := coerceDateToDatetime(lv, rv); ok {
+		lv, rv = dateTime[0], dateTime[1]
+	}
+	comparison := compareValues(lv, rv)
+	switch op {
+	case "=":
+		return comparison == 0
+	case "!=":
+		return comparison != 0
+	case "<":
+		return comparison < 0
+	case "<=":
+		return comparison <= 0
+	case ">":
+		return comparison > 0
+	default:
+		return comparison >= 0
+	}
+}
+
+func isNaN(value interface{}) bool {
+	number, ok := value.(float64)
+	return ok && math.IsNaN(number)
+}
+
+// coerceDateToDatetime makes a DATE comparable with a DATETIME, which are
+// both stored as strings but in different formats.
+func coerceDateToDatetime(lv, rv interface{}) ([2]interface{}, bool) {
+	ls, lok := lv.(string)
+	rs, rok := rv.(string)
+	if !lok || !rok {
+		return [2]interface{}{}, false
+	}
+	if len(ls) == 10 && len(rs) > 10 && rs[10] == 'T' {
+		return [2]interface{}{ls + "T00:00:00", rs}, true
+	}
+	if len(rs) == 10 && len(ls) > 10 && ls[10] == 'T' {
+		return [2]interface{}{ls, rs + "T00:00:00"}, true
+	}
+	return [2]interface{}{}, false
+}
+
+// coerceLiterals converts a string literal compared with a DATE, TIME,
+// DATETIME or TIMESTAMP to that type, as in WHERE date_column = '2020-01-01'.
+func (c *compiler) coerceLiterals(le Expr, l expression, re Expr, r expression) (expression, expression, error) {
+	var err error
+	if l, err = coerceLiteral(le, l, r.field); err != nil {
+		return l, r, err
+	}
+	if r, err = coerceLiteral(re, r, l.field); err != nil {
+		return l, r, err
+	}
+	return l, r, nil
+}
+
+func coerceLiteral(e Expr, x expression, to data.Field) (expression, error) {
+	// An empty array literal can be an array of anything
+	if array, ok := e.(*ArrayLit); ok && len(array.Elems) == 0 && array.ElemType == nil && isArray(to) {
+		return constant(to, []interface{}{}), nil
+	}
+	literal, ok := e.(*Literal)
+	if !ok || literal.Type != "STRING" || isArray(to) {
+		return x, nil
+	}
+	switch to.Type {
+	case "DATE", "TIME", "DATETIME", "TIMESTAMP":
+		value, err := castValue(literal.Value, x.field, to)
+		if err != nil {
+			return x, queryError(literal.Pos, "Could not cast literal %q to type %s", literal.Value, typeName(to))
+		}
+		return constant(scalarField(to.Type), value), nil
+	}
+	return x, nil
+}
+
+func arithmeticType(op string, a, b data.Field) (data.Field, bool) {
+	if isArray(a) || isArray(b) {
+		return data.Field{}, false
+	}
+	if a.Type == "NULL" && b.Type == "NULL" {
+		return scalarField("INTEGER"), true
+	}
+	if op == "+" && a.Type == "INTERVAL" {
+		a, b = b, a
+	}
+	if b.Type == "INTERVAL" && (op == "+" || op == "-") {
+		switch a.Type {
+		case "DATE", "DATETIME":
+			return scalarField("DATETIME"), true
+		case "TIMESTAMP":
+			return scalarField("TIMESTAMP"), true
+		}
+		return data.Field{}, false
+	}
+	if op == "+" && a.Type == "INTEGER" && b.Type == "DATE" {
+		a, b = b, a
+	}
+	if a.Type == "DATE" && (b.Type == "INTEGER" || b.Type == "NULL") && (op == "+" || op == "-") {
+		return scalarField("DATE"), true
+	}
+	if a.Type == "NULL" {
+		a = b
+	}
+	if b.Type == "NULL" {
+		b = a
+	}
+	if !isNumeric(a.Type) || !isNumeric(b.Type) {
+		return data.Field{}, false
+	}
+	field, _ := supertype(a, b)
+	field = scalarField(field.Type)
+	if op == "/" && field.Type == "INTEGER" {
+		field.Type = "FLOAT"
+	}
+	return field, true
+}
+
+func arithmetic(op string, field data.Field, lv, rv interface{}) (interface{}, error) {
+	if iv, ok := rv.(interval); ok {
+		if op == "-" {
+			iv = iv.negate()
+		}
+		return addInterval(lv, iv)
+	}
+	if iv, ok := lv.(interval); ok {
+		return addInterval(rv, iv)
+	}
+	if date, ok := lv.(string); ok {
+		days := rv.(int64)
+		if op == "-" {
+			days = -days
+		}
+		parsed, _ := time.Parse("2006-01-02", date)
+		return parsed.AddDate(0, 0, int(days)).Format("2006-01-02"), nil
+	}
+	if date, ok := rv.(string); ok {
+		parsed, _ := time.Parse("2006-01-02", date)
+		return parsed.AddDate(0, 0, int(lv.(int64))).Format("2006-01-02"), nil
+	}
+
+	if field.Type == "INTEGER" {
+		a, b := lv.(int64), rv.(int64)
+		var result int64
+		overflow := false
+		switch op {
+		case "+":
+			result = a + b
+			overflow = (b > 0 && result < a) || (b < 0 && result > a)
+		case "-":
+			result = a - b
+			overflow = (b < 0 && result < a) || (b > 0 && result > a)
+		case "*":
+			result = a * b
+			overflow = a != 0 && (result/a != b || (a == -1 && b == math.MinInt64))
+		}
+		if overflow {
+			return nil, runtimeError("int64 overflow: %d %s %d", a, op, b)
+		}
+		return result, nil
+	}
+
+	a, b := toFloat(lv), toFloat(rv)
+	switch op {
+	case "+":
+		return a + b, nil
+	case "-":
+		return a - b, nil
+	case "*":
+		return a * b, nil
+	default:
+		if b == 0 {
+			return nil, runtimeError("division by zero: %s / %s",
+				formatValue(scalarField(field.Type), lv), formatValue(scalarField(field.Type), rv))
+		}
+		return a / b, nil
+	}
+}
+
+func toFloat(value interface{}) float64 {
+	switch value := value.(type) {
+	case int64:
+		return float64(value)
+	case float64:
+		return value
+	}
+	return 0
+}
+
+func toString(value interface{}) string {
+	switch value := value.(type) {
+	case string:
+		return value
+	case []byte:
+		return string(value)
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+// likeRegexp converts a LIKE pattern, where % matches any characters, _
+// matches one and \ escapes, into a regular expression.
+func likeRegexp(pattern string) (*regexp.Regexp, error) {
+	var expression strings.Builder
+	expression.WriteString("(?s)^")
+	runes := []rune(pattern)
+	for i := 0; i < len(runes); i++ {
+		switch runes[i] {
+		case '%':
+			expression.WriteString(".*")
+		case '_':
+			expression.WriteString(".")
+		case '\\':
+			if i+1 == len(runes) {
+				return nil, runtimeError("LIKE pattern ends with a backslash")
+			}
+			i += 1
+			expression.WriteString(regexp.QuoteMeta(string(runes[i])))
+		default:
+			expression.WriteString(regexp.QuoteMeta(string(runes[i])))
+		}
+	}
+	expression.WriteString("$")
+	return regexp.Compile(expression.String())
+}
+
+func (c *compiler) compileIs(e *IsExpr) (expression, error) {
+	x, err := c.compileExpr(e.X)
+	if err != nil {
+		return expression{}, err
+	}
+	if e.What != "NULL" && (x.field.Type != "BOOLEAN" && x.field.Type != "NULL" || isArray(x.field)) {
+		return expression{}, queryError(e.Pos, "No matching signature for operator IS %s for argument types: %s",
+			e.What, typeName(x.field))
+	}
+	what, not := e.What, e.Not
+	return expression{field: scalarField("BOOLEAN"), eval: func(ctx *evalContext) (interface{}, error) {
+		value, err := x.eval(ctx)
+		if err != nil {
+			return nil, err
+		}
+		var result bool
+		switch what {
+		case "NULL":
+			result = value == nil
+		case "TRUE":
+			result = value == true
+		default:
+			result = value == false
+		}
+		return result != not, nil
+	}}, nil
+}
+
+func (c *compiler) compileIn(e *InExpr) (expression, error) {
+	x, err := c.compileExpr(e.X)
+	if err != nil {
+		return expression{}, err
+	}
+	var candidates func(ctx *evalContext) ([]interface{}, error)
+
+	switch {
+	case e.Query != nil:
+		query, err := c.child(c.scope).compileQuery(e.Query)
+		if err != nil {
+			return expression{}, err
+		}
+		if len(query.fields) != 1 {
+			return expression{}, queryError(e.Pos, "Subquery of type IN must have only one output column")
+		}
+		if !comparable(x.field, query.fields[0], true) {
+			return expression{}, queryError(e.Pos, "Cannot execute IN subquery with uncomparable types %s and %s",
+				typeName(x.field), typeName(query.fields[0]))
+		}
+		candidates = func(ctx *evalContext) ([]interface{}, error) {
+			rows, err := query.run(ctx)
+			if err != nil {
+				return nil, err
+			}
+			values := make([]interface{}, len(rows))
+			for i, row := range rows {
+				values[i] = row[0]
+			}
+			return values, nil
+		}
+
+	case e.Unnest != nil:
+		array, err := c.compileExpr(e.Unnest)
+		if err != nil {
+			return expression{}, err
+		}
+		if !isArray(array.field) && array.field.Type != "NULL" {
+			return expression{}, queryError(e.Unnest.position(), "Values referenced in UNNEST must be arrays. UNNEST contains expression of type %s",
+				typeName(array.field))
+		}
+		if !comparable(x.field, elementField(array.field), true) {
+			return expression{}, queryError(e.Pos, "No matching signature for operator IN UNNEST for argument types: %s, %s",
+				typeName(x.field), typeName(array.field))
+		}
+		candidates = func(ctx *evalContext) ([]interface{}, error) {
+			value, err := array.eval(ctx)
+			elements, _ := value.([]interface{})
+			return elements, err
+		}
+
+	default:
+		list := make([]expression, len(e.List))
+		for i, item := range e.List {
+			if list[i], err = c.compileExpr(item); err != nil {
+				return expression{}, err
+			}
+			if _, list[i], err = c.coerceLiterals(e.X, x, item, list[i]); err != nil {
+				return expression{}, err
+			}
+			if !comparable(x.field, list[i].field, true) {
+				return expression{}, queryError(e.Pos, "No matching signature for operator IN for argument types: %s, %s",
+					typeName(x.field), typeName(list[i].field))
+			}
+		}
+		candidates = func(ctx *evalContext) ([]interface{}, error) {
+			values := make([]interface{}, len(list))
+			for i, item := range list {
+				var err error
+				if values[i], err = item.eval(ctx); err != nil {
+					return nil, err
+				}
+			}
+			return values, nil
+		}
+	}
+
+	not := e.Not
+	return expression{field: scalarField("BOOLEAN"), eval: func(ctx *evalContext) (interface{}, error) {
+		value, err := x.eval(ctx)
+		if err != nil {
+			return nil, err
+		}
+		values, err := candidates(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if len(values) == 0 {
+			return not, nil
+		}
+		if value == nil {
+			return nil, nil
+		}
+		sawNull := false
+		for _, candidate := range values {
+			if candidate == nil {
+				sawNull = true
+			} else if compareWith("=", value, candidate) {
+				return !not, nil
+			}
+		}
+		if sawNull {
+			return nil, nil
+		}
+		return not, nil
+	}}, nil
+}
+
+func (c *compiler) compileBetween(e *Between) (expression, error) {
+	x, err := c.compileExpr(e.X)
+	if err != nil {
+		return expression{}, err
+	}
+	low, err := c.compileExpr(e.Low)
+	if err != nil {
+		return expression{}, err
+	}
+	high, err := c.compileExpr(e.High)
+	if err != nil {
+		return expression{}, err
+	}
+	if _, low, err = c.coerceLiterals(e.X, x, e.Low, low); err != nil {
+		return expression{}, err
+	}
+	if _, high, err = c.coerceLiterals(e.X, x, e.High, high); err != nil {
+		return expression{}, err
+	}
+	if !comparable(x.field, low.field, false) || !comparable(x.field, high.field, false) {
+		return expression{}, queryError(e.Pos, "No matching signature for operator BETWEEN for argument types: %s, %s, %s",
+			typeName(x.field), typeName(low.field), typeName(high.field))
+	}
+	not := e.Not
+	return expression{field: scalarField("BOOLEAN"), eval: func(ctx *evalContext) (interface{}, error) {
+		value, lowValue, ok, err := evalBoth(ctx, x, low)
+		if err != nil {
+			return nil, err
+		}
+		var aboveLow interface{}
+		if ok {
+			aboveLow = compareWith(">=", value, lowValue)
+		}
+		value, highValue, ok, err := evalBoth(ctx, x, high)
+		if err != nil {
+			return nil, err
+		}
+		var belowHigh interface{}
+		if ok {
+			belowHigh = compareWith("<=", value, highValue)
+		}
+		var result interface{}
+		switch {
+		case aboveLow == false || belowHigh == false:
+			result = false
+		case aboveLow == nil || belowHigh == nil:
+			return nil, nil
+		default:
+			result = true
+		}
+		return result != not, nil
+	}}, nil
+}
+
+// commonType finds the supertype of several expressions, like the results
+// of a CASE, and returns them coerced to it.
+func commonType(pos position, what string, exprs []Expr, compiled []expression) (data.Field, []expression, error) {
+	field := scalarField("NULL")
+	for i, x := range compiled {
+		super, ok := supertype(field, x.field)
+		if !ok {
+			// A string literal can become a DATE, TIMESTAMP, etc.
+			if coerced, err := coerceLiteral(exprs[i], x, field); err == nil && coerced.field.Type == field.Type {
+				compiled[i] = coerced
+				continue
+			}
+			types := []string{}
+			for _, other := range compiled {
+				types = append(types, typeName(other.field))
+			}
+			return field, nil, queryError(pos, "No matching signature for %s for argument types: %s",
+				what, strings.Join(types, ", "))
+		}
+		field = super
+	}
+	for i := range compiled {
+		compiled[i] = coerceTo(compiled[i], field)
+	}
+	return field, compiled, nil
+}
+
+func coerceTo(x expression, to data.Field) expression {
+	if (sameType(x.field, to) && sameNames(x.field, to)) || x.field.Type == "NULL" {
+		return expression{field: to, eval: x.eval}
+	}
+	from := x.field
+	return expression{field: to, eval: func(ctx *evalContext) (interface{}, error) {
+		value, err := x.eval(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return coerce(value, from, to), nil
+	}}
+}
+
+func (c *compiler) compileCase(e *Case) (expression, error) {
+	var operand expression
+	var err error
+	if e.Operand != nil {
+		if operand, err = c.compileExpr(e.Operand); err != nil {
+			return expression{}, err
+		}
+	}
+	conditions := make([]expression, len(e.Whens))
+	results := make([]expression, 0, len(e.Whens)+1)
+	resultExprs := []Expr{}
+	for i, when := range e.Whens {
+		if e.Operand != nil {
+			condition, err := c.compileExpr(when.Cond)
+			if err != nil {
+				return expression{}, err
+			}
+			if _, condition, err = c.coerceLiterals(e.Operand, operand, when.Cond, condition); err != nil {
+				return expression{}, err
+			}
+			if !comparable(operand.field, condition.field, true) {
+				return expression{}, queryError(when.Cond.position(), "No matching signature for operator CASE for argument types: %s, %s",
+					typeName(operand.field), typeName(condition.field))
+			}
+			conditions[i] = condition
+		} else if conditions[i], err = c.compileCondition(when.Cond, "WHEN"); err != nil {
+			return expression{}, err
+		}
+		result, err := c.compileExpr(when.Result)
+		if err != nil {
+			return expression{}, err
+		}
+		results = append(results, result)
+		resultExprs = append(resultExprs, when.Result)
+	}
+	if e.Else != nil {
+		result, err := c.compileExpr(e.Else)
+		if err != nil {
+			return expression{}, err
+		}
+		results = append(results, result)
+		resultExprs = append(resultExprs, e.Else)
+	}
+	field, results, err := commonType(e.Pos, "operator CASE", resultExprs, results)
+	if err != nil {
+		return expression{}, err
+	}
+
+	hasOperand, hasElse := e.Operand != nil, e.Else != nil
+	return expression{field: field, eval: func(ctx *evalContext) (interface{}, error) {
+		var operandValue interface{}
+		if hasOperand {
+			var err error
+			if operandValue, err = operand.eval(ctx); err != nil {
+				return nil, err
+			}
+		}
+		for i, condition := range conditions {
+			value, err := condition.eval(ctx)
+			if err != nil {
+				return nil, err
+			}
+			matched := false
+			if hasOperand {
+				matched = operandValue != nil && value != nil && compareWith("=", operandValue, value)
+			} else {
+				matched = value == true
+			}
+			if matched {
+				return results[i].eval(ctx)
+			}
+		}
+		if hasElse {
+			return results[len(results)-1].eval(ctx)
+		}
+		return nil, nil
+	}}, nil
+}
+
+func (c *compiler) compileCast(e *Cast) (expression, error) {
+	x, err := c.compileExpr(e.X)
+	if err != nil {
+		return expression{}, err
+	}
+	to := fieldFromTypeSpec(e.Type)
+	if !canCast(x.field, to) {
+		return expression{}, queryError(e.Pos, "Invalid cast from %s to %s", typeName(x.field), typeName(to))
+	}
+	safe := e.Safe
+	from := x.field
+	return expression{field: to, eval: func(ctx *evalContext) (interface{}, error) {
+		value, err := x.eval(ctx)
+		if value == nil || err != nil {
+			return nil, err
+		}
+		converted, err := castValue(value, from, to)
+		if err != nil {
+			if safe {
+				return nil, nil
+			}
+			return nil, runtimeError("%s", err)
+		}
+		return converted, nil
+	}}, nil
+}
+
+func (c *compiler) compileSubquery(e *Subquery) (expression, error) {
+	query, err := c.child(c.scope).compileQuery(e.Query)
+	if err != nil {
+		return expression{}, err
+	}
+	switch e.Kind {
+	case "EXISTS":
+		return expression{field: scalarField("BOOLEAN"), eval: func(ctx *evalContext) (interface{}, error) {
+			rows, err := query.run(ctx)
+			return len(rows) > 0, err
+		}}, nil
+
+	case "ARRAY":
+		if len(query.fields) != 1 {
+			return expression{}, queryError(e.Pos,
+				"ARRAY subquery cannot have more than one column unless using SELECT AS STRUCT to build STRUCT values")
+		}
+		if isArray(query.fields[0]) {
+			return expression{}, queryError(e.Pos, "Cannot use array subquery with column of type %s because nested arrays are not supported",
+				typeName(query.fields[0]))
+		}
+		return expression{field: arrayField(query.fields[0]), eval: func(ctx *evalContext) (interface{}, error) {
+			rows, err := query.run(ctx)
+			if err != nil {
+				return nil, err
+			}
+			elements := make([]interface{}, len(rows))
+			for i, row := range rows {
+				elements[i] = row[0]
+			}
+			return elements, nil
+		}}, nil
+
+	default:
+		if len(query.fields) != 1 {
+			return expression{}, queryError(e.Pos,
+				"Scalar subquery cannot have more than one column unless using SELECT AS STRUCT to build STRUCT values")
+		}
+		return expression{field: query.fields[0], eval: func(ctx *evalContext) (interface{}, error) {
+			rows, err := query.run(ctx)
+			if err != nil {
+				return nil, err
+			}
+			if len(rows) > 1 {
+				return nil, runtimeError("Scalar subquery produced more than one element")
+			} else if len(rows) == 0 {
+				return nil, nil
+			}
+			return rows[0][0], nil
+		}}, nil
+	}
+}
+
+func (c *compiler) compileArray(e *ArrayLit) (expression, error) {
+	elements, err := c.compileExprs(e.Elems)
+	if err != nil {
+		return expression{}, err
+	}
+	var field data.Field
+	if e.ElemType != nil {
+		field = fieldFromTypeSpec(e.ElemType)
+		for i, element := range elements {
+			if elements[i], err = coerceLiteral(e.Elems[i], element, field); err != nil {
+				return expression{}, err
+			}
+			if _, ok := supertype(field, elements[i].field); !ok || !assignable(elements[i].field, field) {
+				return expression{}, queryError(e.Elems[i].position(), "Array element type %s does not coerce to %s",
+					typeName(elements[i].field), typeName(field))
+			}
+			elements[i] = coerceTo(elements[i], field)
+		}
+	} else {
+		if field, elements, err = commonType(e.Pos, "array literal", e.Elems, elements); err != nil {
+			return expression{}, queryError(e.Pos, "Array elements of types {%s} do not have a common supertype",
+				strings.Join(fieldTypeNames(elements), ", "))
+		}
+		if field.Type == "NULL" {
+			field = scalarField("INTEGER")
+		}
+	}
+	if isArray(field) {
+		return expression{}, queryError(e.Pos, "Cannot construct array with element type %s because nested arrays are not supported",
+			typeName(field))
+	}
+	return expression{field: arrayField(field), eval: func(ctx *evalContext) (interface{}, error) {
+		values := make([]interface{}, len(elements))
+		for i, element := range elements {
+			var err error
+			if values[i], err = element.eval(ctx); err != nil {
+				return nil, err
+			}
+		}
+		return values, nil
+	}}, nil
+}
+
+func fieldTypeNames(exprs []expression) []string {
+	names := []string{}
+	for _, x := range exprs {
+		names = append(names, typeName(x.field))
+	}
+	return names
+}
+
+func (c *compiler) compileStruct(e *StructLit) (expression, error) {
+	values, err := c.compileExprs(e.Values)
+	if err != nil {
+		return expression{}, err
+	}
+	field := scalarField("RECORD")
+	if e.Type != nil {
+		field = fieldFromTypeSpec(e.Type)
+		if len(field.Fields) != len(values) {
+			return expression{}, queryError(e.Pos, "STRUCT type has %d fields but constructor call has %d fields",
+				len(field.Fields), len(values))
+		}
+		for i, value := range values {
+			if values[i], err = coerceLiteral(e.Values[i], value, field.Fields[i]); err != nil {
+				return expression{}, err
+			}
+			if !assignable(values[i].field, field.Fields[i]) {
+				return expression{}, queryError(e.Values[i].position(), "STRUCT field %d type %s does not coerce to %s",
+					i+1, typeName(values[i].field), typeName(field.Fields[i]))
+			}
+			values[i] = coerceTo(values[i], field.Fields[i])
+		}
+	} else {
+		for i, value := range values {
+			subfield := value.field
+			subfield.Name = e.Names[i]
+			// STRUCT(x) is STRUCT(x AS x)
+			if path, ok := e.Values[i].(*Path); ok && subfield.Name == "" {
+				subfield.Name = path.Parts[len(path.Parts)-1]
+			}
+			if subfield.Name == "" {
+				subfield.Name = fmt.Sprintf("_field_%d", i+1)
+			}
+			if subfield.Type == "NULL" {
+				subfield.Type = "INTEGER"
+			}
+			field.Fields = append(field.Fields, subfield)
+		}
+	}
+	return expression{field: field, eval: func(ctx *evalContext) (interface{}, error) {
+		record := map[string]interface{}{}
+		for i, value := range values {
+			var err error
+			if record[field.Fields[i].Name], err = value.eval(ctx); err != nil {
+				return nil, err
+			}
+		}
+		return record, nil
+	}}, nil
+}
+
+// assignable reports whether a value of type from can be stored in a
+// column of type to.
+func assignable(from, to data.Field) bool {
+	if from.Type == "NULL" {
+		return true
+	}
+	if isArray(from) != isArray(to) {
+		return false
+	}
+	if from.Type == "RECORD" || to.Type == "RECORD" {
+		if from.Type != to.Type || len(from.Fields) != len(to.Fields) {
+			return false
+		}
+		for i := range from.Fields {
+			if !assignable(from.Fields[i], to.Fields[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	if from.Type == to.Type {
+		return true
+	}
+	return from.Type == "INTEGER" && (to.Type == "FLOAT" || to.Type == "NUMERIC" || to.Type == "BIGNUMERIC") ||
+		from.Type == "NUMERIC" && (to.Type == "FLOAT" || to.Type == "BIGNUMERIC")
+}
+
+func (c *compiler) compileIndex(e *Index) (expression, error) {
+	x, err := c.compileExpr(e.X)
+	if err != nil {
+		return expression{}, err
+	}
+	index, err := c.compileExpr(e.Index)
+	if err != nil {
+		return expression{}, err
+	}
+	if !isArray(x.field) {
+		return expression{}, queryError(e.Pos, "Array element access with array position is not supported on type %s",
+			typeName(x.field))
+	}
+	if index.field.Type != "INTEGER" && index.field.Type != "NULL" {
+		return expression{}, queryError(e.Index.position(), "Array position in [] must be coercible to INT64 type, but has type %s",
+			typeName(index.field))
+	}
+	mode := e.Mode
+	return expression{field: elementField(x.field), eval: func(ctx *evalContext) (interface{}, error) {
+		value, position, ok, err := evalBoth(ctx, x, index)
+		if !ok || err != nil {
+			return nil, err
+		}
+		elements := value.([]interface{})
+		i := position.(int64)
+		if mode == "ORDINAL" || mode == "SAFE_ORDINAL" {
+			i -= 1
+		}
+		if i < 0 || i >= int64(len(elements)) {
+			if strings.HasPrefix(mode, "SAFE_") {
+				return nil, nil
+			}
+			if i < 0 {
+				return nil, runtimeError("Array index %d is out of bounds (underflow)", position)
+			}
+			return nil, runtimeError("Array index %d is out of bounds (overflow)", position)
+		}
+		return elements[i], nil
+	}}, nil
+}
+
+func (c *compiler) compileInterval(e *Interval) (expression, error) {
+	value, err := c.compileExpr(e.Value)
+	if err != nil {
+		return expression{}, err
+	}
+	if value.field.Type != "INTEGER" && value.field.Type != "NULL" {
+		return expression{}, queryError(e.Value.position(), "Interval value must be coercible to INT64 type")
+	}
+	if _, ok := INTERVAL_UNITS[e.Unit]; !ok {
+		return expression{}, queryError(e.Pos, "Unsupported interval unit %s", e.Unit)
+	}
+	unit := e.Unit
+	return expression{field: scalarField("INTERVAL"), eval: func(ctx *evalContext) (interface{}, error) {
+		amount, err := value.eval(ctx)
+		if amount == nil || err != nil {
+			return nil, err
+		}
+		return newInterval(amount.(int64), unit), nil
+	}}, nil
+}
+
+func (c *compiler) compileExtract(e *Extract) (expression, error) {
+	x, err := c.compileExpr(e.X)
+	if err != nil {
+		return expression{}, err
+	}
+	part := e.Part
+	fieldType := x.field.Type
+	if isArray(x.field) || !(fieldType == "DATE" || fieldType == "DATETIME" || fieldType == "TIMESTAMP" ||
+		fieldType == "TIME" || fieldType == "NULL") {
+		return expression{}, queryError(e.Pos, "No matching signature for function EXTRACT for argument types: %s",
+			typeName(x.field))
+	}
+	result := scalarField("INTEGER")
+	switch part {
+	case "DATE":
+		result = scalarField("DATE")
+	case "TIME":
+		result = scalarField("TIME")
+	case "DATETIME":
+		result = scalarField("DATETIME")
+	}
+	if fieldType == "DATE" && (part == "HOUR" || part == "MINUTE" || part == "SECOND" || part == "TIME") ||
+		fieldType == "TIME" && (part == "YEAR" || part == "MONTH" || part == "DAY" || part == "DATE") {
+		return expression{}, queryError(e.Pos, "EXTRACT from %s does not support the %s date part", fieldType, part)
+	}
+	return expression{field: result, eval: func(ctx *evalContext) (interface{}, error) {
+		value, err := x.eval(ctx)
+		if value == nil || err != nil {
+			return nil, err
+		}
+		return extractPart(part, toTime(fieldType, value))
+	}}, nil
+}
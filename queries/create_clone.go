@@ -0,0 +1,83 @@
+package queries
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/danielstutzman/fake-bigquery/data"
+)
+
+var OPTION_REGEXP = regexp.MustCompile(
+	`(?is)^\s*(\w+)\s*=\s*(?:TIMESTAMP\s+)?(?:"((?:[^"\\]|\\.)*)"|'((?:[^'\\]|\\.)*)')\s*(?:,|$)`)
+
+// executeCreateClone runs CREATE SNAPSHOT TABLE ... CLONE and
+// CREATE TABLE ... CLONE, which return no rows.
+func executeCreateClone(projectName string, config Config,
+	destinationName, sourceName, optionsText string, operationType string, orReplace, ifNotExists bool,
+	projects map[string]data.Project) (*data.Result, *data.Error) {
+
+	destination, err := parseTableName(destinationName, projectName, config.DefaultDataset)
+	if err != nil {
+		return nil, err
+	}
+	source, err := parseTableName(sourceName, projectName, config.DefaultDataset)
+	if err != nil {
+		return nil, err
+	}
+
+	statementType := "CREATE_TABLE"
+	if operationType == "SNAPSHOT" {
+		statementType = "CREATE_SNAPSHOT_TABLE"
+	}
+
+	options := data.CopyOptions{OperationType: operationType, CreateTable: config.CreateTable}
+	for optionsText = strings.TrimSpace(optionsText); optionsText != ""; {
+		match := OPTION_REGEXP.FindStringSubmatch(optionsText)
+		if match == nil {
+			return nil, &data.Error{Reason: "invalidQuery",
+				Message: fmt.Sprintf("Syntax error: Unexpected OPTIONS: %s", optionsText)}
+		}
+		optionsText = optionsText[len(match[0]):]
+		value := match[2] + match[3]
+		switch strings.ToLower(match[1]) {
+		case "expiration_timestamp":
+			expirationTime, parseErr := data.ParseTimestamp(value)
+			if parseErr != nil {
+				return nil, &data.Error{Reason: "invalidQuery",
+					Message: fmt.Sprintf("Invalid TIMESTAMP literal: %s", value)}
+			}
+			options.ExpirationTime = expirationTime.UnixNano() / int64(time.Millisecond)
+		case "description":
+			options.Description = value
+		default:
+			return nil, &data.Error{Reason: "invalidQuery",
+				Message: fmt.Sprintf("Unknown option: %s", match[1])}
+		}
+	}
+
+	dataset := projects[destination.ProjectId].Datasets[destination.DatasetId]
+	oldTable, tableExists := dataset.Tables[destination.TableId]
+	sourceTable := source
+	sourceTable.TableId, _ = data.SplitPartitionDecorator(source.TableId)
+	referencedTables := []data.TableRef{sourceTable}
+	if tableExists && ifNotExists {
+		return &data.Result{StatementType: statementType, Fields: []data.Field{}}, nil
+	} else if config.DryRun {
+		if _, sourceExists := projects[source.ProjectId].Datasets[source.DatasetId].Tables[sourceTable.TableId]; !sourceExists {
+			return nil, &data.Error{Reason: "notFound", Message: fmt.Sprintf("Not found: Table %s", sourceTable)}
+		}
+		return &data.Result{StatementType: statementType, Fields: []data.Field{}, ReferencedTables: referencedTables}, nil
+	} else if tableExists && orReplace {
+		delete(dataset.Tables, destination.TableId)
+	}
+
+	if _, err := data.CopyTables(projects, []data.TableRef{source}, destination, options); err != nil {
+		if tableExists && orReplace {
+			dataset.Tables[destination.TableId] = oldTable
+		}
+		return nil, err
+	}
+	return &data.Result{StatementType: statementType, Fields: []data.Field{}, ReferencedTables: referencedTables}, nil
+}
@@ -0,0 +1,648 @@
+package queries
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/danielstutzman/fake-bigquery/data"
+)
+
+// interval is the value of an INTERVAL expression; months and days are
+// kept apart from the rest since their lengths vary.
+type interval struct {
+	months int64
+	days   int64
+	micros int64
+}
+
+var INTERVAL_UNITS = map[string]bool{
+	"YEAR": true, "QUARTER": true, "MONTH": true, "WEEK": true, "DAY": true, "HOUR": true,
+	"MINUTE": true, "SECOND": true, "MILLISECOND": true, "MICROSECOND": true,
+}
+
+func newInterval(amount int64, unit string) interval {
+	switch unit {
+	case "YEAR":
+		return interval{months: amount * 12}
+	case "QUARTER":
+		return interval{months: amount * 3}
+	case "MONTH":
+		return interval{months: amount}
+	case "WEEK":
+		return interval{days: amount * 7}
+	case "DAY":
+		return interval{days: amount}
+	case "HOUR":
+		return interval{micros: amount * 3600000000}
+	case "MINUTE":
+		return interval{micros: amount * 60000000}
+	case "SECOND":
+		return interval{micros: amount * 1000000}
+	case "MILLISECOND":
+		return interval{micros: amount * 1000}
+	default:
+		return interval{micros: amount}
+	}
+}
+
+func (iv interval) negate() interval {
+	return interval{months: -iv.months, days: -iv.days, micros: -iv.micros}
+}
+
+func (iv interval) addTo(t time.Time) time.Time {
+	if iv.months != 0 {
+		t = addMonths(t, iv.months)
+	}
+	if iv.days != 0 {
+		t = t.AddDate(0, 0, int(iv.days))
+	}
+	return t.Add(time.Duration(iv.micros) * time.Microsecond)
+}
+
+// addMonths moves to the same day of a later month, or to the last day of
+// that month if it's shorter.
+func addMonths(t time.Time, months int64) time.Time {
+	year, month, day := t.Date()
+	total := int64(year)*12 + int64(month-1) + months
+	newYear, newMonth := int(total/12), time.Month(total%12+1)
+	if last := daysIn(newYear, newMonth); day > last {
+		day = last
+	}
+	return time.Date(newYear, newMonth, day, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+}
+
+func daysIn(year int, month time.Month) int {
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+// addInterval adds an interval to a DATE (giving a DATETIME), DATETIME or
+// TIMESTAMP.
+func addInterval(value interface{}, iv interval) (interface{}, error) {
+	if t, ok := value.(time.Time); ok {
+		return iv.addTo(t), nil
+	}
+	fieldType := "DATETIME"
+	if len(value.(string)) == 10 {
+		fieldType = "DATE"
+	}
+	return fromTime("DATETIME", iv.addTo(toTime(fieldType, value))), nil
+}
+
+// toTime converts a DATE, DATETIME, TIME or TIMESTAMP value to a time.Time
+// in UTC, so they can share the same arithmetic.
+func toTime(fieldType string, value interface{}) time.Time {
+	switch value := value.(type) {
+	case time.Time:
+		return value
+	case string:
+		switch fieldType {
+		case "DATE":
+			t, _ := time.Parse("2006-01-02", value)
+			return t
+		case "TIME":
+			t, _ := time.Parse("15:04:05.999999", value)
+			return t
+		default:
+			t, _ := data.ParseDatetime(value)
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func fromTime(fieldType string, t time.Time) interface{} {
+	switch fieldType {
+	case "DATE":
+		return t.Format("2006-01-02")
+	case "TIME":
+		return t.Format("15:04:05.999999")
+	case "DATETIME":
+		return t.Format("2006-01-02T15:04:05.999999")
+	default:
+		return t.UTC()
+	}
+}
+
+// inZone converts a TIMESTAMP to the wall clock time in a time zone, in UTC
+// so that it can be treated like a DATETIME.
+func inZone(t time.Time, zone string) (time.Time, error) {
+	location, err := loadLocation(zone)
+	if err != nil {
+		return t, err
+	}
+	local := t.In(location)
+	return time.Date(local.Year(), local.Month(), local.Day(), local.Hour(), local.Minute(),
+		local.Second(), local.Nanosecond(), time.UTC), nil
+}
+
+// fromZone is the reverse of inZone: it reads a wall clock time as being
+// in a time zone.
+func fromZone(t time.Time, zone string) (time.Time, error) {
+	location, err := loadLocation(zone)
+	if err != nil {
+		return t, err
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(),
+		location).UTC(), nil
+}
+
+func loadLocation(zone string) (*time.Location, error) {
+	zone = strings.TrimSpace(zone)
+	if zone == "" || strings.EqualFold(zone, "UTC") || zone == "Z" {
+		return time.UTC, nil
+	}
+	if strings.HasPrefix(zone, "+") || strings.HasPrefix(zone, "-") ||
+		strings.HasPrefix(strings.ToUpper(zone), "UTC") {
+		offset := strings.TrimPrefix(strings.ToUpper(zone), "UTC")
+		sign := 1
+		if strings.HasPrefix(offset, "-") {
+			sign = -1
+		}
+		offset = strings.TrimLeft(offset, "+-")
+		parts := strings.SplitN(offset, ":", 2)
+		hours, err := strconv.Atoi(parts[0])
+		minutes := 0
+		if err == nil && len(parts) == 2 {
+			minutes, err = strconv.Atoi(parts[1])
+		}
+		if err != nil {
+			return nil, runtimeError("Invalid time zone: %s", zone)
+		}
+		return time.FixedZone(zone, sign*(hours*3600+minutes*60)), nil
+	}
+	location, err := time.LoadLocation(zone)
+	if err != nil {
+		return nil, runtimeError("Invalid time zone: %s", zone)
+	}
+	return location, nil
+}
+
+// Date parts
+
+func dayNumber(t time.Time) int64 {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC).Unix() / 86400
+}
+
+// weekStart parses WEEK(<WEEKDAY>) into the day weeks start on; WEEK
+// alone starts on Sunday.
+func weekStart(part string) (time.Weekday, bool) {
+	if part == "WEEK" {
+		return time.Sunday, true
+	}
+	if !strings.HasPrefix(part, "WEEK(") {
+		return 0, false
+	}
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		if part == "WEEK("+strings.ToUpper(day.String())+")" {
+			return day, true
+		}
+	}
+	return 0, false
+}
+
+func truncTime(part string, t time.Time) (time.Time, error) {
+	year, month, day := t.Date()
+	switch part {
+	case "MICROSECOND":
+		return t.Truncate(time.Microsecond), nil
+	case "MILLISECOND":
+		return time.Date(year, month, day, t.Hour(), t.Minute(), t.Second(), t.Nanosecond()/1e6*1e6, t.Location()), nil
+	case "SECOND":
+		return time.Date(year, month, day, t.Hour(), t.Minute(), t.Second(), 0, t.Location()), nil
+	case "MINUTE":
+		return time.Date(year, month, day, t.Hour(), t.Minute(), 0, 0, t.Location()), nil
+	case "HOUR":
+		return time.Date(year, month, day, t.Hour(), 0, 0, 0, t.Location()), nil
+	case "DAY":
+		return time.Date(year, month, day, 0, 0, 0, 0, t.Location()), nil
+	case "ISOWEEK":
+		offset := (int(t.Weekday()) + 6) % 7
+		return time.Date(year, month, day-offset, 0, 0, 0, 0, t.Location()), nil
+	case "MONTH":
+		return time.Date(year, month, 1, 0, 0, 0, 0, t.Location()), nil
+	case "QUARTER":
+		return time.Date(year, (month-1)/3*3+1, 1, 0, 0, 0, 0, t.Location()), nil
+	case "YEAR":
+		return time.Date(year, 1, 1, 0, 0, 0, 0, t.Location()), nil
+	case "ISOYEAR":
+		isoYear, _ := t.ISOWeek()
+		jan4 := time.Date(isoYear, 1, 4, 0, 0, 0, 0, t.Location())
+		return truncTime("ISOWEEK", jan4)
+	}
+	if start, ok := weekStart(part); ok {
+		offset := (int(t.Weekday()) - int(start) + 7) % 7
+		return time.Date(year, month, day-offset, 0, 0, 0, 0, t.Location()), nil
+	}
+	return t, runtimeError("Unsupported date part %s", part)
+}
+
+// diffTime counts the part boundaries between b and a, the way DATE_DIFF
+// and DATETIME_DIFF do; TIMESTAMP_DIFF instead counts whole parts elapsed.
+func diffTime(part string, a, b time.Time, elapsed bool) (int64, error) {
+	units := map[string]time.Duration{
+		"MICROSECOND": time.Microsecond, "MILLISECOND": time.Millisecond, "SECOND": time.Second,
+		"MINUTE": time.Minute, "HOUR": time.Hour,
+	}
+	if elapsed {
+		units["DAY"] = 24 * time.Hour
+	}
+	if unit, ok := units[part]; ok {
+		if elapsed {
+			return int64(a.Sub(b) / unit), nil
+		}
+		micros := int64(unit / time.Microsecond)
+		return floorDiv(a.UnixNano()/1000, micros) - floorDiv(b.UnixNano()/1000, micros), nil
+	}
+	switch part {
+	case "DAY":
+		return dayNumber(a) - dayNumber(b), nil
+	case "MONTH":
+		return int64(a.Year()*12+int(a.Month())) - int64(b.Year()*12+int(b.Month())), nil
+	case "QUARTER":
+		return int64(a.Year()*4+(int(a.Month())-1)/3) - int64(b.Year()*4+(int(b.Month())-1)/3), nil
+	case "YEAR":
+		return int64(a.Year() - b.Year()), nil
+	case "ISOYEAR":
+		aYear, _ := a.ISOWeek()
+		bYear, _ := b.ISOWeek()
+		return int64(aYear - bYear), nil
+	case "ISOWEEK":
+		aStart, _ := truncTime("ISOWEEK", a)
+		bStart, _ := truncTime("ISOWEEK", b)
+		return (dayNumber(aStart) - dayNumber(bStart)) / 7, nil
+	}
+	if _, ok := weekStart(part); ok {
+		aStart, _ := truncTime(part, a)
+		bStart, _ := truncTime(part, b)
+		return (dayNumber(aStart) - dayNumber(bStart)) / 7, nil
+	}
+	return 0, runtimeError("Unsupported date part %s", part)
+}
+
+func floorDiv(a, b int64) int64 {
+	quotient := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		quotient -= 1
+	}
+	return quotient
+}
+
+func extractPart(part string, t time.Time) (interface{}, error) {
+	switch part {
+	case "YEAR":
+		return int64(t.Year()), nil
+	case "QUARTER":
+		return int64((t.Month()-1)/3 + 1), nil
+	case "MONTH":
+		return int64(t.Month()), nil
+	case "DAY":
+		return int64(t.Day()), nil
+	case "DAYOFWEEK":
+		return int64(t.Weekday()) + 1, nil
+	case "DAYOFYEAR":
+		return int64(t.YearDay()), nil
+	case "ISOWEEK":
+		_, week := t.ISOWeek()
+		return int64(week), nil
+	case "ISOYEAR":
+		year, _ := t.ISOWeek()
+		return int64(year), nil
+	case "HOUR":
+		return int64(t.Hour()), nil
+	case "MINUTE":
+		return int64(t.Minute()), nil
+	case "SECOND":
+		return int64(t.Second()), nil
+	case "MILLISECOND":
+		return int64(t.Nanosecond() / 1e6), nil
+	case "MICROSECOND":
+		return int64(t.Nanosecond() / 1e3), nil
+	case "DATE":
+		return fromTime("DATE", t), nil
+	case "TIME":
+		return fromTime("TIME", t), nil
+	case "DATETIME":
+		return fromTime("DATETIME", t), nil
+	}
+	if start, ok := weekStart(part); ok {
+		// Days before the first start day of the year are in week 0
+		firstDay := time.Date(t.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+		offset := (int(start) - int(firstDay.Weekday()) + 7) % 7
+		return int64((t.YearDay() - 1 - offset + 7) / 7), nil
+	}
+	return nil, runtimeError("Unsupported date part %s", part)
+}
+
+// Formatting and parsing with strftime-style format strings
+
+var WEEKDAY_NAMES = []string{"Sunday", "Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday"}
+var MONTH_NAMES = []string{"January", "February", "March", "April", "May", "June", "July",
+	"August", "September", "October", "November", "December"}
+
+func formatTime(format string, t time.Time) string {
+	var output strings.Builder
+	runes := []rune(format)
+	for i := 0; i < len(runes); i++ {
+		if runes[i] != '%' || i+1 == len(runes) {
+			output.WriteRune(runes[i])
+			continue
+		}
+		i += 1
+		directive := runes[i]
+		if directive == 'E' && i+2 < len(runes) {
+			switch {
+			case runes[i+1] == '*' && runes[i+2] == 'S':
+				i += 2
+				output.WriteString(fmt.Sprintf("%02d", t.Second()))
+				if t.Nanosecond() != 0 {
+					output.WriteString(strings.TrimRight(fmt.Sprintf(".%06d", t.Nanosecond()/1000), "0"))
+				}
+				continue
+			case runes[i+1] >= '0' && runes[i+1] <= '9' && runes[i+2] == 'S':
+				digits := int(runes[i+1] - '0')
+				i += 2
+				output.WriteString(fmt.Sprintf("%02d", t.Second()))
+				if digits > 0 {
+					output.WriteString(fmt.Sprintf(".%09d", t.Nanosecond())[:digits+1])
+				}
+				continue
+			case runes[i+1] == '4' && runes[i+2] == 'Y':
+				i += 2
+				output.WriteString(fmt.Sprintf("%04d", t.Year()))
+				continue
+			case runes[i+1] == 'z':
+				i += 1
+				output.WriteString(t.Format("-07:00"))
+				continue
+			}
+		}
+		output.WriteString(formatDirective(directive, t))
+	}
+	return output.String()
+}
+
+func formatDirective(directive rune, t time.Time) string {
+	switch directive {
+	case 'Y':
+		return strconv.Itoa(t.Year())
+	case 'C':
+		return fmt.Sprintf("%02d", t.Year()/100)
+	case 'y':
+		return fmt.Sprintf("%02d", t.Year()%100)
+	case 'm':
+		return fmt.Sprintf("%02d", int(t.Month()))
+	case 'd':
+		return fmt.Sprintf("%02d", t.Day())
+	case 'e':
+		return fmt.Sprintf("%2d", t.Day())
+	case 'j':
+		return fmt.Sprintf("%03d", t.YearDay())
+	case 'H':
+		return fmt.Sprintf("%02d", t.Hour())
+	case 'k':
+		return fmt.Sprintf("%2d", t.Hour())
+	case 'I':
+		return fmt.Sprintf("%02d", (t.Hour()+11)%12+1)
+	case 'l':
+		return fmt.Sprintf("%2d", (t.Hour()+11)%12+1)
+	case 'M':
+		return fmt.Sprintf("%02d", t.Minute())
+	case 'S':
+		return fmt.Sprintf("%02d", t.Second())
+	case 'p':
+		if t.Hour() < 12 {
+			return "AM"
+		}
+		return "PM"
+	case 'P':
+		if t.Hour() < 12 {
+			return "am"
+		}
+		return "pm"
+	case 'Z':
+		name, _ := t.Zone()
+		return name
+	case 'z':
+		return t.Format("-0700")
+	case 'A':
+		return WEEKDAY_NAMES[t.Weekday()]
+	case 'a':
+		return WEEKDAY_NAMES[t.Weekday()][:3]
+	case 'B':
+		return MONTH_NAMES[t.Month()-1]
+	case 'b', 'h':
+		return MONTH_NAMES[t.Month()-1][:3]
+	case 'D', 'x':
+		return formatTime("%m/%d/%y", t)
+	case 'F':
+		return formatTime("%Y-%m-%d", t)
+	case 'T', 'X':
+		return formatTime("%H:%M:%S", t)
+	case 'R':
+		return formatTime("%H:%M", t)
+	case 'c':
+		return formatTime("%a %b %e %H:%M:%S %Y", t)
+	case 's':
+		return strconv.FormatInt(t.Unix(), 10)
+	case 'u':
+		return strconv.Itoa((int(t.Weekday())+6)%7 + 1)
+	case 'w':
+		return strconv.Itoa(int(t.Weekday()))
+	case 'U':
+		week, _ := extractPart("WEEK", t)
+		return fmt.Sprintf("%02d", week)
+	case 'W':
+		week, _ := extractPart("WEEK(MONDAY)", t)
+		return fmt.Sprintf("%02d", week)
+	case 'V':
+		_, week := t.ISOWeek()
+		return fmt.Sprintf("%02d", week)
+	case 'G':
+		year, _ := t.ISOWeek()
+		return strconv.Itoa(year)
+	case 'g':
+		year, _ := t.ISOWeek()
+		return fmt.Sprintf("%02d", year%100)
+	case 'Q':
+		return strconv.Itoa((int(t.Month())-1)/3 + 1)
+	case 'n':
+		return "\n"
+	case 't':
+		return "\t"
+	case '%':
+		return "%"
+	}
+	return "%" + string(directive)
+}
+
+// parseTime reads a string written in a strftime-style format, returning
+// a wall clock time in UTC and whether the string named its own zone.
+func parseTime(format, input string) (time.Time, *time.Location, error) {
+	failed := runtimeError("Failed to parse input string \"%s\"", input)
+	year, month, day := 1970, 1, 1
+	hour, minute, second, nanos := 0, 0, 0, 0
+	pm, hasAmPm := false, false
+	var location *time.Location
+	var epoch *int64
+
+	format = strings.NewReplacer("%F", "%Y-%m-%d", "%T", "%H:%M:%S", "%D", "%m/%d/%y",
+		"%R", "%H:%M", "%x", "%m/%d/%y", "%X", "%H:%M:%S").Replace(format)
+	in := []rune(input)
+	pos := 0
+	number := func(maxDigits int) (int, bool) {
+		start := pos
+		if pos < len(in) && (in[pos] == '-' || in[pos] == '+') && maxDigits > 2 {
+			pos += 1
+		}
+		for pos < len(in) && pos-start < maxDigits && in[pos] >= '0' && in[pos] <= '9' {
+			pos += 1
+		}
+		value, err := strconv.Atoi(string(in[start:pos]))
+		return value, err == nil
+	}
+	name := func(names []string) (int, bool) {
+		for i, candidate := range names {
+			for _, length := range []int{len(candidate), 3} {
+				if pos+length <= len(in) && strings.EqualFold(string(in[pos:pos+length]), candidate[:length]) {
+					pos += length
+					return i, true
+				}
+			}
+		}
+		return 0, false
+	}
+
+	runes := []rune(format)
+	for i := 0; i < len(runes); i++ {
+		if runes[i] != '%' || i+1 == len(runes) {
+			if runes[i] == ' ' {
+				for pos < len(in) && in[pos] == ' ' {
+					pos += 1
+				}
+				continue
+			}
+			if pos >= len(in) || in[pos] != runes[i] {
+				return time.Time{}, nil, failed
+			}
+			pos += 1
+			continue
+		}
+		i += 1
+		directive := runes[i]
+		ok := true
+		switch directive {
+		case 'Y':
+			year, ok = number(5)
+		case 'y':
+			year, ok = number(2)
+			if year < 69 {
+				year += 2000
+			} else {
+				year += 1900
+			}
+		case 'm':
+			month, ok = number(2)
+		case 'd', 'e':
+			for pos < len(in) && in[pos] == ' ' {
+				pos += 1
+			}
+			day, ok = number(2)
+		case 'j':
+			var yearDay int
+			yearDay, ok = number(3)
+			month, day = 1, yearDay
+		case 'H', 'k':
+			hour, ok = number(2)
+		case 'I', 'l':
+			hour, ok = number(2)
+		case 'M':
+			minute, ok = number(2)
+		case 'S':
+			second, ok = number(2)
+		case 'E':
+			if i+2 < len(runes) && runes[i+2] == 'S' {
+				i += 2
+				if second, ok = number(2); ok && pos < len(in) && in[pos] == '.' {
+					pos += 1
+					start := pos
+					for pos < len(in) && in[pos] >= '0' && in[pos] <= '9' {
+						pos += 1
+					}
+					digits := (string(in[start:pos]) + "000000000")[:9]
+					nanos, _ = strconv.Atoi(digits)
+				}
+			} else if i+2 < len(runes) && runes[i+1] == '4' && runes[i+2] == 'Y' {
+				i += 2
+				year, ok = number(4)
+			} else if i+1 < len(runes) && runes[i+1] == 'z' {
+				i += 1
+				location, ok = parseZone(in, &pos)
+			} else {
+				ok = false
+			}
+		case 'p', 'P':
+			hasAmPm = true
+			var index int
+			index, ok = name([]string{"AM", "PM"})
+			pm = index == 1
+		case 'b', 'h', 'B':
+			month, ok = name(MONTH_NAMES)
+			month += 1
+		case 'a', 'A':
+			_, ok = name(WEEKDAY_NAMES)
+		case 'Z', 'z':
+			location, ok = parseZone(in, &pos)
+		case 's':
+			var seconds int
+			seconds, ok = number(19)
+			value := int64(seconds)
+			epoch = &value
+		case 'Q':
+			var quarter int
+			quarter, ok = number(1)
+			month = (quarter-1)*3 + 1
+		case 'n', 't':
+			for pos < len(in) && (in[pos] == ' ' || in[pos] == '\n' || in[pos] == '\t') {
+				pos += 1
+			}
+		case '%':
+			ok = pos < len(in) && in[pos] == '%'
+			pos += 1
+		default:
+			ok = false
+		}
+		if !ok {
+			return time.Time{}, nil, failed
+		}
+	}
+	if pos != len(in) {
+		return time.Time{}, nil, failed
+	}
+	if epoch != nil {
+		return time.Unix(*epoch, 0).UTC(), time.UTC, nil
+	}
+	if hasAmPm {
+		hour = hour % 12
+		if pm {
+			hour += 12
+		}
+	}
+	// A %j day of the year is counted from January 1
+	validDay := day >= 1 && (day <= daysIn(year, time.Month(month)) || strings.Contains(format, "%j"))
+	if month < 1 || month > 12 || !validDay || hour > 23 || minute > 59 || second > 60 {
+		return time.Time{}, nil, failed
+	}
+	return time.Date(year, time.Month(month), day, hour, minute, second, nanos, time.UTC), location, nil
+}
+
+func parseZone(in []rune, pos *int) (*time.Location, bool) {
+	start := *pos
+	for *pos < len(in) && in[*pos] != ' ' {
+		*pos += 1
+	}
+	location, err := loadLocation(string(in[start:*pos]))
+	return location, err == nil
+}
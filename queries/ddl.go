@@ -0,0 +1,540 @@
+package queries
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/danielstutzman/fake-bigquery/data"
+)
+
+func ddlResult(statementType, operation string, table, dataset *data.TableRef) *data.Result {
+	return &data.Result{
+		StatementType:         statementType,
+		Fields:                []data.Field{},
+		Rows:                  []map[string]interface{}{},
+		DdlOperationPerformed: operation,
+		DdlTargetTable:        table,
+		DdlTargetDataset:      dataset,
+	}
+}
+
+// constantValue evaluates x, an OPTIONS value, procedure argument or value
+// for a script variable named name, which must be of type want.
+func (e *executor) constantValue(x Expr, want data.Field, name string) (interface{}, error) {
+	c := &compiler{executor: e, ctes: map[string]*cte{}}
+	compiled, err := c.compileExpr(x)
+	if err != nil {
+		return nil, err
+	}
+	if compiled, err = coerceLiteral(x, compiled, want); err != nil {
+		return nil, err
+	}
+	if !assignable(compiled.field, want) {
+		return nil, queryError(x.position(), "Invalid value for %s: expected %s but got %s",
+			name, typeName(want), typeName(compiled.field))
+	}
+	return coerceTo(compiled, want).eval(&evalContext{})
+}
+
+var LABELS_FIELD = arrayField(data.Field{Type: "RECORD", Mode: "NULLABLE", Fields: []data.Field{
+	{Name: "key", Type: "STRING", Mode: "NULLABLE"},
+	{Name: "value", Type: "STRING", Mode: "NULLABLE"},
+}})
+
+// labelsOption reads labels=[("key", "value"), ...].
+func (e *executor) labelsOption(option Option) (map[string]string, error) {
+	value, err := e.constantValue(option.X, LABELS_FIELD, option.Name)
+	if err != nil {
+		return nil, err
+	}
+	labels := map[string]string{}
+	elements, _ := value.([]interface{})
+	for _, element := range elements {
+		record, _ := element.(map[string]interface{})
+		key, _ := record["key"].(string)
+		labelValue, _ := record["value"].(string)
+		labels[key] = labelValue
+	}
+	return labels, nil
+}
+
+func (e *executor) stringOption(option Option) (string, error) {
+	value, err := e.constantValue(option.X, scalarField("STRING"), option.Name)
+	if err != nil {
+		return "", err
+	}
+	text, _ := value.(string)
+	return text, nil
+}
+
+// daysOption reads an option like default_table_expiration_days as
+// milliseconds, or 0 for NULL.
+func (e *executor) daysOption(option Option) (int64, error) {
+	value, err := e.constantValue(option.X, scalarField("FLOAT"), option.Name)
+	if err != nil {
+		return 0, err
+	}
+	days, _ := value.(float64)
+	return int64(days * 24 * 60 * 60 * 1000), nil
+}
+
+func (e *executor) applyTableOptions(table *data.Table, options []Option) error {
+	for _, option := range options {
+		var err error
+		switch strings.ToLower(option.Name) {
+		case "description":
+			table.Description, err = e.stringOption(option)
+		case "labels":
+			table.Labels, err = e.labelsOption(option)
+		case "expiration_timestamp":
+			var value interface{}
+			if value, err = e.constantValue(option.X, scalarField("TIMESTAMP"), option.Name); err == nil {
+				table.ExpirationTime = 0
+				if expirationTime, ok := value.(time.Time); ok {
+					table.ExpirationTime = expirationTime.UnixNano() / int64(time.Millisecond)
+				}
+			}
+		default:
+			err = queryError(option.Pos, "Unknown option: %s", option.Name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *executor) applyDatasetOptions(dataset *data.Dataset, options []Option) error {
+	for _, option := range options {
+		var err error
+		switch strings.ToLower(option.Name) {
+		case "description":
+			dataset.Description, err = e.stringOption(option)
+		case "friendly_name":
+			dataset.FriendlyName, err = e.stringOption(option)
+		case "labels":
+			dataset.Labels, err = e.labelsOption(option)
+		case "location":
+			dataset.Location, err = e.stringOption(option)
+		case "default_table_expiration_days":
+			dataset.DefaultTableExpirationMs, err = e.daysOption(option)
+		case "default_partition_expiration_days":
+			dataset.DefaultPartitionExpirationMs, err = e.daysOption(option)
+		default:
+			err = queryError(option.Pos, "Unknown option: %s", option.Name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// columnField converts a column definition to a schema field.
+func (e *executor) columnField(column ColumnDef) (data.Field, error) {
+	field := fieldFromTypeSpec(column.Type)
+	field.Name = column.Name
+	if column.NotNull {
+		if isArray(field) {
+			return field, queryError(column.Pos, "NOT NULL cannot be applied to ARRAY column %s", column.Name)
+		}
+		field.Mode = "REQUIRED"
+	}
+	for _, option := range column.Options {
+		if strings.ToLower(option.Name) != "description" {
+			return field, queryError(option.Pos, "Unknown option: %s", option.Name)
+		}
+		var err error
+		if field.Description, err = e.stringOption(option); err != nil {
+			return field, err
+		}
+	}
+	return field, nil
+}
+
+func fieldIndex(fields []data.Field, name string) int {
+	for i, field := range fields {
+		if strings.EqualFold(field.Name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// CREATE TABLE
+
+func (e *executor) executeCreateTable(statement *CreateTableStatement) (*data.Result, error) {
+	var ref data.TableRef
+	var err error
+	if statement.Temporary {
+		ref, err = e.temporaryTableRef(statement.Target)
+	} else {
+		ref, err = e.tableRef(statement.Target.Path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	dataset, err := e.lookupDataset(ref)
+	if err != nil {
+		return nil, err
+	}
+	statementType := "CREATE_TABLE"
+	if statement.Query != nil {
+		statementType = "CREATE_TABLE_AS_SELECT"
+	}
+
+	operation := "CREATE"
+	if _, tableExists := dataset.Tables[ref.TableId]; tableExists {
+		if statement.IfNotExists {
+			return ddlResult(statementType, "SKIP", &ref, nil), nil
+		} else if !statement.OrReplace {
+			return nil, &data.Error{Reason: "duplicate", Message: fmt.Sprintf("Already Exists: Table %s", ref)}
+		}
+		operation = "REPLACE"
+	}
+
+	nowMillis := data.NowMillis()
+	table := data.Table{
+		Fields:           []data.Field{},
+		Rows:             []map[string]interface{}{},
+		CreationTime:     nowMillis,
+		LastModifiedTime: nowMillis,
+		ExpirationTime:   dataset.NewTableExpirationTime(nowMillis),
+	}
+	for _, column := range statement.Columns {
+		if fieldIndex(table.Fields, column.Name) != -1 {
+			return nil, queryError(column.Pos, "Duplicate column name %s in CREATE TABLE", column.Name)
+		}
+		field, err := e.columnField(column)
+		if err != nil {
+			return nil, err
+		}
+		table.Fields = append(table.Fields, field)
+	}
+
+	if statement.Query != nil {
+		result, err := e.executeQuery(statement.Query)
+		if err != nil {
+			return nil, err
+		}
+		if statement.Columns == nil {
+			table.Fields = result.Fields
+			table.Rows = result.Rows
+		} else if table.Rows, err = renameColumns(statement, result, table.Fields); err != nil {
+			return nil, err
+		}
+	}
+
+	if table.Clustering, err = clustering(statement.ClusterBy, table.Fields, statement.Pos); err != nil {
+		return nil, err
+	}
+	if err := e.applyTableOptions(&table, statement.Options); err != nil {
+		return nil, err
+	}
+	if e.dryRun {
+		return dryRunResult(statementType), nil
+	}
+	if err := e.chargeTable(ref); err != nil {
+		return nil, err
+	}
+	dataset.Tables[ref.TableId] = table
+	return ddlResult(statementType, operation, &ref, nil), nil
+}
+
+// chargeTable charges a table the statement is about to add, when there's
+// a quota to charge it to.
+func (e *executor) chargeTable(ref data.TableRef) error {
+	if e.createTable != nil {
+		if err := e.createTable(ref); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// clustering checks the columns of a CLUSTER BY clause, returning nil
+// when there are none.
+func clustering(names []string, fields []data.Field, pos position) (*data.Clustering, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	clustering := &data.Clustering{Fields: names}
+	if err := clustering.Check(fields); err != nil {
+		return nil, queryError(pos, "%s", err)
+	}
+	return clustering, nil
+}
+
+// renameColumns stores the results of CREATE TABLE (columns) AS SELECT
+// under the names of the column list, matching columns by position.
+func renameColumns(statement *CreateTableStatement, result *data.Result,
+	fields []data.Field) ([]map[string]interface{}, error) {
+
+	if len(result.Fields) != len(fields) {
+		return nil, queryError(statement.Pos,
+			"The number of columns in the column definition list does not match the number of columns produced by the query")
+	}
+	from := normalizeFields(result.Fields)
+	to := normalizeFields(fields)
+	for i := range fields {
+		if !assignable(from[i], to[i]) {
+			return nil, queryError(statement.Columns[i].Pos, "Column %s has type %s which cannot be coerced to %s",
+				fields[i].Name, typeName(from[i]), typeName(to[i]))
+		}
+	}
+	rows := []map[string]interface{}{}
+	for _, resultRow := range result.Rows {
+		values := make([]interface{}, len(fields))
+		for i, field := range result.Fields {
+			values[i] = coerce(resultRow[field.Name], from[i], to[i])
+		}
+		row, err := storeRow(fields, values)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// DROP TABLE
+
+func (e *executor) executeDropTable(statement *DropTableStatement) (*data.Result, error) {
+	ref, err := e.tableRef(statement.Target.Path)
+	if err != nil {
+		return nil, err
+	}
+	dataset, err := e.lookupDataset(ref)
+	if err != nil {
+		return nil, err
+	}
+	statementType := "DROP_" + strings.Replace(statement.Kind, " ", "_", -1)
+	table, tableExists := dataset.Tables[ref.TableId]
+	if !tableExists {
+		if statement.IfExists {
+			return ddlResult(statementType, "SKIP", &ref, nil), nil
+		}
+		return nil, tableNotFound(ref, dataset)
+	}
+	// DROP TABLE can also drop a table snapshot
+	if kind := ddlKind(table); kind != statement.Kind && !(kind == "SNAPSHOT TABLE" && statement.Kind == "TABLE") {
+		return nil, queryError(statement.Target.Pos, "%s is a %s; use DROP %s", ref, strings.ToLower(kind), kind)
+	}
+	if e.dryRun {
+		return dryRunResult(statementType), nil
+	}
+	delete(dataset.Tables, ref.TableId)
+	return ddlResult(statementType, "DROP", &ref, nil), nil
+}
+
+// ALTER TABLE
+
+func (e *executor) executeAlterTable(statement *AlterTableStatement) (*data.Result, error) {
+	ref, err := e.tableRef(statement.Target.Path)
+	if err != nil {
+		return nil, err
+	}
+	dataset, err := e.lookupDataset(ref)
+	if err != nil {
+		return nil, err
+	}
+	statementType := "ALTER_" + strings.Replace(statement.Kind, " ", "_", -1)
+	table, tableExists := dataset.Tables[ref.TableId]
+	if !tableExists {
+		if statement.IfExists {
+			return ddlResult(statementType, "SKIP", &ref, nil), nil
+		}
+		return nil, tableNotFound(ref, dataset)
+	}
+	if table.Snapshot != nil {
+		return nil, queryError(statement.Target.Pos, "Cannot alter table snapshot %s", ref)
+	} else if kind := ddlKind(table); kind != statement.Kind {
+		return nil, queryError(statement.Target.Pos, "%s is a %s; use ALTER %s", ref, strings.ToLower(kind), kind)
+	}
+
+	// Work on copies so that a failing action leaves the table unchanged
+	fields := make([]data.Field, len(table.Fields))
+	copy(fields, table.Fields)
+	rows := make([]map[string]interface{}, len(table.Rows))
+	for i, row := range table.Rows {
+		rows[i] = data.CopyValue(row).(map[string]interface{})
+	}
+	newRef := ref
+	for _, action := range statement.Actions {
+		if statement.Kind != "TABLE" && action.Kind != "SET OPTIONS" {
+			return nil, queryError(action.Pos, "ALTER %s only supports SET OPTIONS", statement.Kind)
+		}
+		switch action.Kind {
+		case "ADD COLUMN":
+			if fieldIndex(fields, action.Column.Name) != -1 {
+				if action.IfExists {
+					continue
+				}
+				return nil, queryError(action.Column.Pos, "Column already exists: %s", action.Column.Name)
+			}
+			field, err := e.columnField(action.Column)
+			if err != nil {
+				return nil, err
+			}
+			if field.Mode == "REQUIRED" {
+				return nil, queryError(action.Column.Pos, "Cannot add a NOT NULL column %s to an existing table",
+					action.Column.Name)
+			}
+			fields = append(fields, field)
+
+		case "DROP COLUMN", "RENAME COLUMN":
+			i := fieldIndex(fields, action.Name)
+			if i == -1 {
+				if action.IfExists {
+					continue
+				}
+				return nil, queryError(action.Pos, "Column not found: %s", action.Name)
+			}
+			name := fields[i].Name
+			if table.Clustering != nil && clusteredBy(table.Clustering, name) {
+				return nil, queryError(action.Pos, "Cannot drop or rename clustering column %s", name)
+			}
+			if action.Kind == "DROP COLUMN" {
+				if len(fields) == 1 {
+					return nil, queryError(action.Pos, "Cannot drop the only column of table %s", ref)
+				}
+				fields = append(fields[:i:i], fields[i+1:]...)
+				for _, row := range rows {
+					delete(row, name)
+				}
+			} else {
+				if j := fieldIndex(fields, action.NewName); j != -1 && j != i {
+					return nil, queryError(action.Pos, "Column already exists: %s", action.NewName)
+				}
+				fields[i].Name = action.NewName
+				for _, row := range rows {
+					if value, ok := row[name]; ok {
+						delete(row, name)
+						row[action.NewName] = value
+					}
+				}
+			}
+
+		case "RENAME TO":
+			newRef.TableId = action.NewName
+			if _, exists := dataset.Tables[newRef.TableId]; exists && newRef != ref {
+				return nil, &data.Error{Reason: "duplicate", Message: fmt.Sprintf("Already Exists: Table %s", newRef)}
+			}
+
+		case "SET OPTIONS":
+			if table.MaterializedView != nil {
+				err = e.applyMaterializedViewOptions(&table, action.Options)
+			} else {
+				err = e.applyTableOptions(&table, action.Options)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if e.dryRun {
+		return dryRunResult(statementType), nil
+	}
+	table.Fields = fields
+	table.Rows = rows
+	table.LastModifiedTime = data.NowMillis()
+	table.Version += 1
+	delete(dataset.Tables, ref.TableId)
+	dataset.Tables[newRef.TableId] = table
+	return ddlResult(statementType, "ALTER", &newRef, nil), nil
+}
+
+// ddlKind is how DDL statements name the kind of table.
+func ddlKind(table data.Table) string {
+	if table.Snapshot != nil {
+		return "SNAPSHOT TABLE"
+	}
+	return strings.Replace(table.Type(), "_", " ", -1)
+}
+
+func clusteredBy(clustering *data.Clustering, name string) bool {
+	for _, field := range clustering.Fields {
+		if strings.EqualFold(field, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// CREATE and DROP SCHEMA
+
+func (e *executor) datasetRef(path []string) (data.TableRef, error) {
+	switch len(path) {
+	case 1:
+		return data.TableRef{ProjectId: e.projectName, DatasetId: path[0]}, nil
+	case 2:
+		return data.TableRef{ProjectId: path[0], DatasetId: path[1]}, nil
+	default:
+		return data.TableRef{}, &data.Error{Reason: "invalid", Message: fmt.Sprintf(
+			"Invalid dataset name \"%s\"", strings.Join(path, "."))}
+	}
+}
+
+func (e *executor) executeCreateSchema(statement *CreateSchemaStatement) (*data.Result, error) {
+	ref, err := e.datasetRef(statement.Path)
+	if err != nil {
+		return nil, err
+	}
+	project, projectOk := e.projects[ref.ProjectId]
+	if !projectOk {
+		project = data.Project{
+			Datasets: map[string]data.Dataset{},
+		}
+	}
+	if _, datasetExists := project.Datasets[ref.DatasetId]; datasetExists {
+		if statement.IfNotExists {
+			return ddlResult("CREATE_SCHEMA", "SKIP", nil, &ref), nil
+		}
+		return nil, &data.Error{Reason: "duplicate", Message: fmt.Sprintf(
+			"Already Exists: Dataset %s:%s", ref.ProjectId, ref.DatasetId)}
+	}
+
+	nowMillis := data.NowMillis()
+	dataset := data.Dataset{
+		Tables:           map[string]data.Table{},
+		Location:         "US",
+		Access:           data.DEFAULT_ACCESS,
+		CreationTime:     nowMillis,
+		LastModifiedTime: nowMillis,
+	}
+	if err := e.applyDatasetOptions(&dataset, statement.Options); err != nil {
+		return nil, err
+	}
+	if e.dryRun {
+		return dryRunResult("CREATE_SCHEMA"), nil
+	}
+	e.projects[ref.ProjectId] = project
+	project.Datasets[ref.DatasetId] = dataset
+	return ddlResult("CREATE_SCHEMA", "CREATE", nil, &ref), nil
+}
+
+func (e *executor) executeDropSchema(statement *DropSchemaStatement) (*data.Result, error) {
+	ref, err := e.datasetRef(statement.Path)
+	if err != nil {
+		return nil, err
+	}
+	dataset, datasetOk := e.projects[ref.ProjectId].Datasets[ref.DatasetId]
+	if !datasetOk {
+		if statement.IfExists {
+			return ddlResult("DROP_SCHEMA", "SKIP", nil, &ref), nil
+		}
+		return nil, &data.Error{Reason: "notFound", Message: fmt.Sprintf(
+			"Not found: Dataset %s:%s", ref.ProjectId, ref.DatasetId)}
+	}
+	if len(dataset.Tables) > 0 && !statement.Cascade {
+		return nil, &data.Error{Reason: "resourceInUse", Message: fmt.Sprintf(
+			"Dataset %s:%s is still in use", ref.ProjectId, ref.DatasetId)}
+	}
+	if e.dryRun {
+		return dryRunResult("DROP_SCHEMA"), nil
+	}
+	delete(e.projects[ref.ProjectId].Datasets, ref.DatasetId)
+	return ddlResult("DROP_SCHEMA", "DROP", nil, &ref), nil
+}
@@ -0,0 +1,641 @@
+package queries
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/danielstutzman/fake-bigquery/data"
+)
+
+// dmlTarget is the table an INSERT, UPDATE, DELETE or MERGE changes.
+type dmlTarget struct {
+	ref     data.TableRef
+	table   data.Table
+	fields  []data.Field
+	alias   string
+	columns []column
+}
+
+// lookupTarget finds the table a DML statement changes. UPDATE, DELETE
+// and MERGE scan it, and are billed for all of its columns.
+func (c *compiler) lookupTarget(target *TableName, scanned bool) (*dmlTarget, error) {
+	ref, table, err := c.lookupTable(target.Path, target.Pos)
+	if err != nil {
+		return nil, err
+	}
+	c.executor.referenceTable(ref)
+	if table.Snapshot != nil {
+		return nil, &data.Error{Reason: "invalidQuery", Message: fmt.Sprintf("Cannot write to table snapshot %s.", ref)}
+	} else if table.View != nil {
+		return nil, &data.Error{Reason: "invalidQuery", Message: fmt.Sprintf("Cannot write to view %s.", ref)}
+	} else if table.MaterializedView != nil {
+		return nil, &data.Error{Reason: "invalidQuery", Message: fmt.Sprintf("Cannot write to materialized view %s.", ref)}
+	}
+	alias := target.Alias
+	if alias == "" {
+		alias = target.Path[len(target.Path)-1]
+	}
+	t := &dmlTarget{ref: ref, table: table, fields: normalizeFields(table.Fields), alias: alias}
+	for _, field := range t.fields {
+		t.columns = append(t.columns, column{qualifier: t.alias, name: field.Name, field: field})
+	}
+	t.columns = append(t.columns, partitionColumns(t.table, t.alias)...)
+	if scanned {
+		if scan := c.executor.scanTable(table, t.fields, t.columns); scan != nil {
+			scan.whole = true
+		}
+	}
+	return t, nil
+}
+
+// rows gives the target's rows as tuples ordered like its columns.
+func (t *dmlTarget) rows() [][]interface{} {
+	return partitionedTableRows(t.table, t.fields)
+}
+
+func (t *dmlTarget) scope(parent *scope) *scope {
+	columns := append([]column{}, t.columns...)
+	return &scope{columns: columns, ranges: []rangeVariable{{t.alias, 0, len(columns)}}, parent: parent}
+}
+
+// save replaces the target's rows, putting new rows of an ingestion-time
+// partitioned table in the current partition.
+func (t *dmlTarget) save(projects map[string]data.Project, rows []map[string]interface{}) {
+	now := time.Now()
+	for _, row := range rows {
+		t.table.AssignPartition(row, "", now)
+	}
+	t.table.Rows = rows
+	t.table.LastModifiedTime = data.NowMillis()
+	t.table.Version += 1
+	projects[t.ref.ProjectId].Datasets[t.ref.DatasetId].Tables[t.ref.TableId] = t.table
+}
+
+func (t *dmlTarget) field(name string) (int, bool) {
+	for i, field := range t.fields {
+		if strings.EqualFold(field.Name, name) {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+// keepPartition leaves an updated row in the partition of the row it
+// replaces, or like it in the streaming buffer.
+func keepPartition(updated, old map[string]interface{}) {
+	for _, key := range []string{data.PARTITION_TIME_KEY, data.STREAMED_TIME_KEY} {
+		if value, ok := old[key]; ok {
+			updated[key] = value
+		}
+	}
+}
+
+func dmlResult(statementType string, inserted, updated, deleted int64) *data.Result {
+	return &data.Result{
+		StatementType: statementType,
+		Fields:        []data.Field{},
+		Rows:          []map[string]interface{}{},
+		DmlStats: &data.DmlStats{
+			InsertedRowCount: inserted,
+			UpdatedRowCount:  updated,
+			DeletedRowCount:  deleted,
+		},
+	}
+}
+
+// INSERT
+
+// insertColumns finds the target columns an INSERT names, or all of
+// them when it names none.
+func (t *dmlTarget) insertColumns(names []string, pos position) ([]int, error) {
+	if len(names) == 0 {
+		indexes := make([]int, len(t.fields))
+		for i := range indexes {
+			indexes[i] = i
+		}
+		return indexes, nil
+	}
+	indexes := []int{}
+	seen := map[int]bool{}
+	for _, name := range names {
+		index, ok := t.field(name)
+		if !ok {
+			return nil, queryError(pos, "Column %s is not present in table %s", name, t.ref)
+		}
+		if seen[index] {
+			return nil, queryError(pos, "INSERT has columns with duplicate name: %s", name)
+		}
+		seen[index] = true
+		indexes = append(indexes, index)
+	}
+	return indexes, nil
+}
+
+// compileInsertValues compiles one row of VALUES, where a nil Expr is
+// DEFAULT.
+func (c *compiler) compileInsertValues(t *dmlTarget, indexes []int, exprs []Expr, pos position) ([]expression, error) {
+	if len(exprs) != len(indexes) {
+		return nil, queryError(pos, "Inserted row has wrong column count; Has %d, expected %d", len(exprs), len(indexes))
+	}
+	values := make([]expression, len(exprs))
+	for i, e := range exprs {
+		field := t.fields[indexes[i]]
+		if e == nil {
+			values[i] = constant(field, nil)
+			continue
+		}
+		x, err := c.compileExpr(e)
+		if err != nil {
+			return nil, err
+		}
+		if x, err = coerceLiteral(e, x, field); err != nil {
+			return nil, err
+		}
+		if !assignable(x.field, field) {
+			return nil, queryError(e.position(), "Value has type %s which cannot be inserted into column %s, which has type %s",
+				typeName(x.field), field.Name, typeName(field))
+		}
+		values[i] = coerceTo(x, field)
+	}
+	return values, nil
+}
+
+func (e *executor) executeInsert(s *InsertStatement) (*data.Result, error) {
+	c := &compiler{executor: e, ctes: map[string]*cte{}, clause: "INSERT"}
+	t, err := c.lookupTarget(s.Target, false)
+	if err != nil {
+		return nil, err
+	}
+	indexes, err := t.insertColumns(s.Columns, s.Pos)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows [][]interface{}
+	if s.Values != nil {
+		for _, exprs := range s.Values {
+			values, err := c.compileInsertValues(t, indexes, exprs, s.Pos)
+			if err != nil {
+				return nil, err
+			}
+			if e.dryRun {
+				continue
+			}
+			row := make([]interface{}, len(values))
+			for i, value := range values {
+				if row[i], err = value.eval(&evalContext{}); err != nil {
+					return nil, err
+				}
+			}
+			rows = append(rows, row)
+		}
+	} else {
+		query, err := c.compileQuery(s.Query)
+		if err != nil {
+			return nil, err
+		}
+		if len(query.fields) != len(indexes) {
+			return nil, queryError(s.Pos, "Inserted row has wrong column count; Has %d, expected %d",
+				len(query.fields), len(indexes))
+		}
+		for i, field := range query.fields {
+			if !assignable(field, t.fields[indexes[i]]) {
+				return nil, queryError(s.Pos, "Query column %d has type %s which cannot be inserted into column %s, which has type %s",
+					i+1, typeName(field), t.fields[indexes[i]].Name, typeName(t.fields[indexes[i]]))
+			}
+		}
+		if e.dryRun {
+			return dryRunResult("INSERT"), nil
+		}
+		if rows, err = query.run(&evalContext{}); err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			for i, field := range query.fields {
+				row[i] = coerce(row[i], field, t.fields[indexes[i]])
+			}
+		}
+	}
+
+	if e.dryRun {
+		return dryRunResult("INSERT"), nil
+	}
+	newRows := append([]map[string]interface{}{}, t.table.Rows...)
+	for _, row := range rows {
+		values := make([]interface{}, len(t.fields))
+		for i, index := range indexes {
+			values[index] = row[i]
+		}
+		stored, err := storeRow(t.fields, values)
+		if err != nil {
+			return nil, err
+		}
+		newRows = append(newRows, stored)
+	}
+	t.save(e.projects, newRows)
+	return dmlResult("INSERT", int64(len(rows)), 0, 0), nil
+}
+
+// UPDATE
+
+// assignment is one SET clause, which may set a field inside a STRUCT
+// column.
+type assignment struct {
+	index  int      // of the column
+	fields []string // inside the column
+	value  expression
+}
+
+func (c *compiler) compileAssignments(t *dmlTarget, sets []SetClause) ([]assignment, error) {
+	assignments := []assignment{}
+	seen := []string{}
+	for _, set := range sets {
+		path := set.Path
+		if len(path) > 1 && strings.EqualFold(path[0], t.alias) {
+			if _, isColumn := t.field(path[0]); !isColumn {
+				path = path[1:]
+			}
+		}
+		index, ok := t.field(path[0])
+		if !ok {
+			return nil, queryError(set.Pos, "Unrecognized name: %s", path[0])
+		}
+		field := t.fields[index]
+		fields := []string{}
+		for _, name := range path[1:] {
+			var err error
+			if field, err = structField(field, name, set.Pos); err != nil {
+				return nil, err
+			}
+			fields = append(fields, field.Name)
+		}
+
+		key := strings.ToLower(strings.Join(append([]string{t.fields[index].Name}, fields...), "."))
+		for _, other := range seen {
+			if key == other || strings.HasPrefix(key, other+".") || strings.HasPrefix(other, key+".") {
+				return nil, queryError(set.Pos, "Update item %s overlaps with %s", strings.Join(set.Path, "."), other)
+			}
+		}
+		seen = append(seen, key)
+
+		value := constant(field, nil)
+		if set.X != nil {
+			x, err := c.compileExpr(set.X)
+			if err != nil {
+				return nil, err
+			}
+			if x, err = coerceLiteral(set.X, x, field); err != nil {
+				return nil, err
+			}
+			if !assignable(x.field, field) {
+				return nil, queryError(set.X.position(), "Value of type %s cannot be assigned to %s, which has type %s",
+					typeName(x.field), strings.Join(set.Path, "."), typeName(field))
+			}
+			value = coerceTo(x, field)
+		}
+		assignments = append(assignments, assignment{index: index, fields: fields, value: value})
+	}
+	return assignments, nil
+}
+
+// applyAssignments evaluates every SET clause against the original row,
+// then returns the updated tuple.
+func applyAssignments(assignments []assignment, ctx *evalContext, width int) ([]interface{}, error) {
+	values := make([]interface{}, len(assignments))
+	for i, a := range assignments {
+		var err error
+		if values[i], err = a.value.eval(ctx); err != nil {
+			return nil, err
+		}
+	}
+	row := append([]interface{}{}, ctx.row[:width]...)
+	for i, a := range assignments {
+		if len(a.fields) == 0 {
+			row[a.index] = values[i]
+			continue
+		}
+		record, ok := data.CopyValue(row[a.index]).(map[string]interface{})
+		if !ok {
+			return nil, runtimeError("Cannot set field of NULL %s", strings.Join(a.fields, "."))
+		}
+		row[a.index] = record
+		for _, name := range a.fields[:len(a.fields)-1] {
+			inner, ok := record[name].(map[string]interface{})
+			if !ok {
+				return nil, runtimeError("Cannot set field of NULL %s", name)
+			}
+			record = inner
+		}
+		record[a.fields[len(a.fields)-1]] = values[i]
+	}
+	return row, nil
+}
+
+func (e *executor) executeUpdate(s *UpdateStatement) (*data.Result, error) {
+	c := &compiler{executor: e, ctes: map[string]*cte{}, clause: "UPDATE"}
+	t, err := c.lookupTarget(s.Target, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.checkPartitionFilters(s.Target, s.Where); err != nil {
+		return nil, err
+	}
+	sc := t.scope(nil)
+	var source *relation
+	if s.From != nil {
+		if source, err = c.compileFromItem(s.From); err != nil {
+			return nil, err
+		}
+		sc.columns = append(sc.columns, source.columns...)
+		for _, rangeVar := range source.ranges {
+			sc.ranges = append(sc.ranges, rangeVariable{rangeVar.name,
+				rangeVar.start + len(t.columns), rangeVar.end + len(t.columns)})
+		}
+	}
+	uc := c.child(sc)
+	uc.clause = "WHERE clause"
+	uc.addScanFilters(s.Where)
+	where, err := uc.compileCondition(s.Where, "WHERE")
+	if err != nil {
+		return nil, err
+	}
+	uc.clause = "UPDATE"
+	assignments, err := uc.compileAssignments(t, s.Sets)
+	if err != nil {
+		return nil, err
+	}
+	if e.dryRun {
+		return dryRunResult("UPDATE"), nil
+	}
+
+	sourceRows := [][]interface{}{{}}
+	if source != nil {
+		if sourceRows, err = source.rows(&evalContext{}); err != nil {
+			return nil, err
+		}
+	}
+	width := len(t.columns)
+	updated := int64(0)
+	newRows := make([]map[string]interface{}, len(t.table.Rows))
+	for i, row := range t.rows() {
+		var match *evalContext
+		for _, sourceRow := range sourceRows {
+			ctx := &evalContext{row: append(append([]interface{}{}, row...), sourceRow...)}
+			value, err := where.eval(ctx)
+			if err != nil {
+				return nil, err
+			}
+			if value != true {
+				continue
+			}
+			if match != nil {
+				return nil, runtimeError("UPDATE/MERGE must match at most one source row for each target row")
+			}
+			match = ctx
+		}
+		if match == nil {
+			newRows[i] = t.table.Rows[i]
+			continue
+		}
+		values, err := applyAssignments(assignments, match, width)
+		if err != nil {
+			return nil, err
+		}
+		if newRows[i], err = storeRow(t.fields, values); err != nil {
+			return nil, err
+		}
+		keepPartition(newRows[i], t.table.Rows[i])
+		updated += 1
+	}
+	t.save(e.projects, newRows)
+	return dmlResult("UPDATE", 0, updated, 0), nil
+}
+
+// DELETE
+
+func (e *executor) executeDelete(s *DeleteStatement) (*data.Result, error) {
+	c := &compiler{executor: e, ctes: map[string]*cte{}, clause: "WHERE clause"}
+	t, err := c.lookupTarget(s.Target, true)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.checkPartitionFilters(s.Target, s.Where); err != nil {
+		return nil, err
+	}
+	wc := c.child(t.scope(nil))
+	wc.addScanFilters(s.Where)
+	where, err := wc.compileCondition(s.Where, "WHERE")
+	if err != nil {
+		return nil, err
+	}
+	if e.dryRun {
+		return dryRunResult("DELETE"), nil
+	}
+
+	deleted := int64(0)
+	newRows := []map[string]interface{}{}
+	for i, row := range t.rows() {
+		value, err := where.eval(&evalContext{row: row})
+		if err != nil {
+			return nil, err
+		}
+		if value == true {
+			deleted += 1
+		} else {
+			newRows = append(newRows, t.table.Rows[i])
+		}
+	}
+	t.save(e.projects, newRows)
+	return dmlResult("DELETE", 0, 0, deleted), nil
+}
+
+// MERGE
+
+type mergeClause struct {
+	kind        string
+	action      string
+	condition   *expression
+	assignments []assignment
+	indexes     []int // of the columns an INSERT sets
+	values      []expression
+}
+
+func (e *executor) executeMerge(s *MergeStatement) (*data.Result, error) {
+	c := &compiler{executor: e, ctes: map[string]*cte{}, clause: "MERGE"}
+	t, err := c.lookupTarget(s.Target, true)
+	if err != nil {
+		return nil, err
+	}
+	source, err := c.compileFromItem(s.Source)
+	if err != nil {
+		return nil, err
+	}
+	width := len(t.columns)
+	sc := t.scope(nil)
+	sc.columns = append(sc.columns, source.columns...)
+	for _, rangeVar := range source.ranges {
+		if strings.EqualFold(rangeVar.name, t.alias) {
+			return nil, queryError(s.Pos, "Duplicate table alias %s in the same FROM clause", t.alias)
+		}
+		sc.ranges = append(sc.ranges, rangeVariable{rangeVar.name, rangeVar.start + width, rangeVar.end + width})
+	}
+	mc := c.child(sc)
+	mc.clause = "ON clause"
+	on, err := mc.compileCondition(s.On, "ON")
+	if err != nil {
+		return nil, err
+	}
+
+	clauses := []mergeClause{}
+	hasMatched := false
+	for _, clause := range s.Clauses {
+		compiled := mergeClause{kind: clause.Kind, action: clause.Action}
+		hasMatched = hasMatched || clause.Kind == "MATCHED"
+		if clause.Cond != nil {
+			mc.clause = "WHEN clause"
+			condition, err := mc.compileCondition(clause.Cond, "WHEN")
+			if err != nil {
+				return nil, err
+			}
+			compiled.condition = &condition
+		}
+		mc.clause = "MERGE"
+		switch clause.Action {
+		case "UPDATE":
+			if compiled.assignments, err = mc.compileAssignments(t, clause.Sets); err != nil {
+				return nil, err
+			}
+		case "INSERT":
+			if compiled.indexes, err = t.insertColumns(clause.Columns, clause.Pos); err != nil {
+				return nil, err
+			}
+			exprs := clause.Values
+			if clause.InsertRow {
+				// INSERT ROW takes the source's columns in order
+				if len(source.columns) != len(compiled.indexes) {
+					return nil, queryError(clause.Pos, "Inserted row has wrong column count; Has %d, expected %d",
+						len(source.columns), len(compiled.indexes))
+				}
+				exprs = []Expr{}
+				for i, col := range source.columns {
+					exprs = append(exprs, &columnRef{Pos: clause.Pos, Index: width + i, Name: col.name})
+				}
+			}
+			if compiled.values, err = mc.compileInsertValues(t, compiled.indexes, exprs, clause.Pos); err != nil {
+				return nil, err
+			}
+		}
+		clauses = append(clauses, compiled)
+	}
+	if e.dryRun {
+		return dryRunResult("MERGE"), nil
+	}
+
+	sourceRows, err := source.rows(&evalContext{})
+	if err != nil {
+		return nil, err
+	}
+	targetRows := t.rows()
+	sourceMatched := make([]bool, len(sourceRows))
+	var inserted, updated, deleted int64
+
+	// firstClause finds the first clause of a kind whose condition holds.
+	firstClause := func(kind string, ctx *evalContext) (*mergeClause, error) {
+		for i := range clauses {
+			clause := &clauses[i]
+			if clause.kind != kind {
+				continue
+			}
+			if clause.condition != nil {
+				value, err := clause.condition.eval(ctx)
+				if err != nil {
+					return nil, err
+				}
+				if value != true {
+					continue
+				}
+			}
+			return clause, nil
+		}
+		return nil, nil
+	}
+
+	newRows := []map[string]interface{}{}
+	for i, targetRow := range targetRows {
+		var match *evalContext
+		for j, sourceRow := range sourceRows {
+			ctx := &evalContext{row: append(append([]interface{}{}, targetRow...), sourceRow...)}
+			value, err := on.eval(ctx)
+			if err != nil {
+				return nil, err
+			}
+			if value != true {
+				continue
+			}
+			sourceMatched[j] = true
+			if match != nil && hasMatched {
+				return nil, runtimeError("UPDATE/MERGE must match at most one source row for each target row")
+			}
+			match = ctx
+		}
+
+		kind := "MATCHED"
+		if match == nil {
+			kind = "NOT_MATCHED_BY_SOURCE"
+			match = &evalContext{row: append(append([]interface{}{}, targetRow...), make([]interface{}, len(source.columns))...)}
+		}
+		clause, err := firstClause(kind, match)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case clause == nil:
+			newRows = append(newRows, t.table.Rows[i])
+		case clause.action == "DELETE":
+			deleted += 1
+		case clause.action == "UPDATE":
+			values, err := applyAssignments(clause.assignments, match, width)
+			if err != nil {
+				return nil, err
+			}
+			stored, err := storeRow(t.fields, values)
+			if err != nil {
+				return nil, err
+			}
+			keepPartition(stored, t.table.Rows[i])
+			newRows = append(newRows, stored)
+			updated += 1
+		}
+	}
+
+	for j, sourceRow := range sourceRows {
+		if sourceMatched[j] {
+			continue
+		}
+		ctx := &evalContext{row: append(make([]interface{}, width), sourceRow...)}
+		clause, err := firstClause("NOT_MATCHED_BY_TARGET", ctx)
+		if err != nil {
+			return nil, err
+		}
+		if clause == nil {
+			continue
+		}
+		values := make([]interface{}, width)
+		for k, index := range clause.indexes {
+			if values[index], err = clause.values[k].eval(ctx); err != nil {
+				return nil, err
+			}
+		}
+		stored, err := storeRow(t.fields, values)
+		if err != nil {
+			return nil, err
+		}
+		newRows = append(newRows, stored)
+		inserted += 1
+	}
+
+	t.save(e.projects, newRows)
+	return dmlResult("MERGE", inserted, updated, deleted), nil
+}
@@ -0,0 +1,1734 @@
+package queries
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	mathrand "math/rand"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/danielstutzman/fake-bigquery/data"
+)
+
+type function struct {
+	minArgs int
+	maxArgs int // -1 for any number
+	// returns checks the argument types, giving the result type
+	returns func(args []data.Field) (data.Field, bool)
+	eval    func(args []interface{}, fields []data.Field) (interface{}, error)
+	nulls   bool // eval handles NULL arguments, rather than the result being NULL
+}
+
+// signature builds a returns function from parameter types, where the last
+// one repeats. Besides type names, NUMBER is any numeric type, STRINGY is
+// STRING or BYTES, ARRAY is any array and ANY is anything; a result of
+// ARG0 is the type of the first argument and ELEMENT is its element type.
+func signature(result string, params ...string) func(args []data.Field) (data.Field, bool) {
+	return func(args []data.Field) (data.Field, bool) {
+		for i, arg := range args {
+			if len(params) == 0 || !matchesParam(params[minInt(i, len(params)-1)], arg) {
+				return data.Field{}, false
+			}
+		}
+		switch result {
+		case "ARG0":
+			field := args[0]
+			if field.Type == "NULL" {
+				field = scalarField("INTEGER")
+				for _, arg := range args {
+					if arg.Type != "NULL" && matchesParam(params[0], arg) {
+						field = arg
+						break
+					}
+				}
+			}
+			field.Name = ""
+			return field, true
+		case "ELEMENT":
+			if args[0].Type == "NULL" {
+				return scalarField("INTEGER"), true
+			}
+			return elementField(args[0]), true
+		case "FLOAT_OR_NUMERIC":
+			for _, arg := range args {
+				if arg.Type == "NUMERIC" || arg.Type == "BIGNUMERIC" {
+					return scalarField(arg.Type), true
+				}
+			}
+			return scalarField("FLOAT"), true
+		}
+		return scalarField(result), true
+	}
+}
+
+func matchesParam(param string, arg data.Field) bool {
+	if arg.Type == "NULL" || param == "ANY" {
+		return true
+	}
+	if param == "ARRAY" {
+		return isArray(arg)
+	}
+	if isArray(arg) {
+		return false
+	}
+	switch param {
+	case "NUMBER", "FLOAT":
+		return isNumeric(arg.Type)
+	case "STRINGY":
+		return arg.Type == "STRING" || arg.Type == "BYTES"
+	}
+	return param == arg.Type
+}
+
+// isFunctionName reports whether a dotted name is a function, as opposed
+// to a path like t.a(...) that can't be called.
+func isFunctionName(name string) bool {
+	if strings.HasPrefix(name, "SAFE.") {
+		return true
+	}
+	return strings.HasPrefix(name, "NET.") || strings.HasPrefix(name, "HLL_COUNT.") ||
+		strings.HasPrefix(name, "KEYS.") || strings.HasPrefix(name, "AEAD.")
+}
+
+// isNiladicFunction reports whether a name can be called without
+// parentheses, like CURRENT_TIMESTAMP.
+func isNiladicFunction(name string) bool {
+	switch name {
+	case "CURRENT_TIMESTAMP", "CURRENT_DATE", "CURRENT_DATETIME", "CURRENT_TIME":
+		return true
+	}
+	return false
+}
+
+// DATE_PART_ARGS gives which argument of a function is a date part like
+// DAY or WEEK(MONDAY), which is written as a name rather than a string.
+var DATE_PART_ARGS = map[string]int{
+	"DATE_DIFF": 2, "DATETIME_DIFF": 2, "TIMESTAMP_DIFF": 2, "TIME_DIFF": 2,
+	"DATE_TRUNC": 1, "DATETIME_TRUNC": 1, "TIMESTAMP_TRUNC": 1, "TIME_TRUNC": 1, "LAST_DAY": 1,
+}
+
+func datePartName(e Expr) (string, bool) {
+	switch e := e.(type) {
+	case *Path:
+		if len(e.Parts) == 1 {
+			return strings.ToUpper(e.Parts[0]), true
+		}
+	case *Call:
+		if e.Name == "WEEK" && len(e.Args) == 1 {
+			if weekday, ok := datePartName(e.Args[0]); ok {
+				return "WEEK(" + weekday + ")", true
+			}
+		}
+	}
+	return "", false
+}
+
+func (c *compiler) compileCall(e *Call) (expression, error) {
+	name := e.Name
+	safe := strings.HasPrefix(name, "SAFE.")
+	name = strings.TrimPrefix(name, "SAFE.")
+
+	if e.Over != nil {
+		return c.compileWindow(e, name)
+	}
+	if _, ok := AGGREGATES[name]; ok {
+		return c.compileAggregate(e, name)
+	}
+	if _, ok := ANALYTIC_FUNCTIONS[name]; ok {
+		return expression{}, queryError(e.Pos, "Analytic function %s cannot be called without an OVER clause", name)
+	}
+	if e.Star || e.Distinct || len(e.OrderBy) > 0 || e.Limit != nil {
+		return expression{}, queryError(e.Pos, "Function %s does not support %s", name, callModifier(e))
+	}
+
+	switch name {
+	case "IF", "IFNULL", "COALESCE", "NULLIF":
+		return c.compileConditional(e, name)
+	case "CURRENT_TIMESTAMP", "CURRENT_DATE", "CURRENT_DATETIME", "CURRENT_TIME":
+		return c.compileCurrent(e, name)
+	}
+
+	fn, ok := FUNCTIONS[name]
+	if !ok {
+		return expression{}, queryError(e.Pos, "Function not found: %s", strings.ToLower(e.Name))
+	}
+	if len(e.Args) < fn.minArgs || (fn.maxArgs != -1 && len(e.Args) > fn.maxArgs) {
+		return expression{}, queryError(e.Pos, "Number of arguments does not match for function %s", name)
+	}
+
+	args := make([]expression, len(e.Args))
+	for i, arg := range e.Args {
+		if index, ok := DATE_PART_ARGS[name]; ok && i == index {
+			part, ok := datePartName(arg)
+			if !ok {
+				return expression{}, queryError(arg.position(), "A valid date part name is required")
+			}
+			args[i] = constant(scalarField("DATE_PART"), part)
+			continue
+		}
+		var err error
+		if args[i], err = c.compileExpr(arg); err != nil {
+			return expression{}, err
+		}
+	}
+
+	fields := make([]data.Field, len(args))
+	for i, arg := range args {
+		fields[i] = arg.field
+	}
+	result, ok := fn.returns(fields)
+	if !ok {
+		return expression{}, queryError(e.Pos, "No matching signature for function %s for argument types: %s",
+			name, strings.Join(fieldTypeNames(args), ", "))
+	}
+
+	return expression{field: result, eval: func(ctx *evalContext) (interface{}, error) {
+		values := make([]interface{}, len(args))
+		for i, arg := range args {
+			var err error
+			if values[i], err = arg.eval(ctx); err != nil {
+				return nil, err
+			}
+			if values[i] == nil && !fn.nulls {
+				return nil, nil
+			}
+		}
+		value, err := fn.eval(values, fields)
+		if err != nil {
+			if safe {
+				return nil, nil
+			}
+			if _, ok := err.(*data.Error); !ok {
+				err = runtimeError("%s", err)
+			}
+			return nil, err
+		}
+		return value, nil
+	}}, nil
+}
+
+func callModifier(e *Call) string {
+	switch {
+	case e.Star:
+		return "*"
+	case e.Distinct:
+		return "DISTINCT"
+	case len(e.OrderBy) > 0:
+		return "ORDER BY"
+	}
+	return "LIMIT"
+}
+
+func (c *compiler) compileConditional(e *Call, name string) (expression, error) {
+	counts := map[string][2]int{"IF": {3, 3}, "IFNULL": {2, 2}, "COALESCE": {1, -1}, "NULLIF": {2, 2}}[name]
+	if len(e.Args) < counts[0] || (counts[1] != -1 && len(e.Args) > counts[1]) {
+		return expression{}, queryError(e.Pos, "Number of arguments does not match for function %s", name)
+	}
+	args, err := c.compileExprs(e.Args)
+	if err != nil {
+		return expression{}, err
+	}
+
+	switch name {
+	case "IF":
+		if args[0].field.Type != "BOOLEAN" && args[0].field.Type != "NULL" {
+			return expression{}, queryError(e.Args[0].position(), "IF condition should return type BOOL, but returns %s",
+				typeName(args[0].field))
+		}
+		field, results, err := commonType(e.Pos, "function IF", e.Args[1:], args[1:])
+		if err != nil {
+			return expression{}, err
+		}
+		return expression{field: field, eval: func(ctx *evalContext) (interface{}, error) {
+			condition, err := args[0].eval(ctx)
+			if err != nil {
+				return nil, err
+			}
+			if condition == true {
+				return results[0].eval(ctx)
+			}
+			return results[1].eval(ctx)
+		}}, nil
+
+	case "NULLIF":
+		if !comparable(args[0].field, args[1].field, true) {
+			return expression{}, queryError(e.Pos, "No matching signature for function NULLIF for argument types: %s",
+				strings.Join(fieldTypeNames(args), ", "))
+		}
+		return expression{field: args[0].field, eval: func(ctx *evalContext) (interface{}, error) {
+			value, other, ok, err := evalBoth(ctx, args[0], args[1])
+			if err != nil || (ok && compareWith("=", value, other)) {
+				return nil, err
+			}
+			return value, nil
+		}}, nil
+
+	default: // IFNULL, COALESCE
+		field, results, err := commonType(e.Pos, "function "+name, e.Args, args)
+		if err != nil {
+			return expression{}, err
+		}
+		return expression{field: field, eval: func(ctx *evalContext) (interface{}, error) {
+			for _, result := range results {
+				value, err := result.eval(ctx)
+				if value != nil || err != nil {
+					return value, err
+				}
+			}
+			return nil, nil
+		}}, nil
+	}
+}
+
+func (c *compiler) compileCurrent(e *Call, name string) (expression, error) {
+	fieldType := strings.TrimPrefix(name, "CURRENT_")
+	maxArgs := 1
+	if fieldType == "TIMESTAMP" {
+		maxArgs = 0
+	}
+	if len(e.Args) > maxArgs {
+		return expression{}, queryError(e.Pos, "Number of arguments does not match for function %s", name)
+	}
+	now := c.executor.now
+	if len(e.Args) == 0 {
+		return constant(scalarField(fieldType), fromTime(fieldType, now)), nil
+	}
+	zone, err := c.compileExpr(e.Args[0])
+	if err != nil {
+		return expression{}, err
+	}
+	if zone.field.Type != "STRING" {
+		return expression{}, queryError(e.Pos, "No matching signature for function %s for argument types: %s",
+			name, typeName(zone.field))
+	}
+	return expression{field: scalarField(fieldType), eval: func(ctx *evalContext) (interface{}, error) {
+		value, err := zone.eval(ctx)
+		if value == nil || err != nil {
+			return nil, err
+		}
+		local, err := inZone(now, value.(string))
+		if err != nil {
+			return nil, err
+		}
+		return fromTime(fieldType, local), nil
+	}}, nil
+}
+
+// FUNCTIONS are the scalar functions, other than the conditional ones
+// like IF, which only evaluate some of their arguments.
+var FUNCTIONS = map[string]function{}
+
+func init() {
+	for name, fn := range map[string]function{
+		// Strings
+		"CONCAT": {1, -1, signature("ARG0", "STRINGY"), func(args []interface{}, fields []data.Field) (interface{}, error) {
+			if _, ok := args[0].([]byte); ok {
+				var result []byte
+				for _, arg := range args {
+					result = append(result, arg.([]byte)...)
+				}
+				return result, nil
+			}
+			var result strings.Builder
+			for _, arg := range args {
+				result.WriteString(arg.(string))
+			}
+			return result.String(), nil
+		}, false},
+		"LENGTH":           {1, 1, signature("INTEGER", "STRINGY"), evalLength, false},
+		"CHAR_LENGTH":      {1, 1, signature("INTEGER", "STRING"), evalLength, false},
+		"CHARACTER_LENGTH": {1, 1, signature("INTEGER", "STRING"), evalLength, false},
+		"BYTE_LENGTH": {1, 1, signature("INTEGER", "STRINGY"), func(args []interface{}, fields []data.Field) (interface{}, error) {
+			return int64(len(toString(args[0]))), nil
+		}, false},
+		"LOWER": {1, 1, signature("ARG0", "STRINGY"), stringFunction(strings.ToLower), false},
+		"UPPER": {1, 1, signature("ARG0", "STRINGY"), stringFunction(strings.ToUpper), false},
+		"TRIM":  {1, 2, signature("ARG0", "STRINGY"), trimFunction(strings.TrimSpace, strings.Trim), false},
+		"LTRIM": {1, 2, signature("ARG0", "STRINGY"), trimFunction(func(s string) string {
+			return strings.TrimLeftFunc(s, unicode.IsSpace)
+		}, strings.TrimLeft), false},
+		"RTRIM": {1, 2, signature("ARG0", "STRINGY"), trimFunction(func(s string) string {
+			return strings.TrimRightFunc(s, unicode.IsSpace)
+		}, strings.TrimRight), false},
+		"SUBSTR":    {2, 3, substrSignature, evalSubstr, false},
+		"SUBSTRING": {2, 3, substrSignature, evalSubstr, false},
+		"LEFT": {2, 2, substrSignature, func(args []interface{}, fields []data.Field) (interface{}, error) {
+			if args[1].(int64) < 0 {
+				return nil, runtimeError("Second argument in LEFT() cannot be negative")
+			}
+			return evalSubstr([]interface{}{args[0], int64(1), args[1]}, fields)
+		}, false},
+		"RIGHT": {2, 2, substrSignature, func(args []interface{}, fields []data.Field) (interface{}, error) {
+			length := args[1].(int64)
+			if length < 0 {
+				return nil, runtimeError("Second argument in RIGHT() cannot be negative")
+			}
+			runes := stringRunes(args[0])
+			if length > int64(len(runes)) {
+				length = int64(len(runes))
+			}
+			return fromRunes(args[0], runes[int64(len(runes))-length:]), nil
+		}, false},
+		"REPLACE": {3, 3, signature("ARG0", "STRINGY"), func(args []interface{}, fields []data.Field) (interface{}, error) {
+			if toString(args[1]) == "" {
+				return args[0], nil
+			}
+			return sameKind(args[0], strings.Replace(toString(args[0]), toString(args[1]), toString(args[2]), -1)), nil
+		}, false},
+		"STARTS_WITH": {2, 2, signature("BOOLEAN", "STRINGY"), func(args []interface{}, fields []data.Field) (interface{}, error) {
+			return strings.HasPrefix(toString(args[0]), toString(args[1])), nil
+		}, false},
+		"ENDS_WITH": {2, 2, signature("BOOLEAN", "STRINGY"), func(args []interface{}, fields []data.Field) (interface{}, error) {
+			return strings.HasSuffix(toString(args[0]), toString(args[1])), nil
+		}, false},
+		"STRPOS": {2, 2, signature("INTEGER", "STRINGY"), func(args []interface{}, fields []data.Field) (interface{}, error) {
+			index := strings.Index(toString(args[0]), toString(args[1]))
+			if index == -1 {
+				return int64(0), nil
+			}
+			if _, ok := args[0].([]byte); ok {
+				return int64(index + 1), nil
+			}
+			return int64(utf8.RuneCountInString(toString(args[0])[:index]) + 1), nil
+		}, false},
+		"CONTAINS_SUBSTR": {2, 2, signature("BOOLEAN", "ANY", "STRING"), func(args []interface{}, fields []data.Field) (interface{}, error) {
+			return strings.Contains(strings.ToLower(formatValue(fields[0], args[0])), strings.ToLower(args[1].(string))), nil
+		}, false},
+		"SPLIT": {1, 2, func(args []data.Field) (data.Field, bool) {
+			field, ok := signature("ARG0", "STRINGY")(args)
+			return arrayField(field), ok
+		}, func(args []interface{}, fields []data.Field) (interface{}, error) {
+			delimiter := ","
+			if len(args) == 2 {
+				delimiter = toString(args[1])
+			}
+			text := toString(args[0])
+			if text == "" {
+				return []interface{}{}, nil
+			}
+			parts := []string{}
+			if delimiter == "" {
+				for _, r := range text {
+					parts = append(parts, string(r))
+				}
+			} else {
+				parts = strings.Split(text, delimiter)
+			}
+			elements := make([]interface{}, len(parts))
+			for i, part := range parts {
+				elements[i] = sameKind(args[0], part)
+			}
+			return elements, nil
+		}, false},
+		"REVERSE": {1, 1, signature("ARG0", "STRINGY"), func(args []interface{}, fields []data.Field) (interface{}, error) {
+			runes := stringRunes(args[0])
+			for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+				runes[i], runes[j] = runes[j], runes[i]
+			}
+			return fromRunes(args[0], runes), nil
+		}, false},
+		"REPEAT": {2, 2, signature("ARG0", "STRINGY", "INTEGER"), func(args []interface{}, fields []data.Field) (interface{}, error) {
+			count := args[1].(int64)
+			if count < 0 {
+				return nil, runtimeError("Second argument in REPEAT() cannot be negative")
+			}
+			return sameKind(args[0], strings.Repeat(toString(args[0]), int(count))), nil
+		}, false},
+		"LPAD": {2, 3, padSignature, padFunction(true), false},
+		"RPAD": {2, 3, padSignature, padFunction(false), false},
+		"INITCAP": {1, 2, signature("STRING", "STRING"), func(args []interface{}, fields []data.Field) (interface{}, error) {
+			delimiters := " [](){}/|\n\t\r\f\v<>!?,;.:-_"
+			if len(args) == 2 {
+				delimiters = args[1].(string)
+			}
+			var result strings.Builder
+			startOfWord := true
+			for _, r := range args[0].(string) {
+				if strings.ContainsRune(delimiters, r) {
+					startOfWord = true
+					result.WriteRune(r)
+				} else if startOfWord {
+					result.WriteRune(unicode.ToUpper(r))
+					startOfWord = false
+				} else {
+					result.WriteRune(unicode.ToLower(r))
+				}
+			}
+			return result.String(), nil
+		}, false},
+		"ASCII": {1, 1, signature("INTEGER", "STRINGY"), func(args []interface{}, fields []data.Field) (interface{}, error) {
+			text := toString(args[0])
+			if text == "" {
+				return int64(0), nil
+			}
+			return int64(text[0]), nil
+		}, false},
+		"UNICODE": {1, 1, signature("INTEGER", "STRING"), func(args []interface{}, fields []data.Field) (interface{}, error) {
+			for _, r := range args[0].(string) {
+				return int64(r), nil
+			}
+			return int64(0), nil
+		}, false},
+		"CHR": {1, 1, signature("STRING", "INTEGER"), func(args []interface{}, fields []data.Field) (interface{}, error) {
+			if args[0].(int64) == 0 {
+				return "", nil
+			}
+			return string(rune(args[0].(int64))), nil
+		}, false},
+		"TO_HEX": {1, 1, signature("STRING", "BYTES"), func(args []interface{}, fields []data.Field) (interface{}, error) {
+			return hex.EncodeToString(args[0].([]byte)), nil
+		}, false},
+		"FROM_HEX": {1, 1, signature("BYTES", "STRING"), func(args []interface{}, fields []data.Field) (interface{}, error) {
+			text := args[0].(string)
+			if len(text)%2 == 1 {
+				text = "0" + text
+			}
+			decoded, err := hex.DecodeString(text)
+			if err != nil {
+				return nil, runtimeError("Failed to decode invalid hexadecimal string: \"%s\"", args[0])
+			}
+			return decoded, nil
+		}, false},
+		"TO_BASE64": {1, 1, signature("STRING", "BYTES"), func(args []interface{}, fields []data.Field) (interface{}, error) {
+			return base64.StdEncoding.EncodeToString(args[0].([]byte)), nil
+		}, false},
+		"FROM_BASE64": {1, 1, signature("BYTES", "STRING"), func(args []interface{}, fields []data.Field) (interface{}, error) {
+			decoded, err := base64.StdEncoding.DecodeString(args[0].(string))
+			if err != nil {
+				return nil, runtimeError("Failed to decode invalid base64 string: \"%s\"", args[0])
+			}
+			return decoded, nil
+		}, false},
+		"SAFE_CONVERT_BYTES_TO_STRING": {1, 1, signature("STRING", "BYTES"), func(args []interface{}, fields []data.Field) (interface{}, error) {
+			return strings.ToValidUTF8(string(args[0].([]byte)), "�"), nil
+		}, false},
+		"FORMAT": {1, -1, func(args []data.Field) (data.Field, bool) {
+			return scalarField("STRING"), args[0].Type == "STRING" || args[0].Type == "NULL"
+		}, evalFormat, true},
+		"MD5":    {1, 1, signature("BYTES", "STRINGY"), hashFunction(func(b []byte) []byte { h := md5.Sum(b); return h[:] }), false},
+		"SHA1":   {1, 1, signature("BYTES", "STRINGY"), hashFunction(func(b []byte) []byte { h := sha1.Sum(b); return h[:] }), false},
+		"SHA256": {1, 1, signature("BYTES", "STRINGY"), hashFunction(func(b []byte) []byte { h := sha256.Sum256(b); return h[:] }), false},
+		"SHA512": {1, 1, signature("BYTES", "STRINGY"), hashFunction(func(b []byte) []byte { h := sha512.Sum512(b); return h[:] }), false},
+		"GENERATE_UUID": {0, 0, signature("STRING"), func(args []interface{}, fields []data.Field) (interface{}, error) {
+			b := make([]byte, 16)
+			rand.Read(b)
+			b[6] = b[6]&0x0f | 0x40
+			b[8] = b[8]&0x3f | 0x80
+			return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+		}, false},
+
+		// Regular expressions
+		"REGEXP_CONTAINS": {2, 2, signature("BOOLEAN", "STRINGY"), func(args []interface{}, fields []data.Field) (interface{}, error) {
+			compiled, err := compileRegexp(args[1])
+			if err != nil {
+				return nil, err
+			}
+			return compiled.MatchString(toString(args[0])), nil
+		}, false},
+		"REGEXP_EXTRACT": {2, 4, signature("ARG0", "STRINGY", "STRINGY", "INTEGER"), func(args []interface{}, fields []data.Field) (interface{}, error) {
+			matches, err := regexpMatches(args[0], args[1])
+			if err != nil {
+				return nil, err
+			}
+			position, occurrence := int64(1), int64(1)
+			if len(args) > 2 {
+				position = args[2].(int64)
+			}
+			if len(args) > 3 {
+				occurrence = args[3].(int64)
+			}
+			if position < 1 || occurrence < 1 {
+				return nil, runtimeError("Position and occurrence in REGEXP_EXTRACT must be positive")
+			}
+			for _, match := range matches {
+				if int64(match.start) < position-1 {
+					continue
+				}
+				occurrence -= 1
+				if occurrence == 0 {
+					return sameKind(args[0], match.text), nil
+				}
+			}
+			return nil, nil
+		}, false},
+		"REGEXP_EXTRACT_ALL": {2, 2, func(args []data.Field) (data.Field, bool) {
+			field, ok := signature("ARG0", "STRINGY")(args)
+			return arrayField(field), ok
+		}, func(args []interface{}, fields []data.Field) (interface{}, error) {
+			matches, err := regexpMatches(args[0], args[1])
+			if err != nil {
+				return nil, err
+			}
+			elements := make([]interface{}, len(matches))
+			for i, match := range matches {
+				elements[i] = sameKind(args[0], match.text)
+			}
+			return elements, nil
+		}, false},
+		"REGEXP_REPLACE": {3, 3, signature("ARG0", "STRINGY"), func(args []interface{}, fields []data.Field) (interface{}, error) {
+			compiled, err := compileRegexp(args[1])
+			if err != nil {
+				return nil, err
+			}
+			// \1 in the replacement refers to a group, which Go writes as ${1}
+			replacement := regexp.MustCompile(`\\(\d)`).ReplaceAllString(
+				strings.Replace(toString(args[2]), "$", "$$", -1), "$${$1}")
+			replacement = strings.Replace(replacement, `\\`, `\`, -1)
+			return sameKind(args[0], compiled.ReplaceAllString(toString(args[0]), replacement)), nil
+		}, false},
+
+		// JSON
+		"TO_JSON_STRING": {1, 2, signature("STRING", "ANY", "BOOLEAN"), func(args []interface{}, fields []data.Field) (interface{}, error) {
+			value := toJSONValue(fields[0], args[0])
+			var encoded []byte
+			if len(args) == 2 && args[1] == true {
+				encoded, _ = json.MarshalIndent(value, "", "  ")
+			} else {
+				encoded, _ = json.Marshal(value)
+			}
+			return string(encoded), nil
+		}, true},
+		"JSON_EXTRACT_SCALAR": {1, 2, signature("STRING", "ANY", "STRING"), evalJSONScalar, false},
+		"JSON_VALUE":          {1, 2, signature("STRING", "ANY", "STRING"), evalJSONScalar, false},
+		"JSON_EXTRACT": {1, 2, signature("ARG0", "ANY", "STRING"), func(args []interface{}, fields []data.Field) (interface{}, error) {
+			node, ok, err := jsonPath(args)
+			if !ok || err != nil {
+				return nil, err
+			}
+			encoded, _ := json.Marshal(node)
+			return string(encoded), nil
+		}, false},
+		"JSON_QUERY": {1, 2, signature("ARG0", "ANY", "STRING"), func(args []interface{}, fields []data.Field) (interface{}, error) {
+			node, ok, err := jsonPath(args)
+			if !ok || err != nil {
+				return nil, err
+			}
+			encoded, _ := json.Marshal(node)
+			return string(encoded), nil
+		}, false},
+		"JSON_EXTRACT_ARRAY": {1, 2, func(args []data.Field) (data.Field, bool) {
+			return arrayField(scalarField(args[0].Type)), true
+		}, func(args []interface{}, fields []data.Field) (interface{}, error) {
+			node, ok, err := jsonPath(args)
+			elements, isArray := node.([]interface{})
+			if !ok || err != nil || !isArray {
+				return nil, err
+			}
+			result := make([]interface{}, len(elements))
+			for i, element := range elements {
+				encoded, _ := json.Marshal(element)
+				result[i] = string(encoded)
+			}
+			return result, nil
+		}, false},
+		"JSON_EXTRACT_STRING_ARRAY": {1, 2, stringArraySignature, evalJSONStringArray, false},
+		"JSON_VALUE_ARRAY":          {1, 2, stringArraySignature, evalJSONStringArray, false},
+		"PARSE_JSON": {1, 1, signature("JSON", "STRING"), func(args []interface{}, fields []data.Field) (interface{}, error) {
+			var node interface{}
+			if err := json.Unmarshal([]byte(args[0].(string)), &node); err != nil {
+				return nil, runtimeError("Invalid input to PARSE_JSON: %s", err)
+			}
+			encoded, _ := json.Marshal(node)
+			return string(encoded), nil
+		}, false},
+		"TO_JSON": {1, 1, signature("JSON", "ANY"), func(args []interface{}, fields []data.Field) (interface{}, error) {
+			encoded, _ := json.Marshal(toJSONValue(fields[0], args[0]))
+			return string(encoded), nil
+		}, true},
+
+		// Math
+		"ABS": {1, 1, signature("ARG0", "NUMBER"), func(args []interface{}, fields []data.Field) (interface{}, error) {
+			if number, ok := args[0].(int64); ok {
+				if number == math.MinInt64 {
+					return nil, runtimeError("int64 overflow: ABS(%d)", number)
+				} else if number < 0 {
+					return -number, nil
+				}
+				return number, nil
+			}
+			return math.Abs(args[0].(float64)), nil
+		}, false},
+		"SIGN": {1, 1, signature("ARG0", "NUMBER"), func(args []interface{}, fields []data.Field) (interface{}, error) {
+			if number, ok := args[0].(int64); ok {
+				return int64(compareInts(number, 0)), nil
+			}
+			number := args[0].(float64)
+			if math.IsNaN(number) {
+				return number, nil
+			}
+			return float64(compareFloats(number, 0)), nil
+		}, false},
+		"ROUND": {1, 2, roundSignature, roundFunction(func(x float64) float64 {
+			return math.Round(x)
+		}), false},
+		"TRUNC":   {1, 2, roundSignature, roundFunction(math.Trunc), false},
+		"CEIL":    {1, 1, roundSignature, roundFunction(math.Ceil), false},
+		"CEILING": {1, 1, roundSignature, roundFunction(math.Ceil), false},
+		"FLOOR":   {1, 1, roundSignature, roundFunction(math.Floor), false},
+		"SQRT": {1, 1, signature("FLOAT_OR_NUMERIC", "NUMBER"), floatFunction(func(x float64) (float64, error) {
+			if x < 0 {
+				return 0, runtimeError("Argument to SQRT cannot be negative: %v", x)
+			}
+			return math.Sqrt(x), nil
+		}), false},
+		"EXP": {1, 1, signature("FLOAT_OR_NUMERIC", "NUMBER"), floatFunction(func(x float64) (float64, error) {
+			return math.Exp(x), nil
+		}), false},
+		"LN": {1, 1, signature("FLOAT_OR_NUMERIC", "NUMBER"), floatFunction(func(x float64) (float64, error) {
+			if x <= 0 {
+				return 0, runtimeError("Argument to LN cannot be zero or negative: %v", x)
+			}
+			return math.Log(x), nil
+		}), false},
+		"LOG10": {1, 1, signature("FLOAT_OR_NUMERIC", "NUMBER"), floatFunction(func(x float64) (float64, error) {
+			if x <= 0 {
+				return 0, runtimeError("Argument to LOG10 cannot be zero or negative: %v", x)
+			}
+			return math.Log10(x), nil
+		}), false},
+		"LOG": {1, 2, signature("FLOAT_OR_NUMERIC", "NUMBER"), func(args []interface{}, fields []data.Field) (interface{}, error) {
+			x := toFloat(args[0])
+			if x <= 0 {
+				return nil, runtimeError("Argument to LOG cannot be zero or negative: %v", x)
+			}
+			if len(args) == 1 {
+				return math.Log(x), nil
+			}
+			return math.Log(x) / math.Log(toFloat(args[1])), nil
+		}, false},
+		"POW":   {2, 2, signature("FLOAT_OR_NUMERIC", "NUMBER"), evalPow, false},
+		"POWER": {2, 2, signature("FLOAT_OR_NUMERIC", "NUMBER"), evalPow, false},
+		"MOD": {2, 2, divisionSignature, func(args []interface{}, fields []data.Field) (interface{}, error) {
+			if b, ok := args[1].(int64); ok {
+				if b == 0 {
+					return nil, runtimeError("division by zero: MOD(%d, 0)", args[0])
+				}
+				return args[0].(int64) % b, nil
+			}
+			if toFloat(args[1]) == 0 {
+				return nil, runtimeError("division by zero: MOD(%v, 0)", args[0])
+			}
+			return math.Mod(toFloat(args[0]), toFloat(args[1])), nil
+		}, false},
+		"DIV": {2, 2, divisionSignature, func(args []interface{}, fields []data.Field) (interface{}, error) {
+			if b, ok := args[1].(int64); ok {
+				if b == 0 {
+					return nil, runtimeError("division by zero: DIV(%d, 0)", args[0])
+				}
+				return args[0].(int64) / b, nil
+			}
+			if toFloat(args[1]) == 0 {
+				return nil, runtimeError("division by zero: DIV(%v, 0)", args[0])
+			}
+			return math.Trunc(toFloat(args[0]) / toFloat(args[1])), nil
+		}, false},
+		"SAFE_DIVIDE": {2, 2, signature("FLOAT_OR_NUMERIC", "NUMBER"), func(args []interface{}, fields []data.Field) (interface{}, error) {
+			if toFloat(args[1]) == 0 {
+				return nil, nil
+			}
+			return toFloat(args[0]) / toFloat(args[1]), nil
+		}, false},
+		"IEEE_DIVIDE": {2, 2, signature("FLOAT", "NUMBER"), func(args []interface{}, fields []data.Field) (interface{}, error) {
+			a, b := toFloat(args[0]), toFloat(args[1])
+			if b == 0 {
+				switch {
+				case a == 0 || math.IsNaN(a):
+					return math.NaN(), nil
+				case (a > 0) == !math.Signbit(b):
+					return math.Inf(1), nil
+				default:
+					return math.Inf(-1), nil
+				}
+			}
+			return a / b, nil
+		}, false},
+		"SAFE_ADD":      {2, 2, arithmeticSignature("+"), safeArithmetic("+"), false},
+		"SAFE_SUBTRACT": {2, 2, arithmeticSignature("-"), safeArithmetic("-"), false},
+		"SAFE_MULTIPLY": {2, 2, arithmeticSignature("*"), safeArithmetic("*"), false},
+		"SAFE_NEGATE": {1, 1, signature("ARG0", "NUMBER"), func(args []interface{}, fields []data.Field) (interface{}, error) {
+			if number, ok := args[0].(int64); ok {
+				if number == math.MinInt64 {
+					return nil, nil
+				}
+				return -number, nil
+			}
+			return -args[0].(float64), nil
+		}, false},
+		"GREATEST": {1, -1, supertypeSignature, extremeFunction(1), true},
+		"LEAST":    {1, -1, supertypeSignature, extremeFunction(-1), true},
+		"RAND": {0, 0, signature("FLOAT"), func(args []interface{}, fields []data.Field) (interface{}, error) {
+			return mathrand.Float64(), nil
+		}, false},
+		"IS_NAN": {1, 1, signature("BOOLEAN", "NUMBER"), func(args []interface{}, fields []data.Field) (interface{}, error) {
+			return math.IsNaN(toFloat(args[0])), nil
+		}, false},
+		"IS_INF": {1, 1, signature("BOOLEAN", "NUMBER"), func(args []interface{}, fields []data.Field) (interface{}, error) {
+			return math.IsInf(toFloat(args[0]), 0), nil
+		}, false},
+		"SIN":  {1, 1, signature("FLOAT", "NUMBER"), floatFunction(noError(math.Sin)), false},
+		"COS":  {1, 1, signature("FLOAT", "NUMBER"), floatFunction(noError(math.Cos)), false},
+		"TAN":  {1, 1, signature("FLOAT", "NUMBER"), floatFunction(noError(math.Tan)), false},
+		"ASIN": {1, 1, signature("FLOAT", "NUMBER"), floatFunction(noError(math.Asin)), false},
+		"ACOS": {1, 1, signature("FLOAT", "NUMBER"), floatFunction(noError(math.Acos)), false},
+		"ATAN": {1, 1, signature("FLOAT", "NUMBER"), floatFunction(noError(math.Atan)), false},
+		"ATAN2": {2, 2, signature("FLOAT", "NUMBER"), func(args []interface{}, fields []data.Field) (interface{}, error) {
+			return math.Atan2(toFloat(args[0]), toFloat(args[1])), nil
+		}, false},
+		"RANGE_BUCKET": {2, 2, func(args []data.Field) (data.Field, bool) {
+			return scalarField("INTEGER"), (args[1].Type == "NULL" || isArray(args[1])) && !isArray(args[0])
+		}, func(args []interface{}, fields []data.Field) (interface{}, error) {
+			if isNaN(args[0]) {
+				return nil, nil
+			}
+			count := int64(0)
+			for _, boundary := range args[1].([]interface{}) {
+				if boundary == nil {
+					return nil, runtimeError("Elements in RANGE_BUCKET array cannot be NULL")
+				}
+				if compareValues(args[0], boundary) < 0 {
+					break
+				}
+				count += 1
+			}
+			return count, nil
+		}, false},
+
+		// Arrays
+		"ARRAY_LENGTH": {1, 1, signature("INTEGER", "ARRAY"), func(args []interface{}, fields []data.Field) (interface{}, error) {
+			return int64(len(args[0].([]interface{}))), nil
+		}, false},
+		"ARRAY_CONCAT": {1, -1, supertypeSignature, func(args []interface{}, fields []data.Field) (interface{}, error) {
+			result := []interface{}{}
+			for _, arg := range args {
+				result = append(result, arg.([]interface{})...)
+			}
+			return result, nil
+		}, false},
+		"ARRAY_REVERSE": {1, 1, signature("ARG0", "ARRAY"), func(args []interface{}, fields []data.Field) (interface{}, error) {
+			elements := args[0].([]interface{})
+			reversed := make([]interface{}, len(elements))
+			for i, element := range elements {
+				reversed[len(elements)-1-i] = element
+			}
+			return reversed, nil
+		}, false},
+		"ARRAY_TO_STRING": {2, 3, func(args []data.Field) (data.Field, bool) {
+			element := elementField(args[0])
+			return scalarField(element.Type), isArray(args[0]) && (element.Type == "STRING" || element.Type == "BYTES")
+		}, func(args []interface{}, fields []data.Field) (interface{}, error) {
+			parts := []string{}
+			for _, element := range args[0].([]interface{}) {
+				if element != nil {
+					parts = append(parts, toString(element))
+				} else if len(args) == 3 && args[2] != nil {
+					parts = append(parts, toString(args[2]))
+				}
+			}
+			return sameKind(args[1], strings.Join(parts, toString(args[1]))), nil
+		}, false},
+		"ARRAY_FIRST": {1, 1, signature("ELEMENT", "ARRAY"), func(args []interface{}, fields []data.Field) (interface{}, error) {
+			elements := args[0].([]interface{})
+			if len(elements) == 0 {
+				return nil, runtimeError("ARRAY_FIRST cannot get the first element of an empty array")
+			}
+			return elements[0], nil
+		}, false},
+		"ARRAY_LAST": {1, 1, signature("ELEMENT", "ARRAY"), func(args []interface{}, fields []data.Field) (interface{}, error) {
+			elements := args[0].([]interface{})
+			if len(elements) == 0 {
+				return nil, runtimeError("ARRAY_LAST cannot get the last element of an empty array")
+			}
+			return elements[len(elements)-1], nil
+		}, false},
+		"GENERATE_ARRAY": {2, 3, func(args []data.Field) (data.Field, bool) {
+			field, ok := signature("ARG0", "NUMBER")(args)
+			for _, arg := range args {
+				if arg.Type != "INTEGER" && arg.Type != "NULL" {
+					field.Type = "FLOAT"
+				}
+			}
+			return arrayField(field), ok
+		}, func(args []interface{}, fields []data.Field) (interface{}, error) {
+			result := []interface{}{}
+			if _, isInt := args[0].(int64); isInt && fields[1].Type == "INTEGER" &&
+				(len(args) == 2 || fields[2].Type == "INTEGER") {
+				start, end, step := args[0].(int64), args[1].(int64), int64(1)
+				if len(args) == 3 {
+					step = args[2].(int64)
+				}
+				if step == 0 {
+					return nil, runtimeError("Sequence step cannot be 0.")
+				}
+				for i := start; (step > 0 && i <= end) || (step < 0 && i >= end); i += step {
+					result = append(result, i)
+				}
+				return result, nil
+			}
+			start, end, step := toFloat(args[0]), toFloat(args[1]), 1.0
+			if len(args) == 3 {
+				step = toFloat(args[2])
+			}
+			if step == 0 {
+				return nil, runtimeError("Sequence step cannot be 0.")
+			}
+			for i := start; (step > 0 && i <= end) || (step < 0 && i >= end); i += step {
+				result = append(result, i)
+			}
+			return result, nil
+		}, false},
+		"GENERATE_DATE_ARRAY": {2, 3, func(args []data.Field) (data.Field, bool) {
+			ok := matchesParam("DATE", args[0]) && matchesParam("DATE", args[1]) &&
+				(len(args) == 2 || matchesParam("INTERVAL", args[2]))
+			return arrayField(scalarField("DATE")), ok
+		}, func(args []interface{}, fields []data.Field) (interface{}, error) {
+			step := interval{days: 1}
+			if len(args) == 3 {
+				step = args[2].(interval)
+			}
+			if step == (interval{}) {
+				return nil, runtimeError("Sequence step cannot be 0.")
+			}
+			start, end := toTime("DATE", args[0]), toTime("DATE", args[1])
+			forward := step.addTo(start).After(start)
+			result := []interface{}{}
+			for i := 0; ; i++ {
+				current := interval{months: step.months * int64(i), days: step.days * int64(i)}.addTo(start)
+				if (forward && current.After(end)) || (!forward && current.Before(end)) {
+					break
+				}
+				result = append(result, fromTime("DATE", current))
+			}
+			return result, nil
+		}, false},
+
+		// Dates and times
+		"DATE": {1, 3, func(args []data.Field) (data.Field, bool) {
+			switch len(args) {
+			case 3:
+				return signature("DATE", "INTEGER")(args)
+			case 2:
+				return signature("DATE", "TIMESTAMP", "STRING")(args)
+			}
+			ok := args[0].Type == "TIMESTAMP" || args[0].Type == "DATETIME" || args[0].Type == "DATE" ||
+				args[0].Type == "STRING" || args[0].Type == "NULL"
+			return scalarField("DATE"), ok && !isArray(args[0])
+		}, func(args []interface{}, fields []data.Field) (interface{}, error) {
+			if len(args) == 3 {
+				return civilDate(args[0].(int64), args[1].(int64), args[2].(int64))
+			}
+			if len(args) == 2 {
+				local, err := inZone(args[0].(time.Time), args[1].(string))
+				return fromTime("DATE", local), err
+			}
+			return castValue(args[0], fields[0], scalarField("DATE"))
+		}, false},
+		"DATETIME": {1, 6, func(args []data.Field) (data.Field, bool) {
+			switch len(args) {
+			case 6:
+				return signature("DATETIME", "INTEGER")(args)
+			case 2:
+				if args[0].Type == "TIMESTAMP" {
+					return signature("DATETIME", "TIMESTAMP", "STRING")(args)
+				}
+				return signature("DATETIME", "DATE", "TIME")(args)
+			case 1:
+				ok := args[0].Type == "TIMESTAMP" || args[0].Type == "DATETIME" || args[0].Type == "DATE" ||
+					args[0].Type == "STRING" || args[0].Type == "NULL"
+				return scalarField("DATETIME"), ok && !isArray(args[0])
+			}
+			return data.Field{}, false
+		}, func(args []interface{}, fields []data.Field) (interface{}, error) {
+			switch len(args) {
+			case 6:
+				date, err := civilDate(args[0].(int64), args[1].(int64), args[2].(int64))
+				if err != nil {
+					return nil, err
+				}
+				clock, err := civilTime(args[3].(int64), args[4].(int64), args[5].(int64))
+				if err != nil {
+					return nil, err
+				}
+				return date.(string) + "T" + clock.(string), nil
+			case 2:
+				if t, ok := args[0].(time.Time); ok {
+					local, err := inZone(t, args[1].(string))
+					return fromTime("DATETIME", local), err
+				}
+				return args[0].(string) + "T" + args[1].(string), nil
+			}
+			return castValue(args[0], fields[0], scalarField("DATETIME"))
+		}, false},
+		"TIME": {1, 3, func(args []data.Field) (data.Field, bool) {
+			switch len(args) {
+			case 3:
+				return signature("TIME", "INTEGER")(args)
+			case 2:
+				return signature("TIME", "TIMESTAMP", "STRING")(args)
+			}
+			ok := args[0].Type == "TIMESTAMP" || args[0].Type == "DATETIME" || args[0].Type == "TIME" ||
+				args[0].Type == "STRING" || args[0].Type == "NULL"
+			return scalarField("TIME"), ok && !isArray(args[0])
+		}, func(args []interface{}, fields []data.Field) (interface{}, error) {
+			if len(args) == 3 {
+				return civilTime(args[0].(int64), args[1].(int64), args[2].(int64))
+			}
+			if len(args) == 2 {
+				local, err := inZone(args[0].(time.Time), args[1].(string))
+				return fromTime("TIME", local), err
+			}
+			return castValue(args[0], fields[0], scalarField("TIME"))
+		}, false},
+		"TIMESTAMP": {1, 2, func(args []data.Field) (data.Field, bool) {
+			ok := args[0].Type == "STRING" || args[0].Type == "DATE" || args[0].Type == "DATETIME" ||
+				args[0].Type == "TIMESTAMP" || args[0].Type == "NULL"
+			return scalarField("TIMESTAMP"), ok && !isArray(args[0]) && (len(args) == 1 || matchesParam("STRING", args[1]))
+		}, func(args []interface{}, fields []data.Field) (interface{}, error) {
+			if len(args) == 2 && fields[0].Type != "TIMESTAMP" {
+				if fields[0].Type == "STRING" {
+					if parsed, err := data.ParseDatetime(args[0].(string)); err == nil {
+						return fromZone(parsed, args[1].(string))
+					}
+				} else {
+					return fromZone(toTime(fields[0].Type, args[0]), args[1].(string))
+				}
+			}
+			return castValue(args[0], fields[0], scalarField("TIMESTAMP"))
+		}, false},
+		"DATE_ADD":      {2, 2, signature("DATE", "DATE", "INTERVAL"), addFunction("DATE", 1), false},
+		"DATE_SUB":      {2, 2, signature("DATE", "DATE", "INTERVAL"), addFunction("DATE", -1), false},
+		"DATETIME_ADD":  {2, 2, signature("DATETIME", "DATETIME", "INTERVAL"), addFunction("DATETIME", 1), false},
+		"DATETIME_SUB":  {2, 2, signature("DATETIME", "DATETIME", "INTERVAL"), addFunction("DATETIME", -1), false},
+		"TIMESTAMP_ADD": {2, 2, signature("TIMESTAMP", "TIMESTAMP", "INTERVAL"), addFunction("TIMESTAMP", 1), false},
+		"TIMESTAMP_SUB": {2, 2, signature("TIMESTAMP", "TIMESTAMP", "INTERVAL"), addFunction("TIMESTAMP", -1), false},
+		"TIME_ADD":      {2, 2, signature("TIME", "TIME", "INTERVAL"), addFunction("TIME", 1), false},
+		"TIME_SUB":      {2, 2, signature("TIME", "TIME", "INTERVAL"), addFunction("TIME", -1), false},
+		"DATE_DIFF": {3, 3, func(args []data.Field) (data.Field, bool) {
+			// DATE_DIFF also accepts DATETIMEs and TIMESTAMPs
+			return scalarField("INTEGER"), args[0].Type == args[1].Type || args[0].Type == "NULL" || args[1].Type == "NULL"
+		}, diffFunction("DATE"), false},
+		"DATETIME_DIFF":  {3, 3, signature("INTEGER", "DATETIME", "DATETIME", "DATE_PART"), diffFunction("DATETIME"), false},
+		"TIMESTAMP_DIFF": {3, 3, signature("INTEGER", "TIMESTAMP", "TIMESTAMP", "DATE_PART"), diffFunction("TIMESTAMP"), false},
+		"TIME_DIFF":      {3, 3, signature("INTEGER", "TIME", "TIME", "DATE_PART"), diffFunction("TIME"), false},
+		"DATE_TRUNC": {2, 2, func(args []data.Field) (data.Field, bool) {
+			return signature("ARG0", "ANY", "DATE_PART")(args)
+		}, truncFunction, false},
+		"DATETIME_TRUNC":  {2, 2, signature("DATETIME", "DATETIME", "DATE_PART"), truncFunction, false},
+		"TIMESTAMP_TRUNC": {2, 3, signature("TIMESTAMP", "TIMESTAMP", "DATE_PART", "STRING"), truncFunction, false},
+		"TIME_TRUNC":      {2, 2, signature("TIME", "TIME", "DATE_PART"), truncFunction, false},
+		"LAST_DAY": {1, 2, signature("ARG0", "ANY", "DATE_PART"), func(args []interface{}, fields []data.Field) (interface{}, error) {
+			part := "MONTH"
+			if len(args) == 2 {
+				part = args[1].(string)
+			}
+			start, err := truncTime(part, toTime(fields[0].Type, args[0]))
+			if err != nil {
+				return nil, err
+			}
+			var end time.Time
+			switch part {
+			case "YEAR":
+				end = start.AddDate(1, 0, -1)
+			case "QUARTER":
+				end = start.AddDate(0, 3, -1)
+			case "MONTH":
+				end = start.AddDate(0, 1, -1)
+			default:
+				end = start.AddDate(0, 0, 6)
+			}
+			return fromTime(fields[0].Type, end), nil
+		}, false},
+		"FORMAT_DATE":     {2, 2, signature("STRING", "STRING", "ANY"), formatFunction, false},
+		"FORMAT_DATETIME": {2, 2, signature("STRING", "STRING", "ANY"), formatFunction, false},
+		"FORMAT_TIME":     {2, 2, signature("STRING", "STRING", "TIME"), formatFunction, false},
+		"FORMAT_TIMESTAMP": {2, 3, signature("STRING", "STRING", "TIMESTAMP", "STRING"),
+			func(args []interface{}, fields []data.Field) (interface{}, error) {
+				t := args[1].(time.Time)
+				if len(args) == 3 {
+					location, err := loadLocation(args[2].(string))
+					if err != nil {
+						return nil, err
+					}
+					t = t.In(location)
+				}
+				return formatTime(args[0].(string), t), nil
+			}, false},
+		"PARSE_DATE":     {2, 2, signature("DATE", "STRING"), parseFunction("DATE"), false},
+		"PARSE_DATETIME": {2, 2, signature("DATETIME", "STRING"), parseFunction("DATETIME"), false},
+		"PARSE_TIME":     {2, 2, signature("TIME", "STRING"), parseFunction("TIME"), false},
+		"PARSE_TIMESTAMP": {2, 3, signature("TIMESTAMP", "STRING"), func(args []interface{}, fields []data.Field) (interface{}, error) {
+			parsed, location, err := parseTime(args[0].(string), args[1].(string))
+			if err != nil {
+				return nil, err
+			}
+			if location == nil {
+				location = time.UTC
+				if len(args) == 3 {
+					if location, err = loadLocation(args[2].(string)); err != nil {
+						return nil, err
+					}
+				}
+			}
+			return time.Date(parsed.Year(), parsed.Month(), parsed.Day(), parsed.Hour(), parsed.Minute(),
+				parsed.Second(), parsed.Nanosecond(), location).UTC(), nil
+		}, false},
+		"UNIX_SECONDS": {1, 1, signature("INTEGER", "TIMESTAMP"), func(args []interface{}, fields []data.Field) (interface{}, error) {
+			return floorDiv(args[0].(time.Time).UnixNano()/1000, 1000000), nil
+		}, false},
+		"UNIX_MILLIS": {1, 1, signature("INTEGER", "TIMESTAMP"), func(args []interface{}, fields []data.Field) (interface{}, error) {
+			return floorDiv(args[0].(time.Time).UnixNano()/1000, 1000), nil
+		}, false},
+		"UNIX_MICROS": {1, 1, signature("INTEGER", "TIMESTAMP"), func(args []interface{}, fields []data.Field) (interface{}, error) {
+			return args[0].(time.Time).UnixNano() / 1000, nil
+		}, false},
+		"UNIX_DATE": {1, 1, signature("INTEGER", "DATE"), func(args []interface{}, fields []data.Field) (interface{}, error) {
+			return dayNumber(toTime("DATE", args[0])), nil
+		}, false},
+		"TIMESTAMP_SECONDS": {1, 1, signature("TIMESTAMP", "INTEGER"), func(args []interface{}, fields []data.Field) (interface{}, error) {
+			return time.Unix(args[0].(int64), 0).UTC(), nil
+		}, false},
+		"TIMESTAMP_MILLIS": {1, 1, signature("TIMESTAMP", "INTEGER"), func(args []interface{}, fields []data.Field) (interface{}, error) {
+			millis := args[0].(int64)
+			return time.Unix(floorDiv(millis, 1000), (millis-floorDiv(millis, 1000)*1000)*1e6).UTC(), nil
+		}, false},
+		"TIMESTAMP_MICROS": {1, 1, signature("TIMESTAMP", "INTEGER"), func(args []interface{}, fields []data.Field) (interface{}, error) {
+			micros := args[0].(int64)
+			return time.Unix(floorDiv(micros, 1e6), (micros-floorDiv(micros, 1e6)*1e6)*1e3).UTC(), nil
+		}, false},
+		"DATE_FROM_UNIX_DATE": {1, 1, signature("DATE", "INTEGER"), func(args []interface{}, fields []data.Field) (interface{}, error) {
+			return fromTime("DATE", time.Unix(args[0].(int64)*86400, 0).UTC()), nil
+		}, false},
+	} {
+		FUNCTIONS[name] = fn
+	}
+}
+
+// String helpers
+
+func evalLength(args []interface{}, fields []data.Field) (interface{}, error) {
+	if b, ok := args[0].([]byte); ok {
+		return int64(len(b)), nil
+	}
+	return int64(utf8.RuneCountInString(args[0].(string))), nil
+}
+
+// sameKind returns text as BYTES if like is BYTES, else as a STRING.
+func sameKind(like interface{}, text string) interface{} {
+	if _, ok := like.([]byte); ok {
+		return []byte(text)
+	}
+	return text
+}
+
+func stringRunes(value interface{}) []rune {
+	if b, ok := value.([]byte); ok {
+		runes := make([]rune, len(b))
+		for i, c := range b {
+			runes[i] = rune(c)
+		}
+		return runes
+	}
+	return []rune(value.(string))
+}
+
+func fromRunes(like interface{}, runes []rune) interface{} {
+	if _, ok := like.([]byte); ok {
+		b := make([]byte, len(runes))
+		for i, r := range runes {
+			b[i] = byte(r)
+		}
+		return b
+	}
+	return string(runes)
+}
+
+func stringFunction(convert func(string) string) func([]interface{}, []data.Field) (interface{}, error) {
+	return func(args []interface{}, fields []data.Field) (interface{}, error) {
+		return sameKind(args[0], convert(toString(args[0]))), nil
+	}
+}
+
+func trimFunction(trimSpace func(string) string,
+	trim func(string, string) string) func([]interface{}, []data.Field) (interface{}, error) {
+	return func(args []interface{}, fields []data.Field) (interface{}, error) {
+		if len(args) == 1 {
+			return sameKind(args[0], trimSpace(toString(args[0]))), nil
+		}
+		return sameKind(args[0], trim(toString(args[0]), toString(args[1]))), nil
+	}
+}
+
+func substrSignature(args []data.Field) (data.Field, bool) {
+	return signature("ARG0", "STRINGY", "INTEGER")(args)
+}
+
+func evalSubstr(args []interface{}, fields []data.Field) (interface{}, error) {
+	runes := stringRunes(args[0])
+	length := int64(len(runes))
+	start := args[1].(int64)
+	switch {
+	case start > 0:
+		start -= 1
+	case start < 0:
+		start += length
+		if start < 0 {
+			start = 0
+		}
+	}
+	if start > length {
+		start = length
+	}
+	end := length
+	if len(args) == 3 {
+		count := args[2].(int64)
+		if count < 0 {
+			return nil, runtimeError("Third argument in SUBSTR() cannot be negative")
+		}
+		if start+count < end {
+			end = start + count
+		}
+	}
+	return fromRunes(args[0], runes[start:end]), nil
+}
+
+func padSignature(args []data.Field) (data.Field, bool) {
+	ok := matchesParam("STRINGY", args[0]) && matchesParam("INTEGER", args[1]) &&
+		(len(args) == 2 || matchesParam("STRINGY", args[2]))
+	field, _ := signature("ARG0", "ANY")(args[:1])
+	return field, ok
+}
+
+func padFunction(left bool) func([]interface{}, []data.Field) (interface{}, error) {
+	return func(args []interface{}, fields []data.Field) (interface{}, error) {
+		runes := stringRunes(args[0])
+		length := args[1].(int64)
+		if length < 0 {
+			return nil, runtimeError("Second argument in LPAD/RPAD cannot be negative")
+		}
+		pattern := []rune(" ")
+		if len(args) == 3 {
+			pattern = stringRunes(args[2])
+		}
+		if int64(len(runes)) >= length {
+			return fromRunes(args[0], runes[:length]), nil
+		}
+		if len(pattern) == 0 {
+			return nil, runtimeError("Third argument in LPAD/RPAD cannot be empty")
+		}
+		padding := []rune{}
+		for int64(len(padding)+len(runes)) < length {
+			padding = append(padding, pattern[len(padding)%len(pattern)])
+		}
+		if left {
+			return fromRunes(args[0], append(padding, runes...)), nil
+		}
+		return fromRunes(args[0], append(runes, padding...)), nil
+	}
+}
+
+func hashFunction(hash func([]byte) []byte) func([]interface{}, []data.Field) (interface{}, error) {
+	return func(args []interface{}, fields []data.Field) (interface{}, error) {
+		return hash([]byte(toString(args[0]))), nil
+	}
+}
+
+// evalFormat implements FORMAT, which is like printf with %t for a
+// value's string form and %T for its literal form.
+func evalFormat(args []interface{}, fields []data.Field) (interface{}, error) {
+	if args[0] == nil {
+		return nil, nil
+	}
+	format := []rune(args[0].(string))
+	var output strings.Builder
+	next := 1
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			output.WriteRune(format[i])
+			continue
+		}
+		j := i + 1
+		for j < len(format) && strings.ContainsRune("-+ #0123456789.'", format[j]) {
+			j += 1
+		}
+		if j == len(format) {
+			return nil, runtimeError("Invalid format specifier: %s", string(format[i:]))
+		}
+		flags := strings.Replace(string(format[i+1:j]), "'", "", -1)
+		verb := format[j]
+		i = j
+		if verb == '%' {
+			output.WriteRune('%')
+			continue
+		}
+		if next >= len(args) {
+			return nil, runtimeError("Too few arguments to FORMAT for pattern \"%s\"", args[0])
+		}
+		value, field := args[next], fields[next]
+		next += 1
+		if value == nil {
+			if verb == 'T' {
+				output.WriteString(fmt.Sprintf("%"+flags+"s", "NULL"))
+			} else {
+				output.WriteString(fmt.Sprintf("%"+flags+"s", "NULL"))
+			}
+			continue
+		}
+		switch verb {
+		case 'd', 'i':
+			output.WriteString(fmt.Sprintf("%"+flags+"d", value))
+		case 'f', 'F', 'e', 'E', 'g', 'G':
+			output.WriteString(fmt.Sprintf("%"+flags+string(verb), toFloat(value)))
+		case 'x', 'X', 'o':
+			output.WriteString(fmt.Sprintf("%"+flags+string(verb), value))
+		case 's':
+			output.WriteString(fmt.Sprintf("%"+flags+"s", formatValue(field, value)))
+		case 't':
+			output.WriteString(fmt.Sprintf("%"+flags+"s", formatValue(field, value)))
+		case 'T':
+			output.WriteString(fmt.Sprintf("%"+flags+"s", literalValue(field, value)))
+		default:
+			return nil, runtimeError("Invalid format specifier: %%%c", verb)
+		}
+	}
+	return output.String(), nil
+}
+
+// literalValue renders a value as it'd be written in a query.
+func literalValue(field data.Field, value interface{}) string {
+	if value == nil {
+		return "NULL"
+	}
+	if isArray(field) {
+		parts := []string{}
+		for _, element := range value.([]interface{}) {
+			parts = append(parts, literalValue(elementField(field), element))
+		}
+		return "[" + strings.Join(parts, ", ") + "]"
+	}
+	switch field.Type {
+	case "STRING":
+		return strconv.Quote(value.(string))
+	case "BYTES":
+		return "b" + strconv.Quote(string(value.([]byte)))
+	case "DATE", "DATETIME", "TIME", "TIMESTAMP", "NUMERIC", "BIGNUMERIC", "JSON":
+		return field.Type + " " + strconv.Quote(formatValue(field, value))
+	case "RECORD":
+		record := value.(map[string]interface{})
+		parts := []string{}
+		for _, subfield := range field.Fields {
+			parts = append(parts, literalValue(subfield, record[subfield.Name]))
+		}
+		return "(" + strings.Join(parts, ", ") + ")"
+	}
+	return formatValue(field, value)
+}
+
+// Regular expressions
+
+func compileRegexp(pattern interface{}) (*regexp.Regexp, error) {
+	compiled, err := regexp.Compile(toString(pattern))
+	if err != nil {
+		return nil, runtimeError("Cannot parse regular expression: %s", err)
+	}
+	return compiled, nil
+}
+
+type regexpMatch struct {
+	start int
+	text  string
+}
+
+// regexpMatches finds the matches of a pattern, taking its capturing group
+// if it has one.
+func regexpMatches(value, pattern interface{}) ([]regexpMatch, error) {
+	compiled, err := compileRegexp(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if compiled.NumSubexp() > 1 {
+		return nil, runtimeError("Regular expressions passed into extraction functions must not have more than 1 capturing group")
+	}
+	text := toString(value)
+	matches := []regexpMatch{}
+	for _, indexes := range compiled.FindAllStringSubmatchIndex(text, -1) {
+		if compiled.NumSubexp() == 1 {
+			if indexes[2] == -1 {
+				continue
+			}
+			matches = append(matches, regexpMatch{utf8.RuneCountInString(text[:indexes[0]]), text[indexes[2]:indexes[3]]})
+		} else {
+			matches = append(matches, regexpMatch{utf8.RuneCountInString(text[:indexes[0]]), text[indexes[0]:indexes[1]]})
+		}
+	}
+	return matches, nil
+}
+
+// JSON
+
+func toJSONValue(field data.Field, value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+	if isArray(field) {
+		elements := []interface{}{}
+		for _, element := range value.([]interface{}) {
+			elements = append(elements, toJSONValue(elementField(field), element))
+		}
+		return elements
+	}
+	switch field.Type {
+	case "RECORD":
+		record := value.(map[string]interface{})
+		object := orderedObject{}
+		for _, subfield := range field.Fields {
+			object = append(object, orderedField{subfield.Name, toJSONValue(subfield, record[subfield.Name])})
+		}
+		return object
+	case "INTEGER", "BOOLEAN":
+		return value
+	case "FLOAT":
+		number := value.(float64)
+		if math.IsNaN(number) || math.IsInf(number, 0) {
+			return formatValue(field, value)
+		}
+		return json.Number(strconv.FormatFloat(number, 'g', -1, 64))
+	case "NUMERIC", "BIGNUMERIC":
+		return json.Number(formatValue(field, value))
+	case "TIMESTAMP":
+		return value.(time.Time).UTC().Format("2006-01-02T15:04:05.999999Z")
+	case "BYTES":
+		return base64.StdEncoding.EncodeToString(value.([]byte))
+	case "JSON":
+		return json.RawMessage(value.(string))
+	}
+	return formatValue(field, value)
+}
+
+type orderedField struct {
+	name  string
+	value interface{}
+}
+
+// orderedObject encodes as a JSON object keeping its fields in order.
+type orderedObject []orderedField
+
+func (o orderedObject) MarshalJSON() ([]byte, error) {
+	parts := []string{}
+	for _, field := range o {
+		name, _ := json.Marshal(field.name)
+		value, err := json.Marshal(field.value)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, string(name)+":"+string(value))
+	}
+	return []byte("{" + strings.Join(parts, ",") + "}"), nil
+}
+
+var JSON_PATH_REGEXP = regexp.MustCompile(`^(\.([^.\[]+)|\[\s*(\d+)\s*\]|\[\s*'([^']*)'\s*\]|\[\s*"([^"]*)"\s*\])`)
+
+// jsonPath finds the node at a JSONPath like $.a.b[0] or $['a'].
+func jsonPath(args []interface{}) (interface{}, bool, error) {
+	var node interface{}
+	decoder := json.NewDecoder(strings.NewReader(toString(args[0])))
+	decoder.UseNumber()
+	if err := decoder.Decode(&node); err != nil {
+		return nil, false, nil
+	}
+	path := "$"
+	if len(args) == 2 {
+		if args[1] == nil {
+			return nil, false, nil
+		}
+		path = args[1].(string)
+	}
+	if !strings.HasPrefix(path, "$") {
+		return nil, false, runtimeError("JSONPath must start with '$'")
+	}
+	path = path[1:]
+	for path != "" {
+		match := JSON_PATH_REGEXP.FindStringSubmatch(path)
+		if match == nil {
+			return nil, false, runtimeError("Invalid token in JSONPath at: %s", path)
+		}
+		path = path[len(match[0]):]
+		if match[3] != "" {
+			array, ok := node.([]interface{})
+			index, _ := strconv.Atoi(match[3])
+			if !ok || index >= len(array) {
+				return nil, false, nil
+			}
+			node = array[index]
+			continue
+		}
+		key := match[2] + match[4] + match[5]
+		object, ok := node.(map[string]interface{})
+		if !ok {
+			return nil, false, nil
+		}
+		if node, ok = object[key]; !ok {
+			return nil, false, nil
+		}
+	}
+	return node, true, nil
+}
+
+func evalJSONScalar(args []interface{}, fields []data.Field) (interface{}, error) {
+	node, ok, err := jsonPath(args)
+	if !ok || err != nil || node == nil {
+		return nil, err
+	}
+	switch node := node.(type) {
+	case string:
+		return node, nil
+	case json.Number:
+		return node.String(), nil
+	case bool:
+		return strconv.FormatBool(node), nil
+	}
+	return nil, nil
+}
+
+func stringArraySignature(args []data.Field) (data.Field, bool) {
+	_, ok := signature("STRING", "ANY", "STRING")(args)
+	return arrayField(scalarField("STRING")), ok
+}
+
+func evalJSONStringArray(args []interface{}, fields []data.Field) (interface{}, error) {
+	node, ok, err := jsonPath(args)
+	elements, isArray := node.([]interface{})
+	if !ok || err != nil || !isArray {
+		return nil, err
+	}
+	result := make([]interface{}, len(elements))
+	for i, element := range elements {
+		switch element := element.(type) {
+		case string:
+			result[i] = element
+		case json.Number:
+			result[i] = element.String()
+		case bool:
+			result[i] = strconv.FormatBool(element)
+		case nil:
+		default:
+			return nil, nil
+		}
+	}
+	return result, nil
+}
+
+// Math helpers
+
+func roundSignature(args []data.Field) (data.Field, bool) {
+	if !matchesParam("NUMBER", args[0]) || (len(args) == 2 && !matchesParam("INTEGER", args[1])) {
+		return data.Field{}, false
+	}
+	if args[0].Type == "NUMERIC" || args[0].Type == "BIGNUMERIC" {
+		return scalarField(args[0].Type), true
+	}
+	return scalarField("FLOAT"), true
+}
+
+func roundFunction(round func(float64) float64) func([]interface{}, []data.Field) (interface{}, error) {
+	return func(args []interface{}, fields []data.Field) (interface{}, error) {
+		x := toFloat(args[0])
+		if len(args) == 1 {
+			return round(x), nil
+		}
+		scale := math.Pow(10, float64(args[1].(int64)))
+		return round(x*scale) / scale, nil
+	}
+}
+
+func floatFunction(compute func(float64) (float64, error)) func([]interface{}, []data.Field) (interface{}, error) {
+	return func(args []interface{}, fields []data.Field) (interface{}, error) {
+		result, err := compute(toFloat(args[0]))
+		if err != nil {
+			return nil, err
+		}
+		return result, nil
+	}
+}
+
+func noError(compute func(float64) float64) func(float64) (float64, error) {
+	return func(x float64) (float64, error) {
+		return compute(x), nil
+	}
+}
+
+func evalPow(args []interface{}, fields []data.Field) (interface{}, error) {
+	result := math.Pow(toFloat(args[0]), toFloat(args[1]))
+	if math.IsNaN(result) && !isNaN(args[0]) && !isNaN(args[1]) {
+		return nil, runtimeError("Floating point error in function: POW(%v, %v)", args[0], args[1])
+	}
+	return result, nil
+}
+
+func divisionSignature(args []data.Field) (data.Field, bool) {
+	field, ok := signature("ARG0", "NUMBER")(args)
+	if args[1].Type != "NULL" && args[1].Type != field.Type {
+		field, ok = supertype(field, args[1])
+	}
+	if field.Type == "FLOAT" {
+		return data.Field{}, false
+	}
+	return scalarField(field.Type), ok
+}
+
+func arithmeticSignature(op string) func(args []data.Field) (data.Field, bool) {
+	return func(args []data.Field) (data.Field, bool) {
+		if !matchesParam("NUMBER", args[0]) || !matchesParam("NUMBER", args[1]) {
+			return data.Field{}, false
+		}
+		return arithmeticType(op, args[0], args[1])
+	}
+}
+
+func safeArithmetic(op string) func([]interface{}, []data.Field) (interface{}, error) {
+	return func(args []interface{}, fields []data.Field) (interface{}, error) {
+		field, _ := arithmeticType(op, fields[0], fields[1])
+		result, err := arithmetic(op, field, args[0], args[1])
+		if err != nil {
+			return nil, nil
+		}
+		return result, nil
+	}
+}
+
+func supertypeSignature(args []data.Field) (data.Field, bool) {
+	field := scalarField("NULL")
+	for _, arg := range args {
+		var ok bool
+		if field, ok = supertype(field, arg); !ok {
+			return data.Field{}, false
+		}
+	}
+	if field.Type == "NULL" {
+		field.Type = "INTEGER"
+	}
+	return field, true
+}
+
+func extremeFunction(direction int) func([]interface{}, []data.Field) (interface{}, error) {
+	return func(args []interface{}, fields []data.Field) (interface{}, error) {
+		result, _ := supertypeSignature(fields)
+		var best interface{}
+		for i, arg := range args {
+			if arg == nil {
+				return nil, nil
+			}
+			value := coerce(arg, fields[i], result)
+			if isNaN(value) {
+				return value, nil
+			}
+			if best == nil || compareValues(value, best)*direction > 0 {
+				best = value
+			}
+		}
+		return best, nil
+	}
+}
+
+// Date and time helpers
+
+func civilDate(year, month, day int64) (interface{}, error) {
+	if month < 1 || month > 12 || day < 1 || day > int64(daysIn(int(year), time.Month(month))) ||
+		year < 1 || year > 9999 {
+		return nil, runtimeError("Input calculates to invalid date: %04d-%02d-%02d", year, month, day)
+	}
+	return fmt.Sprintf("%04d-%02d-%02d", year, month, day), nil
+}
+
+func civilTime(hour, minute, second int64) (interface{}, error) {
+	if hour < 0 || hour > 23 || minute < 0 || minute > 59 || second < 0 || second > 59 {
+		return nil, runtimeError("Input calculates to invalid time: %02d:%02d:%02d", hour, minute, second)
+	}
+	return fmt.Sprintf("%02d:%02d:%02d", hour, minute, second), nil
+}
+
+func addFunction(fieldType string, sign int64) func([]interface{}, []data.Field) (interface{}, error) {
+	return func(args []interface{}, fields []data.Field) (interface{}, error) {
+		iv := args[1].(interval)
+		if sign < 0 {
+			iv = iv.negate()
+		}
+		if fieldType == "DATE" && iv.micros != 0 {
+			return nil, runtimeError("Unsupported date part in DATE_ADD or DATE_SUB")
+		}
+		if fieldType == "TIME" {
+			iv.months, iv.days = 0, 0
+		}
+		return fromTime(fieldType, iv.addTo(toTime(fieldType, args[0]))), nil
+	}
+}
+
+func diffFunction(fieldType string) func([]interface{}, []data.Field) (interface{}, error) {
+	return func(args []interface{}, fields []data.Field) (interface{}, error) {
+		valueType := fields[0].Type
+		if valueType == "NULL" {
+			valueType = fields[1].Type
+		}
+		a, b := toTime(valueType, args[0]), toTime(valueType, args[1])
+		return diffTime(args[2].(string), a, b, fieldType == "TIMESTAMP" || valueType == "TIMESTAMP")
+	}
+}
+
+func truncFunction(args []interface{}, fields []data.Field) (interface{}, error) {
+	fieldType := fields[0].Type
+	t := toTime(fieldType, args[0])
+	zone := ""
+	if fieldType == "TIMESTAMP" {
+		// Days and larger are truncated in a time zone, UTC by default
+		if len(args) == 3 {
+			zone = args[2].(string)
+		}
+		var err error
+		if t, err = inZone(t, zone); err != nil {
+			return nil, err
+		}
+	}
+	truncated, err := truncTime(args[1].(string), t)
+	if err != nil {
+		return nil, err
+	}
+	if fieldType == "TIMESTAMP" {
+		return fromZone(truncated, zone)
+	}
+	return fromTime(fieldType, truncated), nil
+}
+
+func formatFunction(args []interface{}, fields []data.Field) (interface{}, error) {
+	return formatTime(args[0].(string), toTime(fields[1].Type, args[1])), nil
+}
+
+func parseFunction(fieldType string) func([]interface{}, []data.Field) (interface{}, error) {
+	return func(args []interface{}, fields []data.Field) (interface{}, error) {
+		parsed, _, err := parseTime(args[0].(string), args[1].(string))
+		if err != nil {
+			return nil, err
+		}
+		return fromTime(fieldType, parsed), nil
+	}
+}
+
+// sortValues sorts values in place, with NULLs first.
+func sortValues(values []interface{}) {
+	sort.SliceStable(values, func(i, j int) bool {
+		if values[i] == nil || values[j] == nil {
+			return values[i] == nil && values[j] != nil
+		}
+		return compareValues(values[i], values[j]) < 0
+	})
+}
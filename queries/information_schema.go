@@ -0,0 +1,547 @@
+package queries
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/danielstutzman/fake-bigquery/data"
+)
+
+// informationSchemaView is one of the INFORMATION_SCHEMA views, whose rows
+// are made from the catalog and job registry whenever a query reads it.
+type informationSchemaView struct {
+	fields       []data.Field
+	datasetLevel bool // may be qualified by a dataset, not just a project or region
+	rows         func(e *executor, scope *informationSchemaScope) [][]interface{}
+}
+
+// informationSchemaScope is what a view's qualifier covers: some datasets
+// of a project, and for a region qualifier, the project's jobs there.
+type informationSchemaScope struct {
+	project  string
+	datasets []string // sorted
+	region   string   // lowercase, or "" without a region qualifier
+}
+
+var INFORMATION_SCHEMA_VIEWS = map[string]informationSchemaView{
+	"TABLES": {datasetLevel: true, rows: informationSchemaTables, fields: tableNameFields(
+		namedField("table_type", "STRING"),
+		namedField("is_insertable_into", "STRING"),
+		namedField("is_typed", "STRING"),
+		namedField("creation_time", "TIMESTAMP"),
+		namedField("base_table_catalog", "STRING"),
+		namedField("base_table_schema", "STRING"),
+		namedField("base_table_name", "STRING"),
+		namedField("snapshot_time_ms", "TIMESTAMP"),
+		namedField("ddl", "STRING"),
+		namedField("default_collation_name", "STRING"),
+	)},
+	"COLUMNS": {datasetLevel: true, rows: informationSchemaColumns, fields: tableNameFields(
+		namedField("column_name", "STRING"),
+		namedField("ordinal_position", "INTEGER"),
+		namedField("is_nullable", "STRING"),
+		namedField("data_type", "STRING"),
+		namedField("is_generated", "STRING"),
+		namedField("generation_expression", "STRING"),
+		namedField("is_stored", "STRING"),
+		namedField("is_hidden", "STRING"),
+		namedField("is_updatable", "STRING"),
+		namedField("is_system_defined", "STRING"),
+		namedField("is_partitioning_column", "STRING"),
+		namedField("clustering_ordinal_position", "INTEGER"),
+		namedField("collation_name", "STRING"),
+		namedField("column_default", "STRING"),
+		namedField("rounding_mode", "STRING"),
+	)},
+	"COLUMN_FIELD_PATHS": {datasetLevel: true, rows: informationSchemaColumnFieldPaths, fields: tableNameFields(
+		namedField("column_name", "STRING"),
+		namedField("field_path", "STRING"),
+		namedField("data_type", "STRING"),
+		namedField("description", "STRING"),
+		namedField("collation_name", "STRING"),
+		namedField("rounding_mode", "STRING"),
+	)},
+	"PARTITIONS": {datasetLevel: true, rows: informationSchemaPartitions, fields: tableNameFields(
+		namedField("partition_id", "STRING"),
+		namedField("total_rows", "INTEGER"),
+		namedField("total_logical_bytes", "INTEGER"),
+		namedField("total_billable_bytes", "INTEGER"),
+		namedField("last_modified_time", "TIMESTAMP"),
+		namedField("storage_tier", "STRING"),
+	)},
+	"VIEWS": {datasetLevel: true, rows: informationSchemaViews, fields: tableNameFields(
+		namedField("view_definition", "STRING"),
+		namedField("check_option", "STRING"),
+		namedField("use_standard_sql", "STRING"),
+	)},
+	"SCHEMATA": {rows: informationSchemaSchemata, fields: []data.Field{
+		namedField("catalog_name", "STRING"),
+		namedField("schema_name", "STRING"),
+		namedField("schema_owner", "STRING"),
+		namedField("creation_time", "TIMESTAMP"),
+		namedField("last_modified_time", "TIMESTAMP"),
+		namedField("location", "STRING"),
+		namedField("ddl", "STRING"),
+		namedField("default_collation_name", "STRING"),
+	}},
+	"JOBS_BY_PROJECT": JOBS_VIEW,
+	"JOBS":            JOBS_VIEW,
+}
+
+var JOBS_VIEW = informationSchemaView{rows: informationSchemaJobs, fields: []data.Field{
+	namedField("creation_time", "TIMESTAMP"),
+	namedField("project_id", "STRING"),
+	namedField("project_number", "INTEGER"),
+	namedField("user_email", "STRING"),
+	namedField("job_id", "STRING"),
+	namedField("parent_job_id", "STRING"),
+	namedField("job_type", "STRING"),
+	namedField("statement_type", "STRING"),
+	namedField("priority", "STRING"),
+	namedField("start_time", "TIMESTAMP"),
+	namedField("end_time", "TIMESTAMP"),
+	namedField("query", "STRING"),
+	namedField("state", "STRING"),
+	namedField("reservation_id", "STRING"),
+	namedField("total_bytes_processed", "INTEGER"),
+	namedField("total_bytes_billed", "INTEGER"),
+	recordField("error_result",
+		namedField("reason", "STRING"),
+		namedField("location", "STRING"),
+		namedField("debug_info", "STRING"),
+		namedField("message", "STRING")),
+	namedField("cache_hit", "BOOLEAN"),
+	recordField("destination_table", TABLE_REFERENCE_FIELDS...),
+	arrayField(recordField("referenced_tables", TABLE_REFERENCE_FIELDS...)),
+	recordField("session_info", namedField("session_id", "STRING")),
+}}
+
+var TABLE_REFERENCE_FIELDS = []data.Field{
+	namedField("project_id", "STRING"),
+	namedField("dataset_id", "STRING"),
+	namedField("table_id", "STRING"),
+}
+
+func namedField(name, fieldType string) data.Field {
+	field := scalarField(fieldType)
+	field.Name = name
+	return field
+}
+
+func recordField(name string, fields ...data.Field) data.Field {
+	field := namedField(name, "RECORD")
+	field.Fields = fields
+	return field
+}
+
+// tableNameFields are the columns of a view with a row per table, or per
+// part of a table, which start by naming it.
+func tableNameFields(fields ...data.Field) []data.Field {
+	return append([]data.Field{
+		namedField("table_catalog", "STRING"),
+		namedField("table_schema", "STRING"),
+		namedField("table_name", "STRING"),
+	}, fields...)
+}
+
+func isInformationSchema(path []string) bool {
+	return len(path) >= 2 && strings.EqualFold(path[len(path)-2], "INFORMATION_SCHEMA")
+}
+
+// informationSchemaRelation reads the view named by a path like
+// dataset.INFORMATION_SCHEMA.TABLES or `region-us`.INFORMATION_SCHEMA.JOBS.
+func (c *compiler) informationSchemaRelation(item *TableName) (*relation, error) {
+	e := c.executor
+	name := strings.ToUpper(item.Path[len(item.Path)-1])
+	view, ok := INFORMATION_SCHEMA_VIEWS[name]
+	if !ok {
+		return nil, &data.Error{Reason: "notFound", Message: fmt.Sprintf(
+			"Not found: Table %s was not found in location US", strings.Join(item.Path, "."))}
+	}
+	if e.legacy {
+		return nil, queryError(item.Pos, "INFORMATION_SCHEMA is not supported in legacy SQL")
+	}
+	scope, err := e.informationSchemaScope(item.Path[:len(item.Path)-2], view, name)
+	if err != nil {
+		return nil, err
+	}
+
+	alias := item.Alias
+	if alias == "" {
+		alias = item.Path[len(item.Path)-1]
+	}
+	rel := &relation{rows: func(ctx *evalContext) ([][]interface{}, error) {
+		return view.rows(e, scope), nil
+	}}
+	for _, field := range view.fields {
+		rel.columns = append(rel.columns, column{qualifier: alias, name: field.Name, field: field})
+	}
+	rel.ranges = []rangeVariable{{alias, 0, len(rel.columns)}}
+	return rel, nil
+}
+
+// informationSchemaScope resolves what comes before INFORMATION_SCHEMA: a
+// region like region-us or a dataset, either optionally in a project, or
+// for views that aren't per dataset, just a project.
+func (e *executor) informationSchemaScope(qualifier []string, view informationSchemaView,
+	name string) (*informationSchemaScope, error) {
+
+	scope := &informationSchemaScope{project: e.projectName}
+	last := ""
+	if len(qualifier) > 0 {
+		last = strings.ToLower(qualifier[len(qualifier)-1])
+	}
+	switch {
+	case strings.HasPrefix(last, "region-") && len(qualifier) <= 2:
+		if len(qualifier) == 2 {
+			scope.project = qualifier[0]
+		}
+		scope.region = strings.TrimPrefix(last, "region-")
+	case !view.datasetLevel && len(qualifier) == 1:
+		scope.project = qualifier[0]
+	case !view.datasetLevel:
+		return nil, &data.Error{Reason: "invalidQuery", Message: fmt.Sprintf(
+			"INFORMATION_SCHEMA.%s must be qualified by a project or a region, like `region-us`.INFORMATION_SCHEMA.%s",
+			name, name)}
+	case len(qualifier) == 0 && e.defaultDataset == nil:
+		return nil, &data.Error{Reason: "invalid", Message: fmt.Sprintf(
+			"Table name \"INFORMATION_SCHEMA.%s\" missing dataset while no default dataset is set in the request.", name)}
+	default:
+		ref, err := e.tableRef(append(append([]string{}, qualifier...), name))
+		if err != nil {
+			return nil, err
+		}
+		if _, err := e.lookupDataset(ref); err != nil {
+			return nil, err
+		}
+		scope.project = ref.ProjectId
+		scope.datasets = []string{ref.DatasetId}
+		return scope, nil
+	}
+
+	for datasetId, dataset := range e.projects[scope.project].Datasets {
+		if strings.HasPrefix(datasetId, "_") {
+			continue
+		}
+		if scope.region == "" || strings.EqualFold(datasetLocation(dataset), scope.region) {
+			scope.datasets = append(scope.datasets, datasetId)
+		}
+	}
+	sort.Strings(scope.datasets)
+	return scope, nil
+}
+
+func datasetLocation(dataset data.Dataset) string {
+	if dataset.Location == "" {
+		return "US"
+	}
+	return dataset.Location
+}
+
+// eachTable calls f with the tables of the scope's datasets, in order.
+func (e *executor) eachTable(scope *informationSchemaScope, f func(ref data.TableRef, table data.Table)) {
+	for _, datasetId := range scope.datasets {
+		dataset := e.projects[scope.project].Datasets[datasetId]
+		tableIds := []string{}
+		for tableId := range dataset.Tables {
+			tableIds = append(tableIds, tableId)
+		}
+		sort.Strings(tableIds)
+		for _, tableId := range tableIds {
+			f(data.TableRef{ProjectId: scope.project, DatasetId: datasetId, TableId: tableId}, dataset.Tables[tableId])
+		}
+	}
+}
+
+// timestampValue converts milliseconds since epoch to a TIMESTAMP, which is
+// NULL for 0.
+func timestampValue(millis int64) interface{} {
+	if millis == 0 {
+		return nil
+	}
+	return time.Unix(0, millis*int64(time.Millisecond)).UTC()
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "YES"
+	}
+	return "NO"
+}
+
+func informationSchemaTables(e *executor, scope *informationSchemaScope) [][]interface{} {
+	rows := [][]interface{}{}
+	e.eachTable(scope, func(ref data.TableRef, table data.Table) {
+		tableType := "BASE TABLE"
+		switch table.Type() {
+		case "VIEW":
+			tableType = "VIEW"
+		case "MATERIALIZED_VIEW":
+			tableType = "MATERIALIZED VIEW"
+		case "SNAPSHOT":
+			tableType = "SNAPSHOT"
+		}
+		var baseCatalog, baseSchema, baseName, snapshotTime interface{}
+		base := table.Clone
+		if table.Snapshot != nil {
+			base = table.Snapshot
+			snapshotTime = timestampValue(base.Time)
+		}
+		if base != nil {
+			baseCatalog, baseSchema, baseName = base.ProjectId, base.DatasetId, base.TableId
+		}
+		rows = append(rows, []interface{}{ref.ProjectId, ref.DatasetId, ref.TableId, tableType,
+			yesNo(tableType == "BASE TABLE"), "NO", timestampValue(table.CreationTime),
+			baseCatalog, baseSchema, baseName, snapshotTime, tableDDL(ref, table), "NULL"})
+	})
+	return rows
+}
+
+func informationSchemaColumns(e *executor, scope *informationSchemaScope) [][]interface{} {
+	rows := [][]interface{}{}
+	e.eachTable(scope, func(ref data.TableRef, table data.Table) {
+		partitioning := map[string]bool{}
+		if !table.IngestionTimePartitioned() {
+			for _, name := range table.PartitionColumns() {
+				partitioning[strings.ToLower(name)] = true
+			}
+		}
+		for i, field := range normalizeFields(table.Fields) {
+			var clusteringPosition interface{}
+			if table.Clustering != nil {
+				for j, name := range table.Clustering.Fields {
+					if strings.EqualFold(name, field.Name) {
+						clusteringPosition = int64(j + 1)
+					}
+				}
+			}
+			rows = append(rows, []interface{}{ref.ProjectId, ref.DatasetId, ref.TableId, field.Name,
+				int64(i + 1), yesNo(field.Mode != "REQUIRED"), typeName(field), "NEVER", nil, nil, "NO", nil, "NO",
+				yesNo(partitioning[strings.ToLower(field.Name)]), clusteringPosition, "NULL", "NULL", nil})
+		}
+	})
+	return rows
+}
+
+// informationSchemaColumnFieldPaths has a row for each column and each
+// field nested in a RECORD column.
+func informationSchemaColumnFieldPaths(e *executor, scope *informationSchemaScope) [][]interface{} {
+	rows := [][]interface{}{}
+	e.eachTable(scope, func(ref data.TableRef, table data.Table) {
+		var walk func(columnName, path string, field, normalized data.Field)
+		walk = func(columnName, path string, field, normalized data.Field) {
+			var description interface{}
+			if field.Description != "" {
+				description = field.Description
+			}
+			rows = append(rows, []interface{}{ref.ProjectId, ref.DatasetId, ref.TableId, columnName, path,
+				typeName(normalized), description, "NULL", nil})
+			for i, subfield := range field.Fields {
+				walk(columnName, path+"."+subfield.Name, subfield, normalized.Fields[i])
+			}
+		}
+		for i, normalized := range normalizeFields(table.Fields) {
+			walk(normalized.Name, normalized.Name, table.Fields[i], normalized)
+		}
+	})
+	return rows
+}
+
+// informationSchemaPartitions has a row for each partition of a table,
+// or one with a NULL partition_id for a table that isn't partitioned.
+func informationSchemaPartitions(e *executor, scope *informationSchemaScope) [][]interface{} {
+	rows := [][]interface{}{}
+	e.eachTable(scope, func(ref data.TableRef, table data.Table) {
+		if table.View != nil {
+			return
+		}
+		if !table.IsPartitioned() {
+			bytes := data.TableBytes(table)
+			rows = append(rows, []interface{}{ref.ProjectId, ref.DatasetId, ref.TableId, nil,
+				int64(len(table.Rows)), bytes, bytes, timestampValue(table.LastModifiedTime), "ACTIVE"})
+			return
+		}
+		rowCounts, byteCounts := map[string]int64{}, map[string]int64{}
+		partitionIds := []string{}
+		for _, row := range table.Rows {
+			partitionId := table.PartitionId(row)
+			if _, seen := rowCounts[partitionId]; !seen {
+				partitionIds = append(partitionIds, partitionId)
+			}
+			rowCounts[partitionId] += 1
+			byteCounts[partitionId] += data.RowBytes(table.Fields, row)
+		}
+		sort.Strings(partitionIds)
+		for _, partitionId := range partitionIds {
+			rows = append(rows, []interface{}{ref.ProjectId, ref.DatasetId, ref.TableId, partitionId,
+				rowCounts[partitionId], byteCounts[partitionId], byteCounts[partitionId],
+				timestampValue(table.LastModifiedTime), "ACTIVE"})
+		}
+	})
+	return rows
+}
+
+func informationSchemaViews(e *executor, scope *informationSchemaScope) [][]interface{} {
+	rows := [][]interface{}{}
+	e.eachTable(scope, func(ref data.TableRef, table data.Table) {
+		if table.View != nil {
+			rows = append(rows, []interface{}{ref.ProjectId, ref.DatasetId, ref.TableId,
+				table.View.Query, nil, yesNo(!table.View.UseLegacySql)})
+		}
+	})
+	return rows
+}
+
+func informationSchemaSchemata(e *executor, scope *informationSchemaScope) [][]interface{} {
+	rows := [][]interface{}{}
+	for _, datasetId := range scope.datasets {
+		dataset := e.projects[scope.project].Datasets[datasetId]
+		options := []string{"location=" + strconv.Quote(datasetLocation(dataset))}
+		if dataset.Description != "" {
+			options = append(options, "description="+strconv.Quote(dataset.Description))
+		}
+		ddl := fmt.Sprintf("CREATE SCHEMA `%s.%s`\nOPTIONS(\n  %s\n);",
+			scope.project, datasetId, strings.Join(options, ",\n  "))
+		rows = append(rows, []interface{}{scope.project, datasetId, nil,
+			timestampValue(dataset.CreationTime), timestampValue(dataset.LastModifiedTime),
+			datasetLocation(dataset), ddl, "NULL"})
+	}
+	return rows
+}
+
+func informationSchemaJobs(e *executor, scope *informationSchemaScope) [][]interface{} {
+	rows := [][]interface{}{}
+	if e.jobs == nil {
+		return rows
+	}
+	jobs := e.jobs()
+	sort.SliceStable(jobs, func(i, j int) bool { return jobs[i].CreationTime < jobs[j].CreationTime })
+	for _, job := range jobs {
+		if job.ProjectId != scope.project ||
+			(scope.region != "" && !strings.EqualFold(job.Location, scope.region)) {
+			continue
+		}
+		var statementType, query, bytesProcessed, bytesBilled interface{}
+		if job.JobType == "QUERY" {
+			query, bytesProcessed, bytesBilled = job.Query, job.TotalBytesProcessed, job.TotalBytesBilled
+		}
+		if job.StatementType != "" {
+			statementType = job.StatementType
+		}
+		var parentJobId, errorResult, destinationTable, sessionInfo interface{}
+		if job.ParentJobId != "" {
+			parentJobId = job.ParentJobId
+		}
+		if job.SessionId != "" {
+			sessionInfo = map[string]interface{}{"session_id": job.SessionId}
+		}
+		if job.ErrorReason != "" {
+			var location interface{}
+			if job.ErrorLocation != "" {
+				location = job.ErrorLocation
+			}
+			errorResult = map[string]interface{}{"reason": job.ErrorReason, "location": location,
+				"debug_info": nil, "message": job.ErrorMessage}
+		}
+		if job.DestinationTable != nil {
+			destinationTable = tableReferenceValue(*job.DestinationTable)
+		}
+		referencedTables := []interface{}{}
+		for _, ref := range job.ReferencedTables {
+			referencedTables = append(referencedTables, tableReferenceValue(ref))
+		}
+		rows = append(rows, []interface{}{timestampValue(job.CreationTime), job.ProjectId, nil,
+			job.UserEmail, job.JobId, parentJobId, job.JobType, statementType, "INTERACTIVE",
+			timestampValue(job.StartTime), timestampValue(job.EndTime), query, job.State, nil,
+			bytesProcessed, bytesBilled, errorResult, false, destinationTable, referencedTables, sessionInfo})
+	}
+	return rows
+}
+
+func tableReferenceValue(ref data.TableRef) map[string]interface{} {
+	return map[string]interface{}{"project_id": ref.ProjectId, "dataset_id": ref.DatasetId, "table_id": ref.TableId}
+}
+
+// tableDDL is the statement that would create a table as it is now.
+func tableDDL(ref data.TableRef, table data.Table) string {
+	name := fmt.Sprintf("`%s.%s.%s`", ref.ProjectId, ref.DatasetId, ref.TableId)
+	switch {
+	case table.View != nil:
+		return fmt.Sprintf("CREATE VIEW %s\nAS %s;", name, table.View.Query)
+	case table.MaterializedView != nil:
+		return fmt.Sprintf("CREATE MATERIALIZED VIEW %s\nAS %s;", name, table.MaterializedView.Query)
+	case table.Snapshot != nil:
+		base := table.Snapshot.TableRef
+		return fmt.Sprintf("CREATE SNAPSHOT TABLE %s\nCLONE `%s.%s.%s`;", name,
+			base.ProjectId, base.DatasetId, base.TableId)
+	}
+
+	columns := []string{}
+	for _, field := range normalizeFields(table.Fields) {
+		column := "  " + field.Name + " " + typeName(field)
+		if field.Mode == "REQUIRED" {
+			column += " NOT NULL"
+		}
+		columns = append(columns, column)
+	}
+	ddl := fmt.Sprintf("CREATE TABLE %s\n(\n%s\n)", name, strings.Join(columns, ",\n"))
+	if partitionBy := partitionExpression(table); partitionBy != "" {
+		ddl += "\nPARTITION BY " + partitionBy
+	}
+	if table.Clustering != nil {
+		ddl += "\nCLUSTER BY " + strings.Join(table.Clustering.Fields, ", ")
+	}
+	options := []string{}
+	if table.Description != "" {
+		options = append(options, "description="+strconv.Quote(table.Description))
+	}
+	if table.RequiresPartitionFilter() {
+		options = append(options, "require_partition_filter=true")
+	}
+	if len(options) > 0 {
+		ddl += "\nOPTIONS(\n  " + strings.Join(options, ",\n  ") + "\n)"
+	}
+	return ddl + ";"
+}
+
+// partitionExpression is a table's partitioning as a PARTITION BY clause
+// would give it, or "" for a table that isn't partitioned.
+func partitionExpression(table data.Table) string {
+	if partitioning := table.RangePartitioning; partitioning != nil {
+		bounds := partitioning.Range
+		return fmt.Sprintf("RANGE_BUCKET(%s, GENERATE_ARRAY(%s, %s, %s))",
+			partitioning.Field, bounds.Start, bounds.End, bounds.Interval)
+	}
+	partitioning := table.TimePartitioning
+	if partitioning == nil {
+		return ""
+	}
+	partitionType := partitioning.PartitionType()
+	if partitioning.Field == "" {
+		if partitionType == "DAY" {
+			return "_PARTITIONDATE"
+		}
+		return fmt.Sprintf("TIMESTAMP_TRUNC(_PARTITIONTIME, %s)", partitionType)
+	}
+	fieldType := ""
+	for _, field := range table.Fields {
+		if strings.EqualFold(field.Name, partitioning.Field) {
+			fieldType = data.NormalizeType(field.Type)
+		}
+	}
+	switch {
+	case fieldType == "DATE" && partitionType == "DAY":
+		return partitioning.Field
+	case fieldType == "DATE":
+		return fmt.Sprintf("DATE_TRUNC(%s, %s)", partitioning.Field, partitionType)
+	case fieldType == "TIMESTAMP" && partitionType == "DAY":
+		return fmt.Sprintf("DATE(%s)", partitioning.Field)
+	case fieldType == "TIMESTAMP":
+		return fmt.Sprintf("TIMESTAMP_TRUNC(%s, %s)", partitioning.Field, partitionType)
+	default:
+		return fmt.Sprintf("DATETIME_TRUNC(%s, %s)", partitioning.Field, partitionType)
+	}
+}
@@ -0,0 +1,493 @@
+package queries
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/danielstutzman/fake-bigquery/data"
+)
+
+// Legacy SQL is parsed by the same parser with legacy set, which turns off
+// standard-only syntax like WITH, UNION ALL and UNNEST, and compiled onto
+// the same executor.
+
+// LEGACY_KEYWORDS are reported as keywords rather than identifiers in
+// legacy SQL syntax errors, besides the standard reserved keywords.
+var LEGACY_KEYWORDS = map[string]bool{}
+
+func init() {
+	for _, keyword := range strings.Fields(`ALTER CALL CREATE DELETE DROP EACH
+		FLATTEN INSERT MERGE QUALIFY REPLACE TRUNCATE UPDATE`) {
+		LEGACY_KEYWORDS[keyword] = true
+	}
+}
+
+// legacySyntaxError reports an unexpected token the way legacy SQL does.
+type legacySyntaxError struct {
+	token  token
+	source string
+}
+
+func (e *legacySyntaxError) Error() string {
+	t := e.token
+	var kind, image string
+	switch t.kind {
+	case TOKEN_EOF:
+		return fmt.Sprintf(`Encountered "<EOF>" at line %d, column %d.`, t.pos.Line, t.pos.Column)
+	case TOKEN_IDENT, TOKEN_QUOTED_IDENT:
+		kind, image = "<ID>", t.text
+		if LEGACY_KEYWORDS[strings.ToUpper(t.text)] {
+			kind = `"` + strings.ToUpper(t.text) + `"`
+		}
+	case TOKEN_INTEGER:
+		kind, image = "<INTEGER>", t.text
+	case TOKEN_FLOAT:
+		kind, image = "<DOUBLE>", t.text
+	case TOKEN_STRING, TOKEN_BYTES:
+		kind, image = "<STRING>", "'"+t.text+"'"
+	default:
+		// keywords are uppercased by the tokenizer
+		kind, image = `"`+strings.ToUpper(t.text)+`"`, e.source[t.start:t.start+len(t.text)]
+	}
+	return fmt.Sprintf(`Encountered " %s "%s "" at line %d, column %d.`, kind, image, t.pos.Line, t.pos.Column)
+}
+
+// legacyLexicalError reports a character legacy SQL has no use for, like
+// the backtick of a standard SQL quoted name.
+type legacyLexicalError struct {
+	char rune
+	pos  position
+}
+
+func (e *legacyLexicalError) Error() string {
+	return fmt.Sprintf(`Lexical error at line %d, column %d.  Encountered: "%c" (%d), after : ""`,
+		e.pos.Line, e.pos.Column, e.char, e.char)
+}
+
+// parseLegacyStatement parses a legacy SQL query, the only kind of
+// statement legacy SQL has.
+func parseLegacyStatement(query string) (Statement, error) {
+	tokens, err := tokenize(query)
+	if err != nil {
+		return nil, err
+	}
+	if tokens, err = legacyTokens(query, tokens); err != nil {
+		return nil, err
+	}
+	p := &parser{source: query, tokens: tokens, legacy: true}
+	if !p.isWord("SELECT") && !p.isSymbol("(") {
+		return nil, p.unexpected("keyword SELECT")
+	}
+	statement, err := p.query()
+	if err != nil {
+		return nil, err
+	}
+	p.acceptSymbol(";")
+	if p.peek().kind != TOKEN_EOF {
+		return nil, p.unexpected("end of input")
+	}
+	return &QueryStatement{Query: statement}, nil
+}
+
+// legacyTokens turns each [...] into one quoted name token, since names
+// like [my-project:dataset.table] hold characters the tokenizer splits
+// on. Backticks and query parameters are errors.
+func legacyTokens(source string, tokens []token) ([]token, error) {
+	result := []token{}
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+		switch {
+		case t.kind == TOKEN_QUOTED_IDENT || t.kind == TOKEN_PARAM:
+			char, _ := utf8.DecodeRuneInString(source[t.start:])
+			return nil, &legacyLexicalError{char, t.pos}
+		case t.kind == TOKEN_SYMBOL && t.text == "[":
+			end := i + 1
+			for tokens[end].kind != TOKEN_EOF && !(tokens[end].kind == TOKEN_SYMBOL && tokens[end].text == "]") {
+				end += 1
+			}
+			if tokens[end].kind == TOKEN_EOF {
+				return nil, &legacySyntaxError{tokens[end], source}
+			}
+			name := strings.TrimSpace(source[t.start+1 : tokens[end].start])
+			result = append(result, token{TOKEN_QUOTED_IDENT, name, t.pos, t.start})
+			i = end
+		default:
+			result = append(result, t)
+		}
+	}
+	return result, nil
+}
+
+// legacyFromClause parses FROM items and JOINs, which may say EACH, as in
+// JOIN EACH, a hint BigQuery no longer needs.
+func (p *parser) legacyFromClause() (FromItem, error) {
+	left, err := p.legacyFromList()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		join := &Join{Pos: p.peek().pos, Left: left}
+		switch {
+		case p.isWord("CROSS"):
+			p.next()
+			join.Kind = "CROSS"
+		case p.isWord("INNER"):
+			p.next()
+			join.Kind = "INNER"
+		case p.isWord("JOIN"):
+			join.Kind = "INNER"
+		case p.isWord("LEFT"), p.isWord("RIGHT"), p.isWord("FULL"):
+			join.Kind = p.next().text
+			p.acceptWord("OUTER")
+		default:
+			return left, nil
+		}
+		if err := p.expectWord("JOIN"); err != nil {
+			return nil, err
+		}
+		p.acceptWord("EACH")
+		if join.Right, err = p.legacyFromPrimary(); err != nil {
+			return nil, err
+		}
+		if join.Kind != "CROSS" {
+			if err := p.expectWord("ON"); err != nil {
+				return nil, err
+			}
+			if join.On, err = p.expr(); err != nil {
+				return nil, err
+			}
+		}
+		left = join
+	}
+}
+
+// legacyFromList parses FROM items separated by commas, which legacy SQL
+// unions rather than joins.
+func (p *parser) legacyFromList() (FromItem, error) {
+	pos := p.peek().pos
+	item, err := p.legacyFromPrimary()
+	if err != nil || !p.isSymbol(",") {
+		return item, err
+	}
+	union := &LegacyUnion{Pos: pos, Items: []FromItem{item}}
+	for p.acceptSymbol(",") {
+		item, err := p.legacyFromPrimary()
+		if err != nil {
+			return nil, err
+		}
+		union.Items = append(union.Items, item)
+	}
+	return union, nil
+}
+
+func (p *parser) legacyFromPrimary() (FromItem, error) {
+	pos := p.peek().pos
+	var err error
+	switch {
+	case p.isSymbol("("):
+		p.next()
+		query, err := p.query()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectSymbol(")"); err != nil {
+			return nil, err
+		}
+		subquery := &SubqueryFrom{Pos: pos, Query: query}
+		if subquery.Alias, err = p.alias(); err != nil {
+			return nil, err
+		}
+		return subquery, nil
+
+	case p.isWord("TABLE_DATE_RANGE") && p.peekAt(1).text == "(":
+		p.next()
+		p.next()
+		item := &TableDateRange{Pos: pos}
+		if item.Prefix, err = p.legacyTableName(); err != nil {
+			return nil, err
+		}
+		if err := p.expectSymbol(","); err != nil {
+			return nil, err
+		}
+		if item.Start, err = p.expr(); err != nil {
+			return nil, err
+		}
+		if err := p.expectSymbol(","); err != nil {
+			return nil, err
+		}
+		if item.End, err = p.expr(); err != nil {
+			return nil, err
+		}
+		if err := p.expectSymbol(")"); err != nil {
+			return nil, err
+		}
+		if item.Alias, err = p.alias(); err != nil {
+			return nil, err
+		}
+		return item, nil
+
+	case p.isWord("FLATTEN") && p.peekAt(1).text == "(":
+		p.next()
+		p.next()
+		item := &Flatten{Pos: pos}
+		if item.Source, err = p.legacyFromPrimary(); err != nil {
+			return nil, err
+		}
+		if err := p.expectSymbol(","); err != nil {
+			return nil, err
+		}
+		if item.Field, err = p.path(); err != nil {
+			return nil, err
+		}
+		if err := p.expectSymbol(")"); err != nil {
+			return nil, err
+		}
+		if item.Alias, err = p.alias(); err != nil {
+			return nil, err
+		}
+		return item, nil
+
+	default:
+		path, err := p.legacyTableName()
+		if err != nil {
+			return nil, err
+		}
+		table := &TableName{Pos: pos, Path: path}
+		if table.Alias, err = p.alias(); err != nil {
+			return nil, err
+		}
+		return table, nil
+	}
+}
+
+// legacyTableName parses [project:dataset.table] or [dataset.table], with
+// or without the brackets.
+func (p *parser) legacyTableName() ([]string, error) {
+	path, err := p.path()
+	if err != nil {
+		return nil, err
+	}
+	if len(path) == 1 && p.isSymbol(":") {
+		p.next()
+		rest, err := p.path()
+		if err != nil {
+			return nil, err
+		}
+		return append(path, rest...), nil
+	}
+	if project := strings.SplitN(path[0], ":", 2); len(project) == 2 {
+		return append(project, path[1:]...), nil
+	}
+	return path, nil
+}
+
+// legacyName is the name legacy SQL gives an unaliased column, which
+// keeps the whole path, e.g. t_info_a for t.info.a.
+func legacyName(e Expr) string {
+	if path, ok := e.(*Path); ok {
+		return strings.Join(path.Parts, "_")
+	}
+	return ""
+}
+
+// compileLegacyUnion appends the rows of items, matching their columns by
+// name and filling in NULL for the columns an item doesn't have.
+func (c *compiler) compileLegacyUnion(items []FromItem, alias string, pos position) (*relation, error) {
+	rel := &relation{}
+	sources := []*relation{}
+	mappings := [][]int{}
+	indexes := map[string]int{}
+	for _, item := range items {
+		source, err := c.compileFromItem(item)
+		if err != nil {
+			return nil, err
+		}
+		mapping := make([]int, len(source.columns))
+		for i, col := range source.columns {
+			j, seen := indexes[strings.ToLower(col.name)]
+			if !seen {
+				j = len(rel.columns)
+				indexes[strings.ToLower(col.name)] = j
+				rel.columns = append(rel.columns, column{qualifier: alias, name: col.name, field: col.field})
+			} else if existing := rel.columns[j].field; !sameType(existing, col.field) {
+				return nil, queryError(pos, "Cannot union tables : Incompatible types. '%s' : '%s' and '%s' : '%s'",
+					rel.columns[j].name, typeName(existing), col.name, typeName(col.field))
+			}
+			mapping[i] = j
+		}
+		sources = append(sources, source)
+		mappings = append(mappings, mapping)
+	}
+	if alias != "" {
+		rel.ranges = []rangeVariable{{alias, 0, len(rel.columns)}}
+	}
+	width := len(rel.columns)
+	rel.rows = func(ctx *evalContext) ([][]interface{}, error) {
+		rows := [][]interface{}{}
+		for i, source := range sources {
+			sourceRows, err := source.rows(ctx)
+			if err != nil {
+				return nil, err
+			}
+			for _, sourceRow := range sourceRows {
+				row := make([]interface{}, width)
+				for j, value := range sourceRow {
+					row[mappings[i][j]] = value
+				}
+				rows = append(rows, row)
+			}
+		}
+		return rows, nil
+	}
+	return rel, nil
+}
+
+// compileTableDateRange unions the tables whose names are the prefix and
+// a YYYYMMDD date from the day of start to the day of end.
+func (c *compiler) compileTableDateRange(item *TableDateRange) (*relation, error) {
+	prefix, err := c.executor.tableRef(item.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	dataset, err := c.executor.lookupDataset(prefix)
+	if err != nil {
+		return nil, err
+	}
+	bounds := []string{}
+	for _, x := range []Expr{item.Start, item.End} {
+		value, err := c.executor.constantValue(x, scalarField("TIMESTAMP"), "TABLE_DATE_RANGE")
+		if err != nil {
+			return nil, err
+		}
+		bound, ok := value.(time.Time)
+		if !ok {
+			return nil, queryError(x.position(), "TABLE_DATE_RANGE requires non-NULL timestamps")
+		}
+		bounds = append(bounds, bound.Format("20060102"))
+	}
+
+	tableIds := []string{}
+	for tableId := range dataset.Tables {
+		if !strings.HasPrefix(tableId, prefix.TableId) {
+			continue
+		}
+		suffix := tableId[len(prefix.TableId):]
+		if _, err := time.Parse("20060102", suffix); err == nil && suffix >= bounds[0] && suffix <= bounds[1] {
+			tableIds = append(tableIds, tableId)
+		}
+	}
+	if len(tableIds) == 0 {
+		return nil, queryError(item.Pos, "FROM clause with table wildcards matches no table")
+	}
+	sort.Strings(tableIds)
+	items := []FromItem{}
+	for _, tableId := range tableIds {
+		items = append(items, &TableName{Pos: item.Pos, Path: []string{prefix.ProjectId, prefix.DatasetId, tableId}})
+	}
+	return c.compileLegacyUnion(items, item.Alias, item.Pos)
+}
+
+// compileFlatten repeats each row of the source once per element of the
+// repeated field, or once with NULL if it has none.
+func (c *compiler) compileFlatten(item *Flatten) (*relation, error) {
+	source, err := c.compileFromItem(item.Source)
+	if err != nil {
+		return nil, err
+	}
+	index := -1
+	for i, col := range source.columns {
+		if strings.EqualFold(col.name, item.Field[0]) {
+			index = i
+			break
+		}
+	}
+	if index == -1 {
+		return nil, queryError(item.Pos, "Field %s not found in FLATTEN", strings.Join(item.Field, "."))
+	}
+	field, names, err := flattenedField(source.columns[index].field, item.Field[1:])
+	if err != nil {
+		return nil, queryError(item.Pos, "Cannot flatten %s: %s", strings.Join(item.Field, "."), err)
+	}
+
+	rel := &relation{columns: append([]column{}, source.columns...), ranges: source.ranges}
+	rel.columns[index].field = field
+	if item.Alias != "" {
+		for i := range rel.columns {
+			rel.columns[i].qualifier = item.Alias
+		}
+		rel.ranges = []rangeVariable{{item.Alias, 0, len(rel.columns)}}
+	}
+	rel.rows = func(ctx *evalContext) ([][]interface{}, error) {
+		sourceRows, err := source.rows(ctx)
+		if err != nil {
+			return nil, err
+		}
+		rows := [][]interface{}{}
+		for _, sourceRow := range sourceRows {
+			for _, value := range flattenValue(sourceRow[index], names) {
+				row := append([]interface{}{}, sourceRow...)
+				row[index] = value
+				rows = append(rows, row)
+			}
+		}
+		return rows, nil
+	}
+	return rel, nil
+}
+
+// flattenedField is field once the repeated field at path within it is
+// flattened, along with the names along path as they're stored.
+func flattenedField(field data.Field, path []string) (data.Field, []string, error) {
+	if len(path) == 0 {
+		if !isArray(field) {
+			return field, nil, fmt.Errorf("it is not a repeated field")
+		}
+		return elementField(field), nil, nil
+	}
+	if field.Type != "RECORD" || isArray(field) {
+		return field, nil, fmt.Errorf("%s is not a non-repeated record", field.Name)
+	}
+	for i, subfield := range field.Fields {
+		if strings.EqualFold(subfield.Name, path[0]) {
+			flattened, names, err := flattenedField(subfield, path[1:])
+			if err != nil {
+				return field, nil, err
+			}
+			field.Fields = append([]data.Field{}, field.Fields...)
+			field.Fields[i] = flattened
+			return field, append([]string{subfield.Name}, names...), nil
+		}
+	}
+	return field, nil, fmt.Errorf("no such field %s", path[0])
+}
+
+// flattenValue gives the values that replace value when the repeated field
+// at path within it is flattened.
+func flattenValue(value interface{}, path []string) []interface{} {
+	if len(path) == 0 {
+		if elements, _ := value.([]interface{}); len(elements) > 0 {
+			return elements
+		}
+		return []interface{}{nil}
+	}
+	record, ok := value.(map[string]interface{})
+	if !ok {
+		return []interface{}{nil}
+	}
+	values := []interface{}{}
+	for _, element := range flattenValue(record[path[0]], path[1:]) {
+		flattened := map[string]interface{}{}
+		for name, fieldValue := range record {
+			flattened[name] = fieldValue
+		}
+		flattened[path[0]] = element
+		if element == nil {
+			delete(flattened, path[0])
+		}
+		values = append(values, flattened)
+	}
+	return values
+}
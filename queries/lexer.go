@@ -0,0 +1,362 @@
+package queries
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	TOKEN_EOF tokenKind = iota
+	TOKEN_IDENT
+	TOKEN_QUOTED_IDENT
+	TOKEN_KEYWORD
+	TOKEN_INTEGER
+	TOKEN_FLOAT
+	TOKEN_STRING
+	TOKEN_BYTES
+	TOKEN_PARAM
+	TOKEN_SYMBOL
+)
+
+type position struct {
+	Line   int
+	Column int
+}
+
+func (p position) String() string {
+	return fmt.Sprintf("[%d:%d]", p.Line, p.Column)
+}
+
+type token struct {
+	kind  tokenKind
+	text  string // keywords are uppercased; strings are unescaped
+	pos   position
+	start int // byte offset of the token in the query
+}
+
+func (t token) describe() string {
+	switch t.kind {
+	case TOKEN_EOF:
+		return "end of input"
+	case TOKEN_IDENT, TOKEN_QUOTED_IDENT:
+		return fmt.Sprintf("identifier %q", t.text)
+	case TOKEN_KEYWORD:
+		return "keyword " + t.text
+	case TOKEN_STRING:
+		return "string literal"
+	case TOKEN_INTEGER, TOKEN_FLOAT:
+		return "integer literal \"" + t.text + "\""
+	default:
+		return "\"" + t.text + "\""
+	}
+}
+
+// RESERVED_KEYWORDS can't be used as unquoted identifiers.
+var RESERVED_KEYWORDS = map[string]bool{}
+
+func init() {
+	for _, keyword := range strings.Fields(`ALL AND ANY ARRAY AS ASC
+		ASSERT_ROWS_MODIFIED AT BETWEEN BY CASE CAST COLLATE CONTAINS CREATE
+		CROSS CUBE CURRENT DEFAULT DEFINE DESC DISTINCT ELSE END ENUM ESCAPE
+		EXCEPT EXCLUDE EXISTS EXTRACT FALSE FETCH FOLLOWING FOR FROM FULL
+		GROUP GROUPING GROUPS HASH HAVING IF IGNORE IN INNER INTERSECT
+		INTERVAL INTO IS JOIN LATERAL LEFT LIKE LIMIT LOOKUP MERGE NATURAL
+		NEW NO NOT NULL NULLS OF ON OR ORDER OUTER OVER PARTITION PRECEDING
+		PROTO QUALIFY RANGE RECURSIVE RESPECT RIGHT ROLLUP ROWS SELECT SET
+		SOME STRUCT TABLESAMPLE THEN TO TREAT TRUE UNBOUNDED UNION UNNEST
+		USING WHEN WHERE WINDOW WITH WITHIN`) {
+		RESERVED_KEYWORDS[keyword] = true
+	}
+}
+
+type syntaxError struct {
+	message string
+	pos     position
+}
+
+func (e *syntaxError) Error() string {
+	return "Syntax error: " + e.message + " at " + e.pos.String()
+}
+
+// tokenize splits a GoogleSQL query into tokens, dropping comments.
+func tokenize(query string) ([]token, error) {
+	tokens := []token{}
+	runes := []rune(query)
+	offsets := make([]int, len(runes)+1)
+	offset := 0
+	for i, r := range runes {
+		offsets[i] = offset
+		offset += len(string(r))
+	}
+	offsets[len(runes)] = offset
+
+	line, lineStart := 1, 0
+	i := 0
+	posAt := func(index int) position {
+		return position{Line: line, Column: index - lineStart + 1}
+	}
+	advanceLines := func(from, to int) {
+		for j := from; j < to; j++ {
+			if runes[j] == '\n' {
+				line += 1
+				lineStart = j + 1
+			}
+		}
+	}
+
+	for i < len(runes) {
+		r := runes[i]
+		switch {
+		case r == '\n':
+			line += 1
+			lineStart = i + 1
+			i += 1
+			continue
+		case unicode.IsSpace(r):
+			i += 1
+			continue
+		case r == '#' || (r == '-' && i+1 < len(runes) && runes[i+1] == '-'):
+			for i < len(runes) && runes[i] != '\n' {
+				i += 1
+			}
+			continue
+		case r == '/' && i+1 < len(runes) && runes[i+1] == '*':
+			end := strings.Index(string(runes[i+2:]), "*/")
+			if end == -1 {
+				return nil, &syntaxError{"Unclosed comment", posAt(i)}
+			}
+			endIndex := i + 2 + len([]rune(string(runes[i+2:])[:end])) + 2
+			advanceLines(i, endIndex)
+			i = endIndex
+			continue
+		}
+
+		pos := posAt(i)
+		start := i
+		switch {
+		case r == '`':
+			end := i + 1
+			for end < len(runes) && runes[end] != '`' {
+				end += 1
+			}
+			if end == len(runes) {
+				return nil, &syntaxError{"Unclosed identifier literal", pos}
+			}
+			tokens = append(tokens, token{TOKEN_QUOTED_IDENT, string(runes[i+1 : end]), pos, offsets[start]})
+			i = end + 1
+
+		case isStringStart(runes, i):
+			raw, isBytes := false, false
+			for runes[i] != '\'' && runes[i] != '"' {
+				switch unicode.ToUpper(runes[i]) {
+				case 'R':
+					raw = true
+				case 'B':
+					isBytes = true
+				}
+				i += 1
+			}
+			quote := string(runes[i])
+			if i+2 < len(runes) && runes[i+1] == runes[i] && runes[i+2] == runes[i] {
+				quote = strings.Repeat(quote, 3)
+			}
+			i += len(quote)
+			var value strings.Builder
+			closed := false
+			for i < len(runes) {
+				if strings.HasPrefix(string(runes[i:minInt(i+len(quote), len(runes))]), quote) {
+					i += len(quote)
+					closed = true
+					break
+				}
+				if runes[i] == '\n' && len(quote) == 1 {
+					break
+				}
+				if runes[i] == '\\' && i+1 < len(runes) {
+					if raw {
+						value.WriteRune(runes[i])
+						value.WriteRune(runes[i+1])
+						i += 2
+						continue
+					}
+					escaped, length, err := unescape(runes[i+1:])
+					if err != nil {
+						return nil, &syntaxError{err.Error(), posAt(i)}
+					}
+					value.WriteString(escaped)
+					i += 1 + length
+					continue
+				}
+				value.WriteRune(runes[i])
+				i += 1
+			}
+			if !closed {
+				return nil, &syntaxError{"Unclosed string literal", pos}
+			}
+			advanceLines(start, i)
+			kind := TOKEN_STRING
+			if isBytes {
+				kind = TOKEN_BYTES
+			}
+			tokens = append(tokens, token{kind, value.String(), pos, offsets[start]})
+
+		case unicode.IsLetter(r) || r == '_':
+			end := i
+			for end < len(runes) && (unicode.IsLetter(runes[end]) || unicode.IsDigit(runes[end]) || runes[end] == '_') {
+				end += 1
+			}
+			text := string(runes[i:end])
+			// Keywords directly after a dot are field names, e.g. t.select
+			afterDot := len(tokens) > 0 && tokens[len(tokens)-1].kind == TOKEN_SYMBOL &&
+				tokens[len(tokens)-1].text == "."
+			if RESERVED_KEYWORDS[strings.ToUpper(text)] && !afterDot {
+				tokens = append(tokens, token{TOKEN_KEYWORD, strings.ToUpper(text), pos, offsets[start]})
+			} else {
+				tokens = append(tokens, token{TOKEN_IDENT, text, pos, offsets[start]})
+			}
+			i = end
+
+		case unicode.IsDigit(r) || (r == '.' && i+1 < len(runes) && unicode.IsDigit(runes[i+1]) &&
+			!(len(tokens) > 0 && isPathToken(tokens[len(tokens)-1]))):
+			end := i
+			isFloat := false
+			if r == '0' && i+1 < len(runes) && (runes[i+1] == 'x' || runes[i+1] == 'X') {
+				end = i + 2
+				for end < len(runes) && strings.ContainsRune("0123456789abcdefABCDEF", runes[end]) {
+					end += 1
+				}
+			} else {
+				for end < len(runes) && unicode.IsDigit(runes[end]) {
+					end += 1
+				}
+				if end < len(runes) && runes[end] == '.' {
+					isFloat = true
+					end += 1
+					for end < len(runes) && unicode.IsDigit(runes[end]) {
+						end += 1
+					}
+				}
+				if end < len(runes) && (runes[end] == 'e' || runes[end] == 'E') {
+					isFloat = true
+					end += 1
+					if end < len(runes) && (runes[end] == '+' || runes[end] == '-') {
+						end += 1
+					}
+					for end < len(runes) && unicode.IsDigit(runes[end]) {
+						end += 1
+					}
+				}
+			}
+			kind := TOKEN_INTEGER
+			if isFloat {
+				kind = TOKEN_FLOAT
+			}
+			tokens = append(tokens, token{kind, string(runes[i:end]), pos, offsets[start]})
+			i = end
+
+		case r == '@' || r == '?':
+			end := i + 1
+			if r == '@' {
+				system := end < len(runes) && runes[end] == '@'
+				if system {
+					end += 1
+				}
+				// only system variables like @@session.time_zone are dotted;
+				// @s.a is field a of parameter @s
+				for end < len(runes) && (unicode.IsLetter(runes[end]) || unicode.IsDigit(runes[end]) ||
+					runes[end] == '_' || (system && runes[end] == '.')) {
+					end += 1
+				}
+			}
+			tokens = append(tokens, token{TOKEN_PARAM, string(runes[i:end]), pos, offsets[start]})
+			i = end
+
+		default:
+			symbol := string(r)
+			for _, candidate := range []string{"<>", "!=", "<=", ">=", "||", "<<", ">>", "=>"} {
+				if strings.HasPrefix(string(runes[i:minInt(i+2, len(runes))]), candidate) {
+					symbol = candidate
+					break
+				}
+			}
+			if !strings.Contains("(),.;*+-/=<>[]|&^~!:{}", string(r)) && len(symbol) == 1 {
+				return nil, &syntaxError{fmt.Sprintf("Illegal input character %q", r), pos}
+			}
+			tokens = append(tokens, token{TOKEN_SYMBOL, symbol, pos, offsets[start]})
+			i += len([]rune(symbol))
+		}
+	}
+	tokens = append(tokens, token{TOKEN_EOF, "", posAt(len(runes)), offsets[len(runes)]})
+	return tokens, nil
+}
+
+// isPathToken reports whether a "." after t continues a path, rather than
+// starting a number like .5.
+func isPathToken(t token) bool {
+	return t.kind == TOKEN_IDENT || t.kind == TOKEN_QUOTED_IDENT ||
+		(t.kind == TOKEN_SYMBOL && (t.text == ")" || t.text == "]"))
+}
+
+func isStringStart(runes []rune, i int) bool {
+	for j := i; j < len(runes) && j < i+3; j++ {
+		switch unicode.ToUpper(runes[j]) {
+		case '\'', '"':
+			return true
+		case 'R', 'B':
+			continue
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+func unescape(runes []rune) (string, int, error) {
+	switch runes[0] {
+	case 'n':
+		return "\n", 1, nil
+	case 't':
+		return "\t", 1, nil
+	case 'r':
+		return "\r", 1, nil
+	case 'a':
+		return "\a", 1, nil
+	case 'b':
+		return "\b", 1, nil
+	case 'f':
+		return "\f", 1, nil
+	case 'v':
+		return "\v", 1, nil
+	case '\\', '\'', '"', '`', '?':
+		return string(runes[0]), 1, nil
+	case 'x', 'X', 'u', 'U':
+		length := map[rune]int{'x': 2, 'X': 2, 'u': 4, 'U': 8}[runes[0]]
+		if len(runes) < length+1 {
+			return "", 0, fmt.Errorf("Illegal escape sequence: \\%c", runes[0])
+		}
+		var code rune
+		for _, digit := range runes[1 : length+1] {
+			value := strings.IndexRune("0123456789abcdef", unicode.ToLower(digit))
+			if value == -1 {
+				return "", 0, fmt.Errorf("Illegal escape sequence: \\%c", runes[0])
+			}
+			code = code*16 + rune(value)
+		}
+		return string(code), length + 1, nil
+	}
+	if runes[0] >= '0' && runes[0] <= '7' && len(runes) >= 3 {
+		code := (runes[0]-'0')*64 + (runes[1]-'0')*8 + (runes[2] - '0')
+		return string(code), 3, nil
+	}
+	return "", 0, fmt.Errorf("Illegal escape sequence: \\%c", runes[0])
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
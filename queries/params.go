@@ -0,0 +1,85 @@
+package queries
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/danielstutzman/fake-bigquery/data"
+)
+
+// parameters are a query's parameters as constants, with named ones keyed
+// by lowercased name.
+type parameters struct {
+	positional bool
+	named      map[string]expression
+	ordered    []expression
+}
+
+func newParameters(config Config) (*parameters, *data.Error) {
+	params := &parameters{named: map[string]expression{}}
+	switch strings.ToUpper(config.ParameterMode) {
+	case "POSITIONAL":
+		params.positional = true
+	case "NAMED":
+	case "":
+		params.positional = len(config.Parameters) > 0 && config.Parameters[0].Name == ""
+	default:
+		return nil, &data.Error{Reason: "invalid",
+			Message: fmt.Sprintf("Invalid parameterMode: %s", config.ParameterMode)}
+	}
+
+	for i, param := range config.Parameters {
+		name := param.Name
+		if name == "" {
+			if !params.positional {
+				return nil, &data.Error{Reason: "invalid",
+					Message: "Named query parameters must have a name"}
+			}
+			name = fmt.Sprintf("%d", i+1)
+		}
+		field, err := param.ParameterType.Field(name)
+		if err != nil {
+			return nil, &data.Error{Reason: "invalid", Message: err.Error()}
+		}
+		value, err := param.ParameterType.Convert(param.ParameterValue)
+		if err != nil {
+			return nil, &data.Error{Reason: "invalid",
+				Message: fmt.Sprintf("Invalid value for query parameter %s: %s", name, err)}
+		}
+		if params.positional {
+			params.ordered = append(params.ordered, constant(field, value))
+		} else if _, duplicate := params.named[strings.ToLower(name)]; duplicate {
+			return nil, &data.Error{Reason: "invalid",
+				Message: fmt.Sprintf("Duplicate query parameter name %s", name)}
+		} else {
+			params.named[strings.ToLower(name)] = constant(field, value)
+		}
+	}
+	return params, nil
+}
+
+func (c *compiler) compileParam(e *Param) (expression, error) {
+	params := c.executor.params
+	if params == nil {
+		return expression{}, queryError(e.Pos, "Query parameters cannot be used in views")
+	}
+	if strings.HasPrefix(e.Name, "@") {
+		return c.compileSystemVariable(e)
+	}
+	if e.Name == "" {
+		if !params.positional {
+			return expression{}, queryError(e.Pos, "Positional parameters are not supported")
+		} else if e.Index >= len(params.ordered) {
+			return expression{}, queryError(e.Pos, "Positional parameter %d not found", e.Index+1)
+		}
+		return params.ordered[e.Index], nil
+	}
+	if params.positional {
+		return expression{}, queryError(e.Pos, "Named parameters are not supported")
+	}
+	param, ok := params.named[strings.ToLower(e.Name)]
+	if !ok {
+		return expression{}, queryError(e.Pos, "Query parameter '%s' not found", e.Name)
+	}
+	return param, nil
+}
@@ -0,0 +1,2313 @@
+package queries
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/danielstutzman/fake-bigquery/data"
+)
+
+type parser struct {
+	source     string
+	tokens     []token
+	i          int
+	positional int  // the number of ? parameters seen so far
+	legacy     bool // parsing legacy SQL; see legacy.go
+	loops      int  // how many loops enclose what's being parsed, for BREAK and CONTINUE
+}
+
+// parseStatement parses one GoogleSQL statement, with an optional trailing
+// semicolon.
+func parseStatement(query string) (Statement, error) {
+	tokens, err := tokenize(query)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{source: query, tokens: tokens}
+	statement, err := p.statement()
+	if err != nil {
+		return nil, err
+	}
+	p.acceptSymbol(";")
+	if p.peek().kind != TOKEN_EOF {
+		return nil, p.unexpected("end of input")
+	}
+	return statement, nil
+}
+
+// parseScript parses a GoogleSQL query of one or more statements, separated
+// by semicolons, which may include scripting statements like DECLARE and IF.
+func parseScript(query string) ([]ScriptStatement, error) {
+	tokens, err := tokenize(query)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{source: query, tokens: tokens}
+	statements, err := p.statementList(true)
+	if err != nil {
+		return nil, err
+	}
+	if len(statements) == 0 {
+		return nil, p.unexpected("keyword SELECT")
+	}
+	if p.peek().kind != TOKEN_EOF {
+		return nil, p.unexpected("end of input")
+	}
+	return statements, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.i]
+}
+
+func (p *parser) peekAt(offset int) token {
+	if p.i+offset >= len(p.tokens) {
+		return p.tokens[len(p.tokens)-1]
+	}
+	return p.tokens[p.i+offset]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.i]
+	if t.kind != TOKEN_EOF {
+		p.i += 1
+	}
+	return t
+}
+
+func (p *parser) unexpected(expected string) error {
+	t := p.peek()
+	if p.legacy {
+		return &legacySyntaxError{t, p.source}
+	}
+	return &syntaxError{fmt.Sprintf("Expected %s but got %s", expected, t.describe()), t.pos}
+}
+
+func (p *parser) isKeyword(keyword string) bool {
+	t := p.peek()
+	return t.kind == TOKEN_KEYWORD && t.text == keyword
+}
+
+// isWord matches a keyword or a non-reserved word like MATCHED
+func (p *parser) isWord(word string) bool {
+	t := p.peek()
+	return (t.kind == TOKEN_KEYWORD || t.kind == TOKEN_IDENT) && strings.ToUpper(t.text) == word
+}
+
+func (p *parser) isWordAt(offset int, word string) bool {
+	t := p.peekAt(offset)
+	return (t.kind == TOKEN_KEYWORD || t.kind == TOKEN_IDENT) && strings.ToUpper(t.text) == word
+}
+
+func (p *parser) acceptWord(word string) bool {
+	if p.isWord(word) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *parser) expectWord(word string) error {
+	if !p.acceptWord(word) {
+		return p.unexpected("keyword " + word)
+	}
+	return nil
+}
+
+func (p *parser) isSymbol(symbol string) bool {
+	t := p.peek()
+	return t.kind == TOKEN_SYMBOL && t.text == symbol
+}
+
+func (p *parser) acceptSymbol(symbol string) bool {
+	if p.isSymbol(symbol) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *parser) expectSymbol(symbol string) error {
+	if !p.acceptSymbol(symbol) {
+		return p.unexpected("\"" + symbol + "\"")
+	}
+	return nil
+}
+
+func (p *parser) identifier() (string, error) {
+	t := p.peek()
+	if t.kind != TOKEN_IDENT && t.kind != TOKEN_QUOTED_IDENT {
+		return "", p.unexpected("identifier")
+	}
+	p.next()
+	return t.text, nil
+}
+
+// path parses a dotted name; a quoted part like `p.d.t` may hold several.
+func (p *parser) path() ([]string, error) {
+	parts := []string{}
+	for {
+		t := p.peek()
+		if t.kind == TOKEN_QUOTED_IDENT {
+			parts = append(parts, strings.Split(t.text, ".")...)
+		} else if t.kind == TOKEN_IDENT {
+			name := t.text
+			// Project names may contain dashes, e.g. my-project.dataset.table
+			for len(parts) == 0 && p.peekAt(1).kind == TOKEN_SYMBOL && p.peekAt(1).text == "-" &&
+				(p.peekAt(2).kind == TOKEN_IDENT || p.peekAt(2).kind == TOKEN_INTEGER) &&
+				p.peekAt(2).start == p.peekAt(1).start+1 && p.peekAt(1).start == t.start+len(name) {
+				p.next()
+				p.next()
+				name += "-" + p.peek().text
+				t = p.peek()
+			}
+			parts = append(parts, name)
+		} else {
+			return nil, p.unexpected("identifier")
+		}
+		p.next()
+		if !p.isSymbol(".") {
+			return parts, nil
+		}
+		p.next()
+	}
+}
+
+func (p *parser) alias() (string, error) {
+	if p.acceptWord("AS") {
+		return p.identifier()
+	}
+	if t := p.peek(); t.kind == TOKEN_IDENT || t.kind == TOKEN_QUOTED_IDENT {
+		p.next()
+		return t.text, nil
+	}
+	return "", nil
+}
+
+// Statements
+
+func (p *parser) statement() (Statement, error) {
+	switch {
+	case p.isWord("INSERT"):
+		return p.insertStatement()
+	case p.isWord("UPDATE"):
+		return p.updateStatement()
+	case p.isWord("DELETE"):
+		return p.deleteStatement()
+	case p.isWord("MERGE"):
+		return p.mergeStatement()
+	case p.isWord("CREATE"):
+		return p.createStatement()
+	case p.isWord("DROP"):
+		return p.dropStatement()
+	case p.isWord("ALTER"):
+		return p.alterStatement()
+	case p.isWord("CALL"):
+		return p.callStatement()
+	case p.isWord("SELECT"), p.isWord("WITH"), p.isSymbol("("):
+		query, err := p.query()
+		if err != nil {
+			return nil, err
+		}
+		return &QueryStatement{Query: query}, nil
+	default:
+		return nil, p.unexpected("keyword SELECT")
+	}
+}
+
+// Scripts
+
+// statementList parses statements, each followed by a semicolon, up to
+// the end of the enclosing block or script. Only a block or script may
+// start by declaring variables.
+func (p *parser) statementList(declarations bool) ([]ScriptStatement, error) {
+	statements := []ScriptStatement{}
+	for p.peek().kind != TOKEN_EOF && !p.isWord("END") && !p.isWord("ELSE") && !p.isWord("ELSEIF") &&
+		!p.isWord("EXCEPTION") && !p.isWord("UNTIL") {
+
+		start := p.peek()
+		statement, err := p.scriptStatement()
+		if err != nil {
+			return nil, err
+		}
+		if _, isDeclare := statement.(*DeclareStatement); !isDeclare {
+			declarations = false
+		} else if !declarations {
+			return nil, &syntaxError{"Variable declarations are allowed only at the start of a block or script", start.pos}
+		}
+		statements = append(statements, ScriptStatement{Pos: start.pos,
+			Text: strings.TrimSpace(p.source[start.start:p.peek().start]), Statement: statement})
+		if !p.acceptSymbol(";") {
+			break
+		}
+	}
+	return statements, nil
+}
+
+func (p *parser) scriptStatement() (Statement, error) {
+	pos := p.peek().pos
+	switch {
+	case p.isWord("DECLARE"):
+		return p.declareStatement()
+	case p.isWord("SET"):
+		return p.setStatement()
+	case p.isWord("IF"):
+		return p.ifStatement()
+	case p.isWord("LOOP"), p.isWord("WHILE"), p.isWord("REPEAT"):
+		return p.loopStatement()
+	case p.isWord("FOR"):
+		return p.forStatement()
+	case p.isWord("BEGIN"):
+		if next := p.peekAt(1); next.kind == TOKEN_EOF || next.kind == TOKEN_SYMBOL && next.text == ";" ||
+			next.kind != TOKEN_STRING && strings.ToUpper(next.text) == "TRANSACTION" {
+			return p.transactionStatement()
+		}
+		return p.blockStatement()
+	case p.isWord("COMMIT"), p.isWord("ROLLBACK"):
+		return p.transactionStatement()
+	case p.isWord("RAISE"):
+		return p.raiseStatement()
+	case p.acceptWord("RETURN"):
+		return &ReturnStatement{Pos: pos}, nil
+	case p.isWord("BREAK"), p.isWord("LEAVE"), p.isWord("CONTINUE"), p.isWord("ITERATE"):
+		keyword := strings.ToUpper(p.next().text)
+		if p.loops == 0 {
+			return nil, &syntaxError{keyword + " is only allowed inside a loop", pos}
+		}
+		return &BreakStatement{Pos: pos, Continue: keyword == "CONTINUE" || keyword == "ITERATE"}, nil
+	case p.isWord("EXECUTE"):
+		return p.executeImmediateStatement()
+	default:
+		return p.statement()
+	}
+}
+
+func (p *parser) declareStatement() (Statement, error) {
+	statement := &DeclareStatement{Pos: p.next().pos}
+	var err error
+	if statement.Names, err = p.identifierList(); err != nil {
+		return nil, err
+	}
+	if !p.isWord("DEFAULT") {
+		if statement.Type, err = p.typeSpec(); err != nil {
+			return nil, err
+		}
+	}
+	if p.acceptWord("DEFAULT") {
+		if statement.Default, err = p.expr(); err != nil {
+			return nil, err
+		}
+	}
+	return statement, nil
+}
+
+func (p *parser) setStatement() (Statement, error) {
+	statement := &SetStatement{Pos: p.next().pos}
+	var err error
+	if p.acceptSymbol("(") {
+		statement.Tuple = true
+		if statement.Names, err = p.identifierList(); err != nil {
+			return nil, err
+		}
+		if err := p.expectSymbol(")"); err != nil {
+			return nil, err
+		}
+	} else {
+		name, err := p.identifier()
+		if err != nil {
+			return nil, err
+		}
+		statement.Names = []string{name}
+	}
+	if err := p.expectSymbol("="); err != nil {
+		return nil, err
+	}
+	statement.X, err = p.expr()
+	return statement, err
+}
+
+func (p *parser) ifStatement() (Statement, error) {
+	statement := &IfStatement{Pos: p.next().pos}
+	for {
+		cond, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectWord("THEN"); err != nil {
+			return nil, err
+		}
+		body, err := p.statementList(false)
+		if err != nil {
+			return nil, err
+		}
+		statement.Branches = append(statement.Branches, IfBranch{Cond: cond, Body: body})
+		if !p.acceptWord("ELSEIF") {
+			break
+		}
+	}
+	if p.acceptWord("ELSE") {
+		var err error
+		if statement.Else, err = p.statementList(false); err != nil {
+			return nil, err
+		}
+	}
+	return statement, p.expectEnd("IF")
+}
+
+// expectEnd parses the END IF, END LOOP, ... that closes a statement.
+func (p *parser) expectEnd(keyword string) error {
+	if err := p.expectWord("END"); err != nil {
+		return err
+	}
+	return p.expectWord(keyword)
+}
+
+func (p *parser) loopStatement() (Statement, error) {
+	statement := &LoopStatement{Pos: p.peek().pos, Kind: strings.ToUpper(p.next().text)}
+	var err error
+	if statement.Kind == "WHILE" {
+		if statement.Cond, err = p.expr(); err != nil {
+			return nil, err
+		}
+		if err := p.expectWord("DO"); err != nil {
+			return nil, err
+		}
+	}
+	p.loops += 1
+	statement.Body, err = p.statementList(false)
+	p.loops -= 1
+	if err != nil {
+		return nil, err
+	}
+	if statement.Kind == "REPEAT" {
+		if err := p.expectWord("UNTIL"); err != nil {
+			return nil, err
+		}
+		if statement.Cond, err = p.expr(); err != nil {
+			return nil, err
+		}
+	}
+	return statement, p.expectEnd(statement.Kind)
+}
+
+func (p *parser) forStatement() (Statement, error) {
+	statement := &ForStatement{Pos: p.next().pos}
+	var err error
+	if statement.Name, err = p.identifier(); err != nil {
+		return nil, err
+	}
+	if err := p.expectWord("IN"); err != nil {
+		return nil, err
+	}
+	if err := p.expectSymbol("("); err != nil {
+		return nil, err
+	}
+	start := p.peek().start
+	if statement.Query, err = p.query(); err != nil {
+		return nil, err
+	}
+	statement.QueryText = strings.TrimSpace(p.source[start:p.peek().start])
+	if err := p.expectSymbol(")"); err != nil {
+		return nil, err
+	}
+	if err := p.expectWord("DO"); err != nil {
+		return nil, err
+	}
+	p.loops += 1
+	statement.Body, err = p.statementList(false)
+	p.loops -= 1
+	if err != nil {
+		return nil, err
+	}
+	return statement, p.expectEnd("FOR")
+}
+
+func (p *parser) blockStatement() (Statement, error) {
+	statement := &BlockStatement{Pos: p.next().pos}
+	var err error
+	if statement.Body, err = p.statementList(true); err != nil {
+		return nil, err
+	}
+	if p.acceptWord("EXCEPTION") {
+		for _, word := range []string{"WHEN", "ERROR", "THEN"} {
+			if err := p.expectWord(word); err != nil {
+				return nil, err
+			}
+		}
+		// a loop around the block can't be broken out of from its handler
+		loops := p.loops
+		p.loops = 0
+		statement.Handler, err = p.statementList(false)
+		p.loops = loops
+		if err != nil {
+			return nil, err
+		}
+	}
+	return statement, p.expectWord("END")
+}
+
+// transactionStatement parses BEGIN, COMMIT or ROLLBACK, which BEGIN
+// alone tells apart from a block by the semicolon after it.
+func (p *parser) transactionStatement() (Statement, error) {
+	keyword := p.next()
+	p.acceptWord("TRANSACTION")
+	return &TransactionStatement{Pos: keyword.pos, Kind: strings.ToUpper(keyword.text)}, nil
+}
+
+func (p *parser) raiseStatement() (Statement, error) {
+	statement := &RaiseStatement{Pos: p.next().pos}
+	if p.acceptWord("USING") {
+		if err := p.expectWord("MESSAGE"); err != nil {
+			return nil, err
+		}
+		if err := p.expectSymbol("="); err != nil {
+			return nil, err
+		}
+		var err error
+		if statement.Message, err = p.expr(); err != nil {
+			return nil, err
+		}
+	}
+	return statement, nil
+}
+
+func (p *parser) executeImmediateStatement() (Statement, error) {
+	statement := &ExecuteImmediateStatement{Pos: p.next().pos}
+	if err := p.expectWord("IMMEDIATE"); err != nil {
+		return nil, err
+	}
+	var err error
+	if statement.SQL, err = p.expr(); err != nil {
+		return nil, err
+	}
+	if p.acceptWord("INTO") {
+		if statement.Into, err = p.identifierList(); err != nil {
+			return nil, err
+		}
+	}
+	if p.acceptWord("USING") {
+		for {
+			x, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			name := ""
+			if p.acceptWord("AS") {
+				if name, err = p.identifier(); err != nil {
+					return nil, err
+				}
+			}
+			statement.Using = append(statement.Using, x)
+			statement.UsingNames = append(statement.UsingNames, name)
+			if !p.acceptSymbol(",") {
+				break
+			}
+		}
+	}
+	return statement, nil
+}
+
+func (p *parser) targetTable() (*TableName, error) {
+	pos := p.peek().pos
+	path, err := p.path()
+	if err != nil {
+		return nil, err
+	}
+	target := &TableName{Pos: pos, Path: path}
+	if !p.isWord("SET") && !p.isWord("WHERE") && !p.isWord("USING") && !p.isWord("FROM") {
+		if target.Alias, err = p.alias(); err != nil {
+			return nil, err
+		}
+	}
+	return target, nil
+}
+
+func (p *parser) insertStatement() (Statement, error) {
+	statement := &InsertStatement{Pos: p.next().pos}
+	p.acceptWord("INTO")
+	pos := p.peek().pos
+	path, err := p.path()
+	if err != nil {
+		return nil, err
+	}
+	statement.Target = &TableName{Pos: pos, Path: path}
+
+	if p.isSymbol("(") && !p.isWordAt(1, "SELECT") && !p.isWordAt(1, "WITH") {
+		p.next()
+		if statement.Columns, err = p.identifierList(); err != nil {
+			return nil, err
+		}
+		if err := p.expectSymbol(")"); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.acceptWord("VALUES") {
+		statement.Values = [][]Expr{}
+		for {
+			if err := p.expectSymbol("("); err != nil {
+				return nil, err
+			}
+			values, err := p.valueList()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expectSymbol(")"); err != nil {
+				return nil, err
+			}
+			statement.Values = append(statement.Values, values)
+			if !p.acceptSymbol(",") {
+				break
+			}
+		}
+	} else {
+		if statement.Query, err = p.query(); err != nil {
+			return nil, err
+		}
+	}
+	return statement, nil
+}
+
+func (p *parser) identifierList() ([]string, error) {
+	names := []string{}
+	for {
+		name, err := p.identifier()
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+		if !p.acceptSymbol(",") {
+			return names, nil
+		}
+	}
+}
+
+func (p *parser) expressionList() ([]Expr, error) {
+	exprs := []Expr{}
+	for {
+		expr, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, expr)
+		if !p.acceptSymbol(",") {
+			return exprs, nil
+		}
+	}
+}
+
+// valueList parses the values of an INSERT, where DEFAULT (a nil Expr)
+// stands for NULL.
+func (p *parser) valueList() ([]Expr, error) {
+	exprs := []Expr{}
+	for {
+		var expr Expr
+		if p.isKeyword("DEFAULT") {
+			p.next()
+		} else {
+			var err error
+			if expr, err = p.expr(); err != nil {
+				return nil, err
+			}
+		}
+		exprs = append(exprs, expr)
+		if !p.acceptSymbol(",") {
+			return exprs, nil
+		}
+	}
+}
+
+func (p *parser) setClauses() ([]SetClause, error) {
+	sets := []SetClause{}
+	for {
+		pos := p.peek().pos
+		path, err := p.path()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectSymbol("="); err != nil {
+			return nil, err
+		}
+		set := SetClause{Pos: pos, Path: path}
+		if p.isKeyword("DEFAULT") {
+			p.next()
+		} else if set.X, err = p.expr(); err != nil {
+			return nil, err
+		}
+		sets = append(sets, set)
+		if !p.acceptSymbol(",") {
+			return sets, nil
+		}
+	}
+}
+
+func (p *parser) updateStatement() (Statement, error) {
+	statement := &UpdateStatement{Pos: p.next().pos}
+	var err error
+	if statement.Target, err = p.targetTable(); err != nil {
+		return nil, err
+	}
+	if err := p.expectWord("SET"); err != nil {
+		return nil, err
+	}
+	if statement.Sets, err = p.setClauses(); err != nil {
+		return nil, err
+	}
+	if p.acceptWord("FROM") {
+		if statement.From, err = p.fromClause(); err != nil {
+			return nil, err
+		}
+	}
+	if !p.acceptWord("WHERE") {
+		return nil, &syntaxError{"UPDATE must have a WHERE clause", p.peek().pos}
+	}
+	if statement.Where, err = p.expr(); err != nil {
+		return nil, err
+	}
+	return statement, nil
+}
+
+func (p *parser) deleteStatement() (Statement, error) {
+	statement := &DeleteStatement{Pos: p.next().pos}
+	p.acceptWord("FROM")
+	var err error
+	if statement.Target, err = p.targetTable(); err != nil {
+		return nil, err
+	}
+	if !p.acceptWord("WHERE") {
+		return nil, &syntaxError{"DELETE must have a WHERE clause", p.peek().pos}
+	}
+	if statement.Where, err = p.expr(); err != nil {
+		return nil, err
+	}
+	return statement, nil
+}
+
+func (p *parser) mergeStatement() (Statement, error) {
+	statement := &MergeStatement{Pos: p.next().pos}
+	p.acceptWord("INTO")
+	var err error
+	if statement.Target, err = p.targetTable(); err != nil {
+		return nil, err
+	}
+	if err := p.expectWord("USING"); err != nil {
+		return nil, err
+	}
+	if statement.Source, err = p.fromPrimary(); err != nil {
+		return nil, err
+	}
+	if err := p.expectWord("ON"); err != nil {
+		return nil, err
+	}
+	if statement.On, err = p.expr(); err != nil {
+		return nil, err
+	}
+
+	for p.isWord("WHEN") {
+		clause := MergeClause{Pos: p.next().pos}
+		if p.acceptWord("MATCHED") {
+			clause.Kind = "MATCHED"
+		} else {
+			if err := p.expectWord("NOT"); err != nil {
+				return nil, err
+			}
+			if err := p.expectWord("MATCHED"); err != nil {
+				return nil, err
+			}
+			clause.Kind = "NOT_MATCHED_BY_TARGET"
+			if p.acceptWord("BY") {
+				if p.acceptWord("SOURCE") {
+					clause.Kind = "NOT_MATCHED_BY_SOURCE"
+				} else if err := p.expectWord("TARGET"); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if p.acceptWord("AND") {
+			if clause.Cond, err = p.expr(); err != nil {
+				return nil, err
+			}
+		}
+		if err := p.expectWord("THEN"); err != nil {
+			return nil, err
+		}
+
+		switch {
+		case p.acceptWord("UPDATE"):
+			clause.Action = "UPDATE"
+			if err := p.expectWord("SET"); err != nil {
+				return nil, err
+			}
+			if clause.Sets, err = p.setClauses(); err != nil {
+				return nil, err
+			}
+		case p.acceptWord("DELETE"):
+			clause.Action = "DELETE"
+		case p.acceptWord("INSERT"):
+			clause.Action = "INSERT"
+			if p.acceptWord("ROW") {
+				clause.InsertRow = true
+				break
+			}
+			if p.acceptSymbol("(") {
+				if clause.Columns, err = p.identifierList(); err != nil {
+					return nil, err
+				}
+				if err := p.expectSymbol(")"); err != nil {
+					return nil, err
+				}
+			}
+			if err := p.expectWord("VALUES"); err != nil {
+				return nil, err
+			}
+			if err := p.expectSymbol("("); err != nil {
+				return nil, err
+			}
+			if clause.Values, err = p.valueList(); err != nil {
+				return nil, err
+			}
+			if err := p.expectSymbol(")"); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, p.unexpected("keyword UPDATE, DELETE or INSERT")
+		}
+		if (clause.Kind == "NOT_MATCHED_BY_TARGET") != (clause.Action == "INSERT") {
+			return nil, &syntaxError{fmt.Sprintf("%s is not allowed in WHEN %s clauses",
+				clause.Action, strings.Replace(strings.Replace(clause.Kind, "_", " ", -1), "NOT MATCHED BY TARGET", "NOT MATCHED", 1)),
+				clause.Pos}
+		}
+		statement.Clauses = append(statement.Clauses, clause)
+	}
+	if len(statement.Clauses) == 0 {
+		return nil, p.unexpected("keyword WHEN")
+	}
+	return statement, nil
+}
+
+// DDL
+
+// ifExists parses an optional IF EXISTS, or IF NOT EXISTS when not is set.
+func (p *parser) ifExists(not bool) (bool, error) {
+	if !p.acceptWord("IF") {
+		return false, nil
+	}
+	if not {
+		if err := p.expectWord("NOT"); err != nil {
+			return false, err
+		}
+	}
+	return true, p.expectWord("EXISTS")
+}
+
+func (p *parser) options() ([]Option, error) {
+	if err := p.expectSymbol("("); err != nil {
+		return nil, err
+	}
+	options := []Option{}
+	for !p.acceptSymbol(")") {
+		option := Option{Pos: p.peek().pos}
+		var err error
+		if option.Name, err = p.identifier(); err != nil {
+			return nil, err
+		}
+		if err := p.expectSymbol("="); err != nil {
+			return nil, err
+		}
+		if option.X, err = p.expr(); err != nil {
+			return nil, err
+		}
+		options = append(options, option)
+		if !p.acceptSymbol(",") {
+			if err := p.expectSymbol(")"); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	return options, nil
+}
+
+func (p *parser) columnDef() (ColumnDef, error) {
+	column := ColumnDef{Pos: p.peek().pos}
+	var err error
+	if column.Name, err = p.identifier(); err != nil {
+		return column, err
+	}
+	if column.Type, err = p.typeSpec(); err != nil {
+		return column, err
+	}
+	if p.acceptWord("NOT") {
+		if err := p.expectWord("NULL"); err != nil {
+			return column, err
+		}
+		column.NotNull = true
+	}
+	if p.acceptWord("OPTIONS") {
+		if column.Options, err = p.options(); err != nil {
+			return column, err
+		}
+	}
+	return column, nil
+}
+
+func (p *parser) tableName() (*TableName, error) {
+	pos := p.peek().pos
+	path, err := p.path()
+	if err != nil {
+		return nil, err
+	}
+	return &TableName{Pos: pos, Path: path}, nil
+}
+
+func (p *parser) createStatement() (Statement, error) {
+	pos := p.next().pos
+	orReplace := false
+	if p.acceptWord("OR") {
+		if err := p.expectWord("REPLACE"); err != nil {
+			return nil, err
+		}
+		orReplace = true
+	}
+	temporary := p.acceptWord("TEMP") || p.acceptWord("TEMPORARY")
+	if !temporary {
+		if p.isWord("SCHEMA") {
+			if orReplace {
+				return nil, &syntaxError{"CREATE OR REPLACE SCHEMA is not supported", pos}
+			}
+			return p.createSchemaStatement(pos)
+		}
+		if materialized := p.acceptWord("MATERIALIZED"); materialized || p.isWord("VIEW") {
+			return p.createViewStatement(pos, orReplace, materialized)
+		}
+	}
+	if err := p.expectWord("TABLE"); err != nil {
+		return nil, err
+	}
+
+	statement := &CreateTableStatement{Pos: pos, OrReplace: orReplace, Temporary: temporary}
+	var err error
+	if statement.IfNotExists, err = p.ifExists(true); err != nil {
+		return nil, err
+	}
+	if statement.OrReplace && statement.IfNotExists {
+		return nil, &syntaxError{"CREATE TABLE cannot have both OR REPLACE and IF NOT EXISTS", pos}
+	}
+	if statement.Target, err = p.tableName(); err != nil {
+		return nil, err
+	}
+	if p.acceptSymbol("(") {
+		statement.Columns = []ColumnDef{}
+		for {
+			column, err := p.columnDef()
+			if err != nil {
+				return nil, err
+			}
+			statement.Columns = append(statement.Columns, column)
+			if !p.acceptSymbol(",") {
+				break
+			}
+		}
+		if err := p.expectSymbol(")"); err != nil {
+			return nil, err
+		}
+	}
+	if p.acceptWord("CLUSTER") {
+		if err := p.expectWord("BY"); err != nil {
+			return nil, err
+		}
+		if statement.ClusterBy, err = p.identifierList(); err != nil {
+			return nil, err
+		}
+	}
+	if p.acceptWord("OPTIONS") {
+		if statement.Options, err = p.options(); err != nil {
+			return nil, err
+		}
+	}
+	if p.acceptWord("AS") {
+		if statement.Query, err = p.query(); err != nil {
+			return nil, err
+		}
+	} else if statement.Columns == nil {
+		return nil, p.unexpected("keyword AS")
+	}
+	return statement, nil
+}
+
+func (p *parser) createViewStatement(pos position, orReplace, materialized bool) (Statement, error) {
+	if err := p.expectWord("VIEW"); err != nil {
+		return nil, err
+	}
+	statement := &CreateViewStatement{Pos: pos, OrReplace: orReplace, Materialized: materialized}
+	var err error
+	if statement.IfNotExists, err = p.ifExists(true); err != nil {
+		return nil, err
+	}
+	if statement.OrReplace && statement.IfNotExists {
+		return nil, &syntaxError{"CREATE VIEW cannot have both OR REPLACE and IF NOT EXISTS", pos}
+	}
+	if statement.Target, err = p.tableName(); err != nil {
+		return nil, err
+	}
+	if materialized && p.acceptWord("CLUSTER") {
+		if err := p.expectWord("BY"); err != nil {
+			return nil, err
+		}
+		if statement.ClusterBy, err = p.identifierList(); err != nil {
+			return nil, err
+		}
+	}
+	if p.acceptWord("OPTIONS") {
+		if statement.Options, err = p.options(); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expectWord("AS"); err != nil {
+		return nil, err
+	}
+	start := p.peek().start
+	if statement.Query, err = p.query(); err != nil {
+		return nil, err
+	}
+	statement.QueryText = strings.TrimSpace(p.source[start:p.peek().start])
+	return statement, nil
+}
+
+func (p *parser) createSchemaStatement(pos position) (Statement, error) {
+	p.next()
+	statement := &CreateSchemaStatement{Pos: pos}
+	var err error
+	if statement.IfNotExists, err = p.ifExists(true); err != nil {
+		return nil, err
+	}
+	if statement.Path, err = p.path(); err != nil {
+		return nil, err
+	}
+	if p.acceptWord("OPTIONS") {
+		if statement.Options, err = p.options(); err != nil {
+			return nil, err
+		}
+	}
+	return statement, nil
+}
+
+func (p *parser) dropStatement() (Statement, error) {
+	pos := p.next().pos
+	var err error
+	if p.acceptWord("SCHEMA") {
+		statement := &DropSchemaStatement{Pos: pos}
+		if statement.IfExists, err = p.ifExists(false); err != nil {
+			return nil, err
+		}
+		if statement.Path, err = p.path(); err != nil {
+			return nil, err
+		}
+		if p.acceptWord("CASCADE") {
+			statement.Cascade = true
+		} else {
+			p.acceptWord("RESTRICT")
+		}
+		return statement, nil
+	}
+
+	statement := &DropTableStatement{Pos: pos, Kind: "TABLE"}
+	if p.acceptWord("MATERIALIZED") {
+		if err := p.expectWord("VIEW"); err != nil {
+			return nil, err
+		}
+		statement.Kind = "MATERIALIZED VIEW"
+	} else if p.acceptWord("VIEW") {
+		statement.Kind = "VIEW"
+	} else {
+		if p.acceptWord("SNAPSHOT") {
+			statement.Kind = "SNAPSHOT TABLE"
+		}
+		if err := p.expectWord("TABLE"); err != nil {
+			return nil, err
+		}
+	}
+	if statement.IfExists, err = p.ifExists(false); err != nil {
+		return nil, err
+	}
+	if statement.Target, err = p.tableName(); err != nil {
+		return nil, err
+	}
+	return statement, nil
+}
+
+func (p *parser) alterStatement() (Statement, error) {
+	statement := &AlterTableStatement{Pos: p.next().pos, Kind: "TABLE"}
+	if p.acceptWord("MATERIALIZED") {
+		if err := p.expectWord("VIEW"); err != nil {
+			return nil, err
+		}
+		statement.Kind = "MATERIALIZED VIEW"
+	} else if p.acceptWord("VIEW") {
+		statement.Kind = "VIEW"
+	} else if err := p.expectWord("TABLE"); err != nil {
+		return nil, err
+	}
+	var err error
+	if statement.IfExists, err = p.ifExists(false); err != nil {
+		return nil, err
+	}
+	if statement.Target, err = p.tableName(); err != nil {
+		return nil, err
+	}
+	for {
+		action, err := p.alterAction()
+		if err != nil {
+			return nil, err
+		}
+		statement.Actions = append(statement.Actions, action)
+		if !p.acceptSymbol(",") {
+			return statement, nil
+		}
+	}
+}
+
+func (p *parser) callStatement() (Statement, error) {
+	statement := &CallStatement{Pos: p.next().pos}
+	var err error
+	if statement.Procedure, err = p.path(); err != nil {
+		return nil, err
+	}
+	if err := p.expectSymbol("("); err != nil {
+		return nil, err
+	}
+	statement.Args = []Expr{}
+	if !p.isSymbol(")") {
+		if statement.Args, err = p.expressionList(); err != nil {
+			return nil, err
+		}
+	}
+	return statement, p.expectSymbol(")")
+}
+
+func (p *parser) alterAction() (AlterAction, error) {
+	action := AlterAction{Pos: p.peek().pos}
+	var err error
+	switch {
+	case p.acceptWord("ADD"):
+		if err := p.expectWord("COLUMN"); err != nil {
+			return action, err
+		}
+		action.Kind = "ADD COLUMN"
+		if action.IfExists, err = p.ifExists(true); err != nil {
+			return action, err
+		}
+		action.Column, err = p.columnDef()
+	case p.acceptWord("DROP"):
+		if err := p.expectWord("COLUMN"); err != nil {
+			return action, err
+		}
+		action.Kind = "DROP COLUMN"
+		if action.IfExists, err = p.ifExists(false); err != nil {
+			return action, err
+		}
+		action.Name, err = p.identifier()
+	case p.acceptWord("RENAME"):
+		if p.acceptWord("COLUMN") {
+			action.Kind = "RENAME COLUMN"
+			if action.IfExists, err = p.ifExists(false); err != nil {
+				return action, err
+			}
+			if action.Name, err = p.identifier(); err != nil {
+				return action, err
+			}
+		} else {
+			action.Kind = "RENAME TO"
+		}
+		if err := p.expectWord("TO"); err != nil {
+			return action, err
+		}
+		action.NewName, err = p.identifier()
+	case p.acceptWord("SET"):
+		if err := p.expectWord("OPTIONS"); err != nil {
+			return action, err
+		}
+		action.Kind = "SET OPTIONS"
+		action.Options, err = p.options()
+	default:
+		err = p.unexpected("keyword ADD, DROP, RENAME or SET")
+	}
+	return action, err
+}
+
+// Queries
+
+func (p *parser) query() (*Query, error) {
+	query := &Query{}
+	if !p.legacy && p.acceptWord("WITH") {
+		p.acceptWord("RECURSIVE")
+		for {
+			name, err := p.identifier()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expectWord("AS"); err != nil {
+				return nil, err
+			}
+			if err := p.expectSymbol("("); err != nil {
+				return nil, err
+			}
+			cteQuery, err := p.query()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expectSymbol(")"); err != nil {
+				return nil, err
+			}
+			query.With = append(query.With, CTE{Name: name, Query: cteQuery})
+			if !p.acceptSymbol(",") {
+				break
+			}
+		}
+	}
+
+	var err error
+	if query.Body, err = p.setOperation(); err != nil {
+		return nil, err
+	}
+	if query.OrderBy, query.Limit, query.Offset, err = p.orderAndLimit(); err != nil {
+		return nil, err
+	}
+	return query, nil
+}
+
+func (p *parser) orderAndLimit() ([]OrderItem, Expr, Expr, error) {
+	var orderBy []OrderItem
+	var limit, offset Expr
+	var err error
+	if p.isKeyword("ORDER") {
+		if orderBy, err = p.orderBy(); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	if p.acceptWord("LIMIT") {
+		if limit, err = p.unary(); err != nil {
+			return nil, nil, nil, err
+		}
+		if p.acceptWord("OFFSET") {
+			if offset, err = p.unary(); err != nil {
+				return nil, nil, nil, err
+			}
+		}
+	}
+	return orderBy, limit, offset, nil
+}
+
+func (p *parser) orderBy() ([]OrderItem, error) {
+	p.next()
+	if err := p.expectWord("BY"); err != nil {
+		return nil, err
+	}
+	items := []OrderItem{}
+	for {
+		expr, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		item := OrderItem{Expr: expr}
+		if p.acceptWord("DESC") {
+			item.Desc = true
+		} else {
+			p.acceptWord("ASC")
+		}
+		if p.acceptWord("NULLS") {
+			nullsFirst := p.acceptWord("FIRST")
+			if !nullsFirst {
+				if err := p.expectWord("LAST"); err != nil {
+					return nil, err
+				}
+			}
+			item.NullsFirst = &nullsFirst
+		}
+		items = append(items, item)
+		if !p.acceptSymbol(",") {
+			return items, nil
+		}
+	}
+}
+
+func (p *parser) setOperation() (QueryBody, error) {
+	left, err := p.queryPrimary()
+	if err != nil {
+		return nil, err
+	}
+	for !p.legacy && (p.isWord("UNION") || p.isWord("INTERSECT") || p.isWord("EXCEPT")) {
+		operation := &SetOperation{Pos: p.peek().pos, Op: p.next().text, Left: left}
+		if p.acceptWord("DISTINCT") {
+			operation.Distinct = true
+		} else if !p.acceptWord("ALL") {
+			return nil, p.unexpected("keyword ALL or DISTINCT")
+		}
+		if operation.Right, err = p.queryPrimary(); err != nil {
+			return nil, err
+		}
+		left = operation
+	}
+	return left, nil
+}
+
+func (p *parser) queryPrimary() (QueryBody, error) {
+	if p.acceptSymbol("(") {
+		query, err := p.query()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectSymbol(")"); err != nil {
+			return nil, err
+		}
+		return query, nil
+	}
+	return p.selectBody()
+}
+
+func (p *parser) selectBody() (*Select, error) {
+	if !p.isWord("SELECT") {
+		return nil, p.unexpected("keyword SELECT")
+	}
+	selectBody := &Select{Pos: p.next().pos}
+	if p.acceptWord("DISTINCT") {
+		selectBody.Distinct = true
+	} else {
+		p.acceptWord("ALL")
+	}
+	if !p.legacy && p.isWord("AS") && p.isWordAt(1, "STRUCT") {
+		p.next()
+		p.next()
+		selectBody.AsStruct = true
+	}
+
+	for {
+		item, err := p.selectItem()
+		if err != nil {
+			return nil, err
+		}
+		selectBody.Items = append(selectBody.Items, item)
+		if !p.acceptSymbol(",") {
+			break
+		}
+		// A trailing comma before FROM is allowed
+		if p.isWord("FROM") {
+			break
+		}
+	}
+
+	var err error
+	if p.acceptWord("FROM") {
+		if p.legacy {
+			selectBody.From, err = p.legacyFromClause()
+		} else {
+			selectBody.From, err = p.fromClause()
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if p.acceptWord("WHERE") {
+		if selectBody.Where, err = p.expr(); err != nil {
+			return nil, err
+		}
+	}
+	if p.isKeyword("GROUP") {
+		p.next()
+		if p.legacy {
+			p.acceptWord("EACH")
+		}
+		if err := p.expectWord("BY"); err != nil {
+			return nil, err
+		}
+		if selectBody.GroupBy, err = p.expressionList(); err != nil {
+			return nil, err
+		}
+	}
+	if p.acceptWord("HAVING") {
+		if selectBody.Having, err = p.expr(); err != nil {
+			return nil, err
+		}
+	}
+	if !p.legacy && p.acceptWord("QUALIFY") {
+		if selectBody.Qualify, err = p.expr(); err != nil {
+			return nil, err
+		}
+	}
+	return selectBody, nil
+}
+
+func (p *parser) selectItem() (SelectItem, error) {
+	item := SelectItem{}
+	if p.acceptSymbol("*") {
+		item.Star = true
+	} else {
+		expr, err := p.expr()
+		if err != nil {
+			return item, err
+		}
+		if p.isSymbol(".") && p.peekAt(1).kind == TOKEN_SYMBOL && p.peekAt(1).text == "*" {
+			p.next()
+			p.next()
+			item.Star = true
+			item.Of = expr
+		} else {
+			item.Expr = expr
+			if item.Alias, err = p.alias(); err != nil {
+				return item, err
+			}
+			return item, nil
+		}
+	}
+
+	if p.legacy {
+		return item, nil
+	}
+	if p.acceptWord("EXCEPT") {
+		if err := p.expectSymbol("("); err != nil {
+			return item, err
+		}
+		names, err := p.identifierList()
+		if err != nil {
+			return item, err
+		}
+		item.Except = names
+		if err := p.expectSymbol(")"); err != nil {
+			return item, err
+		}
+	}
+	if p.acceptWord("REPLACE") {
+		if err := p.expectSymbol("("); err != nil {
+			return item, err
+		}
+		for {
+			expr, err := p.expr()
+			if err != nil {
+				return item, err
+			}
+			if err := p.expectWord("AS"); err != nil {
+				return item, err
+			}
+			name, err := p.identifier()
+			if err != nil {
+				return item, err
+			}
+			item.Replace = append(item.Replace, SelectItem{Expr: expr, Alias: name})
+			if !p.acceptSymbol(",") {
+				break
+			}
+		}
+		if err := p.expectSymbol(")"); err != nil {
+			return item, err
+		}
+	}
+	return item, nil
+}
+
+func (p *parser) fromClause() (FromItem, error) {
+	left, err := p.fromPrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		join := &Join{Pos: p.peek().pos, Left: left}
+		switch {
+		case p.acceptSymbol(","):
+			join.Kind = "COMMA"
+		case p.isWord("CROSS"):
+			p.next()
+			join.Kind = "CROSS"
+		case p.isWord("INNER"):
+			p.next()
+			join.Kind = "INNER"
+		case p.isWord("JOIN"):
+			join.Kind = "INNER"
+		case p.isWord("LEFT"), p.isWord("RIGHT"), p.isWord("FULL"):
+			join.Kind = p.next().text
+			p.acceptWord("OUTER")
+		default:
+			return left, nil
+		}
+		if join.Kind != "COMMA" {
+			if err := p.expectWord("JOIN"); err != nil {
+				return nil, err
+			}
+		}
+		if join.Right, err = p.fromPrimary(); err != nil {
+			return nil, err
+		}
+		if join.Kind != "COMMA" && join.Kind != "CROSS" {
+			if p.acceptWord("ON") {
+				if join.On, err = p.expr(); err != nil {
+					return nil, err
+				}
+			} else if p.acceptWord("USING") {
+				if err := p.expectSymbol("("); err != nil {
+					return nil, err
+				}
+				if join.Using, err = p.identifierList(); err != nil {
+					return nil, err
+				}
+				if err := p.expectSymbol(")"); err != nil {
+					return nil, err
+				}
+			} else if _, isUnnest := join.Right.(*UnnestFrom); !isUnnest {
+				return nil, p.unexpected("keyword ON or USING")
+			}
+		}
+		left = join
+	}
+}
+
+func (p *parser) fromPrimary() (FromItem, error) {
+	pos := p.peek().pos
+	var err error
+	switch {
+	case p.isWord("UNNEST"):
+		p.next()
+		if err := p.expectSymbol("("); err != nil {
+			return nil, err
+		}
+		unnest := &UnnestFrom{Pos: pos}
+		if unnest.X, err = p.expr(); err != nil {
+			return nil, err
+		}
+		if err := p.expectSymbol(")"); err != nil {
+			return nil, err
+		}
+		if unnest.Alias, err = p.alias(); err != nil {
+			return nil, err
+		}
+		if p.isWord("WITH") && p.isWordAt(1, "OFFSET") {
+			p.next()
+			p.next()
+			unnest.WithOffset = true
+			if unnest.OffsetAlias, err = p.alias(); err != nil {
+				return nil, err
+			}
+		}
+		return unnest, nil
+
+	case p.isSymbol("("):
+		if p.isWordAt(1, "SELECT") || p.isWordAt(1, "WITH") || p.isSymbol("(") && p.peekAt(1).text == "(" {
+			p.next()
+			query, err := p.query()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expectSymbol(")"); err != nil {
+				return nil, err
+			}
+			subquery := &SubqueryFrom{Pos: pos, Query: query}
+			if subquery.Alias, err = p.alias(); err != nil {
+				return nil, err
+			}
+			return subquery, nil
+		}
+		p.next()
+		item, err := p.fromClause()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectSymbol(")"); err != nil {
+			return nil, err
+		}
+		return item, nil
+
+	default:
+		path, err := p.path()
+		if err != nil {
+			return nil, err
+		}
+		table := &TableName{Pos: pos, Path: path}
+		if p.isKeyword("FOR") && p.isWordAt(1, "SYSTEM_TIME") {
+			p.next()
+			p.next()
+			if err := p.expectWord("AS"); err != nil {
+				return nil, err
+			}
+			if err := p.expectWord("OF"); err != nil {
+				return nil, err
+			}
+			if table.SystemTime, err = p.expr(); err != nil {
+				return nil, err
+			}
+		}
+		if table.Alias, err = p.alias(); err != nil {
+			return nil, err
+		}
+		return table, nil
+	}
+}
+
+// Expressions, from lowest to highest precedence
+
+func (p *parser) expr() (Expr, error) {
+	return p.or()
+}
+
+func (p *parser) or() (Expr, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("OR") {
+		pos := p.next().pos
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		left = &Binary{Pos: pos, Op: "OR", L: left, R: right}
+	}
+	return left, nil
+}
+
+func (p *parser) and() (Expr, error) {
+	left, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("AND") {
+		pos := p.next().pos
+		right, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		left = &Binary{Pos: pos, Op: "AND", L: left, R: right}
+	}
+	return left, nil
+}
+
+func (p *parser) not() (Expr, error) {
+	if p.isKeyword("NOT") {
+		pos := p.next().pos
+		x, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return &Unary{Pos: pos, Op: "NOT", X: x}, nil
+	}
+	return p.comparison()
+}
+
+func (p *parser) comparison() (Expr, error) {
+	left, err := p.bitOr()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		pos := p.peek().pos
+		switch {
+		case p.isSymbol("=") || p.isSymbol("!=") || p.isSymbol("<>") || p.isSymbol("<") ||
+			p.isSymbol("<=") || p.isSymbol(">") || p.isSymbol(">="):
+			op := p.next().text
+			if op == "<>" {
+				op = "!="
+			}
+			right, err := p.bitOr()
+			if err != nil {
+				return nil, err
+			}
+			left = &Binary{Pos: pos, Op: op, L: left, R: right}
+
+		case p.isKeyword("IS"):
+			p.next()
+			is := &IsExpr{Pos: pos, X: left, Not: p.acceptWord("NOT")}
+			switch {
+			case p.acceptWord("NULL"):
+				is.What = "NULL"
+			case p.acceptWord("TRUE"):
+				is.What = "TRUE"
+			case p.acceptWord("FALSE"):
+				is.What = "FALSE"
+			default:
+				return nil, p.unexpected("keyword NULL, TRUE or FALSE")
+			}
+			left = is
+
+		case p.isKeyword("NOT") && (p.isWordAt(1, "IN") || p.isWordAt(1, "LIKE") || p.isWordAt(1, "BETWEEN")):
+			p.next()
+			if left, err = p.comparisonSuffix(left, pos); err != nil {
+				return nil, err
+			}
+			if inExpr, ok := left.(*InExpr); ok {
+				inExpr.Not = true
+			} else if between, ok := left.(*Between); ok {
+				between.Not = true
+			} else {
+				left = &Unary{Pos: pos, Op: "NOT", X: left}
+			}
+
+		case p.isKeyword("IN") || p.isKeyword("LIKE") || p.isKeyword("BETWEEN"):
+			if left, err = p.comparisonSuffix(left, pos); err != nil {
+				return nil, err
+			}
+
+		default:
+			return left, nil
+		}
+	}
+}
+
+func (p *parser) comparisonSuffix(left Expr, pos position) (Expr, error) {
+	switch p.next().text {
+	case "IN":
+		in := &InExpr{Pos: pos, X: left}
+		if p.acceptWord("UNNEST") {
+			if err := p.expectSymbol("("); err != nil {
+				return nil, err
+			}
+			var err error
+			if in.Unnest, err = p.expr(); err != nil {
+				return nil, err
+			}
+			return in, p.expectSymbol(")")
+		}
+		if err := p.expectSymbol("("); err != nil {
+			return nil, err
+		}
+		var err error
+		if p.isWord("SELECT") || p.isWord("WITH") {
+			if in.Query, err = p.query(); err != nil {
+				return nil, err
+			}
+		} else if in.List, err = p.expressionList(); err != nil {
+			return nil, err
+		}
+		return in, p.expectSymbol(")")
+
+	case "LIKE":
+		right, err := p.bitOr()
+		if err != nil {
+			return nil, err
+		}
+		return &Binary{Pos: pos, Op: "LIKE", L: left, R: right}, nil
+
+	default: // BETWEEN
+		low, err := p.bitOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectWord("AND"); err != nil {
+			return nil, err
+		}
+		high, err := p.bitOr()
+		if err != nil {
+			return nil, err
+		}
+		return &Between{Pos: pos, X: left, Low: low, High: high}, nil
+	}
+}
+
+func (p *parser) binaryLevel(operators []string, operand func() (Expr, error)) (Expr, error) {
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		matched := ""
+		for _, operator := range operators {
+			if p.isSymbol(operator) {
+				matched = operator
+			}
+		}
+		if matched == "" {
+			return left, nil
+		}
+		pos := p.next().pos
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		left = &Binary{Pos: pos, Op: matched, L: left, R: right}
+	}
+}
+
+func (p *parser) bitOr() (Expr, error) {
+	return p.binaryLevel([]string{"|"}, p.bitXor)
+}
+
+func (p *parser) bitXor() (Expr, error) {
+	return p.binaryLevel([]string{"^"}, p.bitAnd)
+}
+
+func (p *parser) bitAnd() (Expr, error) {
+	return p.binaryLevel([]string{"&"}, p.shift)
+}
+
+func (p *parser) shift() (Expr, error) {
+	return p.binaryLevel([]string{"<<", ">>"}, p.additive)
+}
+
+func (p *parser) additive() (Expr, error) {
+	return p.binaryLevel([]string{"+", "-"}, p.multiplicative)
+}
+
+func (p *parser) multiplicative() (Expr, error) {
+	return p.binaryLevel([]string{"*", "/", "||"}, p.unary)
+}
+
+func (p *parser) unary() (Expr, error) {
+	if p.isSymbol("-") || p.isSymbol("+") || p.isSymbol("~") {
+		t := p.next()
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		if t.text == "+" {
+			return x, nil
+		}
+		if literal, ok := x.(*Literal); ok && t.text == "-" {
+			switch value := literal.Value.(type) {
+			case int64:
+				return &Literal{Pos: t.pos, Value: -value, Type: literal.Type}, nil
+			case float64:
+				return &Literal{Pos: t.pos, Value: -value, Type: literal.Type}, nil
+			}
+		}
+		return &Unary{Pos: t.pos, Op: t.text, X: x}, nil
+	}
+	return p.postfix()
+}
+
+func (p *parser) postfix() (Expr, error) {
+	x, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		pos := p.peek().pos
+		switch {
+		case p.isSymbol("["):
+			p.next()
+			index := &Index{Pos: pos, X: x, Mode: "OFFSET"}
+			t := p.peek()
+			if t.kind == TOKEN_IDENT && p.peekAt(1).text == "(" {
+				switch mode := strings.ToUpper(t.text); mode {
+				case "OFFSET", "ORDINAL", "SAFE_OFFSET", "SAFE_ORDINAL":
+					p.next()
+					p.next()
+					index.Mode = mode
+					if index.Index, err = p.expr(); err != nil {
+						return nil, err
+					}
+					if err := p.expectSymbol(")"); err != nil {
+						return nil, err
+					}
+				}
+			}
+			if index.Index == nil {
+				if index.Index, err = p.expr(); err != nil {
+					return nil, err
+				}
+			}
+			if err := p.expectSymbol("]"); err != nil {
+				return nil, err
+			}
+			x = index
+		case p.isSymbol(".") && p.peekAt(1).kind != TOKEN_SYMBOL:
+			p.next()
+			name, err := p.identifier()
+			if err != nil {
+				return nil, err
+			}
+			if path, ok := x.(*Path); ok {
+				path.Parts = append(path.Parts, name)
+			} else {
+				x = &FieldAccess{Pos: pos, X: x, Name: name}
+			}
+		default:
+			return x, nil
+		}
+	}
+}
+
+func (p *parser) primary() (Expr, error) {
+	t := p.peek()
+	pos := t.pos
+	switch t.kind {
+	case TOKEN_INTEGER:
+		p.next()
+		value, err := strconv.ParseInt(t.text, 0, 64)
+		if err != nil {
+			return nil, &syntaxError{"Invalid integer literal: " + t.text, pos}
+		}
+		return &Literal{Pos: pos, Value: value, Type: "INTEGER"}, nil
+	case TOKEN_FLOAT:
+		p.next()
+		value, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, &syntaxError{"Invalid floating point literal: " + t.text, pos}
+		}
+		return &Literal{Pos: pos, Value: value, Type: "FLOAT"}, nil
+	case TOKEN_STRING:
+		p.next()
+		return &Literal{Pos: pos, Value: t.text, Type: "STRING"}, nil
+	case TOKEN_BYTES:
+		p.next()
+		return &Literal{Pos: pos, Value: []byte(t.text), Type: "BYTES"}, nil
+	case TOKEN_PARAM:
+		p.next()
+		if t.text == "?" {
+			p.positional++
+			return &Param{Pos: pos, Index: p.positional - 1}, nil
+		}
+		return &Param{Pos: pos, Name: strings.TrimPrefix(t.text, "@")}, nil
+	case TOKEN_SYMBOL:
+		if t.text == "(" {
+			p.next()
+			if p.isWord("SELECT") || p.isWord("WITH") {
+				query, err := p.query()
+				if err != nil {
+					return nil, err
+				}
+				return &Subquery{Pos: pos, Kind: "SCALAR", Query: query}, p.expectSymbol(")")
+			}
+			x, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			if !p.legacy && p.isSymbol(",") {
+				// (a, b) is shorthand for STRUCT(a, b)
+				structLit := &StructLit{Pos: pos, Names: []string{""}, Values: []Expr{x}}
+				for p.acceptSymbol(",") {
+					value, err := p.expr()
+					if err != nil {
+						return nil, err
+					}
+					structLit.Names = append(structLit.Names, "")
+					structLit.Values = append(structLit.Values, value)
+				}
+				return structLit, p.expectSymbol(")")
+			}
+			return x, p.expectSymbol(")")
+		}
+		if t.text == "[" {
+			return p.arrayLiteral(nil)
+		}
+	case TOKEN_KEYWORD:
+		if p.legacy && (t.text == "ARRAY" || t.text == "STRUCT" || t.text == "INTERVAL") {
+			break
+		}
+		switch t.text {
+		case "NULL":
+			p.next()
+			return &Literal{Pos: pos, Type: "NULL"}, nil
+		case "TRUE", "FALSE":
+			p.next()
+			return &Literal{Pos: pos, Value: t.text == "TRUE", Type: "BOOLEAN"}, nil
+		case "CASE":
+			return p.caseExpr()
+		case "CAST":
+			p.next()
+			return p.cast(pos, false)
+		case "EXISTS":
+			p.next()
+			if err := p.expectSymbol("("); err != nil {
+				return nil, err
+			}
+			query, err := p.query()
+			if err != nil {
+				return nil, err
+			}
+			return &Subquery{Pos: pos, Kind: "EXISTS", Query: query}, p.expectSymbol(")")
+		case "ARRAY":
+			p.next()
+			if p.isSymbol("(") {
+				p.next()
+				query, err := p.query()
+				if err != nil {
+					return nil, err
+				}
+				return &Subquery{Pos: pos, Kind: "ARRAY", Query: query}, p.expectSymbol(")")
+			}
+			var elemType *TypeSpec
+			if p.acceptSymbol("<") {
+				var err error
+				if elemType, err = p.typeSpec(); err != nil {
+					return nil, err
+				}
+				if err := p.expectSymbol(">"); err != nil {
+					return nil, err
+				}
+			}
+			return p.arrayLiteral(elemType)
+		case "STRUCT":
+			return p.structLiteral()
+		case "EXTRACT":
+			p.next()
+			if err := p.expectSymbol("("); err != nil {
+				return nil, err
+			}
+			part, err := p.identifier()
+			if err != nil {
+				return nil, err
+			}
+			if p.acceptSymbol("(") {
+				weekday, err := p.identifier()
+				if err != nil {
+					return nil, err
+				}
+				part += "(" + strings.ToUpper(weekday) + ")"
+				if err := p.expectSymbol(")"); err != nil {
+					return nil, err
+				}
+			}
+			if err := p.expectWord("FROM"); err != nil {
+				return nil, err
+			}
+			x, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			return &Extract{Pos: pos, Part: strings.ToUpper(part), X: x}, p.expectSymbol(")")
+		case "INTERVAL":
+			p.next()
+			value, err := p.additive()
+			if err != nil {
+				return nil, err
+			}
+			unit, err := p.identifier()
+			if err != nil {
+				return nil, err
+			}
+			return &Interval{Pos: pos, Value: value, Unit: strings.ToUpper(unit)}, nil
+		case "IF", "LEFT", "RIGHT", "CURRENT":
+			// Reserved words that are also function names
+			if p.peekAt(1).text == "(" {
+				p.next()
+				return p.call(pos, t.text)
+			}
+		}
+	case TOKEN_IDENT, TOKEN_QUOTED_IDENT:
+		upper := strings.ToUpper(t.text)
+		next := p.peekAt(1)
+		if t.kind == TOKEN_IDENT && next.kind == TOKEN_STRING {
+			switch upper {
+			case "DATE", "TIME", "DATETIME", "TIMESTAMP", "NUMERIC", "BIGNUMERIC", "JSON":
+				p.next()
+				p.next()
+				return typedLiteral(pos, upper, next.text)
+			}
+		}
+		if t.kind == TOKEN_IDENT && upper == "SAFE_CAST" && next.text == "(" {
+			p.next()
+			return p.cast(pos, true)
+		}
+		if t.kind == TOKEN_IDENT && next.kind == TOKEN_SYMBOL && (next.text == "(" || next.text == ".") {
+			// Function names may be dotted, e.g. SAFE.PARSE_DATE or NET.HOST
+			name := upper
+			offset := 1
+			for p.peekAt(offset).text == "." && p.peekAt(offset+1).kind == TOKEN_IDENT {
+				name += "." + strings.ToUpper(p.peekAt(offset+1).text)
+				offset += 2
+			}
+			if p.peekAt(offset).text == "(" && (offset == 1 || isFunctionName(name)) {
+				p.i += offset
+				return p.call(pos, name)
+			}
+		}
+		if t.kind == TOKEN_IDENT && isNiladicFunction(upper) && next.text != "." {
+			p.next()
+			return &Call{Pos: pos, Name: upper}, nil
+		}
+		path, err := p.path()
+		if err != nil {
+			return nil, err
+		}
+		return &Path{Pos: pos, Parts: path}, nil
+	}
+	return nil, p.unexpected("expression")
+}
+
+func typedLiteral(pos position, typeName, text string) (Expr, error) {
+	fieldType := data.NormalizeType(typeName)
+	if fieldType == "JSON" {
+		return &Literal{Pos: pos, Value: text, Type: "JSON"}, nil
+	}
+	if fieldType == "NUMERIC" || fieldType == "BIGNUMERIC" {
+		value, err := strconv.ParseFloat(strings.TrimSpace(text), 64)
+		if err != nil {
+			return nil, &syntaxError{fmt.Sprintf("Invalid %s literal: %q", typeName, text), pos}
+		}
+		return &Literal{Pos: pos, Value: value, Type: fieldType}, nil
+	}
+	value, err := data.ConvertValue(fieldType, text)
+	if err != nil {
+		return nil, &syntaxError{fmt.Sprintf("Invalid %s literal: %q", typeName, text), pos}
+	}
+	if t, ok := value.(time.Time); ok {
+		value = t.UTC()
+	}
+	return &Literal{Pos: pos, Value: value, Type: fieldType}, nil
+}
+
+func (p *parser) call(pos position, name string) (Expr, error) {
+	p.next() // (
+	call := &Call{Pos: pos, Name: name}
+	if p.acceptSymbol("*") {
+		call.Star = true
+	} else if !p.isSymbol(")") {
+		call.Distinct = p.acceptWord("DISTINCT")
+		for {
+			arg, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			// Named arguments like format => 'x' are taken positionally
+			if p.acceptSymbol("=>") {
+				if arg, err = p.expr(); err != nil {
+					return nil, err
+				}
+			}
+			call.Args = append(call.Args, arg)
+			if !p.acceptSymbol(",") {
+				break
+			}
+		}
+		if p.isKeyword("IGNORE") || p.isKeyword("RESPECT") {
+			call.IgnoreNulls = p.next().text == "IGNORE"
+			if err := p.expectWord("NULLS"); err != nil {
+				return nil, err
+			}
+		}
+		if p.isKeyword("ORDER") {
+			var err error
+			if call.OrderBy, err = p.orderBy(); err != nil {
+				return nil, err
+			}
+		}
+		if p.acceptWord("LIMIT") {
+			var err error
+			if call.Limit, err = p.unary(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := p.expectSymbol(")"); err != nil {
+		return nil, err
+	}
+
+	if p.acceptWord("OVER") {
+		if err := p.expectSymbol("("); err != nil {
+			return nil, err
+		}
+		call.Over = &Window{}
+		if p.acceptWord("PARTITION") {
+			if err := p.expectWord("BY"); err != nil {
+				return nil, err
+			}
+			var err error
+			if call.Over.PartitionBy, err = p.expressionList(); err != nil {
+				return nil, err
+			}
+		}
+		if p.isKeyword("ORDER") {
+			var err error
+			if call.Over.OrderBy, err = p.orderBy(); err != nil {
+				return nil, err
+			}
+		}
+		if p.isWord("ROWS") || p.isWord("RANGE") {
+			return nil, &syntaxError{"Window frame clauses are not supported", p.peek().pos}
+		}
+		if err := p.expectSymbol(")"); err != nil {
+			return nil, err
+		}
+	}
+	return call, nil
+}
+
+func (p *parser) cast(pos position, safe bool) (Expr, error) {
+	if err := p.expectSymbol("("); err != nil {
+		return nil, err
+	}
+	x, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expectWord("AS"); err != nil {
+		return nil, err
+	}
+	typeSpec, err := p.typeSpec()
+	if err != nil {
+		return nil, err
+	}
+	if p.acceptWord("FORMAT") {
+		return nil, &syntaxError{"CAST ... FORMAT is not supported", p.peek().pos}
+	}
+	return &Cast{Pos: pos, X: x, Type: typeSpec, Safe: safe}, p.expectSymbol(")")
+}
+
+func (p *parser) typeSpec() (*TypeSpec, error) {
+	switch {
+	case p.acceptWord("ARRAY"):
+		if err := p.expectSymbol("<"); err != nil {
+			return nil, err
+		}
+		elem, err := p.typeSpec()
+		if err != nil {
+			return nil, err
+		}
+		return &TypeSpec{Name: "ARRAY", Elem: elem}, p.closeAngle()
+	case p.acceptWord("STRUCT"):
+		spec := &TypeSpec{Name: "RECORD"}
+		if err := p.expectSymbol("<"); err != nil {
+			return nil, err
+		}
+		for {
+			field := TypeField{}
+			// Field names are optional: STRUCT<INT64, STRING> is allowed
+			if t := p.peek(); (t.kind == TOKEN_IDENT || t.kind == TOKEN_QUOTED_IDENT) &&
+				!(p.peekAt(1).kind == TOKEN_SYMBOL && (p.peekAt(1).text == "," || p.peekAt(1).text == ">" ||
+					p.peekAt(1).text == ">>" || p.peekAt(1).text == "(")) {
+				field.Name = p.next().text
+			}
+			var err error
+			if field.Type, err = p.typeSpec(); err != nil {
+				return nil, err
+			}
+			spec.Fields = append(spec.Fields, field)
+			if !p.acceptSymbol(",") {
+				break
+			}
+		}
+		return spec, p.closeAngle()
+	default:
+		name, err := p.identifier()
+		if err != nil {
+			return nil, err
+		}
+		typeName := strings.ToUpper(name)
+		if !isTypeName(typeName) {
+			return nil, &syntaxError{"Type not found: " + name, p.tokens[p.i-1].pos}
+		}
+		// Parameterized types like STRING(10) or NUMERIC(10, 2)
+		if p.acceptSymbol("(") {
+			for !p.acceptSymbol(")") {
+				if p.next().kind == TOKEN_EOF {
+					return nil, p.unexpected("\")\"")
+				}
+			}
+		}
+		return &TypeSpec{Name: data.NormalizeType(typeName)}, nil
+	}
+}
+
+// closeAngle consumes a ">", splitting a ">>" that closes two types.
+func (p *parser) closeAngle() error {
+	if p.isSymbol(">>") {
+		p.tokens[p.i].text = ">"
+		p.tokens[p.i].pos.Column += 1
+		return nil
+	}
+	return p.expectSymbol(">")
+}
+
+func isTypeName(name string) bool {
+	switch name {
+	case "INT64", "INT", "SMALLINT", "INTEGER", "BIGINT", "TINYINT", "BYTEINT", "FLOAT64", "FLOAT",
+		"NUMERIC", "DECIMAL", "BIGNUMERIC", "BIGDECIMAL", "BOOL", "BOOLEAN", "STRING", "BYTES",
+		"DATE", "TIME", "DATETIME", "TIMESTAMP", "JSON", "GEOGRAPHY", "INTERVAL":
+		return true
+	}
+	return false
+}
+
+func (p *parser) arrayLiteral(elemType *TypeSpec) (Expr, error) {
+	pos := p.peek().pos
+	if err := p.expectSymbol("["); err != nil {
+		return nil, err
+	}
+	array := &ArrayLit{Pos: pos, ElemType: elemType, Elems: []Expr{}}
+	if !p.isSymbol("]") {
+		var err error
+		if array.Elems, err = p.expressionList(); err != nil {
+			return nil, err
+		}
+	}
+	return array, p.expectSymbol("]")
+}
+
+func (p *parser) structLiteral() (Expr, error) {
+	pos := p.next().pos
+	structLit := &StructLit{Pos: pos}
+	if p.isSymbol("<") {
+		p.next()
+		fields := []TypeField{}
+		for {
+			field := TypeField{}
+			if t := p.peek(); (t.kind == TOKEN_IDENT || t.kind == TOKEN_QUOTED_IDENT) &&
+				!(p.peekAt(1).kind == TOKEN_SYMBOL && (p.peekAt(1).text == "," || p.peekAt(1).text == ">")) {
+				field.Name = p.next().text
+			}
+			var err error
+			if field.Type, err = p.typeSpec(); err != nil {
+				return nil, err
+			}
+			fields = append(fields, field)
+			if !p.acceptSymbol(",") {
+				break
+			}
+		}
+		if err := p.closeAngle(); err != nil {
+			return nil, err
+		}
+		structLit.Type = &TypeSpec{Name: "RECORD", Fields: fields}
+	}
+	if err := p.expectSymbol("("); err != nil {
+		return nil, err
+	}
+	for !p.isSymbol(")") {
+		value, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		name := ""
+		if p.acceptWord("AS") {
+			if name, err = p.identifier(); err != nil {
+				return nil, err
+			}
+		}
+		structLit.Names = append(structLit.Names, name)
+		structLit.Values = append(structLit.Values, value)
+		if !p.acceptSymbol(",") {
+			break
+		}
+	}
+	return structLit, p.expectSymbol(")")
+}
+
+func (p *parser) caseExpr() (Expr, error) {
+	caseExpr := &Case{Pos: p.next().pos}
+	var err error
+	if !p.isWord("WHEN") {
+		if caseExpr.Operand, err = p.expr(); err != nil {
+			return nil, err
+		}
+	}
+	for p.acceptWord("WHEN") {
+		when := When{}
+		if when.Cond, err = p.expr(); err != nil {
+			return nil, err
+		}
+		if err := p.expectWord("THEN"); err != nil {
+			return nil, err
+		}
+		if when.Result, err = p.expr(); err != nil {
+			return nil, err
+		}
+		caseExpr.Whens = append(caseExpr.Whens, when)
+	}
+	if len(caseExpr.Whens) == 0 {
+		return nil, p.unexpected("keyword WHEN")
+	}
+	if p.acceptWord("ELSE") {
+		if caseExpr.Else, err = p.expr(); err != nil {
+			return nil, err
+		}
+	}
+	return caseExpr, p.expectWord("END")
+}
@@ -0,0 +1,76 @@
+package queries
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestParseStatement(t *testing.T) {
+	for _, test := range []struct {
+		query string
+		want  string
+	}{
+		{"SELECT 1", "*queries.QueryStatement"},
+		{"SELECT a FROM d.t WHERE a > 1 ORDER BY a LIMIT 2;", "*queries.QueryStatement"},
+		{"WITH x AS (SELECT 1 AS a) SELECT a FROM x", "*queries.QueryStatement"},
+		{"INSERT INTO d.t (a) VALUES (1)", "*queries.InsertStatement"},
+		{"UPDATE d.t SET a = 2 WHERE TRUE", "*queries.UpdateStatement"},
+		{"DELETE FROM d.t WHERE a = 1", "*queries.DeleteStatement"},
+		{"MERGE d.t T USING d.s S ON T.a = S.a WHEN MATCHED THEN DELETE", "*queries.MergeStatement"},
+		{"CREATE TABLE d.t (a INT64, b STRING)", "*queries.CreateTableStatement"},
+		{"CREATE VIEW d.v AS SELECT 1 AS a", "*queries.CreateViewStatement"},
+		{"DROP TABLE IF EXISTS d.t", "*queries.DropTableStatement"},
+		{"ALTER TABLE d.t ADD COLUMN c BOOL", "*queries.AlterTableStatement"},
+		{"CREATE SCHEMA d2", "*queries.CreateSchemaStatement"},
+		{"DROP SCHEMA d2", "*queries.DropSchemaStatement"},
+	} {
+		statement, err := parseStatement(test.query)
+		if err != nil {
+			t.Errorf("parseStatement(%q): %v", test.query, err)
+		} else if got := fmt.Sprintf("%T", statement); got != test.want {
+			t.Errorf("parseStatement(%q) gave a %s, want a %s", test.query, got, test.want)
+		}
+	}
+}
+
+func TestParseStatementErrors(t *testing.T) {
+	for _, test := range []struct {
+		query string
+		want  string
+	}{
+		{"SELECT", "Syntax error: Expected expression but got end of input at [1:7]"},
+		{"SELECT 1 +", "Syntax error: Expected expression but got end of input at [1:11]"},
+		{"SELEC 1", `Syntax error: Expected keyword SELECT but got identifier "SELEC" at [1:1]`},
+		{"SELECT 'unterminated", "Syntax error: Unclosed string literal at [1:8]"},
+		{"SELECT 1\nFROM", "Syntax error: Expected identifier but got end of input at [2:5]"},
+		{"UPDATE d.t SET b = 'z'", "Syntax error: UPDATE must have a WHERE clause at [1:23]"},
+		{"SELECT 1 SELECT 2", `Syntax error: Expected end of input but got keyword SELECT at [1:10]`},
+	} {
+		_, err := parseStatement(test.query)
+		if err == nil {
+			t.Errorf("parseStatement(%q) succeeded, want %q", test.query, test.want)
+		} else if err.Error() != test.want {
+			t.Errorf("parseStatement(%q) failed with %q, want %q", test.query, err.Error(), test.want)
+		}
+	}
+}
+
+func TestParseScript(t *testing.T) {
+	statements, err := parseScript("DECLARE x INT64 DEFAULT 1;\nIF x > 0 THEN SELECT x; END IF;\nBEGIN TRANSACTION;\nSELECT 2")
+	if err != nil {
+		t.Fatalf("parseScript: %v", err)
+	}
+	want := []string{"*queries.DeclareStatement", "*queries.IfStatement", "*queries.TransactionStatement",
+		"*queries.QueryStatement"}
+	if len(statements) != len(want) {
+		t.Fatalf("parseScript gave %d statements, want %d", len(statements), len(want))
+	}
+	for i, statement := range statements {
+		if got := fmt.Sprintf("%T", statement.Statement); got != want[i] {
+			t.Errorf("statement %d is a %s, want a %s", i, got, want[i])
+		}
+	}
+	if !isScript(statements) {
+		t.Errorf("isScript is false for %d statements", len(statements))
+	}
+}
@@ -0,0 +1,144 @@
+package queries
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/danielstutzman/fake-bigquery/data"
+)
+
+// partitionColumns are the _PARTITIONTIME and, for daily partitions,
+// _PARTITIONDATE pseudo-columns of an ingestion-time partitioned table.
+func partitionColumns(table data.Table, alias string) []column {
+	if !table.IngestionTimePartitioned() {
+		return nil
+	}
+	columns := []column{{qualifier: alias, name: "_PARTITIONTIME",
+		field: scalarField("TIMESTAMP"), hidden: true}}
+	if table.TimePartitioning.PartitionType() == "DAY" {
+		columns = append(columns, column{qualifier: alias, name: "_PARTITIONDATE",
+			field: scalarField("DATE"), hidden: true})
+	}
+	return columns
+}
+
+// partitionedTableRows is tableRows followed by the values of the
+// table's partitionColumns.
+func partitionedTableRows(table data.Table, fields []data.Field) [][]interface{} {
+	tuples := tableRows(fields, table.Rows)
+	if !table.IngestionTimePartitioned() {
+		return tuples
+	}
+	daily := table.TimePartitioning.PartitionType() == "DAY"
+	for i, row := range table.Rows {
+		partitionTime, ok := table.PartitionTime(row)
+		if !ok {
+			tuples[i] = append(tuples[i], nil)
+			if daily {
+				tuples[i] = append(tuples[i], nil)
+			}
+			continue
+		}
+		tuples[i] = append(tuples[i], partitionTime)
+		if daily {
+			tuples[i] = append(tuples[i], partitionTime.Format("2006-01-02"))
+		}
+	}
+	return tuples
+}
+
+// checkPartitionFilters fails queries that read a table requiring a
+// partition filter without filtering on its partitioning column in their
+// WHERE clause or join conditions, in a condition ANDed with the rest.
+func (c *compiler) checkPartitionFilters(from FromItem, where Expr) error {
+	conditions := []Expr{where}
+	var check func(item FromItem) error
+	check = func(item FromItem) error {
+		switch item := item.(type) {
+		case *Join:
+			conditions = append(conditions, item.On)
+			if err := check(item.Left); err != nil {
+				return err
+			}
+			return check(item.Right)
+		case *LegacyUnion:
+			for _, unioned := range item.Items {
+				if err := check(unioned); err != nil {
+					return err
+				}
+			}
+		case *Flatten:
+			return check(item.Source)
+		case *TableName:
+			if strings.HasSuffix(item.Path[len(item.Path)-1], "*") {
+				return nil
+			}
+			ref, table, err := c.lookupTable(item.Path, item.Pos)
+			if err != nil || !table.RequiresPartitionFilter() {
+				return nil
+			}
+			names := table.PartitionColumns()
+			for _, condition := range conditions {
+				if filtersOn(condition, names) {
+					return nil
+				}
+			}
+			return &data.Error{Reason: "invalidQuery", Message: fmt.Sprintf(
+				"Cannot query over table '%s.%s' without a filter over column(s) '%s' that can be used for partition elimination",
+				ref.DatasetId, ref.TableId, strings.Join(names, "', '"))}
+		}
+		return nil
+	}
+	return check(from)
+}
+
+// filtersOn reports whether a condition can only hold for rows of some
+// values of the named columns: one of its conjuncts mentions them, and if
+// that's an OR, each side of it does. So "day IS NULL OR TRUE" doesn't.
+func filtersOn(condition Expr, names []string) bool {
+	if binary, ok := condition.(*Binary); ok {
+		switch strings.ToUpper(binary.Op) {
+		case "AND":
+			return filtersOn(binary.L, names) || filtersOn(binary.R, names)
+		case "OR":
+			return filtersOn(binary.L, names) && filtersOn(binary.R, names)
+		}
+	}
+	return condition != nil && mentionsColumn(reflect.ValueOf(condition), names)
+}
+
+// mentionsColumn reports whether an expression refers to any of names,
+// looking into its subqueries too.
+func mentionsColumn(value reflect.Value, names []string) bool {
+	switch value.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		if value.IsNil() {
+			return false
+		}
+		if path, ok := value.Interface().(*Path); ok {
+			for _, part := range path.Parts {
+				for _, name := range names {
+					if strings.EqualFold(part, name) {
+						return true
+					}
+				}
+			}
+			return false
+		}
+		return mentionsColumn(value.Elem(), names)
+	case reflect.Struct:
+		for i := 0; i < value.NumField(); i++ {
+			if mentionsColumn(value.Field(i), names) {
+				return true
+			}
+		}
+	case reflect.Slice:
+		for i := 0; i < value.Len(); i++ {
+			if mentionsColumn(value.Index(i), names) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -1,41 +1,259 @@
 package queries
 
 import (
-	"log"
+	"fmt"
 	"regexp"
-	"strconv"
+	"strings"
+	"time"
 
 	"github.com/danielstutzman/fake-bigquery/data"
 )
 
-var SELECT_COUNT_STAR_REGEXP = regexp.MustCompile(
-	`(?i)^SELECT COUNT\(\*\) FROM ([^.]+).([^\s]+)$`)
-var SELECT_STAR_REGEXP = regexp.MustCompile(
-	`(?i)^SELECT \* FROM ([^.]+).([^\s]+)( LIMIT ([0-9])+)?$`)
+var CREATE_SNAPSHOT_REGEXP = regexp.MustCompile(
+	`(?is)^\s*CREATE\s+SNAPSHOT\s+TABLE\s+(IF\s+NOT\s+EXISTS\s+)?(\S+)\s+CLONE\s+(\S+?)(\s+OPTIONS\s*\((.*)\))?\s*;?\s*$`)
+var CREATE_CLONE_REGEXP = regexp.MustCompile(
+	`(?is)^\s*CREATE\s+(OR\s+REPLACE\s+)?TABLE\s+(IF\s+NOT\s+EXISTS\s+)?(\S+)\s+CLONE\s+(\S+?)(\s+OPTIONS\s*\((.*)\))?\s*;?\s*$`)
 
+// Config holds the settings of a query job other than its query.
+type Config struct {
+	UseLegacySql   bool
+	DefaultDataset *data.TableRef // for table names without a dataset
+	ParameterMode  string         // NAMED or POSITIONAL; inferred from the names if ""
+	Parameters     []data.QueryParameter
+	DryRun         bool                  // validate the query and estimate its cost without running it
+	Jobs           func() []data.JobInfo // for INFORMATION_SCHEMA.JOBS_BY_PROJECT
+	Session        *Session              // nil unless the query is part of a session
+
+	// CreateTable, if set, charges each table a statement adds to a quota
+	CreateTable func(data.TableRef) *data.Error
+}
+
+// ExecuteQuery runs a query of one statement, or a script of several. A
+// script that fails still returns a result, holding the child jobs it ran.
+// A query in a session that fails rolls back the session's transaction.
 func ExecuteQuery(query string, projects map[string]data.Project,
-	projectName string) *data.Result {
-
-	if match := SELECT_COUNT_STAR_REGEXP.FindStringSubmatch(query); match != nil {
-		datasetName := match[1]
-		tableName := match[2]
-		return executeSelectCountStar(projectName, datasetName, tableName, projects)
-
-	} else if match := SELECT_STAR_REGEXP.FindStringSubmatch(query); match != nil {
-		datasetName := match[1]
-		tableName := match[2]
-		limit := -1
-		if match[3] != "" {
-			var err error
-			limit, err = strconv.Atoi(match[4])
-			if err != nil {
-				log.Fatalf("Error from Atoi: %s", err)
-			}
+	projectName string, config Config) (*data.Result, *data.Error) {
+
+	var statement Statement
+	var err error
+	if config.UseLegacySql {
+		if len(config.Parameters) > 0 {
+			return nil, &data.Error{Reason: "invalid", Message: "Query parameters are only supported in standard SQL"}
+		}
+		if statement, err = parseLegacyStatement(query); err != nil {
+			return nil, toDataError(err)
 		}
-		return executeSelectStar(projectName, datasetName, tableName, limit, projects)
+	} else if match := CREATE_SNAPSHOT_REGEXP.FindStringSubmatch(query); match != nil {
+		return executeCreateClone(projectName, config, match[2], match[3], match[5],
+			"SNAPSHOT", false, match[1] != "", projects)
+
+	} else if match := CREATE_CLONE_REGEXP.FindStringSubmatch(query); match != nil {
+		return executeCreateClone(projectName, config, match[3], match[4], match[6],
+			"CLONE", match[1] != "", match[2] != "", projects)
 
 	} else {
-		log.Fatalf("Can't parse query: %s", query)
-		return nil
+		statements, err := parseScript(query)
+		if err != nil {
+			return nil, toDataError(err)
+		}
+		if isScript(statements) {
+			return executeScript(statements, projects, projectName, config)
+		}
+		statement = statements[0].Statement
+	}
+
+	params, dataErr := newParameters(config)
+	if dataErr != nil {
+		return nil, dataErr
+	}
+	e := newExecutor(projects, projectName, config, params)
+	if config.Session != nil {
+		e = newScript(projects, projectName, config, params, config.Session).executor(params)
+	}
+	result, err := e.executeStatement(statement)
+	if err != nil {
+		if config.Session != nil && !config.DryRun {
+			config.Session.transaction = nil
+		}
+		return nil, toDataError(err)
+	}
+	return result, nil
+}
+
+func newExecutor(projects map[string]data.Project, projectName string, config Config,
+	params *parameters) *executor {
+
+	return &executor{projects: projects, projectName: projectName, now: time.Now().UTC(), params: params,
+		legacy: config.UseLegacySql, defaultDataset: config.DefaultDataset, dryRun: config.DryRun,
+		scans: &[]*tableScan{}, referenced: &[]data.TableRef{}, jobs: config.Jobs,
+		createTable: config.CreateTable}
+}
+
+// executeStatement runs a statement other than a scripting statement,
+// adding to its result the bytes it read and the tables it referenced.
+func (e *executor) executeStatement(statement Statement) (*data.Result, error) {
+	if e.script != nil && e.script.session.transaction != nil {
+		if err := e.checkTransactional(statement); err != nil {
+			return nil, err
+		}
+	}
+	var result *data.Result
+	var err error
+	switch statement := statement.(type) {
+	case *QueryStatement:
+		result, err = e.executeQuery(statement.Query)
+	case *InsertStatement:
+		result, err = e.executeInsert(statement)
+	case *UpdateStatement:
+		result, err = e.executeUpdate(statement)
+	case *DeleteStatement:
+		result, err = e.executeDelete(statement)
+	case *MergeStatement:
+		result, err = e.executeMerge(statement)
+	case *CreateTableStatement:
+		result, err = e.executeCreateTable(statement)
+	case *DropTableStatement:
+		result, err = e.executeDropTable(statement)
+	case *AlterTableStatement:
+		result, err = e.executeAlterTable(statement)
+	case *CreateSchemaStatement:
+		result, err = e.executeCreateSchema(statement)
+	case *DropSchemaStatement:
+		result, err = e.executeDropSchema(statement)
+	case *CreateViewStatement:
+		result, err = e.executeCreateView(statement)
+	case *CallStatement:
+		result, err = e.executeCall(statement)
+	case *TransactionStatement:
+		result, err = e.executeTransaction(statement)
+	default:
+		err = runtimeError("Unsupported statement type %T", statement)
+	}
+	if err != nil {
+		return nil, err
+	}
+	result.TotalBytesProcessed, result.TotalBytesBilled = e.bytesBilled()
+	e.referenceWildcardTables()
+	result.ReferencedTables = *e.referenced
+	return result, nil
+}
+
+// dryRunResult is what a dry run of a statement that isn't a SELECT
+// gives, having changed nothing.
+func dryRunResult(statementType string) *data.Result {
+	return &data.Result{StatementType: statementType, Fields: []data.Field{}, Rows: []map[string]interface{}{}}
+}
+
+// referenceTable records a table or view the statement names, for its
+// statistics' referencedTables.
+func (e *executor) referenceTable(ref data.TableRef) {
+	if e.referenced == nil {
+		return
+	}
+	for _, other := range *e.referenced {
+		if other == ref {
+			return
+		}
+	}
+	*e.referenced = append(*e.referenced, ref)
+}
+
+func toDataError(err error) *data.Error {
+	if dataErr, ok := err.(*data.Error); ok {
+		return dataErr
+	}
+	return &data.Error{Reason: "invalidQuery", Message: err.Error()}
+}
+
+// executeQuery runs a SELECT.
+func (e *executor) executeQuery(query *Query) (*data.Result, error) {
+	c := &compiler{executor: e, ctes: map[string]*cte{}}
+	compiled, err := c.compileQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	fields, err := nameColumns(compiled.fields)
+	if err != nil {
+		return nil, err
+	}
+	if e.dryRun {
+		return &data.Result{StatementType: "SELECT", Fields: fields, Rows: []map[string]interface{}{}}, nil
+	}
+
+	rows, err := compiled.run(&evalContext{})
+	if err != nil {
+		return nil, err
+	}
+	result := &data.Result{StatementType: "SELECT", Fields: fields, Rows: []map[string]interface{}{}}
+	for _, row := range rows {
+		stored, err := storeRow(fields, row)
+		if err != nil {
+			return nil, err
+		}
+		result.Rows = append(result.Rows, stored)
+	}
+	return result, nil
+}
+
+// nameColumns names the anonymous columns of a query's results f0_,
+// f1_, ..., which must then all have different names.
+func nameColumns(columns []data.Field) ([]data.Field, error) {
+	fields := make([]data.Field, len(columns))
+	seen := map[string]bool{}
+	duplicates := []string{}
+	anonymous := 0
+	for i, field := range columns {
+		if field.Name == "" {
+			field.Name = fmt.Sprintf("f%d_", anonymous)
+			anonymous += 1
+		}
+		if seen[strings.ToLower(field.Name)] {
+			duplicates = append(duplicates, field.Name)
+		}
+		seen[strings.ToLower(field.Name)] = true
+		fields[i] = field
+	}
+	if len(duplicates) > 0 {
+		return nil, runtimeError("Duplicate column names in the result are not supported. Found duplicate(s): %s",
+			strings.Join(duplicates, ", "))
+	}
+	return fields, nil
+}
+
+// storeRow builds a stored row from values in the order of fields,
+// leaving NULLs out like the other ways of adding rows do.
+func storeRow(fields []data.Field, values []interface{}) (map[string]interface{}, error) {
+	row := map[string]interface{}{}
+	for i, field := range fields {
+		if values[i] == nil {
+			if field.Mode == "REQUIRED" {
+				return nil, runtimeError("Cannot set required field %s to NULL", field.Name)
+			}
+			continue
+		}
+		if elements, ok := values[i].([]interface{}); ok {
+			for _, element := range elements {
+				if element == nil {
+					return nil, runtimeError("Cannot write NULL element to array field %s", field.Name)
+				}
+			}
+		}
+		row[field.Name] = data.CopyValue(values[i])
+	}
+	return row, nil
+}
+
+// parseTableName splits a table, dataset.table or project.dataset.table
+// path, optionally quoted with backticks.
+func parseTableName(name, projectName string, defaultDataset *data.TableRef) (data.TableRef, *data.Error) {
+	parts := strings.Split(strings.Replace(name, "`", "", -1), ".")
+	ref, ok := resolveTableRef(parts, projectName, defaultDataset)
+	if !ok {
+		return data.TableRef{}, &data.Error{
+			Reason:  "invalidQuery",
+			Message: "Table name \"" + name + "\" missing dataset while no default dataset is set in the request.",
+		}
 	}
+	return ref, nil
 }
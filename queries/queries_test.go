@@ -0,0 +1,560 @@
+package queries
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danielstutzman/fake-bigquery/data"
+)
+
+// testProjects gives a project p holding d.t, with columns a and b, anew
+// for each test so that DML doesn't leak between them.
+func testProjects() map[string]data.Project {
+	table := data.Table{
+		Fields: []data.Field{
+			{Name: "a", Type: "INTEGER", Mode: "NULLABLE"},
+			{Name: "b", Type: "STRING", Mode: "NULLABLE"},
+		},
+		Rows: []map[string]interface{}{
+			{"a": int64(1), "b": "one"},
+			{"a": int64(2), "b": "two"},
+			{"a": int64(3), "b": "three"},
+		},
+	}
+	return map[string]data.Project{"p": {Datasets: map[string]data.Dataset{
+		"d": {Tables: map[string]data.Table{"t": table}},
+	}}}
+}
+
+func TestExpressions(t *testing.T) {
+	for _, test := range []struct {
+		expression string
+		want       interface{}
+	}{
+		{"1 + 2 * 3", int64(7)},
+		{"7 / 2", 3.5},
+		{"DIV(7, 2)", int64(3)},
+		{"CAST('12' AS INT64) + 1", int64(13)},
+		{"CONCAT('a', 'b')", "ab"},
+		{"'a' || 'b'", "ab"},
+		{"UPPER('abc')", "ABC"},
+		{"LENGTH('héllo')", int64(5)},
+		{"ARRAY_LENGTH([1, 2, 3])", int64(3)},
+		{"NULL IS NULL", true},
+		{"2 IN (1, 2)", true},
+		{"IF(TRUE AND NULL, 1, 2)", int64(2)},
+		{"COALESCE(NULL, 3)", int64(3)},
+		{"CASE WHEN 1 > 2 THEN 'x' ELSE 'y' END", "y"},
+		{"SAFE_DIVIDE(1, 0)", nil},
+		{"TIMESTAMP '2024-01-02 03:04:05'", time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)},
+	} {
+		result, err := ExecuteQuery("SELECT "+test.expression+" AS v", testProjects(), "p", Config{})
+		if err != nil {
+			t.Errorf("%s: %s", test.expression, err.Message)
+		} else if len(result.Rows) != 1 {
+			t.Errorf("%s gave %d rows, want 1", test.expression, len(result.Rows))
+		} else if got := result.Rows[0]["v"]; !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s = %#v, want %#v", test.expression, got, test.want)
+		}
+	}
+}
+
+func TestDmlStats(t *testing.T) {
+	for _, test := range []struct {
+		query   string
+		want    data.DmlStats
+		numRows int // in d.t afterwards
+	}{
+		{"INSERT INTO d.t (a, b) VALUES (4, 'x'), (5, 'y')", data.DmlStats{InsertedRowCount: 2}, 5},
+		{"INSERT INTO d.t SELECT a + 10, b FROM d.t", data.DmlStats{InsertedRowCount: 3}, 6},
+		{"UPDATE d.t SET b = 'z' WHERE a > 1", data.DmlStats{UpdatedRowCount: 2}, 3},
+		{"UPDATE d.t SET b = 'z' WHERE a > 5", data.DmlStats{}, 3},
+		{"DELETE FROM d.t WHERE a = 1", data.DmlStats{DeletedRowCount: 1}, 2},
+		{"DELETE FROM d.t WHERE TRUE", data.DmlStats{DeletedRowCount: 3}, 0},
+		{"MERGE d.t T USING (SELECT 1 AS a UNION ALL SELECT 9) S ON T.a = S.a " +
+			"WHEN MATCHED THEN UPDATE SET b = 'm' WHEN NOT MATCHED THEN INSERT (a, b) VALUES (S.a, 'n')",
+			data.DmlStats{InsertedRowCount: 1, UpdatedRowCount: 1}, 4},
+		{"MERGE d.t T USING (SELECT 2 AS a) S ON T.a = S.a WHEN MATCHED THEN DELETE",
+			data.DmlStats{DeletedRowCount: 1}, 2},
+	} {
+		projects := testProjects()
+		result, err := ExecuteQuery(test.query, projects, "p", Config{})
+		if err != nil {
+			t.Errorf("%s: %s", test.query, err.Message)
+			continue
+		}
+		if result.DmlStats == nil || *result.DmlStats != test.want {
+			t.Errorf("%s gave DmlStats %+v, want %+v", test.query, result.DmlStats, test.want)
+		}
+		if got := len(projects["p"].Datasets["d"].Tables["t"].Rows); got != test.numRows {
+			t.Errorf("%s left %d rows, want %d", test.query, got, test.numRows)
+		}
+	}
+}
+
+func TestQueryErrors(t *testing.T) {
+	for _, test := range []struct {
+		query   string
+		reason  string
+		message string
+	}{
+		{"SELECT * FROM d.missing", "notFound", "Not found: Table p:d.missing was not found in location US"},
+		{"SELECT nope FROM d.t", "invalidQuery", "Unrecognized name: nope at [1:8]"},
+		{"SELECT FOO(1)", "invalidQuery", "Function not found: foo at [1:8]"},
+		{"SELECT 1 / 0", "invalidQuery", "division by zero: 1 / 0"},
+		{"SELECT CAST('x' AS INT64)", "invalidQuery", "Bad int64 value: x"},
+		{"SELECT 9223372036854775807 + 1", "invalidQuery", "int64 overflow: 9223372036854775807 + 1"},
+		{"SELECT a FROM d.t GROUP BY b", "invalidQuery",
+			"SELECT list expression references column a which is neither grouped nor aggregated at [1:8]"},
+		{"SELECT a FROM d.t GROUP BY 3", "invalidQuery", "GROUP BY is out of SELECT column number range: 3 at [1:28]"},
+		{"INSERT INTO d.t (a) VALUES ('x')", "invalidQuery",
+			"Value has type STRING which cannot be inserted into column a, which has type INT64 at [1:29]"},
+		{"INSERT INTO d.t (nope) VALUES (1)", "invalidQuery", "Column nope is not present in table p:d.t at [1:1]"},
+		{"SELECT 1 +", "invalidQuery", "Syntax error: Expected expression but got end of input at [1:11]"},
+	} {
+		_, err := ExecuteQuery(test.query, testProjects(), "p", Config{})
+		if err == nil {
+			t.Errorf("%s succeeded, want %q", test.query, test.message)
+		} else if err.Reason != test.reason || err.Message != test.message {
+			t.Errorf("%s failed with %s %q, want %s %q", test.query, err.Reason, err.Message, test.reason, test.message)
+		}
+	}
+}
+
+func TestWildcardTables(t *testing.T) {
+	projects := testProjects()
+	dataset := projects["p"].Datasets["d"]
+	dataset.Tables["events_1"] = data.Table{
+		Fields:       []data.Field{{Name: "a", Type: "INTEGER", Mode: "NULLABLE"}},
+		Rows:         []map[string]interface{}{{"a": int64(1)}},
+		CreationTime: 1,
+	}
+	dataset.Tables["events_2"] = data.Table{
+		Fields: []data.Field{
+			{Name: "A", Type: "INTEGER", Mode: "NULLABLE"},
+			{Name: "c", Type: "STRING", Mode: "NULLABLE"},
+		},
+		Rows:         []map[string]interface{}{{"A": int64(2), "c": "x"}},
+		CreationTime: 2,
+	}
+
+	result, err := ExecuteQuery("SELECT A, c, _TABLE_SUFFIX AS s FROM d.`events_*` ORDER BY s", projects, "p", Config{})
+	if err != nil {
+		t.Fatalf("ExecuteQuery: %s", err.Message)
+	}
+	want := []map[string]interface{}{{"A": int64(1), "s": "1"}, {"A": int64(2), "c": "x", "s": "2"}}
+	if !reflect.DeepEqual(result.Rows, want) {
+		t.Errorf("rows are %v, want %v", result.Rows, want)
+	}
+	if len(result.ReferencedTables) != 2 {
+		t.Errorf("referenced %v, want both tables", result.ReferencedTables)
+	}
+
+	result, err = ExecuteQuery("SELECT a FROM d.`events_*` WHERE _TABLE_SUFFIX = '2'", projects, "p", Config{})
+	if err != nil {
+		t.Fatalf("ExecuteQuery: %s", err.Message)
+	}
+	wantTables := []data.TableRef{{ProjectId: "p", DatasetId: "d", TableId: "events_2"}}
+	if !reflect.DeepEqual(result.ReferencedTables, wantTables) {
+		t.Errorf("referenced %v, want %v", result.ReferencedTables, wantTables)
+	}
+}
+
+func TestScriptErrors(t *testing.T) {
+	for _, test := range []struct {
+		query   string
+		message string
+	}{
+		{"SELECT 1;\nBREAK;", "Syntax error: BREAK is only allowed inside a loop at [2:1]"},
+		{"IF TRUE THEN CONTINUE; END IF;", "Syntax error: CONTINUE is only allowed inside a loop at [1:14]"},
+	} {
+		_, err := ExecuteQuery(test.query, testProjects(), "p", Config{})
+		if err == nil || err.Reason != "invalidQuery" || err.Message != test.message {
+			t.Errorf("%s failed with %v, want invalidQuery %q", test.query, err, test.message)
+		}
+	}
+
+	result, err := ExecuteQuery("ALTER TABLE d.t ADD COLUMN c BOOL", testProjects(), "p", Config{})
+	if err != nil {
+		t.Fatalf("ALTER TABLE: %s", err.Message)
+	} else if result.DdlOperationPerformed != "ALTER" {
+		t.Errorf("ALTER TABLE performed %q, want ALTER", result.DdlOperationPerformed)
+	}
+}
+
+func TestRequirePartitionFilter(t *testing.T) {
+	projects := testProjects()
+	projects["p"].Datasets["d"].Tables["events"] = data.Table{
+		Fields: []data.Field{
+			{Name: "day", Type: "DATE", Mode: "NULLABLE"},
+			{Name: "n", Type: "INTEGER", Mode: "NULLABLE"},
+		},
+		Rows:                   []map[string]interface{}{{"day": "2024-01-02", "n": int64(1)}},
+		TimePartitioning:       &data.TimePartitioning{Type: "DAY", Field: "day"},
+		RequirePartitionFilter: true,
+	}
+	for _, test := range []struct {
+		where string
+		ok    bool
+	}{
+		{"", false},
+		{"WHERE n = 1", false},
+		{"WHERE day IS NULL OR TRUE", false},
+		{"WHERE n = 1 OR day = '2024-01-02'", false},
+		{"WHERE day = '2024-01-02'", true},
+		{"WHERE n = 1 AND day = '2024-01-02'", true},
+		{"WHERE (day < '2024-01-01' OR day > '2024-01-01') AND n = 1", true},
+	} {
+		_, err := ExecuteQuery("SELECT n FROM d.events "+test.where, projects, "p", Config{})
+		if test.ok && err != nil {
+			t.Errorf("%q failed: %s", test.where, err.Message)
+		} else if !test.ok && err == nil {
+			t.Errorf("%q succeeded, want it to need a partition filter", test.where)
+		}
+	}
+}
+
+func TestTransactions(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		steps   []string // queries run in one session; "!" before one runs it outside
+		message string   // the error the last step fails with, if any
+		numRows int      // in d.t afterwards
+	}{
+		{"commit", []string{"BEGIN TRANSACTION; DELETE FROM d.t WHERE a = 1; COMMIT TRANSACTION"}, "", 2},
+		{"rollback", []string{"BEGIN TRANSACTION; DELETE FROM d.t WHERE TRUE; ROLLBACK TRANSACTION"}, "", 3},
+		{"failure", []string{"BEGIN TRANSACTION; DELETE FROM d.t WHERE a = 1; SELECT 1 / 0; COMMIT TRANSACTION"},
+			"division by zero: 1 / 0", 3},
+		{"across queries", []string{"BEGIN TRANSACTION", "DELETE FROM d.t WHERE a = 1", "COMMIT TRANSACTION"}, "", 2},
+		{"concurrent update", []string{"BEGIN TRANSACTION", "DELETE FROM d.t WHERE a = 1",
+			"!DELETE FROM d.t WHERE a = 3", "COMMIT TRANSACTION"},
+			"Transaction is aborted due to concurrent update against table p:d.t at [1:1]", 2},
+		{"abort session", []string{"BEGIN TRANSACTION", "DELETE FROM d.t WHERE TRUE", "CALL BQ.ABORT_SESSION()"}, "", 3},
+	} {
+		projects := testProjects()
+		session := NewSession("p")
+		var err *data.Error
+		for _, step := range test.steps {
+			config := Config{Session: session}
+			if strings.HasPrefix(step, "!") {
+				step, config = step[1:], Config{}
+			}
+			if _, err = ExecuteQuery(step, projects, "p", config); err != nil && test.message == "" {
+				t.Errorf("%s: %s failed: %s", test.name, step, err.Message)
+			}
+		}
+		if test.message != "" && (err == nil || err.Message != test.message) {
+			t.Errorf("%s: last step failed with %v, want %q", test.name, err, test.message)
+		}
+		if got := len(projects["p"].Datasets["d"].Tables["t"].Rows); got != test.numRows {
+			t.Errorf("%s: left %d rows, want %d", test.name, got, test.numRows)
+		}
+	}
+}
+
+func TestSessions(t *testing.T) {
+	projects := testProjects()
+	session := NewSession("p")
+	for _, query := range []string{
+		"DECLARE x INT64 DEFAULT 5",
+		"CREATE TEMP TABLE tmp AS SELECT x + a AS v FROM d.t",
+		"SET x = 10",
+	} {
+		if _, err := ExecuteQuery(query, projects, "p", Config{Session: session}); err != nil {
+			t.Fatalf("%s: %s", query, err.Message)
+		}
+	}
+	result, err := ExecuteQuery("SELECT SUM(v) + x AS total FROM tmp", projects, "p", Config{Session: session})
+	if err != nil {
+		t.Fatalf("SELECT: %s", err.Message)
+	} else if got := result.Rows[0]["total"]; got != int64(31) {
+		t.Errorf("total is %v, want 31", got)
+	}
+	if _, err := ExecuteQuery("SELECT * FROM tmp", projects, "p", Config{}); err == nil {
+		t.Errorf("a temp table is visible outside its session")
+	}
+
+	if _, err := ExecuteQuery("CALL BQ.ABORT_SESSION()", projects, "p", Config{Session: session}); err != nil {
+		t.Fatalf("ABORT_SESSION: %s", err.Message)
+	} else if !session.Terminated {
+		t.Errorf("ABORT_SESSION left the session running")
+	}
+	if _, err := ExecuteQuery("SELECT * FROM tmp", projects, "p", Config{Session: session}); err == nil {
+		t.Errorf("ABORT_SESSION kept the temp table")
+	}
+}
+
+func TestMaterializedViews(t *testing.T) {
+	projects := testProjects()
+	for _, test := range []struct {
+		query string
+		sum   int64 // that d.mv holds afterwards
+	}{
+		{"CREATE MATERIALIZED VIEW d.mv OPTIONS (enable_refresh = false) AS SELECT SUM(a) AS s FROM d.t", 6},
+		{"INSERT INTO d.t (a) VALUES (4)", 6},
+		{"CALL BQ.REFRESH_MATERIALIZED_VIEW('d.mv')", 10},
+	} {
+		if _, err := ExecuteQuery(test.query, projects, "p", Config{}); err != nil {
+			t.Fatalf("%s: %s", test.query, err.Message)
+		}
+		result, err := ExecuteQuery("SELECT s FROM d.mv", projects, "p", Config{})
+		if err != nil {
+			t.Fatalf("after %s: %s", test.query, err.Message)
+		} else if len(result.Rows) != 1 || result.Rows[0]["s"] != test.sum {
+			t.Errorf("after %s, d.mv holds %v, want a sum of %d", test.query, result.Rows, test.sum)
+		}
+	}
+}
+
+func TestParameters(t *testing.T) {
+	for _, test := range []struct {
+		mode   string
+		params string // JSON, as the client sends queryParameters
+		query  string
+		want   interface{} // the value of v, or the error message
+	}{
+		{"NAMED", `[{"name": "x", "parameterType": {"type": "INT64"}, "parameterValue": {"value": "2"}}]`,
+			"SELECT b AS v FROM d.t WHERE a = @x", "two"},
+		{"NAMED", `[{"name": "X", "parameterType": {"type": "STRING"}, "parameterValue": {}}]`,
+			"SELECT @x IS NULL AS v", true},
+		{"POSITIONAL", `[{"parameterType": {"type": "INT64"}, "parameterValue": {"value": "1"}},
+			{"parameterType": {"type": "INT64"}, "parameterValue": {"value": "3"}}]`,
+			"SELECT COUNT(*) AS v FROM d.t WHERE a BETWEEN ? AND ?", int64(3)},
+		{"", `[{"name": "xs", "parameterType": {"type": "ARRAY", "arrayType": {"type": "INT64"}},
+			"parameterValue": {"arrayValues": [{"value": "1"}, {"value": "3"}]}}]`,
+			"SELECT COUNT(*) AS v FROM d.t WHERE a IN UNNEST(@xs)", int64(2)},
+		{"NAMED", `[{"name": "s", "parameterType": {"type": "STRUCT",
+			"structTypes": [{"name": "y", "type": {"type": "STRING"}}]},
+			"parameterValue": {"structValues": {"y": {"value": "hi"}}}}]`,
+			"SELECT @s.y AS v", "hi"},
+		{"NAMED", `[]`, "SELECT @x AS v", "Query parameter 'x' not found at [1:8]"},
+		{"NAMED", `[{"name": "x", "parameterType": {"type": "INT64"}, "parameterValue": {"value": "1"}}]`,
+			"SELECT ? AS v", "Positional parameters are not supported at [1:8]"},
+		{"NAMED", `[{"name": "x", "parameterType": {"type": "INT64"}, "parameterValue": {"value": "one"}}]`,
+			"SELECT @x AS v", "Invalid value for query parameter x: Cannot convert value to integer (bad value):one"},
+	} {
+		config := Config{ParameterMode: test.mode}
+		if err := json.Unmarshal([]byte(test.params), &config.Parameters); err != nil {
+			t.Fatalf("%s: %s", test.params, err)
+		}
+		result, err := ExecuteQuery(test.query, testProjects(), "p", config)
+		if message, ok := test.want.(string); ok && err != nil {
+			if err.Message != message {
+				t.Errorf("%s failed with %q, want %q", test.query, err.Message, message)
+			}
+		} else if err != nil {
+			t.Errorf("%s: %s", test.query, err.Message)
+		} else if len(result.Rows) != 1 || !reflect.DeepEqual(result.Rows[0]["v"], test.want) {
+			t.Errorf("%s gave %v, want v = %#v", test.query, result.Rows, test.want)
+		}
+	}
+}
+
+func TestLegacySql(t *testing.T) {
+	projects := testProjects()
+	dataset := projects["p"].Datasets["d"]
+	for _, tableId := range []string{"events_20240101", "events_20240102", "events_20240201"} {
+		dataset.Tables[tableId] = data.Table{
+			Fields: []data.Field{{Name: "a", Type: "INTEGER", Mode: "NULLABLE"}},
+			Rows:   []map[string]interface{}{{"a": int64(1)}},
+		}
+	}
+	dataset.Tables["r"] = data.Table{
+		Fields: []data.Field{{Name: "xs", Type: "INTEGER", Mode: "REPEATED"}},
+		Rows:   []map[string]interface{}{{"xs": []interface{}{int64(1), int64(2), int64(3)}}},
+	}
+	for _, test := range []struct {
+		query string
+		want  interface{} // the value of v, or the error message
+	}{
+		{"SELECT SUM(a) AS v FROM [p:d.t]", int64(6)},
+		{"SELECT COUNT(*) AS v FROM [d.t], [p:d.t]", int64(6)},
+		{"SELECT COUNT(*) AS v FROM TABLE_DATE_RANGE([d.events_], TIMESTAMP('2024-01-01'), TIMESTAMP('2024-01-31'))",
+			int64(2)},
+		{"SELECT COUNT(*) AS v FROM FLATTEN([d.r], xs)", int64(3)},
+		{"SELECT COUNT(*) AS v FROM (SELECT b FROM [d.t] GROUP EACH BY b)", int64(3)},
+		{"SELECT a AS v FROM `d.t`", "Lexical error at line 1, column 20.  Encountered: \"`\" (96), after : \"\""},
+		{"DELETE FROM [d.t] WHERE TRUE", `Encountered " "DELETE" "DELETE "" at line 1, column 1.`},
+	} {
+		result, err := ExecuteQuery(test.query, projects, "p", Config{UseLegacySql: true})
+		if message, ok := test.want.(string); ok && err != nil {
+			if err.Message != message {
+				t.Errorf("%s failed with %q, want %q", test.query, err.Message, message)
+			}
+		} else if err != nil {
+			t.Errorf("%s: %s", test.query, err.Message)
+		} else if len(result.Rows) != 1 || !reflect.DeepEqual(result.Rows[0]["v"], test.want) {
+			t.Errorf("%s gave %v, want v = %#v", test.query, result.Rows, test.want)
+		}
+	}
+}
+
+func TestTableNames(t *testing.T) {
+	projects := testProjects()
+	projects["my-proj"] = data.Project{Datasets: map[string]data.Dataset{"ds": {Tables: map[string]data.Table{
+		"t": {
+			Fields: []data.Field{{Name: "a", Type: "INTEGER", Mode: "NULLABLE"}},
+			Rows:   []map[string]interface{}{{"a": int64(10)}},
+		},
+	}}}}
+	for _, test := range []struct {
+		defaultDataset *data.TableRef
+		from           string
+		want           interface{} // the sum of a, or the error message
+	}{
+		{nil, "d.t", int64(6)},
+		{nil, "p.d.t", int64(6)},
+		{nil, "`my-proj.ds.t`", int64(10)},
+		{nil, "`my-proj`.ds.t", int64(10)},
+		{&data.TableRef{ProjectId: "p", DatasetId: "d"}, "t", int64(6)},
+		{&data.TableRef{ProjectId: "my-proj", DatasetId: "ds"}, "t", int64(10)},
+		{&data.TableRef{ProjectId: "my-proj", DatasetId: "ds"}, "ds.t", int64(10)},
+		{&data.TableRef{ProjectId: "my-proj", DatasetId: "ds"}, "p.d.t", int64(6)},
+		{nil, "t", `Table name "t" missing dataset while no default dataset is set in the request.`},
+	} {
+		query := "SELECT SUM(a) AS v FROM " + test.from
+		result, err := ExecuteQuery(query, projects, "p", Config{DefaultDataset: test.defaultDataset})
+		if message, ok := test.want.(string); ok && err != nil {
+			if err.Message != message {
+				t.Errorf("%s failed with %q, want %q", query, err.Message, message)
+			}
+		} else if err != nil {
+			t.Errorf("%s with default dataset %v: %s", query, test.defaultDataset, err.Message)
+		} else if len(result.Rows) != 1 || !reflect.DeepEqual(result.Rows[0]["v"], test.want) {
+			t.Errorf("%s with default dataset %v gave %v, want %v", query, test.defaultDataset, result.Rows, test.want)
+		}
+	}
+}
+
+func TestBytesProcessed(t *testing.T) {
+	projects := testProjects()
+	dataset := projects["p"].Datasets["d"]
+	dataset.Tables["events"] = data.Table{
+		Fields: []data.Field{
+			{Name: "day", Type: "DATE", Mode: "NULLABLE"},
+			{Name: "n", Type: "INTEGER", Mode: "NULLABLE"},
+			{Name: "kind", Type: "STRING", Mode: "NULLABLE"},
+		},
+		Rows: []map[string]interface{}{
+			{"day": "2024-01-01", "n": int64(1), "kind": "x"},
+			{"day": "2024-01-01", "n": int64(2), "kind": "y"},
+			{"day": "2024-01-02", "n": int64(3), "kind": "x"},
+		},
+		TimePartitioning: &data.TimePartitioning{Type: "DAY", Field: "day"},
+		Clustering:       &data.Clustering{Fields: []string{"kind"}},
+	}
+	for _, test := range []struct {
+		query     string
+		processed int64 // an INTEGER or DATE is 8 bytes, and a STRING 2 more than its length
+		billed    int64
+	}{
+		{"SELECT 1", 0, 0},
+		{"SELECT a FROM d.t", 24, MIN_BYTES_BILLED_PER_TABLE},
+		{"SELECT b FROM d.t", 17, MIN_BYTES_BILLED_PER_TABLE},
+		{"SELECT * FROM d.t", 41, MIN_BYTES_BILLED_PER_TABLE},
+		{"SELECT a FROM d.t WHERE b = 'one'", 41, MIN_BYTES_BILLED_PER_TABLE},
+		{"SELECT x.a FROM d.t x JOIN d.t y USING (a)", 48, 2 * MIN_BYTES_BILLED_PER_TABLE},
+		{"SELECT n FROM d.events", 24, MIN_BYTES_BILLED_PER_TABLE},
+		{"SELECT n FROM d.events WHERE day = '2024-01-02'", 16, MIN_BYTES_BILLED_PER_TABLE},
+		{"SELECT n FROM d.events WHERE kind = 'x'", 22, MIN_BYTES_BILLED_PER_TABLE},
+	} {
+		result, err := ExecuteQuery(test.query, projects, "p", Config{})
+		if err != nil {
+			t.Errorf("%s: %s", test.query, err.Message)
+		} else if result.TotalBytesProcessed != test.processed || result.TotalBytesBilled != test.billed {
+			t.Errorf("%s processed %d and billed %d bytes, want %d and %d", test.query,
+				result.TotalBytesProcessed, result.TotalBytesBilled, test.processed, test.billed)
+		}
+	}
+}
+
+func TestInformationSchema(t *testing.T) {
+	projects := testProjects()
+	dataset := projects["p"].Datasets["d"]
+	dataset.Tables["r"] = data.Table{Fields: []data.Field{{Name: "rec", Type: "RECORD", Mode: "NULLABLE",
+		Fields: []data.Field{{Name: "x", Type: "STRING", Mode: "NULLABLE"}}}}}
+	dataset.Tables["v"] = data.Table{Fields: []data.Field{{Name: "a", Type: "INTEGER", Mode: "NULLABLE"}},
+		View: &data.View{Query: "SELECT a FROM d.t"}}
+	projects["p"].Datasets["e"] = data.Dataset{Location: "EU", Tables: map[string]data.Table{}}
+	config := Config{Jobs: func() []data.JobInfo {
+		return []data.JobInfo{
+			{ProjectId: "p", JobId: "j1", Location: "US", JobType: "QUERY", State: "DONE"},
+			{ProjectId: "p", JobId: "j2", Location: "EU", JobType: "LOAD", State: "DONE"},
+		}
+	}}
+	for _, test := range []struct {
+		query string
+		want  interface{} // the value of v, or the error message
+	}{
+		{"SELECT STRING_AGG(table_name || ' ' || table_type, ', ' ORDER BY table_name) AS v " +
+			"FROM d.INFORMATION_SCHEMA.TABLES", "r BASE TABLE, t BASE TABLE, v VIEW"},
+		{"SELECT STRING_AGG(column_name || ' ' || data_type, ', ' ORDER BY ordinal_position) AS v " +
+			"FROM p.d.INFORMATION_SCHEMA.COLUMNS WHERE table_name = 't'", "a INT64, b STRING"},
+		{"SELECT STRING_AGG(field_path || ' ' || data_type, ', ' ORDER BY field_path) AS v " +
+			"FROM d.INFORMATION_SCHEMA.COLUMN_FIELD_PATHS WHERE table_name = 'r'", "rec STRUCT<x STRING>, rec.x STRING"},
+		{"SELECT view_definition AS v FROM d.INFORMATION_SCHEMA.VIEWS", "SELECT a FROM d.t"},
+		{"SELECT SUM(total_rows) AS v FROM d.INFORMATION_SCHEMA.PARTITIONS WHERE table_name = 't'", int64(3)},
+		{"SELECT STRING_AGG(schema_name, ', ' ORDER BY schema_name) AS v FROM p.INFORMATION_SCHEMA.SCHEMATA", "d, e"},
+		{"SELECT STRING_AGG(schema_name) AS v FROM `region-eu`.INFORMATION_SCHEMA.SCHEMATA", "e"},
+		{"SELECT STRING_AGG(job_id) AS v FROM `region-us`.INFORMATION_SCHEMA.JOBS_BY_PROJECT", "j1"},
+		{"SELECT COUNT(*) AS v FROM `region-us`.INFORMATION_SCHEMA.TABLES", int64(3)},
+		{"SELECT * FROM INFORMATION_SCHEMA.TABLES",
+			`Table name "INFORMATION_SCHEMA.TABLES" missing dataset while no default dataset is set in the request.`},
+	} {
+		result, err := ExecuteQuery(test.query, projects, "p", config)
+		if message, ok := test.want.(string); ok && err != nil {
+			if err.Message != message {
+				t.Errorf("%s failed with %q, want %q", test.query, err.Message, message)
+			}
+		} else if err != nil {
+			t.Errorf("%s: %s", test.query, err.Message)
+		} else if len(result.Rows) != 1 || !reflect.DeepEqual(result.Rows[0]["v"], test.want) {
+			t.Errorf("%s gave %v, want v = %#v", test.query, result.Rows, test.want)
+		}
+	}
+}
+
+func TestScripts(t *testing.T) {
+	for _, test := range []struct {
+		script string
+		want   interface{} // the value of v its last SELECT gives, or its error message
+	}{
+		{"DECLARE x INT64 DEFAULT 1; SET x = x + 1; SELECT x AS v", int64(2)},
+		{"DECLARE x INT64 DEFAULT 2; IF x = 1 THEN SELECT 'one' AS v; ELSEIF x = 2 THEN SELECT 'two' AS v; " +
+			"ELSE SELECT 'many' AS v; END IF", "two"},
+		{"DECLARE i INT64 DEFAULT 0; LOOP SET i = i + 1; IF i >= 3 THEN BREAK; END IF; END LOOP; SELECT i AS v", int64(3)},
+		{"DECLARE i INT64 DEFAULT 0; WHILE i < 5 DO SET i = i + 2; END WHILE; SELECT i AS v", int64(6)},
+		{"DECLARE total INT64 DEFAULT 0; FOR r IN (SELECT a FROM d.t) DO SET total = total + r.a; END FOR; " +
+			"SELECT total AS v", int64(6)},
+		{"BEGIN SELECT 1 / 0; EXCEPTION WHEN ERROR THEN SELECT @@error.message AS v; END", "division by zero: 1 / 0"},
+		{"SELECT 1 AS v; RETURN; SELECT 2 AS v", int64(1)},
+		{"DECLARE y INT64; EXECUTE IMMEDIATE 'SELECT @x * 2' INTO y USING 3 AS x; SELECT y AS v", int64(6)},
+		{"CREATE TEMP TABLE tmp AS SELECT a FROM d.t WHERE a > 1; SELECT SUM(a) AS v FROM tmp", int64(5)},
+		{"SELECT 1; RAISE USING MESSAGE = 'boom'", "boom at [1:11]"},
+	} {
+		result, err := ExecuteQuery(test.script, testProjects(), "p", Config{})
+		if message, ok := test.want.(string); ok && err != nil {
+			if err.Message != message {
+				t.Errorf("%s failed with %q, want %q", test.script, err.Message, message)
+			}
+		} else if err != nil {
+			t.Errorf("%s: %s", test.script, err.Message)
+		} else if result.StatementType != "SCRIPT" || len(result.Rows) != 1 ||
+			!reflect.DeepEqual(result.Rows[0]["v"], test.want) {
+			t.Errorf("%s gave %s %v, want a SCRIPT with v = %#v", test.script, result.StatementType, result.Rows, test.want)
+		}
+	}
+
+	result, err := ExecuteQuery("SELECT 1;\nINSERT INTO d.t (a) VALUES (4);\nSELECT 1 / 0", testProjects(), "p", Config{})
+	if err == nil {
+		t.Fatalf("a script dividing by zero succeeded")
+	}
+	children := result.ChildJobs
+	if len(children) != 3 || children[1].Query != "INSERT INTO d.t (a) VALUES (4)" || children[1].StartLine != 2 ||
+		children[1].Result == nil || children[2].Error == nil {
+		t.Errorf("script ran child jobs %+v, want SELECT, INSERT and a failed SELECT", children)
+	}
+}
@@ -0,0 +1,631 @@
+package queries
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/danielstutzman/fake-bigquery/data"
+)
+
+// script runs the statements of a multi-statement query in turn, each SQL
+// statement as a child job of the query's job.
+type script struct {
+	projects    map[string]data.Project
+	projectName string
+	config      Config
+	params      *parameters
+	session     *Session
+	scopes      []map[string]*variable // by lowercased name, the session's first and innermost block last
+	result      *data.Result           // the script's own, with its child jobs so far
+	rowCount    interface{}            // @@row_count: the rows the last DML statement changed
+	handling    []*scriptError         // the errors being handled, innermost last
+}
+
+type variable struct {
+	field data.Field
+	value interface{}
+}
+
+// scriptError is an error that stops a script unless a block's exception
+// handler catches it.
+type scriptError struct {
+	err       *data.Error
+	message   string // for @@error.message; RAISE's has no position
+	statement ScriptStatement
+}
+
+func (e *scriptError) Error() string {
+	return e.err.Message
+}
+
+// scriptSignal interrupts a script's statements for BREAK, CONTINUE or
+// RETURN.
+type scriptSignal struct {
+	kind string
+	pos  position
+}
+
+func (s *scriptSignal) Error() string {
+	return s.kind
+}
+
+// isScript reports whether a query's statements must run as a script,
+// which is the case for several statements or any scripting statement.
+func isScript(statements []ScriptStatement) bool {
+	if len(statements) != 1 {
+		return true
+	}
+	switch statements[0].Statement.(type) {
+	case *DeclareStatement, *SetStatement, *IfStatement, *LoopStatement, *ForStatement,
+		*BlockStatement, *RaiseStatement, *ReturnStatement, *ExecuteImmediateStatement:
+		return true
+	}
+	return false
+}
+
+// executeScript runs a script, whose result is that of its last statement
+// with the bytes of all of them. A script is only parsed when dry run,
+// since what it runs depends on what it computes. An error the script
+// doesn't handle rolls back its transaction, as does ending without
+// committing one outside of a session.
+func executeScript(statements []ScriptStatement, projects map[string]data.Project, projectName string,
+	config Config) (*data.Result, *data.Error) {
+
+	if config.DryRun {
+		return newScriptResult(), nil
+	}
+	params, dataErr := newParameters(config)
+	if dataErr != nil {
+		return nil, dataErr
+	}
+	session := config.Session
+	if session == nil {
+		session = newSession(projectName, "_script")
+	}
+	s := newScript(projects, projectName, config, params, session)
+	err := s.run(statements)
+	// The parser rejects these, so this is only a safeguard
+	if signal, ok := err.(*scriptSignal); ok && signal.kind != "RETURN" {
+		dataErr := toDataError(&syntaxError{signal.kind + " is only allowed inside a loop", signal.pos})
+		err = &scriptError{err: dataErr, message: dataErr.Message}
+	}
+	if t := session.transaction; err == nil && config.Session == nil && t != nil {
+		dataErr := toDataError(queryError(t.begin, "Transaction was neither committed nor rolled back by the end of the script"))
+		err = &scriptError{err: dataErr, message: dataErr.Message}
+	}
+	if _, failed := err.(*scriptError); failed {
+		session.transaction = nil
+	}
+	if config.Session == nil {
+		s.dropTemporaryTables()
+	}
+	if scriptErr, ok := err.(*scriptError); ok {
+		return s.result, scriptErr.err
+	}
+	return s.result, nil
+}
+
+func newScriptResult() *data.Result {
+	return &data.Result{StatementType: "SCRIPT", Fields: []data.Field{}, Rows: []map[string]interface{}{},
+		ReferencedTables: []data.TableRef{}, ChildJobs: []data.ChildJob{}}
+}
+
+func newScript(projects map[string]data.Project, projectName string, config Config, params *parameters,
+	session *Session) *script {
+
+	return &script{
+		projects:    projects,
+		projectName: projectName,
+		config:      config,
+		params:      params,
+		session:     session,
+		scopes:      []map[string]*variable{session.variables},
+		result:      newScriptResult(),
+	}
+}
+
+func newScriptId() string {
+	bytes := make([]byte, 20)
+	if _, err := rand.Read(bytes); err != nil {
+		log.Fatalf("Error from rand.Read: %v", err)
+	}
+	return hex.EncodeToString(bytes)
+}
+
+func (s *script) dropTemporaryTables() {
+	temporary := s.session.temporary
+	if project, ok := s.projects[temporary.ProjectId]; ok {
+		delete(project.Datasets, temporary.DatasetId)
+	}
+}
+
+// executor runs statements on the tables as the script's transaction sees
+// them, if it's in one.
+func (s *script) executor(params *parameters) *executor {
+	projects := s.projects
+	if t := s.session.transaction; t != nil {
+		projects = t.projects
+	}
+	e := newExecutor(projects, s.projectName, s.config, params)
+	e.script = s
+	return e
+}
+
+func (s *script) run(statements []ScriptStatement) error {
+	for _, statement := range statements {
+		if err := s.runStatement(statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *script) runStatement(statement ScriptStatement) error {
+	var err error
+	switch st := statement.Statement.(type) {
+	case *DeclareStatement:
+		err = s.declare(st)
+	case *SetStatement:
+		err = s.set(st)
+	case *IfStatement:
+		err = s.runIf(st)
+	case *LoopStatement:
+		err = s.loop(st)
+	case *ForStatement:
+		err = s.forIn(statement, st)
+	case *BlockStatement:
+		err = s.block(st)
+	case *RaiseStatement:
+		err = s.raise(statement, st)
+	case *ReturnStatement:
+		err = &scriptSignal{"RETURN", st.Pos}
+	case *BreakStatement:
+		err = &scriptSignal{"BREAK", st.Pos}
+		if st.Continue {
+			err = &scriptSignal{"CONTINUE", st.Pos}
+		}
+	case *ExecuteImmediateStatement:
+		err = s.executeImmediate(statement, st)
+	default:
+		_, err = s.execute(statement, st, s.params, "STATEMENT")
+	}
+	switch err.(type) {
+	case nil, *scriptError, *scriptSignal:
+		return err
+	}
+	dataErr := toDataError(err)
+	return &scriptError{err: dataErr, message: dataErr.Message, statement: statement}
+}
+
+// execute runs a SQL statement of the script as a child job.
+func (s *script) execute(statement ScriptStatement, sql Statement, params *parameters,
+	evaluationKind string) (*data.Result, error) {
+
+	end := endPosition(statement.Pos, statement.Text)
+	child := data.ChildJob{
+		Query:          statement.Text,
+		EvaluationKind: evaluationKind,
+		StartLine:      statement.Pos.Line,
+		StartColumn:    statement.Pos.Column,
+		EndLine:        end.Line,
+		EndColumn:      end.Column,
+		StartTime:      data.NowMillis(),
+	}
+	result, err := s.executor(params).executeStatement(sql)
+	child.EndTime = data.NowMillis()
+	if err != nil {
+		child.Error = toDataError(err)
+	} else {
+		child.Result = result
+		s.result.TotalBytesProcessed += result.TotalBytesProcessed
+		s.result.TotalBytesBilled += result.TotalBytesBilled
+		for _, ref := range result.ReferencedTables {
+			if !containsTableRef(s.result.ReferencedTables, ref) {
+				s.result.ReferencedTables = append(s.result.ReferencedTables, ref)
+			}
+		}
+		if evaluationKind == "STATEMENT" {
+			s.result.Fields, s.result.Rows = result.Fields, result.Rows
+			s.rowCount = nil
+			if result.DmlStats != nil {
+				s.rowCount = result.DmlStats.AffectedRows()
+			}
+		}
+	}
+	s.result.ChildJobs = append(s.result.ChildJobs, child)
+	return result, err
+}
+
+func containsTableRef(refs []data.TableRef, ref data.TableRef) bool {
+	for _, other := range refs {
+		if other == ref {
+			return true
+		}
+	}
+	return false
+}
+
+// endPosition is where text that starts at start ends.
+func endPosition(start position, text string) position {
+	lines := strings.Split(text, "\n")
+	if len(lines) == 1 {
+		return position{start.Line, start.Column + len([]rune(text)) - 1}
+	}
+	return position{start.Line + len(lines) - 1, len([]rune(lines[len(lines)-1]))}
+}
+
+// evaluate computes an expression of a scripting statement.
+func (s *script) evaluate(x Expr) (data.Field, interface{}, error) {
+	c := &compiler{executor: s.executor(s.params), ctes: map[string]*cte{}}
+	compiled, err := c.compileExpr(x)
+	if err != nil {
+		return data.Field{}, nil, err
+	}
+	value, err := compiled.eval(&evalContext{})
+	return compiled.field, value, err
+}
+
+// condition evaluates the condition of an IF or a loop, where NULL counts
+// as false.
+func (s *script) condition(x Expr, clause string) (bool, error) {
+	field, value, err := s.evaluate(x)
+	if err != nil {
+		return false, err
+	}
+	if field.Type != "BOOLEAN" && field.Type != "NULL" {
+		return false, queryError(x.position(), "%s condition must be BOOL, but has type %s", clause, typeName(field))
+	}
+	b, _ := value.(bool)
+	return b, nil
+}
+
+// Variables
+
+func (s *script) lookup(name string) *variable {
+	for i := len(s.scopes) - 1; i >= 0; i-- {
+		if v, ok := s.scopes[i][strings.ToLower(name)]; ok {
+			return v
+		}
+	}
+	return nil
+}
+
+func (s *script) lookupAll(names []string, pos position) ([]*variable, error) {
+	variables := make([]*variable, len(names))
+	for i, name := range names {
+		if variables[i] = s.lookup(name); variables[i] == nil {
+			return nil, queryError(pos, "Undeclared variable: %s", name)
+		}
+	}
+	return variables, nil
+}
+
+func (s *script) declare(statement *DeclareStatement) error {
+	var field data.Field
+	var value interface{}
+	var err error
+	if statement.Type != nil {
+		field = fieldFromTypeSpec(statement.Type)
+		if statement.Default != nil {
+			value, err = s.executor(s.params).constantValue(statement.Default, field, statement.Names[0])
+		}
+	} else if field, value, err = s.evaluate(statement.Default); field.Type == "NULL" {
+		field = scalarField("INTEGER")
+	}
+	if err != nil {
+		return err
+	}
+	for _, name := range statement.Names {
+		if s.lookup(name) != nil {
+			return queryError(statement.Pos, "Variable %s is already declared", name)
+		}
+		s.scopes[len(s.scopes)-1][strings.ToLower(name)] = &variable{field: field, value: value}
+	}
+	return nil
+}
+
+func (s *script) set(statement *SetStatement) error {
+	variables, err := s.lookupAll(statement.Names, statement.Pos)
+	if err != nil {
+		return err
+	}
+	if !statement.Tuple {
+		value, err := s.executor(s.params).constantValue(statement.X, variables[0].field, statement.Names[0])
+		if err != nil {
+			return err
+		}
+		variables[0].value = value
+		return nil
+	}
+
+	field, value, err := s.evaluate(statement.X)
+	if err != nil {
+		return err
+	}
+	if field.Type != "RECORD" || isArray(field) || len(field.Fields) != len(variables) {
+		return queryError(statement.X.position(), "SET with %d variables requires a STRUCT with %d fields, but got %s",
+			len(variables), len(variables), typeName(field))
+	}
+	record, _ := value.(map[string]interface{})
+	return s.assignFields(statement.Names, field.Fields, record, statement.X.position())
+}
+
+// assignFields sets each named variable to the matching field of a
+// record, for SET (a, b) = ... and EXECUTE IMMEDIATE ... INTO a, b.
+func (s *script) assignFields(names []string, fields []data.Field, record map[string]interface{},
+	pos position) error {
+
+	variables, err := s.lookupAll(names, pos)
+	if err != nil {
+		return err
+	}
+	values := make([]interface{}, len(variables))
+	for i, v := range variables {
+		if !assignable(fields[i], v.field) {
+			return queryError(pos, "Invalid value for %s: expected %s but got %s",
+				names[i], typeName(v.field), typeName(fields[i]))
+		}
+		values[i] = coerce(record[fields[i].Name], fields[i], v.field)
+	}
+	for i, v := range variables {
+		v.value = values[i]
+	}
+	return nil
+}
+
+// variable finds a script variable that a name in a statement refers to,
+// if the statement is part of a script.
+func (e *executor) variable(name string) *variable {
+	if e.script == nil {
+		return nil
+	}
+	return e.script.lookup(name)
+}
+
+// compileVariable reads a script variable, or a field of one, for a name
+// that isn't a column.
+func (c *compiler) compileVariable(v *variable, e *Path) (expression, error) {
+	field := v.field
+	names := []string{}
+	for _, name := range e.Parts[1:] {
+		var err error
+		if field, err = structField(field, name, e.Pos); err != nil {
+			return expression{}, err
+		}
+		names = append(names, field.Name)
+	}
+	return expression{field: field, eval: func(ctx *evalContext) (interface{}, error) {
+		value := v.value
+		for _, name := range names {
+			record, _ := value.(map[string]interface{})
+			value = record[name]
+		}
+		return value, nil
+	}}, nil
+}
+
+// compileSystemVariable reads a system variable like @@row_count, which
+// parses as a parameter named @row_count.
+func (c *compiler) compileSystemVariable(e *Param) (expression, error) {
+	s := c.executor.script
+	name := strings.ToLower(e.Name[1:])
+	switch name {
+	case "project_id":
+		return constant(scalarField("STRING"), c.executor.projectName), nil
+	case "time_zone":
+		return constant(scalarField("STRING"), "UTC"), nil
+	case "session_id":
+		var sessionId interface{}
+		if s != nil && s.session.Id != "" {
+			sessionId = s.session.Id
+		}
+		return constant(scalarField("STRING"), sessionId), nil
+	case "row_count":
+		var rowCount interface{}
+		if s != nil {
+			rowCount = s.rowCount
+		}
+		return constant(scalarField("INTEGER"), rowCount), nil
+	case "error.message", "error.statement_text", "error.formatted_stack_trace":
+		if s == nil || len(s.handling) == 0 {
+			return expression{}, queryError(e.Pos, "@@%s is only available in an exception handler", name)
+		}
+		handling := s.handling[len(s.handling)-1]
+		value := handling.message
+		if name == "error.statement_text" {
+			value = handling.statement.Text
+		} else if name == "error.formatted_stack_trace" {
+			value = fmt.Sprintf("At %s\n", handling.statement.Pos)
+		}
+		return constant(scalarField("STRING"), value), nil
+	}
+	return expression{}, queryError(e.Pos, "Unrecognized name: @@%s", e.Name[1:])
+}
+
+// Temp tables
+
+// temporaryTable resolves a table name alone to one of the script's temp
+// tables, if it has one by that name.
+func (e *executor) temporaryTable(path []string) (data.TableRef, bool) {
+	if e.script == nil || len(path) != 1 {
+		return data.TableRef{}, false
+	}
+	ref := e.script.session.temporary
+	ref.TableId = path[0]
+	_, exists := e.projects[ref.ProjectId].Datasets[ref.DatasetId].Tables[ref.TableId]
+	return ref, exists
+}
+
+// temporaryTableRef gives where CREATE TEMP TABLE creates a table, making
+// the hidden dataset for the script's temp tables if it's the first.
+func (e *executor) temporaryTableRef(target *TableName) (data.TableRef, error) {
+	if e.script == nil {
+		return data.TableRef{}, queryError(target.Pos, "Use of CREATE TEMPORARY TABLE requires a script or session")
+	}
+	if len(target.Path) != 1 {
+		return data.TableRef{}, queryError(target.Pos, "Temporary table name %s cannot be qualified",
+			strings.Join(target.Path, "."))
+	}
+	ref := e.script.session.temporary
+	ref.TableId = target.Path[0]
+	project, projectOk := e.projects[ref.ProjectId]
+	if !projectOk {
+		project = data.Project{Datasets: map[string]data.Dataset{}}
+		e.projects[ref.ProjectId] = project
+	}
+	if _, datasetExists := project.Datasets[ref.DatasetId]; !datasetExists {
+		nowMillis := data.NowMillis()
+		project.Datasets[ref.DatasetId] = data.Dataset{
+			Tables:           map[string]data.Table{},
+			Location:         "US",
+			Access:           data.DEFAULT_ACCESS,
+			CreationTime:     nowMillis,
+			LastModifiedTime: nowMillis,
+		}
+	}
+	return ref, nil
+}
+
+// Control flow
+
+func (s *script) runIf(statement *IfStatement) error {
+	for _, branch := range statement.Branches {
+		if ok, err := s.condition(branch.Cond, "IF"); err != nil {
+			return err
+		} else if ok {
+			return s.run(branch.Body)
+		}
+	}
+	return s.run(statement.Else)
+}
+
+// endIteration handles how a loop's body finished: BREAK ends the loop,
+// CONTINUE goes on to the next iteration, and an error or RETURN ends the
+// loop and more.
+func endIteration(err error) (bool, error) {
+	if signal, ok := err.(*scriptSignal); ok && signal.kind != "RETURN" {
+		return signal.kind == "BREAK", nil
+	}
+	return err != nil, err
+}
+
+func (s *script) loop(statement *LoopStatement) error {
+	for {
+		if statement.Kind == "WHILE" {
+			if ok, err := s.condition(statement.Cond, "WHILE"); err != nil || !ok {
+				return err
+			}
+		}
+		if stop, err := endIteration(s.run(statement.Body)); stop {
+			return err
+		}
+		if statement.Kind == "REPEAT" {
+			if done, err := s.condition(statement.Cond, "UNTIL"); err != nil || done {
+				return err
+			}
+		}
+	}
+}
+
+// forIn runs a FOR loop's query as a child job, then its body with the
+// loop variable set to each row as a STRUCT.
+func (s *script) forIn(statement ScriptStatement, loop *ForStatement) error {
+	query := ScriptStatement{Pos: statement.Pos, Text: loop.QueryText}
+	result, err := s.execute(query, &QueryStatement{Query: loop.Query}, s.params, "EXPRESSION")
+	if err != nil {
+		return err
+	}
+	row := &variable{field: scalarField("RECORD")}
+	row.field.Fields = result.Fields
+	s.scopes = append(s.scopes, map[string]*variable{strings.ToLower(loop.Name): row})
+	defer func() { s.scopes = s.scopes[:len(s.scopes)-1] }()
+	for _, values := range result.Rows {
+		row.value = values
+		if stop, err := endIteration(s.run(loop.Body)); stop {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *script) block(block *BlockStatement) error {
+	s.scopes = append(s.scopes, map[string]*variable{})
+	err := s.run(block.Body)
+	s.scopes = s.scopes[:len(s.scopes)-1]
+	scriptErr, failed := err.(*scriptError)
+	if !failed || block.Handler == nil {
+		return err
+	}
+	s.handling = append(s.handling, scriptErr)
+	err = s.run(block.Handler)
+	s.handling = s.handling[:len(s.handling)-1]
+	return err
+}
+
+func (s *script) raise(statement ScriptStatement, raise *RaiseStatement) error {
+	if raise.Message == nil {
+		if len(s.handling) == 0 {
+			return queryError(raise.Pos, "RAISE without a message is only allowed in an exception handler")
+		}
+		return s.handling[len(s.handling)-1]
+	}
+	value, err := s.executor(s.params).constantValue(raise.Message, scalarField("STRING"), "MESSAGE")
+	if err != nil {
+		return err
+	}
+	message, _ := value.(string)
+	return &scriptError{err: &data.Error{Reason: "invalidQuery", Message: message + " at " + raise.Pos.String()},
+		message: message, statement: statement}
+}
+
+// executeImmediate runs a statement built as a string, as a child job
+// whose parameters come from USING and whose SELECT can set variables
+// with INTO.
+func (s *script) executeImmediate(statement ScriptStatement, execute *ExecuteImmediateStatement) error {
+	value, err := s.executor(s.params).constantValue(execute.SQL, scalarField("STRING"), "EXECUTE IMMEDIATE")
+	if err != nil {
+		return err
+	}
+	sql, _ := value.(string)
+	params := &parameters{named: map[string]expression{}}
+	for i, x := range execute.Using {
+		field, value, err := s.evaluate(x)
+		if err != nil {
+			return err
+		}
+		if name := execute.UsingNames[i]; name != "" {
+			params.named[strings.ToLower(name)] = constant(field, value)
+		} else {
+			params.positional = true
+			params.ordered = append(params.ordered, constant(field, value))
+		}
+	}
+	if params.positional && len(params.named) > 0 {
+		return queryError(execute.Pos, "EXECUTE IMMEDIATE cannot mix named and positional parameters")
+	}
+
+	dynamic, err := parseStatement(sql)
+	if err != nil {
+		return err
+	}
+	result, err := s.execute(ScriptStatement{Pos: statement.Pos, Text: sql}, dynamic, params, "STATEMENT")
+	if err != nil || execute.Into == nil {
+		return err
+	}
+	if result.StatementType != "SELECT" {
+		return queryError(execute.Pos, "EXECUTE IMMEDIATE ... INTO requires a SELECT statement")
+	} else if len(result.Fields) != len(execute.Into) {
+		return queryError(execute.Pos, "EXECUTE IMMEDIATE ... INTO has %d variables but the query returns %d columns",
+			len(execute.Into), len(result.Fields))
+	} else if len(result.Rows) > 1 {
+		return queryError(execute.Pos, "EXECUTE IMMEDIATE ... INTO returned more than one row")
+	}
+	var row map[string]interface{}
+	if len(result.Rows) == 1 {
+		row = result.Rows[0]
+	}
+	return s.assignFields(execute.Into, result.Fields, row, execute.Pos)
+}
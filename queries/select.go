@@ -0,0 +1,1386 @@
+package queries
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/danielstutzman/fake-bigquery/data"
+)
+
+// compiledQuery produces rows as positional tuples matching fields.
+type compiledQuery struct {
+	fields []data.Field
+	run    func(ctx *evalContext) ([][]interface{}, error)
+	deps   [][]column // the columns each output is computed from, when compiled lazily
+}
+
+// columns gives a relation the output columns of a query, which bill for
+// what they're computed from when they're used.
+func (query *compiledQuery) columns(alias string, fields []data.Field) []column {
+	columns := []column{}
+	for i, field := range fields {
+		col := column{qualifier: alias, name: field.Name, field: field}
+		if query.deps != nil {
+			col.deps = query.deps[i]
+		}
+		columns = append(columns, col)
+	}
+	return columns
+}
+
+// cte is a WITH clause entry, run at most once per statement.
+type cte struct {
+	query *compiledQuery
+	rows  [][]interface{}
+	done  bool
+}
+
+// relation is the output of a FROM clause item.
+type relation struct {
+	columns []column
+	ranges  []rangeVariable
+	rows    func(ctx *evalContext) ([][]interface{}, error)
+}
+
+type ordering struct {
+	expr       expression
+	desc       bool
+	nullsFirst bool
+}
+
+func (c *compiler) compileQuery(q *Query) (*compiledQuery, error) {
+	inner := c.child(c.scope)
+	inner.lazy = true
+	if len(q.With) > 0 {
+		inner.ctes = map[string]*cte{}
+		for name, entry := range c.ctes {
+			inner.ctes[name] = entry
+		}
+		for _, with := range q.With {
+			name := strings.ToLower(with.Name)
+			if _, ok := inner.ctes[name]; ok && !c.hasCTE(name) {
+				return nil, runtimeError("Duplicate alias %s for WITH subquery", with.Name)
+			}
+			compiled, err := inner.compileQuery(with.Query)
+			if err != nil {
+				return nil, err
+			}
+			inner.ctes[name] = &cte{query: compiled}
+		}
+	}
+	inner.lazy = c.lazy
+
+	var query *compiledQuery
+	var err error
+	if sel, ok := q.Body.(*Select); ok {
+		query, err = inner.compileSelect(sel, q.OrderBy)
+	} else {
+		if query, err = inner.compileBody(q.Body); err == nil {
+			query, err = inner.orderOutput(query, q.OrderBy)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if q.Limit == nil && q.Offset == nil {
+		return query, nil
+	}
+	limit, offset := int64(-1), int64(0)
+	if q.Limit != nil {
+		if limit, err = c.constantInt(q.Limit, "LIMIT"); err != nil {
+			return nil, err
+		}
+	}
+	if q.Offset != nil {
+		if offset, err = c.constantInt(q.Offset, "OFFSET"); err != nil {
+			return nil, err
+		}
+	}
+	return &compiledQuery{fields: query.fields, deps: query.deps, run: func(ctx *evalContext) ([][]interface{}, error) {
+		rows, err := query.run(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if offset >= int64(len(rows)) {
+			return [][]interface{}{}, nil
+		}
+		rows = rows[offset:]
+		if limit >= 0 && limit < int64(len(rows)) {
+			rows = rows[:limit]
+		}
+		return rows, nil
+	}}, nil
+}
+
+func (c *compiler) hasCTE(name string) bool {
+	_, ok := c.ctes[name]
+	return ok
+}
+
+func (c *compiler) compileBody(body QueryBody) (*compiledQuery, error) {
+	switch body := body.(type) {
+	case *Select:
+		return c.compileSelect(body, nil)
+	case *SetOperation:
+		return c.compileSetOperation(body)
+	case *Query:
+		return c.compileQuery(body)
+	}
+	return nil, runtimeError("Unsupported query %T", body)
+}
+
+// constantInt evaluates a LIMIT or OFFSET, which can't refer to columns.
+func (c *compiler) constantInt(e Expr, clause string) (int64, error) {
+	x, err := c.child(nil).compileExpr(e)
+	if err != nil {
+		return 0, err
+	}
+	if x.field.Type != "INTEGER" {
+		return 0, queryError(e.position(), "%s expects an integer literal or parameter", clause)
+	}
+	value, err := x.eval(&evalContext{})
+	if err != nil {
+		return 0, err
+	}
+	if value == nil {
+		return 0, queryError(e.position(), "%s must not be null", clause)
+	}
+	if value.(int64) < 0 {
+		return 0, queryError(e.position(), "%s expects a non-negative integer literal or parameter", clause)
+	}
+	return value.(int64), nil
+}
+
+// orderOutput sorts the output of a set operation, whose ORDER BY can
+// only refer to its output columns.
+func (c *compiler) orderOutput(query *compiledQuery, orderBy []OrderItem) (*compiledQuery, error) {
+	if len(orderBy) == 0 {
+		return query, nil
+	}
+	sc := &scope{parent: c.scope, columns: query.columns("", query.fields)}
+	oc := c.child(sc)
+	oc.clause = "ORDER BY clause"
+	orderings, err := oc.compileOrdering(ordinalsToColumns(orderBy, sc.columns))
+	if err != nil {
+		return nil, err
+	}
+	return &compiledQuery{fields: query.fields, deps: query.deps, run: func(ctx *evalContext) ([][]interface{}, error) {
+		rows, err := query.run(ctx)
+		if err != nil {
+			return nil, err
+		}
+		contexts := make([]*evalContext, len(rows))
+		for i, row := range rows {
+			contexts[i] = &evalContext{row: row, outer: ctx}
+		}
+		if _, err := sortContexts(contexts, orderings); err != nil {
+			return nil, err
+		}
+		for i, rowCtx := range contexts {
+			rows[i] = rowCtx.row
+		}
+		return rows, nil
+	}}, nil
+}
+
+func ordinalsToColumns(orderBy []OrderItem, columns []column) []OrderItem {
+	converted := make([]OrderItem, len(orderBy))
+	for i, item := range orderBy {
+		converted[i] = item
+		if literal, ok := item.Expr.(*Literal); ok && literal.Type == "INTEGER" {
+			ordinal := literal.Value.(int64)
+			if ordinal >= 1 && ordinal <= int64(len(columns)) {
+				converted[i].Expr = &columnRef{Pos: literal.Pos, Index: int(ordinal - 1), Name: columns[ordinal-1].name}
+			}
+		}
+	}
+	return converted
+}
+
+func (c *compiler) compileOrdering(items []OrderItem) ([]ordering, error) {
+	orderings := []ordering{}
+	for _, item := range items {
+		if literal, ok := item.Expr.(*Literal); ok && literal.Type == "INTEGER" {
+			return nil, queryError(literal.Pos, "ORDER BY is out of SELECT column number range: %d", literal.Value)
+		}
+		x, err := c.compileExpr(item.Expr)
+		if err != nil {
+			return nil, err
+		}
+		if isArray(x.field) || x.field.Type == "RECORD" || x.field.Type == "JSON" {
+			return nil, queryError(item.Expr.position(), "ORDER BY does not support expressions of type %s",
+				typeName(x.field))
+		}
+		nullsFirst := !item.Desc
+		if item.NullsFirst != nil {
+			nullsFirst = *item.NullsFirst
+		}
+		orderings = append(orderings, ordering{expr: x, desc: item.Desc, nullsFirst: nullsFirst})
+	}
+	return orderings, nil
+}
+
+func compareKeys(a, b []interface{}, orderings []ordering) int {
+	for i, order := range orderings {
+		var result int
+		switch {
+		case a[i] == nil && b[i] == nil:
+			continue
+		case a[i] == nil || b[i] == nil:
+			result = 1
+			if (a[i] == nil) == order.nullsFirst {
+				result = -1
+			}
+			return result
+		}
+		result = compareValues(a[i], b[i])
+		if order.desc {
+			result = -result
+		}
+		if result != 0 {
+			return result
+		}
+	}
+	return 0
+}
+
+func evalKeys(contexts []*evalContext, orderings []ordering) ([][]interface{}, error) {
+	keys := make([][]interface{}, len(contexts))
+	for i, ctx := range contexts {
+		keys[i] = make([]interface{}, len(orderings))
+		for j, order := range orderings {
+			var err error
+			if keys[i][j], err = order.expr.eval(ctx); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return keys, nil
+}
+
+// sortedOrder gives the indexes of keys in sorted order, keeping ties in
+// their original order.
+func sortedOrder(keys [][]interface{}, orderings []ordering) []int {
+	order := make([]int, len(keys))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return compareKeys(keys[order[i]], keys[order[j]], orderings) < 0
+	})
+	return order
+}
+
+// sortContexts sorts rows in place, returning their sort keys in the new
+// order.
+func sortContexts(contexts []*evalContext, orderings []ordering) ([][]interface{}, error) {
+	keys, err := evalKeys(contexts, orderings)
+	if err != nil {
+		return nil, err
+	}
+	order := sortedOrder(keys, orderings)
+	sortedContexts := make([]*evalContext, len(contexts))
+	sortedKeys := make([][]interface{}, len(keys))
+	for i, index := range order {
+		sortedContexts[i], sortedKeys[i] = contexts[index], keys[index]
+	}
+	copy(contexts, sortedContexts)
+	return sortedKeys, nil
+}
+
+// SELECT
+
+type output struct {
+	name string
+	expr expression
+	deps []column // the columns it's computed from, when compiled lazily
+}
+
+func (c *compiler) compileSelect(sel *Select, orderBy []OrderItem) (*compiledQuery, error) {
+	rel := &relation{rows: func(ctx *evalContext) ([][]interface{}, error) {
+		return [][]interface{}{{}}, nil
+	}}
+	if sel.From != nil {
+		var err error
+		if rel, err = c.compileFromItem(sel.From); err != nil {
+			return nil, err
+		}
+		seen := map[string]bool{}
+		for _, rangeVar := range rel.ranges {
+			if seen[strings.ToLower(rangeVar.name)] {
+				return nil, queryError(sel.Pos, "Duplicate table alias %s in the same FROM clause", rangeVar.name)
+			}
+			seen[strings.ToLower(rangeVar.name)] = true
+		}
+	}
+	if sel.From != nil {
+		if err := c.checkPartitionFilters(sel.From, sel.Where); err != nil {
+			return nil, err
+		}
+	}
+	sc := &scope{columns: rel.columns, ranges: rel.ranges, parent: c.scope}
+	rc := c.child(sc)
+	rc.addScanFilters(sel.Where)
+
+	var where expression
+	if sel.Where != nil {
+		rc.clause = "WHERE clause"
+		var err error
+		if where, err = rc.compileCondition(sel.Where, "WHERE"); err != nil {
+			return nil, err
+		}
+	}
+
+	aggregating := len(sel.GroupBy) > 0 || sel.Having != nil || containsAggregate(orderBy) ||
+		containsAggregate(sel.Qualify)
+	for _, item := range sel.Items {
+		aggregating = aggregating || containsAggregate(item.Expr) || containsAggregate(item.Replace)
+	}
+
+	oc := rc
+	var groupKeys []expression
+	if aggregating {
+		rc.clause = "GROUP BY clause"
+		group := &grouping{}
+		for _, e := range sel.GroupBy {
+			e, err := groupByTarget(rc, e, sel.Items)
+			if err != nil {
+				return nil, err
+			}
+			key, err := rc.compileExpr(e)
+			if err != nil {
+				return nil, err
+			}
+			if isArray(key.field) || key.field.Type == "JSON" {
+				return nil, queryError(e.position(), "Grouping by expressions of type %s is not allowed",
+					typeName(key.field))
+			}
+			groupKeys = append(groupKeys, key)
+			group.keys = append(group.keys, rc.exprKey(e))
+			group.fields = append(group.fields, key.field)
+		}
+		oc = c.child(sc)
+		oc.grouping = group
+	}
+	windows := []*window{}
+	oc.windows = &windows
+	oc.clause = "SELECT list"
+	oc.lazy = c.lazy
+
+	outputs, err := oc.compileSelectItems(sel)
+	if err != nil {
+		return nil, err
+	}
+	aliases := map[string]Expr{}
+	for _, item := range sel.Items {
+		if item.Alias != "" && item.Expr != nil {
+			aliases[strings.ToLower(item.Alias)] = item.Expr
+		}
+	}
+
+	var having expression
+	if sel.Having != nil {
+		hc := *oc
+		hc.windows = nil
+		hc.aliases = aliases
+		hc.clause = "HAVING clause"
+		if having, err = hc.compileCondition(sel.Having, "HAVING"); err != nil {
+			return nil, err
+		}
+	}
+	var qualify expression
+	if sel.Qualify != nil {
+		qc := *oc
+		qc.aliases = aliases
+		qc.clause = "QUALIFY clause"
+		if qualify, err = qc.compileCondition(sel.Qualify, "QUALIFY"); err != nil {
+			return nil, err
+		}
+	}
+
+	orderings := []ordering{}
+	if len(orderBy) > 0 {
+		occ := *oc
+		occ.aliases = aliases
+		occ.clause = "ORDER BY clause"
+		for _, item := range orderBy {
+			target := -1
+			if literal, ok := item.Expr.(*Literal); ok && literal.Type == "INTEGER" {
+				ordinal := literal.Value.(int64)
+				if ordinal < 1 || ordinal > int64(len(outputs)) {
+					return nil, queryError(literal.Pos, "ORDER BY is out of SELECT column number range: %d", ordinal)
+				}
+				target = int(ordinal - 1)
+			} else if path, ok := item.Expr.(*Path); ok && len(path.Parts) == 1 {
+				for i, out := range outputs {
+					if out.name != "" && strings.EqualFold(out.name, path.Parts[0]) {
+						target = i
+						break
+					}
+				}
+			}
+			var order []ordering
+			if target != -1 {
+				billColumns(outputs[target].deps)
+				order, err = compileOrderingOf(outputs[target].expr, item)
+			} else {
+				order, err = occ.compileOrdering([]OrderItem{item})
+			}
+			if err != nil {
+				return nil, err
+			}
+			orderings = append(orderings, order...)
+		}
+	}
+
+	fields := make([]data.Field, len(outputs))
+	for i, out := range outputs {
+		fields[i] = outputField(out.name, out.expr.field)
+	}
+	var deps [][]column
+	for _, out := range outputs {
+		if sel.Distinct {
+			billColumns(out.deps)
+		} else if c.lazy {
+			deps = append(deps, out.deps)
+		}
+	}
+	if sel.AsStruct && deps != nil {
+		deps = [][]column{concatColumns(deps)}
+	}
+	if sel.Distinct {
+		for _, field := range fields {
+			if isArray(field) || field.Type == "JSON" {
+				return nil, queryError(sel.Pos, "Column %s of type %s cannot be used in SELECT DISTINCT",
+					field.Name, typeName(field))
+			}
+		}
+	}
+	if sel.AsStruct {
+		record := scalarField("RECORD")
+		for i, field := range fields {
+			if field.Name == "" {
+				field.Name = fmt.Sprintf("_field_%d", i+1)
+			}
+			record.Fields = append(record.Fields, field)
+		}
+		fields = []data.Field{record}
+	}
+
+	width := len(rel.columns)
+	asStruct := sel.AsStruct
+	distinct := sel.Distinct
+	hasWhere, hasHaving, hasQualify := sel.Where != nil, sel.Having != nil, sel.Qualify != nil
+	return &compiledQuery{fields: fields, deps: deps, run: func(ctx *evalContext) ([][]interface{}, error) {
+		rows, err := rel.rows(ctx)
+		if err != nil {
+			return nil, err
+		}
+		contexts := []*evalContext{}
+		for _, row := range rows {
+			rowCtx := &evalContext{row: row, outer: ctx}
+			if hasWhere {
+				value, err := where.eval(rowCtx)
+				if err != nil {
+					return nil, err
+				}
+				if value != true {
+					continue
+				}
+			}
+			contexts = append(contexts, rowCtx)
+		}
+
+		if aggregating {
+			groups := []*evalContext{}
+			byKey := map[string]*evalContext{}
+			for _, rowCtx := range contexts {
+				keys := make([]interface{}, len(groupKeys))
+				for i, key := range groupKeys {
+					if keys[i], err = key.eval(rowCtx); err != nil {
+						return nil, err
+					}
+				}
+				k := rowKey(keys)
+				group, ok := byKey[k]
+				if !ok {
+					// A group's row is its first row, so that correlated
+					// subqueries can see grouped columns
+					group = &evalContext{row: rowCtx.row, outer: ctx, keys: keys}
+					byKey[k] = group
+					groups = append(groups, group)
+				}
+				group.group = append(group.group, rowCtx)
+			}
+			if len(groupKeys) == 0 && len(groups) == 0 {
+				groups = append(groups, &evalContext{row: make([]interface{}, width), outer: ctx, group: []*evalContext{}})
+			}
+			contexts = groups
+		}
+
+		if hasHaving {
+			kept := []*evalContext{}
+			for _, groupCtx := range contexts {
+				value, err := having.eval(groupCtx)
+				if err != nil {
+					return nil, err
+				}
+				if value == true {
+					kept = append(kept, groupCtx)
+				}
+			}
+			contexts = kept
+		}
+
+		if len(windows) > 0 {
+			if err := computeWindows(contexts, windows); err != nil {
+				return nil, err
+			}
+		}
+
+		if hasQualify {
+			kept := []*evalContext{}
+			for _, rowCtx := range contexts {
+				value, err := qualify.eval(rowCtx)
+				if err != nil {
+					return nil, err
+				}
+				if value == true {
+					kept = append(kept, rowCtx)
+				}
+			}
+			contexts = kept
+		}
+
+		results := [][]interface{}{}
+		resultContexts := []*evalContext{}
+		seen := map[string]bool{}
+		for _, rowCtx := range contexts {
+			result := make([]interface{}, len(outputs))
+			for i, out := range outputs {
+				if result[i], err = out.expr.eval(rowCtx); err != nil {
+					return nil, err
+				}
+			}
+			if distinct {
+				k := rowKey(result)
+				if seen[k] {
+					continue
+				}
+				seen[k] = true
+			}
+			results = append(results, result)
+			resultContexts = append(resultContexts, rowCtx)
+		}
+
+		if len(orderings) > 0 {
+			keys, err := evalKeys(resultContexts, orderings)
+			if err != nil {
+				return nil, err
+			}
+			sorted := make([][]interface{}, len(results))
+			for i, index := range sortedOrder(keys, orderings) {
+				sorted[i] = results[index]
+			}
+			results = sorted
+		}
+
+		if asStruct {
+			for i, result := range results {
+				record := map[string]interface{}{}
+				for j, field := range fields[0].Fields {
+					record[field.Name] = result[j]
+				}
+				results[i] = []interface{}{record}
+			}
+		}
+		return results, nil
+	}}, nil
+}
+
+// compileOrderingOf sorts by an already compiled output column.
+func compileOrderingOf(x expression, item OrderItem) ([]ordering, error) {
+	if isArray(x.field) || x.field.Type == "RECORD" || x.field.Type == "JSON" {
+		return nil, queryError(item.Expr.position(), "ORDER BY does not support expressions of type %s",
+			typeName(x.field))
+	}
+	nullsFirst := !item.Desc
+	if item.NullsFirst != nil {
+		nullsFirst = *item.NullsFirst
+	}
+	return []ordering{{expr: x, desc: item.Desc, nullsFirst: nullsFirst}}, nil
+}
+
+// groupByTarget resolves a GROUP BY ordinal or SELECT list alias to the
+// expression it stands for.
+func groupByTarget(c *compiler, e Expr, items []SelectItem) (Expr, error) {
+	if literal, ok := e.(*Literal); ok && literal.Type == "INTEGER" {
+		ordinal := literal.Value.(int64)
+		if ordinal < 1 || ordinal > int64(len(items)) || items[ordinal-1].Star {
+			return nil, queryError(literal.Pos, "GROUP BY is out of SELECT column number range: %d", ordinal)
+		}
+		return items[ordinal-1].Expr, nil
+	}
+	if path, ok := e.(*Path); ok && len(path.Parts) == 1 {
+		if _, err := c.resolve(path.Parts, path.Pos); err != nil {
+			for _, item := range items {
+				if item.Alias != "" && strings.EqualFold(item.Alias, path.Parts[0]) {
+					if containsAggregate(item.Expr) {
+						return nil, queryError(path.Pos, "GROUP BY expression references column alias %s which contains an aggregate",
+							item.Alias)
+					}
+					return item.Expr, nil
+				}
+			}
+		}
+	}
+	return e, nil
+}
+
+// containsAggregate reports whether an expression calls an aggregate
+// function, not counting subqueries, which aggregate on their own.
+func containsAggregate(e interface{}) bool {
+	return walkChildren(reflect.ValueOf(e))
+}
+
+func walkChildren(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Interface:
+		if value.IsNil() {
+			return false
+		}
+		return walkChildren(value.Elem())
+	case reflect.Ptr:
+		if value.IsNil() {
+			return false
+		}
+		switch e := value.Interface().(type) {
+		case *Call:
+			if e.Over == nil && AGGREGATES[strings.TrimPrefix(e.Name, "SAFE.")] {
+				return true
+			}
+		case *Query:
+			return false
+		}
+		return walkChildren(value.Elem())
+	case reflect.Struct:
+		for i := 0; i < value.NumField(); i++ {
+			if walkChildren(value.Field(i)) {
+				return true
+			}
+		}
+	case reflect.Slice:
+		for i := 0; i < value.Len(); i++ {
+			if walkChildren(value.Index(i)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c *compiler) compileSelectItems(sel *Select) ([]output, error) {
+	outputs := []output{}
+	for _, item := range sel.Items {
+		if !item.Star {
+			x, deps, err := c.compileOutput(item.Expr)
+			if err != nil {
+				return nil, err
+			}
+			name := item.Alias
+			if name == "" && c.executor.legacy {
+				name = legacyName(item.Expr)
+			} else if name == "" {
+				name = impliedName(item.Expr)
+			}
+			outputs = append(outputs, output{name, x, deps})
+			continue
+		}
+
+		expanded, err := c.expandStar(sel, item)
+		if err != nil {
+			return nil, err
+		}
+		except := map[string]bool{}
+		for _, name := range item.Except {
+			except[strings.ToLower(name)] = true
+		}
+		replace := map[string]output{}
+		for _, replacement := range item.Replace {
+			x, deps, err := c.compileOutput(replacement.Expr)
+			if err != nil {
+				return nil, err
+			}
+			replace[strings.ToLower(replacement.Alias)] = output{replacement.Alias, x, deps}
+		}
+		found := map[string]bool{}
+		for _, out := range expanded {
+			name := strings.ToLower(out.name)
+			found[name] = true
+			if except[name] {
+				continue
+			}
+			if replacement, ok := replace[name]; ok {
+				out.expr, out.deps = replacement.expr, replacement.deps
+			}
+			outputs = append(outputs, out)
+		}
+		for _, name := range item.Except {
+			if !found[strings.ToLower(name)] {
+				return nil, queryError(sel.Pos, "Column %s in SELECT * EXCEPT list does not exist", name)
+			}
+		}
+		for _, replacement := range item.Replace {
+			if !found[strings.ToLower(replacement.Alias)] {
+				return nil, queryError(sel.Pos, "Column %s in SELECT * REPLACE list does not exist", replacement.Alias)
+			}
+		}
+	}
+	return outputs, nil
+}
+
+func impliedName(e Expr) string {
+	switch e := e.(type) {
+	case *Path:
+		return e.Parts[len(e.Parts)-1]
+	case *FieldAccess:
+		return e.Name
+	}
+	return ""
+}
+
+// expandStar expands * or x.* into one output per column or field.
+func (c *compiler) expandStar(sel *Select, item SelectItem) ([]output, error) {
+	outputs := []output{}
+	if item.Of == nil {
+		if sel.From == nil {
+			return nil, queryError(sel.Pos, "SELECT * must have a FROM clause")
+		}
+		for i, col := range c.scope.columns {
+			if col.hidden || col.merged {
+				continue
+			}
+			x, deps, err := c.compileOutput(&columnRef{Pos: sel.Pos, Index: i, Name: col.name})
+			if err != nil {
+				return nil, err
+			}
+			outputs = append(outputs, output{col.name, x, deps})
+		}
+		return outputs, nil
+	}
+
+	if path, ok := item.Of.(*Path); ok && len(path.Parts) == 1 {
+		for _, rangeVar := range c.scope.ranges {
+			if !strings.EqualFold(rangeVar.name, path.Parts[0]) {
+				continue
+			}
+			for i := rangeVar.start; i < rangeVar.end; i++ {
+				col := c.scope.columns[i]
+				if col.hidden {
+					continue
+				}
+				x, deps, err := c.compileOutput(&columnRef{Pos: path.Pos, Index: i, Name: col.name})
+				if err != nil {
+					return nil, err
+				}
+				outputs = append(outputs, output{col.name, x, deps})
+			}
+			return outputs, nil
+		}
+	}
+
+	record, deps, err := c.compileOutput(item.Of)
+	if err != nil {
+		return nil, err
+	}
+	if record.field.Type != "RECORD" || isArray(record.field) {
+		return nil, queryError(item.Of.position(), "Dot-star is not supported for type %s", typeName(record.field))
+	}
+	for _, field := range record.field.Fields {
+		name := field.Name
+		outputs = append(outputs, output{name, expression{field: field, eval: func(ctx *evalContext) (interface{}, error) {
+			value, err := record.eval(ctx)
+			if value == nil || err != nil {
+				return nil, err
+			}
+			return value.(map[string]interface{})[name], nil
+		}}, deps})
+	}
+	return outputs, nil
+}
+
+func computeWindows(contexts []*evalContext, windows []*window) error {
+	for _, ctx := range contexts {
+		ctx.window = make([]interface{}, len(windows))
+	}
+	for index, w := range windows {
+		partitions := [][]*evalContext{}
+		byKey := map[string]int{}
+		for _, ctx := range contexts {
+			keys := make([]interface{}, len(w.partitionBy))
+			for i, partition := range w.partitionBy {
+				var err error
+				if keys[i], err = partition.eval(ctx); err != nil {
+					return err
+				}
+			}
+			k := rowKey(keys)
+			i, ok := byKey[k]
+			if !ok {
+				i = len(partitions)
+				byKey[k] = i
+				partitions = append(partitions, nil)
+			}
+			partitions[i] = append(partitions[i], ctx)
+		}
+
+		for _, partition := range partitions {
+			keys, err := sortContexts(partition, w.orderBy)
+			if err != nil {
+				return err
+			}
+			peers := make([]int, len(partition))
+			for i := 1; i < len(partition); i++ {
+				peers[i] = peers[i-1]
+				if compareKeys(keys[i-1], keys[i], w.orderBy) != 0 {
+					peers[i] += 1
+				}
+			}
+			values, err := w.compute(partition, peers)
+			if err != nil {
+				return err
+			}
+			for i, ctx := range partition {
+				ctx.window[index] = values[i]
+			}
+		}
+	}
+	return nil
+}
+
+// Set operations
+
+func (c *compiler) compileSetOperation(op *SetOperation) (*compiledQuery, error) {
+	left, err := c.compileBody(op.Left)
+	if err != nil {
+		return nil, err
+	}
+	right, err := c.compileBody(op.Right)
+	if err != nil {
+		return nil, err
+	}
+	name := op.Op + " ALL"
+	if op.Distinct {
+		name = op.Op + " DISTINCT"
+	}
+	if len(left.fields) != len(right.fields) {
+		return nil, queryError(op.Pos, "Queries in %s have mismatched column count; query 1 has %d columns, query 2 has %d columns",
+			name, len(left.fields), len(right.fields))
+	}
+	fields := make([]data.Field, len(left.fields))
+	for i := range left.fields {
+		field, ok := supertype(left.fields[i], right.fields[i])
+		if !ok {
+			return nil, queryError(op.Pos, "Column %d in %s has incompatible types: %s, %s",
+				i+1, name, typeName(left.fields[i]), typeName(right.fields[i]))
+		}
+		if op.Distinct && (isArray(field) || field.Type == "JSON") {
+			return nil, queryError(op.Pos, "Column %d in %s has type that does not support set operation %s: %s",
+				i+1, name, op.Op, typeName(field))
+		}
+		fields[i] = outputField(left.fields[i].Name, field)
+	}
+	// Only UNION ALL can leave out columns the enclosing query doesn't
+	// use; the others compare whole rows.
+	var deps [][]column
+	for i := range fields {
+		if left.deps == nil || right.deps == nil {
+			break
+		} else if strings.EqualFold(op.Op, "UNION") && !op.Distinct {
+			deps = append(deps, concatColumns([][]column{left.deps[i], right.deps[i]}))
+		} else {
+			billColumns(left.deps[i])
+			billColumns(right.deps[i])
+		}
+	}
+
+	convert := func(rows [][]interface{}, from []data.Field) [][]interface{} {
+		for _, row := range rows {
+			for i := range row {
+				row[i] = coerce(row[i], from[i], fields[i])
+			}
+		}
+		return rows
+	}
+	kind, distinct := op.Op, op.Distinct
+	return &compiledQuery{fields: fields, deps: deps, run: func(ctx *evalContext) ([][]interface{}, error) {
+		leftRows, err := left.run(ctx)
+		if err != nil {
+			return nil, err
+		}
+		rightRows, err := right.run(ctx)
+		if err != nil {
+			return nil, err
+		}
+		leftRows, rightRows = convert(leftRows, left.fields), convert(rightRows, right.fields)
+
+		rightCounts := map[string]int{}
+		for _, row := range rightRows {
+			rightCounts[rowKey(row)] += 1
+		}
+		results := [][]interface{}{}
+		seen := map[string]bool{}
+		add := func(row []interface{}) {
+			k := rowKey(row)
+			if distinct && seen[k] {
+				return
+			}
+			seen[k] = true
+			results = append(results, row)
+		}
+		switch kind {
+		case "UNION":
+			for _, row := range append(leftRows, rightRows...) {
+				add(row)
+			}
+		case "INTERSECT":
+			for _, row := range leftRows {
+				k := rowKey(row)
+				if rightCounts[k] > 0 {
+					if !distinct {
+						rightCounts[k] -= 1
+					}
+					add(row)
+				}
+			}
+		case "EXCEPT":
+			for _, row := range leftRows {
+				k := rowKey(row)
+				if rightCounts[k] > 0 {
+					if !distinct {
+						rightCounts[k] -= 1
+					}
+					continue
+				}
+				add(row)
+			}
+		}
+		return results, nil
+	}}, nil
+}
+
+// FROM
+
+func (c *compiler) compileFromItem(item FromItem) (*relation, error) {
+	switch item := item.(type) {
+	case *TableName:
+		if len(item.Path) == 1 {
+			if entry, ok := c.ctes[strings.ToLower(item.Path[0])]; ok {
+				return c.cteRelation(item, entry), nil
+			}
+		}
+		if c.scope != nil {
+			if r, err := c.resolve(item.Path, item.Pos); err == nil && isArray(r.field) {
+				alias := item.Alias
+				if alias == "" {
+					alias = item.Path[len(item.Path)-1]
+				}
+				return c.compileUnnest(&UnnestFrom{Pos: item.Pos, X: &Path{Pos: item.Pos, Parts: item.Path},
+					Alias: alias})
+			}
+		}
+		return c.tableRelation(item)
+	case *SubqueryFrom:
+		sc := c.child(c.scope)
+		sc.lazy = true
+		query, err := sc.compileQuery(item.Query)
+		if err != nil {
+			return nil, err
+		}
+		rel := &relation{rows: query.run, columns: query.columns(item.Alias, query.fields)}
+		if item.Alias != "" {
+			rel.ranges = []rangeVariable{{item.Alias, 0, len(rel.columns)}}
+		}
+		return rel, nil
+	case *UnnestFrom:
+		return c.compileUnnest(item)
+	case *Join:
+		return c.compileJoin(item)
+	case *LegacyUnion:
+		return c.compileLegacyUnion(item.Items, "", item.Pos)
+	case *TableDateRange:
+		return c.compileTableDateRange(item)
+	case *Flatten:
+		return c.compileFlatten(item)
+	}
+	return nil, runtimeError("Unsupported FROM item %T", item)
+}
+
+func (c *compiler) cteRelation(item *TableName, entry *cte) *relation {
+	alias := item.Alias
+	if alias == "" {
+		alias = item.Path[0]
+	}
+	rel := &relation{rows: func(ctx *evalContext) ([][]interface{}, error) {
+		if !entry.done {
+			rows, err := entry.query.run(&evalContext{})
+			if err != nil {
+				return nil, err
+			}
+			entry.rows, entry.done = rows, true
+		}
+		return entry.rows, nil
+	}}
+	rel.columns = entry.query.columns(alias, entry.query.fields)
+	rel.ranges = []rangeVariable{{alias, 0, len(rel.columns)}}
+	return rel
+}
+
+// lookupTable finds the table a query refers to, as of when it's
+// compiled.
+func (c *compiler) lookupTable(path []string, pos position) (data.TableRef, data.Table, error) {
+	ref, err := c.executor.tableRef(path)
+	if err != nil {
+		return ref, data.Table{}, err
+	}
+	dataset, err := c.executor.lookupDataset(ref)
+	if err != nil {
+		return ref, data.Table{}, err
+	}
+	table, tableOk := dataset.Tables[ref.TableId]
+	if !tableOk {
+		return ref, data.Table{}, tableNotFound(ref, dataset)
+	}
+	return ref, table, nil
+}
+
+// tableRef resolves the path of a table named in a statement.
+func (e *executor) tableRef(path []string) (data.TableRef, error) {
+	if ref, ok := e.temporaryTable(path); ok {
+		return ref, nil
+	}
+	ref, ok := resolveTableRef(path, e.projectName, e.defaultDataset)
+	if !ok {
+		return ref, &data.Error{Reason: "invalid", Message: fmt.Sprintf(
+			"Table name \"%s\" missing dataset while no default dataset is set in the request.",
+			strings.Join(path, "."))}
+	}
+	return ref, nil
+}
+
+// resolveTableRef completes a table path of one, two or three parts. A
+// table name alone is in the default dataset, and dataset.table is in
+// the default dataset's project, or else the job's.
+func resolveTableRef(path []string, projectName string, defaultDataset *data.TableRef) (data.TableRef, bool) {
+	if defaultDataset != nil {
+		projectName = defaultDataset.ProjectId
+	}
+	switch {
+	case len(path) == 1 && defaultDataset != nil:
+		return data.TableRef{ProjectId: projectName, DatasetId: defaultDataset.DatasetId, TableId: path[0]}, true
+	case len(path) == 2:
+		return data.TableRef{ProjectId: projectName, DatasetId: path[0], TableId: path[1]}, true
+	case len(path) == 3:
+		return data.TableRef{ProjectId: path[0], DatasetId: path[1], TableId: path[2]}, true
+	default:
+		return data.TableRef{}, false
+	}
+}
+
+// lookupDataset finds the dataset holding ref, which needn't have a TableId.
+func (e *executor) lookupDataset(ref data.TableRef) (data.Dataset, error) {
+	dataset, datasetOk := e.projects[ref.ProjectId].Datasets[ref.DatasetId]
+	if !datasetOk {
+		return dataset, &data.Error{Reason: "notFound", Message: fmt.Sprintf(
+			"Not found: Dataset %s:%s was not found in location US", ref.ProjectId, ref.DatasetId)}
+	}
+	return dataset, nil
+}
+
+func tableNotFound(ref data.TableRef, dataset data.Dataset) error {
+	location := dataset.Location
+	if location == "" {
+		location = "US"
+	}
+	return &data.Error{Reason: "notFound", Message: fmt.Sprintf(
+		"Not found: Table %s was not found in location %s", ref, location)}
+}
+
+func (c *compiler) tableRelation(item *TableName) (*relation, error) {
+	if strings.HasSuffix(item.Path[len(item.Path)-1], "*") {
+		return c.wildcardRelation(item)
+	}
+	if isInformationSchema(item.Path) {
+		return c.informationSchemaRelation(item)
+	}
+	ref, table, err := c.lookupTable(item.Path, item.Pos)
+	if err != nil {
+		return nil, err
+	}
+	c.executor.referenceTable(ref)
+	if item.SystemTime != nil {
+		systemTime, err := c.child(nil).compileExpr(item.SystemTime)
+		if err != nil {
+			return nil, err
+		}
+		if systemTime.field.Type != "TIMESTAMP" {
+			return nil, queryError(item.SystemTime.position(), "FOR SYSTEM_TIME AS OF must be a TIMESTAMP, but has type %s",
+				typeName(systemTime.field))
+		}
+	}
+	alias := item.Alias
+	if alias == "" {
+		alias = item.Path[len(item.Path)-1]
+	}
+	if table.View != nil {
+		return c.viewRelation(ref, table.View, alias, item.Pos)
+	}
+	fields := normalizeFields(table.Fields)
+	rel := &relation{rows: func(ctx *evalContext) ([][]interface{}, error) {
+		return partitionedTableRows(table, fields), nil
+	}}
+	for _, field := range fields {
+		rel.columns = append(rel.columns, column{qualifier: alias, name: field.Name, field: field})
+	}
+	rel.columns = append(rel.columns, partitionColumns(table, alias)...)
+	c.executor.scanTable(table, fields, rel.columns)
+	rel.ranges = []rangeVariable{{alias, 0, len(rel.columns)}}
+	return rel, nil
+}
+
+// tableRows converts stored rows to tuples ordered like fields.
+func tableRows(fields []data.Field, rows []map[string]interface{}) [][]interface{} {
+	tuples := make([][]interface{}, len(rows))
+	for i, row := range rows {
+		tuple := make([]interface{}, len(fields))
+		for j, field := range fields {
+			tuple[j] = row[field.Name]
+		}
+		tuples[i] = tuple
+	}
+	return tuples
+}
+
+func (c *compiler) compileUnnest(item *UnnestFrom) (*relation, error) {
+	c = c.child(c.scope)
+	c.clause = "UNNEST"
+	array, err := c.compileExpr(item.X)
+	if err != nil {
+		return nil, err
+	}
+	if !isArray(array.field) && array.field.Type != "NULL" {
+		return nil, queryError(item.X.position(), "Values referenced in UNNEST must be arrays. UNNEST contains expression of type %s",
+			typeName(array.field))
+	}
+	element := elementField(array.field)
+	if array.field.Type == "NULL" {
+		element = scalarField("INTEGER")
+	}
+
+	rel := &relation{}
+	isStruct := element.Type == "RECORD"
+	if isStruct {
+		for _, field := range element.Fields {
+			rel.columns = append(rel.columns, column{qualifier: item.Alias, name: field.Name, field: field})
+		}
+		if item.Alias != "" {
+			rel.ranges = []rangeVariable{{item.Alias, 0, len(rel.columns)}}
+		}
+	} else {
+		rel.columns = []column{{qualifier: item.Alias, name: item.Alias, field: element}}
+	}
+	withOffset := item.WithOffset
+	if withOffset {
+		name := item.OffsetAlias
+		if name == "" {
+			name = "offset"
+		}
+		rel.columns = append(rel.columns, column{name: name, field: scalarField("INTEGER")})
+	}
+	fields := element.Fields
+	rel.rows = func(ctx *evalContext) ([][]interface{}, error) {
+		value, err := array.eval(ctx)
+		if err != nil {
+			return nil, err
+		}
+		elements, _ := value.([]interface{})
+		rows := make([][]interface{}, len(elements))
+		for i, element := range elements {
+			row := []interface{}{element}
+			if isStruct {
+				record, _ := element.(map[string]interface{})
+				row = make([]interface{}, len(fields))
+				for j, field := range fields {
+					row[j] = record[field.Name]
+				}
+			}
+			if withOffset {
+				row = append(row, int64(i))
+			}
+			rows[i] = row
+		}
+		return rows, nil
+	}
+	return rel, nil
+}
+
+// isLateral reports whether the right side of a join is evaluated once
+// per row of the left side, like UNNEST(t.array) or t.array.
+func isLateral(lc *compiler, item FromItem) bool {
+	switch item := item.(type) {
+	case *UnnestFrom:
+		return true
+	case *TableName:
+		if len(item.Path) == 1 && lc.hasCTE(strings.ToLower(item.Path[0])) {
+			return false
+		}
+		r, err := lc.resolve(item.Path, item.Pos)
+		return err == nil && r.depth == 0
+	}
+	return false
+}
+
+func (c *compiler) compileJoin(join *Join) (*relation, error) {
+	left, err := c.compileFromItem(join.Left)
+	if err != nil {
+		return nil, err
+	}
+	leftScope := &scope{columns: left.columns, ranges: left.ranges, parent: c.scope}
+	lc := c.child(leftScope)
+	lateral := isLateral(lc, join.Right)
+	var right *relation
+	if lateral {
+		if join.Kind == "RIGHT" || join.Kind == "FULL" {
+			return nil, queryError(join.Pos, "Array scan is not allowed with %s JOIN", join.Kind)
+		}
+		right, err = lc.compileFromItem(join.Right)
+	} else {
+		right, err = c.compileFromItem(join.Right)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rel := &relation{columns: append(append([]column{}, left.columns...), right.columns...)}
+	rel.ranges = append([]rangeVariable{}, left.ranges...)
+	for _, rangeVar := range right.ranges {
+		rel.ranges = append(rel.ranges, rangeVariable{rangeVar.name,
+			rangeVar.start + len(left.columns), rangeVar.end + len(left.columns)})
+	}
+	leftWidth, rightWidth := len(left.columns), len(right.columns)
+
+	var on expression
+	hasOn := join.On != nil
+	if hasOn {
+		oc := c.child(&scope{columns: rel.columns, ranges: rel.ranges, parent: c.scope})
+		oc.clause = "ON clause"
+		if on, err = oc.compileCondition(join.On, "ON"); err != nil {
+			return nil, err
+		}
+	}
+
+	// USING (a) joins on the left a = the right a, and hides the right a
+	using := [][2]int{}
+	for _, name := range join.Using {
+		pair := [2]int{-1, -1}
+		for side, columns := range [][]column{left.columns, right.columns} {
+			for i, col := range columns {
+				if !col.merged && strings.EqualFold(col.name, name) {
+					if pair[side] != -1 {
+						return nil, queryError(join.Pos, "Column %s in USING clause is ambiguous", name)
+					}
+					pair[side] = i
+				}
+			}
+			if pair[side] == -1 {
+				return nil, queryError(join.Pos, "Column %s in USING clause not found on %s side of join",
+					name, []string{"left", "right"}[side])
+			}
+		}
+		if !comparable(left.columns[pair[0]].field, right.columns[pair[1]].field, true) {
+			return nil, queryError(join.Pos, "Column %s in USING has incompatible types on either side of the join: %s and %s",
+				name, typeName(left.columns[pair[0]].field), typeName(right.columns[pair[1]].field))
+		}
+		c.executor.reference(left.columns[pair[0]], 0, pair[0])
+		c.executor.reference(right.columns[pair[1]], 0, pair[1]+leftWidth)
+		pair[1] += leftWidth
+		rel.columns[pair[1]].merged = true
+		using = append(using, pair)
+	}
+
+	kind := join.Kind
+	matches := func(ctx *evalContext, combined []interface{}) (bool, error) {
+		for _, pair := range using {
+			l, r := combined[pair[0]], combined[pair[1]]
+			if l == nil || r == nil || !compareWith("=", l, r) {
+				return false, nil
+			}
+		}
+		if hasOn {
+			value, err := on.eval(&evalContext{row: combined, outer: ctx})
+			return value == true, err
+		}
+		return true, nil
+	}
+	merge := func(combined []interface{}) []interface{} {
+		for _, pair := range using {
+			if combined[pair[0]] == nil {
+				combined[pair[0]] = combined[pair[1]]
+			}
+		}
+		return combined
+	}
+
+	rel.rows = func(ctx *evalContext) ([][]interface{}, error) {
+		leftRows, err := left.rows(ctx)
+		if err != nil {
+			return nil, err
+		}
+		var rightRows [][]interface{}
+		if !lateral {
+			if rightRows, err = right.rows(ctx); err != nil {
+				return nil, err
+			}
+		}
+		matched := make([]bool, len(rightRows))
+		results := [][]interface{}{}
+		for _, l := range leftRows {
+			if lateral {
+				if rightRows, err = right.rows(&evalContext{row: l, outer: ctx}); err != nil {
+					return nil, err
+				}
+			}
+			found := false
+			for j, r := range rightRows {
+				combined := append(append(make([]interface{}, 0, leftWidth+rightWidth), l...), r...)
+				ok, err := matches(ctx, combined)
+				if err != nil {
+					return nil, err
+				}
+				if !ok {
+					continue
+				}
+				found = true
+				if !lateral {
+					matched[j] = true
+				}
+				results = append(results, merge(combined))
+			}
+			if !found && (kind == "LEFT" || kind == "FULL") {
+				results = append(results, append(append([]interface{}{}, l...), make([]interface{}, rightWidth)...))
+			}
+		}
+		if kind == "RIGHT" || kind == "FULL" {
+			for j, r := range rightRows {
+				if !matched[j] {
+					results = append(results, merge(append(make([]interface{}, leftWidth), r...)))
+				}
+			}
+		}
+		return results, nil
+	}
+	return rel, nil
+}
@@ -0,0 +1,214 @@
+package queries
+
+import (
+	"strings"
+
+	"github.com/danielstutzman/fake-bigquery/data"
+)
+
+// Session is what the queries of a BigQuery session share: the variables
+// they declare, their temp tables and the transaction they're in, if any.
+// A script outside of a session gets one of its own with no Id.
+type Session struct {
+	Id          string
+	ProjectId   string
+	Terminated  bool // by CALL BQ.ABORT_SESSION()
+	variables   map[string]*variable
+	temporary   data.TableRef // the hidden dataset for its temp tables, once there are any
+	transaction *transaction
+}
+
+// NewSession starts a session for queries of a project that give its Id
+// as their session_id connection property.
+func NewSession(projectName string) *Session {
+	session := newSession(projectName, "_session")
+	session.Id = newScriptId()
+	return session
+}
+
+func newSession(projectName, datasetPrefix string) *Session {
+	return &Session{
+		ProjectId: projectName,
+		variables: map[string]*variable{},
+		temporary: data.TableRef{ProjectId: projectName, DatasetId: datasetPrefix + newScriptId()},
+	}
+}
+
+// transaction holds the tables as a multi-statement transaction sees
+// them: a snapshot from when it began, with its own changes on top.
+type transaction struct {
+	begin    position
+	snapshot map[string]data.Project // as of BEGIN
+	projects map[string]data.Project // what the transaction's statements read and write
+}
+
+// copyProjects copies the datasets and tables of projects, but not the
+// rows of the tables, which statements replace rather than change.
+func copyProjects(projects map[string]data.Project) map[string]data.Project {
+	copied := map[string]data.Project{}
+	for projectId, project := range projects {
+		datasets := map[string]data.Dataset{}
+		for datasetId, dataset := range project.Datasets {
+			tables := map[string]data.Table{}
+			for tableId, table := range dataset.Tables {
+				tables[tableId] = table
+			}
+			dataset.Tables = tables
+			datasets[datasetId] = dataset
+		}
+		copied[projectId] = data.Project{Datasets: datasets}
+	}
+	return copied
+}
+
+func lookupTable(projects map[string]data.Project, ref data.TableRef) (data.Table, bool) {
+	table, ok := projects[ref.ProjectId].Datasets[ref.DatasetId].Tables[ref.TableId]
+	return table, ok
+}
+
+// sameTable reports whether a table is still as it was, or still doesn't
+// exist. Only writes a user made count, so that flushing the streaming
+// buffer or dropping expired partitions doesn't.
+func sameTable(before data.Table, beforeOk bool, after data.Table, afterOk bool) bool {
+	if beforeOk != afterOk {
+		return false
+	} else if !beforeOk {
+		return true
+	}
+	return before.CreationTime == after.CreationTime && before.Version == after.Version
+}
+
+// changes lists the tables the transaction created, changed or dropped.
+func (t *transaction) changes() []data.TableRef {
+	refs := []data.TableRef{}
+	for _, projects := range []map[string]data.Project{t.projects, t.snapshot} {
+		for projectId, project := range projects {
+			for datasetId, dataset := range project.Datasets {
+				for tableId := range dataset.Tables {
+					ref := data.TableRef{ProjectId: projectId, DatasetId: datasetId, TableId: tableId}
+					before, beforeOk := lookupTable(t.snapshot, ref)
+					after, afterOk := lookupTable(t.projects, ref)
+					if !sameTable(before, beforeOk, after, afterOk) && !containsTableRef(refs, ref) {
+						refs = append(refs, ref)
+					}
+				}
+			}
+		}
+	}
+	return refs
+}
+
+// commit makes the transaction's changes to projects, unless another job
+// changed one of the same tables since the transaction began, in which
+// case it changes nothing.
+func (t *transaction) commit(projects map[string]data.Project, pos position) error {
+	changes := t.changes()
+	for _, ref := range changes {
+		before, beforeOk := lookupTable(t.snapshot, ref)
+		now, nowOk := lookupTable(projects, ref)
+		if !sameTable(before, beforeOk, now, nowOk) {
+			return queryError(pos, "Transaction is aborted due to concurrent update against table %s", ref)
+		}
+	}
+	for _, ref := range changes {
+		table, exists := lookupTable(t.projects, ref)
+		project, projectOk := projects[ref.ProjectId]
+		if !projectOk {
+			project = data.Project{Datasets: map[string]data.Dataset{}}
+			projects[ref.ProjectId] = project
+		}
+		dataset, datasetOk := project.Datasets[ref.DatasetId]
+		if !datasetOk {
+			// like the dataset for temp tables created in the transaction
+			dataset = t.projects[ref.ProjectId].Datasets[ref.DatasetId]
+			dataset.Tables = map[string]data.Table{}
+			project.Datasets[ref.DatasetId] = dataset
+		}
+		if exists {
+			dataset.Tables[ref.TableId] = table
+		} else {
+			delete(dataset.Tables, ref.TableId)
+		}
+	}
+	return nil
+}
+
+// executeTransaction runs BEGIN, COMMIT or ROLLBACK, which only a script
+// or a session can, since a transaction spans several statements.
+func (e *executor) executeTransaction(statement *TransactionStatement) (*data.Result, error) {
+	if e.script == nil {
+		return nil, queryError(statement.Pos, "Transaction control statements are supported only in scripts or sessions")
+	}
+	statementType := statement.Kind + "_TRANSACTION"
+	if e.dryRun {
+		return dryRunResult(statementType), nil
+	}
+	s := e.script
+	if statement.Kind == "BEGIN" {
+		if s.session.transaction != nil {
+			return nil, queryError(statement.Pos, "Nested transactions are not supported")
+		}
+		s.session.transaction = &transaction{
+			begin:    statement.Pos,
+			snapshot: copyProjects(s.projects),
+			projects: copyProjects(s.projects),
+		}
+	} else if s.session.transaction == nil {
+		return nil, queryError(statement.Pos, "%s TRANSACTION requires a transaction in progress", statement.Kind)
+	} else {
+		t := s.session.transaction
+		s.session.transaction = nil
+		if statement.Kind == "COMMIT" {
+			if err := t.commit(s.projects, statement.Pos); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &data.Result{StatementType: statementType, Fields: []data.Field{}, Rows: []map[string]interface{}{}}, nil
+}
+
+// checkTransactional fails the statements a transaction can't run, which
+// are those that create, change or drop anything but temp tables.
+func (e *executor) checkTransactional(statement Statement) error {
+	var pos position
+	switch statement := statement.(type) {
+	case *CreateTableStatement:
+		if statement.Temporary {
+			return nil
+		}
+		pos = statement.Pos
+	case *DropTableStatement:
+		if _, temporary := e.temporaryTable(statement.Target.Path); temporary {
+			return nil
+		}
+		pos = statement.Pos
+	case *AlterTableStatement:
+		pos = statement.Pos
+	case *CreateSchemaStatement:
+		pos = statement.Pos
+	case *DropSchemaStatement:
+		pos = statement.Pos
+	case *CreateViewStatement:
+		pos = statement.Pos
+	default:
+		return nil
+	}
+	return queryError(pos, "DDL statements on permanent entities are not supported inside a transaction")
+}
+
+// abortSession ends the session a CALL BQ.ABORT_SESSION() runs in,
+// rolling back its transaction and dropping its temp tables.
+func (e *executor) abortSession(statement *CallStatement) (*data.Result, error) {
+	name := strings.Join(statement.Procedure, ".")
+	if len(statement.Args) != 0 {
+		return nil, queryError(statement.Pos, "%s expects 0 arguments but got %d", name, len(statement.Args))
+	} else if e.script == nil || e.script.session.Id == "" {
+		return nil, queryError(statement.Pos, "%s can only be called in a session", name)
+	}
+	if !e.dryRun {
+		e.script.session.transaction = nil
+		e.script.dropTemporaryTables()
+		e.script.session.Terminated = true
+	}
+	return &data.Result{StatementType: "SCRIPT", Fields: []data.Field{}, Rows: []map[string]interface{}{}}, nil
+}
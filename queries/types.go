@@ -0,0 +1,680 @@
+package queries
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/danielstutzman/fake-bigquery/data"
+)
+
+// Values inside the query engine are stored like table values: int64,
+// float64 (FLOAT and NUMERIC), string, bool, []byte, time.Time (TIMESTAMP),
+// canonical strings for DATE, TIME and DATETIME, map[string]interface{}
+// for STRUCTs and []interface{} for ARRAYs. Types are data.Fields, with
+// Mode REPEATED for ARRAYs. An untyped NULL has type NULL.
+
+func scalarField(fieldType string) data.Field {
+	return data.Field{Type: fieldType, Mode: "NULLABLE"}
+}
+
+func arrayField(elem data.Field) data.Field {
+	elem.Mode = "REPEATED"
+	return elem
+}
+
+func isArray(field data.Field) bool {
+	return field.Mode == "REPEATED"
+}
+
+func elementField(field data.Field) data.Field {
+	field.Mode = "NULLABLE"
+	return field
+}
+
+func isNumeric(fieldType string) bool {
+	return fieldType == "INTEGER" || fieldType == "FLOAT" || fieldType == "NUMERIC" ||
+		fieldType == "BIGNUMERIC"
+}
+
+// typeName renders a type the way GoogleSQL error messages do.
+func typeName(field data.Field) string {
+	if isArray(field) {
+		return "ARRAY<" + typeName(elementField(field)) + ">"
+	}
+	switch field.Type {
+	case "INTEGER":
+		return "INT64"
+	case "FLOAT":
+		return "FLOAT64"
+	case "BOOLEAN":
+		return "BOOL"
+	case "NULL":
+		return "INT64"
+	case "RECORD":
+		parts := []string{}
+		for _, subfield := range field.Fields {
+			parts = append(parts, strings.TrimSpace(subfield.Name+" "+typeName(subfield)))
+		}
+		return "STRUCT<" + strings.Join(parts, ", ") + ">"
+	default:
+		return field.Type
+	}
+}
+
+// normalizeFields converts a table schema, which may use standard SQL
+// type names like INT64, to the legacy names used throughout.
+func normalizeFields(fields []data.Field) []data.Field {
+	normalized := make([]data.Field, len(fields))
+	for i, field := range fields {
+		normalized[i] = data.Field{
+			Name:   field.Name,
+			Type:   data.NormalizeType(field.Type),
+			Mode:   data.NormalizeMode(field.Mode),
+			Fields: normalizeFields(field.Fields),
+		}
+		if len(field.Fields) == 0 {
+			normalized[i].Fields = nil
+		}
+	}
+	return normalized
+}
+
+// outputField finalizes a type for a result schema, where an untyped NULL
+// becomes an INT64.
+func outputField(name string, field data.Field) data.Field {
+	field.Name = name
+	if field.Type == "NULL" {
+		field.Type = "INTEGER"
+	}
+	if field.Mode == "" {
+		field.Mode = "NULLABLE"
+	}
+	for i, subfield := range field.Fields {
+		field.Fields[i] = outputField(subfield.Name, subfield)
+	}
+	return field
+}
+
+func sameType(a, b data.Field) bool {
+	if a.Type != b.Type || isArray(a) != isArray(b) || len(a.Fields) != len(b.Fields) {
+		return false
+	}
+	for i := range a.Fields {
+		if !sameType(a.Fields[i], b.Fields[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// sameNames reports whether the fields of records a and b, at every
+// level, have the same names, so values of one need no renaming as the
+// other.
+func sameNames(a, b data.Field) bool {
+	for i := range a.Fields {
+		if i >= len(b.Fields) || a.Fields[i].Name != b.Fields[i].Name || !sameNames(a.Fields[i], b.Fields[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// supertype finds the common type of a and b that both coerce to, as for
+// the branches of a CASE or the columns of a UNION.
+func supertype(a, b data.Field) (data.Field, bool) {
+	if a.Type == "NULL" {
+		return b, true
+	}
+	if b.Type == "NULL" {
+		return a, true
+	}
+	if isArray(a) != isArray(b) {
+		return data.Field{}, false
+	}
+	if a.Type == "RECORD" && b.Type == "RECORD" {
+		if len(a.Fields) != len(b.Fields) {
+			return data.Field{}, false
+		}
+		result := a
+		result.Fields = make([]data.Field, len(a.Fields))
+		for i := range a.Fields {
+			field, ok := supertype(a.Fields[i], b.Fields[i])
+			if !ok {
+				return data.Field{}, false
+			}
+			field.Name = a.Fields[i].Name
+			result.Fields[i] = field
+		}
+		return result, true
+	}
+	if a.Type == b.Type {
+		return a, true
+	}
+	if isNumeric(a.Type) && isNumeric(b.Type) {
+		result := a
+		switch {
+		case a.Type == "FLOAT" || b.Type == "FLOAT":
+			result.Type = "FLOAT"
+		case a.Type == "BIGNUMERIC" || b.Type == "BIGNUMERIC":
+			result.Type = "BIGNUMERIC"
+		default:
+			result.Type = "NUMERIC"
+		}
+		return result, true
+	}
+	if (a.Type == "DATE" && b.Type == "DATETIME") || (a.Type == "DATETIME" && b.Type == "DATE") {
+		result := a
+		result.Type = "DATETIME"
+		return result, true
+	}
+	return data.Field{}, false
+}
+
+// coerce converts a value of type from to type to, which supertype chose.
+func coerce(value interface{}, from, to data.Field) interface{} {
+	if value == nil {
+		return nil
+	}
+	if isArray(to) {
+		elements, _ := value.([]interface{})
+		coerced := make([]interface{}, len(elements))
+		for i, element := range elements {
+			coerced[i] = coerce(element, elementField(from), elementField(to))
+		}
+		return coerced
+	}
+	switch to.Type {
+	case "FLOAT", "NUMERIC", "BIGNUMERIC":
+		if number, ok := value.(int64); ok {
+			return float64(number)
+		}
+	case "DATETIME":
+		if from.Type == "DATE" {
+			return value.(string) + "T00:00:00"
+		}
+	case "RECORD":
+		record, ok := value.(map[string]interface{})
+		if !ok || len(from.Fields) != len(to.Fields) {
+			return value
+		}
+		coerced := map[string]interface{}{}
+		for i, field := range to.Fields {
+			coerced[field.Name] = coerce(record[from.Fields[i].Name], from.Fields[i], field)
+		}
+		return coerced
+	}
+	return value
+}
+
+// compareValues orders two non-NULL values of comparable types.
+func compareValues(a, b interface{}) int {
+	switch a := a.(type) {
+	case int64:
+		switch b := b.(type) {
+		case int64:
+			return compareInts(a, b)
+		case float64:
+			return compareFloats(float64(a), b)
+		}
+	case float64:
+		switch b := b.(type) {
+		case int64:
+			return compareFloats(a, float64(b))
+		case float64:
+			return compareFloats(a, b)
+		}
+	case string:
+		if b, ok := b.(string); ok {
+			return strings.Compare(a, b)
+		}
+	case bool:
+		if b, ok := b.(bool); ok {
+			if a == b {
+				return 0
+			} else if !a {
+				return -1
+			}
+			return 1
+		}
+	case []byte:
+		if b, ok := b.([]byte); ok {
+			return bytes.Compare(a, b)
+		}
+	case time.Time:
+		if b, ok := b.(time.Time); ok {
+			if a.Before(b) {
+				return -1
+			} else if a.After(b) {
+				return 1
+			}
+			return 0
+		}
+	case map[string]interface{}:
+		return strings.Compare(valueKey(a), valueKey(b))
+	case []interface{}:
+		b, _ := b.([]interface{})
+		for i := 0; i < len(a) && i < len(b); i++ {
+			if a[i] == nil || b[i] == nil {
+				if a[i] == nil && b[i] != nil {
+					return -1
+				} else if a[i] != nil && b[i] == nil {
+					return 1
+				}
+				continue
+			}
+			if c := compareValues(a[i], b[i]); c != 0 {
+				return c
+			}
+		}
+		return compareInts(int64(len(a)), int64(len(b)))
+	}
+	return strings.Compare(valueKey(a), valueKey(b))
+}
+
+func compareInts(a, b int64) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+// compareFloats orders NaN before every other value, as ORDER BY does.
+func compareFloats(a, b float64) int {
+	switch {
+	case math.IsNaN(a) && math.IsNaN(b):
+		return 0
+	case math.IsNaN(a):
+		return -1
+	case math.IsNaN(b):
+		return 1
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// valueKey renders a value as a string that's equal for equal values, for
+// grouping, DISTINCT and set operations.
+func valueKey(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return "N"
+	case int64:
+		return "n" + strconv.FormatInt(value, 10)
+	case float64:
+		if value == math.Trunc(value) && math.Abs(value) < 1e18 {
+			return "n" + strconv.FormatInt(int64(value), 10)
+		}
+		return "n" + strconv.FormatFloat(value, 'g', -1, 64)
+	case string:
+		return "s" + strconv.Quote(value)
+	case bool:
+		return "b" + strconv.FormatBool(value)
+	case []byte:
+		return "y" + base64.StdEncoding.EncodeToString(value)
+	case time.Time:
+		return "t" + strconv.FormatInt(value.UnixNano(), 10)
+	case map[string]interface{}:
+		keys := []string{}
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		parts := []string{}
+		for _, key := range keys {
+			parts = append(parts, strconv.Quote(key)+":"+valueKey(value[key]))
+		}
+		return "{" + strings.Join(parts, ",") + "}"
+	case []interface{}:
+		parts := []string{}
+		for _, element := range value {
+			parts = append(parts, valueKey(element))
+		}
+		return "[" + strings.Join(parts, ",") + "]"
+	default:
+		return fmt.Sprintf("?%v", value)
+	}
+}
+
+func rowKey(values []interface{}) string {
+	parts := make([]string, len(values))
+	for i, value := range values {
+		parts[i] = valueKey(value)
+	}
+	return strings.Join(parts, "|")
+}
+
+// fieldFromTypeSpec converts a type written in a query to a data.Field.
+func fieldFromTypeSpec(spec *TypeSpec) data.Field {
+	if spec.Name == "ARRAY" {
+		return arrayField(fieldFromTypeSpec(spec.Elem))
+	}
+	field := scalarField(spec.Name)
+	for i, specField := range spec.Fields {
+		subfield := fieldFromTypeSpec(specField.Type)
+		subfield.Name = specField.Name
+		if subfield.Name == "" {
+			subfield.Name = fmt.Sprintf("_field_%d", i+1)
+		}
+		field.Fields = append(field.Fields, subfield)
+	}
+	return field
+}
+
+// formatValue renders a value the way CAST(x AS STRING) does.
+func formatValue(field data.Field, value interface{}) string {
+	switch value := value.(type) {
+	case float64:
+		if field.Type == "NUMERIC" || field.Type == "BIGNUMERIC" {
+			return strconv.FormatFloat(value, 'f', -1, 64)
+		}
+		switch {
+		case math.IsInf(value, 1):
+			return "inf"
+		case math.IsInf(value, -1):
+			return "-inf"
+		case math.IsNaN(value):
+			return "nan"
+		}
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	case time.Time:
+		return formatTimestamp(value)
+	case string:
+		if field.Type == "DATETIME" {
+			return strings.Replace(value, "T", " ", 1)
+		}
+		return value
+	case []byte:
+		return string(value)
+	case map[string]interface{}:
+		parts := []string{}
+		for _, subfield := range field.Fields {
+			if value[subfield.Name] == nil {
+				parts = append(parts, "NULL")
+			} else {
+				parts = append(parts, formatValue(subfield, value[subfield.Name]))
+			}
+		}
+		return "(" + strings.Join(parts, ", ") + ")"
+	case []interface{}:
+		parts := []string{}
+		for _, element := range value {
+			if element == nil {
+				parts = append(parts, "NULL")
+			} else {
+				parts = append(parts, formatValue(elementField(field), element))
+			}
+		}
+		return "[" + strings.Join(parts, ", ") + "]"
+	default:
+		return data.FormatScalar(field.Type, value)
+	}
+}
+
+func formatTimestamp(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04:05.999999") + "+00"
+}
+
+// castValue converts a non-NULL value between types, failing the way
+// CAST does for values that don't convert.
+func castValue(value interface{}, from, to data.Field) (interface{}, error) {
+	if isArray(from) || isArray(to) || from.Type == "RECORD" || to.Type == "RECORD" {
+		if sameType(from, to) {
+			return value, nil
+		}
+		if isArray(from) && isArray(to) {
+			elements, _ := value.([]interface{})
+			converted := make([]interface{}, len(elements))
+			for i, element := range elements {
+				if element == nil {
+					continue
+				}
+				var err error
+				if converted[i], err = castValue(element, elementField(from), elementField(to)); err != nil {
+					return nil, err
+				}
+			}
+			return converted, nil
+		}
+		if from.Type == "RECORD" && to.Type == "RECORD" && !isArray(from) && !isArray(to) &&
+			len(from.Fields) == len(to.Fields) {
+			record, _ := value.(map[string]interface{})
+			converted := map[string]interface{}{}
+			for i, field := range to.Fields {
+				element := record[from.Fields[i].Name]
+				if element == nil {
+					converted[field.Name] = nil
+					continue
+				}
+				var err error
+				if converted[field.Name], err = castValue(element, from.Fields[i], field); err != nil {
+					return nil, err
+				}
+			}
+			return converted, nil
+		}
+		return nil, fmt.Errorf("Invalid cast from %s to %s", typeName(from), typeName(to))
+	}
+
+	switch to.Type {
+	case "STRING":
+		if from.Type == "BYTES" {
+			return string(value.([]byte)), nil
+		}
+		if from.Type == "JSON" {
+			return value, nil
+		}
+		return formatValue(from, value), nil
+
+	case "INTEGER":
+		switch value := value.(type) {
+		case int64:
+			return value, nil
+		case float64:
+			rounded := math.Round(value)
+			if math.IsNaN(value) || math.IsInf(value, 0) || math.Abs(rounded) > 9.223372036854775e18 {
+				return nil, fmt.Errorf("Illegal conversion of non-finite floating point number to an integer: %v", value)
+			}
+			return int64(rounded), nil
+		case bool:
+			if value {
+				return int64(1), nil
+			}
+			return int64(0), nil
+		case string:
+			text := strings.TrimSpace(value)
+			parsed, err := strconv.ParseInt(text, 0, 64)
+			if err != nil {
+				return nil, fmt.Errorf("Bad int64 value: %s", value)
+			}
+			return parsed, nil
+		}
+
+	case "FLOAT", "NUMERIC", "BIGNUMERIC":
+		switch value := value.(type) {
+		case int64:
+			return float64(value), nil
+		case float64:
+			if to.Type != "FLOAT" && (math.IsNaN(value) || math.IsInf(value, 0)) {
+				return nil, fmt.Errorf("Illegal conversion of non-finite floating point number to numeric: %v", value)
+			}
+			if to.Type == "NUMERIC" {
+				return math.Round(value*1e9) / 1e9, nil
+			}
+			return value, nil
+		case string:
+			text := strings.TrimSpace(value)
+			switch strings.ToLower(text) {
+			case "inf", "+inf", "infinity":
+				if to.Type == "FLOAT" {
+					return math.Inf(1), nil
+				}
+			case "-inf", "-infinity":
+				if to.Type == "FLOAT" {
+					return math.Inf(-1), nil
+				}
+			case "nan":
+				if to.Type == "FLOAT" {
+					return math.NaN(), nil
+				}
+			}
+			parsed, err := strconv.ParseFloat(text, 64)
+			if err != nil {
+				if to.Type == "FLOAT" {
+					return nil, fmt.Errorf("Bad double value: %s", value)
+				}
+				return nil, fmt.Errorf("Invalid NUMERIC value: %s", value)
+			}
+			return parsed, nil
+		}
+
+	case "BOOLEAN":
+		switch value := value.(type) {
+		case bool:
+			return value, nil
+		case int64:
+			return value != 0, nil
+		case string:
+			switch strings.ToLower(strings.TrimSpace(value)) {
+			case "true":
+				return true, nil
+			case "false":
+				return false, nil
+			}
+			return nil, fmt.Errorf("Bad bool value: %s", value)
+		}
+
+	case "BYTES":
+		switch value := value.(type) {
+		case []byte:
+			return value, nil
+		case string:
+			return []byte(value), nil
+		}
+
+	case "DATE":
+		switch from.Type {
+		case "DATE":
+			return value, nil
+		case "DATETIME":
+			return value.(string)[:10], nil
+		case "TIMESTAMP":
+			return value.(time.Time).UTC().Format("2006-01-02"), nil
+		case "STRING":
+			parsed, err := time.Parse("2006-01-02", strings.TrimSpace(value.(string)))
+			if err != nil {
+				return nil, fmt.Errorf("Invalid date: '%s'", value)
+			}
+			return parsed.Format("2006-01-02"), nil
+		}
+
+	case "TIME":
+		switch from.Type {
+		case "TIME":
+			return value, nil
+		case "DATETIME":
+			parsed, _ := data.ParseDatetime(value.(string))
+			return parsed.Format("15:04:05.999999"), nil
+		case "TIMESTAMP":
+			return value.(time.Time).UTC().Format("15:04:05.999999"), nil
+		case "STRING":
+			converted, err := data.ConvertValue("TIME", value)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid time string \"%s\"", value)
+			}
+			return converted, nil
+		}
+
+	case "DATETIME":
+		switch from.Type {
+		case "DATETIME":
+			return value, nil
+		case "DATE":
+			return value.(string) + "T00:00:00", nil
+		case "TIMESTAMP":
+			return value.(time.Time).UTC().Format("2006-01-02T15:04:05.999999"), nil
+		case "STRING":
+			parsed, err := data.ParseDatetime(value.(string))
+			if err != nil {
+				return nil, fmt.Errorf("Invalid datetime string \"%s\"", value)
+			}
+			return parsed.Format("2006-01-02T15:04:05.999999"), nil
+		}
+
+	case "TIMESTAMP":
+		switch from.Type {
+		case "TIMESTAMP":
+			return value, nil
+		case "DATE":
+			parsed, _ := time.Parse("2006-01-02", value.(string))
+			return parsed, nil
+		case "DATETIME":
+			parsed, _ := data.ParseDatetime(value.(string))
+			return parsed.UTC(), nil
+		case "STRING":
+			text := strings.TrimSpace(value.(string))
+			if strings.HasSuffix(text, "+00") {
+				text = strings.TrimSuffix(text, "+00") + "Z"
+			}
+			parsed, err := data.ParseTimestamp(text)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid timestamp: '%s'", value)
+			}
+			return parsed, nil
+		}
+
+	case "JSON":
+		if from.Type == "JSON" {
+			return value, nil
+		}
+
+	case "GEOGRAPHY":
+		if from.Type == "GEOGRAPHY" || from.Type == "STRING" {
+			return value, nil
+		}
+	}
+	return nil, fmt.Errorf("Invalid cast from %s to %s", typeName(from), typeName(to))
+}
+
+// canCast reports whether CAST from one type to another is allowed.
+func canCast(from, to data.Field) bool {
+	if from.Type == "NULL" {
+		return true
+	}
+	if isArray(from) || isArray(to) {
+		return isArray(from) && isArray(to) && canCast(elementField(from), elementField(to))
+	}
+	if from.Type == "RECORD" || to.Type == "RECORD" {
+		if from.Type != to.Type || len(from.Fields) != len(to.Fields) {
+			return false
+		}
+		for i := range from.Fields {
+			if !canCast(from.Fields[i], to.Fields[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	if from.Type == to.Type || to.Type == "STRING" || from.Type == "STRING" {
+		return from.Type != "JSON" || to.Type == "JSON" || to.Type == "STRING"
+	}
+	switch to.Type {
+	case "INTEGER", "FLOAT", "NUMERIC", "BIGNUMERIC":
+		return isNumeric(from.Type) || from.Type == "BOOLEAN" && to.Type == "INTEGER"
+	case "BOOLEAN":
+		return from.Type == "INTEGER"
+	case "DATE", "DATETIME", "TIME", "TIMESTAMP":
+		return from.Type == "DATE" || from.Type == "DATETIME" || from.Type == "TIMESTAMP"
+	}
+	return false
+}
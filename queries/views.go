@@ -0,0 +1,286 @@
+package queries
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/danielstutzman/fake-bigquery/data"
+)
+
+// ViewSchema checks the query of a view being created as ref, returning
+// the schema of its results.
+func ViewSchema(query string, useLegacySql bool, projects map[string]data.Project,
+	ref data.TableRef) ([]data.Field, *data.Error) {
+
+	statement, err := parseView(query, useLegacySql)
+	if err != nil {
+		return nil, toDataError(err)
+	}
+	queryStatement, ok := statement.(*QueryStatement)
+	if !ok {
+		return nil, &data.Error{Reason: "invalid", Message: "The view query must be a SELECT statement."}
+	}
+	e := &executor{projects: projects, projectName: ref.ProjectId, now: time.Now().UTC()}
+	_, fields, err := e.compileView(ref, queryStatement.Query, useLegacySql, position{1, 1})
+	if err != nil {
+		return nil, toDataError(err)
+	}
+	return fields, nil
+}
+
+func parseView(query string, useLegacySql bool) (Statement, error) {
+	if useLegacySql {
+		return parseLegacyStatement(query)
+	}
+	return parseStatement(query)
+}
+
+// compileView compiles the query of the view ref, in which table names
+// resolve in the view's project and the CTEs of any referencing query
+// aren't visible, returning it with its output schema.
+func (e *executor) compileView(ref data.TableRef, query *Query, legacy bool,
+	pos position) (*compiledQuery, []data.Field, error) {
+
+	for _, expanding := range e.views {
+		if expanding == ref {
+			return nil, nil, queryError(pos, "View %s references itself", ref)
+		}
+	}
+	inner := *e
+	inner.projectName = ref.ProjectId
+	inner.views = append(append([]data.TableRef{}, e.views...), ref)
+	inner.params = nil
+	inner.script = nil
+	inner.legacy = legacy
+	inner.defaultDataset = nil
+	compiled, err := (&compiler{executor: &inner, ctes: map[string]*cte{}, lazy: true}).compileQuery(query)
+	if err != nil {
+		return nil, nil, err
+	}
+	fields, err := nameColumns(compiled.fields)
+	return compiled, fields, err
+}
+
+// viewRelation expands a view named in a FROM clause into its query.
+func (c *compiler) viewRelation(ref data.TableRef, view *data.View, alias string, pos position) (*relation, error) {
+	if view.UseLegacySql && !c.executor.legacy {
+		return nil, queryError(pos, "Cannot reference a legacy SQL view %s in a standard SQL query", ref)
+	} else if !view.UseLegacySql && c.executor.legacy {
+		return nil, queryError(pos, "Cannot reference a standard SQL view %s in a legacy SQL query", ref)
+	}
+	statement, err := parseView(view.Query, view.UseLegacySql)
+	if err != nil {
+		return nil, runtimeError("Invalid query in view %s: %s", ref, err)
+	}
+	queryStatement, ok := statement.(*QueryStatement)
+	if !ok {
+		return nil, runtimeError("Invalid query in view %s", ref)
+	}
+	query, fields, err := c.executor.compileView(ref, queryStatement.Query, view.UseLegacySql, pos)
+	if err != nil {
+		return nil, err
+	}
+	rel := &relation{rows: query.run, columns: query.columns(alias, fields)}
+	rel.ranges = []rangeVariable{{alias, 0, len(rel.columns)}}
+	return rel, nil
+}
+
+func (e *executor) executeCreateView(statement *CreateViewStatement) (*data.Result, error) {
+	ref, err := e.tableRef(statement.Target.Path)
+	if err != nil {
+		return nil, err
+	}
+	dataset, err := e.lookupDataset(ref)
+	if err != nil {
+		return nil, err
+	}
+	kind := "VIEW"
+	if statement.Materialized {
+		kind = "MATERIALIZED VIEW"
+	}
+	statementType := "CREATE_" + strings.Replace(kind, " ", "_", -1)
+
+	operation := "CREATE"
+	old, tableExists := dataset.Tables[ref.TableId]
+	if tableExists {
+		if statement.IfNotExists {
+			return ddlResult(statementType, "SKIP", &ref, nil), nil
+		} else if !statement.OrReplace || ddlKind(old) != kind {
+			return nil, &data.Error{Reason: "duplicate", Message: fmt.Sprintf("Already Exists: Table %s", ref)}
+		}
+		operation = "REPLACE"
+	}
+
+	// Defining a view only compiles its query, which reads nothing.
+	schema := *e
+	schema.scans = nil
+	_, fields, err := schema.compileView(ref, statement.Query, false, statement.Target.Pos)
+	if err != nil {
+		return nil, err
+	}
+	nowMillis := data.NowMillis()
+	table := data.Table{
+		Fields:           fields,
+		Rows:             []map[string]interface{}{},
+		CreationTime:     nowMillis,
+		LastModifiedTime: nowMillis,
+		ExpirationTime:   dataset.NewTableExpirationTime(nowMillis),
+	}
+	if !statement.Materialized {
+		table.View = &data.View{Query: statement.QueryText}
+		if err := e.applyTableOptions(&table, statement.Options); err != nil {
+			return nil, err
+		}
+		if e.dryRun {
+			return dryRunResult(statementType), nil
+		}
+		if err := e.chargeTable(ref); err != nil {
+			return nil, err
+		}
+		dataset.Tables[ref.TableId] = table
+		return ddlResult(statementType, operation, &ref, nil), nil
+	}
+
+	table.MaterializedView = &data.MaterializedView{
+		Query:             statement.QueryText,
+		EnableRefresh:     true,
+		RefreshIntervalMs: data.DEFAULT_REFRESH_INTERVAL_MS,
+	}
+	if table.Clustering, err = clustering(statement.ClusterBy, fields, statement.Pos); err != nil {
+		return nil, err
+	}
+	if err := e.applyMaterializedViewOptions(&table, statement.Options); err != nil {
+		return nil, err
+	}
+	if e.dryRun {
+		return dryRunResult(statementType), nil
+	}
+	// It's only charged for once it's been populated
+	dataset.Tables[ref.TableId] = table
+	err = e.refreshMaterializedView(ref, statement.Target.Pos)
+	if err == nil {
+		err = e.chargeTable(ref)
+	}
+	if err != nil {
+		if tableExists {
+			dataset.Tables[ref.TableId] = old
+		} else {
+			delete(dataset.Tables, ref.TableId)
+		}
+		return nil, err
+	}
+	return ddlResult(statementType, operation, &ref, nil), nil
+}
+
+func (e *executor) applyMaterializedViewOptions(table *data.Table, options []Option) error {
+	view := *table.MaterializedView
+	tableOptions := []Option{}
+	for _, option := range options {
+		switch strings.ToLower(option.Name) {
+		case "enable_refresh":
+			value, err := e.constantValue(option.X, scalarField("BOOLEAN"), option.Name)
+			if err != nil {
+				return err
+			}
+			view.EnableRefresh = value == true
+		case "refresh_interval_minutes":
+			value, err := e.constantValue(option.X, scalarField("FLOAT"), option.Name)
+			if err != nil {
+				return err
+			}
+			minutes, _ := value.(float64)
+			if minutes <= 0 {
+				return queryError(option.X.position(), "refresh_interval_minutes must be positive")
+			}
+			view.RefreshIntervalMs = int64(minutes * 60 * 1000)
+		default:
+			tableOptions = append(tableOptions, option)
+		}
+	}
+	table.MaterializedView = &view
+	return e.applyTableOptions(table, tableOptions)
+}
+
+// RefreshMaterializedView recomputes the rows of the materialized view ref.
+func RefreshMaterializedView(projects map[string]data.Project, ref data.TableRef) *data.Error {
+	e := &executor{projects: projects, projectName: ref.ProjectId, now: time.Now().UTC()}
+	if err := e.refreshMaterializedView(ref, position{1, 1}); err != nil {
+		return toDataError(err)
+	}
+	return nil
+}
+
+func (e *executor) refreshMaterializedView(ref data.TableRef, pos position) error {
+	dataset, err := e.lookupDataset(ref)
+	if err != nil {
+		return err
+	}
+	table, tableOk := dataset.Tables[ref.TableId]
+	if !tableOk {
+		return tableNotFound(ref, dataset)
+	} else if table.MaterializedView == nil {
+		return queryError(pos, "%s is not a materialized view", ref)
+	}
+	statement, err := parseStatement(table.MaterializedView.Query)
+	if err != nil {
+		return runtimeError("Invalid query in materialized view %s: %s", ref, err)
+	}
+	queryStatement, ok := statement.(*QueryStatement)
+	if !ok {
+		return runtimeError("Invalid query in materialized view %s", ref)
+	}
+	query, fields, err := e.compileView(ref, queryStatement.Query, false, pos)
+	if err != nil || e.dryRun {
+		return err
+	}
+	tuples, err := query.run(&evalContext{})
+	if err != nil {
+		return err
+	}
+	rows := []map[string]interface{}{}
+	for _, tuple := range tuples {
+		row, err := storeRow(fields, tuple)
+		if err != nil {
+			return err
+		}
+		rows = append(rows, row)
+	}
+
+	nowMillis := data.NowMillis()
+	view := *table.MaterializedView
+	view.LastRefreshTime = nowMillis
+	table.MaterializedView = &view
+	table.Fields = fields
+	table.Rows = rows
+	table.LastModifiedTime = nowMillis
+	dataset.Tables[ref.TableId] = table
+	return nil
+}
+
+// executeCall runs CALL BQ.REFRESH_MATERIALIZED_VIEW('dataset.view'), the
+// only system procedure there is.
+func (e *executor) executeCall(statement *CallStatement) (*data.Result, error) {
+	name := strings.Join(statement.Procedure, ".")
+	if strings.ToUpper(name) == "BQ.ABORT_SESSION" {
+		return e.abortSession(statement)
+	} else if strings.ToUpper(name) != "BQ.REFRESH_MATERIALIZED_VIEW" {
+		return nil, queryError(statement.Pos, "Procedure not found: %s", name)
+	}
+	if len(statement.Args) != 1 {
+		return nil, queryError(statement.Pos, "%s expects 1 argument but got %d", name, len(statement.Args))
+	}
+	value, err := e.constantValue(statement.Args[0], scalarField("STRING"), "view_name")
+	if err != nil {
+		return nil, err
+	}
+	viewName, _ := value.(string)
+	ref, err := e.tableRef(strings.Split(strings.Replace(viewName, "`", "", -1), "."))
+	if err != nil {
+		return nil, err
+	}
+	if err := e.refreshMaterializedView(ref, statement.Args[0].position()); err != nil {
+		return nil, err
+	}
+	return &data.Result{StatementType: "SCRIPT", Fields: []data.Field{}, Rows: []map[string]interface{}{}}, nil
+}
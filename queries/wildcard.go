@@ -0,0 +1,123 @@
+package queries
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/danielstutzman/fake-bigquery/data"
+)
+
+// wildcardRelation reads every table whose name matches one like
+// events_*, with the columns of all of them, led by those of the most
+// recently created, plus a _TABLE_SUFFIX pseudo-column holding the part of
+// each name the * matched. A table without some column reads it as NULL.
+func (c *compiler) wildcardRelation(item *TableName) (*relation, error) {
+	ref, err := c.executor.tableRef(item.Path)
+	if err != nil {
+		return nil, err
+	}
+	dataset, err := c.executor.lookupDataset(ref)
+	if err != nil {
+		return nil, err
+	}
+	prefix := strings.TrimSuffix(ref.TableId, "*")
+	tableIds := []string{}
+	for tableId := range dataset.Tables {
+		if strings.HasPrefix(tableId, prefix) {
+			tableIds = append(tableIds, tableId)
+		}
+	}
+	if len(tableIds) == 0 {
+		return nil, tableNotFound(ref, dataset)
+	}
+	sort.Strings(tableIds)
+
+	newest := dataset.Tables[tableIds[0]]
+	for _, tableId := range tableIds {
+		table := dataset.Tables[tableId]
+		if table.View != nil || table.MaterializedView != nil {
+			return nil, queryError(item.Pos, "Views cannot be queried through prefix. First view %s.",
+				data.TableRef{ProjectId: ref.ProjectId, DatasetId: ref.DatasetId, TableId: tableId})
+		}
+		if table.CreationTime >= newest.CreationTime {
+			newest = table
+		}
+	}
+	fields := normalizeFields(newest.Fields)
+	for _, tableId := range tableIds {
+		for _, tableField := range normalizeFields(dataset.Tables[tableId].Fields) {
+			if fieldIndex(fields, tableField.Name) == -1 {
+				fields = append(fields, tableField)
+			}
+		}
+	}
+	tables := []data.Table{}
+	for _, tableId := range tableIds {
+		tables = append(tables, dataset.Tables[tableId])
+		tableFields := normalizeFields(dataset.Tables[tableId].Fields)
+		for _, field := range fields {
+			for _, tableField := range tableFields {
+				if strings.EqualFold(tableField.Name, field.Name) && !sameType(tableField, field) {
+					return nil, queryError(item.Pos, "Cannot read field '%s' of type %s as %s in table %s",
+						field.Name, typeName(tableField), typeName(field),
+						data.TableRef{ProjectId: ref.ProjectId, DatasetId: ref.DatasetId, TableId: tableId})
+				}
+			}
+		}
+	}
+
+	alias := item.Alias
+	if alias == "" {
+		alias = ref.TableId
+	}
+	rel := &relation{}
+	for _, field := range fields {
+		rel.columns = append(rel.columns, column{qualifier: alias, name: field.Name, field: field})
+	}
+	rel.columns = append(rel.columns, column{qualifier: alias, name: "_TABLE_SUFFIX",
+		field: scalarField("STRING"), hidden: true})
+	rel.ranges = []rangeVariable{{alias, 0, len(rel.columns)}}
+
+	// Filters on _TABLE_SUFFIX prune whole tables, like partitions, and the
+	// tables they prune aren't referenced
+	if scan := c.executor.newScan(fields, rel.columns); scan != nil {
+		scan.partitioning["_table_suffix"] = true
+		for i, tableId := range tableIds {
+			suffix := strings.TrimPrefix(tableId, prefix)
+			for _, row := range wildcardRows(fields, tables[i]) {
+				scan.rows = append(scan.rows, append(row, suffix))
+				scan.partitions = append(scan.partitions, tableId)
+			}
+			scan.wildcardTables = append(scan.wildcardTables, wildcardTable{suffix: suffix,
+				ref: data.TableRef{ProjectId: ref.ProjectId, DatasetId: ref.DatasetId, TableId: tableId}})
+		}
+	} else {
+		for _, tableId := range tableIds {
+			c.executor.referenceTable(data.TableRef{ProjectId: ref.ProjectId, DatasetId: ref.DatasetId, TableId: tableId})
+		}
+	}
+	rel.rows = func(ctx *evalContext) ([][]interface{}, error) {
+		rows := [][]interface{}{}
+		for i, tableId := range tableIds {
+			suffix := strings.TrimPrefix(tableId, prefix)
+			for _, row := range wildcardRows(fields, tables[i]) {
+				rows = append(rows, append(row, suffix))
+			}
+		}
+		return rows, nil
+	}
+	return rel, nil
+}
+
+// wildcardRows gives a table's rows with the columns of fields, which
+// name them in the case of the newest table, with NULL for any it lacks.
+func wildcardRows(fields []data.Field, table data.Table) [][]interface{} {
+	tableFields := make([]data.Field, len(fields))
+	for i, field := range fields {
+		tableFields[i] = field
+		if j := fieldIndex(table.Fields, field.Name); j != -1 {
+			tableFields[i].Name = table.Fields[j].Name
+		}
+	}
+	return tableRows(tableFields, table.Rows)
+}
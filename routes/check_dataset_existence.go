@@ -1,7 +1,9 @@
 package routes
 
 import (
+	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
 
 	"github.com/danielstutzman/fake-bigquery/data"
@@ -16,35 +18,15 @@ func (app *App) checkDatasetExistence(w http.ResponseWriter, r *http.Request, pr
 		app.projects[projectName] = project
 	}
 
-	_, datasetExists := project.Datasets[datasetName]
+	dataset, datasetExists := project.Datasets[datasetName]
 	if datasetExists {
-		fmt.Fprintf(w, `{
-			"kind": "bigquery#dataset",
-			"etag": "\"cX5UmbB_R-S07ii743IKGH9YCYM/MTUxMTExNTg0MDU2Ng\"",
-			"id": "%s:%s",
-			"selfLink": "https://www.googleapis.com/bigquery/v2/projects/%s/datasets/%s",
-			"datasetReference": {
-				"projectId": "%s",
-				"datasetId": "%s"
-			}
-		}`, projectName, datasetName, projectName, datasetName, projectName, datasetName)
-	} else {
-		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
-		w.Header().Set("X-Content-Type-Options", "nosniff")
-		w.WriteHeader(http.StatusNotFound)
-		fmt.Fprintf(w, `{
-			"error": {
-				"errors": [
-					{
-						"domain": "global",
-						"reason": "notFound",
-						"message": "Not found: Dataset %s:%s"
-					}
-				],
-				"code": 404,
-				"message": "Not found: Dataset %s:%s"
-			}
+		outputJson, err := json.Marshal(datasetResource(projectName, datasetName, dataset))
+		if err != nil {
+			log.Fatalf("Error from Marshal: %v", err)
 		}
-`, projectName, datasetName, projectName, datasetName)
+		w.Write(outputJson)
+	} else {
+		writeError(w, http.StatusNotFound, "notFound",
+			fmt.Sprintf("Not found: Dataset %s:%s", projectName, datasetName))
 	}
 }
@@ -0,0 +1,75 @@
+package routes
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/danielstutzman/fake-bigquery/data"
+)
+
+type CopyConfiguration struct {
+	SourceTable               *TableReference  `json:"sourceTable"`
+	SourceTables              []TableReference `json:"sourceTables"`
+	DestinationTable          TableReference   `json:"destinationTable"`
+	CreateDisposition         string           `json:"createDisposition"`
+	WriteDisposition          string           `json:"writeDisposition"`
+	OperationType             string           `json:"operationType"` // COPY, SNAPSHOT, RESTORE, CLONE
+	DestinationExpirationTime string           `json:"destinationExpirationTime"`
+}
+
+// runCopyJob copies the job's source tables into its destination table.
+func (app *App) runCopyJob(job *Job, config CopyConfiguration) *ErrorProto {
+	references := config.SourceTables
+	if config.SourceTable != nil {
+		references = append([]TableReference{*config.SourceTable}, references...)
+	}
+	sources := []data.TableRef{}
+	var inputBytes int64
+	for _, reference := range references {
+		source := tableRef(reference, job.ProjectId)
+		sources = append(sources, source)
+		if table, tableOk := app.projects[source.ProjectId].Datasets[source.DatasetId].Tables[source.TableId]; tableOk {
+			inputBytes += data.TableBytes(table)
+		}
+	}
+
+	options := data.CopyOptions{
+		OperationType:     strings.ToUpper(config.OperationType),
+		CreateDisposition: config.CreateDisposition,
+		WriteDisposition:  config.WriteDisposition,
+		CreateTable:       app.chargeCreatedTable,
+	}
+	if config.DestinationExpirationTime != "" {
+		expirationTime, err := time.Parse(time.RFC3339Nano, config.DestinationExpirationTime)
+		if err != nil {
+			return newJobError("invalid", "Invalid value for destinationExpirationTime: %s",
+				config.DestinationExpirationTime)
+		}
+		options.ExpirationTime = expirationTime.UnixNano() / int64(time.Millisecond)
+	}
+
+	numCopied, err := data.CopyTables(app.projects,
+		sources, tableRef(config.DestinationTable, job.ProjectId), options)
+	if err != nil {
+		return newJobError(err.Reason, "%s", err.Message)
+	}
+
+	job.Statistics["copy"] = map[string]string{
+		"copiedRows":         fmt.Sprintf("%d", numCopied),
+		"copiedLogicalBytes": fmt.Sprintf("%d", inputBytes),
+	}
+	return nil
+}
+
+func tableRef(reference TableReference, defaultProjectId string) data.TableRef {
+	projectId := reference.ProjectId
+	if projectId == "" {
+		projectId = defaultProjectId
+	}
+	return data.TableRef{
+		ProjectId: projectId,
+		DatasetId: reference.DatasetId,
+		TableId:   reference.TableId,
+	}
+}
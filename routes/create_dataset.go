@@ -2,14 +2,23 @@ package routes
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 
 	"github.com/danielstutzman/fake-bigquery/data"
 )
 
 type CreateDatasetRequest struct {
-	DatasetReference DatasetReference `json:"datasetReference"`
+	DatasetReference             DatasetReference   `json:"datasetReference"`
+	Location                     string             `json:"location"`
+	Description                  string             `json:"description"`
+	FriendlyName                 string             `json:"friendlyName"`
+	Labels                       map[string]string  `json:"labels"`
+	DefaultTableExpirationMs     string             `json:"defaultTableExpirationMs"`
+	DefaultPartitionExpirationMs string             `json:"defaultPartitionExpirationMs"`
+	Access                       []data.AccessEntry `json:"access"`
 }
 
 type DatasetReference struct {
@@ -22,13 +31,16 @@ func (app *App) createDataset(w http.ResponseWriter, r *http.Request, projectNam
 	var body CreateDatasetRequest
 	err := decoder.Decode(&body)
 	if err != nil {
-		panic(err)
+		writeError(w, http.StatusBadRequest, "invalid", fmt.Sprintf("Invalid JSON payload: %s", err))
+		return
 	}
 	defer r.Body.Close()
 
-	projectName2 := body.DatasetReference.ProjectId
-	if projectName2 != projectName {
-		log.Fatalf("Expected project name to match")
+	if body.DatasetReference.ProjectId != projectName {
+		writeError(w, http.StatusBadRequest, "invalid",
+			fmt.Sprintf("Dataset reference project %s does not match the project %s in the request path",
+				body.DatasetReference.ProjectId, projectName))
+		return
 	}
 	datasetName := body.DatasetReference.DatasetId
 
@@ -40,12 +52,55 @@ func (app *App) createDataset(w http.ResponseWriter, r *http.Request, projectNam
 		app.projects[projectName] = project
 	}
 
-	project.Datasets[datasetName] = data.Dataset{
-		Tables: map[string]data.Table{},
+	if _, datasetExists := project.Datasets[datasetName]; datasetExists {
+		writeError(w, http.StatusConflict, "duplicate",
+			fmt.Sprintf("Already Exists: Dataset %s:%s", projectName, datasetName))
+		return
 	}
 
-	// Just serve the input as output
-	outputJson, err := json.Marshal(body)
+	var defaultTableExpirationMs, defaultPartitionExpirationMs int64
+	if body.DefaultTableExpirationMs != "" {
+		defaultTableExpirationMs, err = strconv.ParseInt(body.DefaultTableExpirationMs, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid",
+				fmt.Sprintf("Invalid value for defaultTableExpirationMs: %s", body.DefaultTableExpirationMs))
+			return
+		}
+	}
+	if body.DefaultPartitionExpirationMs != "" {
+		defaultPartitionExpirationMs, err = strconv.ParseInt(body.DefaultPartitionExpirationMs, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid",
+				fmt.Sprintf("Invalid value for defaultPartitionExpirationMs: %s", body.DefaultPartitionExpirationMs))
+			return
+		}
+	}
+
+	location := body.Location
+	if location == "" {
+		location = "US"
+	}
+	access := body.Access
+	if len(access) == 0 {
+		access = data.DEFAULT_ACCESS
+	}
+
+	nowMillis := data.NowMillis()
+	dataset := data.Dataset{
+		Tables:                       map[string]data.Table{},
+		Location:                     location,
+		Description:                  body.Description,
+		FriendlyName:                 body.FriendlyName,
+		Labels:                       body.Labels,
+		DefaultTableExpirationMs:     defaultTableExpirationMs,
+		DefaultPartitionExpirationMs: defaultPartitionExpirationMs,
+		Access:                       access,
+		CreationTime:                 nowMillis,
+		LastModifiedTime:             nowMillis,
+	}
+	project.Datasets[datasetName] = dataset
+
+	outputJson, err := json.Marshal(datasetResource(projectName, datasetName, dataset))
 	if err != nil {
 		log.Fatalf("Error from Marshal: %v", err)
 	}
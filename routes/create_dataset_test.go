@@ -0,0 +1,60 @@
+package routes
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestDatasetProperties(t *testing.T) {
+	app := NewApp(nil, Options{})
+	w := serve(app, "POST", "/bigquery/v2/projects/p/datasets", `{
+		"datasetReference": {"projectId": "p", "datasetId": "d"},
+		"location": "EU",
+		"description": "about d",
+		"friendlyName": "D",
+		"labels": {"team": "x"},
+		"defaultTableExpirationMs": "3600000",
+		"defaultPartitionExpirationMs": "86400000"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("creating the dataset gave %d %s", w.Code, w.Body.String())
+	}
+	created := decode(t, w)
+
+	got := decode(t, serve(app, "GET", "/bigquery/v2/projects/p/datasets/d", ""))
+	for key, want := range map[string]interface{}{
+		"location":                     "EU",
+		"description":                  "about d",
+		"friendlyName":                 "D",
+		"labels":                       map[string]interface{}{"team": "x"},
+		"defaultTableExpirationMs":     "3600000",
+		"defaultPartitionExpirationMs": "86400000",
+		"etag":                         created["etag"],
+	} {
+		if !reflect.DeepEqual(got[key], want) {
+			t.Errorf("%s is %#v, want %#v", key, got[key], want)
+		}
+	}
+
+	// A new table expires after the dataset's default
+	serve(app, "POST", "/bigquery/v2/projects/p/datasets/d/tables",
+		`{"tableReference": {"projectId": "p", "datasetId": "d", "tableId": "t"}}`)
+	table := app.projects["p"].Datasets["d"].Tables["t"]
+	if table.ExpirationTime != table.CreationTime+3600000 {
+		t.Errorf("table expires at %d, want an hour after %d", table.ExpirationTime, table.CreationTime)
+	}
+
+	for _, body := range []string{
+		`{"datasetReference": {"projectId": "q", "datasetId": "e"}}`,
+		`{"datasetReference": `,
+		`{"datasetReference": {"projectId": "p", "datasetId": "e"}, "defaultTableExpirationMs": "soon"}`,
+	} {
+		if w := serve(app, "POST", "/bigquery/v2/projects/p/datasets", body); w.Code != http.StatusBadRequest {
+			t.Errorf("creating %s gave %d %s, want 400", body, w.Code, w.Body.String())
+		}
+	}
+	if w := serve(app, "POST", "/bigquery/v2/projects/p/datasets",
+		`{"datasetReference": {"projectId": "p", "datasetId": "d"}}`); w.Code != http.StatusConflict {
+		t.Errorf("creating d again gave %d, want 409", w.Code)
+	}
+}
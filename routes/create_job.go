@@ -3,9 +3,11 @@ package routes
 import (
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"log"
 	"net/http"
 
-	"github.com/danielstutzman/fake-bigquery/queries"
+	"github.com/danielstutzman/fake-bigquery/data"
 )
 
 type CreateJobRequest struct {
@@ -14,67 +16,129 @@ type CreateJobRequest struct {
 }
 
 type Configuration struct {
-	Query1 Query1 `json:"query"`
+	Query1  Query1                `json:"query"`
+	Load    *LoadConfiguration    `json:"load"`
+	Extract *ExtractConfiguration `json:"extract"`
+	Copy    *CopyConfiguration    `json:"copy"`
+	DryRun  bool                  `json:"dryRun"`
 }
 
 type Query1 struct {
-	Query2 string `json:"query"`
+	Query2             string                `json:"query"`
+	DestinationTable   *TableReference       `json:"destinationTable"`
+	CreateDisposition  string                `json:"createDisposition"`
+	WriteDisposition   string                `json:"writeDisposition"`
+	AllowLargeResults  bool                  `json:"allowLargeResults"`
+	UseLegacySql       *bool                 `json:"useLegacySql"` // legacy SQL when missing
+	DefaultDataset     *DatasetReference     `json:"defaultDataset"`
+	ParameterMode      string                `json:"parameterMode"`
+	QueryParameters    []data.QueryParameter `json:"queryParameters"`
+	MaximumBytesBilled string                `json:"maximumBytesBilled"`
+	CreateSession      bool                  `json:"createSession"`
+	// session_id names the session to run the query in
+	ConnectionProperties []ConnectionProperty `json:"connectionProperties"`
 }
 
 type JobReference struct {
 	ProjectId string `json:"projectId"`
 	JobId     string `json:"jobId"`
+	Location  string `json:"location"`
 }
 
 func (app *App) createJob(w http.ResponseWriter, r *http.Request, projectName string) {
-	decoder := json.NewDecoder(r.Body)
-	var body CreateJobRequest
-	err := decoder.Decode(&body)
+	bodyJson, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		panic(err)
+		writeError(w, http.StatusBadRequest, "invalid", fmt.Sprintf("Error reading request body: %s", err))
+		return
 	}
 	defer r.Body.Close()
 
-	query := body.Configuration.Query1.Query2
-	result := queries.ExecuteQuery(query, app.projects, projectName)
+	// A query job counts against MaxConcurrentQueries while it waits its
+	// turn, as well as while it runs.
+	var body CreateJobRequest
+	if err := json.Unmarshal(bodyJson, &body); err == nil && isQueryJob(body.Configuration) {
+		if err := app.startQuery(projectName); err != nil {
+			writeError(w, http.StatusForbidden, err.Reason, err.Message)
+			return
+		}
+		defer app.finishQuery(projectName)
+	}
+	app.lock()
+	defer app.mutex.Unlock()
+	app.insertJob(w, projectName, bodyJson, nil)
+}
+
+func isQueryJob(configuration Configuration) bool {
+	return !configuration.DryRun && configuration.Load == nil && configuration.Extract == nil &&
+		configuration.Copy == nil
+}
+
+// insertJob runs the job described by bodyJson to completion and serves
+// the finished job. media is the uploaded data for a load job, if any.
+func (app *App) insertJob(w http.ResponseWriter, projectName string, bodyJson []byte, media []byte) {
+	var body CreateJobRequest
+	err := json.Unmarshal(bodyJson, &body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid", fmt.Sprintf("Invalid JSON payload: %s", err))
+		return
+	}
+	var rawBody struct {
+		Configuration map[string]interface{} `json:"configuration"`
+	}
+	if err := json.Unmarshal(bodyJson, &rawBody); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid", fmt.Sprintf("Invalid JSON payload: %s", err))
+		return
+	}
 
 	jobId := body.JobReference.JobId
-	app.queryResultByJobId[jobId] = *result
-
-	email := "a@b.com"
-	fmt.Fprintf(w, `{
-		"kind": "bigquery#job",
-		"etag": "\"cX5UmbB_R-S07ii743IKGH9YCYM/_oiKSu1NLem_L8Icwp_IYkfy3vg\"",
-		"id": "%s:%s",
-		"selfLink": "https://www.googleapis.com/bigquery/v2/projects/%s/jobs/%s",
-		"jobReference": {
-		 "projectId": "%s",
-		 "jobId": "%s"
-		},
-		"configuration": {
-		 "query": {
-			"query": "%s",
-			"destinationTable": {
-			 "projectId": "%s",
-			 "datasetId": "_2cf7cfaa9c05dd2381014b72df999b53fd45fe85",
-			 "tableId": "anon5fb7e0264db7f54e07e3df0833fbfcfd11d63e03"
-			},
-			"createDisposition": "CREATE_IF_NEEDED",
-			"writeDisposition": "WRITE_TRUNCATE"
-		 }
-		},
-		"status": {
-		 "state": "DONE"
-		},
-		"statistics": {
-		 "creationTime": "1511049825816",
-		 "startTime": "1511049826072"
-		},
-		"user_email": "%s"
-	 }`, projectName, jobId,
-		projectName, jobId,
-		projectName, jobId,
-		query,
-		projectName,
-		email)
+	if jobId == "" {
+		jobId = newJobId()
+	}
+	if _, jobExists := app.jobs[jobKey(projectName, jobId)]; jobExists && !body.Configuration.DryRun {
+		writeError(w, http.StatusConflict, "duplicate",
+			fmt.Sprintf("Already Exists: Job %s:%s", projectName, jobId))
+		return
+	}
+	location := body.JobReference.Location
+	if location == "" {
+		location = "US"
+	}
+
+	nowMillis := data.NowMillis()
+	job := &Job{
+		ProjectId:     projectName,
+		JobId:         jobId,
+		Location:      location,
+		Configuration: rawBody.Configuration,
+		State:         "RUNNING",
+		CreationTime:  nowMillis,
+		StartTime:     nowMillis,
+		Statistics:    map[string]interface{}{},
+		UserEmail:     "a@b.com",
+	}
+
+	if body.Configuration.DryRun {
+		app.dryRunJob(w, job, body.Configuration)
+		return
+	}
+
+	if body.Configuration.Load != nil {
+		job.ErrorResult = app.runLoadJob(job, *body.Configuration.Load, media)
+	} else if body.Configuration.Extract != nil {
+		job.ErrorResult = app.runExtractJob(job, *body.Configuration.Extract)
+	} else if body.Configuration.Copy != nil {
+		job.ErrorResult = app.runCopyJob(job, *body.Configuration.Copy)
+	} else {
+		job.ErrorResult = app.runQueryJob(job, body.Configuration.Query1)
+	}
+
+	job.State = "DONE"
+	job.EndTime = data.NowMillis()
+	app.jobs[jobKey(projectName, jobId)] = job
+
+	outputJson, err := json.Marshal(jobResource(job))
+	if err != nil {
+		log.Fatalf("Error from Marshal: %v", err)
+	}
+	w.Write(outputJson)
 }
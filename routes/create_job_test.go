@@ -0,0 +1,20 @@
+package routes
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestCreateJobErrors(t *testing.T) {
+	app := NewApp(nil, Options{})
+	for _, body := range []string{
+		`{"configuration": `,
+		`{"configuration": []}`,
+		`{"configuration": {"query": {"query": 1}}}`,
+	} {
+		w := serve(app, "POST", "/bigquery/v2/projects/p/jobs", body)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("inserting %s gave %d %s, want 400", body, w.Code, w.Body.String())
+		}
+	}
+}
@@ -2,15 +2,69 @@ package routes
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 
 	"github.com/danielstutzman/fake-bigquery/data"
+	"github.com/danielstutzman/fake-bigquery/queries"
 )
 
 type CreateTableRequest struct {
-	TableReference TableReference `json:"tableReference"`
-	Schema         Schema         `json:"schema"`
+	TableReference         TableReference              `json:"tableReference"`
+	Schema                 Schema                      `json:"schema"`
+	Description            string                      `json:"description"`
+	Labels                 map[string]string           `json:"labels"`
+	ExpirationTime         string                      `json:"expirationTime"`
+	TimePartitioning       *data.TimePartitioning      `json:"timePartitioning"`
+	RangePartitioning      *data.RangePartitioning     `json:"rangePartitioning"`
+	RequirePartitionFilter bool                        `json:"requirePartitionFilter"`
+	Clustering             *data.Clustering            `json:"clustering"`
+	View                   *ViewDefinition             `json:"view"`
+	MaterializedView       *MaterializedViewDefinition `json:"materializedView"`
+}
+
+// ViewDefinition is the view property of a table resource. BigQuery
+// assumes legacy SQL when useLegacySql is missing.
+type ViewDefinition struct {
+	Query        string `json:"query"`
+	UseLegacySql *bool  `json:"useLegacySql"`
+}
+
+type MaterializedViewDefinition struct {
+	Query             string `json:"query"`
+	EnableRefresh     *bool  `json:"enableRefresh"`
+	RefreshIntervalMs string `json:"refreshIntervalMs"`
+}
+
+// apply sets the refresh settings of an existing or new materialized view.
+func (definition MaterializedViewDefinition) apply(view *data.MaterializedView) error {
+	if definition.EnableRefresh != nil {
+		view.EnableRefresh = *definition.EnableRefresh
+	}
+	if definition.RefreshIntervalMs != "" {
+		refreshIntervalMs, err := strconv.ParseInt(definition.RefreshIntervalMs, 10, 64)
+		if err != nil || refreshIntervalMs <= 0 {
+			return fmt.Errorf("Invalid value for refreshIntervalMs: %s", definition.RefreshIntervalMs)
+		}
+		view.RefreshIntervalMs = refreshIntervalMs
+	}
+	return nil
+}
+
+// newView checks a view's definition, returning it with the schema of
+// its results.
+func (app *App) newView(definition ViewDefinition, ref data.TableRef) (*data.View, []data.Field, *data.Error) {
+	view := &data.View{Query: definition.Query, UseLegacySql: true}
+	if definition.UseLegacySql != nil {
+		view.UseLegacySql = *definition.UseLegacySql
+	}
+	fields, err := queries.ViewSchema(view.Query, view.UseLegacySql, app.projects, ref)
+	if err != nil {
+		return nil, nil, err
+	}
+	return view, fields, nil
 }
 
 type TableReference struct {
@@ -28,17 +82,16 @@ func (app *App) createTable(w http.ResponseWriter, r *http.Request, projectName,
 	var body CreateTableRequest
 	err := decoder.Decode(&body)
 	if err != nil {
-		panic(err)
+		writeError(w, http.StatusBadRequest, "invalid", fmt.Sprintf("Invalid JSON payload: %s", err))
+		return
 	}
 	defer r.Body.Close()
 
-	projectName2 := body.TableReference.ProjectId
-	if projectName2 != projectName {
-		log.Fatalf("Expected project name to match")
-	}
-	datasetName2 := body.TableReference.DatasetId
-	if datasetName2 != datasetName {
-		log.Fatalf("Expected dataset name to match")
+	if body.TableReference.ProjectId != projectName || body.TableReference.DatasetId != datasetName {
+		writeError(w, http.StatusBadRequest, "invalid",
+			fmt.Sprintf("Table reference %s:%s does not match the dataset %s:%s in the request path",
+				body.TableReference.ProjectId, body.TableReference.DatasetId, projectName, datasetName))
+		return
 	}
 	tableName := body.TableReference.TableId
 
@@ -52,18 +105,109 @@ func (app *App) createTable(w http.ResponseWriter, r *http.Request, projectName,
 
 	dataset, datasetOk := project.Datasets[datasetName]
 	if !datasetOk {
-		log.Fatalf("Dataset doesn't exist: %s", datasetName)
+		writeError(w, http.StatusNotFound, "notFound",
+			fmt.Sprintf("Not found: Dataset %s:%s", projectName, datasetName))
+		return
+	}
+
+	if _, tableExists := dataset.Tables[tableName]; tableExists {
+		writeError(w, http.StatusConflict, "duplicate",
+			fmt.Sprintf("Already Exists: Table %s:%s.%s", projectName, datasetName, tableName))
+		return
+	}
+
+	var expirationTime int64
+	if body.ExpirationTime != "" {
+		expirationTime, err = strconv.ParseInt(body.ExpirationTime, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid",
+				fmt.Sprintf("Invalid value for expirationTime: %s", body.ExpirationTime))
+			return
+		}
+	}
+
+	if expirationTime == 0 {
+		expirationTime = dataset.NewTableExpirationTime(data.NowMillis())
+	}
+	if body.TimePartitioning != nil && body.TimePartitioning.ExpirationMs == "" &&
+		dataset.DefaultPartitionExpirationMs != 0 {
+		body.TimePartitioning.ExpirationMs =
+			fmt.Sprintf("%d", dataset.DefaultPartitionExpirationMs)
 	}
 
 	fieldsCopy := make([]data.Field, len(body.Schema.Fields))
 	copy(fieldsCopy, body.Schema.Fields)
-	dataset.Tables[tableName] = data.Table{
-		Fields: fieldsCopy,
-		Rows:   []map[string]interface{}{},
+	var view *data.View
+	if body.View != nil {
+		var viewErr *data.Error
+		view, fieldsCopy, viewErr = app.newView(*body.View,
+			data.TableRef{ProjectId: projectName, DatasetId: datasetName, TableId: tableName})
+		if viewErr != nil {
+			writeError(w, http.StatusBadRequest, viewErr.Reason, viewErr.Message)
+			return
+		}
+	}
+	var materializedView *data.MaterializedView
+	if body.MaterializedView != nil {
+		materializedView = &data.MaterializedView{
+			Query:             body.MaterializedView.Query,
+			EnableRefresh:     true,
+			RefreshIntervalMs: data.DEFAULT_REFRESH_INTERVAL_MS,
+		}
+		if err := body.MaterializedView.apply(materializedView); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid", err.Error())
+			return
+		}
+	}
+
+	nowMillis := data.NowMillis()
+	table := data.Table{
+		Fields:                 fieldsCopy,
+		Rows:                   []map[string]interface{}{},
+		Description:            body.Description,
+		Labels:                 body.Labels,
+		ExpirationTime:         expirationTime,
+		CreationTime:           nowMillis,
+		LastModifiedTime:       nowMillis,
+		TimePartitioning:       body.TimePartitioning,
+		RangePartitioning:      body.RangePartitioning,
+		RequirePartitionFilter: body.RequirePartitionFilter,
+		Clustering:             body.Clustering,
+		View:                   view,
+		MaterializedView:       materializedView,
+	}
+	if materializedView == nil {
+		if err := table.CheckPartitioning(); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid", err.Error())
+			return
+		}
+		if table.Clustering != nil {
+			if err := table.Clustering.Check(table.Fields); err != nil {
+				writeError(w, http.StatusBadRequest, "invalid", err.Error())
+				return
+			}
+		}
+	}
+	dataset.Tables[tableName] = table
+
+	// Materialized views are populated as soon as they're created, so are
+	// only charged for once that's worked
+	if materializedView != nil {
+		ref := data.TableRef{ProjectId: projectName, DatasetId: datasetName, TableId: tableName}
+		if err := queries.RefreshMaterializedView(app.projects, ref); err != nil {
+			delete(dataset.Tables, tableName)
+			writeError(w, http.StatusBadRequest, err.Reason, err.Message)
+			return
+		}
+		table = dataset.Tables[tableName]
+	}
+	if err := app.useCreatedTable(projectName); err != nil {
+		delete(dataset.Tables, tableName)
+		writeError(w, http.StatusForbidden, err.Reason, err.Message)
+		return
 	}
 
-	// Just serve the input as output
-	outputJson, err := json.Marshal(body)
+	outputJson, err := json.Marshal(tableResource(projectName, datasetName, tableName, table))
 	if err != nil {
 		log.Fatalf("Error from Marshal: %v", err)
 	}
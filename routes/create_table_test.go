@@ -0,0 +1,71 @@
+package routes
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestCreateTableErrors(t *testing.T) {
+	app := NewApp(nil, Options{})
+	serve(app, "POST", "/bigquery/v2/projects/p/datasets", `{"datasetReference": {"projectId": "p", "datasetId": "d"}}`)
+	for _, body := range []string{
+		`{"tableReference": {"projectId": "p", "datasetId": "other", "tableId": "t"}}`,
+		`{"tableReference": {"projectId": "q", "datasetId": "d", "tableId": "t"}}`,
+		`{"tableReference": `,
+	} {
+		w := serve(app, "POST", "/bigquery/v2/projects/p/datasets/d/tables", body)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("creating %s gave %d %s, want 400", body, w.Code, w.Body.String())
+		}
+	}
+	if tables := app.projects["p"].Datasets["d"].Tables; len(tables) != 0 {
+		t.Errorf("dataset holds %d tables, want none", len(tables))
+	}
+}
+
+func TestViews(t *testing.T) {
+	app := testApp(Options{})
+	w := serve(app, "POST", "/bigquery/v2/projects/p/datasets/d/tables", `{
+		"tableReference": {"projectId": "p", "datasetId": "d", "tableId": "v"},
+		"view": {"query": "SELECT a FROM d.t WHERE a > 1", "useLegacySql": false}}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("creating the view gave %d %s", w.Code, w.Body.String())
+	}
+
+	table := decode(t, serve(app, "GET", "/bigquery/v2/projects/p/datasets/d/tables/v", ""))
+	if table["type"] != "VIEW" {
+		t.Errorf("tables.get gave type %v, want VIEW", table["type"])
+	}
+	if view, _ := table["view"].(map[string]interface{}); view["query"] != "SELECT a FROM d.t WHERE a > 1" {
+		t.Errorf("tables.get gave view %v", table["view"])
+	}
+	list := decode(t, serve(app, "GET", "/bigquery/v2/projects/p/datasets/d/tables", ""))
+	for _, table := range list["tables"].([]interface{}) {
+		table := table.(map[string]interface{})
+		want := "TABLE"
+		if table["tableReference"].(map[string]interface{})["tableId"] == "v" {
+			want = "VIEW"
+		}
+		if table["type"] != want {
+			t.Errorf("tables.list gave %v, want type %s", table, want)
+		}
+	}
+
+	// Queries see rows added to the view's table after it was created
+	serve(app, "POST", "/bigquery/v2/projects/p/datasets/d/tables/t/insertAll", `{"rows": [{"json": {"a": 4}}]}`)
+	body := decode(t, serve(app, "POST", "/bigquery/v2/projects/p/jobs", queryJob("SELECT SUM(a) AS s FROM d.v")))
+	if reason := errorReason(t, body); reason != "" {
+		t.Fatalf("querying the view failed with %s", reason)
+	}
+	jobId := body["jobReference"].(map[string]interface{})["jobId"].(string)
+	if result := app.queryResultByJobId[jobKey("p", jobId)]; len(result.Rows) != 1 || result.Rows[0]["s"] != int64(9) {
+		t.Errorf("querying the view gave %v, want a sum of 9", result.Rows)
+	}
+
+	w = serve(app, "POST", "/bigquery/v2/projects/p/datasets/d/tables", `{
+		"tableReference": {"projectId": "p", "datasetId": "d", "tableId": "bad"},
+		"view": {"query": "SELECT nope FROM d.t", "useLegacySql": false}}`)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("creating a view with an invalid query gave %d, want 400", w.Code)
+	}
+}
@@ -0,0 +1,50 @@
+package routes
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"time"
+)
+
+// recentInserts remembers the insertIds streamed into a table within the
+// dedup window, in the order they came so the oldest are forgotten first.
+type recentInserts struct {
+	seenAt map[string]time.Time
+	order  []string
+}
+
+// isDuplicateInsert reports whether a streamed row should be dropped because
+// a row with the same insertId reached the same table within the dedup
+// window. Like BigQuery's, the dedup is only best-effort: with a nonzero
+// DedupLeakRate, that fraction of duplicates is let through anyway.
+func (app *App) isDuplicateInsert(projectName, datasetName, tableName, insertId string, now time.Time) bool {
+	if insertId == "" || app.options.DedupWindow <= 0 {
+		return false
+	}
+
+	tableKey := fmt.Sprintf("%s:%s.%s", projectName, datasetName, tableName)
+	recent, ok := app.recentInsertIds[tableKey]
+	if !ok {
+		recent = &recentInserts{seenAt: map[string]time.Time{}}
+		app.recentInsertIds[tableKey] = recent
+	}
+
+	for len(recent.order) > 0 && now.Sub(recent.seenAt[recent.order[0]]) > app.options.DedupWindow {
+		delete(recent.seenAt, recent.order[0])
+		recent.order = recent.order[1:]
+	}
+
+	if _, seen := recent.seenAt[insertId]; seen {
+		if app.options.DedupLeakRate > 0 && rand.Float64() < app.options.DedupLeakRate {
+			log.Printf("Letting duplicate insertId %s into %s", insertId, tableKey)
+			return false
+		}
+		log.Printf("Dropping duplicate insertId %s for %s", insertId, tableKey)
+		return true
+	}
+
+	recent.seenAt[insertId] = now
+	recent.order = append(recent.order, insertId)
+	return false
+}
@@ -0,0 +1,36 @@
+package routes
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIsDuplicateInsert(t *testing.T) {
+	app := NewApp(nil, Options{DedupWindow: time.Minute})
+	start := time.Now()
+	for _, test := range []struct {
+		table    string
+		insertId string
+		after    time.Duration
+		want     bool
+	}{
+		{"t", "a", 0, false},
+		{"t", "a", time.Second, true},
+		{"t", "", time.Second, false},
+		{"t", "", time.Second, false},
+		{"u", "a", time.Second, false},
+		{"t", "b", 30 * time.Second, false},
+		{"t", "a", 61 * time.Second, false}, // forgotten, so seen again from now
+		{"t", "b", 62 * time.Second, true},
+		{"t", "a", 62 * time.Second, true},
+	} {
+		got := app.isDuplicateInsert("p", "d", test.table, test.insertId, start.Add(test.after))
+		if got != test.want {
+			t.Errorf("insertId %q into %s after %s: isDuplicateInsert = %v, want %v",
+				test.insertId, test.table, test.after, got, test.want)
+		}
+	}
+	if recent := app.recentInsertIds["p:d.t"]; len(recent.seenAt) != 2 || len(recent.order) != 2 {
+		t.Errorf("remembers %v in order %v, want a and b once each", recent.seenAt, recent.order)
+	}
+}
@@ -0,0 +1,34 @@
+package routes
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/danielstutzman/fake-bigquery/data"
+)
+
+func (app *App) deleteDataset(w http.ResponseWriter, r *http.Request, projectName, datasetName string) {
+	project, projectOk := app.projects[projectName]
+	if !projectOk {
+		project = data.Project{
+			Datasets: map[string]data.Dataset{},
+		}
+		app.projects[projectName] = project
+	}
+
+	dataset, datasetOk := project.Datasets[datasetName]
+	if !datasetOk {
+		writeError(w, http.StatusNotFound, "notFound",
+			fmt.Sprintf("Not found: Dataset %s:%s", projectName, datasetName))
+		return
+	}
+
+	if len(dataset.Tables) > 0 && r.URL.Query().Get("deleteContents") != "true" {
+		writeError(w, http.StatusBadRequest, "resourceInUse",
+			fmt.Sprintf("Dataset %s:%s is still in use", projectName, datasetName))
+		return
+	}
+
+	delete(project.Datasets, datasetName)
+	w.WriteHeader(http.StatusNoContent)
+}
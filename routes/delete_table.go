@@ -0,0 +1,34 @@
+package routes
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/danielstutzman/fake-bigquery/data"
+)
+
+func (app *App) deleteTable(w http.ResponseWriter, r *http.Request, projectName, datasetName, tableName string) {
+	project, projectOk := app.projects[projectName]
+	if !projectOk {
+		project = data.Project{
+			Datasets: map[string]data.Dataset{},
+		}
+		app.projects[projectName] = project
+	}
+
+	dataset, datasetOk := project.Datasets[datasetName]
+	if !datasetOk {
+		writeError(w, http.StatusNotFound, "notFound",
+			fmt.Sprintf("Not found: Dataset %s:%s", projectName, datasetName))
+		return
+	}
+
+	if _, tableOk := dataset.Tables[tableName]; !tableOk {
+		writeError(w, http.StatusNotFound, "notFound",
+			fmt.Sprintf("Not found: Table %s:%s.%s", projectName, datasetName, tableName))
+		return
+	}
+
+	delete(dataset.Tables, tableName)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,49 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/danielstutzman/fake-bigquery/queries"
+)
+
+// dryRunJob serves a job with configuration.dryRun set, which compiles
+// its query against the tables as they are and reports the schema,
+// referenced tables and bytes it would process, without running it or
+// keeping the job. A query that doesn't compile fails the request itself.
+// Other kinds of job do nothing on a dry run.
+func (app *App) dryRunJob(w http.ResponseWriter, job *Job, configuration Configuration) {
+	if configuration.Load == nil && configuration.Extract == nil && configuration.Copy == nil {
+		config := app.queryConfig(job, configuration.Query1)
+		config.DryRun = true
+		result, err := queries.ExecuteQuery(configuration.Query1.Query2, app.projects, job.ProjectId, config)
+		if err != nil {
+			writeError(w, errorCode(err.Reason), err.Reason, err.Message)
+			return
+		}
+		queryStatistics := queryStatistics(result)
+		queryStatistics["totalBytesBilled"] = "0"
+		queryStatistics["totalBytesProcessedAccuracy"] = "PRECISE"
+		queryStatistics["schema"] = map[string]interface{}{"fields": result.Fields}
+		job.Statistics["query"] = queryStatistics
+		job.Statistics["totalBytesProcessed"] = fmt.Sprintf("%d", result.TotalBytesProcessed)
+	}
+	job.State = "DONE"
+	job.EndTime = job.StartTime
+
+	// A dry run isn't a job that can be looked up later, so has no ID
+	resource := jobResource(job)
+	delete(resource, "id")
+	delete(resource, "selfLink")
+	resource["jobReference"] = map[string]string{
+		"projectId": job.ProjectId,
+		"location":  job.Location,
+	}
+	outputJson, err := json.Marshal(resource)
+	if err != nil {
+		log.Fatalf("Error from Marshal: %v", err)
+	}
+	w.Write(outputJson)
+}
@@ -0,0 +1,30 @@
+package routes
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestDryRun(t *testing.T) {
+	app := testApp(Options{})
+	w := serve(app, "POST", "/bigquery/v2/projects/p/jobs",
+		`{"configuration": {"dryRun": true, "query": {"query": "SELECT a FROM d.t", "useLegacySql": false}}}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("dry run gave %d %s", w.Code, w.Body.String())
+	}
+	statistics := decode(t, w)["statistics"].(map[string]interface{})
+	if got := statistics["totalBytesProcessed"]; got != "24" {
+		t.Errorf("totalBytesProcessed is %v, want 24", got)
+	}
+	if len(app.jobs) != 0 {
+		t.Errorf("dry run kept %d jobs, want none", len(app.jobs))
+	}
+
+	w = serve(app, "POST", "/bigquery/v2/projects/p/jobs",
+		`{"configuration": {"dryRun": true, "query": {"query": "SELECT nope FROM d.t", "useLegacySql": false}}}`)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `"reason": "invalidQuery"`) ||
+		strings.Contains(w.Body.String(), `"location"`) {
+		t.Errorf("invalid dry run gave %d %s, want a plain 400 invalidQuery", w.Code, w.Body.String())
+	}
+}
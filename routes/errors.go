@@ -0,0 +1,51 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// writeError serves an error body in the same shape BigQuery uses, e.g.
+// writeError(w, http.StatusNotFound, "notFound", "Not found: Table p:d.t").
+func writeError(w http.ResponseWriter, code int, reason, message string) {
+	messageJson, err := json.Marshal(message)
+	if err != nil {
+		log.Fatalf("Error from Marshal: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(code)
+	fmt.Fprintf(w, `{
+		"error": {
+			"errors": [
+				{
+					"domain": "global",
+					"reason": "%s",
+					"message": %s
+				}
+			],
+			"code": %d,
+			"message": %s
+		}
+	}
+`, reason, messageJson, code, messageJson)
+}
+
+// errorCode is the HTTP status BigQuery serves an error with, given its
+// reason.
+func errorCode(reason string) int {
+	switch reason {
+	case "notFound":
+		return http.StatusNotFound
+	case "duplicate":
+		return http.StatusConflict
+	case "accessDenied", "quotaExceeded", "rateLimitExceeded", "responseTooLarge":
+		return http.StatusForbidden
+	case "backendError", "internalError":
+		return http.StatusInternalServerError
+	}
+	return http.StatusBadRequest
+}
@@ -0,0 +1,79 @@
+package routes
+
+import (
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/danielstutzman/fake-bigquery/data"
+)
+
+// dropExpiredTables deletes every table whose expirationTime has passed.
+// It runs before each request, which is as often as anyone could notice.
+func (app *App) dropExpiredTables() {
+	nowMillis := data.NowMillis()
+	for projectName, project := range app.projects {
+		for datasetName, dataset := range project.Datasets {
+			for tableName, table := range dataset.Tables {
+				if table.ExpirationTime != 0 && table.ExpirationTime <= nowMillis {
+					log.Printf("Table %s:%s.%s has expired", projectName, datasetName, tableName)
+					delete(dataset.Tables, tableName)
+				}
+			}
+		}
+	}
+}
+
+// dropExpiredPartitions deletes the rows of time partitions that ended
+// more than their table's timePartitioning.expirationMs ago. Rows in the
+// NULL partition never expire.
+func (app *App) dropExpiredPartitions() {
+	now := time.Now()
+	for projectName, project := range app.projects {
+		for datasetName, dataset := range project.Datasets {
+			for tableName, table := range dataset.Tables {
+				if table.TimePartitioning == nil || table.TimePartitioning.ExpirationMs == "" {
+					continue
+				}
+				expirationMs, err := strconv.ParseInt(table.TimePartitioning.ExpirationMs, 10, 64)
+				if err != nil {
+					continue
+				}
+				partitionType := table.TimePartitioning.PartitionType()
+				kept := []map[string]interface{}{}
+				for _, row := range table.Rows {
+					start, ok := table.PartitionTime(row)
+					if ok && !data.PartitionEnd(start, partitionType).
+						Add(time.Duration(expirationMs)*time.Millisecond).After(now) {
+						continue
+					}
+					kept = append(kept, row)
+				}
+				if len(kept) < len(table.Rows) {
+					log.Printf("%d rows of %s:%s.%s have expired", len(table.Rows)-len(kept),
+						projectName, datasetName, tableName)
+					table.Rows = kept
+					dataset.Tables[tableName] = table
+				}
+			}
+		}
+	}
+}
+
+// flushStreamingBuffers ages streamed rows out of their tables' streaming
+// buffers, and into their partitions, once they've been there for the
+// StreamingBufferFlush option.
+func (app *App) flushStreamingBuffers() {
+	flushedMillis := data.NowMillis() - int64(app.options.StreamingBufferFlush/time.Millisecond)
+	for _, project := range app.projects {
+		for _, dataset := range project.Datasets {
+			for tableName, table := range dataset.Tables {
+				if table.StreamingBuffer != nil && table.StreamingBuffer.OldestEntryTime <= flushedMillis {
+					table.Rows = table.FlushStreamedRows(flushedMillis)
+					table.StreamingBuffer = table.StreamingBuffer.Flush(flushedMillis)
+					dataset.Tables[tableName] = table
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package routes
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/danielstutzman/fake-bigquery/data"
+	"github.com/danielstutzman/fake-bigquery/formats"
+)
+
+type ExtractConfiguration struct {
+	SourceTable         TableReference `json:"sourceTable"`
+	DestinationUris     []string       `json:"destinationUris"`
+	DestinationUri      string         `json:"destinationUri"`
+	DestinationFormat   string         `json:"destinationFormat"` // CSV, NEWLINE_DELIMITED_JSON, AVRO, PARQUET
+	Compression         string         `json:"compression"`       // NONE, GZIP, DEFLATE, SNAPPY
+	FieldDelimiter      string         `json:"fieldDelimiter"`
+	PrintHeader         *bool          `json:"printHeader"`
+	UseAvroLogicalTypes bool           `json:"useAvroLogicalTypes"`
+}
+
+// FIRST_SHARD is what a * in a destination URI is replaced with. Tables
+// here are small, so every URI gets exactly one file.
+const FIRST_SHARD = "000000000000"
+
+// runExtractJob writes the source table's rows to the files behind the
+// job's destinationUris, splitting them evenly when there are several.
+func (app *App) runExtractJob(job *Job, config ExtractConfiguration) *ErrorProto {
+	source := config.SourceTable
+	if source.ProjectId == "" {
+		source.ProjectId = job.ProjectId
+	}
+	destinationFormat := strings.ToUpper(config.DestinationFormat)
+	if destinationFormat == "" {
+		destinationFormat = "CSV"
+	}
+	compression := strings.ToUpper(config.Compression)
+	if compression == "" {
+		compression = "NONE"
+	}
+	uris := config.DestinationUris
+	if config.DestinationUri != "" {
+		uris = append([]string{config.DestinationUri}, uris...)
+	}
+	if len(uris) == 0 {
+		return newJobError("invalid", "No destination URIs specified.")
+	}
+
+	project, projectOk := app.projects[source.ProjectId]
+	if !projectOk {
+		project = data.Project{
+			Datasets: map[string]data.Dataset{},
+		}
+		app.projects[source.ProjectId] = project
+	}
+
+	dataset, datasetOk := project.Datasets[source.DatasetId]
+	if !datasetOk {
+		return newJobError("notFound", "Not found: Dataset %s:%s",
+			source.ProjectId, source.DatasetId)
+	}
+	table, tableOk := dataset.Tables[source.TableId]
+	if !tableOk {
+		return newJobError("notFound", "Not found: Table %s:%s.%s",
+			source.ProjectId, source.DatasetId, source.TableId)
+	}
+	if table.View != nil || table.MaterializedView != nil {
+		return newJobError("invalid", "%s:%s.%s is not allowed for this operation because it is currently a %s.",
+			source.ProjectId, source.DatasetId, source.TableId, table.Type())
+	}
+
+	if destinationFormat == "CSV" {
+		for _, field := range table.Fields {
+			if data.NormalizeType(field.Type) == "RECORD" || data.NormalizeMode(field.Mode) == "REPEATED" {
+				return newJobError("invalid",
+					"Operation cannot be performed on a nested schema. Field: %s", field.Name)
+			}
+		}
+	}
+	switch {
+	case compression == "NONE":
+	case compression == "GZIP" && destinationFormat != "AVRO":
+	case (compression == "DEFLATE" || compression == "SNAPPY") && destinationFormat == "AVRO":
+	case (compression == "SNAPPY" || compression == "GZIP") && destinationFormat == "PARQUET":
+	default:
+		return newJobError("invalid", "Compression %s is not supported for %s exports.",
+			compression, formatDisplayName(destinationFormat))
+	}
+
+	printHeader := true
+	if config.PrintHeader != nil {
+		printHeader = *config.PrintHeader
+	}
+
+	fileCounts := []string{}
+	for i, uri := range uris {
+		start := len(table.Rows) * i / len(uris)
+		end := len(table.Rows) * (i + 1) / len(uris)
+		rows := table.Rows[start:end]
+
+		var content []byte
+		var err error
+		switch destinationFormat {
+		case "CSV":
+			content = formats.WriteCsv(table.Fields, rows, config.FieldDelimiter, printHeader)
+		case "NEWLINE_DELIMITED_JSON":
+			content, err = formats.WriteNdjson(table.Fields, rows)
+		case "AVRO":
+			codec := "null"
+			if compression != "NONE" {
+				codec = strings.ToLower(compression)
+			}
+			content, err = formats.WriteAvro(table.Fields, rows, codec)
+		case "PARQUET":
+			content, err = formats.WriteParquet(table.Fields, rows, compression)
+		default:
+			return newJobError("invalid", "Unsupported destination format %s", config.DestinationFormat)
+		}
+		if err != nil {
+			return newJobError("internalError", "Error while writing data, error message: %s", err)
+		}
+
+		if compression == "GZIP" && destinationFormat != "PARQUET" {
+			var compressed bytes.Buffer
+			gzipWriter := gzip.NewWriter(&compressed)
+			gzipWriter.Write(content)
+			gzipWriter.Close()
+			content = compressed.Bytes()
+		}
+
+		path, jobErr := app.gcsPath(strings.Replace(uri, "*", FIRST_SHARD, 1))
+		if jobErr != nil {
+			return jobErr
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return newJobError("internalError", "Can't write %s: %s", uri, err)
+		}
+		if err := ioutil.WriteFile(path, content, 0644); err != nil {
+			return newJobError("internalError", "Can't write %s: %s", uri, err)
+		}
+		fileCounts = append(fileCounts, "1")
+	}
+
+	job.Statistics["extract"] = map[string]interface{}{
+		"destinationUriFileCounts": fileCounts,
+		"inputBytes":               fmt.Sprintf("%d", data.TableBytes(table)),
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package routes
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestExtractShards(t *testing.T) {
+	dir := t.TempDir()
+	app := testApp(Options{GcsDir: dir})
+	body := decode(t, serve(app, "POST", "/bigquery/v2/projects/p/jobs", `{"configuration": {"extract": {
+		"sourceTable": {"projectId": "p", "datasetId": "d", "tableId": "t"},
+		"destinationUris": ["gs://b/one-*.json", "gs://b/two-*.json"],
+		"destinationFormat": "NEWLINE_DELIMITED_JSON"}}}`))
+	if reason := errorReason(t, body); reason != "" {
+		t.Fatalf("extract failed with %s: %v", reason, body["status"])
+	}
+	counts := body["statistics"].(map[string]interface{})["extract"].(map[string]interface{})["destinationUriFileCounts"]
+	if !reflect.DeepEqual(counts, []interface{}{"1", "1"}) {
+		t.Errorf("destinationUriFileCounts is %v, want one file per URI", counts)
+	}
+
+	for name, want := range map[string]string{
+		"one-000000000000.json": `{"a":"1","b":"one"}` + "\n",
+		"two-000000000000.json": `{"a":"2","b":"two"}` + "\n" + `{"a":"3","b":"three"}` + "\n",
+	} {
+		content, err := ioutil.ReadFile(filepath.Join(dir, "b", name))
+		if err != nil {
+			t.Errorf("reading %s: %s", name, err)
+		} else if string(content) != want {
+			t.Errorf("%s holds %q, want %q", name, content, want)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package routes
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var GCS_URI_REGEXP = regexp.MustCompile("^gs://([^/]+)/(.+)$")
+
+// gcsPath maps a gs://bucket/object URI onto a file under the -gcs-dir
+// directory, which stands in for Cloud Storage.
+func (app *App) gcsPath(uri string) (string, *ErrorProto) {
+	bucketDir, object, jobErr := app.gcsBucketDir(uri)
+	if jobErr != nil {
+		return "", jobErr
+	}
+	path := filepath.Join(bucketDir, filepath.FromSlash(object))
+	if !insideDir(bucketDir, path) {
+		return "", newJobError("invalid", "Invalid URI: %s. The object name can't leave its bucket", uri)
+	}
+	return path, nil
+}
+
+// gcsBucketDir gives the directory standing in for a URI's bucket, and
+// the object name after it.
+func (app *App) gcsBucketDir(uri string) (string, string, *ErrorProto) {
+	match := GCS_URI_REGEXP.FindStringSubmatch(uri)
+	if match == nil {
+		return "", "", newJobError("invalid", "Invalid URI: %s. URIs must start with gs://", uri)
+	}
+	if app.options.GcsDir == "" {
+		return "", "", newJobError("invalid",
+			"Can't access %s: start fake-bigquery with -gcs-dir to use gs:// URIs", uri)
+	}
+	gcsDir := filepath.Clean(app.options.GcsDir)
+	bucketDir := filepath.Join(gcsDir, match[1])
+	if filepath.Dir(bucketDir) != gcsDir {
+		return "", "", newJobError("invalid", "Invalid URI: %s. Invalid bucket name: %s", uri, match[1])
+	}
+	return bucketDir, match[2], nil
+}
+
+// insideDir reports whether path, once cleaned, is dir or under it.
+func insideDir(dir, path string) bool {
+	relative, err := filepath.Rel(dir, filepath.Clean(path))
+	return err == nil && relative != ".." && !strings.HasPrefix(relative, ".."+string(filepath.Separator))
+}
+
+// expandGcsUri lists the files matching a URI, which may contain one *
+// wildcard that (as in Cloud Storage) can match across slashes.
+func (app *App) expandGcsUri(uri string) ([]string, *ErrorProto) {
+	path, jobErr := app.gcsPath(uri)
+	if jobErr != nil {
+		return nil, jobErr
+	}
+
+	if !strings.Contains(uri, "*") {
+		if _, err := os.Stat(path); err != nil {
+			return nil, newJobError("notFound", "Not found: URI %s", uri)
+		}
+		return []string{path}, nil
+	}
+
+	bucketDir, object, jobErr := app.gcsBucketDir(uri)
+	if jobErr != nil {
+		return nil, jobErr
+	}
+	parts := strings.Split(object, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	objectRegexp := regexp.MustCompile("^" + strings.Join(parts, ".*") + "$")
+
+	paths := []string{}
+	filepath.Walk(bucketDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		relative, err := filepath.Rel(bucketDir, path)
+		if err == nil && insideDir(bucketDir, path) && objectRegexp.MatchString(filepath.ToSlash(relative)) {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if len(paths) == 0 {
+		return nil, newJobError("notFound", "Not found: URI %s", uri)
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
@@ -5,13 +5,17 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"sort"
 	"time"
 
 	"github.com/danielstutzman/fake-bigquery/data"
 )
 
 type InsertRowsRequest struct {
-	Rows []InsertRow `json:"rows"`
+	Rows                []InsertRow `json:"rows"`
+	SkipInvalidRows     bool        `json:"skipInvalidRows"`
+	IgnoreUnknownValues bool        `json:"ignoreUnknownValues"`
+	TemplateSuffix      string      `json:"templateSuffix"`
 }
 
 type InsertRow struct {
@@ -19,15 +23,37 @@ type InsertRow struct {
 	Json     map[string]interface{} `json:"json"`
 }
 
+type InsertError struct {
+	Index  int               `json:"index"`
+	Errors []InsertErrorItem `json:"errors"`
+}
+
+type InsertErrorItem struct {
+	Reason    string `json:"reason"`
+	Location  string `json:"location"`
+	DebugInfo string `json:"debugInfo"`
+	Message   string `json:"message"`
+}
+
 func (app *App) insertRows(w http.ResponseWriter, r *http.Request, projectName, datasetName, tableName string) {
 	decoder := json.NewDecoder(r.Body)
+	decoder.UseNumber() // so that INTEGERs beyond 2^53 keep their precision
 	var body InsertRowsRequest
 	err := decoder.Decode(&body)
 	if err != nil {
-		panic(err)
+		writeError(w, http.StatusBadRequest, "invalid", fmt.Sprintf("Invalid JSON payload: %s", err))
+		return
 	}
 	defer r.Body.Close()
 
+	if err := app.useInsertedRows(projectName, len(body.Rows)); err != nil {
+		writeError(w, http.StatusForbidden, err.Reason, err.Message)
+		return
+	}
+
+	// A decorator like events$20240101 streams into that partition
+	tableName, partitionId := data.SplitPartitionDecorator(tableName)
+
 	project, projectOk := app.projects[projectName]
 	if !projectOk {
 		project = data.Project{
@@ -38,35 +64,157 @@ func (app *App) insertRows(w http.ResponseWriter, r *http.Request, projectName,
 
 	dataset, datasetOk := project.Datasets[datasetName]
 	if !datasetOk {
-		log.Fatalf("Dataset doesn't exist: %s", datasetName)
+		writeError(w, http.StatusNotFound, "notFound",
+			fmt.Sprintf("Not found: Dataset %s:%s", projectName, datasetName))
+		return
 	}
 
 	table, tableOk := dataset.Tables[tableName]
 	if !tableOk {
-		log.Fatalf("Table doesn't exist: %s", tableName)
+		writeError(w, http.StatusNotFound, "notFound",
+			fmt.Sprintf("Not found: Table %s:%s.%s", projectName, datasetName, tableName))
+		return
+	}
+	if table.Snapshot != nil {
+		writeError(w, http.StatusBadRequest, "invalid",
+			fmt.Sprintf("Cannot write to table snapshot %s:%s.%s.", projectName, datasetName, tableName))
+		return
+	} else if table.View != nil {
+		writeError(w, http.StatusBadRequest, "invalid",
+			fmt.Sprintf("Cannot write to view %s:%s.%s.", projectName, datasetName, tableName))
+		return
+	} else if table.MaterializedView != nil {
+		writeError(w, http.StatusBadRequest, "invalid",
+			fmt.Sprintf("Cannot write to materialized view %s:%s.%s.", projectName, datasetName, tableName))
+		return
+	}
+	if partitionId != "" {
+		if err := table.CheckPartitionId(partitionId); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid", err.Error())
+			return
+		}
 	}
 
-	for _, row := range body.Rows {
-		newRow := map[string]interface{}{}
-		for _, field := range table.Fields {
-			value := row.Json[field.Name]
-			if field.Type == "TIMESTAMP" {
-				parsedTime, err := time.Parse(time.RFC3339, value.(string))
-				if err != nil {
-					log.Fatalf("Can't parse time: %s", value)
-				}
-				newRow[field.Name] = parsedTime
-			} else {
-				newRow[field.Name] = row.Json[field.Name]
+	nowMillis := data.NowMillis()
+
+	// With a templateSuffix, rows go to a table named after the template
+	// plus the suffix, which is created with the template's schema if needed
+	if body.TemplateSuffix != "" {
+		tableName = tableName + body.TemplateSuffix
+		suffixedTable, suffixedTableOk := dataset.Tables[tableName]
+		if !suffixedTableOk {
+			fieldsCopy := make([]data.Field, len(table.Fields))
+			copy(fieldsCopy, table.Fields)
+			suffixedTable = data.Table{
+				Fields:                 fieldsCopy,
+				Rows:                   []map[string]interface{}{},
+				CreationTime:           nowMillis,
+				LastModifiedTime:       nowMillis,
+				ExpirationTime:         dataset.NewTableExpirationTime(nowMillis),
+				TimePartitioning:       table.TimePartitioning,
+				RangePartitioning:      table.RangePartitioning,
+				RequirePartitionFilter: table.RequirePartitionFilter,
+				Clustering:             table.Clustering,
 			}
 		}
+		table = suffixedTable
+	}
 
+	newRows := []map[string]interface{}{}
+	newRowInsertIds := []string{}
+	insertErrors := []InsertError{}
+	invalidIndexes := map[int]bool{}
+	for i, row := range body.Rows {
+		newRow, fieldErrors := data.ConvertRow(table.Fields, row.Json, body.IgnoreUnknownValues)
+		if len(fieldErrors) > 0 {
+			insertError := InsertError{Index: i, Errors: []InsertErrorItem{}}
+			for _, fieldError := range fieldErrors {
+				insertError.Errors = append(insertError.Errors, InsertErrorItem{
+					Reason:   "invalid",
+					Location: fieldError.Location,
+					Message:  fieldError.Message,
+				})
+			}
+			insertErrors = append(insertErrors, insertError)
+			invalidIndexes[i] = true
+			continue
+		}
+		streamedAt := time.Unix(0, nowMillis*int64(time.Millisecond))
+		if err := table.AssignStreamedPartition(newRow, partitionId, streamedAt); err != nil {
+			insertErrors = append(insertErrors, InsertError{Index: i, Errors: []InsertErrorItem{{
+				Reason:  "invalid",
+				Message: err.Error(),
+			}}})
+			invalidIndexes[i] = true
+			continue
+		}
+		newRows = append(newRows, newRow)
+		newRowInsertIds = append(newRowInsertIds, row.InsertId)
+	}
+
+	// Without skipInvalidRows, one bad row stops the whole request, and
+	// every other row is reported as "stopped"
+	if len(insertErrors) > 0 && !body.SkipInvalidRows {
+		for i := range body.Rows {
+			if !invalidIndexes[i] {
+				insertErrors = append(insertErrors, InsertError{
+					Index:  i,
+					Errors: []InsertErrorItem{{Reason: "stopped"}},
+				})
+			}
+		}
+		newRows = nil
+	}
+
+	// A table made from a template counts as created once rows go into it
+	if _, tableExists := dataset.Tables[tableName]; !tableExists && len(newRows) > 0 {
+		if err := app.useCreatedTable(projectName); err != nil {
+			writeError(w, http.StatusForbidden, err.Reason, err.Message)
+			return
+		}
+	}
+
+	// A request whose rows were all duplicates leaves the table untouched
+	now := time.Now()
+	written := false
+	for i, newRow := range newRows {
+		if app.isDuplicateInsert(projectName, datasetName, tableName, newRowInsertIds[i], now) {
+			continue
+		}
+		if table.StreamingBuffer == nil {
+			table.StreamingBuffer = &data.StreamingBuffer{}
+		}
 		table.Rows = append(table.Rows, newRow)
+		table.StreamingBuffer.Add(nowMillis, data.RowBytes(table.Fields, newRow))
+		written = true
+	}
+	if written {
+		table.LastModifiedTime = nowMillis
+		table.Version += 1
+		dataset.Tables[tableName] = table
 	}
-	dataset.Tables[tableName] = table
 
-	// No errors implies success
-	fmt.Fprintf(w, `{
+	if len(insertErrors) == 0 {
+		// No errors implies success
+		fmt.Fprintf(w, `{
 		"kind": "bigquery#tableDataInsertAllResponse"
 	}`)
+		return
+	}
+
+	sortInsertErrors(insertErrors)
+	insertErrorsJson, err := json.Marshal(insertErrors)
+	if err != nil {
+		log.Fatalf("Error from Marshal: %v", err)
+	}
+	fmt.Fprintf(w, `{
+		"kind": "bigquery#tableDataInsertAllResponse",
+		"insertErrors": %s
+	}`, insertErrorsJson)
+}
+
+func sortInsertErrors(insertErrors []InsertError) {
+	sort.Slice(insertErrors, func(i, j int) bool {
+		return insertErrors[i].Index < insertErrors[j].Index
+	})
 }
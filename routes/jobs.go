@@ -0,0 +1,229 @@
+package routes
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/danielstutzman/fake-bigquery/data"
+)
+
+// Job is what the job registry remembers about a submitted job, so that
+// jobs.get can report on it after jobs.insert returns.
+type Job struct {
+	ProjectId     string
+	JobId         string
+	Location      string
+	Configuration map[string]interface{} // as submitted, plus defaults
+	State         string                 // PENDING, RUNNING or DONE
+	ErrorResult   *ErrorProto
+	Errors        []ErrorProto
+	CreationTime  int64
+	StartTime     int64
+	EndTime       int64
+	Statistics    map[string]interface{} // e.g. {"load": {"outputRows": "3"}}
+	UserEmail     string
+}
+
+type ErrorProto struct {
+	Reason   string `json:"reason"`
+	Location string `json:"location,omitempty"`
+	Message  string `json:"message"`
+}
+
+func (e *ErrorProto) Error() string {
+	return e.Message
+}
+
+func newJobError(reason, format string, args ...interface{}) *ErrorProto {
+	return &ErrorProto{Reason: reason, Message: fmt.Sprintf(format, args...)}
+}
+
+func newJobId() string {
+	return "job_" + newRandomId()
+}
+
+func newRandomId() string {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		log.Fatalf("Error from rand.Read: %v", err)
+	}
+	return hex.EncodeToString(random)
+}
+
+func jobKey(projectName, jobId string) string {
+	return projectName + ":" + jobId
+}
+
+// jobResource renders a job the way jobs.insert and jobs.get return it.
+func jobResource(job *Job) map[string]interface{} {
+	statistics := map[string]interface{}{
+		"creationTime": fmt.Sprintf("%d", job.CreationTime),
+		"startTime":    fmt.Sprintf("%d", job.StartTime),
+	}
+	if job.State == "DONE" {
+		statistics["endTime"] = fmt.Sprintf("%d", job.EndTime)
+	}
+	for key, value := range job.Statistics {
+		statistics[key] = value
+	}
+
+	status := map[string]interface{}{
+		"state": job.State,
+	}
+	if job.ErrorResult != nil {
+		status["errorResult"] = job.ErrorResult
+		errors := job.Errors
+		if len(errors) == 0 {
+			errors = []ErrorProto{*job.ErrorResult}
+		}
+		status["errors"] = errors
+	} else if len(job.Errors) > 0 {
+		status["errors"] = job.Errors
+	}
+
+	return map[string]interface{}{
+		"kind":     "bigquery#job",
+		"etag":     "\"cX5UmbB_R-S07ii743IKGH9YCYM/_oiKSu1NLem_L8Icwp_IYkfy3vg\"",
+		"id":       fmt.Sprintf("%s:%s.%s", job.ProjectId, job.Location, job.JobId),
+		"selfLink": fmt.Sprintf("https://www.googleapis.com/bigquery/v2/projects/%s/jobs/%s", job.ProjectId, job.JobId),
+		"jobReference": map[string]string{
+			"projectId": job.ProjectId,
+			"jobId":     job.JobId,
+			"location":  job.Location,
+		},
+		"configuration": job.Configuration,
+		"status":        status,
+		"statistics":    statistics,
+		"user_email":    job.UserEmail,
+	}
+}
+
+// jobInfos lists the jobs in the registry for INFORMATION_SCHEMA.JOBS.
+func (app *App) jobInfos() []data.JobInfo {
+	infos := []data.JobInfo{}
+	for _, job := range app.jobs {
+		info := data.JobInfo{
+			ProjectId:    job.ProjectId,
+			JobId:        job.JobId,
+			ParentJobId:  parentJobId(job),
+			Location:     job.Location,
+			UserEmail:    job.UserEmail,
+			JobType:      "QUERY",
+			State:        job.State,
+			CreationTime: job.CreationTime,
+			StartTime:    job.StartTime,
+			EndTime:      job.EndTime,
+		}
+		for _, jobType := range []string{"load", "extract", "copy"} {
+			if _, ok := job.Configuration[jobType]; ok {
+				info.JobType = strings.ToUpper(jobType)
+			}
+		}
+		if job.ErrorResult != nil {
+			info.ErrorReason = job.ErrorResult.Reason
+			info.ErrorLocation = job.ErrorResult.Location
+			info.ErrorMessage = job.ErrorResult.Message
+		}
+		if configuration, ok := job.Configuration["query"].(map[string]interface{}); ok {
+			info.Query, _ = configuration["query"].(string)
+			info.DestinationTable = jobTableRef(configuration["destinationTable"])
+		}
+		if statistics, ok := job.Statistics["query"].(map[string]interface{}); ok {
+			info.StatementType, _ = statistics["statementType"].(string)
+			totalBytesProcessed, _ := statistics["totalBytesProcessed"].(string)
+			info.TotalBytesProcessed, _ = strconv.ParseInt(totalBytesProcessed, 10, 64)
+			totalBytesBilled, _ := statistics["totalBytesBilled"].(string)
+			info.TotalBytesBilled, _ = strconv.ParseInt(totalBytesBilled, 10, 64)
+			referencedTables, _ := statistics["referencedTables"].([]map[string]string)
+			for _, ref := range referencedTables {
+				info.ReferencedTables = append(info.ReferencedTables, *jobTableRef(ref))
+			}
+		}
+		if sessionInfo, ok := job.Statistics["sessionInfo"].(map[string]string); ok {
+			info.SessionId = sessionInfo["sessionId"]
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// parentJobId is the ID of the script job that ran a job, or "".
+func parentJobId(job *Job) string {
+	parent, _ := job.Statistics["parentJobId"].(string)
+	return parent
+}
+
+// listJobs serves jobs.list, listing a project's jobs newest first. Those
+// are its top-level jobs, unless parentJobId asks for a script's child jobs.
+func (app *App) listJobs(w http.ResponseWriter, r *http.Request, projectName string) {
+	parent := r.URL.Query().Get("parentJobId")
+	jobs := []*Job{}
+	for _, job := range app.jobs {
+		if job.ProjectId == projectName && parentJobId(job) == parent {
+			jobs = append(jobs, job)
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		if jobs[i].CreationTime != jobs[j].CreationTime {
+			return jobs[i].CreationTime > jobs[j].CreationTime
+		}
+		return jobs[i].JobId > jobs[j].JobId
+	})
+
+	jobOutputs := []map[string]interface{}{}
+	for _, job := range jobs {
+		jobOutput := jobResource(job)
+		jobOutput["state"] = job.State
+		if job.ErrorResult != nil {
+			jobOutput["errorResult"] = job.ErrorResult
+		}
+		jobOutputs = append(jobOutputs, jobOutput)
+	}
+	jobOutputsJson, err := json.Marshal(jobOutputs)
+	if err != nil {
+		log.Fatalf("Error from Marshal: %v", err)
+	}
+
+	fmt.Fprintf(w, `{
+		"kind": "bigquery#jobList",
+		"etag": "\"cX5UmbB_R-S07ii743IKGH9YCYM/UfH5dTMk0cxKTdSuUjUaHu3rxPY\"",
+		"jobs": %s
+	}`, jobOutputsJson)
+}
+
+// jobTableRef reads a table reference from a job's configuration, either
+// as submitted or as filled in when it ran.
+func jobTableRef(value interface{}) *data.TableRef {
+	switch ref := value.(type) {
+	case map[string]string:
+		return &data.TableRef{ProjectId: ref["projectId"], DatasetId: ref["datasetId"], TableId: ref["tableId"]}
+	case map[string]interface{}:
+		projectId, _ := ref["projectId"].(string)
+		datasetId, _ := ref["datasetId"].(string)
+		tableId, _ := ref["tableId"].(string)
+		return &data.TableRef{ProjectId: projectId, DatasetId: datasetId, TableId: tableId}
+	}
+	return nil
+}
+
+func (app *App) getJob(w http.ResponseWriter, r *http.Request, projectName, jobId string) {
+	job, jobOk := app.jobs[jobKey(projectName, jobId)]
+	if !jobOk {
+		writeError(w, http.StatusNotFound, "notFound",
+			fmt.Sprintf("Not found: Job %s:%s", projectName, jobId))
+		return
+	}
+
+	outputJson, err := json.Marshal(jobResource(job))
+	if err != nil {
+		log.Fatalf("Error from Marshal: %v", err)
+	}
+	w.Write(outputJson)
+}
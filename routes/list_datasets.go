@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strings"
 
 	"github.com/danielstutzman/fake-bigquery/data"
 )
@@ -19,7 +20,12 @@ func (app *App) listDatasets(w http.ResponseWriter, r *http.Request, projectName
 	}
 
 	datasetOutputs := []map[string]interface{}{}
-	for datasetName := range project.Datasets {
+	for datasetName, dataset := range project.Datasets {
+		// Hidden datasets, like the ones holding anonymous query results,
+		// are only listed with all=true
+		if strings.HasPrefix(datasetName, "_") && r.URL.Query().Get("all") != "true" {
+			continue
+		}
 		datasetOutput := map[string]interface{}{
 			"kind": "bigquery#dataset",
 			"id":   fmt.Sprintf("%s:%s", projectName, datasetName),
@@ -27,6 +33,13 @@ func (app *App) listDatasets(w http.ResponseWriter, r *http.Request, projectName
 				"projectId": projectName,
 				"datasetId": datasetName,
 			},
+			"location": dataset.Location,
+		}
+		if dataset.FriendlyName != "" {
+			datasetOutput["friendlyName"] = dataset.FriendlyName
+		}
+		if len(dataset.Labels) > 0 {
+			datasetOutput["labels"] = dataset.Labels
 		}
 		datasetOutputs = append(datasetOutputs, datasetOutput)
 	}
@@ -0,0 +1,179 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/danielstutzman/fake-bigquery/data"
+)
+
+func (app *App) listTableData(w http.ResponseWriter, r *http.Request, projectName, datasetName, tableName string) {
+	project, projectOk := app.projects[projectName]
+	if !projectOk {
+		project = data.Project{
+			Datasets: map[string]data.Dataset{},
+		}
+		app.projects[projectName] = project
+	}
+
+	dataset, datasetOk := project.Datasets[datasetName]
+	if !datasetOk {
+		writeError(w, http.StatusNotFound, "notFound",
+			fmt.Sprintf("Not found: Dataset %s:%s", projectName, datasetName))
+		return
+	}
+
+	table, tableOk := dataset.Tables[tableName]
+	if !tableOk {
+		writeError(w, http.StatusNotFound, "notFound",
+			fmt.Sprintf("Not found: Table %s:%s.%s", projectName, datasetName, tableName))
+		return
+	}
+	if table.View != nil || table.MaterializedView != nil {
+		writeError(w, http.StatusBadRequest, "invalid",
+			fmt.Sprintf("Cannot list a table of type %s.", table.Type()))
+		return
+	}
+
+	params := r.URL.Query()
+	startIndex, endIndex, ok := pageRange(w, params, len(table.Rows))
+	if !ok {
+		return
+	}
+
+	fields := table.Fields
+	if param := params.Get("selectedFields"); param != "" {
+		var err error
+		fields, err = selectFields(table.Fields, strings.Split(param, ","))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid", err.Error())
+			return
+		}
+	}
+
+	rows := []data.ResultRow{}
+	for _, row := range table.Rows[startIndex:endIndex] {
+		rows = append(rows, data.EncodeRow(fields, row))
+	}
+	rowsJson, err := json.Marshal(rows)
+	if err != nil {
+		log.Fatalf("Error from Marshal: %v", err)
+	}
+
+	fmt.Fprintf(w, `{
+		"kind": "bigquery#tableDataList",
+		"etag": "\"cX5UmbB_R-S07ii743IKGH9YCYM/MTUxMTEyMDI0ODcwMA\"",
+		"totalRows": "%d",
+		%s
+		"rows": %s
+	}`, len(table.Rows), pageTokenJson(endIndex, len(table.Rows)), rowsJson)
+}
+
+// pageRange gives the rows of total to serve from the pageToken or
+// startIndex and the maxResults parameters of a request, or serves an
+// error if they're invalid.
+func pageRange(w http.ResponseWriter, params url.Values, total int) (int, int, bool) {
+	startIndex := 0
+	if param := params.Get("pageToken"); param != "" {
+		var err error
+		startIndex, err = strconv.Atoi(param)
+		if err != nil || startIndex < 0 {
+			writeError(w, http.StatusBadRequest, "invalid",
+				fmt.Sprintf("Invalid page token: %s", param))
+			return 0, 0, false
+		}
+	} else if param := params.Get("startIndex"); param != "" {
+		var err error
+		startIndex, err = strconv.Atoi(param)
+		if err != nil || startIndex < 0 {
+			writeError(w, http.StatusBadRequest, "invalid",
+				fmt.Sprintf("Invalid value for startIndex: %s", param))
+			return 0, 0, false
+		}
+	}
+
+	maxResults := -1
+	if param := params.Get("maxResults"); param != "" {
+		var err error
+		maxResults, err = strconv.Atoi(param)
+		if err != nil || maxResults < 0 {
+			writeError(w, http.StatusBadRequest, "invalid",
+				fmt.Sprintf("Invalid value for maxResults: %s", param))
+			return 0, 0, false
+		}
+	}
+
+	if startIndex > total {
+		startIndex = total
+	}
+	endIndex := total
+	if maxResults != -1 && startIndex+maxResults < endIndex {
+		endIndex = startIndex + maxResults
+	}
+	return startIndex, endIndex, true
+}
+
+// pageTokenJson is the pageToken property of a page ending at endIndex,
+// which is left out of the last page.
+func pageTokenJson(endIndex, total int) string {
+	if endIndex < total {
+		return fmt.Sprintf(`"pageToken": "%d",`, endIndex)
+	}
+	return ""
+}
+
+// selectFields narrows a schema to the given comma-separated field paths
+// (e.g. "name,address.city"), keeping the schema's own field order.
+func selectFields(fields []data.Field, paths []string) ([]data.Field, error) {
+	subPathsByName := map[string][]string{}
+	wholeByName := map[string]bool{}
+	for _, path := range paths {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		parts := strings.SplitN(path, ".", 2)
+		name := strings.ToLower(parts[0])
+		if len(parts) == 2 {
+			subPathsByName[name] = append(subPathsByName[name], parts[1])
+		} else {
+			wholeByName[name] = true
+		}
+	}
+	// Selecting the whole field overrides any narrower selection, whichever
+	// comes first
+	for name := range wholeByName {
+		subPathsByName[name] = nil
+	}
+
+	selected := []data.Field{}
+	for _, field := range fields {
+		subPaths, ok := subPathsByName[strings.ToLower(field.Name)]
+		if !ok {
+			continue
+		}
+		delete(subPathsByName, strings.ToLower(field.Name))
+
+		if len(subPaths) > 0 {
+			if field.Type != "RECORD" && field.Type != "STRUCT" {
+				return nil, fmt.Errorf("Field %s is not a RECORD", field.Name)
+			}
+			subFields, err := selectFields(field.Fields, subPaths)
+			if err != nil {
+				return nil, err
+			}
+			field.Fields = subFields
+		}
+		selected = append(selected, field)
+	}
+
+	for name := range subPathsByName {
+		return nil, fmt.Errorf("Field %s not found in schema", name)
+	}
+	return selected, nil
+}
@@ -0,0 +1,43 @@
+package routes
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/danielstutzman/fake-bigquery/data"
+)
+
+func TestSelectFields(t *testing.T) {
+	fields := []data.Field{
+		{Name: "id", Type: "INTEGER"},
+		{Name: "a", Type: "RECORD", Fields: []data.Field{
+			{Name: "b", Type: "STRING"},
+			{Name: "c", Type: "STRING"},
+		}},
+	}
+	narrowed := []data.Field{fields[1]}
+	narrowed[0].Fields = fields[1].Fields[:1]
+	for _, test := range []struct {
+		paths []string
+		want  []data.Field
+	}{
+		{[]string{"a", "id"}, fields},
+		{[]string{"a.b"}, narrowed},
+		{[]string{"a", "a.b"}, fields[1:]},
+		{[]string{"a.b", "a"}, fields[1:]},
+		{[]string{"A.b", " a"}, fields[1:]},
+	} {
+		got, err := selectFields(fields, test.paths)
+		if err != nil {
+			t.Errorf("selectFields(%v): %v", test.paths, err)
+		} else if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("selectFields(%v) = %+v, want %+v", test.paths, got, test.want)
+		}
+	}
+
+	for _, paths := range [][]string{{"nope"}, {"id.x"}, {"a.nope"}} {
+		if _, err := selectFields(fields, paths); err == nil {
+			t.Errorf("selectFields(%v) succeeded, want an error", paths)
+		}
+	}
+}
@@ -20,11 +20,13 @@ func (app *App) listTables(w http.ResponseWriter, r *http.Request, projectName,
 
 	dataset, datasetOk := project.Datasets[datasetName]
 	if !datasetOk {
-		log.Fatalf("Unknown dataset %s", datasetName)
+		writeError(w, http.StatusNotFound, "notFound",
+			fmt.Sprintf("Not found: Dataset %s:%s", projectName, datasetName))
+		return
 	}
 
 	tableOutputs := []map[string]interface{}{}
-	for table := range dataset.Tables {
+	for table, tableData := range dataset.Tables {
 		tableOutput := map[string]interface{}{
 			"kind": "bigquery#table",
 			"id":   fmt.Sprintf("%s:%s.%s", projectName, datasetName, table),
@@ -33,8 +35,8 @@ func (app *App) listTables(w http.ResponseWriter, r *http.Request, projectName,
 				"datasetId": datasetName,
 				"tableId":   table,
 			},
-			"type":         "TABLE",
-			"creationTime": "1234567890123",
+			"type":         tableData.Type(),
+			"creationTime": fmt.Sprintf("%d", tableData.CreationTime),
 		}
 		tableOutputs = append(tableOutputs, tableOutput)
 	}
@@ -0,0 +1,372 @@
+package routes
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/danielstutzman/fake-bigquery/data"
+	"github.com/danielstutzman/fake-bigquery/formats"
+)
+
+type LoadConfiguration struct {
+	SourceUris          []string                `json:"sourceUris"`
+	SourceFormat        string                  `json:"sourceFormat"` // CSV, NEWLINE_DELIMITED_JSON, AVRO, PARQUET
+	Schema              *Schema                 `json:"schema"`
+	DestinationTable    TableReference          `json:"destinationTable"`
+	CreateDisposition   string                  `json:"createDisposition"`
+	WriteDisposition    string                  `json:"writeDisposition"`
+	SkipLeadingRows     int                     `json:"skipLeadingRows"`
+	FieldDelimiter      string                  `json:"fieldDelimiter"`
+	Quote               *string                 `json:"quote"`
+	AllowJaggedRows     bool                    `json:"allowJaggedRows"`
+	AllowQuotedNewlines bool                    `json:"allowQuotedNewlines"`
+	NullMarker          string                  `json:"nullMarker"`
+	Autodetect          bool                    `json:"autodetect"`
+	MaxBadRecords       int                     `json:"maxBadRecords"`
+	IgnoreUnknownValues bool                    `json:"ignoreUnknownValues"`
+	TimePartitioning    *data.TimePartitioning  `json:"timePartitioning"`
+	RangePartitioning   *data.RangePartitioning `json:"rangePartitioning"`
+	Clustering          *data.Clustering        `json:"clustering"`
+}
+
+// MAX_REPORTED_ERRORS caps how many bad rows are listed in a job's errors.
+const MAX_REPORTED_ERRORS = 5
+
+// runLoadJob loads media (if uploaded) or the files behind the job's
+// sourceUris into the destination table.
+func (app *App) runLoadJob(job *Job, config LoadConfiguration, media []byte) *ErrorProto {
+	destination := config.DestinationTable
+	if destination.ProjectId == "" {
+		destination.ProjectId = job.ProjectId
+	}
+	// A decorator like events$20240101 loads into just that partition
+	tableId, partitionId := data.SplitPartitionDecorator(destination.TableId)
+	destination.TableId = tableId
+	sourceFormat := strings.ToUpper(config.SourceFormat)
+	if sourceFormat == "" {
+		sourceFormat = "CSV"
+	}
+
+	project, projectOk := app.projects[destination.ProjectId]
+	if !projectOk {
+		project = data.Project{
+			Datasets: map[string]data.Dataset{},
+		}
+		app.projects[destination.ProjectId] = project
+	}
+
+	dataset, datasetOk := project.Datasets[destination.DatasetId]
+	if !datasetOk {
+		return newJobError("notFound", "Not found: Dataset %s:%s",
+			destination.ProjectId, destination.DatasetId)
+	}
+
+	table, tableExists := dataset.Tables[destination.TableId]
+	if !tableExists && config.CreateDisposition == "CREATE_NEVER" {
+		return newJobError("notFound", "Not found: Table %s:%s.%s",
+			destination.ProjectId, destination.DatasetId, destination.TableId)
+	}
+	if tableExists && table.Snapshot != nil {
+		return newJobError("invalid", "Cannot write to table snapshot %s:%s.%s.",
+			destination.ProjectId, destination.DatasetId, destination.TableId)
+	} else if tableExists && table.View != nil {
+		return newJobError("invalid", "Cannot write to view %s:%s.%s.",
+			destination.ProjectId, destination.DatasetId, destination.TableId)
+	} else if tableExists && table.MaterializedView != nil {
+		return newJobError("invalid", "Cannot write to materialized view %s:%s.%s.",
+			destination.ProjectId, destination.DatasetId, destination.TableId)
+	}
+	existingRows := table.Rows
+	if tableExists && partitionId != "" {
+		if err := table.CheckPartitionId(partitionId); err != nil {
+			return newJobError("invalid", "%s", err)
+		}
+		existingRows, _ = table.PartitionRows(partitionId)
+	}
+	if tableExists && config.WriteDisposition == "WRITE_EMPTY" && len(existingRows) > 0 {
+		return newJobError("duplicate", "Already Exists: Table %s:%s.%s",
+			destination.ProjectId, destination.DatasetId, destination.TableId)
+	}
+
+	sources := [][]byte{}
+	var inputFileBytes int64
+	if media != nil {
+		sources = append(sources, media)
+		inputFileBytes += int64(len(media))
+	} else {
+		for _, uri := range config.SourceUris {
+			paths, jobErr := app.expandGcsUri(uri)
+			if jobErr != nil {
+				return jobErr
+			}
+			for _, path := range paths {
+				content, err := ioutil.ReadFile(path)
+				if err != nil {
+					return newJobError("notFound", "Not found: URI %s", uri)
+				}
+				inputFileBytes += int64(len(content))
+				sources = append(sources, content)
+			}
+		}
+	}
+	for i, source := range sources {
+		if bytes.HasPrefix(source, []byte{0x1f, 0x8b}) {
+			reader, err := gzip.NewReader(bytes.NewReader(source))
+			if err == nil {
+				source, err = ioutil.ReadAll(reader)
+			}
+			if err != nil {
+				return newJobError("invalid", "Error while reading data, error message: %s", err)
+			}
+			sources[i] = source
+		}
+	}
+
+	var fields []data.Field
+	if config.Schema != nil && len(config.Schema.Fields) > 0 {
+		fields = config.Schema.Fields
+	} else if tableExists && len(table.Fields) > 0 &&
+		(config.WriteDisposition != "WRITE_TRUNCATE" || partitionId != "") {
+		fields = table.Fields
+	}
+
+	var rawRows []map[string]interface{}
+	var rowErrors []ErrorProto
+	switch sourceFormat {
+	case "CSV":
+		var jobErr *ErrorProto
+		fields, rawRows, rowErrors, jobErr = readCsvSources(sources, config, fields)
+		if jobErr != nil {
+			return jobErr
+		}
+	case "NEWLINE_DELIMITED_JSON":
+		for _, source := range sources {
+			rows, err := formats.ReadNdjson(source)
+			if err != nil {
+				return newJobError("invalid", "%s", err)
+			}
+			rawRows = append(rawRows, rows...)
+		}
+		if fields == nil && config.Autodetect {
+			fields = formats.DetectJsonSchema(rawRows)
+		}
+	case "AVRO", "PARQUET":
+		for _, source := range sources {
+			read := formats.ReadAvro
+			if sourceFormat == "PARQUET" {
+				read = formats.ReadParquet
+			}
+			embeddedFields, rows, err := read(source)
+			if err != nil {
+				return newJobError("invalid", "Error while reading data, error message: %s", err)
+			}
+			if fields == nil {
+				fields = embeddedFields
+			}
+			rawRows = append(rawRows, rows...)
+		}
+	default:
+		return newJobError("invalid", "Unsupported source format %s", config.SourceFormat)
+	}
+	if fields == nil {
+		return newJobError("invalid", "No schema specified on job or table.")
+	}
+
+	// An existing table keeps its partitioning; a new one takes the job's
+	partitioned := data.Table{Fields: fields,
+		TimePartitioning: config.TimePartitioning, RangePartitioning: config.RangePartitioning}
+	if tableExists {
+		partitioned.TimePartitioning = table.TimePartitioning
+		partitioned.RangePartitioning = table.RangePartitioning
+	} else if err := partitioned.CheckPartitioning(); err != nil {
+		return newJobError("invalid", "%s", err)
+	} else if config.Clustering != nil && config.Clustering.Check(fields) != nil {
+		return newJobError("invalid", "%s", config.Clustering.Check(fields))
+	} else if partitionId != "" {
+		if err := partitioned.CheckPartitionId(partitionId); err != nil {
+			return newJobError("invalid", "%s", err)
+		}
+	}
+
+	newRows := []map[string]interface{}{}
+	var outputBytes int64
+	now := time.Now()
+	for i, rawRow := range rawRows {
+		newRow, fieldErrors := data.ConvertRow(fields, rawRow, config.IgnoreUnknownValues)
+		if len(fieldErrors) > 0 {
+			rowErrors = append(rowErrors, ErrorProto{
+				Reason: "invalid",
+				Message: fmt.Sprintf("Error while reading data, error message: %s; row %d, field: %s",
+					fieldErrors[0].Message, i+1, fieldErrors[0].Location),
+			})
+			continue
+		}
+		if err := partitioned.AssignPartition(newRow, partitionId, now); err != nil {
+			rowErrors = append(rowErrors, ErrorProto{
+				Reason:  "invalid",
+				Message: fmt.Sprintf("Error while reading data, error message: %s; row %d", err, i+1),
+			})
+			continue
+		}
+		newRows = append(newRows, newRow)
+		outputBytes += data.RowBytes(fields, newRow)
+	}
+
+	if len(rowErrors) > config.MaxBadRecords {
+		job.Errors = []ErrorProto{}
+		jobErr := newJobError("invalid",
+			"Error while reading data, error message: %s table encountered too many errors, giving up. Rows: %d; errors: %d. Please look into the errors[] collection for more details.",
+			formatDisplayName(sourceFormat), len(rawRows), len(rowErrors))
+		job.Errors = append(job.Errors, *jobErr)
+		for i, rowError := range rowErrors {
+			if i == MAX_REPORTED_ERRORS {
+				break
+			}
+			job.Errors = append(job.Errors, rowError)
+		}
+		return jobErr
+	}
+	job.Errors = rowErrors
+
+	nowMillis := data.NowMillis()
+	if !tableExists {
+		table = data.Table{
+			Rows:              []map[string]interface{}{},
+			CreationTime:      nowMillis,
+			ExpirationTime:    dataset.NewTableExpirationTime(nowMillis),
+			TimePartitioning:  config.TimePartitioning,
+			RangePartitioning: config.RangePartitioning,
+			Clustering:        config.Clustering,
+		}
+	}
+	if (config.WriteDisposition == "WRITE_TRUNCATE" && partitionId == "") || !tableExists || len(table.Fields) == 0 {
+		fieldsCopy := make([]data.Field, len(fields))
+		copy(fieldsCopy, fields)
+		table.Fields = fieldsCopy
+	}
+	if config.WriteDisposition == "WRITE_TRUNCATE" && partitionId != "" {
+		_, table.Rows = table.PartitionRows(partitionId)
+	} else if config.WriteDisposition == "WRITE_TRUNCATE" {
+		table.Rows = []map[string]interface{}{}
+	}
+	table.Rows = append(table.Rows, newRows...)
+	if !tableExists {
+		if err := app.useCreatedTable(destination.ProjectId); err != nil {
+			return err
+		}
+	}
+	table.LastModifiedTime = nowMillis
+	table.Version += 1
+	dataset.Tables[destination.TableId] = table
+
+	job.Statistics["load"] = map[string]string{
+		"inputFiles":     fmt.Sprintf("%d", len(sources)),
+		"inputFileBytes": fmt.Sprintf("%d", inputFileBytes),
+		"outputRows":     fmt.Sprintf("%d", len(newRows)),
+		"outputBytes":    fmt.Sprintf("%d", outputBytes),
+		"badRecords":     fmt.Sprintf("%d", len(rowErrors)),
+	}
+	return nil
+}
+
+func readCsvSources(sources [][]byte, config LoadConfiguration, fields []data.Field) (
+	[]data.Field, []map[string]interface{}, []ErrorProto, *ErrorProto) {
+
+	options := formats.CsvOptions{
+		FieldDelimiter:      config.FieldDelimiter,
+		Quote:               "\"",
+		AllowQuotedNewlines: config.AllowQuotedNewlines,
+	}
+	if config.Quote != nil {
+		options.Quote = *config.Quote
+	}
+
+	recordsBySource := [][][]formats.CsvField{}
+	for _, source := range sources {
+		sourceRecords, err := formats.ReadCsv(string(source), options)
+		if err != nil {
+			return nil, nil, nil, newJobError("invalid", "Error while reading data, error message: %s", err)
+		}
+		skip := config.SkipLeadingRows
+		if fields == nil && config.Autodetect && skip > 0 {
+			// Keep the last skipped row around to name the columns
+			skip -= 1
+		}
+		if skip > len(sourceRecords) {
+			skip = len(sourceRecords)
+		}
+		recordsBySource = append(recordsBySource, sourceRecords[skip:])
+	}
+
+	// Autodetect looks at the first file, and if it finds a header there,
+	// expects every file to start with one
+	dropHeader := false
+	if fields == nil && config.Autodetect && len(recordsBySource) > 0 {
+		var hasHeader bool
+		fields, hasHeader = formats.DetectCsvSchema(recordsBySource[0])
+		dropHeader = hasHeader || config.SkipLeadingRows > 0
+	}
+	if fields == nil {
+		return nil, nil, nil, newJobError("invalid", "No schema specified on job or table.")
+	}
+	// No row could fill such a field, so none is read
+	for _, field := range fields {
+		if data.NormalizeType(field.Type) == "RECORD" || data.NormalizeMode(field.Mode) == "REPEATED" {
+			return nil, nil, nil, newJobError("invalid", "CSV files can't hold RECORD or REPEATED field %s", field.Name)
+		}
+	}
+
+	records := [][]formats.CsvField{}
+	for _, sourceRecords := range recordsBySource {
+		if dropHeader && len(sourceRecords) > 0 {
+			sourceRecords = sourceRecords[1:]
+		}
+		records = append(records, sourceRecords...)
+	}
+
+	rows := []map[string]interface{}{}
+	rowErrors := []ErrorProto{}
+	for i, record := range records {
+		if len(record) < len(fields) && !config.AllowJaggedRows {
+			rowErrors = append(rowErrors, ErrorProto{
+				Reason: "invalid",
+				Message: fmt.Sprintf("Error while reading data, error message: CSV table references column position %d, but line %d contains only %d columns.",
+					len(fields)-1, i+1, len(record)),
+			})
+			continue
+		}
+		if len(record) > len(fields) && !config.IgnoreUnknownValues {
+			rowErrors = append(rowErrors, ErrorProto{
+				Reason: "invalid",
+				Message: fmt.Sprintf("Error while reading data, error message: Too many values in line %d: expected %d, found %d.",
+					i+1, len(fields), len(record)),
+			})
+			continue
+		}
+
+		row := map[string]interface{}{}
+		for j, field := range fields {
+			if j >= len(record) {
+				break
+			}
+			value := record[j]
+			if value.Value == config.NullMarker && !value.Quoted {
+				continue
+			}
+			row[field.Name] = value.Value
+		}
+		rows = append(rows, row)
+	}
+	return fields, rows, rowErrors, nil
+}
+
+func formatDisplayName(sourceFormat string) string {
+	if sourceFormat == "NEWLINE_DELIMITED_JSON" {
+		return "JSON"
+	}
+	return sourceFormat
+}
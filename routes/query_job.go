@@ -0,0 +1,262 @@
+package routes
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"fmt"
+	"strconv"
+
+	"github.com/danielstutzman/fake-bigquery/data"
+	"github.com/danielstutzman/fake-bigquery/queries"
+)
+
+// ANONYMOUS_TABLE_LIFETIME_MS is how long BigQuery keeps the anonymous
+// tables that hold query results without a destinationTable.
+const ANONYMOUS_TABLE_LIFETIME_MS = 24 * 60 * 60 * 1000
+
+// runQueryJob runs the job's query, in its session if it has one, and
+// writes a SELECT's results to its destinationTable, or to an anonymous
+// table in a hidden dataset.
+func (app *App) runQueryJob(job *Job, config Query1) *ErrorProto {
+	session, jobErr := app.querySession(job, config)
+	if jobErr != nil {
+		return jobErr
+	}
+	if session != nil {
+		job.Statistics["sessionInfo"] = map[string]string{"sessionId": session.Id}
+	}
+	if err := app.checkBytesBilled(job, config); err != nil {
+		return err
+	}
+	settings := app.queryConfig(job, config)
+	settings.Session = session
+	result, err := queries.ExecuteQuery(config.Query2, app.projects, job.ProjectId, settings)
+	app.endSession(session)
+	if result != nil && result.StatementType == "SCRIPT" {
+		app.addChildJobs(job, result.ChildJobs)
+		job.Statistics["query"] = queryStatistics(result)
+	}
+	if err != nil {
+		return queryJobError(err)
+	}
+	job.Statistics["query"] = queryStatistics(result)
+	job.Statistics["totalBytesProcessed"] = fmt.Sprintf("%d", result.TotalBytesProcessed)
+	if result.StatementType != "SELECT" {
+		app.queryResultByJobId[jobKey(job.ProjectId, job.JobId)] = *result
+		return nil
+	}
+
+	options := data.CopyOptions{
+		CreateDisposition: config.CreateDisposition,
+		WriteDisposition:  config.WriteDisposition,
+	}
+	if options.CreateDisposition == "" {
+		options.CreateDisposition = "CREATE_IF_NEEDED"
+	}
+	if options.WriteDisposition == "" {
+		options.WriteDisposition = "WRITE_EMPTY"
+	}
+
+	var destination data.TableRef
+	if config.DestinationTable != nil {
+		destination = tableRef(*config.DestinationTable, job.ProjectId)
+		options.CreateTable = app.chargeCreatedTable
+	} else if config.AllowLargeResults {
+		return newJobError("invalid", "allowLargeResults requires destinationTable to be set.")
+	} else {
+		destination = app.anonymousTable(job)
+		options.WriteDisposition = "WRITE_TRUNCATE"
+		options.ExpirationTime = data.NowMillis() + ANONYMOUS_TABLE_LIFETIME_MS
+	}
+
+	if err := data.WriteTable(app.projects, destination,
+		data.Table{Fields: result.Fields, Rows: result.Rows}, options); err != nil {
+		return newJobError(err.Reason, "%s", err.Message)
+	}
+	app.queryResultByJobId[jobKey(job.ProjectId, job.JobId)] = *result
+
+	queryConfiguration, _ := job.Configuration["query"].(map[string]interface{})
+	if queryConfiguration != nil {
+		queryConfiguration["destinationTable"] = map[string]string{
+			"projectId": destination.ProjectId,
+			"datasetId": destination.DatasetId,
+			"tableId":   destination.TableId,
+		}
+		queryConfiguration["createDisposition"] = options.CreateDisposition
+		queryConfiguration["writeDisposition"] = options.WriteDisposition
+	}
+	return nil
+}
+
+// queryJobError is the error of a job whose query failed, located in the
+// query when it's invalid.
+func queryJobError(err *data.Error) *ErrorProto {
+	jobErr := newJobError(err.Reason, "%s", err.Message)
+	if err.Reason == "invalidQuery" {
+		jobErr.Location = "query"
+	}
+	return jobErr
+}
+
+// addChildJobs registers the statements a script ran as jobs of their
+// own, which jobs.list lists given the script job's ID as parentJobId.
+func (app *App) addChildJobs(parent *Job, children []data.ChildJob) {
+	for i, child := range children {
+		job := &Job{
+			ProjectId: parent.ProjectId,
+			JobId:     fmt.Sprintf("script_job_%x_%d", md5.Sum([]byte(parent.ProjectId+":"+parent.JobId)), i),
+			Location:  parent.Location,
+			Configuration: map[string]interface{}{"query": map[string]interface{}{
+				"query":        child.Query,
+				"useLegacySql": false,
+				"priority":     "INTERACTIVE",
+			}},
+			State:        "DONE",
+			CreationTime: child.StartTime,
+			StartTime:    child.StartTime,
+			EndTime:      child.EndTime,
+			Statistics: map[string]interface{}{
+				"parentJobId": parent.JobId,
+				"scriptStatistics": map[string]interface{}{
+					"evaluationKind": child.EvaluationKind,
+					"stackFrames": []map[string]interface{}{{
+						"startLine":   child.StartLine,
+						"startColumn": child.StartColumn,
+						"endLine":     child.EndLine,
+						"endColumn":   child.EndColumn,
+						"text":        child.Query,
+					}},
+				},
+			},
+			UserEmail: parent.UserEmail,
+		}
+		if sessionInfo, ok := parent.Statistics["sessionInfo"]; ok {
+			job.Statistics["sessionInfo"] = sessionInfo
+		}
+		if child.Error != nil {
+			job.ErrorResult = queryJobError(child.Error)
+		} else {
+			job.Statistics["query"] = queryStatistics(child.Result)
+			job.Statistics["totalBytesProcessed"] = fmt.Sprintf("%d", child.Result.TotalBytesProcessed)
+			app.queryResultByJobId[jobKey(job.ProjectId, job.JobId)] = *child.Result
+		}
+		app.jobs[jobKey(job.ProjectId, job.JobId)] = job
+	}
+	parent.Statistics["numChildJobs"] = fmt.Sprintf("%d", len(children))
+}
+
+// checkBytesBilled fails a query that would bill more than its
+// maximumBytesBilled, which a dry run finds out before anything changes.
+func (app *App) checkBytesBilled(job *Job, config Query1) *ErrorProto {
+	if config.MaximumBytesBilled == "" {
+		return nil
+	}
+	maximum, parseErr := strconv.ParseInt(config.MaximumBytesBilled, 10, 64)
+	if parseErr != nil {
+		return newJobError("invalid", "Invalid value for maximumBytesBilled: %s", config.MaximumBytesBilled)
+	}
+	dryRun := app.queryConfig(job, config)
+	dryRun.DryRun = true
+	estimate, err := queries.ExecuteQuery(config.Query2, app.projects, job.ProjectId, dryRun)
+	if err != nil {
+		// the query itself reports the error when it runs
+		return nil
+	}
+	if estimate.TotalBytesBilled > maximum {
+		return newJobError("bytesBilledLimitExceeded",
+			"Query exceeded limit for bytes billed: %d. %d or higher required.", maximum, estimate.TotalBytesBilled)
+	}
+	return nil
+}
+
+// queryConfig gives the settings a query job's query runs with.
+func (app *App) queryConfig(job *Job, config Query1) queries.Config {
+	var defaultDataset *data.TableRef
+	if ref := config.DefaultDataset; ref != nil && ref.DatasetId != "" {
+		defaultDataset = &data.TableRef{ProjectId: ref.ProjectId, DatasetId: ref.DatasetId}
+		if defaultDataset.ProjectId == "" {
+			defaultDataset.ProjectId = job.ProjectId
+		}
+	}
+	return queries.Config{
+		UseLegacySql:   config.UseLegacySql == nil || *config.UseLegacySql,
+		DefaultDataset: defaultDataset,
+		ParameterMode:  config.ParameterMode,
+		Parameters:     config.QueryParameters,
+		Jobs:           app.jobInfos,
+		Session:        app.sessions[sessionId(config)],
+		CreateTable:    app.chargeCreatedTable,
+	}
+}
+
+// queryStatistics renders the statistics.query of a job that ran, or
+// dry ran, a query.
+func queryStatistics(result *data.Result) map[string]interface{} {
+	referencedTables := []map[string]string{}
+	for _, ref := range result.ReferencedTables {
+		referencedTables = append(referencedTables, map[string]string{
+			"projectId": ref.ProjectId,
+			"datasetId": ref.DatasetId,
+			"tableId":   ref.TableId,
+		})
+	}
+	queryStatistics := map[string]interface{}{
+		"statementType":       result.StatementType,
+		"totalBytesProcessed": fmt.Sprintf("%d", result.TotalBytesProcessed),
+		"totalBytesBilled":    fmt.Sprintf("%d", result.TotalBytesBilled),
+		"billingTier":         1,
+		"cacheHit":            false,
+		"referencedTables":    referencedTables,
+	}
+	if result.DmlStats != nil {
+		queryStatistics["numDmlAffectedRows"] = fmt.Sprintf("%d", result.DmlStats.AffectedRows())
+		queryStatistics["dmlStats"] = result.DmlStats.Json()
+	}
+	if result.DdlOperationPerformed != "" {
+		queryStatistics["ddlOperationPerformed"] = result.DdlOperationPerformed
+	}
+	if ref := result.DdlTargetTable; ref != nil {
+		queryStatistics["ddlTargetTable"] = map[string]string{
+			"projectId": ref.ProjectId,
+			"datasetId": ref.DatasetId,
+			"tableId":   ref.TableId,
+		}
+	}
+	if ref := result.DdlTargetDataset; ref != nil {
+		queryStatistics["ddlTargetDataset"] = map[string]string{
+			"projectId": ref.ProjectId,
+			"datasetId": ref.DatasetId,
+		}
+	}
+	return queryStatistics
+}
+
+// anonymousTable names a new table for a job's results in the project's
+// hidden "_"-prefixed dataset, creating that dataset if needed.
+func (app *App) anonymousTable(job *Job) data.TableRef {
+	project, projectOk := app.projects[job.ProjectId]
+	if !projectOk {
+		project = data.Project{
+			Datasets: map[string]data.Dataset{},
+		}
+		app.projects[job.ProjectId] = project
+	}
+
+	datasetName := fmt.Sprintf("_%x", sha1.Sum([]byte(job.ProjectId+":"+job.UserEmail)))
+	if _, datasetOk := project.Datasets[datasetName]; !datasetOk {
+		nowMillis := data.NowMillis()
+		project.Datasets[datasetName] = data.Dataset{
+			Tables:           map[string]data.Table{},
+			Location:         job.Location,
+			Access:           data.DEFAULT_ACCESS,
+			CreationTime:     nowMillis,
+			LastModifiedTime: nowMillis,
+		}
+	}
+
+	return data.TableRef{
+		ProjectId: job.ProjectId,
+		DatasetId: datasetName,
+		TableId:   "anon" + newRandomId(),
+	}
+}
@@ -0,0 +1,164 @@
+package routes
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// destinationJob is a query job selecting t's rows into d.x.
+func destinationJob(createDisposition, writeDisposition string) string {
+	return `{"configuration": {"query": {"query": "SELECT a FROM d.t", "useLegacySql": false,
+		"destinationTable": {"projectId": "p", "datasetId": "d", "tableId": "x"},
+		"createDisposition": "` + createDisposition + `", "writeDisposition": "` + writeDisposition + `"}}}`
+}
+
+func TestQueryDestination(t *testing.T) {
+	for _, test := range []struct {
+		dispositions [][2]string // create and write dispositions of each job
+		reason       string      // the error the last job fails with, if any
+		numRows      int         // in d.x afterwards
+	}{
+		{[][2]string{{"", ""}}, "", 3},
+		{[][2]string{{"", ""}, {"", ""}}, "duplicate", 3},
+		{[][2]string{{"", ""}, {"", "WRITE_APPEND"}}, "", 6},
+		{[][2]string{{"", ""}, {"", "WRITE_TRUNCATE"}}, "", 3},
+		{[][2]string{{"CREATE_NEVER", ""}}, "notFound", 0},
+	} {
+		app := testApp(Options{})
+		reason := ""
+		for _, dispositions := range test.dispositions {
+			reason = errorReason(t, decode(t, serve(app, "POST", "/bigquery/v2/projects/p/jobs",
+				destinationJob(dispositions[0], dispositions[1]))))
+		}
+		if reason != test.reason {
+			t.Errorf("%v failed with %q, want %q", test.dispositions, reason, test.reason)
+		}
+		if rows := app.projects["p"].Datasets["d"].Tables["x"].Rows; len(rows) != test.numRows {
+			t.Errorf("%v left %d rows, want %d", test.dispositions, len(rows), test.numRows)
+		}
+	}
+}
+
+func TestAnonymousDestination(t *testing.T) {
+	app := testApp(Options{})
+	body := decode(t, serve(app, "POST", "/bigquery/v2/projects/p/jobs", queryJob("SELECT a FROM d.t WHERE a > 1")))
+	if reason := errorReason(t, body); reason != "" {
+		t.Fatalf("query failed with %s", reason)
+	}
+	destination := body["configuration"].(map[string]interface{})["query"].(map[string]interface{})["destinationTable"].(map[string]interface{})
+	if datasetId := destination["datasetId"].(string); datasetId[0] != '_' {
+		t.Errorf("anonymous results are in dataset %s, want a hidden one", datasetId)
+	}
+
+	list := decode(t, serve(app, "GET", "/bigquery/v2/projects/p/datasets/"+destination["datasetId"].(string)+
+		"/tables/"+destination["tableId"].(string)+"/data", ""))
+	if rows, _ := list["rows"].([]interface{}); len(rows) != 2 {
+		t.Errorf("tabledata.list of the results gave %v, want 2 rows", list)
+	}
+
+	body = decode(t, serve(app, "POST", "/bigquery/v2/projects/p/jobs",
+		`{"configuration": {"query": {"query": "SELECT 1", "useLegacySql": false, "allowLargeResults": true}}}`))
+	if reason := errorReason(t, body); reason != "invalid" {
+		t.Errorf("allowLargeResults without destinationTable failed with %q, want invalid", reason)
+	}
+}
+
+func TestQueryParameters(t *testing.T) {
+	app := testApp(Options{})
+	body := decode(t, serve(app, "POST", "/bigquery/v2/projects/p/jobs", `{"configuration": {"query": {
+		"query": "SELECT b FROM d.t WHERE a = @a", "useLegacySql": false, "parameterMode": "NAMED",
+		"queryParameters": [{"name": "a", "parameterType": {"type": "INT64"}, "parameterValue": {"value": "3"}}]}}}`))
+	if reason := errorReason(t, body); reason != "" {
+		t.Fatalf("query failed with %s: %v", reason, body["status"])
+	}
+	jobId := body["jobReference"].(map[string]interface{})["jobId"].(string)
+	if rows := app.queryResultByJobId[jobKey("p", jobId)].Rows; len(rows) != 1 || rows[0]["b"] != "three" {
+		t.Errorf("query gave %v, want the row where a is 3", rows)
+	}
+}
+
+func TestDefaultDataset(t *testing.T) {
+	app := testApp(Options{})
+	body := decode(t, serve(app, "POST", "/bigquery/v2/projects/p/jobs", `{"configuration": {"query": {
+		"query": "SELECT COUNT(*) AS n FROM t", "useLegacySql": false,
+		"defaultDataset": {"projectId": "p", "datasetId": "d"}}}}`))
+	if reason := errorReason(t, body); reason != "" {
+		t.Fatalf("query failed with %s: %v", reason, body["status"])
+	}
+	jobId := body["jobReference"].(map[string]interface{})["jobId"].(string)
+	if rows := app.queryResultByJobId[jobKey("p", jobId)].Rows; len(rows) != 1 || rows[0]["n"] != int64(3) {
+		t.Errorf("query gave %v, want a count of 3", rows)
+	}
+}
+
+func TestBytesStatistics(t *testing.T) {
+	app := testApp(Options{})
+	w := serve(app, "POST", "/bigquery/v2/projects/p/datasets/d/tables", `{
+		"tableReference": {"projectId": "p", "datasetId": "d", "tableId": "c"},
+		"schema": {"fields": [{"name": "a", "type": "INTEGER"}, {"name": "b", "type": "STRING"}]},
+		"clustering": {"fields": ["b"]}}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("creating the table gave %d %s", w.Code, w.Body.String())
+	}
+	table := decode(t, serve(app, "GET", "/bigquery/v2/projects/p/datasets/d/tables/c", ""))
+	if clustering := table["clustering"]; !reflect.DeepEqual(clustering,
+		map[string]interface{}{"fields": []interface{}{"b"}}) {
+		t.Errorf("tables.get gave clustering %v, want fields [b]", clustering)
+	}
+
+	body := decode(t, serve(app, "POST", "/bigquery/v2/projects/p/jobs", queryJob("SELECT a FROM d.t")))
+	statistics := body["statistics"].(map[string]interface{})
+	query := statistics["query"].(map[string]interface{})
+	if statistics["totalBytesProcessed"] != "24" || query["totalBytesProcessed"] != "24" ||
+		query["totalBytesBilled"] != "10485760" {
+		t.Errorf("query gave statistics %v, want 24 bytes processed and 10 MB billed", statistics)
+	}
+}
+
+func TestJobsByProject(t *testing.T) {
+	app := testApp(Options{})
+	first := decode(t, serve(app, "POST", "/bigquery/v2/projects/p/jobs", queryJob("SELECT 1")))
+	firstId := first["jobReference"].(map[string]interface{})["jobId"].(string)
+	body := decode(t, serve(app, "POST", "/bigquery/v2/projects/p/jobs", queryJob(
+		"SELECT job_id, statement_type FROM `region-us`.INFORMATION_SCHEMA.JOBS_BY_PROJECT WHERE state = 'DONE'")))
+	if reason := errorReason(t, body); reason != "" {
+		t.Fatalf("query failed with %s: %v", reason, body["status"])
+	}
+	jobId := body["jobReference"].(map[string]interface{})["jobId"].(string)
+	rows := app.queryResultByJobId[jobKey("p", jobId)].Rows
+	if len(rows) != 1 || rows[0]["job_id"] != firstId || rows[0]["statement_type"] != "SELECT" {
+		t.Errorf("JOBS_BY_PROJECT gave %v, want just %s", rows, firstId)
+	}
+}
+
+func TestScriptChildJobs(t *testing.T) {
+	app := testApp(Options{})
+	body := decode(t, serve(app, "POST", "/bigquery/v2/projects/p/jobs",
+		queryJob("DECLARE x INT64 DEFAULT 2; SELECT a FROM d.t WHERE a = x; DELETE FROM d.t WHERE a = x")))
+	if reason := errorReason(t, body); reason != "" {
+		t.Fatalf("script failed with %s: %v", reason, body["status"])
+	}
+	statistics := body["statistics"].(map[string]interface{})["query"].(map[string]interface{})
+	if statistics["statementType"] != "SCRIPT" {
+		t.Errorf("script job has statementType %v, want SCRIPT", statistics["statementType"])
+	}
+
+	jobId := body["jobReference"].(map[string]interface{})["jobId"].(string)
+	list := decode(t, serve(app, "GET", "/bigquery/v2/projects/p/jobs?parentJobId="+jobId, ""))
+	children, _ := list["jobs"].([]interface{})
+	statementTypes := []string{}
+	for _, child := range children {
+		statistics := child.(map[string]interface{})["statistics"].(map[string]interface{})
+		if statistics["parentJobId"] != jobId {
+			t.Errorf("child job has parentJobId %v, want %s", statistics["parentJobId"], jobId)
+		}
+		statementTypes = append(statementTypes, statistics["query"].(map[string]interface{})["statementType"].(string))
+	}
+	if !reflect.DeepEqual(statementTypes, []string{"DELETE", "SELECT"}) {
+		t.Errorf("jobs.list gave child jobs of types %v, want DELETE then SELECT", statementTypes)
+	}
+	if list := decode(t, serve(app, "GET", "/bigquery/v2/projects/p/jobs", "")); len(list["jobs"].([]interface{})) != 1 {
+		t.Errorf("jobs.list gave %d top-level jobs, want just the script", len(list["jobs"].([]interface{})))
+	}
+}
@@ -0,0 +1,114 @@
+package routes
+
+import (
+	"sync"
+	"time"
+
+	"github.com/danielstutzman/fake-bigquery/data"
+)
+
+const QUOTA_ERROR_HELP = "For more information, see https://cloud.google.com/bigquery/docs/troubleshoot-quotas"
+
+// quotas tracks what each project has used of the limits in Options.
+// It has a lock of its own, since query jobs count as running before they
+// take App's.
+type quotas struct {
+	mutex          sync.Mutex
+	runningQueries map[string]int
+	insertedRows   map[string][]usage // in the last second
+	createdTables  map[string][]usage // in the last day
+}
+
+// usage is an amount of a quota used at a time.
+type usage struct {
+	time  time.Time
+	count int
+}
+
+func newQuotas() *quotas {
+	return &quotas{
+		runningQueries: map[string]int{},
+		insertedRows:   map[string][]usage{},
+		createdTables:  map[string][]usage{},
+	}
+}
+
+// startQuery counts a query job as running until finishQuery, unless the
+// project already has MaxConcurrentQueries running.
+func (app *App) startQuery(projectName string) *ErrorProto {
+	app.quotas.mutex.Lock()
+	defer app.quotas.mutex.Unlock()
+	limit := app.options.MaxConcurrentQueries
+	if limit > 0 && app.quotas.runningQueries[projectName] >= limit {
+		return newJobError("rateLimitExceeded",
+			"Exceeded rate limits: too many concurrent queries for this project_and_region. %s", QUOTA_ERROR_HELP)
+	}
+	app.quotas.runningQueries[projectName] += 1
+	return nil
+}
+
+func (app *App) finishQuery(projectName string) {
+	app.quotas.mutex.Lock()
+	defer app.quotas.mutex.Unlock()
+	app.quotas.runningQueries[projectName] -= 1
+}
+
+// useInsertedRows counts rows streamed into a project against
+// MaxInsertRowsPerSecond.
+func (app *App) useInsertedRows(projectName string, count int) *ErrorProto {
+	if !app.quotas.use(app.quotas.insertedRows, projectName, count,
+		app.options.MaxInsertRowsPerSecond, time.Second) {
+		return newJobError("rateLimitExceeded",
+			"Exceeded rate limits: too many rows per second for tabledata.insertAll in project %s. %s",
+			projectName, QUOTA_ERROR_HELP)
+	}
+	return nil
+}
+
+// useCreatedTable counts a table created in a project against
+// MaxTableCreationsPerDay.
+func (app *App) useCreatedTable(projectName string) *ErrorProto {
+	if !app.quotas.use(app.quotas.createdTables, projectName, 1,
+		app.options.MaxTableCreationsPerDay, 24*time.Hour) {
+		return newJobError("quotaExceeded",
+			"Quota exceeded: Your project exceeded quota for tables created per day. %s", QUOTA_ERROR_HELP)
+	}
+	return nil
+}
+
+// chargeCreatedTable is useCreatedTable for the table a job or statement
+// is about to add.
+func (app *App) chargeCreatedTable(ref data.TableRef) *data.Error {
+	if err := app.useCreatedTable(ref.ProjectId); err != nil {
+		return &data.Error{Reason: err.Reason, Message: err.Message}
+	}
+	return nil
+}
+
+// use records count more of a quota used by a project, unless that would
+// take its use within the last period over limit. A limit of 0 means
+// there's no limit.
+func (quotas *quotas) use(used map[string][]usage, projectName string, count, limit int,
+	period time.Duration) bool {
+
+	quotas.mutex.Lock()
+	defer quotas.mutex.Unlock()
+	if limit <= 0 {
+		return true
+	}
+	now := time.Now()
+	recent := []usage{}
+	total := count
+	for _, u := range used[projectName] {
+		if now.Sub(u.time) < period {
+			recent = append(recent, u)
+			total += u.count
+		}
+	}
+	if total > limit {
+		used[projectName] = recent
+		return false
+	}
+	used[projectName] = append(recent, usage{now, count})
+	return true
+}
@@ -0,0 +1,67 @@
+package routes
+
+import (
+	"testing"
+)
+
+// errorReason is the reason a request failed, or its job failed, for.
+func errorReason(t *testing.T, body map[string]interface{}) string {
+	t.Helper()
+	if err, ok := body["error"].(map[string]interface{}); ok {
+		return err["errors"].([]interface{})[0].(map[string]interface{})["reason"].(string)
+	}
+	if status, ok := body["status"].(map[string]interface{}); ok {
+		if errorResult, ok := status["errorResult"].(map[string]interface{}); ok {
+			return errorResult["reason"].(string)
+		}
+	}
+	return ""
+}
+
+func queryJob(query string) string {
+	return `{"configuration": {"query": {"query": "` + query + `", "useLegacySql": false}}}`
+}
+
+func TestTableCreationQuota(t *testing.T) {
+	const jobs = "/bigquery/v2/projects/p/jobs"
+	const insertAll = "/bigquery/v2/projects/p/datasets/d/tables/t/insertAll"
+	for _, test := range []struct {
+		name     string
+		requests [][2]string // path and body; all but the last create a table
+	}{
+		{"insertAll", [][2]string{
+			{insertAll, `{"templateSuffix": "_1", "rows": [{"json": {"a": 1}}]}`},
+			{insertAll, `{"templateSuffix": "_2", "rows": [{"json": {"a": 2}}]}`},
+		}},
+		{"DDL", [][2]string{
+			{jobs, queryJob("CREATE TABLE d.x (a INT64)")},
+			{jobs, queryJob("CREATE VIEW d.v AS SELECT 1 AS a")},
+		}},
+		{"copy", [][2]string{
+			{jobs, `{"configuration": {"copy": {"sourceTable": {"projectId": "p", "datasetId": "d", "tableId": "t"},
+				"destinationTable": {"projectId": "p", "datasetId": "d", "tableId": "x"}}}}`},
+			{jobs, `{"configuration": {"copy": {"sourceTable": {"projectId": "p", "datasetId": "d", "tableId": "t"},
+				"destinationTable": {"projectId": "p", "datasetId": "d", "tableId": "y"}}}}`},
+		}},
+		{"destinationTable", [][2]string{
+			{jobs, `{"configuration": {"query": {"query": "SELECT 1 AS a", "useLegacySql": false,
+				"destinationTable": {"projectId": "p", "datasetId": "d", "tableId": "x"}}}}`},
+			{jobs, `{"configuration": {"query": {"query": "SELECT 1 AS a", "useLegacySql": false,
+				"destinationTable": {"projectId": "p", "datasetId": "d", "tableId": "y"}}}}`},
+		}},
+	} {
+		app := testApp(Options{MaxTableCreationsPerDay: 1})
+		for i, request := range test.requests {
+			reason := errorReason(t, decode(t, serve(app, "POST", request[0], request[1])))
+			last := i == len(test.requests)-1
+			if !last && reason != "" {
+				t.Errorf("%s: request %d failed with %s", test.name, i, reason)
+			} else if last && reason != "quotaExceeded" {
+				t.Errorf("%s: request %d failed with %q, want quotaExceeded", test.name, i, reason)
+			}
+		}
+		if tables := app.projects["p"].Datasets["d"].Tables; len(tables) != len(test.requests) {
+			t.Errorf("%s: dataset holds %d tables, want %d", test.name, len(tables), len(test.requests))
+		}
+	}
+}
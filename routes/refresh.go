@@ -0,0 +1,29 @@
+package routes
+
+import (
+	"log"
+
+	"github.com/danielstutzman/fake-bigquery/data"
+	"github.com/danielstutzman/fake-bigquery/queries"
+)
+
+// refreshMaterializedViews recomputes every materialized view with
+// automatic refresh enabled whose refresh interval has passed since it
+// was last refreshed. Like dropExpiredTables, it runs before each request.
+func (app *App) refreshMaterializedViews() {
+	nowMillis := data.NowMillis()
+	for projectName, project := range app.projects {
+		for datasetName, dataset := range project.Datasets {
+			for tableName, table := range dataset.Tables {
+				view := table.MaterializedView
+				if view == nil || !view.EnableRefresh || view.LastRefreshTime+view.RefreshIntervalMs > nowMillis {
+					continue
+				}
+				ref := data.TableRef{ProjectId: projectName, DatasetId: datasetName, TableId: tableName}
+				if err := queries.RefreshMaterializedView(app.projects, ref); err != nil {
+					log.Printf("Refreshing materialized view %s failed: %s", ref, err.Message)
+				}
+			}
+		}
+	}
+}
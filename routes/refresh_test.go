@@ -0,0 +1,41 @@
+package routes
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestMaterializedViewRefresh(t *testing.T) {
+	for _, test := range []struct {
+		definition string
+		sum        int64 // that the view holds after a row is added to t
+	}{
+		{`"enableRefresh": true, "refreshIntervalMs": "1"`, 10},
+		{`"enableRefresh": false, "refreshIntervalMs": "1"`, 6},
+		{`"enableRefresh": true`, 6}, // within the default interval
+	} {
+		app := testApp(Options{})
+		w := serve(app, "POST", "/bigquery/v2/projects/p/datasets/d/tables", `{
+			"tableReference": {"projectId": "p", "datasetId": "d", "tableId": "mv"},
+			"materializedView": {"query": "SELECT SUM(a) AS s FROM d.t", `+test.definition+`}}`)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: creating the view gave %d %s", test.definition, w.Code, w.Body.String())
+			continue
+		}
+		created := app.projects["p"].Datasets["d"].Tables["mv"].MaterializedView.LastRefreshTime
+		serve(app, "POST", "/bigquery/v2/projects/p/datasets/d/tables/t/insertAll", `{"rows": [{"json": {"a": 4}}]}`)
+
+		time.Sleep(5 * time.Millisecond)
+		table := decode(t, serve(app, "GET", "/bigquery/v2/projects/p/datasets/d/tables/mv", ""))
+		view := app.projects["p"].Datasets["d"].Tables["mv"]
+		if rows := view.Rows; len(rows) != 1 || rows[0]["s"] != test.sum {
+			t.Errorf("%s: view holds %v, want a sum of %d", test.definition, rows, test.sum)
+		}
+		refreshed := view.MaterializedView.LastRefreshTime != created
+		if refreshed != (test.sum == 10) {
+			t.Errorf("%s: lastRefreshTime is %v, created at %d", test.definition,
+				table["materializedView"].(map[string]interface{})["lastRefreshTime"], created)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package routes
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/danielstutzman/fake-bigquery/data"
+)
+
+// datasetResource renders a dataset the way datasets.get returns it.
+func datasetResource(projectName, datasetName string, dataset data.Dataset) map[string]interface{} {
+	resource := map[string]interface{}{
+		"kind":     "bigquery#dataset",
+		"id":       fmt.Sprintf("%s:%s", projectName, datasetName),
+		"selfLink": fmt.Sprintf("https://www.googleapis.com/bigquery/v2/projects/%s/datasets/%s", projectName, datasetName),
+		"datasetReference": map[string]string{
+			"projectId": projectName,
+			"datasetId": datasetName,
+		},
+		"location":         dataset.Location,
+		"access":           dataset.Access,
+		"creationTime":     fmt.Sprintf("%d", dataset.CreationTime),
+		"lastModifiedTime": fmt.Sprintf("%d", dataset.LastModifiedTime),
+	}
+	if dataset.Description != "" {
+		resource["description"] = dataset.Description
+	}
+	if dataset.FriendlyName != "" {
+		resource["friendlyName"] = dataset.FriendlyName
+	}
+	if len(dataset.Labels) > 0 {
+		resource["labels"] = dataset.Labels
+	}
+	if dataset.DefaultTableExpirationMs != 0 {
+		resource["defaultTableExpirationMs"] = fmt.Sprintf("%d", dataset.DefaultTableExpirationMs)
+	}
+	if dataset.DefaultPartitionExpirationMs != 0 {
+		resource["defaultPartitionExpirationMs"] = fmt.Sprintf("%d", dataset.DefaultPartitionExpirationMs)
+	}
+	resource["etag"] = etagOf(resource)
+	return resource
+}
+
+// tableResource renders a table the way tables.get returns it, with live
+// row and byte counts and an etag that changes whenever any of them do.
+func tableResource(projectName, datasetName, tableName string, table data.Table) map[string]interface{} {
+	// Like BigQuery's, the counts leave out rows still in the streaming buffer
+	numBytes, numRows := data.TableBytes(table), int64(len(table.Rows))
+	if buffer := table.StreamingBuffer; buffer != nil {
+		numBytes, numRows = nonNegative(numBytes-buffer.EstimatedBytes), nonNegative(numRows-buffer.EstimatedRows)
+	}
+	resource := map[string]interface{}{
+		"kind":     "bigquery#table",
+		"id":       fmt.Sprintf("%s:%s.%s", projectName, datasetName, tableName),
+		"selfLink": fmt.Sprintf("https://www.googleapis.com/bigquery/v2/projects/%s/datasets/%s/tables/%s", projectName, datasetName, tableName),
+		"tableReference": map[string]string{
+			"projectId": projectName,
+			"datasetId": datasetName,
+			"tableId":   tableName,
+		},
+		"schema": map[string]interface{}{
+			"fields": schemaFields(table.Fields),
+		},
+		"numBytes":         fmt.Sprintf("%d", numBytes),
+		"numLongTermBytes": "0",
+		"numRows":          fmt.Sprintf("%d", numRows),
+		"creationTime":     fmt.Sprintf("%d", table.CreationTime),
+		"lastModifiedTime": fmt.Sprintf("%d", table.LastModifiedTime),
+		"type":             table.Type(),
+	}
+	if table.Snapshot != nil {
+		resource["snapshotDefinition"] = map[string]interface{}{
+			"baseTableReference": baseTableReference(*table.Snapshot),
+			"snapshotTime":       formatMillis(table.Snapshot.Time),
+		}
+	}
+	if table.Clone != nil {
+		resource["cloneDefinition"] = map[string]interface{}{
+			"baseTableReference": baseTableReference(*table.Clone),
+			"cloneTime":          formatMillis(table.Clone.Time),
+		}
+	}
+	if table.View != nil {
+		resource["view"] = table.View
+	}
+	if view := table.MaterializedView; view != nil {
+		resource["materializedView"] = map[string]interface{}{
+			"query":             view.Query,
+			"enableRefresh":     view.EnableRefresh,
+			"refreshIntervalMs": fmt.Sprintf("%d", view.RefreshIntervalMs),
+			"lastRefreshTime":   fmt.Sprintf("%d", view.LastRefreshTime),
+		}
+	}
+	if table.Description != "" {
+		resource["description"] = table.Description
+	}
+	if len(table.Labels) > 0 {
+		resource["labels"] = table.Labels
+	}
+	if table.ExpirationTime != 0 {
+		resource["expirationTime"] = fmt.Sprintf("%d", table.ExpirationTime)
+	}
+	if table.TimePartitioning != nil {
+		resource["timePartitioning"] = table.TimePartitioning
+	}
+	if table.RangePartitioning != nil {
+		resource["rangePartitioning"] = table.RangePartitioning
+	}
+	if table.RequirePartitionFilter {
+		resource["requirePartitionFilter"] = true
+	}
+	if table.Clustering != nil {
+		resource["clustering"] = table.Clustering
+	}
+	if table.StreamingBuffer != nil {
+		resource["streamingBuffer"] = map[string]string{
+			"estimatedRows":   fmt.Sprintf("%d", table.StreamingBuffer.EstimatedRows),
+			"estimatedBytes":  fmt.Sprintf("%d", table.StreamingBuffer.EstimatedBytes),
+			"oldestEntryTime": fmt.Sprintf("%d", table.StreamingBuffer.OldestEntryTime),
+		}
+	}
+	resource["etag"] = etagOf(resource)
+	return resource
+}
+
+// schemaFields gives fields as BigQuery reports them, with NULLABLE for
+// an unset mode.
+func schemaFields(fields []data.Field) []data.Field {
+	reported := make([]data.Field, len(fields))
+	for i, field := range fields {
+		reported[i] = field
+		reported[i].Mode = data.NormalizeMode(field.Mode)
+		if len(field.Fields) > 0 {
+			reported[i].Fields = schemaFields(field.Fields)
+		}
+	}
+	return reported
+}
+
+func nonNegative(n int64) int64 {
+	if n < 0 {
+		return 0
+	}
+	return n
+}
+
+func baseTableReference(base data.BaseTable) map[string]string {
+	return map[string]string{
+		"projectId": base.ProjectId,
+		"datasetId": base.DatasetId,
+		"tableId":   base.TableId,
+	}
+}
+
+// formatMillis renders milliseconds since epoch as an RFC 3339 timestamp,
+// the format of snapshotTime and cloneTime.
+func formatMillis(millis int64) string {
+	return time.Unix(0, millis*int64(time.Millisecond)).UTC().Format("2006-01-02T15:04:05.000Z")
+}
+
+// etagOf hashes a resource's JSON, so that the etag changes exactly when
+// the resource does.
+func etagOf(resource map[string]interface{}) string {
+	resourceJson, err := json.Marshal(resource)
+	if err != nil {
+		log.Fatalf("Error from Marshal: %v", err)
+	}
+	hash := sha1.Sum(resourceJson)
+	return base64.StdEncoding.EncodeToString(hash[:12])
+}
@@ -4,29 +4,69 @@ import (
 	"log"
 	"net/http"
 	"regexp"
+	"sync"
+	"time"
 
 	"github.com/danielstutzman/fake-bigquery/data"
+	"github.com/danielstutzman/fake-bigquery/queries"
 )
 
 var DATASETS_REGEXP = regexp.MustCompile("^(/bigquery/v2)?/projects/([^/]*)/datasets$")
 var DATASET_REGEXP = regexp.MustCompile("^(/bigquery/v2)?/projects/([^/]*)/datasets/([^/]*)$")
 var TABLES_REGEXP = regexp.MustCompile("^(/bigquery/v2)?/projects/([^/]*)/datasets/([^/]*)/tables$")
 var TABLE_REGEXP = regexp.MustCompile("^(/bigquery/v2)?/projects/([^/]*)/datasets/([^/]*)/tables/([^/]*)$")
+var TABLE_DATA_REGEXP = regexp.MustCompile("^(/bigquery/v2)?/projects/([^/]*)/datasets/([^/]*)/tables/([^/]*)/data$")
 var JOBS_REGEXP = regexp.MustCompile("^(/bigquery/v2)?/projects/([^/]*)/jobs$")
+var JOB_REGEXP = regexp.MustCompile("^(/bigquery/v2)?/projects/([^/]*)/jobs/([^/]*)$")
+var UPLOAD_JOBS_REGEXP = regexp.MustCompile("^/upload/bigquery/v2/projects/([^/]*)/jobs$")
 var QUERY_REGEXP = regexp.MustCompile("^(/bigquery/v2)?/projects/([^/]*)/queries/([^/]*)$")
 var INSERT_REGEXP = regexp.MustCompile("^(/bigquery/v2)?/projects/([^/]*)/datasets/([^/]*)/tables/([^/]*)/insertAll")
 
+type Options struct {
+	// How long an insertId is remembered for deduplicating streamed rows,
+	// or 0 to disable deduplication
+	DedupWindow time.Duration
+	// Fraction (0 to 1) of duplicate rows to let through anyway
+	DedupLeakRate float64
+	// Directory standing in for Cloud Storage: gs://bucket/path maps to
+	// GcsDir/bucket/path
+	GcsDir string
+	// Per-project quotas, each 0 for no limit: query jobs running at once,
+	// rows streamed per second, and tables created with tables.insert per
+	// day
+	MaxConcurrentQueries    int
+	MaxInsertRowsPerSecond  int
+	MaxTableCreationsPerDay int
+	// How long streamed rows stay in their table's streaming buffer
+	StreamingBufferFlush time.Duration
+}
+
+// App holds the emulator's state, which requests take turns with, each
+// holding mutex while it runs.
 type App struct {
+	mutex              sync.Mutex
 	discoveryJson      []byte
+	options            Options
 	projects           map[string]data.Project
-	queryResultByJobId map[string]data.Result
+	queryResultByJobId map[string]data.Result    // by jobKey
+	recentInsertIds    map[string]*recentInserts // by table
+	jobs               map[string]*Job
+	resumableUploads   map[string]*resumableUpload
+	quotas             *quotas
+	sessions           map[string]*queries.Session
 }
 
-func NewApp(discoveryJson []byte) *App {
+func NewApp(discoveryJson []byte, options Options) *App {
 	return &App{
 		discoveryJson:      discoveryJson,
+		options:            options,
 		projects:           map[string]data.Project{},
 		queryResultByJobId: map[string]data.Result{},
+		recentInsertIds:    map[string]*recentInserts{},
+		jobs:               map[string]*Job{},
+		resumableUploads:   map[string]*resumableUpload{},
+		quotas:             newQuotas(),
+		sessions:           map[string]*queries.Session{},
 	}
 }
 
@@ -34,6 +74,14 @@ func (app *App) Route(w http.ResponseWriter, r *http.Request) {
 	path := r.URL.Path
 	log.Printf("Incoming path: %s", path)
 
+	if match := JOBS_REGEXP.FindStringSubmatch(path); match != nil && r.Method == "POST" {
+		// it locks once a query job counts as running
+		app.createJob(w, r, match[2])
+		return
+	}
+	app.lock()
+	defer app.mutex.Unlock()
+
 	if path == "/discovery/v1/apis/bigquery/v2/rest" {
 		w.Write(app.discoveryJson)
 	} else if match := DATASET_REGEXP.FindStringSubmatch(path); match != nil {
@@ -41,8 +89,14 @@ func (app *App) Route(w http.ResponseWriter, r *http.Request) {
 		dataset := match[3]
 		if r.Method == "GET" {
 			app.checkDatasetExistence(w, r, project, dataset)
+		} else if r.Method == "DELETE" {
+			app.deleteDataset(w, r, project, dataset)
+		} else if r.Method == "PATCH" {
+			app.updateDataset(w, r, project, dataset, false)
+		} else if r.Method == "PUT" {
+			app.updateDataset(w, r, project, dataset, true)
 		} else {
-			log.Fatalf("Unexpected method: %s", r.Method)
+			writeError(w, http.StatusMethodNotAllowed, "invalid", "Unexpected method: "+r.Method)
 		}
 	} else if match := DATASETS_REGEXP.FindStringSubmatch(path); match != nil {
 		project := match[2]
@@ -51,7 +105,7 @@ func (app *App) Route(w http.ResponseWriter, r *http.Request) {
 		} else if r.Method == "POST" {
 			app.createDataset(w, r, project)
 		} else {
-			log.Fatalf("Unexpected method: %s", r.Method)
+			writeError(w, http.StatusMethodNotAllowed, "invalid", "Unexpected method: "+r.Method)
 		}
 	} else if match := TABLES_REGEXP.FindStringSubmatch(path); match != nil {
 		project := match[2]
@@ -61,7 +115,7 @@ func (app *App) Route(w http.ResponseWriter, r *http.Request) {
 		} else if r.Method == "POST" {
 			app.createTable(w, r, project, dataset)
 		} else {
-			log.Fatalf("Unexpected method: %s", r.Method)
+			writeError(w, http.StatusMethodNotAllowed, "invalid", "Unexpected method: "+r.Method)
 		}
 	} else if match := TABLE_REGEXP.FindStringSubmatch(path); match != nil {
 		project := match[2]
@@ -69,16 +123,42 @@ func (app *App) Route(w http.ResponseWriter, r *http.Request) {
 		table := match[4]
 		if r.Method == "GET" {
 			app.checkTableExistence(w, r, project, dataset, table)
+		} else if r.Method == "DELETE" {
+			app.deleteTable(w, r, project, dataset, table)
+		} else if r.Method == "PATCH" {
+			app.updateTable(w, r, project, dataset, table, false)
+		} else if r.Method == "PUT" {
+			app.updateTable(w, r, project, dataset, table, true)
+		} else {
+			writeError(w, http.StatusMethodNotAllowed, "invalid", "Unexpected method: "+r.Method)
+		}
+	} else if match := TABLE_DATA_REGEXP.FindStringSubmatch(path); match != nil {
+		project := match[2]
+		dataset := match[3]
+		table := match[4]
+		if r.Method == "GET" {
+			app.listTableData(w, r, project, dataset, table)
 		} else {
-			log.Fatalf("Unexpected method: %s", r.Method)
+			writeError(w, http.StatusMethodNotAllowed, "invalid", "Unexpected method: "+r.Method)
 		}
 	} else if match := JOBS_REGEXP.FindStringSubmatch(path); match != nil {
 		project := match[2]
-		if r.Method == "POST" {
-			app.createJob(w, r, project)
+		if r.Method == "GET" {
+			app.listJobs(w, r, project)
 		} else {
-			log.Fatalf("Unexpected method: %s", r.Method)
+			writeError(w, http.StatusMethodNotAllowed, "invalid", "Unexpected method: "+r.Method)
 		}
+	} else if match := JOB_REGEXP.FindStringSubmatch(path); match != nil {
+		project := match[2]
+		jobId := match[3]
+		if r.Method == "GET" {
+			app.getJob(w, r, project, jobId)
+		} else {
+			writeError(w, http.StatusMethodNotAllowed, "invalid", "Unexpected method: "+r.Method)
+		}
+	} else if match := UPLOAD_JOBS_REGEXP.FindStringSubmatch(path); match != nil {
+		project := match[1]
+		app.uploadJob(w, r, project)
 	} else if match := QUERY_REGEXP.FindStringSubmatch(path); match != nil {
 		project := match[2]
 		jobId := match[3]
@@ -89,6 +169,16 @@ func (app *App) Route(w http.ResponseWriter, r *http.Request) {
 		table := match[4]
 		app.insertRows(w, r, project, dataset, table)
 	} else {
-		log.Fatalf("Don't know how to serve path %s", r.URL.Path)
+		writeError(w, http.StatusNotFound, "notFound", "Not found: URL "+r.URL.Path)
 	}
 }
+
+// lock waits for the requests before to finish, then drops and refreshes
+// what has expired since.
+func (app *App) lock() {
+	app.mutex.Lock()
+	app.dropExpiredTables()
+	app.dropExpiredPartitions()
+	app.flushStreamingBuffers()
+	app.refreshMaterializedViews()
+}
@@ -0,0 +1,67 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/danielstutzman/fake-bigquery/data"
+)
+
+// testApp gives an App whose project p holds d.t, with columns a and b.
+func testApp(options Options) *App {
+	app := NewApp(nil, options)
+	app.projects["p"] = data.Project{Datasets: map[string]data.Dataset{
+		"d": {Tables: map[string]data.Table{"t": {
+			Fields: []data.Field{
+				{Name: "a", Type: "INTEGER", Mode: "NULLABLE"},
+				{Name: "b", Type: "STRING", Mode: "NULLABLE"},
+			},
+			Rows: []map[string]interface{}{
+				{"a": int64(1), "b": "one"},
+				{"a": int64(2), "b": "two"},
+				{"a": int64(3), "b": "three"},
+			},
+		}}},
+	}}
+	return app
+}
+
+// serve sends one request through Route and returns its response.
+func serve(app *App, method, path, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	app.Route(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+	return w
+}
+
+// decode parses a response body, failing the test if it isn't JSON.
+func decode(t *testing.T, w *httptest.ResponseRecorder) map[string]interface{} {
+	t.Helper()
+	var body map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("response %s isn't JSON: %s", w.Body.String(), err)
+	}
+	return body
+}
+
+func TestRouteErrors(t *testing.T) {
+	app := NewApp(nil, Options{})
+	for _, test := range []struct {
+		method string
+		path   string
+		code   int
+		reason string
+	}{
+		{"POST", "/bigquery/v2/projects/p/datasets/d", http.StatusMethodNotAllowed, `"reason": "invalid"`},
+		{"DELETE", "/bigquery/v2/projects/p/datasets/d/tables/t/data", http.StatusMethodNotAllowed, `"reason": "invalid"`},
+		{"GET", "/bigquery/v2/nowhere", http.StatusNotFound, `"reason": "notFound"`},
+	} {
+		w := serve(app, test.method, test.path, "")
+		if w.Code != test.code || !strings.Contains(w.Body.String(), test.reason) {
+			t.Errorf("%s %s gave %d %s, want %d with %s",
+				test.method, test.path, w.Code, w.Body.String(), test.code, test.reason)
+		}
+	}
+}
@@ -5,21 +5,52 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+
+	"github.com/danielstutzman/fake-bigquery/data"
 )
 
+// serveQuery serves jobs.getQueryResults, a page at a time, or the error
+// of a job that failed.
 func (app *App) serveQuery(w http.ResponseWriter, r *http.Request, projectName, jobId string) {
-	fields := app.queryResultByJobId[jobId].Fields
+	job, jobOk := app.jobs[jobKey(projectName, jobId)]
+	if !jobOk {
+		writeError(w, http.StatusNotFound, "notFound", fmt.Sprintf("Not found: Job %s:%s", projectName, jobId))
+		return
+	} else if job.ErrorResult != nil {
+		writeError(w, errorCode(job.ErrorResult.Reason), job.ErrorResult.Reason, job.ErrorResult.Message)
+		return
+	}
+	result := app.queryResultByJobId[jobKey(projectName, jobId)]
+	startIndex, endIndex, ok := pageRange(w, r.URL.Query(), len(result.Rows))
+	if !ok {
+		return
+	}
+	fields := result.Fields
 	fieldsJson, err := json.Marshal(fields)
 	if err != nil {
 		log.Fatalf("Error from Marshal: %s", err)
 	}
 
-	rows := app.queryResultByJobId[jobId].Rows
+	rows := []data.ResultRow{}
+	for _, row := range result.Rows[startIndex:endIndex] {
+		rows = append(rows, data.EncodeRow(fields, row))
+	}
 	rowsJson, err := json.Marshal(rows)
 	if err != nil {
 		log.Fatalf("Error from Marshal: %s", err)
 	}
 
+	dmlJson := ""
+	if result.DmlStats != nil {
+		statsJson, err := json.Marshal(result.DmlStats.Json())
+		if err != nil {
+			log.Fatalf("Error from Marshal: %s", err)
+		}
+		dmlJson = fmt.Sprintf(`
+		"numDmlAffectedRows": "%d",
+		"dmlStats": %s,`, result.DmlStats.AffectedRows(), statsJson)
+	}
+
 	fmt.Fprintf(w, `{
 		"kind": "bigquery#getQueryResultsResponse",
 		"etag": "\"cX5UmbB_R-S07ii743IKGH9YCYM/wLFL5h11OCxiWY3yDLqREwltkXs\"",
@@ -30,10 +61,12 @@ func (app *App) serveQuery(w http.ResponseWriter, r *http.Request, projectName,
 			"projectId": "%s",
 			"jobId": "%s"
 		},
-		"totalRows": "1",
-		"rows": %s,
-		"totalBytesProcessed": "0",
+		"totalRows": "%d",
+		%s
+		"rows": %s,%s
+		"totalBytesProcessed": "%d",
 		"jobComplete": true,
-		"cacheHit": true
-	}`, fieldsJson, projectName, jobId, rowsJson)
+		"cacheHit": false
+	}`, fieldsJson, projectName, jobId, len(result.Rows), pageTokenJson(endIndex, len(result.Rows)), rowsJson,
+		dmlJson, result.TotalBytesProcessed)
 }
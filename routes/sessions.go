@@ -0,0 +1,50 @@
+package routes
+
+import (
+	"github.com/danielstutzman/fake-bigquery/queries"
+)
+
+type ConnectionProperty struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// sessionId is the session_id connection property of a query job, or ""
+// if it has none.
+func sessionId(config Query1) string {
+	for _, property := range config.ConnectionProperties {
+		if property.Key == "session_id" {
+			return property.Value
+		}
+	}
+	return ""
+}
+
+// querySession gives the session a query job runs in: a new one with
+// createSession, or the one its session_id connection property names.
+// It's nil for a job outside of any session.
+func (app *App) querySession(job *Job, config Query1) (*queries.Session, *ErrorProto) {
+	id := sessionId(config)
+	if config.CreateSession {
+		if id != "" {
+			return nil, newJobError("invalid", "Cannot set createSession and the session_id connection property together")
+		}
+		session := queries.NewSession(job.ProjectId)
+		app.sessions[session.Id] = session
+		return session, nil
+	} else if id == "" {
+		return nil, nil
+	}
+	session, sessionOk := app.sessions[id]
+	if !sessionOk || session.ProjectId != job.ProjectId {
+		return nil, newJobError("notFound", "Not found: Session %s", id)
+	}
+	return session, nil
+}
+
+// endSession forgets a session its last query terminated.
+func (app *App) endSession(session *queries.Session) {
+	if session != nil && session.Terminated {
+		delete(app.sessions, session.Id)
+	}
+}
@@ -0,0 +1,37 @@
+package routes
+
+import (
+	"testing"
+	"time"
+
+	"github.com/danielstutzman/fake-bigquery/data"
+)
+
+func TestCommitAfterFlush(t *testing.T) {
+	app := testApp(Options{StreamingBufferFlush: 50 * time.Millisecond})
+	table := app.projects["p"].Datasets["d"].Tables["t"]
+	table.TimePartitioning = &data.TimePartitioning{Type: "DAY"} // so that flushing rewrites rows
+	app.projects["p"].Datasets["d"].Tables["t"] = table
+	serve(app, "POST", "/bigquery/v2/projects/p/datasets/d/tables/t/insertAll",
+		`{"rows": [{"json": {"a": 4, "b": "four"}}]}`)
+
+	body := decode(t, serve(app, "POST", "/bigquery/v2/projects/p/jobs",
+		`{"configuration": {"query": {"query": "BEGIN TRANSACTION", "useLegacySql": false, "createSession": true}}}`))
+	if reason := errorReason(t, body); reason != "" {
+		t.Fatalf("BEGIN TRANSACTION failed with %s", reason)
+	}
+	sessionInfo := body["statistics"].(map[string]interface{})["sessionInfo"].(map[string]interface{})
+
+	// The buffer is flushed as the next job starts
+	time.Sleep(60 * time.Millisecond)
+	body = decode(t, serve(app, "POST", "/bigquery/v2/projects/p/jobs",
+		`{"configuration": {"query": {"query": "INSERT INTO d.t (a) VALUES (5); COMMIT TRANSACTION",
+			"useLegacySql": false, "connectionProperties": [{"key": "session_id", "value": "`+
+			sessionInfo["sessionId"].(string)+`"}]}}}`))
+	if reason := errorReason(t, body); reason != "" {
+		t.Errorf("COMMIT failed with %s: %v", reason, body["status"])
+	}
+	if rows := app.projects["p"].Datasets["d"].Tables["t"].Rows; len(rows) != 5 {
+		t.Errorf("table holds %d rows, want 5", len(rows))
+	}
+}
@@ -0,0 +1,161 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"strconv"
+
+	"github.com/danielstutzman/fake-bigquery/data"
+)
+
+// updateDataset serves both datasets.patch (replace=false), which only
+// changes the properties present in the body, and datasets.update
+// (replace=true), which resets every property missing from the body.
+func (app *App) updateDataset(w http.ResponseWriter, r *http.Request, projectName, datasetName string, replace bool) {
+	decoder := json.NewDecoder(r.Body)
+	var body map[string]json.RawMessage
+	err := decoder.Decode(&body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid", fmt.Sprintf("Invalid JSON payload: %s", err))
+		return
+	}
+	defer r.Body.Close()
+
+	project, projectOk := app.projects[projectName]
+	if !projectOk {
+		project = data.Project{
+			Datasets: map[string]data.Dataset{},
+		}
+		app.projects[projectName] = project
+	}
+
+	dataset, datasetOk := project.Datasets[datasetName]
+	if !datasetOk {
+		writeError(w, http.StatusNotFound, "notFound",
+			fmt.Sprintf("Not found: Dataset %s:%s", projectName, datasetName))
+		return
+	}
+
+	if raw, present := body["location"]; present {
+		var location string
+		if err := json.Unmarshal(raw, &location); err == nil && location != "" &&
+			location != dataset.Location {
+			writeError(w, http.StatusBadRequest, "invalid",
+				fmt.Sprintf("Cannot change location of dataset %s:%s from %s to %s",
+					projectName, datasetName, dataset.Location, location))
+			return
+		}
+	}
+
+	if err := decodeProperty(body, "description", &dataset.Description, replace); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid", err.Error())
+		return
+	}
+	if err := decodeProperty(body, "friendlyName", &dataset.FriendlyName, replace); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid", err.Error())
+		return
+	}
+	if dataset.Labels, err = updateLabels(dataset.Labels, body, replace); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid", err.Error())
+		return
+	}
+
+	if err := decodeInt64Property(body, "defaultTableExpirationMs", &dataset.DefaultTableExpirationMs, replace); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid", err.Error())
+		return
+	}
+	if err := decodeInt64Property(body, "defaultPartitionExpirationMs", &dataset.DefaultPartitionExpirationMs, replace); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid", err.Error())
+		return
+	}
+	if err := decodeProperty(body, "access", &dataset.Access, replace); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid", err.Error())
+		return
+	}
+	if len(dataset.Access) == 0 {
+		dataset.Access = data.DEFAULT_ACCESS
+	}
+
+	dataset.LastModifiedTime = data.NowMillis()
+	project.Datasets[datasetName] = dataset
+
+	outputJson, err := json.Marshal(datasetResource(projectName, datasetName, dataset))
+	if err != nil {
+		log.Fatalf("Error from Marshal: %v", err)
+	}
+	w.Write(outputJson)
+}
+
+// decodeProperty copies body[key] into target. A missing key leaves target
+// alone unless replace is set, and an explicit null always clears it.
+func decodeProperty(body map[string]json.RawMessage, key string, target interface{}, replace bool) error {
+	raw, present := body[key]
+	if !present && !replace {
+		return nil
+	}
+
+	// Zero the target first, so that decoding into a slice or map can't
+	// reuse (and overwrite) whatever it shared storage with before
+	targetValue := reflect.ValueOf(target).Elem()
+	targetValue.Set(reflect.Zero(targetValue.Type()))
+	if !present || string(raw) == "null" {
+		return nil
+	}
+
+	if err := json.Unmarshal(raw, target); err != nil {
+		return fmt.Errorf("Invalid value for %s: %s", key, err)
+	}
+	return nil
+}
+
+// decodeInt64Property is like decodeProperty for int64 properties, which
+// BigQuery sends as JSON strings.
+func decodeInt64Property(body map[string]json.RawMessage, key string, target *int64, replace bool) error {
+	var number json.Number
+	if err := decodeProperty(body, key, &number, replace); err != nil {
+		return err
+	}
+	if _, present := body[key]; !present && !replace {
+		return nil
+	}
+
+	*target = 0
+	if number != "" {
+		value, err := strconv.ParseInt(string(number), 10, 64)
+		if err != nil {
+			return fmt.Errorf("Invalid value for %s: %s", key, number)
+		}
+		*target = value
+	}
+	return nil
+}
+
+// updateLabels merges body["labels"] into labels; a label set to null is
+// removed. Under replace semantics the body's labels replace all existing ones.
+func updateLabels(labels map[string]string, body map[string]json.RawMessage, replace bool) (map[string]string, error) {
+	var patch map[string]*string
+	if err := decodeProperty(body, "labels", &patch, replace); err != nil {
+		return nil, err
+	}
+	if _, present := body["labels"]; !present && !replace {
+		return labels, nil
+	}
+
+	newLabels := map[string]string{}
+	if !replace {
+		for key, value := range labels {
+			newLabels[key] = value
+		}
+	}
+	for key, value := range patch {
+		if value == nil {
+			delete(newLabels, key)
+		} else {
+			newLabels[key] = *value
+		}
+	}
+	return newLabels, nil
+}
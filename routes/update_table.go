@@ -0,0 +1,212 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/danielstutzman/fake-bigquery/data"
+)
+
+// updateTable serves both tables.patch (replace=false) and tables.update
+// (replace=true); see updateDataset for the difference.
+func (app *App) updateTable(w http.ResponseWriter, r *http.Request, projectName, datasetName, tableName string, replace bool) {
+	decoder := json.NewDecoder(r.Body)
+	var body map[string]json.RawMessage
+	err := decoder.Decode(&body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid", fmt.Sprintf("Invalid JSON payload: %s", err))
+		return
+	}
+	defer r.Body.Close()
+
+	project, projectOk := app.projects[projectName]
+	if !projectOk {
+		project = data.Project{
+			Datasets: map[string]data.Dataset{},
+		}
+		app.projects[projectName] = project
+	}
+
+	dataset, datasetOk := project.Datasets[datasetName]
+	if !datasetOk {
+		writeError(w, http.StatusNotFound, "notFound",
+			fmt.Sprintf("Not found: Dataset %s:%s", projectName, datasetName))
+		return
+	}
+
+	table, tableOk := dataset.Tables[tableName]
+	if !tableOk {
+		writeError(w, http.StatusNotFound, "notFound",
+			fmt.Sprintf("Not found: Table %s:%s.%s", projectName, datasetName, tableName))
+		return
+	}
+
+	if err := decodeProperty(body, "description", &table.Description, replace); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid", err.Error())
+		return
+	}
+	if table.Labels, err = updateLabels(table.Labels, body, replace); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid", err.Error())
+		return
+	}
+
+	if err := decodeInt64Property(body, "expirationTime", &table.ExpirationTime, replace); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid", err.Error())
+		return
+	}
+
+	if err := decodeProperty(body, "requirePartitionFilter", &table.RequirePartitionFilter, replace); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid", err.Error())
+		return
+	}
+
+	// Only a partitioned table's expirationMs and requirePartitionFilter
+	// can change; its partitioning itself is fixed when it's created.
+	if _, present := body["timePartitioning"]; present && table.TimePartitioning != nil {
+		var partitioning data.TimePartitioning
+		if err := decodeProperty(body, "timePartitioning", &partitioning, replace); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid", err.Error())
+			return
+		}
+		if partitioning.PartitionType() != table.TimePartitioning.PartitionType() ||
+			!strings.EqualFold(partitioning.Field, table.TimePartitioning.Field) {
+			writeError(w, http.StatusBadRequest, "invalid",
+				"Cannot change partitioning spec for a partitioned table.")
+			return
+		}
+		updated := data.Table{Fields: table.Fields, TimePartitioning: &partitioning}
+		if err := updated.CheckPartitioning(); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid", err.Error())
+			return
+		}
+		table.TimePartitioning = &partitioning
+	}
+
+	clustering := table.Clustering
+	if err := decodeProperty(body, "clustering", &clustering, replace); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid", err.Error())
+		return
+	}
+	if clustering != nil {
+		if err := clustering.Check(table.Fields); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid", err.Error())
+			return
+		}
+	}
+	table.Clustering = clustering
+
+	// Under update semantics a missing schema means "keep the current one",
+	// since BigQuery never lets a schema lose its columns.
+	if _, present := body["schema"]; present {
+		var schema Schema
+		if err := decodeProperty(body, "schema", &schema, replace); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid", err.Error())
+			return
+		}
+		err := validateSchemaUpdate(table.Fields, schema.Fields, "")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid",
+				fmt.Sprintf("Provided Schema does not match Table %s:%s.%s. %s",
+					projectName, datasetName, tableName, err))
+			return
+		}
+		table.Fields = schema.Fields
+	}
+
+	if _, present := body["view"]; present && table.View != nil {
+		var definition ViewDefinition
+		if err := decodeProperty(body, "view", &definition, replace); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid", err.Error())
+			return
+		}
+		view, fields, viewErr := app.newView(definition,
+			data.TableRef{ProjectId: projectName, DatasetId: datasetName, TableId: tableName})
+		if viewErr != nil {
+			writeError(w, http.StatusBadRequest, viewErr.Reason, viewErr.Message)
+			return
+		}
+		table.View = view
+		table.Fields = fields
+	}
+
+	if _, present := body["materializedView"]; present && table.MaterializedView != nil {
+		var definition MaterializedViewDefinition
+		if err := decodeProperty(body, "materializedView", &definition, replace); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid", err.Error())
+			return
+		}
+		if definition.Query != "" && definition.Query != table.MaterializedView.Query {
+			writeError(w, http.StatusBadRequest, "invalid",
+				"The query of a materialized view cannot be changed.")
+			return
+		}
+		materializedView := *table.MaterializedView
+		if err := definition.apply(&materializedView); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid", err.Error())
+			return
+		}
+		table.MaterializedView = &materializedView
+	}
+
+	table.LastModifiedTime = data.NowMillis()
+	table.Version += 1
+	dataset.Tables[tableName] = table
+
+	outputJson, err := json.Marshal(tableResource(projectName, datasetName, tableName, table))
+	if err != nil {
+		log.Fatalf("Error from Marshal: %v", err)
+	}
+	w.Write(outputJson)
+}
+
+// validateSchemaUpdate allows only the changes BigQuery allows on an
+// existing table: adding NULLABLE or REPEATED columns and relaxing REQUIRED
+// columns to NULLABLE. Columns may not be dropped, retyped or narrowed.
+func validateSchemaUpdate(oldFields, newFields []data.Field, prefix string) error {
+	newFieldsByName := map[string]data.Field{}
+	for _, newField := range newFields {
+		newFieldsByName[strings.ToLower(newField.Name)] = newField
+	}
+
+	oldFieldsByName := map[string]data.Field{}
+	for _, oldField := range oldFields {
+		oldFieldsByName[strings.ToLower(oldField.Name)] = oldField
+
+		path := prefix + oldField.Name
+		newField, ok := newFieldsByName[strings.ToLower(oldField.Name)]
+		if !ok {
+			return fmt.Errorf("Field %s is missing in new schema", path)
+		}
+
+		oldType := data.NormalizeType(oldField.Type)
+		newType := data.NormalizeType(newField.Type)
+		if oldType != newType {
+			return fmt.Errorf("Field %s has changed type from %s to %s", path, oldType, newType)
+		}
+
+		oldMode := data.NormalizeMode(oldField.Mode)
+		newMode := data.NormalizeMode(newField.Mode)
+		if oldMode != newMode && !(oldMode == "REQUIRED" && newMode == "NULLABLE") {
+			return fmt.Errorf("Field %s has changed mode from %s to %s", path, oldMode, newMode)
+		}
+
+		if oldType == "RECORD" {
+			err := validateSchemaUpdate(oldField.Fields, newField.Fields, path+".")
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, newField := range newFields {
+		_, existed := oldFieldsByName[strings.ToLower(newField.Name)]
+		if !existed && data.NormalizeMode(newField.Mode) == "REQUIRED" {
+			return fmt.Errorf("Cannot add required fields to an existing schema. (field: %s%s)",
+				prefix, newField.Name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package routes
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/danielstutzman/fake-bigquery/data"
+)
+
+func TestValidateSchemaUpdate(t *testing.T) {
+	old := []data.Field{
+		{Name: "a", Type: "INTEGER", Mode: "REQUIRED"},
+		{Name: "r", Type: "RECORD", Mode: "NULLABLE", Fields: []data.Field{{Name: "x", Type: "STRING"}}},
+	}
+	for _, test := range []struct {
+		fields []data.Field
+		want   string // the error, or "" if the update is allowed
+	}{
+		{[]data.Field{old[0], old[1], {Name: "c", Type: "STRING", Mode: "NULLABLE"}}, ""},
+		{[]data.Field{{Name: "A", Type: "INT64", Mode: "NULLABLE"}, old[1]}, ""},
+		{[]data.Field{old[0], {Name: "r", Type: "RECORD", Fields: []data.Field{{Name: "x", Type: "STRING"},
+			{Name: "y", Type: "DATE", Mode: "REPEATED"}}}}, ""},
+		{[]data.Field{old[1]}, "Field a is missing in new schema"},
+		{[]data.Field{{Name: "a", Type: "STRING", Mode: "REQUIRED"}, old[1]},
+			"Field a has changed type from INTEGER to STRING"},
+		{[]data.Field{{Name: "a", Type: "INTEGER", Mode: "REPEATED"}, old[1]},
+			"Field a has changed mode from REQUIRED to REPEATED"},
+		{[]data.Field{old[0], {Name: "r", Type: "RECORD", Mode: "REQUIRED", Fields: old[1].Fields}},
+			"Field r has changed mode from NULLABLE to REQUIRED"},
+		{[]data.Field{old[0], {Name: "r", Type: "RECORD", Fields: []data.Field{}}},
+			"Field r.x is missing in new schema"},
+		{[]data.Field{old[0], old[1], {Name: "c", Type: "STRING", Mode: "REQUIRED"}},
+			"Cannot add required fields to an existing schema. (field: c)"},
+	} {
+		got := ""
+		if err := validateSchemaUpdate(old, test.fields, ""); err != nil {
+			got = err.Error()
+		}
+		if got != test.want {
+			t.Errorf("%+v: got %q, want %q", test.fields, got, test.want)
+		}
+	}
+}
+
+func TestUpdateTable(t *testing.T) {
+	const path = "/bigquery/v2/projects/p/datasets/d/tables/t"
+	for _, test := range []struct {
+		method string
+		body   string
+		labels map[string]string
+	}{
+		{"PATCH", `{"labels": {"b": "3", "c": "4"}}`, map[string]string{"a": "1", "b": "3", "c": "4"}},
+		{"PATCH", `{"labels": {"a": null}}`, map[string]string{"b": "2"}},
+		{"PATCH", `{"description": "x"}`, map[string]string{"a": "1", "b": "2"}},
+		{"PUT", `{"labels": {"c": "4"}}`, map[string]string{"c": "4"}},
+		{"PUT", `{"description": "x"}`, map[string]string{}},
+	} {
+		app := testApp(Options{})
+		table := app.projects["p"].Datasets["d"].Tables["t"]
+		table.Labels = map[string]string{"a": "1", "b": "2"}
+		app.projects["p"].Datasets["d"].Tables["t"] = table
+
+		if w := serve(app, test.method, path, test.body); w.Code != http.StatusOK {
+			t.Errorf("%s %s gave %d %s", test.method, test.body, w.Code, w.Body.String())
+		} else if got := app.projects["p"].Datasets["d"].Tables["t"].Labels; !reflect.DeepEqual(got, test.labels) {
+			t.Errorf("%s %s left labels %v, want %v", test.method, test.body, got, test.labels)
+		}
+	}
+
+	app := testApp(Options{})
+	if w := serve(app, "DELETE", path, ""); w.Code != http.StatusNoContent {
+		t.Errorf("DELETE gave %d %s, want 204", w.Code, w.Body.String())
+	}
+	if w := serve(app, "GET", path, ""); w.Code != http.StatusNotFound {
+		t.Errorf("GET after DELETE gave %d, want 404", w.Code)
+	}
+	if w := serve(app, "DELETE", path, ""); w.Code != http.StatusNotFound {
+		t.Errorf("second DELETE gave %d, want 404", w.Code)
+	}
+}
@@ -0,0 +1,111 @@
+package routes
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"regexp"
+	"strconv"
+)
+
+var CONTENT_RANGE_REGEXP = regexp.MustCompile(`^bytes (\*|([0-9]+)-([0-9]+))/(\*|[0-9]+)$`)
+
+type resumableUpload struct {
+	projectName string
+	bodyJson    []byte
+	media       bytes.Buffer
+}
+
+// uploadJob serves jobs.insert with media, either as a single
+// multipart/related request or as a resumable upload: a POST with the job
+// metadata that returns an upload URL, then PUTs of the data to that URL.
+func (app *App) uploadJob(w http.ResponseWriter, r *http.Request, projectName string) {
+	defer r.Body.Close()
+	params := r.URL.Query()
+
+	switch params.Get("uploadType") {
+	case "multipart":
+		mediaType, mediaParams, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || mediaType != "multipart/related" {
+			writeError(w, http.StatusBadRequest, "invalid",
+				"Multipart uploads need a Content-Type of multipart/related")
+			return
+		}
+		reader := multipart.NewReader(r.Body, mediaParams["boundary"])
+		parts := [][]byte{}
+		for {
+			part, err := reader.NextPart()
+			if err != nil {
+				break
+			}
+			partBytes, err := ioutil.ReadAll(part)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "invalid", err.Error())
+				return
+			}
+			parts = append(parts, partBytes)
+		}
+		if len(parts) != 2 {
+			writeError(w, http.StatusBadRequest, "invalid",
+				fmt.Sprintf("Expected 2 parts in multipart upload, got %d", len(parts)))
+			return
+		}
+		app.insertJob(w, projectName, parts[0], parts[1])
+
+	case "resumable":
+		uploadId := params.Get("upload_id")
+		if uploadId == "" {
+			bodyJson, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "invalid", fmt.Sprintf("Error reading request body: %s", err))
+				return
+			}
+			uploadId = newRandomId()
+			app.resumableUploads[uploadId] = &resumableUpload{
+				projectName: projectName,
+				bodyJson:    bodyJson,
+			}
+			w.Header().Set("Location", fmt.Sprintf(
+				"http://%s/upload/bigquery/v2/projects/%s/jobs?uploadType=resumable&upload_id=%s",
+				r.Host, projectName, uploadId))
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		upload, uploadOk := app.resumableUploads[uploadId]
+		if !uploadOk {
+			writeError(w, http.StatusNotFound, "notFound",
+				fmt.Sprintf("Not found: Upload %s", uploadId))
+			return
+		}
+		chunk, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid", fmt.Sprintf("Error reading request body: %s", err))
+			return
+		}
+		upload.media.Write(chunk)
+
+		complete := true
+		if match := CONTENT_RANGE_REGEXP.FindStringSubmatch(r.Header.Get("Content-Range")); match != nil {
+			total, err := strconv.Atoi(match[4])
+			complete = err == nil && total == upload.media.Len()
+		}
+		if !complete {
+			if upload.media.Len() > 0 {
+				w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", upload.media.Len()-1))
+			}
+			w.WriteHeader(308) // Resume Incomplete
+			return
+		}
+
+		delete(app.resumableUploads, uploadId)
+		app.insertJob(w, upload.projectName, upload.bodyJson, upload.media.Bytes())
+
+	default:
+		writeError(w, http.StatusBadRequest, "invalid",
+			fmt.Sprintf("Unsupported uploadType: %s", params.Get("uploadType")))
+	}
+}